@@ -1,51 +1,58 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
 	"time"
 
+	"minecharts/cmd/apierror"
 	"minecharts/cmd/auth"
 	"minecharts/cmd/config"
 	"minecharts/cmd/database"
 	"minecharts/cmd/logging"
+	"minecharts/cmd/security"
 
 	"github.com/gin-gonic/gin"
 )
 
-// CreateAPIKeyRequest represents a request to create a new API key.
+// CreateAPIKeyRequest represents a request to create a new API key. Keys get every
+// permission of their user unless restricted to some of them, and can be restricted
+// to some servers.
 type CreateAPIKeyRequest struct {
 	Description string    `json:"description" example:"Key for CI/CD pipeline"`
 	ExpiresAt   time.Time `json:"expires_at" example:"2023-12-31T23:59:59Z"`
+	Permissions []string  `json:"permissions,omitempty" example:"PermRestartServer"`
+	ServerIDs   []int64   `json:"server_ids,omitempty" example:"42"`
 }
 
 // CreateAPIKeyHandler creates a new API key for the authenticated user.
 //
 // @Summary      Create API key
-// @Description  Creates a new API key for the authenticated user
+// @Description  Creates a new API key for the authenticated user, optionally restricted to some of their permissions and to some servers
 // @Tags         api-keys
 // @Accept       json
 // @Produce      json
 // @Security     BearerAuth
 // @Param        request  body      CreateAPIKeyRequest  true  "API key information"
 // @Success      201      {object}  map[string]interface{}  "Created API key (includes full key)"
-// @Failure      400      {object}  map[string]string       "Invalid request"
-// @Failure      401      {object}  map[string]string       "Authentication required"
-// @Failure      500      {object}  map[string]string       "Server error"
+// @Failure      400      {object}  apierror.Error          "Invalid request"
+// @Failure      401      {object}  apierror.Error          "Authentication required"
+// @Failure      500      {object}  apierror.Error          "Server error"
 // @Router       /apikeys [post]
 func CreateAPIKeyHandler(c *gin.Context) {
 	user, ok := auth.GetCurrentUser(c)
 	if !ok {
-		logging.API.InvalidRequest.WithFields(
+		logging.API.InvalidRequest.WithContext(c.Request.Context()).WithFields(
 			"path", c.Request.URL.Path,
 			"remote_ip", c.ClientIP(),
 			"error", "not_authenticated",
 		).Warn("API key creation failed: user not authenticated")
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		apierror.Write(c, http.StatusUnauthorized, "Authentication required")
 		return
 	}
 
-	logging.API.Keys.WithFields(
+	logging.API.Keys.WithContext(c.Request.Context()).WithFields(
 		"user_id", user.ID,
 		"username", user.Username,
 		"remote_ip", c.ClientIP(),
@@ -53,53 +60,80 @@ func CreateAPIKeyHandler(c *gin.Context) {
 
 	var req CreateAPIKeyRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		logging.API.InvalidRequest.WithFields(
+		logging.API.InvalidRequest.WithContext(c.Request.Context()).WithFields(
 			"user_id", user.ID,
 			"username", user.Username,
 			"remote_ip", c.ClientIP(),
 			"error", err.Error(),
 		).Warn("API key creation failed: invalid request format")
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		apierror.Write(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Keys created without an expiry time never expire
+	var expiresAt *time.Time
+	if !req.ExpiresAt.IsZero() {
+		if !req.ExpiresAt.After(auth.Now()) {
+			apierror.Write(c, http.StatusBadRequest, "expires_at must be in the future")
+			return
+		}
+		expiresAt = &req.ExpiresAt
+	}
+
+	var permissions int64
+	for _, name := range req.Permissions {
+		permission, ok := database.PermissionNames[name]
+		if !ok {
+			apierror.Write(c, http.StatusBadRequest, "Unknown permission "+strconv.Quote(name)+", see GET /permissions")
+			return
+		}
+		permissions |= permission
+	}
+	if !checkAPIKeyServerIDs(c, user, req.ServerIDs) {
 		return
 	}
 
 	// Generate a new API key
 	keyValue, err := auth.GenerateAPIKey(config.APIKeyPrefix)
 	if err != nil {
-		logging.API.Keys.WithFields(
+		logging.API.Keys.WithContext(c.Request.Context()).WithFields(
 			"user_id", user.ID,
 			"username", user.Username,
 			"error", err.Error(),
 		).Error("Failed to generate API key")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate API key"})
+		apierror.Write(c, http.StatusInternalServerError, "Failed to generate API key")
 		return
 	}
 
-	logging.API.Keys.Debug("API key generated successfully")
+	logging.API.Keys.WithContext(c.Request.Context()).Debug("API key generated successfully")
 
 	// Create API key record
 	apiKey := &database.APIKey{
 		UserID:      user.ID,
 		Key:         keyValue,
 		Description: req.Description,
-		ExpiresAt:   &req.ExpiresAt,
+		Permissions: permissions,
+		ServerIDs:   req.ServerIDs,
+		ExpiresAt:   expiresAt,
 	}
 
 	db := database.GetDB()
 	if err := db.CreateAPIKey(c.Request.Context(), apiKey); err != nil {
-		logging.DB.WithFields(
+		logging.DB.WithContext(c.Request.Context()).WithFields(
 			"user_id", user.ID,
 			"username", user.Username,
 			"error", err.Error(),
 		).Error("Failed to save API key to database")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create API key"})
+		apierror.Write(c, http.StatusInternalServerError, "Failed to create API key")
 		return
 	}
 
-	logging.API.Keys.WithFields(
+	logging.API.Keys.WithContext(c.Request.Context()).WithFields(
 		"user_id", user.ID,
 		"username", user.Username,
 		"api_key_id", apiKey.ID,
+		"permissions", apiKey.Permissions,
+		"server_ids", apiKey.ServerIDs,
 		"expires_at", apiKey.ExpiresAt,
 	).Info("API key created successfully")
 
@@ -107,6 +141,8 @@ func CreateAPIKeyHandler(c *gin.Context) {
 		"id":          apiKey.ID,
 		"key":         apiKey.Key, // This is the only time the full key will be shown
 		"description": apiKey.Description,
+		"permissions": apiKey.Permissions,
+		"server_ids":  apiKey.ServerIDs,
 		"expires_at":  apiKey.ExpiresAt,
 		"created_at":  apiKey.CreatedAt,
 	})
@@ -115,66 +151,68 @@ func CreateAPIKeyHandler(c *gin.Context) {
 // ListAPIKeysHandler returns all API keys for the authenticated user.
 //
 // @Summary      List API keys
-// @Description  Returns all API keys owned by the authenticated user
+// @Description  Returns the API keys owned by the authenticated user, a page at a time with limit or offset, the X-Total-Count header giving the number of keys matching
 // @Tags         api-keys
 // @Produce      json
 // @Security     BearerAuth
-// @Success      200  {array}   map[string]interface{}  "List of API keys (with masked key values)"
-// @Failure      401  {object}  map[string]string       "Authentication required"
-// @Failure      500  {object}  map[string]string       "Server error"
+// @Param        search  query     string  false  "Part of the description"
+// @Param        sort    query     string  false  "id, description, created_at, last_used or expires_at, prefixed with - for a descending sort"
+// @Param        limit   query     int     false  "Maximum number of keys (max 500, every key when limit and offset are omitted)"
+// @Param        offset  query     int     false  "Number of keys skipped (the limit defaults to 50)"
+// @Success      200     {array}   map[string]interface{}  "List of API keys (with masked key values)"
+// @Header       200     {integer} X-Total-Count  "Number of keys matching the filters"
+// @Failure      400     {object}  apierror.Error          "Invalid filter or page"
+// @Failure      401     {object}  apierror.Error          "Authentication required"
+// @Failure      500     {object}  apierror.Error          "Server error"
 // @Router       /apikeys [get]
 func ListAPIKeysHandler(c *gin.Context) {
 	user, ok := auth.GetCurrentUser(c)
 	if !ok {
-		logging.API.InvalidRequest.WithFields(
+		logging.API.InvalidRequest.WithContext(c.Request.Context()).WithFields(
 			"path", c.Request.URL.Path,
 			"remote_ip", c.ClientIP(),
 			"error", "not_authenticated",
 		).Warn("API key listing failed: user not authenticated")
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		apierror.Write(c, http.StatusUnauthorized, "Authentication required")
 		return
 	}
 
-	logging.API.Keys.WithFields(
+	logging.API.Keys.WithContext(c.Request.Context()).WithFields(
 		"user_id", user.ID,
 		"username", user.Username,
 		"remote_ip", c.ClientIP(),
 	).Info("API key listing requested")
 
+	page, ok := parsePage(c, database.APIKeySortFields)
+	if !ok {
+		return
+	}
+
 	db := database.GetDB()
-	apiKeys, err := db.ListAPIKeysByUser(c.Request.Context(), user.ID)
+	apiKeys, total, err := db.SearchAPIKeys(c.Request.Context(), database.APIKeyFilter{Page: page, UserID: user.ID, Search: c.Query("search")})
 	if err != nil {
-		logging.DB.WithFields(
+		logging.DB.WithContext(c.Request.Context()).WithFields(
 			"user_id", user.ID,
 			"username", user.Username,
 			"error", err.Error(),
 		).Error("Failed to list API keys from database")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list API keys"})
+		apierror.Write(c, http.StatusInternalServerError, "Failed to list API keys")
 		return
 	}
 
 	// For security, only return partial key values
 	response := make([]gin.H, len(apiKeys))
 	for i, key := range apiKeys {
-		// Create a masked version of the key (e.g., "mcapi.XXXX")
-		maskedKey := key.Key[:8] + "..." // Only show first part of the key
-
-		response[i] = gin.H{
-			"id":          key.ID,
-			"key":         maskedKey,
-			"description": key.Description,
-			"last_used":   key.LastUsed,
-			"expires_at":  key.ExpiresAt,
-			"created_at":  key.CreatedAt,
-		}
+		response[i] = maskedAPIKey(key)
 	}
 
-	logging.API.Keys.WithFields(
+	logging.API.Keys.WithContext(c.Request.Context()).WithFields(
 		"user_id", user.ID,
 		"username", user.Username,
 		"key_count", len(apiKeys),
 	).Debug("API keys listed successfully")
 
+	c.Header(totalCountHeader, strconv.Itoa(total))
 	c.JSON(http.StatusOK, response)
 }
 
@@ -187,20 +225,20 @@ func ListAPIKeysHandler(c *gin.Context) {
 // @Security     BearerAuth
 // @Param        id   path      integer  true  "API Key ID"
 // @Success      200  {object}  map[string]string  "API key deleted successfully"
-// @Failure      400  {object}  map[string]string  "Invalid API key ID"
-// @Failure      401  {object}  map[string]string  "Authentication required"
-// @Failure      403  {object}  map[string]string  "Permission denied"
-// @Failure      500  {object}  map[string]string  "Server error"
+// @Failure      400  {object}  apierror.Error     "Invalid API key ID"
+// @Failure      401  {object}  apierror.Error     "Authentication required"
+// @Failure      403  {object}  apierror.Error     "Permission denied"
+// @Failure      500  {object}  apierror.Error     "Server error"
 // @Router       /apikeys/{id} [delete]
 func DeleteAPIKeyHandler(c *gin.Context) {
 	user, ok := auth.GetCurrentUser(c)
 	if !ok {
-		logging.API.InvalidRequest.WithFields(
+		logging.API.InvalidRequest.WithContext(c.Request.Context()).WithFields(
 			"path", c.Request.URL.Path,
 			"remote_ip", c.ClientIP(),
 			"error", "not_authenticated",
 		).Warn("API key deletion failed: user not authenticated")
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		apierror.Write(c, http.StatusUnauthorized, "Authentication required")
 		return
 	}
 
@@ -208,18 +246,18 @@ func DeleteAPIKeyHandler(c *gin.Context) {
 	idStr := c.Param("id")
 	id, err := strconv.ParseInt(idStr, 10, 64)
 	if err != nil {
-		logging.API.InvalidRequest.WithFields(
+		logging.API.InvalidRequest.WithContext(c.Request.Context()).WithFields(
 			"user_id", user.ID,
 			"username", user.Username,
 			"key_id_param", idStr,
 			"remote_ip", c.ClientIP(),
 			"error", "invalid_id_format",
 		).Warn("API key deletion failed: invalid ID format")
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid API key ID"})
+		apierror.Write(c, http.StatusBadRequest, "Invalid API key ID")
 		return
 	}
 
-	logging.API.Keys.WithFields(
+	logging.API.Keys.WithContext(c.Request.Context()).WithFields(
 		"user_id", user.ID,
 		"username", user.Username,
 		"api_key_id", id,
@@ -231,13 +269,13 @@ func DeleteAPIKeyHandler(c *gin.Context) {
 		db := database.GetDB()
 		keys, err := db.ListAPIKeysByUser(c.Request.Context(), user.ID)
 		if err != nil {
-			logging.DB.WithFields(
+			logging.DB.WithContext(c.Request.Context()).WithFields(
 				"user_id", user.ID,
 				"username", user.Username,
 				"api_key_id", id,
 				"error", err.Error(),
 			).Error("Failed to verify API key ownership")
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify API key ownership"})
+			apierror.Write(c, http.StatusInternalServerError, "Failed to verify API key ownership")
 			return
 		}
 
@@ -250,14 +288,14 @@ func DeleteAPIKeyHandler(c *gin.Context) {
 		}
 
 		if !found {
-			logging.API.Keys.WithFields(
+			logging.API.Keys.WithContext(c.Request.Context()).WithFields(
 				"user_id", user.ID,
 				"username", user.Username,
 				"api_key_id", id,
 				"remote_ip", c.ClientIP(),
 				"error", "permission_denied",
 			).Warn("API key deletion failed: user doesn't own this API key")
-			c.JSON(http.StatusForbidden, gin.H{"error": "You do not own this API key"})
+			apierror.Write(c, http.StatusForbidden, "You do not own this API key")
 			return
 		}
 	}
@@ -265,17 +303,17 @@ func DeleteAPIKeyHandler(c *gin.Context) {
 	// Delete the API key
 	db := database.GetDB()
 	if err := db.DeleteAPIKey(c.Request.Context(), id); err != nil {
-		logging.DB.WithFields(
+		logging.DB.WithContext(c.Request.Context()).WithFields(
 			"user_id", user.ID,
 			"username", user.Username,
 			"api_key_id", id,
 			"error", err.Error(),
 		).Error("Failed to delete API key from database")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete API key"})
+		apierror.Write(c, http.StatusInternalServerError, "Failed to delete API key")
 		return
 	}
 
-	logging.API.Keys.WithFields(
+	logging.API.Keys.WithContext(c.Request.Context()).WithFields(
 		"user_id", user.ID,
 		"username", user.Username,
 		"api_key_id", id,
@@ -284,3 +322,149 @@ func DeleteAPIKeyHandler(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{"message": "API key deleted"})
 }
+
+// checkAPIKeyServerIDs checks that the servers a key is restricted to exist and that
+// the user can view them. It writes the error response and returns false otherwise.
+func checkAPIKeyServerIDs(c *gin.Context, user *database.User, serverIDs []int64) bool {
+	if len(serverIDs) == 0 {
+		return true
+	}
+
+	ctx := c.Request.Context()
+	servers, err := database.GetDB().ListServers(ctx)
+	if err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to list servers")
+		return false
+	}
+	byID := make(map[int64]*database.MinecraftServer, len(servers))
+	for _, server := range servers {
+		byID[server.ID] = server
+	}
+	for _, id := range serverIDs {
+		server, ok := byID[id]
+		if !ok || !auth.HasServerAccess(ctx, user, server, database.PermViewServer) {
+			apierror.Write(c, http.StatusBadRequest, "Unknown server ID "+strconv.FormatInt(id, 10))
+			return false
+		}
+	}
+	return true
+}
+
+// maskedAPIKey describes an API key without its full value.
+func maskedAPIKey(key *database.APIKey) gin.H {
+	return gin.H{
+		"id":          key.ID,
+		"key":         key.Key[:8] + "...", // Only show first part of the key (e.g., "mcapi.XXXX")
+		"description": key.Description,
+		"permissions": key.Permissions,
+		"server_ids":  key.ServerIDs,
+		"last_used":   key.LastUsed,
+		"expires_at":  key.ExpiresAt,
+		"created_at":  key.CreatedAt,
+	}
+}
+
+// ListAllAPIKeysHandler returns the API keys of every user (admin only).
+//
+// @Summary      List all API keys
+// @Description  Returns the API keys of every user, with their user, last use and scope (admin only)
+// @Tags         api-keys
+// @Produce      json
+// @Security     BearerAuth
+// @Param        user_id  query     int     false  "User of the keys"
+// @Param        search   query     string  false  "Part of the description"
+// @Param        sort     query     string  false  "id, description, created_at, last_used or expires_at, prefixed with - for a descending sort"
+// @Param        limit    query     int     false  "Maximum number of keys (max 500, every key when limit and offset are omitted)"
+// @Param        offset   query     int     false  "Number of keys skipped (the limit defaults to 50)"
+// @Success      200      {array}   map[string]interface{}  "List of API keys (with masked key values)"
+// @Header       200      {integer} X-Total-Count  "Number of keys matching the filters"
+// @Failure      400      {object}  apierror.Error          "Invalid filter or page"
+// @Failure      401      {object}  apierror.Error          "Authentication required"
+// @Failure      403      {object}  apierror.Error          "Permission denied"
+// @Failure      500      {object}  apierror.Error          "Server error"
+// @Router       /admin/apikeys [get]
+func ListAllAPIKeysHandler(c *gin.Context) {
+	page, ok := parsePage(c, database.APIKeySortFields)
+	if !ok {
+		return
+	}
+	filter := database.APIKeyFilter{Page: page, Search: c.Query("search")}
+	if value := c.Query("user_id"); value != "" {
+		userID, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || userID < 1 {
+			apierror.Write(c, http.StatusBadRequest, "user_id must be a positive integer")
+			return
+		}
+		filter.UserID = userID
+	}
+
+	apiKeys, total, err := database.GetDB().SearchAPIKeys(c.Request.Context(), filter)
+	if err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to list API keys")
+		return
+	}
+
+	response := make([]gin.H, len(apiKeys))
+	for i, key := range apiKeys {
+		response[i] = maskedAPIKey(key)
+		response[i]["user_id"] = key.UserID
+		response[i]["username"] = key.Username
+	}
+	c.Header(totalCountHeader, strconv.Itoa(total))
+	c.JSON(http.StatusOK, response)
+}
+
+// RevokeAPIKeyHandler deletes the API key of any user (admin only).
+//
+// @Summary      Revoke API key
+// @Description  Deletes the API key of any user, for instance when it leaked (admin only)
+// @Tags         api-keys
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id   path      integer  true  "API Key ID"
+// @Success      200  {object}  map[string]string  "API key revoked"
+// @Failure      400  {object}  apierror.Error     "Invalid API key ID"
+// @Failure      401  {object}  apierror.Error     "Authentication required"
+// @Failure      403  {object}  apierror.Error     "Permission denied"
+// @Failure      404  {object}  apierror.Error     "API key not found"
+// @Failure      500  {object}  apierror.Error     "Server error"
+// @Router       /admin/apikeys/{id} [delete]
+func RevokeAPIKeyHandler(c *gin.Context) {
+	user, ok := auth.RequireCurrentUser(c)
+	if !ok {
+		return
+	}
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		apierror.Write(c, http.StatusBadRequest, "Invalid API key ID")
+		return
+	}
+
+	ctx := c.Request.Context()
+	db := database.GetDB()
+	key, err := db.GetAPIKeyByID(ctx, id)
+	if errors.Is(err, database.ErrAPIKeyNotFound) {
+		apierror.Write(c, http.StatusNotFound, "API key not found")
+		return
+	}
+	if err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to get API key")
+		return
+	}
+	if err := db.DeleteAPIKey(ctx, key.ID); err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to delete API key")
+		return
+	}
+
+	logging.API.Keys.WithContext(ctx).WithFields(
+		"user_id", user.ID,
+		"username", user.Username,
+		"api_key_id", key.ID,
+		"api_key_user_id", key.UserID,
+		"remote_ip", c.ClientIP(),
+	).Info("API key revoked by admin")
+	security.NewEvent(c, security.AdminAction, "revoke_api_key").WithUser(user).WithTarget(key.Username).
+		WithDetail("api_key_id", strconv.FormatInt(key.ID, 10)).Emit()
+
+	c.JSON(http.StatusOK, gin.H{"message": "API key revoked"})
+}
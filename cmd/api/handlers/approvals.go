@@ -0,0 +1,303 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"minecharts/cmd/apierror"
+	"minecharts/cmd/auth"
+	"minecharts/cmd/database"
+	"minecharts/cmd/logging"
+	"minecharts/cmd/security"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RejectServerRequestRequest represents the body of a server request rejection.
+type RejectServerRequestRequest struct {
+	Reason string `json:"reason" binding:"required" example:"Please use the shared survival server instead"`
+}
+
+// submitServerRequest stores a server creation request for admin review
+// and notifies administrators.
+func submitServerRequest(c *gin.Context, user *database.User, req StartMinecraftServerRequest) {
+	if user == nil {
+		apierror.Write(c, http.StatusUnauthorized, "Authentication required")
+		return
+	}
+
+	db := database.GetDB()
+	ctx := c.Request.Context()
+
+	pending, err := db.ListServerRequests(ctx, database.ServerRequestPending)
+	if err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to check pending requests")
+		return
+	}
+	for _, p := range pending {
+		if p.ServerName == req.ServerName {
+			logging.Server.WithContext(ctx).WithFields(
+				"server_name", req.ServerName,
+				"user_id", user.ID,
+				"server_request_id", p.ID,
+			).Warn("Server request rejected: a request for this name is already pending")
+			apierror.Write(c, http.StatusConflict, "A request for this server name is already pending")
+			return
+		}
+	}
+
+	payload, err := json.Marshal(req)
+	if err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to encode server request")
+		return
+	}
+
+	serverRequest := &database.ServerRequest{
+		ServerName:  req.ServerName,
+		RequesterID: user.ID,
+		Payload:     payload,
+		Status:      database.ServerRequestPending,
+	}
+	if err := db.CreateServerRequest(ctx, serverRequest); err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to create server request")
+		return
+	}
+
+	logging.Server.WithContext(ctx).WithFields(
+		"server_name", req.ServerName,
+		"user_id", user.ID,
+		"username", user.Username,
+		"server_request_id", serverRequest.ID,
+	).Info("Server creation submitted for approval")
+
+	notifyAdmins(ctx, fmt.Sprintf("%s requested a new server %q (request #%d)", user.Username, req.ServerName, serverRequest.ID))
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message":   "Server request submitted for approval",
+		"requestId": serverRequest.ID,
+		"status":    serverRequest.Status,
+	})
+}
+
+// ListServerRequestsHandler lists server creation requests.
+// Administrators see every request, other users only see their own.
+//
+// @Summary      List server requests
+// @Description  Lists server creation requests; administrators see all requests, other users see their own
+// @Tags         server-requests
+// @Produce      json
+// @Security     BearerAuth
+// @Security     APIKeyAuth
+// @Param        status  query     string                   false  "Filter by status (pending, approved, rejected), admins only"
+// @Success      200     {array}   database.ServerRequest   "List of server requests"
+// @Failure      401     {object}  apierror.Error           "Authentication required"
+// @Failure      500     {object}  apierror.Error           "Server error"
+// @Router       /server-requests [get]
+func ListServerRequestsHandler(c *gin.Context) {
+	user, ok := auth.GetCurrentUser(c)
+	if !ok {
+		apierror.Write(c, http.StatusUnauthorized, "Authentication required")
+		return
+	}
+
+	db := database.GetDB()
+	var (
+		requests []*database.ServerRequest
+		err      error
+	)
+	if user.IsAdmin() {
+		requests, err = db.ListServerRequests(c.Request.Context(), c.Query("status"))
+	} else {
+		requests, err = db.ListServerRequestsByUser(c.Request.Context(), user.ID)
+	}
+	if err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to list server requests")
+		return
+	}
+
+	for i, serverRequest := range requests {
+		requests[i] = redactedServerRequest(serverRequest)
+	}
+
+	c.JSON(http.StatusOK, requests)
+}
+
+// ApproveServerRequestHandler approves a pending server request and creates the server
+// on behalf of the requester.
+//
+// @Summary      Approve server request
+// @Description  Approves a pending server creation request and provisions the server for the requester
+// @Tags         server-requests
+// @Produce      json
+// @Security     BearerAuth
+// @Security     APIKeyAuth
+// @Param        id   path      int                     true  "Server request ID"
+// @Success      200  {object}  database.ServerRequest  "Approved request"
+// @Failure      400  {object}  apierror.Error          "Invalid request ID"
+// @Failure      401  {object}  apierror.Error          "Authentication required"
+// @Failure      403  {object}  apierror.Error          "Permission denied or requester quota exceeded"
+// @Failure      404  {object}  apierror.Error          "Server request not found"
+// @Failure      409  {object}  apierror.Error          "Request already reviewed"
+// @Failure      500  {object}  apierror.Error          "Server error"
+// @Router       /server-requests/{id}/approve [post]
+func ApproveServerRequestHandler(c *gin.Context) {
+	reviewer, serverRequest, ok := loadPendingServerRequest(c)
+	if !ok {
+		return
+	}
+
+	var req StartMinecraftServerRequest
+	if err := json.Unmarshal(serverRequest.Payload, &req); err != nil {
+		logging.Server.WithContext(c.Request.Context()).WithFields(
+			"server_request_id", serverRequest.ID,
+			"error", err.Error(),
+		).Error("Failed to decode stored server request")
+		apierror.Write(c, http.StatusInternalServerError, "Stored server request is invalid")
+		return
+	}
+
+	// The requester's quota may have changed since the request was submitted
+	if !enforceQuota(c, serverRequest.RequesterID, req.ServerSpec) {
+		return
+	}
+
+	ctx := c.Request.Context()
+	deploymentName, pvcName, err := provisionMinecraftServer(ctx, req, serverRequest.RequesterID)
+	if err != nil {
+		var exceeded *apierror.Error
+		if errors.As(err, &exceeded) {
+			apierror.Respond(c, exceeded)
+			return
+		}
+		apierror.Write(c, http.StatusInternalServerError, "Failed to create server: "+err.Error())
+		return
+	}
+
+	now := time.Now()
+	serverRequest.Status = database.ServerRequestApproved
+	serverRequest.ReviewerID = &reviewer.ID
+	serverRequest.ReviewedAt = &now
+	if err := database.GetDB().UpdateServerRequest(ctx, serverRequest); err != nil {
+		// The server exists at this point, so report success and keep the log
+		logging.DB.WithContext(ctx).WithFields(
+			"server_request_id", serverRequest.ID,
+			"error", err.Error(),
+		).Error("Failed to record server request approval")
+	}
+
+	logging.Server.WithContext(ctx).WithFields(
+		"server_request_id", serverRequest.ID,
+		"server_name", serverRequest.ServerName,
+		"deployment", deploymentName,
+		"pvc", pvcName,
+		"requester_id", serverRequest.RequesterID,
+		"reviewer_id", reviewer.ID,
+	).Info("Server request approved")
+	security.NewEvent(c, security.AdminAction, "approve_server_request").WithUser(reviewer).
+		WithTarget(serverRequest.ServerName).WithDetail("server_request_id", strconv.FormatInt(serverRequest.ID, 10)).Emit()
+
+	notifyUser(ctx, serverRequest.RequesterID,
+		fmt.Sprintf("Your request for server %q was approved by %s", serverRequest.ServerName, reviewer.Username))
+
+	c.JSON(http.StatusOK, redactedServerRequest(serverRequest))
+}
+
+// RejectServerRequestHandler rejects a pending server request with a reason.
+//
+// @Summary      Reject server request
+// @Description  Rejects a pending server creation request; the reason is sent to the requester
+// @Tags         server-requests
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Security     APIKeyAuth
+// @Param        id       path      int                         true  "Server request ID"
+// @Param        request  body      RejectServerRequestRequest  true  "Rejection reason"
+// @Success      200      {object}  database.ServerRequest      "Rejected request"
+// @Failure      400      {object}  apierror.Error              "Invalid request"
+// @Failure      401      {object}  apierror.Error              "Authentication required"
+// @Failure      403      {object}  apierror.Error              "Permission denied"
+// @Failure      404      {object}  apierror.Error              "Server request not found"
+// @Failure      409      {object}  apierror.Error              "Request already reviewed"
+// @Failure      500      {object}  apierror.Error              "Server error"
+// @Router       /server-requests/{id}/reject [post]
+func RejectServerRequestHandler(c *gin.Context) {
+	var body RejectServerRequestRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		logging.API.InvalidRequest.WithContext(c.Request.Context()).WithFields(
+			"error", err.Error(),
+			"remote_ip", c.ClientIP(),
+		).Warn("Invalid server request rejection format")
+		apierror.Write(c, http.StatusBadRequest, "A rejection reason is required")
+		return
+	}
+
+	reviewer, serverRequest, ok := loadPendingServerRequest(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	now := time.Now()
+	serverRequest.Status = database.ServerRequestRejected
+	serverRequest.Reason = body.Reason
+	serverRequest.ReviewerID = &reviewer.ID
+	serverRequest.ReviewedAt = &now
+	if err := database.GetDB().UpdateServerRequest(ctx, serverRequest); err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to update server request")
+		return
+	}
+
+	logging.Server.WithContext(ctx).WithFields(
+		"server_request_id", serverRequest.ID,
+		"server_name", serverRequest.ServerName,
+		"requester_id", serverRequest.RequesterID,
+		"reviewer_id", reviewer.ID,
+		"reason", body.Reason,
+	).Info("Server request rejected")
+	security.NewEvent(c, security.AdminAction, "reject_server_request").WithUser(reviewer).
+		WithTarget(serverRequest.ServerName).WithReason(body.Reason).
+		WithDetail("server_request_id", strconv.FormatInt(serverRequest.ID, 10)).Emit()
+
+	notifyUser(ctx, serverRequest.RequesterID,
+		fmt.Sprintf("Your request for server %q was rejected by %s: %s", serverRequest.ServerName, reviewer.Username, body.Reason))
+
+	c.JSON(http.StatusOK, redactedServerRequest(serverRequest))
+}
+
+// loadPendingServerRequest resolves the reviewer and the pending request referenced by the :id parameter.
+// It writes the error response and returns false when the request cannot be reviewed.
+func loadPendingServerRequest(c *gin.Context) (*database.User, *database.ServerRequest, bool) {
+	reviewer, ok := auth.GetCurrentUser(c)
+	if !ok {
+		apierror.Write(c, http.StatusUnauthorized, "Authentication required")
+		return nil, nil, false
+	}
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		apierror.Write(c, http.StatusBadRequest, "Invalid request ID")
+		return nil, nil, false
+	}
+
+	serverRequest, err := database.GetDB().GetServerRequest(c.Request.Context(), id)
+	if err != nil {
+		if errors.Is(err, database.ErrRequestNotFound) {
+			apierror.Write(c, http.StatusNotFound, "Server request not found")
+		} else {
+			apierror.Write(c, http.StatusInternalServerError, "Failed to get server request")
+		}
+		return nil, nil, false
+	}
+
+	if serverRequest.Status != database.ServerRequestPending {
+		apierror.Write(c, http.StatusConflict, "Server request has already been "+serverRequest.Status)
+		return nil, nil, false
+	}
+
+	return reviewer, serverRequest, true
+}
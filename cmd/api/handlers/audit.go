@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"minecharts/cmd/apierror"
+	"minecharts/cmd/database"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultAuditLimit = 50
+	maxAuditLimit     = 500
+)
+
+// ListAuditEventsHandler returns the audit log of the state-changing API calls,
+// newest first (admin only). Pages are followed with the next_before cursor of the
+// previous page.
+//
+// @Summary      List audit events
+// @Description  Returns the state-changing API calls with the user who made them, their target and result, newest first (admin only)
+// @Tags         audit
+// @Produce      json
+// @Security     BearerAuth
+// @Param        user_id         query     int                     false  "User who made the calls"
+// @Param        action          query     string                  false  "Method and route, such as POST /servers/:serverName/restart"
+// @Param        server          query     string                  false  "Target server"
+// @Param        target_user_id  query     int                     false  "Target user"
+// @Param        result          query     string                  false  "Result: success, denied or failed"
+// @Param        since           query     string                  false  "RFC 3339 time of the oldest events"
+// @Param        until           query     string                  false  "RFC 3339 time the events precede"
+// @Param        before          query     int                     false  "Cursor returned as next_before by the previous page"
+// @Param        limit           query     int                     false  "Maximum number of events (default 50, max 500)"
+// @Success      200             {object}  map[string]interface{}  "Audit events and the cursor of the next page"
+// @Failure      400             {object}  apierror.Error          "Invalid filter"
+// @Failure      401             {object}  apierror.Error          "Authentication required"
+// @Failure      403             {object}  apierror.Error          "Permission denied"
+// @Failure      500             {object}  apierror.Error          "Server error"
+// @Router       /audit [get]
+func ListAuditEventsHandler(c *gin.Context) {
+	filter := database.AuditFilter{
+		Action:       c.Query("action"),
+		TargetServer: c.Query("server"),
+		Result:       c.Query("result"),
+		Limit:        defaultAuditLimit,
+	}
+
+	for param, target := range map[string]*int64{
+		"user_id":        &filter.UserID,
+		"target_user_id": &filter.TargetUserID,
+		"before":         &filter.BeforeID,
+	} {
+		if value := c.Query(param); value != "" {
+			parsed, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || parsed < 1 {
+				apierror.Write(c, http.StatusBadRequest, param+" must be a positive integer")
+				return
+			}
+			*target = parsed
+		}
+	}
+	for param, target := range map[string]*time.Time{
+		"since": &filter.Since,
+		"until": &filter.Until,
+	} {
+		if value := c.Query(param); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				apierror.Write(c, http.StatusBadRequest, param+" must be an RFC 3339 timestamp")
+				return
+			}
+			*target = parsed
+		}
+	}
+	switch filter.Result {
+	case "", database.AuditResultSuccess, database.AuditResultDenied, database.AuditResultFailed:
+	default:
+		apierror.Write(c, http.StatusBadRequest, "result must be success, denied or failed")
+		return
+	}
+	if value := c.Query("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxAuditLimit {
+			apierror.Write(c, http.StatusBadRequest, "limit must be between 1 and "+strconv.Itoa(maxAuditLimit))
+			return
+		}
+		filter.Limit = parsed
+	}
+
+	events, err := database.GetDB().ListAuditEvents(c.Request.Context(), filter)
+	if err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to list audit events")
+		return
+	}
+
+	response := gin.H{"events": events}
+	if len(events) == filter.Limit {
+		response["next_before"] = events[len(events)-1].ID
+	}
+	c.JSON(http.StatusOK, response)
+}
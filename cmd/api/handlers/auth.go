@@ -4,14 +4,20 @@ package handlers
 import (
 	"context"
 	"crypto/rand"
+	"crypto/subtle"
 	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"net/http"
+	"strings"
 	"time"
 
+	"minecharts/cmd/apierror"
 	"minecharts/cmd/auth"
 	"minecharts/cmd/config"
 	"minecharts/cmd/database"
 	"minecharts/cmd/logging"
+	"minecharts/cmd/security"
 
 	"github.com/gin-gonic/gin"
 )
@@ -38,65 +44,68 @@ type RegisterRequest struct {
 // @Produce      json
 // @Param        request  body      LoginRequest  true  "Login credentials"
 // @Success      200      {object}  map[string]interface{}  "Authentication successful"
-// @Failure      400      {object}  map[string]string       "Invalid request format"
-// @Failure      401      {object}  map[string]string       "Authentication failed"
-// @Failure      403      {object}  map[string]string       "Account inactive"
-// @Failure      500      {object}  map[string]string       "Server error"
+// @Failure      400      {object}  apierror.Error          "Invalid request format"
+// @Failure      401      {object}  apierror.Error          "Authentication failed"
+// @Failure      403      {object}  apierror.Error          "Account inactive"
+// @Failure      500      {object}  apierror.Error          "Server error"
 // @Router       /auth/login [post]
 func LoginHandler(c *gin.Context) {
 	var req LoginRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		logging.API.InvalidRequest.WithFields("error", err.Error(), "remote_ip", c.ClientIP(), "reason", "invalid_request").
+		logging.API.InvalidRequest.WithContext(c.Request.Context()).WithFields("error", err.Error(), "remote_ip", c.ClientIP(), "reason", "invalid_request").
 			Warn("Invalid login request format")
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		apierror.Invalid(c, err)
 		return
 	}
 
-	logging.Auth.Login.WithFields("user", req.Username, "r_ip", c.ClientIP()).Info("User login attempt")
+	logging.Auth.Login.WithContext(c.Request.Context()).WithFields("username", req.Username, "remote_ip", c.ClientIP()).Info("User login attempt")
 
 	// Get user from database
 	db := database.GetDB()
-	logging.DB.Debug("Using database implementation %T", db)
+	logging.DB.WithContext(c.Request.Context()).Debug("Using database implementation %T", db)
 
 	user, err := db.GetUserByUsername(c.Request.Context(), req.Username)
 	if err != nil {
 		if err == database.ErrUserNotFound {
-			logging.Auth.Login.WithFields("user", req.Username, "r_ip", c.ClientIP(), "reason", "user_not_found").
+			logging.Auth.InvalidCredentials.WithContext(c.Request.Context()).WithFields("username", req.Username, "remote_ip", c.ClientIP(), "reason", "user_not_found").
 				Warn("Login failed: user not found")
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid username or password"})
+			security.NewEvent(c, security.AuthFailure, "login").WithUsername(req.Username).WithReason("user_not_found").Emit()
+			apierror.Write(c, http.StatusUnauthorized, "Invalid username or password")
 			return
 		}
-		logging.DB.WithFields("user", req.Username, "r_ip", c.ClientIP(), "error", err.Error()).Error("Database error during login")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user"})
+		logging.DB.WithContext(c.Request.Context()).WithFields("username", req.Username, "remote_ip", c.ClientIP(), "error", err.Error()).Error("Database error during login")
+		apierror.Write(c, http.StatusInternalServerError, "Failed to get user")
 		return
 	}
 
-	logging.DB.WithFields("username", req.Username, "user_id", user.ID).Debug("User found in database")
+	logging.DB.WithContext(c.Request.Context()).WithFields("username", req.Username, "user_id", user.ID).Debug("User found in database")
 
 	// Verify password
 	if err := auth.VerifyPassword(user.PasswordHash, req.Password); err != nil {
-		logging.Auth.Login.WithFields("username", req.Username, "remote_ip", c.ClientIP(), "reason", "invalid_password").
+		logging.Auth.InvalidCredentials.WithContext(c.Request.Context()).WithFields("username", req.Username, "remote_ip", c.ClientIP(), "reason", "invalid_password").
 			Warn("Login failed: invalid password")
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid username or password"})
+		security.NewEvent(c, security.AuthFailure, "login").WithUser(user).WithReason("invalid_password").Emit()
+		apierror.Write(c, http.StatusUnauthorized, "Invalid username or password")
 		return
 	}
 
-	logging.Auth.WithFields("username", req.Username, "user_id", user.ID).Debug("Password verified successfully")
+	logging.Auth.WithContext(c.Request.Context()).WithFields("username", req.Username, "user_id", user.ID).Debug("Password verified successfully")
 
 	// Check if user is active
 	if !user.Active {
-		logging.Auth.Login.WithFields("username", req.Username, "user_id", user.ID, "remote_ip", c.ClientIP(), "reason", "account_inactive").
+		logging.Auth.Login.WithContext(c.Request.Context()).WithFields("username", req.Username, "user_id", user.ID, "remote_ip", c.ClientIP(), "reason", "account_inactive").
 			Warn("Login failed: account inactive")
-		c.JSON(http.StatusForbidden, gin.H{"error": "User account is inactive"})
+		security.NewEvent(c, security.AuthFailure, "login").WithUser(user).WithReason("account_inactive").Emit()
+		apierror.Write(c, http.StatusForbidden, "User account is inactive")
 		return
 	}
 
 	// Generate JWT token
 	token, err := auth.GenerateJWT(user.ID, user.Username, user.Email, user.Permissions)
 	if err != nil {
-		logging.Auth.JWT.WithFields("username", req.Username, "user_id", user.ID, "error", err.Error()).
+		logging.Auth.JWT.WithContext(c.Request.Context()).WithFields("username", req.Username, "user_id", user.ID, "error", err.Error()).
 			Error("Failed to generate JWT token")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		apierror.Write(c, http.StatusInternalServerError, "Failed to generate token")
 		return
 	}
 
@@ -104,19 +113,22 @@ func LoginHandler(c *gin.Context) {
 	now := time.Now()
 	user.LastLogin = &now
 	if err := db.UpdateUser(c.Request.Context(), user); err != nil {
-		logging.DB.WithFields("username", req.Username, "user_id", user.ID, "error", err.Error()).
+		logging.DB.WithContext(c.Request.Context()).WithFields("username", req.Username, "user_id", user.ID, "error", err.Error()).
 			Warn("Failed to update last login time")
 	}
 
-	logging.Auth.Login.WithFields("username", req.Username, "user_id", user.ID, "remote_ip", c.ClientIP()).
+	logging.Auth.Login.WithContext(c.Request.Context()).WithFields("username", req.Username, "user_id", user.ID, "remote_ip", c.ClientIP()).
 		Info("User login successful")
 
 	c.JSON(http.StatusOK, gin.H{
-		"token":       token,
-		"user_id":     user.ID,
-		"username":    user.Username,
-		"email":       user.Email,
-		"permissions": user.Permissions,
+		"token":                    token,
+		"user_id":                  user.ID,
+		"username":                 user.Username,
+		"email":                    user.Email,
+		"permissions":              user.Permissions,
+		"password_change_required": user.PasswordChangeRequired,
+		"minecraft_uuid":           user.MinecraftUUID,
+		"minecraft_name":           user.MinecraftName,
 	})
 }
 
@@ -129,28 +141,28 @@ func LoginHandler(c *gin.Context) {
 // @Produce      json
 // @Param        request  body      RegisterRequest  true  "Registration information"
 // @Success      201      {object}  map[string]interface{}  "Registration successful"
-// @Failure      400      {object}  map[string]string       "Invalid request format"
-// @Failure      409      {object}  map[string]string       "User already exists"
-// @Failure      500      {object}  map[string]string       "Server error"
+// @Failure      400      {object}  apierror.Error          "Invalid request format"
+// @Failure      409      {object}  apierror.Error          "User already exists"
+// @Failure      500      {object}  apierror.Error          "Server error"
 // @Router       /auth/register [post]
 func RegisterHandler(c *gin.Context) {
 	var req RegisterRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		logging.API.InvalidRequest.WithFields("error", err.Error(), "remote_ip", c.ClientIP()).
+		logging.API.InvalidRequest.WithContext(c.Request.Context()).WithFields("error", err.Error(), "remote_ip", c.ClientIP()).
 			Warn("Invalid registration request format")
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		apierror.Invalid(c, err)
 		return
 	}
 
-	logging.Auth.Register.WithFields("username", req.Username, "email", req.Email, "remote_ip", c.ClientIP()).
+	logging.Auth.Register.WithContext(c.Request.Context()).WithFields("username", req.Username, "email", req.Email, "remote_ip", c.ClientIP()).
 		Info("User registration attempt")
 
 	// Hash password
 	passwordHash, err := auth.HashPassword(req.Password)
 	if err != nil {
-		logging.Auth.WithFields("username", req.Username, "error", err.Error()).
+		logging.Auth.WithContext(c.Request.Context()).WithFields("username", req.Username, "error", err.Error()).
 			Error("Failed to hash password during registration")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+		apierror.Write(c, http.StatusInternalServerError, "Failed to hash password")
 		return
 	}
 
@@ -166,27 +178,27 @@ func RegisterHandler(c *gin.Context) {
 	db := database.GetDB()
 	if err := db.CreateUser(c.Request.Context(), user); err != nil {
 		if err == database.ErrUserExists {
-			logging.Auth.Register.WithFields("username", req.Username, "email", req.Email, "remote_ip", c.ClientIP(), "reason", "user_exists").
+			logging.Auth.Register.WithContext(c.Request.Context()).WithFields("username", req.Username, "email", req.Email, "remote_ip", c.ClientIP(), "reason", "user_exists").
 				Warn("Registration failed: user already exists")
-			c.JSON(http.StatusConflict, gin.H{"error": "Username or email already exists"})
+			apierror.Write(c, http.StatusConflict, "Username or email already exists")
 			return
 		}
-		logging.DB.WithFields("username", req.Username, "email", req.Email, "error", err.Error()).
+		logging.DB.WithContext(c.Request.Context()).WithFields("username", req.Username, "email", req.Email, "error", err.Error()).
 			Error("Database error during user registration")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
+		apierror.Write(c, http.StatusInternalServerError, "Failed to create user")
 		return
 	}
 
 	// Generate JWT token
 	token, err := auth.GenerateJWT(user.ID, user.Username, user.Email, user.Permissions)
 	if err != nil {
-		logging.Auth.JWT.WithFields("username", req.Username, "user_id", user.ID, "error", err.Error()).
+		logging.Auth.JWT.WithContext(c.Request.Context()).WithFields("username", req.Username, "user_id", user.ID, "error", err.Error()).
 			Error("Failed to generate JWT token during registration")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		apierror.Write(c, http.StatusInternalServerError, "Failed to generate token")
 		return
 	}
 
-	logging.Auth.Register.WithFields("username", req.Username, "user_id", user.ID, "email", req.Email, "remote_ip", c.ClientIP()).
+	logging.Auth.Register.WithContext(c.Request.Context()).WithFields("username", req.Username, "user_id", user.ID, "email", req.Email, "remote_ip", c.ClientIP()).
 		Info("User registration successful")
 
 	c.JSON(http.StatusCreated, gin.H{
@@ -198,39 +210,330 @@ func RegisterHandler(c *gin.Context) {
 	})
 }
 
-// GetUserInfoHandler returns information about the authenticated user.
+// UserServerSummary sums up the servers owned by a user, for the dashboard home screen.
+type UserServerSummary struct {
+	Owned    int                 `json:"owned" example:"3"`
+	Running  int                 `json:"running" example:"1"`
+	ByStatus map[string]int      `json:"by_status"`
+	Usage    QuotaUsage          `json:"usage"`
+	Quota    *database.UserQuota `json:"quota"`
+}
+
+// userServerSummary counts the servers owned by a user by the status last recorded
+// for them, with the resources they use against the user's quota, nil when the user
+// has none.
+func userServerSummary(ctx context.Context, userID int64) (*UserServerSummary, error) {
+	db := database.GetDB()
+	servers, err := db.ListServersByOwner(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	quota, err := db.GetUserQuota(ctx, userID)
+	if err != nil && !errors.Is(err, database.ErrQuotaNotFound) {
+		return nil, err
+	}
+
+	summary := &UserServerSummary{
+		Owned:    len(servers),
+		ByStatus: make(map[string]int),
+		Usage:    serversQuotaUsage(servers).response(),
+		Quota:    quota,
+	}
+	for _, server := range servers {
+		summary.ByStatus[server.Status]++
+		if server.Status == database.ServerStatusRunning {
+			summary.Running++
+		}
+	}
+	return summary, nil
+}
+
+// GetUserInfoHandler returns information about the authenticated user, with a
+// summary of the servers they own. The summary is left out when it cannot be read,
+// rather than failing the request.
 //
 // @Summary      Get current user info
-// @Description  Returns information about the currently authenticated user
+// @Description  Returns information about the currently authenticated user, with the number of servers they own, how many are running, and their quota usage
 // @Tags         auth
 // @Produce      json
 // @Security     BearerAuth
 // @Success      200  {object}  map[string]interface{}  "User information"
-// @Failure      401  {object}  map[string]string       "Authentication required"
+// @Failure      401  {object}  apierror.Error          "Authentication required"
 // @Router       /auth/me [get]
 func GetUserInfoHandler(c *gin.Context) {
 	user, ok := auth.GetCurrentUser(c)
 	if !ok {
-		logging.Auth.Session.WithFields("remote_ip", c.ClientIP(), "reason", "not_authenticated").
+		logging.Auth.Session.WithContext(c.Request.Context()).WithFields("remote_ip", c.ClientIP(), "reason", "not_authenticated").
 			Warn("User info request from unauthenticated user")
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		apierror.Write(c, http.StatusUnauthorized, "Not authenticated")
 		return
 	}
 
-	logging.Auth.Session.WithFields("user_id", user.ID, "username", user.Username, "remote_ip", c.ClientIP()).
+	logging.Auth.Session.WithContext(c.Request.Context()).WithFields("user_id", user.ID, "username", user.Username, "remote_ip", c.ClientIP()).
 		Debug("User info requested")
 
+	servers, err := userServerSummary(c.Request.Context(), user.ID)
+	if err != nil {
+		logging.Auth.Session.WithContext(c.Request.Context()).WithFields("user_id", user.ID, "error", err.Error()).
+			Warn("Failed to summarize the servers of the user")
+	}
+
 	c.JSON(http.StatusOK, gin.H{
-		"user_id":     user.ID,
-		"username":    user.Username,
-		"email":       user.Email,
-		"permissions": user.Permissions,
-		"active":      user.Active,
-		"last_login":  user.LastLogin,
-		"created_at":  user.CreatedAt,
+		"user_id":                  user.ID,
+		"username":                 user.Username,
+		"email":                    user.Email,
+		"display_name":             user.DisplayName,
+		"permissions":              user.Permissions,
+		"active":                   user.Active,
+		"last_login":               user.LastLogin,
+		"created_at":               user.CreatedAt,
+		"password_change_required": user.PasswordChangeRequired,
+		"minecraft_uuid":           user.MinecraftUUID,
+		"minecraft_name":           user.MinecraftName,
+		"servers":                  servers,
+	})
+}
+
+// UpdateProfileRequest represents the profile change payload. Omitted fields are
+// kept, and changing the email requires the current password.
+type UpdateProfileRequest struct {
+	Email           *string `json:"email" binding:"omitempty,email" example:"new@example.com"`
+	DisplayName     *string `json:"displayName" binding:"omitempty,max=100" example:"Steve"`
+	CurrentPassword string  `json:"currentPassword" example:"oldpassword"`
+}
+
+// UpdateProfileHandler changes the email and display name of the authenticated user.
+//
+// @Summary      Update profile
+// @Description  Changes the email and display name of the currently authenticated user. Changing the email requires the current password
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        request  body      UpdateProfileRequest    true  "Profile fields to change"
+// @Success      200      {object}  map[string]interface{}  "Updated profile"
+// @Failure      400      {object}  apierror.Error          "Invalid request format"
+// @Failure      401      {object}  apierror.Error          "Authentication required or wrong current password"
+// @Failure      409      {object}  apierror.Error          "Email already in use"
+// @Failure      500      {object}  apierror.Error          "Server error"
+// @Router       /auth/me [put]
+func UpdateProfileHandler(c *gin.Context) {
+	user, ok := auth.GetCurrentUser(c)
+	if !ok {
+		apierror.Write(c, http.StatusUnauthorized, "Not authenticated")
+		return
+	}
+
+	var req UpdateProfileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		logging.API.InvalidRequest.WithContext(c.Request.Context()).WithFields("error", err.Error(), "user_id", user.ID, "remote_ip", c.ClientIP()).
+			Warn("Invalid profile update request format")
+		apierror.Invalid(c, err)
+		return
+	}
+
+	ctx := c.Request.Context()
+	db := database.GetDB()
+	updateFields := make([]string, 0)
+
+	if req.Email != nil && !strings.EqualFold(*req.Email, user.Email) {
+		if err := auth.VerifyPassword(user.PasswordHash, req.CurrentPassword); err != nil {
+			logging.Auth.Password.WithContext(ctx).WithFields("user_id", user.ID, "username", user.Username, "remote_ip", c.ClientIP(), "reason", "invalid_password").
+				Warn("Email change failed: invalid current password")
+			security.NewEvent(c, security.AuthFailure, "change_email").WithUser(user).WithReason("invalid_password").Emit()
+			apierror.Write(c, http.StatusUnauthorized, "Current password is incorrect")
+			return
+		}
+		existing, err := db.GetUserByEmail(ctx, *req.Email)
+		if err == nil && existing.ID != user.ID {
+			apierror.Write(c, http.StatusConflict, "Email already exists")
+			return
+		}
+		if err != nil && !errors.Is(err, database.ErrUserNotFound) {
+			apierror.Write(c, http.StatusInternalServerError, "Failed to check email")
+			return
+		}
+	}
+	if req.Email != nil && *req.Email != user.Email {
+		updateFields = append(updateFields, "email")
+		user.Email = *req.Email
+	}
+	if req.DisplayName != nil {
+		updateFields = append(updateFields, "display_name")
+		user.DisplayName = strings.TrimSpace(*req.DisplayName)
+	}
+
+	if len(updateFields) > 0 {
+		if err := db.UpdateUser(ctx, user); err != nil {
+			logging.DB.WithContext(ctx).WithFields("user_id", user.ID, "error", err.Error()).
+				Error("Failed to update user profile")
+			apierror.Write(c, http.StatusInternalServerError, "Failed to update profile")
+			return
+		}
+		logging.Auth.WithContext(ctx).WithFields("user_id", user.ID, "username", user.Username, "updated_fields", updateFields, "remote_ip", c.ClientIP()).
+			Info("User profile updated")
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"user_id":      user.ID,
+		"username":     user.Username,
+		"email":        user.Email,
+		"display_name": user.DisplayName,
+		"updated_at":   user.UpdatedAt,
 	})
 }
 
+// ChangePasswordRequest represents the password change payload.
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"currentPassword" binding:"required" example:"oldpassword"`
+	NewPassword     string `json:"newPassword" binding:"required,min=8" example:"newsecurepass123"`
+}
+
+// ChangePasswordHandler changes the password of the authenticated user.
+//
+// @Summary      Change password
+// @Description  Changes the password of the currently authenticated user and clears any pending password change requirement
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        request  body      ChangePasswordRequest  true  "Current and new password"
+// @Success      200      {object}  map[string]string      "Password changed"
+// @Failure      400      {object}  apierror.Error         "Invalid request format"
+// @Failure      401      {object}  apierror.Error         "Authentication required or wrong current password"
+// @Failure      500      {object}  apierror.Error         "Server error"
+// @Router       /auth/me/password [post]
+func ChangePasswordHandler(c *gin.Context) {
+	user, ok := auth.GetCurrentUser(c)
+	if !ok {
+		apierror.Write(c, http.StatusUnauthorized, "Not authenticated")
+		return
+	}
+
+	var req ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		logging.API.InvalidRequest.WithContext(c.Request.Context()).WithFields("error", err.Error(), "user_id", user.ID, "remote_ip", c.ClientIP()).
+			Warn("Invalid password change request format")
+		apierror.Invalid(c, err)
+		return
+	}
+
+	if err := auth.VerifyPassword(user.PasswordHash, req.CurrentPassword); err != nil {
+		logging.Auth.Password.WithContext(c.Request.Context()).WithFields("user_id", user.ID, "username", user.Username, "remote_ip", c.ClientIP(), "reason", "invalid_password").
+			Warn("Password change failed: invalid current password")
+		security.NewEvent(c, security.AuthFailure, "change_password").WithUser(user).WithReason("invalid_password").Emit()
+		apierror.Write(c, http.StatusUnauthorized, "Current password is incorrect")
+		return
+	}
+
+	if req.NewPassword == req.CurrentPassword {
+		apierror.Write(c, http.StatusBadRequest, "New password must differ from the current password")
+		return
+	}
+
+	passwordHash, err := auth.HashPassword(req.NewPassword)
+	if err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to hash password")
+		return
+	}
+
+	user.PasswordHash = passwordHash
+	user.PasswordChangeRequired = false
+	if err := database.GetDB().UpdateUser(c.Request.Context(), user); err != nil {
+		logging.DB.WithContext(c.Request.Context()).WithFields("user_id", user.ID, "error", err.Error()).
+			Error("Failed to update user password")
+		apierror.Write(c, http.StatusInternalServerError, "Failed to update password")
+		return
+	}
+
+	logging.Auth.Password.WithContext(c.Request.Context()).WithFields("user_id", user.ID, "username", user.Username, "remote_ip", c.ClientIP()).
+		Info("User password changed")
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password changed successfully"})
+}
+
+// LogoutHandler revokes the JWT the request is authenticated with, so that it stops
+// working before it expires.
+//
+// @Summary      Log out
+// @Description  Revokes the JWT of the request until it expires. Tokens issued before logout was supported cannot be revoked and are refused with a 400
+// @Tags         auth
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200  {object}  map[string]string  "Logged out"
+// @Failure      400  {object}  apierror.Error     "Token cannot be revoked"
+// @Failure      401  {object}  apierror.Error     "Authentication required"
+// @Failure      500  {object}  apierror.Error     "Server error"
+// @Router       /auth/logout [post]
+func LogoutHandler(c *gin.Context) {
+	user, ok := auth.RequireCurrentUser(c)
+	if !ok {
+		return
+	}
+	claims, ok := auth.GetCurrentClaims(c)
+	if !ok {
+		apierror.Write(c, http.StatusBadRequest, "Only JWT sessions can log out")
+		return
+	}
+
+	if err := auth.RevokeToken(c.Request.Context(), claims); err != nil {
+		if errors.Is(err, auth.ErrTokenNotRevocable) {
+			apierror.Write(c, http.StatusBadRequest, "This token predates logout and cannot be revoked; log in again to get one that can")
+			return
+		}
+		logging.Auth.Session.WithContext(c.Request.Context()).WithFields("user_id", user.ID, "error", err.Error()).
+			Error("Failed to revoke token")
+		apierror.Write(c, http.StatusInternalServerError, "Failed to log out")
+		return
+	}
+
+	logging.Auth.Session.WithContext(c.Request.Context()).WithFields("user_id", user.ID, "username", user.Username, "remote_ip", c.ClientIP()).
+		Info("User logged out")
+
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out successfully"})
+}
+
+// oauthStatePurpose is the purpose of the nonces recording the OAuth login states,
+// keyed by the session of the oauthSessionCookie.
+const oauthStatePurpose = "oauth-state"
+
+// oauthSessionCookie binds an OAuth flow to the browser that started it. It holds an
+// opaque session, the state and the PKCE verifier staying on the API.
+const oauthSessionCookie = "oauth_session"
+
+// oauthState is the flow an OAuth state was issued for: a login with a provider, or
+// the linking of a provider to the account of a user.
+type oauthState struct {
+	Provider string `json:"provider"`
+	UserID   int64  `json:"userId,omitempty"` // User linking the provider, 0 for a login
+	State    string `json:"state"`
+	Verifier string `json:"verifier"` // PKCE code verifier
+}
+
+// getOAuthProvider initializes the OAuth provider named in a request, answering 400
+// for unknown or disabled providers.
+func getOAuthProvider(c *gin.Context, provider string) (*auth.OAuthProvider, bool) {
+	oauthProvider, err := auth.GetOAuthProvider(provider)
+	switch {
+	case errors.Is(err, auth.ErrUnsupportedProvider):
+		logging.Auth.OAuth.WithContext(c.Request.Context()).WithFields("remote_ip", c.ClientIP(), "provider", provider, "reason", "unsupported_provider").
+			Warn("OAuth request failed: unsupported provider")
+		apierror.Write(c, http.StatusBadRequest, "Unsupported OAuth provider")
+		return nil, false
+	case errors.Is(err, auth.ErrOAuthNotEnabled):
+		logging.Auth.OAuth.WithContext(c.Request.Context()).WithFields("remote_ip", c.ClientIP(), "provider", provider, "reason", "provider_not_enabled").
+			Warn("OAuth request failed: provider is not enabled")
+		apierror.Write(c, http.StatusBadRequest, "OAuth provider is not enabled")
+		return nil, false
+	case err != nil:
+		logging.Auth.OAuth.WithContext(c.Request.Context()).WithFields("remote_ip", c.ClientIP(), "provider", provider, "error", err.Error()).
+			Error("Failed to initialize OAuth provider")
+		apierror.Write(c, http.StatusInternalServerError, "Failed to initialize OAuth provider")
+		return nil, false
+	}
+	return oauthProvider, true
+}
+
 // GenerateStateValue creates a random state value for OAuth flows.
 // It returns a base64-encoded random string and any error encountered.
 func GenerateStateValue() (string, error) {
@@ -248,71 +551,85 @@ func GenerateStateValue() (string, error) {
 // @Description  Redirects to OAuth provider's login page
 // @Tags         auth
 // @Produce      html
-// @Param        provider  path      string  true  "OAuth provider (e.g., 'authentik')"
+// @Param        provider  path      string  true  "OAuth provider: authentik, github or google"
 // @Success      307       {string}  string  "Redirect to OAuth provider"
-// @Failure      400       {object}  map[string]string  "OAuth not enabled or invalid provider"
-// @Failure      500       {object}  map[string]string  "Server error"
+// @Failure      400       {object}  apierror.Error     "OAuth not enabled or invalid provider"
+// @Failure      500       {object}  apierror.Error     "Server error"
 // @Router       /auth/oauth/{provider} [get]
 func OAuthLoginHandler(c *gin.Context) {
 	// Check if OAuth is enabled
-	if !config.OAuthEnabled {
-		logging.Auth.OAuth.WithFields("remote_ip", c.ClientIP(), "reason", "oauth_not_enabled").
+	if !config.Live().OAuthEnabled {
+		logging.Auth.OAuth.WithContext(c.Request.Context()).WithFields("remote_ip", c.ClientIP(), "reason", "oauth_not_enabled").
 			Warn("OAuth login failed: OAuth is not enabled")
-		c.JSON(http.StatusBadRequest, gin.H{"error": "OAuth is not enabled"})
+		apierror.Write(c, http.StatusBadRequest, "OAuth is not enabled")
 		return
 	}
 
 	// Get provider from URL parameter
 	provider := c.Param("provider")
-	if provider != "authentik" {
-		logging.Auth.OAuth.WithFields("remote_ip", c.ClientIP(), "provider", provider, "reason", "unsupported_provider").
-			Warn("OAuth login failed: unsupported provider")
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported OAuth provider"})
-		return
-	}
 
-	logging.Auth.OAuth.WithFields("remote_ip", c.ClientIP(), "provider", provider).
+	logging.Auth.OAuth.WithContext(c.Request.Context()).WithFields("remote_ip", c.ClientIP(), "provider", provider).
 		Info("OAuth login flow initiated")
 
 	// Initialize OAuth provider
-	oauthProvider, err := auth.NewAuthentikProvider()
-	if err != nil {
-		logging.Auth.OAuth.WithFields("remote_ip", c.ClientIP(), "provider", provider, "error", err.Error()).
-			Error("Failed to initialize OAuth provider")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to initialize OAuth provider"})
+	oauthProvider, ok := getOAuthProvider(c, provider)
+	if !ok {
+		return
+	}
+
+	authURL, ok := startOAuthFlow(c, oauthProvider, oauthState{Provider: provider})
+	if !ok {
 		return
 	}
 
+	logging.Auth.OAuth.WithContext(c.Request.Context()).WithFields("remote_ip", c.ClientIP(), "provider", provider).
+		Info("Redirecting user to OAuth provider")
+
+	c.Redirect(http.StatusTemporaryRedirect, authURL)
+}
+
+// startOAuthFlow records a new state for a flow with an OAuth provider and returns
+// the URL to send the user to.
+func startOAuthFlow(c *gin.Context, oauthProvider *auth.OAuthProvider, flow oauthState) (string, bool) {
 	// Generate and store state parameter to prevent CSRF
 	state, err := GenerateStateValue()
+	var session string
+	if err == nil {
+		session, err = GenerateStateValue()
+	}
 	if err != nil {
-		logging.Auth.OAuth.WithFields("remote_ip", c.ClientIP(), "provider", provider, "error", err.Error()).
+		logging.Auth.OAuth.WithContext(c.Request.Context()).WithFields("remote_ip", c.ClientIP(), "provider", flow.Provider, "error", err.Error()).
 			Error("Failed to generate OAuth state parameter")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate state"})
-		return
+		apierror.Write(c, http.StatusInternalServerError, "Failed to generate state")
+		return "", false
 	}
 
-	// Store state in a secure HTTP-only cookie for verification later
+	// Record the flow for one-time use, wherever the callback is served, under a
+	// session bound to the browser with a secure HTTP-only cookie. The cookie is sent
+	// back on the redirect from the provider, a top-level navigation, so it is Lax.
+	flow.State, flow.Verifier = state, auth.NewVerifier()
+	data, _ := json.Marshal(flow)
+	if err := database.GetDB().CreateNonce(c.Request.Context(), oauthStatePurpose, session, string(data), time.Now().Add(config.OAuthStateTTL)); err != nil {
+		logging.Auth.OAuth.WithContext(c.Request.Context()).WithFields("remote_ip", c.ClientIP(), "provider", flow.Provider, "error", err.Error()).
+			Error("Failed to store OAuth state parameter")
+		apierror.Write(c, http.StatusInternalServerError, "Failed to store state")
+		return "", false
+	}
+	c.SetSameSite(http.SameSiteLaxMode)
 	c.SetCookie(
-		"oauth_state",
-		state,
-		int(time.Now().Add(15*time.Minute).Unix()), // Expires after 15 minutes
+		oauthSessionCookie,
+		session,
+		int(config.OAuthStateTTL.Seconds()),
 		"/",
 		"",
 		true, // Secure (HTTPS only)
 		true, // HTTP-only
 	)
 
-	logging.Auth.OAuth.WithFields("remote_ip", c.ClientIP(), "provider", provider).
+	logging.Auth.OAuth.WithContext(c.Request.Context()).WithFields("remote_ip", c.ClientIP(), "provider", flow.Provider, "linking_user_id", flow.UserID).
 		Debug("OAuth state parameter generated and stored")
 
-	// Redirect to OAuth provider's auth page
-	authURL := oauthProvider.GetAuthURL(state)
-
-	logging.Auth.OAuth.WithFields("remote_ip", c.ClientIP(), "provider", provider).
-		Info("Redirecting user to OAuth provider")
-
-	c.Redirect(http.StatusTemporaryRedirect, authURL)
+	return oauthProvider.GetAuthURL(state, flow.Verifier), true
 }
 
 // OAuthCallbackHandler handles the OAuth callback from providers.
@@ -321,32 +638,28 @@ func OAuthLoginHandler(c *gin.Context) {
 // @Description  Handles the callback from OAuth provider and creates/authenticates user
 // @Tags         auth
 // @Produce      html
-// @Param        provider  path      string  true  "OAuth provider (e.g., 'authentik')"
+// @Param        provider  path      string  true  "OAuth provider: authentik, github or google"
 // @Param        code      query     string  true  "OAuth code"
 // @Param        state     query     string  true  "OAuth state"
 // @Success      307       {string}  string  "Redirect to frontend with token"
-// @Failure      400       {object}  map[string]string  "Invalid request or state mismatch"
-// @Failure      500       {object}  map[string]string  "Server error"
+// @Failure      400       {object}  apierror.Error     "Invalid request or state mismatch"
+// @Failure      403       {object}  apierror.Error     "Account inactive, or signups by invitation only"
+// @Failure      500       {object}  apierror.Error     "Server error"
 // @Router       /auth/callback/{provider} [get]
 func OAuthCallbackHandler(c *gin.Context) {
+	cfg := config.Live()
 	// Check if OAuth is enabled
-	if !config.OAuthEnabled {
-		logging.Auth.OAuth.WithFields("remote_ip", c.ClientIP(), "reason", "oauth_not_enabled").
+	if !cfg.OAuthEnabled {
+		logging.Auth.OAuth.WithContext(c.Request.Context()).WithFields("remote_ip", c.ClientIP(), "reason", "oauth_not_enabled").
 			Warn("OAuth callback failed: OAuth is not enabled")
-		c.JSON(http.StatusBadRequest, gin.H{"error": "OAuth is not enabled"})
+		apierror.Write(c, http.StatusBadRequest, "OAuth is not enabled")
 		return
 	}
 
 	// Get provider from URL parameter
 	provider := c.Param("provider")
-	if provider != "authentik" {
-		logging.Auth.OAuth.WithFields("remote_ip", c.ClientIP(), "provider", provider, "reason", "unsupported_provider").
-			Warn("OAuth callback failed: unsupported provider")
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported OAuth provider"})
-		return
-	}
 
-	logging.Auth.OAuth.WithFields("remote_ip", c.ClientIP(), "provider", provider).
+	logging.Auth.OAuth.WithContext(c.Request.Context()).WithFields("remote_ip", c.ClientIP(), "provider", provider).
 		Info("OAuth callback received")
 
 	// Get code and state from query parameters
@@ -354,82 +667,131 @@ func OAuthCallbackHandler(c *gin.Context) {
 	state := c.Query("state")
 
 	if code == "" {
-		logging.Auth.OAuth.WithFields("remote_ip", c.ClientIP(), "provider", provider, "reason", "missing_code").
+		logging.Auth.OAuth.WithContext(c.Request.Context()).WithFields("remote_ip", c.ClientIP(), "provider", provider, "reason", "missing_code").
 			Warn("OAuth callback failed: missing code parameter")
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing code parameter"})
+		apierror.Write(c, http.StatusBadRequest, "Missing code parameter")
+		return
+	}
+
+	// Retrieve the flow of the session of the browser, used once, even when the
+	// callback is replayed to another replica
+	session, err := c.Cookie(oauthSessionCookie)
+	if err != nil || session == "" {
+		logging.Auth.OAuth.WithContext(c.Request.Context()).WithFields("remote_ip", c.ClientIP(), "provider", provider, "reason", "missing_session").
+			Warn("OAuth callback failed: no OAuth session cookie")
+		security.NewEvent(c, security.AuthFailure, "oauth_callback").WithReason("state_mismatch").WithDetail("provider", provider).Emit()
+		apierror.Write(c, http.StatusBadRequest, "Invalid OAuth state parameter")
+		return
+	}
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oauthSessionCookie, "", -1, "/", "", true, true)
+
+	flowData, err := database.GetDB().ConsumeNonce(c.Request.Context(), oauthStatePurpose, session)
+	if errors.Is(err, database.ErrNonceNotFound) {
+		logging.Auth.OAuth.WithContext(c.Request.Context()).WithFields("remote_ip", c.ClientIP(), "provider", provider, "reason", "state_reused").
+			Warn("OAuth callback failed: state already used or expired")
+		security.NewEvent(c, security.AuthFailure, "oauth_callback").WithReason("state_reused").WithDetail("provider", provider).Emit()
+		apierror.Write(c, http.StatusBadRequest, "OAuth state already used or expired")
+		return
+	}
+	if err != nil {
+		logging.Auth.OAuth.WithContext(c.Request.Context()).WithFields("remote_ip", c.ClientIP(), "provider", provider, "error", err.Error()).
+			Error("Failed to verify OAuth state parameter")
+		apierror.Write(c, http.StatusInternalServerError, "Failed to verify OAuth state")
 		return
 	}
 
-	// Retrieve and verify the state from cookie
-	savedState, err := c.Cookie("oauth_state")
-	if err != nil || savedState == "" || savedState != state {
-		logging.Auth.OAuth.WithFields("remote_ip", c.ClientIP(), "provider", provider, "reason", "state_mismatch",
-			"have_cookie", savedState != "", "state_match", savedState == state).
+	// The state comes back from the provider as it was issued to the session
+	var flow oauthState
+	if err := json.Unmarshal([]byte(flowData), &flow); err != nil || subtle.ConstantTimeCompare([]byte(flow.State), []byte(state)) != 1 {
+		logging.Auth.OAuth.WithContext(c.Request.Context()).WithFields("remote_ip", c.ClientIP(), "provider", provider, "reason", "state_mismatch").
 			Warn("OAuth callback failed: invalid state parameter")
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid OAuth state parameter"})
-		c.Abort()
+		security.NewEvent(c, security.AuthFailure, "oauth_callback").WithReason("state_mismatch").WithDetail("provider", provider).Emit()
+		apierror.Write(c, http.StatusBadRequest, "Invalid OAuth state parameter")
 		return
 	}
 
-	logging.Auth.OAuth.Debug("OAuth state verification successful")
+	// The state was issued for a flow with one provider, whose callback this must be
+	if flow.Provider != provider {
+		logging.Auth.OAuth.WithContext(c.Request.Context()).WithFields("remote_ip", c.ClientIP(), "provider", provider, "state_provider", flow.Provider, "reason", "provider_mismatch").
+			Warn("OAuth callback failed: state issued for another provider")
+		security.NewEvent(c, security.AuthFailure, "oauth_callback").WithReason("provider_mismatch").WithDetail("provider", provider).Emit()
+		apierror.Write(c, http.StatusBadRequest, "Invalid OAuth state parameter")
+		return
+	}
 
-	// Clear the cookie after use
-	c.SetCookie("oauth_state", "", -1, "/", "", true, true)
+	logging.Auth.OAuth.WithContext(c.Request.Context()).Debug("OAuth state verification successful")
 
 	// Initialize OAuth provider
-	oauthProvider, err := auth.NewAuthentikProvider()
-	if err != nil {
-		logging.Auth.OAuth.WithFields("remote_ip", c.ClientIP(), "provider", provider, "error", err.Error()).
-			Error("Failed to initialize OAuth provider during callback")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to initialize OAuth provider"})
+	oauthProvider, ok := getOAuthProvider(c, provider)
+	if !ok {
 		return
 	}
 
 	// Exchange code for token
-	token, err := oauthProvider.Exchange(context.Background(), code)
+	token, err := oauthProvider.Exchange(context.Background(), code, flow.Verifier)
 	if err != nil {
-		logging.Auth.OAuth.WithFields("remote_ip", c.ClientIP(), "provider", provider, "error", err.Error()).
+		logging.Auth.OAuth.WithContext(c.Request.Context()).WithFields("remote_ip", c.ClientIP(), "provider", provider, "error", err.Error()).
 			Error("Failed to exchange OAuth code for token")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to exchange OAuth code: " + err.Error()})
+		apierror.Write(c, http.StatusInternalServerError, "Failed to exchange OAuth code: "+err.Error())
 		return
 	}
 
-	logging.Auth.OAuth.Debug("OAuth code successfully exchanged for token")
+	logging.Auth.OAuth.WithContext(c.Request.Context()).Debug("OAuth code successfully exchanged for token")
 
 	// Get user info from token
 	userInfo, err := oauthProvider.GetUserInfo(context.Background(), token)
 	if err != nil {
-		logging.Auth.OAuth.WithFields("remote_ip", c.ClientIP(), "provider", provider, "error", err.Error()).
+		logging.Auth.OAuth.WithContext(c.Request.Context()).WithFields("remote_ip", c.ClientIP(), "provider", provider, "error", err.Error()).
 			Error("Failed to get user info from OAuth provider")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user info: " + err.Error()})
+		apierror.Write(c, http.StatusInternalServerError, "Failed to get user info: "+err.Error())
 		return
 	}
 
-	logging.Auth.OAuth.WithFields("remote_ip", c.ClientIP(), "provider", provider, "oauth_username", userInfo.Username, "oauth_email", userInfo.Email).
+	logging.Auth.OAuth.WithContext(c.Request.Context()).WithFields("remote_ip", c.ClientIP(), "provider", provider, "oauth_username", userInfo.Username, "oauth_email", userInfo.Email).
 		Info("Successfully retrieved user info from OAuth provider")
 
-	// Create or update user in database
+	if flow.UserID != 0 {
+		completeOAuthLink(c, flow.UserID, userInfo)
+		return
+	}
+
+	// Get or create the user linked to the account
 	user, err := auth.SyncOAuthUser(c.Request.Context(), userInfo)
+	if errors.Is(err, auth.ErrOAuthAccountExists) {
+		offerOAuthLink(c, user, userInfo)
+		return
+	}
+	if errors.Is(err, auth.ErrAccountInactive) {
+		security.NewEvent(c, security.AuthFailure, "oauth_callback").WithUser(user).WithReason("account_inactive").WithDetail("provider", provider).Emit()
+		apierror.Write(c, http.StatusForbidden, "User account is inactive")
+		return
+	}
+	if errors.Is(err, auth.ErrSignupDisabled) {
+		security.NewEvent(c, security.AuthFailure, "oauth_callback").WithReason("signup_disabled").WithDetail("provider", provider).Emit()
+		apierror.Write(c, http.StatusForbidden, "Accounts are created by invitation only: ask an admin for an invitation")
+		return
+	}
 	if err != nil {
-		logging.Auth.OAuth.WithFields("remote_ip", c.ClientIP(), "provider", provider, "oauth_username", userInfo.Username, "error", err.Error()).
+		logging.Auth.OAuth.WithContext(c.Request.Context()).WithFields("remote_ip", c.ClientIP(), "provider", provider, "oauth_username", userInfo.Username, "error", err.Error()).
 			Error("Failed to sync OAuth user with database")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sync user: " + err.Error()})
+		apierror.Write(c, http.StatusInternalServerError, "Failed to sync user: "+err.Error())
 		return
 	}
 
 	// Generate JWT token
 	jwtToken, err := auth.GenerateJWT(user.ID, user.Username, user.Email, user.Permissions)
 	if err != nil {
-		logging.Auth.JWT.WithFields("remote_ip", c.ClientIP(), "provider", provider, "user_id", user.ID, "username", user.Username, "error", err.Error()).
+		logging.Auth.JWT.WithContext(c.Request.Context()).WithFields("remote_ip", c.ClientIP(), "provider", provider, "user_id", user.ID, "username", user.Username, "error", err.Error()).
 			Error("Failed to generate JWT token after OAuth authentication")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		apierror.Write(c, http.StatusInternalServerError, "Failed to generate token")
 		return
 	}
 
-	logging.Auth.OAuth.WithFields("remote_ip", c.ClientIP(), "provider", provider, "user_id", user.ID, "username", user.Username).
+	logging.Auth.OAuth.WithContext(c.Request.Context()).WithFields("remote_ip", c.ClientIP(), "provider", provider, "user_id", user.ID, "username", user.Username).
 		Info("OAuth authentication successful, redirecting to frontend")
 
 	// Redirect to frontend with token
-	frontendRedirectURL := config.FrontendURL + "/oauth-callback?token=" + jwtToken
+	frontendRedirectURL := cfg.FrontendURL + "/oauth-callback?token=" + jwtToken
 	c.Redirect(http.StatusTemporaryRedirect, frontendRedirectURL)
 }
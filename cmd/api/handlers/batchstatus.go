@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"minecharts/cmd/apierror"
+	"minecharts/cmd/auth"
+	"minecharts/cmd/config"
+	"minecharts/cmd/database"
+	"minecharts/cmd/kubernetes"
+	"minecharts/cmd/mcproto"
+
+	"github.com/gin-gonic/gin"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// BatchStatusAction is the custom method of the batch status endpoint, which gin
+// routes as a server name since it cannot register a literal colon.
+const BatchStatusAction = "status:batch"
+
+// BatchStatusRequest lists the servers whose status is requested.
+type BatchStatusRequest struct {
+	Servers []string `json:"servers" binding:"required,min=1,max=100,dive,required" example:"survival,creative"`
+}
+
+// ServerAddress is an address players reach a server at.
+type ServerAddress struct {
+	Type    string `json:"type" example:"LoadBalancer"` // "ClusterIP", "NodePort", "LoadBalancer" or "MCRouter"
+	Address string `json:"address" example:"203.0.113.10:25565"`
+}
+
+// PlayerCount is the number of players connected to a server.
+type PlayerCount struct {
+	Online int `json:"online" example:"3"`
+	Max    int `json:"max" example:"20"`
+}
+
+// BatchServerStatus is the status of a server in a batch status response.
+type BatchServerStatus struct {
+	ServerName string          `json:"serverName"`
+	Status     string          `json:"status,omitempty" example:"running"`
+	Reason     string          `json:"reason,omitempty"`
+	Ready      bool            `json:"ready"`
+	Cached     bool            `json:"cached,omitempty"`  // Served from the last known state while the cluster is unreachable
+	Players    *PlayerCount    `json:"players,omitempty"` // Missing when the server is not running or did not answer the status ping
+	Addresses  []ServerAddress `json:"addresses,omitempty"`
+	Error      string          `json:"error,omitempty"` // Why the status of this server is missing
+}
+
+// BatchServerStatusHandler returns the status, player count and addresses of several
+// servers in one call.
+//
+// The servers are queried in parallel, at most MINECHARTS_BATCH_STATUS_CONCURRENCY at
+// a time, and reported in the order of the request.
+//
+// @Summary      Get the status of several servers
+// @Description  Returns the status, the number of connected players and the addresses of the servers named, in the order of the request. A server that is unknown or that the user cannot view is reported with an error, as is a server whose status could not be read; the player count is missing for the servers that are not running
+// @Tags         servers
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Security     APIKeyAuth
+// @Param        request  body      BatchStatusRequest       true  "Server names"
+// @Success      200      {object}  map[string][]BatchServerStatus  "Status of each server"
+// @Failure      400      {object}  apierror.Error           "Invalid request"
+// @Failure      401      {object}  apierror.Error           "Authentication required"
+// @Failure      404      {object}  apierror.Error           "Unknown action"
+// @Router       /servers/status:batch [post]
+func BatchServerStatusHandler(c *gin.Context) {
+	if c.Param("serverName") != BatchStatusAction {
+		apierror.Write(c, http.StatusNotFound, "Not found")
+		return
+	}
+	var req BatchStatusRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Invalid(c, err)
+		return
+	}
+	user, ok := auth.RequireCurrentUser(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	access, err := auth.LoadServerAccess(ctx, user)
+	if err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to load server memberships")
+		return
+	}
+	results := make([]BatchServerStatus, len(req.Servers))
+	slots := make(chan struct{}, max(config.BatchStatusConcurrency, 1))
+	var wg sync.WaitGroup
+	for i, serverName := range req.Servers {
+		results[i].ServerName = serverName
+		server, err := database.GetDB().GetServerByName(ctx, serverName)
+		// Servers the user cannot see are reported as not found
+		if err != nil || !access.Has(server.ServerName, server.OwnerID, database.PermViewServer) {
+			results[i].Error = "Server not found"
+			continue
+		}
+
+		wg.Add(1)
+		go func(result *BatchServerStatus, server *database.MinecraftServer) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			batchServerStatus(ctx, server, result)
+		}(&results[i], server)
+	}
+	wg.Wait()
+
+	c.JSON(http.StatusOK, gin.H{"servers": results})
+}
+
+// batchServerStatus fills the status of a server, its player count when it runs and
+// its addresses.
+func batchServerStatus(ctx context.Context, server *database.MinecraftServer, result *BatchServerStatus) {
+	namespace := kubernetes.ServerNamespace(server)
+	status, err := lookupServerStatus(ctx, namespace, server.ServerName, server.DeploymentName)
+	switch {
+	case errors.Is(err, errStatusNotFound):
+		result.Error = "Deployment not found"
+		return
+	case errors.Is(err, kubernetes.ErrClusterUnreachable):
+		result.Error = "Kubernetes cluster unreachable"
+		return
+	case err != nil:
+		result.Error = "Failed to get deployment"
+		return
+	}
+	result.Status = status.Status
+	result.Reason = status.Reason
+	result.Ready = status.Readiness.Ready
+	result.Cached = status.Cached
+	if status.Cached {
+		return
+	}
+
+	service, err := kubernetes.GetServiceDetails(ctx, namespace, server.DeploymentName+"-svc")
+	if err == nil {
+		result.Addresses = serviceAddresses(service)
+	}
+
+	if !result.Ready {
+		return
+	}
+	address, err := resolveGameAddress(ctx, namespace, server.DeploymentName)
+	if err != nil {
+		return
+	}
+	pingCtx, cancel := context.WithTimeout(ctx, mcproto.DefaultTimeout)
+	defer cancel()
+	if ping, err := mcproto.Ping(pingCtx, address); err == nil {
+		result.Players = &PlayerCount{Online: ping.Online, Max: ping.Max}
+	}
+}
+
+// serviceAddresses returns the addresses players reach a server at through its
+// service, as set up by the expose endpoint.
+func serviceAddresses(service *corev1.Service) []ServerAddress {
+	if domain := service.Annotations[kubernetes.MCRouterAnnotation]; domain != "" {
+		return []ServerAddress{{Type: "MCRouter", Address: domain}}
+	}
+
+	if len(service.Spec.Ports) == 0 {
+		return nil
+	}
+	port := service.Spec.Ports[0]
+	for _, servicePort := range service.Spec.Ports {
+		if servicePort.Name == "minecraft" {
+			port = servicePort
+		}
+	}
+
+	var addresses []ServerAddress
+	switch service.Spec.Type {
+	case corev1.ServiceTypeLoadBalancer:
+		for _, ingress := range service.Status.LoadBalancer.Ingress {
+			host := ingress.IP
+			if host == "" {
+				host = ingress.Hostname
+			}
+			addresses = append(addresses, ServerAddress{Type: "LoadBalancer", Address: net.JoinHostPort(host, strconv.Itoa(int(port.Port)))})
+		}
+	case corev1.ServiceTypeNodePort:
+		if port.NodePort > 0 {
+			// Reachable on any node of the cluster
+			addresses = append(addresses, ServerAddress{Type: "NodePort", Address: ":" + strconv.Itoa(int(port.NodePort))})
+		}
+	}
+	if service.Spec.ClusterIP != "" && service.Spec.ClusterIP != corev1.ClusterIPNone {
+		addresses = append(addresses, ServerAddress{Type: "ClusterIP", Address: net.JoinHostPort(service.Spec.ClusterIP, strconv.Itoa(int(port.Port)))})
+	}
+	return addresses
+}
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"minecharts/cmd/api/middleware"
+	"minecharts/cmd/auth"
+	"minecharts/cmd/config"
+	"minecharts/cmd/database"
+	"minecharts/cmd/kubernetes"
+
+	"github.com/gin-gonic/gin"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// The servers seeded for the benchmarks, a share of them owned by the user listing.
+const (
+	benchServers = 200
+	benchOwners  = 10
+)
+
+// seedBench records benchServers servers spread over benchOwners users, with their
+// deployments on the fake cluster, and returns the first user.
+func seedBench(b *testing.B) *database.User {
+	b.Helper()
+	db := setupTest(b)
+	owners := make([]*database.User, benchOwners)
+	for i := range owners {
+		owners[i] = createTestUser(b, db, fmt.Sprintf("user%d", i), database.PermReadOnly)
+	}
+	for i := 0; i < benchServers; i++ {
+		server := createTestServer(b, db, fmt.Sprintf("server%d", i), owners[i%benchOwners])
+		replicas := int32(1)
+		deployment := &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: server.DeploymentName, Namespace: config.DefaultNamespace},
+			Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+			Status:     appsv1.DeploymentStatus{Replicas: 1, ReadyReplicas: 1},
+		}
+		_, err := kubernetes.Clientset.AppsV1().Deployments(config.DefaultNamespace).Create(context.Background(), deployment, metav1.CreateOptions{})
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+	return owners[0]
+}
+
+// benchHandler runs the handlers of a route as user on b.N requests, in parallel.
+func benchHandler(b *testing.B, user *database.User, route, path string, handlers ...gin.HandlerFunc) {
+	b.Helper()
+	router := gin.New()
+	router.GET(route, append([]gin.HandlerFunc{func(c *gin.Context) {
+		c.Set(auth.AuthUserKey, user)
+		c.Next()
+	}}, handlers...)...)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+			if rec.Code != http.StatusOK {
+				// FailNow is not allowed off the benchmark goroutine
+				b.Errorf("status = %d: %s", rec.Code, rec.Body.String())
+				return
+			}
+		}
+	})
+}
+
+func BenchmarkListServers(b *testing.B) {
+	user := seedBench(b)
+	benchHandler(b, user, "/servers", "/servers", ListServersHandler)
+}
+
+func BenchmarkServerStatus(b *testing.B) {
+	user := seedBench(b)
+	benchHandler(b, user, "/servers/:serverName/status", "/servers/server0/status", GetServerStatusHandler)
+}
+
+// BenchmarkListTemplates compares the template list served by the handler with the
+// list served from the response cache.
+func BenchmarkListTemplates(b *testing.B) {
+	for _, cached := range []bool{false, true} {
+		name := "uncached"
+		if cached {
+			name = "cached"
+		}
+		b.Run(name, func(b *testing.B) {
+			user := seedBench(b)
+			for i := 0; i < 20; i++ {
+				template := &database.ServerTemplate{Name: fmt.Sprintf("template%d", i), Category: "vanilla", CreatedBy: user.ID}
+				if err := database.GetDB().CreateServerTemplate(context.Background(), template); err != nil {
+					b.Fatal(err)
+				}
+			}
+			chain := []gin.HandlerFunc{ListServerTemplatesHandler}
+			if cached {
+				b.Cleanup(func() { middleware.InvalidateCache(TemplatesCacheGroup) })
+				chain = append([]gin.HandlerFunc{middleware.CacheResponse(TemplatesCacheGroup, time.Minute, "category")}, chain...)
+			}
+			benchHandler(b, user, "/templates", "/templates", chain...)
+		})
+	}
+}
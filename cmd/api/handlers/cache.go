@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"net/http"
+
+	"minecharts/cmd/api/middleware"
+	"minecharts/cmd/apierror"
+	"minecharts/cmd/auth"
+	"minecharts/cmd/logging"
+	"minecharts/cmd/mcversions"
+	"minecharts/cmd/security"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Groups of the response cache, emptied when their responses change.
+const (
+	PermissionsCacheGroup = "permissions"
+	TemplatesCacheGroup   = "templates"
+	VersionsCacheGroup    = "versions" // The upstream version manifests
+)
+
+// InvalidateCacheHandler empties the response cache (admin only).
+//
+// @Summary      Invalidate response cache
+// @Description  Drops the cached responses of the permissions map and the template list and the fetched version manifests, or only those of the group given, so that the next requests are answered from the database or upstream. Template changes made through the API already do so (admin only)
+// @Tags         admin
+// @Produce      json
+// @Security     BearerAuth
+// @Param        group  query     string             false  "Cache group: permissions, templates or versions"
+// @Success      200    {object}  map[string]interface{}  "Cache invalidated"
+// @Failure      400    {object}  apierror.Error     "Unknown cache group"
+// @Failure      401    {object}  apierror.Error     "Authentication required"
+// @Failure      403    {object}  apierror.Error     "Permission denied"
+// @Router       /admin/cache [delete]
+func InvalidateCacheHandler(c *gin.Context) {
+	user, ok := auth.RequireCurrentUser(c)
+	if !ok {
+		return
+	}
+
+	var groups []string
+	dropped := 0
+	switch group := c.Query("group"); group {
+	case "":
+		dropped = mcversions.Invalidate() + middleware.InvalidateCache()
+	case PermissionsCacheGroup, TemplatesCacheGroup:
+		groups = []string{group}
+		dropped = middleware.InvalidateCache(groups...)
+	case VersionsCacheGroup:
+		// Kept apart from the responses, which are filtered from the manifests
+		groups = []string{group}
+		dropped = mcversions.Invalidate()
+	default:
+		apierror.Write(c, http.StatusBadRequest, "Unknown cache group: "+group)
+		return
+	}
+
+	logging.API.WithContext(c.Request.Context()).WithFields(
+		"groups", groups,
+		"dropped", dropped,
+		"user_id", user.ID,
+		"username", user.Username,
+	).Info("Response cache invalidated")
+	security.NewEvent(c, security.AdminAction, "invalidate_cache").WithUser(user).Emit()
+
+	c.JSON(http.StatusOK, gin.H{"message": "Cache invalidated", "dropped": dropped})
+}
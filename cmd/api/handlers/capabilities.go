@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"net/http"
+
+	"minecharts/cmd/apierror"
+	"minecharts/cmd/auth"
+	"minecharts/cmd/database"
+
+	"github.com/gin-gonic/gin"
+)
+
+// capability is an action of the API, with the permission it needs and the
+// endpoints it unlocks. It must follow the permissions required in the route table.
+type capability struct {
+	action     string
+	permission int64
+	endpoints  []string
+}
+
+// globalCapabilities are the actions that do not act on an existing server, which
+// need the permission itself.
+var globalCapabilities = []capability{
+	{action: "createServer", permission: database.PermCreateServer, endpoints: []string{"POST /servers", "POST /proxies"}},
+	{action: "manageUsers", permission: database.PermAdmin, endpoints: []string{
+		"GET /users", "POST /users", "POST /users/invitations",
+		"GET /users/{id}", "PUT /users/{id}", "DELETE /users/{id}", "POST /users/{id}/restore",
+		"GET /users/{id}/quota", "PUT /users/{id}/quota", "DELETE /users/{id}/quota",
+		"POST /users/{id}/permissions/grant", "POST /users/{id}/permissions/revoke",
+	}},
+	{action: "viewAuditLog", permission: database.PermAdmin, endpoints: []string{"GET /audit"}},
+	{action: "manageTemplates", permission: database.PermAdmin, endpoints: []string{
+		"POST /templates", "POST /templates/import", "PUT /templates/{id}", "DELETE /templates/{id}",
+	}},
+	{action: "reviewServerRequests", permission: database.PermAdmin, endpoints: []string{
+		"POST /requests/{id}/approve", "POST /requests/{id}/reject",
+	}},
+	{action: "maintainCluster", permission: database.PermAdmin, endpoints: []string{
+		"POST /admin/gc", "GET /admin/prepull", "POST /admin/prepull", "DELETE /admin/prepull",
+	}},
+}
+
+// serverCapabilities are the actions on a server, granted by its ownership or by the
+// permissions of the user.
+var serverCapabilities = []capability{
+	{action: "view", permission: database.PermViewServer, endpoints: []string{
+		"GET /servers/{serverName}/status", "POST /servers/status:batch", "GET /servers/{serverName}/rollout", "GET /servers/{serverName}/jobs",
+		"GET /servers/{serverName}/incidents", "GET /servers/{serverName}/events/stream",
+		"GET /servers/{serverName}/players/online", "POST /servers/{serverName}/players/link",
+		"GET /servers/{serverName}/plugins", "GET /servers/{serverName}/plugins/search", "GET /servers/{serverName}/datapacks", "GET /servers/{serverName}/worlds",
+		"GET /servers/{serverName}/tasks", "GET /servers/{serverName}/tasks/{taskId}", "GET /servers/{serverName}/tasks/{taskId}/runs",
+	}},
+	{action: "start", permission: database.PermStartServer, endpoints: []string{"POST /servers/{serverName}/start"}},
+	{action: "stop", permission: database.PermStopServer, endpoints: []string{"POST /servers/{serverName}/stop"}},
+	{action: "restart", permission: database.PermRestartServer, endpoints: []string{
+		"POST /servers/{serverName}/restart", "POST /servers/{serverName}/worlds/{worldName}/activate",
+	}},
+	{action: "delete", permission: database.PermDeleteServer, endpoints: []string{
+		"POST /servers/{serverName}/delete", "POST /servers/{serverName}/rename", "POST /servers/{serverName}/upgrade", "PUT /servers/{serverName}/world",
+		"PUT /servers/{serverName}/worlds/{worldName}", "PUT /servers/{serverName}/whitelist/sync", "PUT /servers/{serverName}/labels",
+		"PUT /servers/{serverName}/scheduling",
+	}},
+	{action: "execCommand", permission: database.PermExecCommand, endpoints: []string{
+		"POST /servers/{serverName}/exec", "GET /servers/{serverName}/world/export",
+		"POST /servers/{serverName}/plugins", "DELETE /servers/{serverName}/plugins/{projectId}",
+		"POST /servers/{serverName}/datapacks", "POST /servers/{serverName}/datapacks/enable", "POST /servers/{serverName}/datapacks/disable",
+	}},
+	{action: "expose", permission: database.PermExposeServer, endpoints: []string{
+		"POST /servers/{serverName}/expose", "POST /proxies/{proxyName}/servers", "DELETE /proxies/{proxyName}/servers/{serverName}",
+	}},
+	// Scheduled tasks are also checked against the permission of their action, the
+	// command one covering console commands, backups and broadcasts
+	{action: "scheduleRestart", permission: database.PermRestartServer, endpoints: []string{
+		"POST /servers/{serverName}/tasks", "PUT /servers/{serverName}/tasks/{taskId}", "DELETE /servers/{serverName}/tasks/{taskId}",
+	}},
+	{action: "scheduleCommand", permission: database.PermExecCommand, endpoints: []string{
+		"POST /servers/{serverName}/tasks", "PUT /servers/{serverName}/tasks/{taskId}", "DELETE /servers/{serverName}/tasks/{taskId}",
+	}},
+	{action: "scheduleCleanup", permission: database.PermDeleteServer, endpoints: []string{
+		"POST /servers/{serverName}/tasks", "PUT /servers/{serverName}/tasks/{taskId}", "DELETE /servers/{serverName}/tasks/{taskId}",
+	}},
+}
+
+// ServerCapabilities lists the actions the current user may perform on a server.
+type ServerCapabilities struct {
+	ServerName string          `json:"serverName" example:"survival"`
+	OwnerID    int64           `json:"ownerId" example:"1"`
+	IsOwner    bool            `json:"isOwner"`
+	Actions    map[string]bool `json:"actions"`
+}
+
+// CapabilitiesResponse is returned by the capabilities endpoint.
+type CapabilitiesResponse struct {
+	UserID      int64                `json:"userId" example:"1"`
+	Username    string               `json:"username" example:"admin"`
+	Permissions int64                `json:"permissions" example:"511"`
+	IsAdmin     bool                 `json:"isAdmin"`
+	Actions     map[string]bool      `json:"actions"`   // Actions that do not act on an existing server
+	Servers     []ServerCapabilities `json:"servers"`   // The servers the user can view
+	Endpoints   map[string][]string  `json:"endpoints"` // Endpoints unlocked by each action
+}
+
+// GetCapabilitiesHandler returns the actions the current user may perform, overall
+// and on each server they can view, so that frontends need not evaluate permissions.
+//
+// @Summary      Get capabilities
+// @Description  Lists the actions the current user may perform, from their permissions and the ownership of the servers: the actions that do not act on a server, and those on each server they can view, along with the endpoints unlocked by each action. The server query parameter restricts the list to one server
+// @Tags         users
+// @Produce      json
+// @Security     BearerAuth
+// @Security     APIKeyAuth
+// @Param        server  query     string                false  "Server name"
+// @Success      200     {object}  CapabilitiesResponse  "Capabilities of the user"
+// @Failure      401     {object}  apierror.Error        "Authentication required"
+// @Failure      404     {object}  apierror.Error        "Server not found"
+// @Failure      500     {object}  apierror.Error        "Server error"
+// @Router       /capabilities [get]
+func GetCapabilitiesHandler(c *gin.Context) {
+	user, ok := auth.RequireCurrentUser(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	db := database.GetDB()
+	var servers []*database.MinecraftServer
+	if name := c.Query("server"); name != "" {
+		server, err := db.GetServerByName(ctx, name)
+		// Servers the user cannot see are reported as not found
+		if err != nil || !auth.HasServerAccess(ctx, user, server, database.PermViewServer) {
+			apierror.Respond(c, apierror.New(http.StatusNotFound, apierror.CodeServerNotFound, "Server not found"))
+			return
+		}
+		servers = []*database.MinecraftServer{server}
+	} else {
+		var err error
+		if servers, err = db.ListServers(ctx); err != nil {
+			apierror.Write(c, http.StatusInternalServerError, "Failed to list servers")
+			return
+		}
+	}
+
+	access, err := auth.LoadServerAccess(ctx, user)
+	if err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to read server memberships")
+		return
+	}
+
+	response := CapabilitiesResponse{
+		UserID:      user.ID,
+		Username:    user.Username,
+		Permissions: user.Permissions,
+		IsAdmin:     user.IsAdmin(),
+		Actions:     map[string]bool{},
+		Servers:     []ServerCapabilities{},
+		Endpoints:   map[string][]string{},
+	}
+	for _, capability := range globalCapabilities {
+		response.Actions[capability.action] = user.HasPermission(capability.permission)
+		response.Endpoints[capability.action] = capability.endpoints
+	}
+	for _, capability := range serverCapabilities {
+		response.Endpoints[capability.action] = capability.endpoints
+	}
+	response.Endpoints["clone"] = []string{"POST /servers/{serverName}/clone"}
+
+	for _, server := range servers {
+		if !access.Has(server.ServerName, server.OwnerID, database.PermViewServer) {
+			continue
+		}
+		actions := map[string]bool{}
+		for _, capability := range serverCapabilities {
+			actions[capability.action] = access.Has(server.ServerName, server.OwnerID, capability.permission)
+		}
+		// Cloning creates a server, which ownership of the source does not grant
+		actions["clone"] = response.Actions["createServer"]
+		response.Servers = append(response.Servers, ServerCapabilities{
+			ServerName: server.ServerName,
+			OwnerID:    server.OwnerID,
+			IsOwner:    server.OwnerID == user.ID,
+			Actions:    actions,
+		})
+	}
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"minecharts/cmd/apierror"
+	"minecharts/cmd/auth"
+	"minecharts/cmd/catalog"
+	"minecharts/cmd/logging"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+// ImportServerTemplateRequest points to a template definition to import, either by
+// its name in the catalog or by its URL and checksum.
+type ImportServerTemplateRequest struct {
+	Catalog string `json:"catalog,omitempty" example:"paper-survival"`
+	URL     string `json:"url,omitempty" example:"https://raw.githubusercontent.com/example/templates/main/paper-survival.json"`
+	SHA256  string `json:"sha256,omitempty" example:"9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"`
+	Name    string `json:"name,omitempty" binding:"max=64" example:"survival"` // Replaces the name of the definition
+}
+
+// ListTemplateCatalogHandler lists the community templates of the catalog.
+//
+// @Summary      List template catalog
+// @Description  Lists the community templates of the configured catalog, by category, that administrators can import
+// @Tags         templates
+// @Produce      json
+// @Security     BearerAuth
+// @Security     APIKeyAuth
+// @Param        category  query     string             false  "Only the templates of this category"
+// @Success      200       {object}  catalog.Index      "Catalog templates"
+// @Failure      401       {object}  apierror.Error     "Authentication required"
+// @Failure      502       {object}  apierror.Error     "Catalog unreachable"
+// @Router       /templates/catalog [get]
+func ListTemplateCatalogHandler(c *gin.Context) {
+	index, err := catalog.Get(c.Request.Context())
+	if err != nil {
+		logging.API.WithContext(c.Request.Context()).WithFields(
+			"error", err.Error(),
+		).Warn("Failed to fetch the template catalog")
+		apierror.Write(c, http.StatusBadGateway, "Failed to fetch the template catalog")
+		return
+	}
+
+	response := *index
+	if category := strings.ToLower(c.Query("category")); category != "" {
+		response.Templates = []catalog.Entry{}
+		for _, entry := range index.Templates {
+			if entry.Category == category {
+				response.Templates = append(response.Templates, entry)
+			}
+		}
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// ImportServerTemplateHandler creates a template from a shared definition (admin only).
+// The definition is a template body as accepted by POST /templates, verified against
+// its SHA-256 checksum before it is validated and stored.
+//
+// @Summary      Import server template
+// @Description  Creates a server template from a definition of the catalog, or from an https URL with the SHA-256 checksum of the definition (admin only)
+// @Tags         templates
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Security     APIKeyAuth
+// @Param        request  body      ImportServerTemplateRequest  true  "Definition to import"
+// @Success      201      {object}  database.ServerTemplate      "Server template imported"
+// @Failure      400      {object}  apierror.Error               "Invalid request or definition"
+// @Failure      401      {object}  apierror.Error               "Authentication required"
+// @Failure      403      {object}  apierror.Error               "Permission denied"
+// @Failure      404      {object}  apierror.Error               "Template not in the catalog"
+// @Failure      409      {object}  apierror.Error               "Template name already exists"
+// @Failure      422      {object}  apierror.Error               "Definition does not match its checksum"
+// @Failure      502      {object}  apierror.Error               "Definition unreachable"
+// @Router       /templates/import [post]
+func ImportServerTemplateHandler(c *gin.Context) {
+	user, ok := auth.RequireCurrentUser(c)
+	if !ok {
+		return
+	}
+
+	var req ImportServerTemplateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Invalid(c, err)
+		return
+	}
+
+	category := ""
+	switch {
+	case req.Catalog != "" && req.URL != "":
+		apierror.Write(c, http.StatusBadRequest, "Set either catalog or url, not both")
+		return
+	case req.Catalog != "":
+		index, err := catalog.Get(c.Request.Context())
+		if errors.Is(err, catalog.ErrDisabled) {
+			apierror.Write(c, http.StatusBadRequest, "No template catalog is configured, import by url instead")
+			return
+		}
+		if err != nil {
+			apierror.Write(c, http.StatusBadGateway, "Failed to fetch the template catalog")
+			return
+		}
+		entry, found := index.Find(req.Catalog)
+		if !found {
+			apierror.Write(c, http.StatusNotFound, "Template not found in the catalog")
+			return
+		}
+		req.URL, req.SHA256, category = entry.URL, entry.SHA256, entry.Category
+	case req.URL == "" || req.SHA256 == "":
+		apierror.Write(c, http.StatusBadRequest, "Set catalog, or url with the sha256 checksum of the definition")
+		return
+	}
+
+	data, err := catalog.FetchDefinition(c.Request.Context(), req.URL, req.SHA256)
+	if err != nil {
+		logging.API.WithContext(c.Request.Context()).WithFields(
+			"url", req.URL,
+			"user_id", user.ID,
+			"error", err.Error(),
+		).Warn("Failed to fetch server template definition")
+		switch {
+		case errors.Is(err, catalog.ErrChecksumMismatch):
+			apierror.Write(c, http.StatusUnprocessableEntity, err.Error())
+		case errors.Is(err, catalog.ErrInvalidURL), errors.Is(err, catalog.ErrInvalidChecksum):
+			apierror.Write(c, http.StatusBadRequest, err.Error())
+		default:
+			apierror.Write(c, http.StatusBadGateway, "Failed to fetch the template definition")
+		}
+		return
+	}
+
+	var definition ServerTemplateRequest
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&definition); err != nil {
+		apierror.Write(c, http.StatusBadRequest, "Invalid template definition: "+err.Error())
+		return
+	}
+	if req.Name != "" {
+		definition.Name = req.Name
+	}
+	if definition.Category == "" {
+		definition.Category = category
+	}
+	if err := binding.Validator.ValidateStruct(&definition); err != nil {
+		apierror.Write(c, http.StatusBadRequest, "Invalid template definition: "+err.Error())
+		return
+	}
+	if err := validateServerTemplateRequest(&definition); err != nil {
+		apierror.Write(c, http.StatusBadRequest, "Invalid template definition: "+err.Error())
+		return
+	}
+
+	createServerTemplate(c, user, definition, req.URL)
+}
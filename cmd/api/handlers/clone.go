@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"minecharts/cmd/apierror"
+	"minecharts/cmd/auth"
+	"minecharts/cmd/config"
+	"minecharts/cmd/database"
+	"minecharts/cmd/jobs"
+	"minecharts/cmd/kubernetes"
+	"minecharts/cmd/logging"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CloneServerRequest represents the request to clone a Minecraft server.
+type CloneServerRequest struct {
+	Target string `json:"target" binding:"required" example:"survival-test"`
+}
+
+// CloneServerHandler creates a copy of a server, with its spec and world, under a new name.
+// The data is copied by a job in the background: the clone is reported as creating
+// until the copy completes and its deployment starts, and the job reports its progress.
+//
+// @Summary      Clone Minecraft server
+// @Description  Creates a new server named target with the spec of the source server and a copy of its data. The world of a running source is saved before the copy starts. The copy runs in the background as a job, follow it with the job URL of the response or the status endpoint of the target
+// @Tags         servers
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Security     APIKeyAuth
+// @Param        serverName  path      string                  true  "Source server name"
+// @Param        request     body      CloneServerRequest      true  "Target server name"
+// @Success      202         {object}  map[string]interface{}  "Clone started, with its job ID"
+// @Failure      400         {object}  apierror.Error          "Invalid request"
+// @Failure      401         {object}  apierror.Error          "Authentication required"
+// @Failure      403         {object}  apierror.Error          "Permission denied or quota exceeded"
+// @Failure      404         {object}  apierror.Error          "Server not found"
+// @Failure      409         {object}  apierror.Error          "Target name already taken"
+// @Failure      500         {object}  apierror.Error          "Server error"
+// @Router       /servers/{serverName}/clone [post]
+func CloneServerHandler(c *gin.Context) {
+	var req CloneServerRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Invalid(c, err)
+		return
+	}
+
+	serverName := c.Param("serverName")
+	if err := validateServerName(req.Target); err != nil {
+		apierror.Write(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	user, ok := auth.RequireCurrentUser(c)
+	if !ok {
+		return
+	}
+	if config.RequireServerApproval && !user.IsAdmin() {
+		apierror.Write(c, http.StatusForbidden, "Server creation requires approval, clones can only be made by an administrator")
+		return
+	}
+
+	ctx := c.Request.Context()
+	db := database.GetDB()
+	source, err := db.GetServerByName(ctx, serverName)
+	if err != nil {
+		apierror.Respond(c, apierror.New(http.StatusNotFound, apierror.CodeServerNotFound, "Server not found"))
+		return
+	}
+	if _, err := db.GetServerByName(ctx, req.Target); err == nil {
+		apierror.Write(c, http.StatusConflict, "A server with this name already exists")
+		return
+	}
+
+	if !enforceQuota(c, user.ID, source.Spec) {
+		return
+	}
+
+	// The volume is copied by a job mounting both claims, which must share a namespace
+	if source.Namespace != kubernetes.TenantNamespace(user.ID) {
+		apierror.Write(c, http.StatusBadRequest, "The source server is in the namespace of another user and cannot be cloned")
+		return
+	}
+
+	namespace := kubernetes.ServerNamespace(source)
+	deployment, ok := kubernetes.CheckDeploymentExists(c, namespace, source.DeploymentName)
+	if !ok {
+		return
+	}
+
+	// Flush the world of a running source to its volume before it is copied
+	if deployment.Status.ReadyReplicas > 0 {
+		pod, err := kubernetes.GetMinecraftPod(ctx, namespace, source.DeploymentName)
+		if err != nil || pod == nil {
+			apierror.Write(c, http.StatusInternalServerError, "Failed to find pod for deployment: "+source.DeploymentName)
+			return
+		}
+		if _, _, err := kubernetes.SaveWorld(ctx, pod.Name, namespace); err != nil {
+			apierror.Write(c, http.StatusInternalServerError, "Failed to save world: "+err.Error())
+			return
+		}
+	}
+
+	logging.Server.WithContext(ctx).WithFields(
+		"server_name", req.Target,
+		"source", serverName,
+		"user_id", user.ID,
+		"username", user.Username,
+	).Info("Cloning Minecraft server")
+
+	target, exceeded := recordNewServer(ctx, StartMinecraftServerRequest{ServerName: req.Target, ServerSpec: source.Spec}, user.ID)
+	if exceeded != nil {
+		apierror.Respond(c, exceeded)
+		return
+	}
+
+	job := &database.Job{
+		Type:       database.JobTypeClone,
+		ServerName: target.ServerName,
+		OwnerID:    user.ID,
+		CreatedBy:  user.ID,
+	}
+	err = jobs.Start(ctx, job, config.CloneTimeout, func(ctx context.Context, progress jobs.Progress) (string, error) {
+		populate := func(ctx context.Context, pvcName string) error {
+			progress(10, "Copying data from "+source.ServerName)
+			stop := keepCreating(ctx, target.ServerName, "Copying data from "+source.ServerName)
+			defer stop()
+			if err := kubernetes.CopyVolume(ctx, namespace, source.DeploymentName, source.PVCName, target.DeploymentName, pvcName); err != nil {
+				return err
+			}
+			progress(90, "Creating the deployment")
+			return nil
+		}
+		// Also recorded as the failure reason of the clone
+		if err := createServerResources(ctx, target, source.Spec, populate); err != nil {
+			return "", err
+		}
+		logging.Server.Cloned.WithContext(ctx).WithFields(
+			"server_name", target.ServerName,
+			"source", source.ServerName,
+			"deployment", target.DeploymentName,
+		).Info("Minecraft server cloned successfully")
+		return "Server cloned from " + source.ServerName, nil
+	})
+	if err != nil {
+		recordProvisioningFailure(ctx, target.ServerName, "Failed to start clone job: "+err.Error())
+		jobStartFailed(c, err, "Failed to start clone job")
+		return
+	}
+
+	acceptJob(c, job, gin.H{
+		"message":        "Server clone started",
+		"serverName":     target.ServerName,
+		"source":         source.ServerName,
+		"deploymentName": target.DeploymentName,
+		"pvcName":        target.PVCName,
+	})
+}
+
+// keepCreating records a server as creating with the given reason until stop is
+// called. The record is refreshed regularly as the reconciler fails servers left
+// without a deployment for too long.
+func keepCreating(ctx context.Context, serverName, reason string) (stop func()) {
+	update := func() {
+		if err := database.GetDB().UpdateServerStatus(ctx, serverName, database.ServerStatusCreating, reason); err != nil {
+			logging.DB.WithContext(ctx).WithFields(
+				"server_name", serverName,
+				"error", err.Error(),
+			).Warn("Failed to refresh server status")
+		}
+	}
+	update()
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(30 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				update()
+			}
+		}
+	}()
+	return func() { close(done) }
+}
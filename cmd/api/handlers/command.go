@@ -1,9 +1,13 @@
 package handlers
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"net/http"
 	"time"
 
+	"minecharts/cmd/apierror"
 	"minecharts/cmd/auth"
 	"minecharts/cmd/config"
 	"minecharts/cmd/database"
@@ -11,20 +15,26 @@ import (
 	"minecharts/cmd/logging"
 
 	"github.com/gin-gonic/gin"
-
-	corev1 "k8s.io/api/core/v1"
 )
 
 // StartMinecraftServerRequest represents the request to create a Minecraft server.
+// The typed spec fields are validated and mapped to the image's environment
+// variables; env only carries additional variables not covered by the spec.
+// When templateId is set, the spec fields override the template's, and parameters
+// fill in the parameters the template declares.
 type StartMinecraftServerRequest struct {
 	ServerName string            `json:"serverName" binding:"required" example:"survival"`
-	Env        map[string]string `json:"env" example:"{\"DIFFICULTY\":\"normal\",\"MODE\":\"survival\",\"MEMORY\":\"4G\"}"`
+	TemplateID int64             `json:"templateId,omitempty" example:"1"`
+	Parameters map[string]string `json:"parameters,omitempty" example:"{\"MEMORY\":\"6G\"}"`
+	database.ServerSpec
 }
 
 // StartMinecraftServerHandler creates the PVC and starts the Minecraft deployment.
+// When server approval is required, requests from non-admin users are stored
+// as pending server requests instead.
 //
 // @Summary      Create Minecraft server
-// @Description  Creates a new Minecraft server with the specified configuration
+// @Description  Creates a new Minecraft server with the specified configuration, or submits it for approval when approval mode is enabled
 // @Tags         servers
 // @Accept       json
 // @Produce      json
@@ -32,236 +42,439 @@ type StartMinecraftServerRequest struct {
 // @Security     APIKeyAuth
 // @Param        request  body      StartMinecraftServerRequest  true  "Server configuration"
 // @Success      200      {object}  map[string]string           "Server created successfully"
-// @Failure      400      {object}  map[string]string           "Invalid request"
-// @Failure      401      {object}  map[string]string           "Authentication required"
-// @Failure      403      {object}  map[string]string           "Permission denied"
-// @Failure      500      {object}  map[string]string           "Server error"
+// @Success      202      {object}  map[string]interface{}      "Server request submitted for approval"
+// @Failure      400      {object}  apierror.Error              "Invalid request"
+// @Failure      401      {object}  apierror.Error              "Authentication required"
+// @Failure      403      {object}  apierror.Error              "Permission denied or quota exceeded"
+// @Failure      409      {object}  apierror.Error              "A request for this server is already pending"
+// @Failure      500      {object}  apierror.Error              "Server error"
 // @Router       /servers [post]
 func StartMinecraftServerHandler(c *gin.Context) {
 	var req StartMinecraftServerRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		logging.API.InvalidRequest.WithFields(
+		logging.API.InvalidRequest.WithContext(c.Request.Context()).WithFields(
 			"error", err.Error(),
 			"remote_ip", c.ClientIP(),
 		).Warn("Invalid server creation request format")
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		apierror.Write(c, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	// Get current user for logging
-	user, _ := auth.GetCurrentUser(c)
-	userID := int64(0)
-	username := "unknown"
-	if user != nil {
-		userID = user.ID
-		username = user.Username
+	if err := validateServerName(req.ServerName); err != nil {
+		apierror.Write(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if req.TemplateID != 0 {
+		template, err := database.GetDB().GetServerTemplate(c.Request.Context(), req.TemplateID)
+		if err != nil {
+			if errors.Is(err, database.ErrTemplateNotFound) {
+				apierror.Write(c, http.StatusBadRequest, "Server template not found")
+			} else {
+				apierror.Write(c, http.StatusInternalServerError, "Failed to get server template")
+			}
+			return
+		}
+		values, err := resolveTemplateParameters(template.Parameters, req.Parameters)
+		if err != nil {
+			apierror.Write(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		req.ServerSpec = mergeServerSpec(renderTemplateSpec(template.Spec, values), req.ServerSpec)
+	} else if len(req.Parameters) > 0 {
+		apierror.Write(c, http.StatusBadRequest, "parameters require a templateId")
+		return
+	}
+	if err := normalizeServerSpec(&req.ServerSpec); err != nil {
+		logging.API.InvalidRequest.WithContext(c.Request.Context()).WithFields(
+			"server_name", req.ServerName,
+			"error", err.Error(),
+			"remote_ip", c.ClientIP(),
+		).Warn("Invalid server spec")
+		apierror.Write(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	memoryWarning, err := checkMemoryHeadroom(&req.ServerSpec)
+	if err != nil {
+		apierror.Write(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if memoryWarning != "" {
+		logging.Server.WithContext(c.Request.Context()).WithFields(
+			"server_name", req.ServerName,
+			"memory", req.Memory,
+			"memory_limit", req.Resources.MemoryLimit,
+			"warning", memoryWarning,
+		).Warn("JVM heap too close to the container memory limit")
+	}
+
+	// Get current user
+	user, ok := auth.RequireCurrentUser(c)
+	if !ok {
+		return
+	}
+
+	if !enforceQuota(c, user.ID, req.ServerSpec) {
+		return
 	}
 
-	baseName := req.ServerName
-	deploymentName := config.DeploymentPrefix + baseName
+	if config.RequireServerApproval && !user.IsAdmin() {
+		submitServerRequest(c, user, req)
+		return
+	}
+
+	logging.Server.WithContext(c.Request.Context()).WithFields(
+		"server_name", req.ServerName,
+		"template_id", req.TemplateID,
+		"user_id", user.ID,
+		"username", user.Username,
+	).Info("Creating new Minecraft server")
+
+	deploymentName, pvcName, err := provisionMinecraftServer(c.Request.Context(), req, user.ID)
+	if err != nil {
+		var exceeded *apierror.Error
+		if errors.As(err, &exceeded) {
+			apierror.Respond(c, exceeded)
+			return
+		}
+		apierror.Write(c, http.StatusInternalServerError, "Failed to create server: "+err.Error())
+		return
+	}
+
+	logging.Server.Created.WithContext(c.Request.Context()).WithFields(
+		"server_name", req.ServerName,
+		"deployment", deploymentName,
+		"pvc", pvcName,
+		"user_id", user.ID,
+		"username", user.Username,
+	).Info("Minecraft server created successfully")
+
+	response := gin.H{"message": "Minecraft server started", "deploymentName": deploymentName, "pvcName": pvcName}
+	if memoryWarning != "" {
+		response["warning"] = memoryWarning
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// provisionMinecraftServer creates the PVC and deployment for a server and
+// records it in the database with the given owner. It returns the *apierror.Error of
+// recordNewServer when the server would exceed the quota of the owner.
+func provisionMinecraftServer(ctx context.Context, req StartMinecraftServerRequest, ownerID int64) (string, string, error) {
+	server, exceeded := recordNewServer(ctx, req, ownerID)
+	if exceeded != nil {
+		return "", "", exceeded
+	}
+	if err := createServerResources(ctx, server, req.ServerSpec, nil); err != nil {
+		return "", "", err
+	}
+	return server.DeploymentName, server.PVCName, nil
+}
+
+// recordNewServer records a server being created, so its provisioning state can
+// be followed, and returns the record holding its resource names. The quota of the
+// owner is checked in the transaction of the record, so concurrent creations cannot
+// exceed it together, and the 403 error is returned when it would be.
+func recordNewServer(ctx context.Context, req StartMinecraftServerRequest, ownerID int64) (*database.MinecraftServer, *apierror.Error) {
+	deploymentName := config.DeploymentPrefix + req.ServerName
+	// The claim of a StatefulSet is named after its template and pod
 	pvcName := deploymentName + config.PVCSuffix
+	if serverWorkload(req.ServerSpec) == kubernetes.StatefulSetWorkload {
+		pvcName = kubernetes.StatefulClaimName(deploymentName)
+	}
 
-	logging.Server.WithFields(
-		"server_name", baseName,
+	logging.Server.WithContext(ctx).WithFields(
+		"server_name", req.ServerName,
 		"deployment", deploymentName,
 		"pvc", pvcName,
-		"user_id", userID,
-		"username", username,
-	).Info("Creating new Minecraft server")
+		"owner_id", ownerID,
+	).Debug("Provisioning Minecraft server resources")
 
-	// Creates the PVC if it doesn't already exist.
-	if err := kubernetes.EnsurePVC(config.DefaultNamespace, pvcName); err != nil {
-		logging.Server.WithFields(
+	server := &database.MinecraftServer{
+		ServerName:     req.ServerName,
+		DeploymentName: deploymentName,
+		PVCName:        pvcName,
+		OwnerID:        ownerID,
+		Namespace:      kubernetes.TenantNamespace(ownerID),
+		CreatedAt:      time.Now(),
+		UpdatedAt:      time.Now(),
+		Status:         database.ServerStatusCreating,
+		Spec:           persistedSpec(req.ServerSpec),
+	}
+	var exceeded *apierror.Error
+	err := database.GetDB().WithTx(ctx, func(tx database.DB) error {
+		var err error
+		exceeded, err = checkQuota(ctx, tx, ownerID, req.ServerSpec)
+		if err != nil || exceeded != nil {
+			return err
+		}
+		return tx.CreateServerRecord(ctx, server)
+	})
+	if exceeded != nil {
+		return nil, exceeded
+	}
+	if err != nil {
+		// Log the error but don't fail the request, the Kubernetes resources are the source of truth
+		logging.DB.WithContext(ctx).WithFields(
+			"server_name", req.ServerName,
+			"owner_id", ownerID,
+			"error", err.Error(),
+		).Error("Failed to record server in database")
+	}
+	return server, nil
+}
+
+// createServerResources creates the storage and deployment of a recorded server.
+// When populate is set, it fills the new volume before the deployment is created.
+func createServerResources(ctx context.Context, server *database.MinecraftServer, spec database.ServerSpec, populate func(ctx context.Context, pvcName string) error) error {
+	baseName, deploymentName, pvcName, ownerID := server.ServerName, server.DeploymentName, server.PVCName, server.OwnerID
+
+	// The namespace of the user, with tenant namespaces, is set up before their first server
+	namespace := kubernetes.ServerNamespace(server)
+	if server.Namespace != "" {
+		if err := kubernetes.EnsureTenantNamespace(ctx, namespace, ownerID); err != nil {
+			recordProvisioningFailure(ctx, baseName, "Failed to set up namespace: "+err.Error())
+			return err
+		}
+	}
+
+	// Creates the storage (a PVC unless another driver is configured) if it doesn't already exist.
+	if err := kubernetes.EnsureStorage(ctx, namespace, pvcName, spec.StorageSize, spec.StorageClass); err != nil {
+		logging.Server.WithContext(ctx).WithFields(
 			"server_name", baseName,
 			"pvc", pvcName,
-			"user_id", userID,
+			"owner_id", ownerID,
 			"error", err.Error(),
-		).Error("Failed to ensure PVC")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to ensure PVC: " + err.Error()})
-		return
+		).Error("Failed to ensure storage")
+		recordProvisioningFailure(ctx, baseName, "Failed to create volume: "+err.Error())
+		return fmt.Errorf("failed to ensure storage: %w", err)
 	}
 
-	logging.Server.WithFields(
+	logging.Server.WithContext(ctx).WithFields(
 		"server_name", baseName,
 		"pvc", pvcName,
-	).Debug("PVC ensured")
-
-	// Prepares default environment variables.
-	envVars := []corev1.EnvVar{
-		{
-			Name:  "EULA",
-			Value: "TRUE",
-		},
-		{
-			Name:  "CREATE_CONSOLE_IN_PIPE",
-			Value: "true",
-		},
-	}
-	// Adds additional environment variables provided in the request.
-	for key, value := range req.Env {
-		envVars = append(envVars, corev1.EnvVar{
-			Name:  key,
-			Value: value,
-		})
+	).Debug("Storage ensured")
+
+	if populate != nil {
+		if err := populate(ctx, pvcName); err != nil {
+			logging.Server.WithContext(ctx).WithFields(
+				"server_name", baseName,
+				"pvc", pvcName,
+				"error", err.Error(),
+			).Error("Failed to populate storage")
+			recordProvisioningFailure(ctx, baseName, "Failed to populate volume: "+err.Error())
+			return fmt.Errorf("failed to populate storage: %w", err)
+		}
+	}
+
+	// Maps the spec to environment variables, whitelisting the linked players who can view the server.
+	spec.Env = withLinkedWhitelist(ctx, spec.Env, server)
+	envVars := serverEnvVars(spec)
+
+	if rconEnabled(spec.Env) {
+		passwordEnv, err := setupRCONPassword(ctx, namespace, deploymentName, spec.Env["RCON_PASSWORD"])
+		if err != nil {
+			logging.Server.WithContext(ctx).WithFields(
+				"server_name", baseName,
+				"deployment", deploymentName,
+				"error", err.Error(),
+			).Error("Failed to set up RCON password")
+			recordProvisioningFailure(ctx, baseName, "Failed to set up RCON password: "+err.Error())
+			return err
+		}
+		envVars = append(envVars, passwordEnv)
+	}
+
+	// The pods are restricted from the start, the expose endpoint opens the game ports
+	if config.NetworkPolicies {
+		if err := kubernetes.ApplyNetworkPolicy(ctx, namespace, deploymentName, serverPolicy(spec, "")); err != nil {
+			recordProvisioningFailure(ctx, baseName, "Failed to create network policy: "+err.Error())
+			return err
+		}
+	}
+
+	if spec.DisruptionBudget {
+		if err := kubernetes.EnsureDisruptionBudget(ctx, namespace, deploymentName); err != nil {
+			recordProvisioningFailure(ctx, baseName, "Failed to create disruption budget: "+err.Error())
+			return err
+		}
 	}
 
 	// Creates the deployment with the existing PVC (created if necessary).
-	if err := kubernetes.CreateDeployment(config.DefaultNamespace, deploymentName, pvcName, envVars); err != nil {
-		logging.Server.WithFields(
+	resources := serverResourceRequirements(spec)
+	if err := kubernetes.CreateDeployment(ctx, namespace, deploymentName, pvcName, serverEdition(spec), envVars, resources, spec.Labels, spec.PriorityClass, serverPlacement(spec), serverWorkload(spec)); err != nil {
+		logging.Server.WithContext(ctx).WithFields(
 			"server_name", baseName,
 			"deployment", deploymentName,
 			"pvc", pvcName,
-			"user_id", userID,
+			"owner_id", ownerID,
 			"error", err.Error(),
 		).Error("Failed to create deployment")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create deployment: " + err.Error()})
-		return
-	}
-
-	// After successful deployment creation, record the server in database
-	server := &database.MinecraftServer{
-		ServerName:     baseName,
-		DeploymentName: deploymentName,
-		PVCName:        pvcName,
-		OwnerID:        userID,
-		CreatedAt:      time.Now(),
-		UpdatedAt:      time.Now(),
-		Status:         "running",
+		recordProvisioningFailure(ctx, baseName, "Failed to create deployment: "+err.Error())
+		return fmt.Errorf("failed to create deployment: %w", err)
+	}
+	if len(spec.Labels) > 0 {
+		// The deployment and its pods are created with the labels, the storage gets them here
+		if err := kubernetes.SetServerLabels(ctx, namespace, deploymentName, pvcName, spec.Labels); err != nil {
+			logging.Server.WithContext(ctx).WithFields(
+				"server_name", baseName,
+				"error", err.Error(),
+			).Warn("Failed to label server storage")
+		}
 	}
 
-	db := database.GetDB()
-	if err := db.CreateServerRecord(c.Request.Context(), server); err != nil {
-		// Log the error but don't fail the request since the server is already created in K8s
-		logging.DB.WithFields(
+	// The server watcher moves the server to running once its pod is ready
+	if err := database.GetDB().UpdateServerStatus(ctx, baseName, database.ServerStatusStarting, ""); err != nil {
+		logging.DB.WithContext(ctx).WithFields(
 			"server_name", baseName,
-			"user_id", userID,
 			"error", err.Error(),
-		).Error("Failed to record server in database")
+		).Warn("Failed to record server as starting")
 	}
 
-	logging.Server.WithFields(
-		"server_name", baseName,
-		"deployment", deploymentName,
-		"pvc", pvcName,
-		"user_id", userID,
-		"username", username,
-	).Info("Minecraft server created successfully")
+	return nil
+}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Minecraft server started", "deploymentName": deploymentName, "pvcName": pvcName})
+// recordProvisioningFailure marks a server as failed with the reason its resources could not be created.
+func recordProvisioningFailure(ctx context.Context, serverName, reason string) {
+	// The request may have failed because its context timed out, the failure is recorded regardless
+	if err := database.GetDB().UpdateServerStatus(context.WithoutCancel(ctx), serverName, database.ServerStatusFailed, reason); err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"server_name", serverName,
+			"error", err.Error(),
+		).Warn("Failed to record server provisioning failure")
+	}
 }
 
 // RestartMinecraftServerHandler saves the world and then restarts the deployment.
 //
 // @Summary      Restart Minecraft server
-// @Description  Saves the world and restarts the Minecraft server
+// @Description  Saves the world and restarts the Minecraft server. With the wait-for-ready strategy, the response is sent once the new pod is ready, and the deployment is rolled back to its previous pod template if it is not ready within the rollout timeout
 // @Tags         servers
 // @Produce      json
 // @Security     BearerAuth
 // @Security     APIKeyAuth
-// @Param        serverName  path      string  true  "Server name"
-// @Success      200         {object}  map[string]interface{}  "Server restarting"
-// @Failure      401         {object}  map[string]string       "Authentication required"
-// @Failure      403         {object}  map[string]string       "Permission denied"
-// @Failure      404         {object}  map[string]string       "Server not found"
-// @Failure      500         {object}  map[string]string       "Server error"
+// @Param        serverName  path      string  true   "Server name"
+// @Param        strategy    query     string  false  "recreate or wait-for-ready (default from MINECHARTS_ROLLOUT_STRATEGY)"
+// @Success      200         {object}  map[string]interface{}  "Server restarting, or restarted with wait-for-ready"
+// @Failure      400         {object}  apierror.Error          "Invalid strategy"
+// @Failure      401         {object}  apierror.Error          "Authentication required"
+// @Failure      403         {object}  apierror.Error          "Permission denied"
+// @Failure      404         {object}  apierror.Error          "Server not found"
+// @Failure      500         {object}  apierror.Error          "Server error, or rollout aborted with wait-for-ready"
 // @Router       /servers/{serverName}/restart [post]
 func RestartMinecraftServerHandler(c *gin.Context) {
 	deploymentName, _ := kubernetes.GetServerInfo(c)
 
-	// Get current user for logging
-	user, _ := auth.GetCurrentUser(c)
-	userID := int64(0)
-	username := "unknown"
-	if user != nil {
-		userID = user.ID
-		username = user.Username
+	strategy := c.DefaultQuery("strategy", config.RolloutStrategy)
+	if strategy != kubernetes.RolloutRecreate && strategy != kubernetes.RolloutWaitForReady {
+		apierror.Write(c, http.StatusBadRequest, "strategy must be recreate or wait-for-ready")
+		return
+	}
+
+	// Get current user
+	user, ok := auth.RequireCurrentUser(c)
+	if !ok {
+		return
 	}
 
 	serverName := c.Param("serverName")
 
-	logging.Server.WithFields(
+	logging.Server.WithContext(c.Request.Context()).WithFields(
 		"server_name", serverName,
 		"deployment", deploymentName,
-		"user_id", userID,
-		"username", username,
+		"user_id", user.ID,
+		"username", user.Username,
 		"remote_ip", c.ClientIP(),
 	).Info("Restarting Minecraft server")
 
+	namespace := serverNamespace(c)
 	// Check if the deployment exists
-	_, ok := kubernetes.CheckDeploymentExists(c, config.DefaultNamespace, deploymentName)
+	deployment, ok := kubernetes.CheckDeploymentExists(c, namespace, deploymentName)
 	if !ok {
-		logging.Server.WithFields(
+		logging.Server.WithContext(c.Request.Context()).WithFields(
 			"server_name", serverName,
 			"deployment", deploymentName,
 		).Warn("Deployment not found for restart")
 		return
 	}
+	previous := deployment.Spec.Template.DeepCopy()
 
 	// Get the pod associated with this deployment to run the save command
-	pod, err := kubernetes.GetMinecraftPod(config.DefaultNamespace, deploymentName)
+	pod, err := kubernetes.GetMinecraftPod(c.Request.Context(), namespace, deploymentName)
 	if err != nil || pod == nil {
-		logging.Server.WithFields(
+		logging.Server.WithContext(c.Request.Context()).WithFields(
 			"server_name", serverName,
 			"deployment", deploymentName,
 			"error", err,
 		).Error("Failed to find pod for deployment")
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to find pod for deployment: " + deploymentName,
-		})
+		apierror.Write(c, http.StatusInternalServerError, "Failed to find pod for deployment: "+deploymentName)
 		return
 	}
 
-	logging.Server.WithFields(
+	logging.Server.WithContext(c.Request.Context()).WithFields(
 		"server_name", serverName,
 		"pod", pod.Name,
 	).Debug("Found pod for server restart")
 
 	// Save the world
-	stdout, stderr, err := kubernetes.SaveWorld(pod.Name, config.DefaultNamespace)
+	stdout, stderr, err := kubernetes.SaveWorld(c.Request.Context(), pod.Name, namespace)
 	if err != nil {
-		logging.Server.WithFields(
+		logging.Server.WithContext(c.Request.Context()).WithFields(
 			"server_name", serverName,
 			"pod", pod.Name,
 			"error", err.Error(),
 		).Error("Failed to save world before restart")
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error":          "Failed to save world: " + err.Error(),
-			"deploymentName": deploymentName,
-		})
+		apierror.Respond(c, apierror.New(http.StatusInternalServerError, "", "Failed to save world: "+err.Error()).
+			With("deploymentName", deploymentName))
 		return
 	}
 
-	logging.Server.WithFields(
+	logging.Server.WithContext(c.Request.Context()).WithFields(
 		"server_name", serverName,
 		"pod", pod.Name,
 	).Debug("World saved successfully before restart")
 
 	// Restart the deployment
-	if err := kubernetes.RestartDeployment(config.DefaultNamespace, deploymentName); err != nil {
-		logging.Server.WithFields(
+	if err := kubernetes.RestartDeployment(c.Request.Context(), namespace, deploymentName); err != nil {
+		logging.Server.WithContext(c.Request.Context()).WithFields(
 			"server_name", serverName,
 			"deployment", deploymentName,
 			"error", err.Error(),
 		).Error("Failed to restart deployment")
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error":          "Failed to restart deployment: " + err.Error(),
-			"deploymentName": deploymentName,
-		})
+		apierror.Respond(c, apierror.New(http.StatusInternalServerError, "", "Failed to restart deployment: "+err.Error()).
+			With("deploymentName", deploymentName))
 		return
 	}
 
-	logging.Server.WithFields(
-		"server_name", serverName,
-		"deployment", deploymentName,
-		"user_id", userID,
-		"username", username,
-	).Info("Minecraft server restarted successfully")
-
 	response := gin.H{
 		"message":        "Minecraft server restarting",
 		"deploymentName": deploymentName,
+		"strategy":       strategy,
+	}
+
+	if strategy == kubernetes.RolloutWaitForReady {
+		rollout, err := kubernetes.WaitForRollout(c.Request.Context(), namespace, deploymentName, previous, config.RolloutTimeout)
+		if err != nil {
+			logging.Server.WithContext(c.Request.Context()).WithFields(
+				"server_name", serverName,
+				"deployment", deploymentName,
+				"error", err.Error(),
+			).Error("Restart rollout did not complete")
+			apierror.Respond(c, apierror.New(http.StatusInternalServerError, "", "Restart did not complete: "+err.Error()).
+				With("deploymentName", deploymentName).
+				With("rollout", rollout))
+			return
+		}
+		response["message"] = "Minecraft server restarted"
+		response["rollout"] = rollout
 	}
 
+	logging.Server.Restarted.WithContext(c.Request.Context()).WithFields(
+		"server_name", serverName,
+		"deployment", deploymentName,
+		"strategy", strategy,
+		"user_id", user.ID,
+		"username", user.Username,
+	).Info("Minecraft server restarted successfully")
+
 	if stdout != "" || stderr != "" {
 		response["save_stdout"] = stdout
 		response["save_stderr"] = stderr
@@ -280,37 +493,35 @@ func RestartMinecraftServerHandler(c *gin.Context) {
 // @Security     APIKeyAuth
 // @Param        serverName  path      string  true  "Server name"
 // @Success      200         {object}  map[string]string  "Server stopped"
-// @Failure      401         {object}  map[string]string  "Authentication required"
-// @Failure      403         {object}  map[string]string  "Permission denied"
-// @Failure      404         {object}  map[string]string  "Server not found"
-// @Failure      500         {object}  map[string]string  "Server error"
+// @Failure      401         {object}  apierror.Error     "Authentication required"
+// @Failure      403         {object}  apierror.Error     "Permission denied"
+// @Failure      404         {object}  apierror.Error     "Server not found"
+// @Failure      500         {object}  apierror.Error     "Server error"
 // @Router       /servers/{serverName}/stop [post]
 func StopMinecraftServerHandler(c *gin.Context) {
 	deploymentName, _ := kubernetes.GetServerInfo(c)
 
-	// Get current user for logging
-	user, _ := auth.GetCurrentUser(c)
-	userID := int64(0)
-	username := "unknown"
-	if user != nil {
-		userID = user.ID
-		username = user.Username
+	// Get current user
+	user, ok := auth.RequireCurrentUser(c)
+	if !ok {
+		return
 	}
 
 	serverName := c.Param("serverName")
 
-	logging.Server.WithFields(
+	logging.Server.WithContext(c.Request.Context()).WithFields(
 		"server_name", serverName,
 		"deployment", deploymentName,
-		"user_id", userID,
-		"username", username,
+		"user_id", user.ID,
+		"username", user.Username,
 		"remote_ip", c.ClientIP(),
 	).Info("Stopping Minecraft server")
 
+	namespace := serverNamespace(c)
 	// Check if the deployment exists
-	_, ok := kubernetes.CheckDeploymentExists(c, config.DefaultNamespace, deploymentName)
+	_, ok = kubernetes.CheckDeploymentExists(c, namespace, deploymentName)
 	if !ok {
-		logging.Server.WithFields(
+		logging.Server.WithContext(c.Request.Context()).WithFields(
 			"server_name", serverName,
 			"deployment", deploymentName,
 		).Warn("Deployment not found for stop operation")
@@ -318,141 +529,157 @@ func StopMinecraftServerHandler(c *gin.Context) {
 	}
 
 	// Get the pod associated with this deployment to run the save command
-	pod, err := kubernetes.GetMinecraftPod(config.DefaultNamespace, deploymentName)
+	pod, err := kubernetes.GetMinecraftPod(c.Request.Context(), namespace, deploymentName)
 	if err != nil {
-		logging.Server.WithFields(
+		logging.Server.WithContext(c.Request.Context()).WithFields(
 			"server_name", serverName,
 			"deployment", deploymentName,
 			"error", err.Error(),
 		).Error("Failed to find pod for deployment")
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to find pod for deployment: " + deploymentName,
-		})
+		apierror.Write(c, http.StatusInternalServerError, "Failed to find pod for deployment: "+deploymentName)
 		return
 	}
 
 	if pod != nil {
-		logging.Server.WithFields(
+		logging.Server.WithContext(c.Request.Context()).WithFields(
 			"server_name", serverName,
 			"pod", pod.Name,
 		).Debug("Saving world before stopping server")
 		// Save the world before scaling down
-		_, _, err := kubernetes.ExecuteCommandInPod(pod.Name, config.DefaultNamespace, "minecraft-server", "mc-send-to-console save-all")
+		_, _, err := kubernetes.ExecuteCommandInPod(c.Request.Context(), pod.Name, namespace, "minecraft-server", "mc-send-to-console save-all")
 		if err != nil {
-			logging.Server.WithFields(
+			logging.Server.WithContext(c.Request.Context()).WithFields(
 				"server_name", serverName,
 				"pod", pod.Name,
 				"error", err.Error(),
 			).Error("Failed to save world before stopping")
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error":          "Failed to save world: " + err.Error(),
-				"deploymentName": deploymentName,
-			})
+			apierror.Respond(c, apierror.New(http.StatusInternalServerError, "", "Failed to save world: "+err.Error()).
+				With("deploymentName", deploymentName))
 			return
 		}
-		logging.Server.WithFields(
+		logging.Server.WithContext(c.Request.Context()).WithFields(
 			"server_name", serverName,
 			"pod", pod.Name,
 		).Debug("World saved successfully before stopping")
 	}
 
 	// Scale deployment to 0
-	if err := kubernetes.SetDeploymentReplicas(config.DefaultNamespace, deploymentName, 0); err != nil {
-		logging.Server.WithFields(
+	if err := kubernetes.SetDeploymentReplicas(c.Request.Context(), namespace, deploymentName, 0); err != nil {
+		logging.Server.WithContext(c.Request.Context()).WithFields(
 			"server_name", serverName,
 			"deployment", deploymentName,
 			"error", err.Error(),
 		).Error("Failed to scale deployment to 0")
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error":          "Failed to scale deployment: " + err.Error(),
-			"deploymentName": deploymentName,
-		})
+		apierror.Respond(c, apierror.New(http.StatusInternalServerError, "", "Failed to scale deployment: "+err.Error()).
+			With("deploymentName", deploymentName))
 		return
 	}
 
-	logging.Server.WithFields(
+	logging.Server.Stopped.WithContext(c.Request.Context()).WithFields(
 		"server_name", serverName,
 		"deployment", deploymentName,
-		"user_id", userID,
-		"username", username,
+		"user_id", user.ID,
+		"username", user.Username,
 	).Info("Minecraft server stopped successfully")
 
+	if err := database.GetDB().UpdateServerStatus(c.Request.Context(), serverName, database.ServerStatusStopped, ""); err != nil {
+		logging.DB.WithContext(c.Request.Context()).WithFields(
+			"server_name", serverName,
+			"error", err.Error(),
+		).Warn("Failed to record server as stopped")
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"message":        "Server stopped (deployment scaled to 0), data retained",
 		"deploymentName": deploymentName,
 	})
 }
 
-// StartStoppedServerHandler scales a stopped deployment back to 1 replica.
+// StartStoppedServerHandler scales a stopped or hibernated deployment back to 1 replica.
 //
 // @Summary      Start stopped server
-// @Description  Starts a previously stopped Minecraft server (scales to 1)
+// @Description  Starts a previously stopped or hibernated Minecraft server (scales to 1)
 // @Tags         servers
 // @Produce      json
 // @Security     BearerAuth
 // @Security     APIKeyAuth
 // @Param        serverName  path      string  true  "Server name"
 // @Success      200         {object}  map[string]string  "Server starting"
-// @Failure      401         {object}  map[string]string  "Authentication required"
-// @Failure      403         {object}  map[string]string  "Permission denied"
-// @Failure      404         {object}  map[string]string  "Server not found"
-// @Failure      500         {object}  map[string]string  "Server error"
+// @Failure      401         {object}  apierror.Error     "Authentication required"
+// @Failure      403         {object}  apierror.Error     "Permission denied"
+// @Failure      404         {object}  apierror.Error     "Server not found"
+// @Failure      500         {object}  apierror.Error     "Server error"
 // @Router       /servers/{serverName}/start [post]
 func StartStoppedServerHandler(c *gin.Context) {
 	deploymentName, _ := kubernetes.GetServerInfo(c)
 
-	// Get current user for logging
-	user, _ := auth.GetCurrentUser(c)
-	userID := int64(0)
-	username := "unknown"
-	if user != nil {
-		userID = user.ID
-		username = user.Username
+	// Get current user
+	user, ok := auth.RequireCurrentUser(c)
+	if !ok {
+		return
 	}
 
 	serverName := c.Param("serverName")
 
-	logging.Server.WithFields(
+	logging.Server.WithContext(c.Request.Context()).WithFields(
 		"server_name", serverName,
 		"deployment", deploymentName,
-		"user_id", userID,
-		"username", username,
+		"user_id", user.ID,
+		"username", user.Username,
 		"remote_ip", c.ClientIP(),
 	).Info("Starting stopped Minecraft server")
 
+	namespace := serverNamespace(c)
 	// Check if the deployment exists
-	_, ok := kubernetes.CheckDeploymentExists(c, config.DefaultNamespace, deploymentName)
+	deployment, ok := kubernetes.CheckDeploymentExists(c, namespace, deploymentName)
 	if !ok {
-		logging.Server.WithFields(
+		logging.Server.WithContext(c.Request.Context()).WithFields(
 			"server_name", serverName,
 			"deployment", deploymentName,
 		).Warn("Deployment not found for start operation")
 		return
 	}
 
-	// Scale deployment to 1
-	if err := kubernetes.SetDeploymentReplicas(config.DefaultNamespace, deploymentName, 1); err != nil {
-		logging.Server.WithFields(
+	_, hibernated := deployment.Annotations[kubernetes.HibernatedAnnotation]
+	if hibernated {
+		logging.Server.WithContext(c.Request.Context()).WithFields(
+			"server_name", serverName,
+			"deployment", deploymentName,
+		).Info("Waking up hibernated Minecraft server")
+	}
+
+	// Scale deployment to 1, which also clears the hibernation mark
+	if err := kubernetes.SetDeploymentReplicas(c.Request.Context(), namespace, deploymentName, 1); err != nil {
+		logging.Server.WithContext(c.Request.Context()).WithFields(
 			"server_name", serverName,
 			"deployment", deploymentName,
 			"error", err.Error(),
 		).Error("Failed to scale deployment to 1")
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error":          "Failed to start deployment: " + err.Error(),
-			"deploymentName": deploymentName,
-		})
+		apierror.Respond(c, apierror.New(http.StatusInternalServerError, "", "Failed to start deployment: "+err.Error()).
+			With("deploymentName", deploymentName))
 		return
 	}
 
-	logging.Server.WithFields(
+	logging.Server.Started.WithContext(c.Request.Context()).WithFields(
 		"server_name", serverName,
 		"deployment", deploymentName,
-		"user_id", userID,
-		"username", username,
+		"user_id", user.ID,
+		"username", user.Username,
 	).Info("Minecraft server started successfully")
 
+	if err := database.GetDB().UpdateServerStatus(c.Request.Context(), serverName, database.ServerStatusStarting, ""); err != nil {
+		logging.DB.WithContext(c.Request.Context()).WithFields(
+			"server_name", serverName,
+			"error", err.Error(),
+		).Warn("Failed to record server as starting")
+	}
+
+	message := "Server starting (deployment scaled to 1)"
+	if hibernated {
+		message = "Server waking up from hibernation (deployment scaled to 1)"
+	}
 	c.JSON(http.StatusOK, gin.H{
-		"message":        "Server starting (deployment scaled to 1)",
+		"message":        message,
 		"deploymentName": deploymentName,
 	})
 }
@@ -467,80 +694,129 @@ func StartStoppedServerHandler(c *gin.Context) {
 // @Security     APIKeyAuth
 // @Param        serverName  path      string  true  "Server name"
 // @Success      200         {object}  map[string]string  "Server deleted"
-// @Failure      401         {object}  map[string]string  "Authentication required"
-// @Failure      403         {object}  map[string]string  "Permission denied"
-// @Failure      500         {object}  map[string]string  "Server error"
+// @Failure      401         {object}  apierror.Error     "Authentication required"
+// @Failure      403         {object}  apierror.Error     "Permission denied"
+// @Failure      500         {object}  apierror.Error     "Server error"
 // @Router       /servers/{serverName}/delete [post]
 func DeleteMinecraftServerHandler(c *gin.Context) {
 	deploymentName, pvcName := kubernetes.GetServerInfo(c)
 
-	// Get current user for logging
-	user, _ := auth.GetCurrentUser(c)
-	userID := int64(0)
-	username := "unknown"
-	if user != nil {
-		userID = user.ID
-		username = user.Username
+	// Get current user
+	user, ok := auth.RequireCurrentUser(c)
+	if !ok {
+		return
 	}
 
 	serverName := c.Param("serverName")
 
-	logging.Server.WithFields(
+	// Renamed servers keep the PVC of their original name
+	server, serverErr := database.GetDB().GetServerByName(c.Request.Context(), serverName)
+	if serverErr == nil && server.PVCName != "" {
+		pvcName = server.PVCName
+	}
+
+	logging.Server.WithContext(c.Request.Context()).WithFields(
 		"server_name", serverName,
 		"deployment", deploymentName,
 		"pvc", pvcName,
-		"user_id", userID,
-		"username", username,
+		"user_id", user.ID,
+		"username", user.Username,
 		"remote_ip", c.ClientIP(),
 	).Info("Deleting Minecraft server")
 
+	deleteServerResources(c.Request.Context(), serverName, deploymentName, pvcName, server)
+
+	logging.Server.Deleted.WithContext(c.Request.Context()).WithFields(
+		"server_name", serverName,
+		"deployment", deploymentName,
+		"pvc", pvcName,
+		"user_id", user.ID,
+		"username", user.Username,
+	).Info("Minecraft server deleted successfully")
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "Deployment, PVC and network resources deleted",
+		"deploymentName": deploymentName,
+		"pvcName":        pvcName,
+	})
+}
+
+// deleteServerResources deletes the deployment, storage, network resources and
+// RCON secret of a server, then its record. Errors are logged, so that one
+// missing resource does not keep the others around. server is nil for servers
+// without a record.
+func deleteServerResources(ctx context.Context, serverName, deploymentName, pvcName string, server *database.MinecraftServer) {
+	namespace := kubernetes.ServerNamespace(server)
 	// Delete the deployment if it exists
-	if err := kubernetes.DeleteDeployment(config.DefaultNamespace, deploymentName); err != nil {
-		logging.Server.WithFields(
+	if err := kubernetes.DeleteDeployment(ctx, namespace, deploymentName); err != nil {
+		logging.Server.WithContext(ctx).WithFields(
 			"server_name", serverName,
 			"deployment", deploymentName,
 			"error", err.Error(),
 		).Warn("Error when deleting deployment")
 	} else {
-		logging.Server.Debug("Deployment deleted successfully")
+		logging.Server.WithContext(ctx).Debug("Deployment deleted successfully")
 	}
 
-	// Delete the PVC
-	if err := kubernetes.DeletePVC(config.DefaultNamespace, pvcName); err != nil {
-		logging.Server.WithFields(
+	// Delete the storage (the PVC unless another driver is configured)
+	if err := kubernetes.DeleteStorage(ctx, namespace, pvcName); err != nil {
+		logging.Server.WithContext(ctx).WithFields(
 			"server_name", serverName,
 			"pvc", pvcName,
 			"error", err.Error(),
-		).Warn("Error when deleting PVC")
+		).Warn("Error when deleting storage")
 	} else {
-		logging.Server.Debug("PVC deleted successfully")
+		logging.Server.WithContext(ctx).Debug("Storage deleted successfully")
 	}
 
 	// Clean up network resources
 	serviceName := deploymentName + "-svc"
-	if err := kubernetes.DeleteService(config.DefaultNamespace, serviceName); err != nil {
-		logging.Server.WithFields(
+	if err := kubernetes.DeleteService(ctx, namespace, serviceName); err != nil {
+		logging.Server.WithContext(ctx).WithFields(
 			"server_name", serverName,
 			"service", serviceName,
 			"error", err.Error(),
 		).Warn("Error when deleting service")
 	} else {
-		logging.Server.Debug("Service deleted successfully")
+		logging.Server.WithContext(ctx).Debug("Service deleted successfully")
 	}
 
-	logging.Server.WithFields(
-		"server_name", serverName,
-		"deployment", deploymentName,
-		"pvc", pvcName,
-		"user_id", userID,
-		"username", username,
-	).Info("Minecraft server deleted successfully")
+	// Delete the network policy, if the server had one
+	if err := kubernetes.DeleteNetworkPolicy(ctx, namespace, deploymentName); err != nil {
+		logging.Server.WithContext(ctx).WithFields(
+			"server_name", serverName,
+			"error", err.Error(),
+		).Warn("Failed to delete network policy")
+	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"message":        "Deployment, PVC and network resources deleted",
-		"deploymentName": deploymentName,
-		"pvcName":        pvcName,
-	})
+	// Delete the disruption budget, if the server had one
+	if err := kubernetes.DeleteDisruptionBudget(ctx, namespace, deploymentName); err != nil {
+		logging.Server.WithContext(ctx).WithFields(
+			"server_name", serverName,
+			"error", err.Error(),
+		).Warn("Failed to delete disruption budget")
+	}
+
+	// Delete the RCON secret, if the server had one
+	if err := kubernetes.DeleteSecret(ctx, namespace, kubernetes.RCONSecretName(deploymentName)); err != nil {
+		logging.Server.WithContext(ctx).WithFields(
+			"server_name", serverName,
+			"error", err.Error(),
+		).Warn("Error when deleting RCON secret")
+	}
+
+	// Remove the server from the proxies it is registered behind
+	if server != nil {
+		syncServerProxies(ctx, server.ID, true)
+	}
+
+	// Forget the server once its resources are gone
+	if err := database.GetDB().DeleteServerRecord(ctx, serverName); err != nil {
+		logging.Server.WithContext(ctx).WithFields(
+			"server_name", serverName,
+			"error", err.Error(),
+		).Warn("Error when deleting server record")
+	}
 }
 
 // ExecCommandRequest represents a request to execute a command on the Minecraft server.
@@ -551,7 +827,7 @@ type ExecCommandRequest struct {
 // ExecCommandHandler executes a Minecraft command in the server.
 //
 // @Summary      Execute Minecraft command
-// @Description  Executes a command on the Minecraft server
+// @Description  Executes a command on the Minecraft server, over RCON when the server was created with ENABLE_RCON, otherwise through the console pipe
 // @Tags         servers
 // @Accept       json
 // @Produce      json
@@ -560,38 +836,36 @@ type ExecCommandRequest struct {
 // @Param        serverName  path      string             true  "Server name"
 // @Param        request     body      ExecCommandRequest  true  "Command to execute"
 // @Success      200         {object}  map[string]string  "Command executed"
-// @Failure      400         {object}  map[string]string  "Invalid request"
-// @Failure      401         {object}  map[string]string  "Authentication required"
-// @Failure      403         {object}  map[string]string  "Permission denied"
-// @Failure      404         {object}  map[string]string  "Server not found"
-// @Failure      500         {object}  map[string]string  "Server error"
+// @Failure      400         {object}  apierror.Error     "Invalid request"
+// @Failure      401         {object}  apierror.Error     "Authentication required"
+// @Failure      403         {object}  apierror.Error     "Permission denied"
+// @Failure      404         {object}  apierror.Error     "Server not found"
+// @Failure      500         {object}  apierror.Error     "Server error"
 // @Router       /servers/{serverName}/exec [post]
 func ExecCommandHandler(c *gin.Context) {
 	// Extract the server name from the URL parameter
 	serverName := c.Param("serverName")
 	deploymentName := config.DeploymentPrefix + serverName
 
-	// Get current user for logging
-	user, _ := auth.GetCurrentUser(c)
-	userID := int64(0)
-	username := "unknown"
-	if user != nil {
-		userID = user.ID
-		username = user.Username
+	// Get current user
+	user, ok := auth.RequireCurrentUser(c)
+	if !ok {
+		return
 	}
 
-	logging.Server.WithFields(
+	logging.Server.WithContext(c.Request.Context()).WithFields(
 		"server_name", serverName,
 		"deployment", deploymentName,
-		"user_id", userID,
-		"username", username,
+		"user_id", user.ID,
+		"username", user.Username,
 		"remote_ip", c.ClientIP(),
 	).Info("Executing command on Minecraft server")
 
+	namespace := serverNamespace(c)
 	// Check if the deployment exists
-	_, ok := kubernetes.CheckDeploymentExists(c, config.DefaultNamespace, deploymentName)
+	deployment, ok := kubernetes.CheckDeploymentExists(c, namespace, deploymentName)
 	if !ok {
-		logging.Server.WithFields(
+		logging.Server.WithContext(c.Request.Context()).WithFields(
 			"server_name", serverName,
 			"deployment", deploymentName,
 		).Warn("Deployment not found for command execution")
@@ -599,16 +873,14 @@ func ExecCommandHandler(c *gin.Context) {
 	}
 
 	// Get the pod associated with this deployment
-	pod, err := kubernetes.GetMinecraftPod(config.DefaultNamespace, deploymentName)
+	pod, err := kubernetes.GetMinecraftPod(c.Request.Context(), namespace, deploymentName)
 	if err != nil || pod == nil {
-		logging.Server.WithFields(
+		logging.Server.WithContext(c.Request.Context()).WithFields(
 			"server_name", serverName,
 			"deployment", deploymentName,
 			"error", err,
 		).Error("Failed to find running pod for deployment")
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to find running pod for deployment: " + deploymentName,
-		})
+		apierror.Write(c, http.StatusInternalServerError, "Failed to find running pod for deployment: "+deploymentName)
 		return
 	}
 
@@ -617,51 +889,76 @@ func ExecCommandHandler(c *gin.Context) {
 	//TODO Validate the command
 
 	if err := c.ShouldBindJSON(&req); err != nil {
-		logging.API.InvalidRequest.WithFields(
+		logging.API.InvalidRequest.WithContext(c.Request.Context()).WithFields(
 			"server_name", serverName,
 			"error", err.Error(),
 		).Warn("Invalid command request format")
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		apierror.Write(c, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	logging.Server.WithFields(
+	logging.Server.WithContext(c.Request.Context()).WithFields(
 		"server_name", serverName,
 		"pod", pod.Name,
 		"command", req.Command,
-		"username", username,
+		"username", user.Username,
 	).Debug("Executing Minecraft command")
 
+	// Prefer RCON when the server was created with it, as it returns the command output
+	output, usedRCON, err := kubernetes.RCONCommand(c.Request.Context(), namespace, deployment, pod, req.Command)
+	if usedRCON {
+		if err == nil {
+			logging.Server.CommandExec.WithContext(c.Request.Context()).WithFields(
+				"server_name", serverName,
+				"pod", pod.Name,
+				"command", req.Command,
+				"username", user.Username,
+			).Info("Command executed successfully over RCON")
+
+			c.JSON(http.StatusOK, gin.H{
+				"stdout":  output,
+				"stderr":  "",
+				"command": req.Command,
+				"method":  "rcon",
+			})
+			return
+		}
+		logging.Server.WithContext(c.Request.Context()).WithFields(
+			"server_name", serverName,
+			"pod", pod.Name,
+			"error", err.Error(),
+		).Warn("RCON command failed, falling back to console exec")
+	}
+
 	// Prepare the command to send to the console
 	execCommand := "mc-send-to-console " + req.Command
 
 	// Execute the command in the pod
-	stdout, stderr, err := kubernetes.ExecuteCommandInPod(pod.Name, config.DefaultNamespace, "minecraft-server", execCommand)
+	stdout, stderr, err := kubernetes.ExecuteCommandInPod(c.Request.Context(), pod.Name, namespace, "minecraft-server", execCommand)
 	if err != nil {
-		logging.Server.WithFields(
+		logging.Server.WithContext(c.Request.Context()).WithFields(
 			"server_name", serverName,
 			"pod", pod.Name,
 			"command", req.Command,
 			"error", err.Error(),
 		).Error("Failed to execute command")
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error":   "Failed to execute command: " + err.Error(),
-			"stderr":  stderr,
-			"command": req.Command,
-		})
+		apierror.Respond(c, apierror.New(http.StatusInternalServerError, "", "Failed to execute command: "+err.Error()).
+			With("stderr", stderr).
+			With("command", req.Command))
 		return
 	}
 
-	logging.Server.WithFields(
+	logging.Server.CommandExec.WithContext(c.Request.Context()).WithFields(
 		"server_name", serverName,
 		"pod", pod.Name,
 		"command", req.Command,
-		"username", username,
+		"username", user.Username,
 	).Info("Command executed successfully")
 
 	c.JSON(http.StatusOK, gin.H{
 		"stdout":  stdout,
 		"stderr":  stderr,
 		"command": req.Command,
+		"method":  "exec",
 	})
 }
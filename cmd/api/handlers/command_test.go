@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"minecharts/cmd/database"
+)
+
+func TestDeleteServerRemovesRecord(t *testing.T) {
+	db := setupTest(t)
+	owner := createTestUser(t, db, "owner", database.PermAdmin)
+	createTestServer(t, db, "survival", owner)
+
+	recorder := serve(t, owner, http.MethodPost, "/servers/:serverName/delete", "/servers/survival/delete", nil, DeleteMinecraftServerHandler)
+	expectStatus(t, recorder, http.StatusOK)
+
+	if _, err := db.GetServerByName(context.Background(), "survival"); err == nil {
+		t.Fatal("expected the server record to be deleted")
+	}
+}
+
+func TestDeleteServerWithoutRecord(t *testing.T) {
+	db := setupTest(t)
+	owner := createTestUser(t, db, "owner", database.PermAdmin)
+
+	// Resources left without a record are still cleaned up
+	recorder := serve(t, owner, http.MethodPost, "/servers/:serverName/delete", "/servers/orphan/delete", nil, DeleteMinecraftServerHandler)
+	expectStatus(t, recorder, http.StatusOK)
+}
@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"minecharts/cmd/config"
+	"minecharts/cmd/logging"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ConfigResponse is the effective configuration of the API.
+type ConfigResponse struct {
+	Settings   map[string]any `json:"settings"`              // By file key, the secrets redacted
+	Reloadable []string       `json:"reloadable"`            // Keys applied again on SIGHUP
+	ReloadedAt *time.Time     `json:"reloaded_at,omitempty"` // Last reload changing a setting, none since the start
+}
+
+// GetConfigHandler returns the effective configuration (admin only).
+//
+// @Summary      Get configuration
+// @Description  Returns the configuration the API runs with, after the development mode adjustments and the last reload, to check a change was applied. Secrets are redacted (admin only)
+// @Tags         admin
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200  {object}  ConfigResponse  "Effective configuration"
+// @Failure      401  {object}  apierror.Error  "Authentication required"
+// @Failure      403  {object}  apierror.Error  "Permission denied"
+// @Router       /admin/config [get]
+func GetConfigHandler(c *gin.Context) {
+	response := ConfigResponse{Settings: map[string]any{}, Reloadable: []string{}}
+	for _, setting := range config.Current().Settings() {
+		value := setting.Value
+		if setting.Secret && value != "" {
+			value = logging.Redacted
+		}
+		response.Settings[setting.Key] = value
+		if setting.Reloadable {
+			response.Reloadable = append(response.Reloadable, setting.Key)
+		}
+	}
+	if reloadedAt := config.ReloadedAt(); !reloadedAt.IsZero() {
+		response.ReloadedAt = &reloadedAt
+	}
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,357 @@
+package handlers
+
+import (
+	"archive/zip"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"minecharts/cmd/apierror"
+	"minecharts/cmd/auth"
+	"minecharts/cmd/config"
+	"minecharts/cmd/database"
+	"minecharts/cmd/kubernetes"
+	"minecharts/cmd/logging"
+
+	"github.com/gin-gonic/gin"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// datapackFormField is the multipart field holding the datapack archive.
+const datapackFormField = "datapack"
+
+// DatapackRequest names the data pack to enable or disable.
+type DatapackRequest struct {
+	Name string `json:"name" binding:"required,max=128" example:"file/terralith.zip"`
+}
+
+// DatapackListResponse is returned by the datapack list endpoint.
+type DatapackListResponse struct {
+	ServerName string                `json:"serverName"`
+	Source     string                `json:"source" example:"console"` // "console" for a running server with RCON, "files" otherwise
+	Datapacks  []kubernetes.Datapack `json:"datapacks"`
+}
+
+// ListDatapacksHandler lists the data packs of a server.
+//
+// @Summary      List server datapacks
+// @Description  Lists the enabled and available data packs of a running server with RCON, from the datapack list console command. Otherwise, lists the archives and directories of the datapacks directory of the world, without their state
+// @Tags         datapacks
+// @Produce      json
+// @Security     BearerAuth
+// @Security     APIKeyAuth
+// @Param        serverName  path      string                true  "Server name"
+// @Success      200         {object}  DatapackListResponse  "Server datapacks"
+// @Failure      401         {object}  apierror.Error        "Authentication required"
+// @Failure      403         {object}  apierror.Error        "Permission denied"
+// @Failure      404         {object}  apierror.Error        "Server not found"
+// @Failure      409         {object}  apierror.Error        "The server is starting"
+// @Failure      500         {object}  apierror.Error        "Server error"
+// @Router       /servers/{serverName}/datapacks [get]
+func ListDatapacksHandler(c *gin.Context) {
+	server, deployment, pod, ok := datapackServer(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	namespace := kubernetes.ServerNamespace(server)
+	if pod != nil && pod.Status.Phase == corev1.PodRunning {
+		datapacks, ok, err := kubernetes.ListDatapacks(ctx, namespace, deployment, pod)
+		if err != nil {
+			apierror.Write(c, http.StatusInternalServerError, "Failed to list datapacks: "+err.Error())
+			return
+		}
+		if ok {
+			c.JSON(http.StatusOK, DatapackListResponse{ServerName: server.ServerName, Source: "console", Datapacks: datapacks})
+			return
+		}
+	}
+
+	names, err := kubernetes.ListServerDirectory(ctx, namespace, server.DeploymentName, server.PVCName, kubernetes.DatapacksDirectory(deployment))
+	if err != nil {
+		serverFileError(c, "Failed to list datapacks", err)
+		return
+	}
+	datapacks := []kubernetes.Datapack{}
+	for _, name := range names {
+		datapacks = append(datapacks, kubernetes.Datapack{Name: "file/" + name, Source: "files"})
+	}
+	c.JSON(http.StatusOK, DatapackListResponse{ServerName: server.ServerName, Source: "files", Datapacks: datapacks})
+}
+
+// UploadDatapackHandler adds a datapack archive to the world of a server.
+//
+// The archive is checked to hold a pack.mcmeta at its root before being written to
+// the server volume, replacing the archive of the same name.
+//
+// @Summary      Upload server datapack
+// @Description  Adds a zip datapack, sent as the datapack field of a multipart form, to the datapacks directory of the world of the server (the LEVEL environment variable, world by default). A running server is reloaded, which enables the new datapack; a stopped one enables it on its next start
+// @Tags         datapacks
+// @Accept       multipart/form-data
+// @Produce      json
+// @Security     BearerAuth
+// @Security     APIKeyAuth
+// @Param        serverName  path      string             true  "Server name"
+// @Param        datapack    formData  file               true  "Datapack archive (zip)"
+// @Success      201         {object}  map[string]interface{}  "Datapack uploaded"
+// @Failure      400         {object}  apierror.Error     "Missing or invalid archive"
+// @Failure      401         {object}  apierror.Error     "Authentication required"
+// @Failure      403         {object}  apierror.Error     "Permission denied"
+// @Failure      404         {object}  apierror.Error     "Server not found"
+// @Failure      409         {object}  apierror.Error     "The server is starting"
+// @Failure      413         {object}  apierror.Error     "Archive too large"
+// @Failure      500         {object}  apierror.Error     "Server error"
+// @Router       /servers/{serverName}/datapacks [post]
+func UploadDatapackHandler(c *gin.Context) {
+	server, deployment, pod, ok := datapackServer(c)
+	if !ok {
+		return
+	}
+	user, ok := auth.RequireCurrentUser(c)
+	if !ok {
+		return
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, int64(config.DatapackUploadMaxBytes))
+	reader, err := c.Request.MultipartReader()
+	if err != nil {
+		apierror.Write(c, http.StatusBadRequest, "Expected a multipart form with the datapack archive")
+		return
+	}
+	var fileName string
+	var archive io.Reader
+	for archive == nil {
+		part, err := reader.NextPart()
+		if err != nil {
+			apierror.Write(c, http.StatusBadRequest, "Missing "+datapackFormField+" file in the form")
+			return
+		}
+		if part.FormName() == datapackFormField && part.FileName() != "" {
+			fileName = part.FileName()
+			archive = part
+		}
+	}
+	if !validDatapackFileName(fileName) {
+		apierror.Write(c, http.StatusBadRequest, "The datapack must be a zip file with a plain name")
+		return
+	}
+
+	// Buffered since the zip index is at the end of the archive
+	upload, err := os.CreateTemp("", "minecharts-datapack-*.zip")
+	if err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to create upload file")
+		return
+	}
+	defer os.Remove(upload.Name())
+	defer upload.Close()
+	size, err := io.Copy(upload, archive)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			apierror.Write(c, http.StatusRequestEntityTooLarge, "The datapack archive is larger than the upload limit")
+			return
+		}
+		apierror.Write(c, http.StatusBadRequest, "Failed to read the datapack archive: "+err.Error())
+		return
+	}
+	if err := checkDatapackArchive(upload, size); err != nil {
+		apierror.Write(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if _, err := upload.Seek(0, 0); err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to read upload file")
+		return
+	}
+
+	ctx := c.Request.Context()
+	filePath := kubernetes.DatapacksDirectory(deployment) + "/" + fileName
+	namespace := kubernetes.ServerNamespace(server)
+	if err := kubernetes.WriteServerFile(ctx, namespace, server.DeploymentName, server.PVCName, filePath, upload); err != nil {
+		serverFileError(c, "Failed to upload datapack", err)
+		return
+	}
+
+	// Reloading picks the new data packs up and enables them
+	reloaded := false
+	if pod != nil {
+		if _, _, err := kubernetes.SendConsoleCommand(ctx, namespace, deployment, pod, "reload"); err != nil {
+			logging.Server.WithContext(ctx).WithFields(
+				"server_name", server.ServerName,
+				"error", err.Error(),
+			).Warn("Failed to reload server after datapack upload")
+		} else {
+			reloaded = true
+		}
+	}
+
+	logging.Server.WithContext(ctx).WithFields(
+		"server_name", server.ServerName,
+		"file", filePath,
+		"size", size,
+		"reloaded", reloaded,
+		"user_id", user.ID,
+		"username", user.Username,
+	).Info("Datapack uploaded to Minecraft server")
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":  "Datapack uploaded",
+		"name":     "file/" + fileName,
+		"file":     filePath,
+		"reloaded": reloaded,
+	})
+}
+
+// EnableDatapackHandler enables a data pack of a running server.
+//
+// @Summary      Enable server datapack
+// @Description  Enables a data pack with the datapack enable console command. Without RCON, the command is sent but its result is not reported, and confirmed is false
+// @Tags         datapacks
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Security     APIKeyAuth
+// @Param        serverName  path      string           true  "Server name"
+// @Param        request     body      DatapackRequest  true  "Datapack name"
+// @Success      200         {object}  map[string]interface{}  "Datapack enabled"
+// @Failure      400         {object}  apierror.Error     "Invalid request"
+// @Failure      401         {object}  apierror.Error     "Authentication required"
+// @Failure      403         {object}  apierror.Error     "Permission denied"
+// @Failure      404         {object}  apierror.Error     "Server or datapack not found"
+// @Failure      409         {object}  apierror.Error     "The server is not running or the datapack is already enabled"
+// @Failure      500         {object}  apierror.Error     "Server error"
+// @Router       /servers/{serverName}/datapacks/enable [post]
+func EnableDatapackHandler(c *gin.Context) {
+	setDatapackEnabled(c, true)
+}
+
+// DisableDatapackHandler disables a data pack of a running server.
+//
+// @Summary      Disable server datapack
+// @Description  Disables a data pack with the datapack disable console command. Without RCON, the command is sent but its result is not reported, and confirmed is false
+// @Tags         datapacks
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Security     APIKeyAuth
+// @Param        serverName  path      string           true  "Server name"
+// @Param        request     body      DatapackRequest  true  "Datapack name"
+// @Success      200         {object}  map[string]interface{}  "Datapack disabled"
+// @Failure      400         {object}  apierror.Error     "Invalid request"
+// @Failure      401         {object}  apierror.Error     "Authentication required"
+// @Failure      403         {object}  apierror.Error     "Permission denied"
+// @Failure      404         {object}  apierror.Error     "Server or datapack not found"
+// @Failure      409         {object}  apierror.Error     "The server is not running or the datapack is not enabled"
+// @Failure      500         {object}  apierror.Error     "Server error"
+// @Router       /servers/{serverName}/datapacks/disable [post]
+func DisableDatapackHandler(c *gin.Context) {
+	setDatapackEnabled(c, false)
+}
+
+func setDatapackEnabled(c *gin.Context, enabled bool) {
+	var req DatapackRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Invalid(c, err)
+		return
+	}
+	// The name is quoted in the command
+	if strings.ContainsAny(req.Name, "\"\\\r\n") {
+		apierror.Write(c, http.StatusBadRequest, "Invalid datapack name")
+		return
+	}
+	server, deployment, pod, ok := datapackServer(c)
+	if !ok {
+		return
+	}
+	if pod == nil || pod.Status.Phase != corev1.PodRunning {
+		apierror.Write(c, http.StatusConflict, "The server must be running to change its datapacks")
+		return
+	}
+	user, ok := auth.RequireCurrentUser(c)
+	if !ok {
+		return
+	}
+
+	namespace := kubernetes.ServerNamespace(server)
+	confirmed, err := kubernetes.SetDatapackEnabled(c.Request.Context(), namespace, deployment, pod, req.Name, enabled)
+	switch {
+	case errors.Is(err, kubernetes.ErrDatapackNotFound):
+		apierror.Write(c, http.StatusNotFound, "Datapack not found: "+req.Name)
+		return
+	case errors.Is(err, kubernetes.ErrDatapackUnchanged):
+		apierror.Write(c, http.StatusConflict, err.Error())
+		return
+	case err != nil:
+		apierror.Write(c, http.StatusInternalServerError, "Failed to change datapack: "+err.Error())
+		return
+	}
+
+	logging.Server.WithContext(c.Request.Context()).WithFields(
+		"server_name", server.ServerName,
+		"datapack", req.Name,
+		"enabled", enabled,
+		"confirmed", confirmed,
+		"user_id", user.ID,
+		"username", user.Username,
+	).Info("Datapack state changed on Minecraft server")
+
+	message := "Datapack disabled"
+	if enabled {
+		message = "Datapack enabled"
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message":   message,
+		"name":      req.Name,
+		"enabled":   enabled,
+		"confirmed": confirmed,
+	})
+}
+
+// datapackServer returns the server of the request with its deployment and its pod,
+// nil when the server is stopped. It writes the error response and returns false when
+// any of them cannot be found.
+func datapackServer(c *gin.Context) (*database.MinecraftServer, *appsv1.Deployment, *corev1.Pod, bool) {
+	ctx := c.Request.Context()
+	server, err := database.GetDB().GetServerByName(ctx, c.Param("serverName"))
+	if err != nil {
+		apierror.Respond(c, apierror.New(http.StatusNotFound, apierror.CodeServerNotFound, "Server not found"))
+		return nil, nil, nil, false
+	}
+	namespace := kubernetes.ServerNamespace(server)
+	deployment, ok := kubernetes.CheckDeploymentExists(c, namespace, server.DeploymentName)
+	if !ok {
+		return nil, nil, nil, false
+	}
+	pod, err := kubernetes.GetMinecraftPod(ctx, namespace, server.DeploymentName)
+	if err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to get server pod: "+err.Error())
+		return nil, nil, nil, false
+	}
+	return server, deployment, pod, true
+}
+
+// validDatapackFileName reports whether a file name can be written as is to the
+// datapacks directory and named in the datapack commands.
+func validDatapackFileName(name string) bool {
+	return strings.HasSuffix(strings.ToLower(name), ".zip") && len(name) <= 100 &&
+		path.Base(name) == name && !strings.HasPrefix(name, ".") && !strings.ContainsAny(name, "\"\\\r\n")
+}
+
+// checkDatapackArchive checks that an archive is a zip holding a pack.mcmeta at its
+// root, as Minecraft expects.
+func checkDatapackArchive(archive io.ReaderAt, size int64) error {
+	files, err := zip.NewReader(archive, size)
+	if err != nil {
+		return errors.New("the datapack must be a zip archive")
+	}
+	for _, file := range files.File {
+		if file.Name == "pack.mcmeta" {
+			return nil
+		}
+	}
+	return errors.New("the datapack archive has no pack.mcmeta at its root")
+}
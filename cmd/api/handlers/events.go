@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"minecharts/cmd/apierror"
+	"minecharts/cmd/auth"
+	"minecharts/cmd/database"
+	"minecharts/cmd/events"
+	"minecharts/cmd/kubernetes"
+	"minecharts/cmd/logging"
+
+	"github.com/gin-gonic/gin"
+)
+
+// eventStreamHeartbeat is how often an idle event stream sends a comment, so that
+// proxies do not close it.
+const eventStreamHeartbeat = 15 * time.Second
+
+// streamedEvents are the event types of the server streams; the server console is
+// only streamed by the stream of one server.
+var streamedEvents = []events.Type{
+	events.ServerStatus, events.ServerIncident, events.JobProgress, events.BackupCompleted, events.BackupFailed,
+}
+
+// StreamEventsHandler streams the events of the servers the user can view with
+// Server-Sent Events: their status transitions, incidents, backups and the progress
+// of their jobs.
+//
+// @Summary      Stream events
+// @Description  Server-Sent Events stream of the status transitions (server.status), crash incidents (server.incident), job progress (job.progress) and backups (backup.completed, backup.failed) of the servers the user can view. Each event carries its type as the SSE event name and the event as JSON data. The stream ends when the client lags too far behind, for the client to reconnect
+// @Tags         events
+// @Produce      text/event-stream
+// @Security     BearerAuth
+// @Security     APIKeyAuth
+// @Param        types  query     string             false  "Comma separated event types, all by default"
+// @Success      200    {string}  string             "Event stream"
+// @Failure      400    {object}  apierror.Error     "Invalid event type"
+// @Failure      401    {object}  apierror.Error     "Authentication required"
+// @Router       /events/stream [get]
+func StreamEventsHandler(c *gin.Context) {
+	user, ok := auth.RequireCurrentUser(c)
+	if !ok {
+		return
+	}
+	types, err := eventTypes(c.Query("types"), streamedEvents...)
+	if err != nil {
+		apierror.Write(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Memberships granted or revoked during the stream apply once the client reconnects
+	access, err := auth.LoadServerAccess(c.Request.Context(), user)
+	if err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to load server memberships")
+		return
+	}
+
+	sub := events.Subscribe(func(event events.Event) bool {
+		return types[event.Type] && access.Has(event.Server, event.OwnerID, database.PermViewServer)
+	})
+	defer sub.Close()
+	streamEvents(c, sub, user)
+}
+
+// StreamServerEventsHandler streams the events of a server with Server-Sent Events:
+// the events of StreamEventsHandler and, for the users who can run commands on it,
+// its console.
+//
+// @Summary      Stream server events
+// @Description  Server-Sent Events stream of the status transitions (server.status), crash incidents (server.incident), job progress (job.progress), backups (backup.completed, backup.failed) and console lines (server.log) of a server. The console starts with its last lines and requires the permission to run commands on the server; it is only streamed when requested with the types parameter or when no types are given
+// @Tags         events
+// @Produce      text/event-stream
+// @Security     BearerAuth
+// @Security     APIKeyAuth
+// @Param        serverName  path      string             true   "Server name"
+// @Param        types       query     string             false  "Comma separated event types, all by default"
+// @Success      200         {string}  string             "Event stream"
+// @Failure      400         {object}  apierror.Error     "Invalid event type"
+// @Failure      401         {object}  apierror.Error     "Authentication required"
+// @Failure      403         {object}  apierror.Error     "Permission denied"
+// @Failure      404         {object}  apierror.Error     "Server not found"
+// @Router       /servers/{serverName}/events/stream [get]
+func StreamServerEventsHandler(c *gin.Context) {
+	user, ok := auth.RequireCurrentUser(c)
+	if !ok {
+		return
+	}
+	server, err := database.GetDB().GetServerByName(c.Request.Context(), c.Param("serverName"))
+	if err != nil {
+		apierror.Respond(c, apierror.New(http.StatusNotFound, apierror.CodeServerNotFound, "Server not found"))
+		return
+	}
+	types, err := eventTypes(c.Query("types"), append(streamedEvents, events.ServerLog)...)
+	if err != nil {
+		apierror.Write(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if types[events.ServerLog] && !auth.HasServerAccess(c.Request.Context(), user, server, database.PermExecCommand) {
+		if c.Query("types") != "" {
+			apierror.Write(c, http.StatusForbidden, "Streaming the console requires the permission to run commands on the server")
+			return
+		}
+		delete(types, events.ServerLog)
+	}
+
+	serverName := server.ServerName
+	sub := events.Subscribe(func(event events.Event) bool {
+		return event.Server == serverName && types[event.Type]
+	})
+	defer sub.Close()
+	namespace := kubernetes.ServerNamespace(server)
+	if types[events.ServerLog] && kubernetes.Clientset != nil {
+		release := kubernetes.FollowServerLogs(namespace, server.DeploymentName, serverName, server.OwnerID)
+		defer release()
+	}
+	streamEvents(c, sub, user)
+}
+
+// eventTypes parses the types parameter of a stream among the allowed types, all of
+// them when it is empty.
+func eventTypes(param string, allowed ...events.Type) (map[events.Type]bool, error) {
+	types := make(map[events.Type]bool, len(allowed))
+	if param == "" {
+		for _, eventType := range allowed {
+			types[eventType] = true
+		}
+		return types, nil
+	}
+	names := make([]string, len(allowed))
+	for i, eventType := range allowed {
+		names[i] = string(eventType)
+	}
+	for _, name := range strings.Split(param, ",") {
+		name = strings.TrimSpace(name)
+		if !contains(names, name) {
+			return nil, fmt.Errorf("event type %q is invalid, expected one of %s", name, strings.Join(names, ", "))
+		}
+		types[events.Type(name)] = true
+	}
+	return types, nil
+}
+
+// streamEvents writes the events of a subscription as Server-Sent Events until the
+// client disconnects or the subscription is dropped.
+func streamEvents(c *gin.Context, sub *events.Subscription, user *database.User) {
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no") // Keeps nginx from buffering the stream
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	logging.API.WithContext(c.Request.Context()).WithFields(
+		"user_id", user.ID,
+		"path", c.Request.URL.Path,
+	).Debug("Event stream opened")
+
+	heartbeat := time.NewTicker(eventStreamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(c.Writer, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		case event, ok := <-sub.Events:
+			if !ok {
+				logging.API.WithContext(c.Request.Context()).WithFields(
+					"user_id", user.ID,
+					"path", c.Request.URL.Path,
+				).Warn("Event stream lagging behind or API shutting down, closing it")
+				return
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				continue
+			}
+			if _, err := fmt.Fprintf(c.Writer, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data); err != nil {
+				return
+			}
+		}
+		c.Writer.Flush()
+	}
+}
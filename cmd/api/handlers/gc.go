@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"minecharts/cmd/apierror"
+	"minecharts/cmd/auth"
+	"minecharts/cmd/database"
+	"minecharts/cmd/kubernetes"
+	"minecharts/cmd/logging"
+	"minecharts/cmd/security"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GarbageCollectRequest represents the options of a garbage collection run.
+type GarbageCollectRequest struct {
+	// DryRun only reports the orphaned resources. Defaults to true so that
+	// deleting requires an explicit "dryRun": false.
+	DryRun *bool `json:"dryRun" example:"true"`
+}
+
+// GarbageCollectFailure is an orphaned resource that could not be deleted.
+type GarbageCollectFailure struct {
+	kubernetes.ManagedResource
+	Error string `json:"error"`
+}
+
+// GarbageCollectResponse is returned by the garbage collection endpoint.
+type GarbageCollectResponse struct {
+	DryRun  bool                         `json:"dryRun"`
+	Orphans []kubernetes.ManagedResource `json:"orphans"`
+	Deleted []kubernetes.ManagedResource `json:"deleted"`
+	Failed  []GarbageCollectFailure      `json:"failed,omitempty"`
+}
+
+// GarbageCollectHandler reports, and optionally deletes, the resources created by the API
+// that no longer belong to a recorded server (admin only).
+//
+// @Summary      Collect orphaned resources
+// @Description  Scans the namespace for deployments, services, secrets and PVCs created by Minecharts with no matching server record. Nothing is deleted unless dryRun is false (admin only)
+// @Tags         admin
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        request  body      GarbageCollectRequest   false  "Garbage collection options"
+// @Success      200      {object}  GarbageCollectResponse  "Orphaned resources"
+// @Failure      400      {object}  apierror.Error          "Invalid request"
+// @Failure      401      {object}  apierror.Error          "Authentication required"
+// @Failure      403      {object}  apierror.Error          "Permission denied"
+// @Failure      500      {object}  apierror.Error          "Server error"
+// @Failure      503      {object}  apierror.Error          "Cluster unreachable"
+// @Router       /admin/gc [post]
+func GarbageCollectHandler(c *gin.Context) {
+	var req GarbageCollectRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apierror.Invalid(c, err)
+			return
+		}
+	}
+	dryRun := req.DryRun == nil || *req.DryRun
+
+	ctx := c.Request.Context()
+	servers, err := database.GetDB().ListServers(ctx)
+	if err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to list servers")
+		return
+	}
+	recorded := map[string]bool{}
+	for _, server := range servers {
+		recorded[server.DeploymentName] = true
+		// Renamed servers keep the PVC of their original name
+		recorded[server.PVCName] = true
+	}
+	proxies, err := database.GetDB().ListProxies(ctx)
+	if err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to list proxies")
+		return
+	}
+	for _, proxy := range proxies {
+		recorded[proxy.DeploymentName] = true
+	}
+
+	// Every namespace with tenant namespaces
+	namespace := kubernetes.WatchedNamespace()
+	orphans, err := kubernetes.OrphanedResources(ctx, namespace, recorded)
+	if err != nil {
+		logging.K8s.WithContext(ctx).WithFields(
+			"namespace", namespace,
+			"error", err.Error(),
+		).Error("Failed to list managed resources")
+		apierror.Write(c, http.StatusInternalServerError, "Failed to list cluster resources")
+		return
+	}
+
+	response := GarbageCollectResponse{
+		DryRun:  dryRun,
+		Orphans: orphans,
+		Deleted: []kubernetes.ManagedResource{},
+	}
+	if response.Orphans == nil {
+		response.Orphans = []kubernetes.ManagedResource{}
+	}
+
+	adminUser, ok := auth.RequireCurrentUser(c)
+	if !ok {
+		return
+	}
+
+	if !dryRun {
+		for _, resource := range orphans {
+			if err := kubernetes.DeleteManagedResource(ctx, resource); err != nil {
+				response.Failed = append(response.Failed, GarbageCollectFailure{ManagedResource: resource, Error: err.Error()})
+				continue
+			}
+			response.Deleted = append(response.Deleted, resource)
+		}
+
+		security.NewEvent(c, security.AdminAction, "garbage_collect").WithUser(adminUser).
+			WithDetail("deleted", strconv.Itoa(len(response.Deleted))).
+			WithDetail("failed", strconv.Itoa(len(response.Failed))).Emit()
+	}
+
+	logging.K8s.WithContext(ctx).WithFields(
+		"namespace", namespace,
+		"dry_run", dryRun,
+		"orphans", len(orphans),
+		"deleted", len(response.Deleted),
+		"failed", len(response.Failed),
+		"username", adminUser.Username,
+	).Info("Garbage collection completed")
+
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"minecharts/cmd/config"
+	"minecharts/cmd/database"
+	"minecharts/cmd/kubernetes"
+	"minecharts/cmd/logging"
+
+	"github.com/gin-gonic/gin"
+)
+
+// healthCheckTimeout bounds each dependency check of the readiness probe, below the
+// one second timeout of the Kubernetes probes.
+const healthCheckTimeout = 900 * time.Millisecond
+
+// DependencyStatus is the outcome of the check of a dependency of the API.
+type DependencyStatus struct {
+	Status    string `json:"status" example:"ok"` // ok or unavailable
+	LatencyMs int64  `json:"latency_ms" example:"3"`
+	Error     string `json:"error,omitempty"`
+}
+
+// HealthResponse is the answer of the health probes.
+type HealthResponse struct {
+	Status string                      `json:"status" example:"ok"` // ok or unavailable
+	Checks map[string]DependencyStatus `json:"checks,omitempty"`
+}
+
+// healthChecks are the dependencies the API cannot serve without.
+var healthChecks = map[string]func(ctx context.Context) error{
+	"database": func(ctx context.Context) error {
+		return database.GetDB().Ping(ctx)
+	},
+	"kubernetes": func(ctx context.Context) error {
+		return kubernetes.Ping(ctx, config.DefaultNamespace)
+	},
+}
+
+// HealthzHandler answers the liveness probe.
+//
+// @Summary      Liveness probe
+// @Description  Answers as long as the API serves requests. The dependencies are left to the readiness probe, as restarting the API does not bring them back
+// @Tags         system
+// @Produce      json
+// @Success      200  {object}  HealthResponse  "The API is alive"
+// @Router       /healthz [get]
+func HealthzHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, HealthResponse{Status: "ok"})
+}
+
+// ReadyzHandler answers the readiness probe, checking the database and the
+// Kubernetes API.
+//
+// @Summary      Readiness probe
+// @Description  Checks the database and the Kubernetes API in parallel, with the status of each, and answers 503 when one of them is unavailable
+// @Tags         system
+// @Produce      json
+// @Success      200  {object}  HealthResponse  "The API is ready"
+// @Failure      503  {object}  HealthResponse  "A dependency is unavailable"
+// @Router       /readyz [get]
+func ReadyzHandler(c *gin.Context) {
+	response := HealthResponse{Status: "ok", Checks: map[string]DependencyStatus{}}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, check := range healthChecks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(c.Request.Context(), healthCheckTimeout)
+			defer cancel()
+			start := time.Now()
+			err := check(ctx)
+			status := DependencyStatus{Status: "ok", LatencyMs: time.Since(start).Milliseconds()}
+			if err != nil {
+				status.Status = "unavailable"
+				status.Error = err.Error()
+			}
+			mu.Lock()
+			response.Checks[name] = status
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	for name, status := range response.Checks {
+		if status.Status == "ok" {
+			continue
+		}
+		response.Status = "unavailable"
+		logging.API.WithContext(c.Request.Context()).WithFields(
+			"dependency", name,
+			"error", status.Error,
+		).Warn("Readiness check failed")
+	}
+	if response.Status != "ok" {
+		c.JSON(http.StatusServiceUnavailable, response)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
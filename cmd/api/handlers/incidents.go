@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"minecharts/cmd/apierror"
+	"minecharts/cmd/database"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultIncidentsLimit = 20
+	maxIncidentsLimit     = 100
+)
+
+// ListServerIncidentsHandler returns the incidents of a server, newest first. Pages
+// are followed with the next_before cursor of the previous page.
+//
+// @Summary      List server incidents
+// @Description  Returns the crashes of the server container detected by the API, newest first: OOM kills (oom_killed) and crash loops (crash_loop). The owner is notified of each incident, and the webhooks configured with MINECHARTS_WEBHOOK_URLS receive a server.incident delivery
+// @Tags         servers
+// @Produce      json
+// @Security     BearerAuth
+// @Security     APIKeyAuth
+// @Param        serverName  path      string                  true   "Server name"
+// @Param        before      query     int                     false  "Cursor returned as next_before by the previous page"
+// @Param        limit       query     int                     false  "Maximum number of incidents (default 20, max 100)"
+// @Success      200         {object}  map[string]interface{}  "Incidents and the cursor of the next page"
+// @Failure      400         {object}  apierror.Error          "Invalid cursor or limit"
+// @Failure      401         {object}  apierror.Error          "Authentication required"
+// @Failure      403         {object}  apierror.Error          "Permission denied"
+// @Failure      404         {object}  apierror.Error          "Server not found"
+// @Failure      500         {object}  apierror.Error          "Server error"
+// @Router       /servers/{serverName}/incidents [get]
+func ListServerIncidentsHandler(c *gin.Context) {
+	var before int64
+	if value := c.Query("before"); value != "" {
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || parsed < 1 {
+			apierror.Write(c, http.StatusBadRequest, "before must be a positive integer")
+			return
+		}
+		before = parsed
+	}
+	limit := defaultIncidentsLimit
+	if value := c.Query("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxIncidentsLimit {
+			apierror.Write(c, http.StatusBadRequest, "limit must be between 1 and "+strconv.Itoa(maxIncidentsLimit))
+			return
+		}
+		limit = parsed
+	}
+
+	db := database.GetDB()
+	server, err := db.GetServerByName(c.Request.Context(), c.Param("serverName"))
+	if err != nil {
+		apierror.Respond(c, apierror.New(http.StatusNotFound, apierror.CodeServerNotFound, "Server not found"))
+		return
+	}
+	incidents, err := db.ListServerIncidents(c.Request.Context(), server.ID, before, limit)
+	if err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to list incidents")
+		return
+	}
+
+	response := gin.H{"incidents": incidents}
+	if len(incidents) == limit {
+		response["next_before"] = incidents[len(incidents)-1].ID
+	}
+	c.JSON(http.StatusOK, response)
+}
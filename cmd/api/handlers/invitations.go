@@ -0,0 +1,226 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"minecharts/cmd/apierror"
+	"minecharts/cmd/auth"
+	"minecharts/cmd/config"
+	"minecharts/cmd/database"
+	"minecharts/cmd/logging"
+	"minecharts/cmd/mail"
+	"minecharts/cmd/security"
+
+	"github.com/gin-gonic/gin"
+)
+
+// invitationPurpose records the pending invitations as nonces, consumed when their
+// signup link is used.
+const invitationPurpose = "invitation"
+
+// InvitationRequest invites someone to create an account with the permissions
+// chosen by an admin.
+type InvitationRequest struct {
+	Email       string `json:"email" binding:"required,email" example:"user@example.com"`
+	Permissions *int64 `json:"permissions" example:"128"` // Bit flags for permissions, read-only when omitted
+}
+
+// AcceptInvitationRequest creates the account of an invitation.
+type AcceptInvitationRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Username string `json:"username" binding:"required,min=3,max=50" example:"newuser"`
+	Password string `json:"password" binding:"required,min=8" example:"securepass123"`
+}
+
+// pendingInvitation is an invitation waiting for its signup link to be used.
+type pendingInvitation struct {
+	Email       string `json:"email"`
+	Permissions int64  `json:"permissions"`
+	InvitedBy   int64  `json:"invited_by"`
+}
+
+// CreateInvitationHandler invites someone to create an account (admin only).
+//
+// @Summary      Invite user
+// @Description  Creates a one-time signup link for the email, with the permissions chosen by the admin, and emails it when an SMTP server is configured
+// @Tags         users
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        request  body      InvitationRequest       true  "Email and permissions of the invited user"
+// @Success      201      {object}  map[string]interface{}  "Invitation with its signup link"
+// @Failure      400      {object}  apierror.Error          "Invalid request"
+// @Failure      401      {object}  apierror.Error          "Authentication required"
+// @Failure      403      {object}  apierror.Error          "Permission denied"
+// @Failure      409      {object}  apierror.Error          "Email already in use"
+// @Failure      500      {object}  apierror.Error          "Server error"
+// @Failure      502      {object}  apierror.Error          "Invitation email not sent"
+// @Router       /users/invitations [post]
+func CreateInvitationHandler(c *gin.Context) {
+	user, ok := auth.RequireCurrentUser(c)
+	if !ok {
+		return
+	}
+
+	var req InvitationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Invalid(c, err)
+		return
+	}
+	invitation := pendingInvitation{Email: req.Email, Permissions: database.PermReadOnly, InvitedBy: user.ID}
+	if req.Permissions != nil {
+		invitation.Permissions = *req.Permissions
+	}
+
+	ctx := c.Request.Context()
+	db := database.GetDB()
+	_, err := db.GetUserByEmail(ctx, req.Email)
+	if err == nil {
+		apierror.Write(c, http.StatusConflict, "A user already has this email")
+		return
+	}
+	if !errors.Is(err, database.ErrUserNotFound) {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to check email")
+		return
+	}
+
+	token, err := GenerateStateValue()
+	if err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to generate invitation token")
+		return
+	}
+	data, _ := json.Marshal(invitation)
+	expiresAt := time.Now().Add(config.InvitationTTL)
+	if err := db.CreateNonce(ctx, invitationPurpose, token, string(data), expiresAt); err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to store invitation")
+		return
+	}
+	link := config.Live().FrontendURL + "/signup?invitation=" + url.QueryEscape(token)
+
+	emailed := false
+	if mail.Enabled() {
+		body := user.Username + " invited you to create an account on Minecharts.\n\n" +
+			"Choose your username and password at " + link + "\n\n" +
+			"The link can be used once, until " + expiresAt.UTC().Format(time.RFC1123) + "."
+		if err := mail.Send(req.Email, "Your Minecharts invitation", body); err != nil {
+			apierror.Write(c, http.StatusBadGateway, "Failed to send the invitation email")
+			return
+		}
+		emailed = true
+	}
+
+	logging.Auth.WithContext(ctx).WithFields(
+		"current_user_id", user.ID,
+		"username", user.Username,
+		"email", req.Email,
+		"permissions", invitation.Permissions,
+		"emailed", emailed,
+	).Info("User invited")
+	security.NewEvent(c, security.AdminAction, "invite_user").WithUser(user).WithTarget(req.Email).
+		WithDetail("permissions", strconv.FormatInt(invitation.Permissions, 10)).Emit()
+
+	c.JSON(http.StatusCreated, gin.H{
+		"email":       req.Email,
+		"permissions": invitation.Permissions,
+		"link":        link, // For the admin to share when it was not emailed
+		"emailed":     emailed,
+		"expires_at":  expiresAt,
+	})
+}
+
+// AcceptInvitationHandler creates the account of an invitation, consuming it.
+//
+// @Summary      Accept invitation
+// @Description  Creates the account of an invitation with the username and password chosen by the invited user, even when registration is disabled
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        request  body      AcceptInvitationRequest  true  "Invitation token and credentials"
+// @Success      201      {object}  map[string]interface{}   "User created with token"
+// @Failure      400      {object}  apierror.Error           "Invalid request or invitation"
+// @Failure      409      {object}  apierror.Error           "Username or email already exists"
+// @Failure      500      {object}  apierror.Error           "Server error"
+// @Router       /auth/invitations/accept [post]
+func AcceptInvitationHandler(c *gin.Context) {
+	var req AcceptInvitationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Invalid(c, err)
+		return
+	}
+
+	ctx := c.Request.Context()
+	db := database.GetDB()
+	// Check the username before the invitation is used up
+	_, err := db.GetUserByUsername(ctx, req.Username)
+	if err == nil {
+		apierror.Write(c, http.StatusConflict, "Username already exists")
+		return
+	}
+	if !errors.Is(err, database.ErrUserNotFound) {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to check username")
+		return
+	}
+
+	data, err := db.ConsumeNonce(ctx, invitationPurpose, req.Token)
+	if errors.Is(err, database.ErrNonceNotFound) {
+		security.NewEvent(c, security.AuthFailure, "accept_invitation").WithReason("invalid_invitation").Emit()
+		apierror.Write(c, http.StatusBadRequest, "Invalid or expired invitation")
+		return
+	}
+	if err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to read invitation")
+		return
+	}
+	var invitation pendingInvitation
+	if err := json.Unmarshal([]byte(data), &invitation); err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to read invitation")
+		return
+	}
+
+	passwordHash, err := auth.HashPassword(req.Password)
+	if err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to hash password")
+		return
+	}
+	user := &database.User{
+		Username:     req.Username,
+		Email:        invitation.Email,
+		PasswordHash: passwordHash,
+		Permissions:  invitation.Permissions,
+		Active:       true,
+	}
+	if err := db.CreateUser(ctx, user); err != nil {
+		if errors.Is(err, database.ErrUserExists) {
+			apierror.Write(c, http.StatusConflict, "Username or email already exists")
+			return
+		}
+		apierror.Write(c, http.StatusInternalServerError, "Failed to create user")
+		return
+	}
+
+	token, err := auth.GenerateJWT(user.ID, user.Username, user.Email, user.Permissions)
+	if err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to generate token")
+		return
+	}
+
+	logging.Auth.Register.WithContext(ctx).WithFields(
+		"user_id", user.ID,
+		"username", user.Username,
+		"invited_by", invitation.InvitedBy,
+		"remote_ip", c.ClientIP(),
+	).Info("Invited user registered")
+
+	c.JSON(http.StatusCreated, gin.H{
+		"token":       token,
+		"user_id":     user.ID,
+		"username":    user.Username,
+		"email":       user.Email,
+		"permissions": user.Permissions,
+	})
+}
@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"minecharts/cmd/apierror"
+	"minecharts/cmd/auth"
+	"minecharts/cmd/database"
+	"minecharts/cmd/shutdown"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultJobsLimit = 20
+	maxJobsLimit     = 100
+)
+
+// GetJobHandler returns the status and progress of a job. A job is visible to
+// whoever started it and to the users who can view its server.
+//
+// @Summary      Get job
+// @Description  Returns the status (pending, running, succeeded or failed), progress and error of a long-running operation started by an endpoint answering 202 Accepted
+// @Tags         jobs
+// @Produce      json
+// @Security     BearerAuth
+// @Security     APIKeyAuth
+// @Param        id   path      int                true  "Job ID"
+// @Success      200  {object}  database.Job       "Job"
+// @Failure      400  {object}  apierror.Error     "Invalid job ID"
+// @Failure      401  {object}  apierror.Error     "Authentication required"
+// @Failure      404  {object}  apierror.Error     "Job not found"
+// @Failure      500  {object}  apierror.Error     "Server error"
+// @Router       /jobs/{id} [get]
+func GetJobHandler(c *gin.Context) {
+	user, ok := auth.RequireCurrentUser(c)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		apierror.Write(c, http.StatusBadRequest, "Invalid job ID")
+		return
+	}
+
+	job, err := database.GetDB().GetJob(c.Request.Context(), id)
+	if err != nil && !errors.Is(err, database.ErrJobNotFound) {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to get job")
+		return
+	}
+	// The jobs of the servers the user cannot view are not disclosed
+	if job != nil && job.CreatedBy != user.ID {
+		access, err := auth.LoadServerAccess(c.Request.Context(), user)
+		if err != nil {
+			apierror.Write(c, http.StatusInternalServerError, "Failed to load server memberships")
+			return
+		}
+		if !access.Has(job.ServerName, job.OwnerID, database.PermViewServer) {
+			job = nil
+		}
+	}
+	if job == nil {
+		apierror.Write(c, http.StatusNotFound, "Job not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, job)
+}
+
+// ListServerJobsHandler returns the latest jobs of a server, newest first.
+//
+// @Summary      List server jobs
+// @Description  Returns the latest long-running operations of a server, such as clones, newest first
+// @Tags         jobs
+// @Produce      json
+// @Security     BearerAuth
+// @Security     APIKeyAuth
+// @Param        serverName  path      string             true   "Server name"
+// @Param        limit       query     int                false  "Maximum number of jobs (default 20, max 100)"
+// @Success      200         {array}   database.Job       "Jobs"
+// @Failure      400         {object}  apierror.Error     "Invalid limit"
+// @Failure      401         {object}  apierror.Error     "Authentication required"
+// @Failure      403         {object}  apierror.Error     "Permission denied"
+// @Failure      500         {object}  apierror.Error     "Server error"
+// @Router       /servers/{serverName}/jobs [get]
+func ListServerJobsHandler(c *gin.Context) {
+	limit := defaultJobsLimit
+	if value := c.Query("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxJobsLimit {
+			apierror.Write(c, http.StatusBadRequest, "limit must be between 1 and "+strconv.Itoa(maxJobsLimit))
+			return
+		}
+		limit = parsed
+	}
+
+	jobs, err := database.GetDB().ListServerJobs(c.Request.Context(), c.Param("serverName"), limit)
+	if err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to list jobs")
+		return
+	}
+
+	c.JSON(http.StatusOK, jobs)
+}
+
+// jobStartFailed answers the failure to start a job, with 503 when the API is
+// shutting down so that clients retry on another replica.
+func jobStartFailed(c *gin.Context, err error, message string) {
+	if errors.Is(err, shutdown.ErrShuttingDown) {
+		apierror.Write(c, http.StatusServiceUnavailable, message+": "+err.Error())
+		return
+	}
+	apierror.Write(c, http.StatusInternalServerError, message)
+}
+
+// acceptJob answers 202 Accepted for a started job, pointing the client to its status.
+func acceptJob(c *gin.Context, job *database.Job, response gin.H) {
+	location := "/jobs/" + strconv.FormatInt(job.ID, 10)
+	response["jobId"] = job.ID
+	response["jobUrl"] = location
+	c.Header("Location", location)
+	c.JSON(http.StatusAccepted, response)
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"net/http"
+
+	"minecharts/cmd/apierror"
+	"minecharts/cmd/auth"
+	"minecharts/cmd/database"
+	"minecharts/cmd/kubernetes"
+	"minecharts/cmd/logging"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ServerLabelsRequest replaces the custom labels of a server.
+type ServerLabelsRequest struct {
+	Labels map[string]string `json:"labels" example:"{\"environment\":\"prod\",\"community\":\"xyz\"}"`
+}
+
+// SetServerLabelsHandler replaces the custom labels of a server, set on its
+// Kubernetes objects and its metrics.
+//
+// @Summary      Set server labels
+// @Description  Replaces the custom labels of a server. They are set as label.minecharts.io/<key> labels on its deployment, pods, storage and services, so that cost tools such as OpenCost can aggregate by them, and exported with the minecharts_server_label metric. The pod template follows at the next restart, without restarting the server. An empty object removes the labels
+// @Tags         servers
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Security     APIKeyAuth
+// @Param        serverName  path      string               true  "Server name"
+// @Param        request     body      ServerLabelsRequest  true  "Labels"
+// @Success      200         {object}  map[string]interface{}  "Labels updated"
+// @Failure      400         {object}  apierror.Error          "Invalid labels"
+// @Failure      401         {object}  apierror.Error          "Authentication required"
+// @Failure      403         {object}  apierror.Error          "Permission denied"
+// @Failure      404         {object}  apierror.Error          "Server not found"
+// @Failure      500         {object}  apierror.Error          "Server error"
+// @Router       /servers/{serverName}/labels [put]
+func SetServerLabelsHandler(c *gin.Context) {
+	var req ServerLabelsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Invalid(c, err)
+		return
+	}
+	if err := validateServerLabels(req.Labels); err != nil {
+		apierror.Write(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx := c.Request.Context()
+	db := database.GetDB()
+	server, err := db.GetServerByName(ctx, c.Param("serverName"))
+	if err != nil {
+		apierror.Respond(c, apierror.New(http.StatusNotFound, apierror.CodeServerNotFound, "Server not found"))
+		return
+	}
+	user, ok := auth.RequireCurrentUser(c)
+	if !ok {
+		return
+	}
+
+	spec := server.Spec
+	spec.Labels = req.Labels
+	if len(spec.Labels) == 0 {
+		spec.Labels = nil
+	}
+	if err := db.UpdateServerSpec(ctx, server.ServerName, spec); err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to update server")
+		return
+	}
+	namespace := kubernetes.ServerNamespace(server)
+	if err := kubernetes.SetServerLabels(ctx, namespace, server.DeploymentName, server.PVCName, spec.Labels); err != nil {
+		logging.Server.WithContext(ctx).WithFields(
+			"server_name", server.ServerName,
+			"error", err.Error(),
+		).Error("Failed to label server objects")
+		apierror.Write(c, http.StatusInternalServerError, "Labels saved but failed to apply them to the server: "+err.Error())
+		return
+	}
+
+	logging.Server.WithContext(ctx).WithFields(
+		"server_name", server.ServerName,
+		"labels", len(spec.Labels),
+		"user_id", user.ID,
+		"username", user.Username,
+	).Info("Server labels updated")
+
+	labels := spec.Labels
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"serverName": server.ServerName,
+		"labels":     labels,
+	})
+}
@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"minecharts/cmd/auth"
+	"minecharts/cmd/database"
+	"minecharts/cmd/kubernetes"
+	"minecharts/cmd/logging"
+
+	"github.com/gin-gonic/gin"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestMain(m *testing.M) {
+	logging.Init()
+	logging.Logger.SetOutput(io.Discard)
+	gin.SetMode(gin.TestMode)
+	os.Exit(m.Run())
+}
+
+// setupTest gives the test a throwaway SQLite database and a fake cluster.
+func setupTest(t testing.TB) database.DB {
+	t.Helper()
+	db, err := database.NewSQLiteDB(filepath.Join(t.TempDir(), "minecharts.db"), database.PoolConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Init(); err != nil {
+		t.Fatal(err)
+	}
+	database.SetDB(db)
+	kubernetes.Clientset = fake.NewSimpleClientset()
+	t.Cleanup(func() {
+		database.SetDB(nil)
+		db.Close()
+	})
+	return db
+}
+
+// createTestUser records an active user with the given permissions.
+func createTestUser(t testing.TB, db database.DB, username string, permissions int64) *database.User {
+	t.Helper()
+	user := &database.User{
+		Username:    username,
+		Email:       username + "@minecharts.local",
+		Permissions: permissions,
+		Active:      true,
+	}
+	if err := db.CreateUser(context.Background(), user); err != nil {
+		t.Fatal(err)
+	}
+	user, err := db.GetUserByID(context.Background(), user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+// createTestServer records a server owned by a user, without its resources.
+func createTestServer(t testing.TB, db database.DB, serverName string, owner *database.User) *database.MinecraftServer {
+	t.Helper()
+	server := &database.MinecraftServer{
+		ServerName:     serverName,
+		DeploymentName: "minecraft-server-" + serverName,
+		PVCName:        "minecraft-server-" + serverName + "-pvc",
+		OwnerID:        owner.ID,
+		Status:         database.ServerStatusRunning,
+	}
+	if err := db.CreateServerRecord(context.Background(), server); err != nil {
+		t.Fatal(err)
+	}
+	return server
+}
+
+// serve runs a handler on a request made by user, routed as path, and returns the
+// response.
+func serve(t *testing.T, user *database.User, method, route, path string, body interface{}, handler gin.HandlerFunc) *httptest.ResponseRecorder {
+	t.Helper()
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = bytes.NewReader(payload)
+	}
+
+	router := gin.New()
+	router.Handle(method, route, func(c *gin.Context) {
+		c.Set(auth.AuthUserKey, user)
+		c.Next()
+	}, handler)
+	req := httptest.NewRequest(method, path, reader)
+	req.Header.Set("Content-Type", "application/json")
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func expectStatus(t *testing.T, recorder *httptest.ResponseRecorder, status int) {
+	t.Helper()
+	if recorder.Code != status {
+		t.Fatalf("expected status %d, got %d: %s", status, recorder.Code, recorder.Body.String())
+	}
+}
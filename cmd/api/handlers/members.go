@@ -0,0 +1,209 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"minecharts/cmd/apierror"
+	"minecharts/cmd/auth"
+	"minecharts/cmd/database"
+	"minecharts/cmd/kubernetes"
+	"minecharts/cmd/logging"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ServerMemberRequest grants a user permissions on a server. Members can always
+// view the server; the other permissions they can get are PermExecCommand and
+// PermRestartServer.
+type ServerMemberRequest struct {
+	Username    string   `json:"username" binding:"required" example:"steve"`
+	Permissions []string `json:"permissions" example:"PermViewServer,PermRestartServer"`
+}
+
+// ServerMemberResponse is a member of a server with the names of its permissions.
+type ServerMemberResponse struct {
+	*database.ServerMember
+	PermissionNames []string `json:"permission_names" example:"PermRestartServer,PermViewServer"`
+}
+
+func serverMemberResponse(member *database.ServerMember) ServerMemberResponse {
+	return ServerMemberResponse{ServerMember: member, PermissionNames: database.PermissionFlagNames(member.Permissions)}
+}
+
+// ListServerMembersHandler lists the members of a server (its owner and admins only).
+//
+// @Summary      List server members
+// @Description  Lists the users the owner of the server granted permissions on it (owner and admins only)
+// @Tags         servers
+// @Produce      json
+// @Security     BearerAuth
+// @Security     APIKeyAuth
+// @Param        serverName  path      string                  true  "Server name"
+// @Success      200         {array}   ServerMemberResponse    "Members"
+// @Failure      401         {object}  apierror.Error          "Authentication required"
+// @Failure      403         {object}  apierror.Error          "Not the owner of the server"
+// @Failure      404         {object}  apierror.Error          "Server not found"
+// @Failure      500         {object}  apierror.Error          "Server error"
+// @Router       /servers/{serverName}/members [get]
+func ListServerMembersHandler(c *gin.Context) {
+	_, server, ok := loadOwnedServer(c)
+	if !ok {
+		return
+	}
+
+	members, err := database.GetDB().ListServerMembers(c.Request.Context(), server.ID)
+	if err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to list members")
+		return
+	}
+	response := make([]ServerMemberResponse, len(members))
+	for i, member := range members {
+		response[i] = serverMemberResponse(member)
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// AddServerMemberHandler grants a user permissions on a server (its owner and admins
+// only), replacing those granted before.
+//
+// @Summary      Add server member
+// @Description  Grants a user permissions on the server, on top of their global permissions: view, plus PermExecCommand and PermRestartServer when listed. Replaces the permissions of an existing member (owner and admins only)
+// @Tags         servers
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Security     APIKeyAuth
+// @Param        serverName  path      string                true  "Server name"
+// @Param        request     body      ServerMemberRequest   true  "User and permissions"
+// @Success      200         {object}  ServerMemberResponse  "Member saved"
+// @Failure      400         {object}  apierror.Error        "Invalid request"
+// @Failure      401         {object}  apierror.Error        "Authentication required"
+// @Failure      403         {object}  apierror.Error        "Not the owner of the server"
+// @Failure      404         {object}  apierror.Error        "Server or user not found"
+// @Failure      500         {object}  apierror.Error        "Server error"
+// @Router       /servers/{serverName}/members [post]
+func AddServerMemberHandler(c *gin.Context) {
+	user, server, ok := loadOwnedServer(c)
+	if !ok {
+		return
+	}
+
+	var req ServerMemberRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Invalid(c, err)
+		return
+	}
+	permissions := database.PermViewServer
+	for _, name := range req.Permissions {
+		permission, ok := database.PermissionNames[name]
+		if !ok || permission&^database.PermServerMember != 0 {
+			apierror.Write(c, http.StatusBadRequest, "Members can be granted PermViewServer, PermExecCommand and PermRestartServer, not "+strconv.Quote(name))
+			return
+		}
+		permissions |= permission
+	}
+
+	ctx := c.Request.Context()
+	db := database.GetDB()
+	memberUser, err := db.GetUserByUsername(ctx, req.Username)
+	if errors.Is(err, database.ErrUserNotFound) {
+		apierror.Write(c, http.StatusNotFound, "User not found")
+		return
+	}
+	if err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to get user")
+		return
+	}
+	if memberUser.ID == server.OwnerID {
+		apierror.Write(c, http.StatusBadRequest, "The owner of the server has every permission on it")
+		return
+	}
+
+	member := &database.ServerMember{ServerID: server.ID, UserID: memberUser.ID, Username: memberUser.Username, Permissions: permissions}
+	if err := db.SaveServerMember(ctx, member); err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to save member")
+		return
+	}
+
+	logging.API.WithContext(ctx).WithFields(
+		"server_name", server.ServerName,
+		"member_user_id", member.UserID,
+		"member_username", member.Username,
+		"permissions", member.Permissions,
+		"user_id", user.ID,
+	).Info("Server member saved")
+	kubernetes.RequestWhitelistSync()
+
+	c.JSON(http.StatusOK, serverMemberResponse(member))
+}
+
+// RemoveServerMemberHandler removes a user from the members of a server (its owner
+// and admins only).
+//
+// @Summary      Remove server member
+// @Description  Removes the permissions the user was granted on the server (owner and admins only)
+// @Tags         servers
+// @Produce      json
+// @Security     BearerAuth
+// @Security     APIKeyAuth
+// @Param        serverName  path      string             true  "Server name"
+// @Param        userId      path      int                true  "User ID"
+// @Success      200         {object}  map[string]string  "Member removed"
+// @Failure      400         {object}  apierror.Error     "Invalid user ID"
+// @Failure      401         {object}  apierror.Error     "Authentication required"
+// @Failure      403         {object}  apierror.Error     "Not the owner of the server"
+// @Failure      404         {object}  apierror.Error     "Server or member not found"
+// @Failure      500         {object}  apierror.Error     "Server error"
+// @Router       /servers/{serverName}/members/{userId} [delete]
+func RemoveServerMemberHandler(c *gin.Context) {
+	user, server, ok := loadOwnedServer(c)
+	if !ok {
+		return
+	}
+	memberID, err := strconv.ParseInt(c.Param("userId"), 10, 64)
+	if err != nil {
+		apierror.Write(c, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	err = database.GetDB().DeleteServerMember(c.Request.Context(), server.ID, memberID)
+	if errors.Is(err, database.ErrServerMemberNotFound) {
+		apierror.Write(c, http.StatusNotFound, "Member not found")
+		return
+	}
+	if err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to remove member")
+		return
+	}
+
+	logging.API.WithContext(c.Request.Context()).WithFields(
+		"server_name", server.ServerName,
+		"member_user_id", memberID,
+		"user_id", user.ID,
+	).Info("Server member removed")
+	kubernetes.RequestWhitelistSync()
+
+	c.JSON(http.StatusOK, gin.H{"message": "Member removed"})
+}
+
+// loadOwnedServer resolves the server of the request for the users who manage it,
+// as auth.ManagesServer. It writes the error response and returns false for the
+// other users, members included, and when the server cannot be loaded.
+func loadOwnedServer(c *gin.Context) (*database.User, *database.MinecraftServer, bool) {
+	user, ok := auth.RequireCurrentUser(c)
+	if !ok {
+		return nil, nil, false
+	}
+	server, err := database.GetDB().GetServerByName(c.Request.Context(), c.Param("serverName"))
+	if err != nil {
+		apierror.Respond(c, apierror.New(http.StatusNotFound, apierror.CodeServerNotFound, "Server not found"))
+		return nil, nil, false
+	}
+	if !auth.ManagesServer(c.Request.Context(), user, server) {
+		apierror.Write(c, http.StatusForbidden, "Only the owner of the server can manage its members")
+		return nil, nil, false
+	}
+	return user, server, true
+}
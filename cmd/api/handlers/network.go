@@ -1,10 +1,14 @@
 package handlers
 
 import (
+	"net"
 	"net/http"
+	"strconv"
 
+	"minecharts/cmd/apierror"
 	"minecharts/cmd/auth"
 	"minecharts/cmd/config"
+	"minecharts/cmd/database"
 	"minecharts/cmd/kubernetes"
 	"minecharts/cmd/logging"
 
@@ -18,12 +22,16 @@ type ExposeServerRequest struct {
 	ExposureType string `json:"exposureType" binding:"required" example:"NodePort"`
 	Domain       string `json:"domain" example:"mc.example.com"`
 	Port         int32  `json:"port" example:"25565"`
+	BedrockPort  int32  `json:"bedrockPort" example:"19132"` // UDP port of the Bedrock edition, for servers created with bedrock
+	// Restricts the pod to the API, the proxies and mc-router, and to the players for
+	// NodePort and LoadBalancer; false removes the restriction, unset keeps it as it is
+	NetworkPolicy *bool `json:"networkPolicy,omitempty" example:"true"`
 }
 
 // ExposeMinecraftServerHandler exposes a Minecraft server using the specified method.
 //
 // @Summary      Expose Minecraft server
-// @Description  Creates a Kubernetes service to expose the Minecraft server
+// @Description  Creates a Kubernetes service to expose the Minecraft server. Servers created with bedrock also get a UDP port for Bedrock clients, reported in the bedrock field of the response; bedrock servers only get that port, set with port or bedrockPort, and cannot be exposed with MCRouter. networkPolicy turns the NetworkPolicy of the server on or off, which follows the exposure type while on
 // @Tags         servers
 // @Accept       json
 // @Produce      json
@@ -32,41 +40,39 @@ type ExposeServerRequest struct {
 // @Param        serverName  path      string              true  "Server name"
 // @Param        request     body      ExposeServerRequest  true  "Exposure configuration"
 // @Success      200         {object}  map[string]interface{}  "Service created"
-// @Failure      400         {object}  map[string]string       "Invalid request"
-// @Failure      401         {object}  map[string]string       "Authentication required"
-// @Failure      403         {object}  map[string]string       "Permission denied"
-// @Failure      404         {object}  map[string]string       "Server not found"
-// @Failure      500         {object}  map[string]string       "Server error"
+// @Failure      400         {object}  apierror.Error          "Invalid request"
+// @Failure      401         {object}  apierror.Error          "Authentication required"
+// @Failure      403         {object}  apierror.Error          "Permission denied"
+// @Failure      404         {object}  apierror.Error          "Server not found"
+// @Failure      500         {object}  apierror.Error          "Server error"
 // @Router       /servers/{serverName}/expose [post]
 func ExposeMinecraftServerHandler(c *gin.Context) {
 	// Get server info from URL parameter
 	serverName := c.Param("serverName")
 	deploymentName := config.DeploymentPrefix + serverName
 
-	// Get current user for logging
-	user, _ := auth.GetCurrentUser(c)
-	userID := int64(0)
-	username := "unknown"
-	if user != nil {
-		userID = user.ID
-		username = user.Username
+	// Get current user
+	user, ok := auth.RequireCurrentUser(c)
+	if !ok {
+		return
 	}
 
-	logging.Server.WithFields(
+	logging.Server.WithContext(c.Request.Context()).WithFields(
 		"server_name", serverName,
 		"deployment", deploymentName,
-		"user_id", userID,
-		"username", username,
+		"user_id", user.ID,
+		"username", user.Username,
 		"remote_ip", c.ClientIP(),
 	).Info("Expose server request received")
 
+	namespace := serverNamespace(c)
 	// Check if the deployment exists
-	_, ok := kubernetes.CheckDeploymentExists(c, config.DefaultNamespace, deploymentName)
+	_, ok = kubernetes.CheckDeploymentExists(c, namespace, deploymentName)
 	if !ok {
-		logging.Server.WithFields(
+		logging.Server.WithContext(c.Request.Context()).WithFields(
 			"server_name", serverName,
 			"deployment", deploymentName,
-			"user_id", userID,
+			"user_id", user.ID,
 			"error", "deployment_not_found",
 		).Warn("Server exposure failed: deployment not found")
 		return
@@ -76,17 +82,17 @@ func ExposeMinecraftServerHandler(c *gin.Context) {
 	var req ExposeServerRequest
 
 	if err := c.ShouldBindJSON(&req); err != nil {
-		logging.API.InvalidRequest.WithFields(
+		logging.API.InvalidRequest.WithContext(c.Request.Context()).WithFields(
 			"server_name", serverName,
 			"deployment", deploymentName,
-			"user_id", userID,
+			"user_id", user.ID,
 			"error", err.Error(),
 		).Warn("Server exposure failed: invalid request body")
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		apierror.Write(c, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	logging.Server.WithFields(
+	logging.Server.WithContext(c.Request.Context()).WithFields(
 		"server_name", serverName,
 		"deployment", deploymentName,
 		"exposure_type", req.ExposureType,
@@ -99,50 +105,70 @@ func ExposeMinecraftServerHandler(c *gin.Context) {
 		req.ExposureType != "NodePort" &&
 		req.ExposureType != "LoadBalancer" &&
 		req.ExposureType != "MCRouter" {
-		logging.API.InvalidRequest.WithFields(
+		logging.API.InvalidRequest.WithContext(c.Request.Context()).WithFields(
 			"server_name", serverName,
 			"deployment", deploymentName,
 			"exposure_type", req.ExposureType,
-			"user_id", userID,
+			"user_id", user.ID,
 			"error", "invalid_exposure_type",
 		).Warn("Server exposure failed: invalid exposure type")
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Invalid exposureType. Must be one of: ClusterIP, NodePort, LoadBalancer, MCRouter",
-		})
+		apierror.Write(c, http.StatusBadRequest, "Invalid exposureType. Must be one of: ClusterIP, NodePort, LoadBalancer, MCRouter")
 		return
 	}
 
 	// Domain is required for MCRouter
 	if req.ExposureType == "MCRouter" && req.Domain == "" {
-		logging.API.InvalidRequest.WithFields(
+		logging.API.InvalidRequest.WithContext(c.Request.Context()).WithFields(
 			"server_name", serverName,
 			"deployment", deploymentName,
 			"exposure_type", req.ExposureType,
-			"user_id", userID,
+			"user_id", user.ID,
 			"error", "missing_domain",
 		).Warn("Server exposure failed: domain required for MCRouter")
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Domain is required for MCRouter exposure type",
-		})
+		apierror.Write(c, http.StatusBadRequest, "Domain is required for MCRouter exposure type")
+		return
+	}
+
+	// Bedrock servers only listen on their UDP port, which mc-router cannot route
+	server, err := database.GetDB().GetServerByName(c.Request.Context(), serverName)
+	bedrockServer := err == nil && isBedrock(server.Spec)
+	if bedrockServer && req.ExposureType == "MCRouter" {
+		apierror.Write(c, http.StatusBadRequest, "MCRouter only routes Java clients, expose bedrock servers with ClusterIP, NodePort or LoadBalancer")
 		return
 	}
 
 	// Use default Minecraft port if not provided
-	if req.Port <= 0 {
-		logging.Server.Debug("Using default Minecraft port 25565")
+	if req.Port <= 0 && !bedrockServer {
+		logging.Server.WithContext(c.Request.Context()).Debug("Using default Minecraft port 25565")
 		req.Port = 25565
 	}
 
+	// Servers running Geyser are also exposed to Bedrock clients, and bedrock
+	// servers only to them, on the port of the request
+	bedrockPort := int32(0)
+	if bedrockServer || (err == nil && server.Spec.Bedrock) {
+		bedrockPort = req.BedrockPort
+		if bedrockServer {
+			if bedrockPort <= 0 {
+				bedrockPort = req.Port
+			}
+			req.Port = 0
+		}
+		if bedrockPort <= 0 {
+			bedrockPort = kubernetes.BedrockPort
+		}
+	}
+
 	// Service name will be consistent
 	serviceName := deploymentName + "-svc"
 
 	// Clean up any existing services for this deployment
 	// Ignore errors in case the resources don't exist yet
-	logging.Server.WithFields(
+	logging.Server.WithContext(c.Request.Context()).WithFields(
 		"server_name", serverName,
 		"service", serviceName,
 	).Debug("Cleaning up any existing services")
-	_ = kubernetes.DeleteService(config.DefaultNamespace, serviceName)
+	_ = kubernetes.DeleteService(c.Request.Context(), namespace, serviceName)
 
 	// Create appropriate service based on exposure type
 	var serviceType corev1.ServiceType
@@ -155,46 +181,68 @@ func ExposeMinecraftServerHandler(c *gin.Context) {
 		serviceType = corev1.ServiceTypeLoadBalancer
 	case "MCRouter":
 		serviceType = corev1.ServiceTypeClusterIP
-		annotations["mc-router.itzg.me/externalServerName"] = req.Domain
+		annotations[kubernetes.MCRouterAnnotation] = req.Domain
 	default:
 		serviceType = corev1.ServiceTypeClusterIP
 	}
 
-	logging.Server.WithFields(
+	logging.Server.WithContext(c.Request.Context()).WithFields(
 		"server_name", serverName,
 		"service", serviceName,
 		"exposure_type", req.ExposureType,
 		"service_type", string(serviceType),
 		"port", req.Port,
+		"bedrock_port", bedrockPort,
 	).Info("Creating Kubernetes service")
 
 	// Create the service
-	service, err := kubernetes.CreateService(config.DefaultNamespace, deploymentName, serviceType, req.Port, annotations)
+	service, err := kubernetes.CreateService(c.Request.Context(), namespace, deploymentName, serviceType, req.Port, bedrockPort, annotations)
 	if err != nil {
-		logging.Server.WithFields(
+		logging.Server.WithContext(c.Request.Context()).WithFields(
 			"server_name", serverName,
 			"service", serviceName,
 			"exposure_type", req.ExposureType,
-			"user_id", userID,
+			"user_id", user.ID,
 			"error", err.Error(),
 		).Error("Failed to create service")
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to create service: " + err.Error(),
-		})
+		apierror.Write(c, http.StatusInternalServerError, "Failed to create service: "+err.Error())
+		return
+	}
+
+	// The policy follows the exposure, the game ports are opened to the players with it
+	restricted := req.NetworkPolicy != nil && *req.NetworkPolicy
+	if req.NetworkPolicy == nil {
+		if restricted, err = kubernetes.HasNetworkPolicy(c.Request.Context(), namespace, deploymentName); err != nil {
+			apierror.Write(c, http.StatusInternalServerError, "Failed to get network policy: "+err.Error())
+			return
+		}
+	}
+	if restricted {
+		var spec database.ServerSpec
+		if server != nil {
+			spec = server.Spec
+		}
+		err = kubernetes.ApplyNetworkPolicy(c.Request.Context(), namespace, deploymentName, serverPolicy(spec, req.ExposureType))
+	} else if req.NetworkPolicy != nil {
+		err = kubernetes.DeleteNetworkPolicy(c.Request.Context(), namespace, deploymentName)
+	}
+	if err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Service created, but failed to update network policy: "+err.Error())
 		return
 	}
 
 	response := gin.H{
-		"message":      "Service created",
-		"serviceName":  service.Name,
-		"exposureType": req.ExposureType,
-		"serviceType":  string(serviceType),
+		"message":       "Service created",
+		"serviceName":   service.Name,
+		"exposureType":  req.ExposureType,
+		"serviceType":   string(serviceType),
+		"networkPolicy": restricted,
 	}
 
 	// Add service-specific information to response
 	switch req.ExposureType {
 	case "NodePort":
-		if len(service.Spec.Ports) > 0 && service.Spec.Ports[0].NodePort > 0 {
+		if len(service.Spec.Ports) > 0 && service.Spec.Ports[0].NodePort > 0 && req.Port > 0 {
 			response["nodePort"] = service.Spec.Ports[0].NodePort
 		}
 	case "LoadBalancer":
@@ -215,14 +263,61 @@ func ExposeMinecraftServerHandler(c *gin.Context) {
 		response["note"] = "MCRouter configuration created. Make sure mc-router is deployed in your cluster."
 	}
 
-	logging.Server.WithFields(
+	if bedrockPort > 0 {
+		response["bedrock"] = bedrockEndpoint(service, req.ExposureType, bedrockPort)
+	}
+
+	logging.Server.WithContext(c.Request.Context()).WithFields(
 		"server_name", serverName,
 		"service", serviceName,
 		"exposure_type", req.ExposureType,
 		"service_type", string(serviceType),
-		"user_id", userID,
-		"username", username,
+		"user_id", user.ID,
+		"username", user.Username,
 	).Info("Server exposure completed successfully")
 
 	c.JSON(http.StatusOK, response)
 }
+
+// serverPolicy returns who may reach the pod of a server exposed with a service of
+// the exposure type, empty while it is not exposed.
+func serverPolicy(spec database.ServerSpec, exposureType string) kubernetes.ServerPolicy {
+	return kubernetes.ServerPolicy{
+		Java:    !isBedrock(spec),
+		Bedrock: isBedrock(spec) || spec.Bedrock,
+		Public:  exposureType == "NodePort" || exposureType == "LoadBalancer",
+	}
+}
+
+// bedrockEndpoint describes where Bedrock clients join a server exposed by a service.
+func bedrockEndpoint(service *corev1.Service, exposureType string, bedrockPort int32) gin.H {
+	endpoint := gin.H{
+		"protocol": "UDP",
+		"port":     bedrockPort,
+	}
+	for _, port := range service.Spec.Ports {
+		if port.Name != "bedrock" {
+			continue
+		}
+		switch exposureType {
+		case "NodePort":
+			if port.NodePort > 0 {
+				endpoint["nodePort"] = port.NodePort
+			}
+		case "LoadBalancer":
+			if len(service.Status.LoadBalancer.Ingress) > 0 {
+				host := service.Status.LoadBalancer.Ingress[0].IP
+				if host == "" {
+					host = service.Status.LoadBalancer.Ingress[0].Hostname
+				}
+				endpoint["address"] = net.JoinHostPort(host, strconv.Itoa(int(bedrockPort)))
+			} else {
+				endpoint["address"] = "pending"
+			}
+		case "MCRouter":
+			// mc-router only routes the Java edition by host name
+			endpoint["note"] = "mc-router does not route Bedrock clients, expose the server with NodePort or LoadBalancer for them to join"
+		}
+	}
+	return endpoint
+}
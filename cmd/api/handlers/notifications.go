@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"minecharts/cmd/apierror"
+	"minecharts/cmd/auth"
+	"minecharts/cmd/database"
+	"minecharts/cmd/logging"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ListNotificationsHandler returns the current user's notifications.
+//
+// @Summary      List notifications
+// @Description  Returns the notifications of the authenticated user, newest first
+// @Tags         notifications
+// @Produce      json
+// @Security     BearerAuth
+// @Security     APIKeyAuth
+// @Param        unread  query     bool                    false  "Only return unread notifications"
+// @Success      200     {array}   database.Notification   "List of notifications"
+// @Failure      401     {object}  apierror.Error          "Authentication required"
+// @Failure      500     {object}  apierror.Error          "Server error"
+// @Router       /notifications [get]
+func ListNotificationsHandler(c *gin.Context) {
+	user, ok := auth.GetCurrentUser(c)
+	if !ok {
+		apierror.Write(c, http.StatusUnauthorized, "Authentication required")
+		return
+	}
+
+	unreadOnly := c.Query("unread") == "true"
+
+	db := database.GetDB()
+	notifications, err := db.ListNotificationsByUser(c.Request.Context(), user.ID, unreadOnly)
+	if err != nil {
+		logging.DB.WithContext(c.Request.Context()).WithFields(
+			"user_id", user.ID,
+			"error", err.Error(),
+		).Error("Failed to list notifications")
+		apierror.Write(c, http.StatusInternalServerError, "Failed to list notifications")
+		return
+	}
+
+	c.JSON(http.StatusOK, notifications)
+}
+
+// MarkNotificationReadHandler marks one of the current user's notifications as read.
+//
+// @Summary      Mark notification as read
+// @Description  Marks a notification of the authenticated user as read
+// @Tags         notifications
+// @Produce      json
+// @Security     BearerAuth
+// @Security     APIKeyAuth
+// @Param        id   path      int                true  "Notification ID"
+// @Success      200  {object}  map[string]string  "Notification marked as read"
+// @Failure      400  {object}  apierror.Error     "Invalid notification ID"
+// @Failure      401  {object}  apierror.Error     "Authentication required"
+// @Failure      404  {object}  apierror.Error     "Notification not found"
+// @Failure      500  {object}  apierror.Error     "Server error"
+// @Router       /notifications/{id}/read [post]
+func MarkNotificationReadHandler(c *gin.Context) {
+	user, ok := auth.GetCurrentUser(c)
+	if !ok {
+		apierror.Write(c, http.StatusUnauthorized, "Authentication required")
+		return
+	}
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		apierror.Write(c, http.StatusBadRequest, "Invalid notification ID")
+		return
+	}
+
+	db := database.GetDB()
+	if err := db.MarkNotificationRead(c.Request.Context(), user.ID, id); err != nil {
+		if errors.Is(err, database.ErrNotificationNotFound) {
+			apierror.Write(c, http.StatusNotFound, "Notification not found")
+			return
+		}
+		logging.DB.WithContext(c.Request.Context()).WithFields(
+			"user_id", user.ID,
+			"notification_id", id,
+			"error", err.Error(),
+		).Error("Failed to mark notification as read")
+		apierror.Write(c, http.StatusInternalServerError, "Failed to update notification")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Notification marked as read"})
+}
+
+// notifyUser stores a notification for a user. Failures are logged but never
+// block the operation that triggered the notification.
+func notifyUser(ctx context.Context, userID int64, message string) {
+	notification := &database.Notification{
+		UserID:  userID,
+		Message: message,
+	}
+	if err := database.GetDB().CreateNotification(ctx, notification); err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"user_id", userID,
+			"error", err.Error(),
+		).Warn("Failed to create notification")
+	}
+}
+
+// notifyAdmins stores a notification for every active administrator.
+func notifyAdmins(ctx context.Context, message string) {
+	users, err := database.GetDB().ListUsers(ctx)
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"error", err.Error(),
+		).Warn("Failed to list users for admin notification")
+		return
+	}
+	for _, u := range users {
+		if u.Active && u.IsAdmin() {
+			notifyUser(ctx, u.ID, message)
+		}
+	}
+}
@@ -0,0 +1,262 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	"minecharts/cmd/apierror"
+	"minecharts/cmd/auth"
+	"minecharts/cmd/config"
+	"minecharts/cmd/database"
+	"minecharts/cmd/logging"
+	"minecharts/cmd/security"
+
+	"github.com/gin-gonic/gin"
+)
+
+// oauthLinkPurpose is the purpose of the nonces holding the OAuth accounts offered
+// for linking to the user owning their email.
+const oauthLinkPurpose = "oauth-link"
+
+// ConfirmOAuthLinkRequest holds the link token of an OAuth account offered for linking.
+type ConfirmOAuthLinkRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// pendingOAuthLink is an OAuth account whose login matched the verified email of a
+// user, linked once the user confirms it.
+type pendingOAuthLink struct {
+	Provider string `json:"provider"`
+	Subject  string `json:"subject"`
+	Email    string `json:"email"`
+	Username string `json:"username"`
+}
+
+// ListOAuthIdentitiesHandler lists the OAuth accounts linked to the current user.
+//
+// @Summary      List linked OAuth accounts
+// @Description  Lists the accounts at OAuth providers the current user can log in with
+// @Tags         auth
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200  {array}   database.OAuthIdentity  "Linked accounts"
+// @Failure      401  {object}  apierror.Error          "Authentication required"
+// @Failure      500  {object}  apierror.Error          "Server error"
+// @Router       /auth/me/oauth [get]
+func ListOAuthIdentitiesHandler(c *gin.Context) {
+	user, ok := auth.RequireCurrentUser(c)
+	if !ok {
+		return
+	}
+
+	identities, err := database.GetDB().ListUserOAuthIdentities(c.Request.Context(), user.ID)
+	if err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to list linked accounts")
+		return
+	}
+	c.JSON(http.StatusOK, identities)
+}
+
+// LinkOAuthProviderHandler starts linking an account at an OAuth provider to the
+// current user. The frontend sends the user to the returned URL, and the callback
+// of the provider links the account they log in with.
+//
+// @Summary      Link OAuth account
+// @Description  Returns the URL of the provider to log in with the account to link. The callback of the provider links it to the current user
+// @Tags         auth
+// @Produce      json
+// @Security     BearerAuth
+// @Param        provider  path      string             true  "OAuth provider: authentik, github or google"
+// @Success      200       {object}  map[string]string  "URL of the provider"
+// @Failure      400       {object}  apierror.Error     "OAuth not enabled or invalid provider"
+// @Failure      401       {object}  apierror.Error     "Authentication required"
+// @Failure      500       {object}  apierror.Error     "Server error"
+// @Router       /auth/me/oauth/{provider} [post]
+func LinkOAuthProviderHandler(c *gin.Context) {
+	user, ok := auth.RequireCurrentUser(c)
+	if !ok {
+		return
+	}
+	if !config.Live().OAuthEnabled {
+		apierror.Write(c, http.StatusBadRequest, "OAuth is not enabled")
+		return
+	}
+
+	provider := c.Param("provider")
+	oauthProvider, ok := getOAuthProvider(c, provider)
+	if !ok {
+		return
+	}
+	authURL, ok := startOAuthFlow(c, oauthProvider, oauthState{Provider: provider, UserID: user.ID})
+	if !ok {
+		return
+	}
+
+	logging.Auth.OAuth.WithContext(c.Request.Context()).WithFields("remote_ip", c.ClientIP(), "provider", provider, "user_id", user.ID, "username", user.Username).
+		Info("OAuth account linking initiated")
+
+	c.JSON(http.StatusOK, gin.H{"authUrl": authURL})
+}
+
+// ConfirmOAuthLinkHandler links the OAuth account offered to the current user when
+// its login matched their verified email.
+//
+// @Summary      Confirm OAuth account link
+// @Description  Links the OAuth account whose login was refused because the current user owns its verified email, with the link token the callback redirected to the frontend with
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        provider  path      string                   true  "OAuth provider: authentik, github or google"
+// @Param        request   body      ConfirmOAuthLinkRequest  true  "Link token"
+// @Success      200       {object}  map[string]string        "Account linked"
+// @Failure      400       {object}  apierror.Error           "Invalid or expired token"
+// @Failure      401       {object}  apierror.Error           "Authentication required"
+// @Failure      409       {object}  apierror.Error           "Account or provider already linked"
+// @Failure      500       {object}  apierror.Error           "Server error"
+// @Router       /auth/me/oauth/{provider}/confirm [post]
+func ConfirmOAuthLinkHandler(c *gin.Context) {
+	var req ConfirmOAuthLinkRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Invalid(c, err)
+		return
+	}
+	user, ok := auth.RequireCurrentUser(c)
+	if !ok {
+		return
+	}
+
+	provider := c.Param("provider")
+	// Tokens are scoped to the user owning the email, so that no other account can use them
+	data, err := database.GetDB().ConsumeNonce(c.Request.Context(), oauthLinkPurpose, linkCodeNonce(user.ID, req.Token))
+	var link pendingOAuthLink
+	if err == nil {
+		err = json.Unmarshal([]byte(data), &link)
+	}
+	if errors.Is(err, database.ErrNonceNotFound) || err == nil && link.Provider != provider {
+		logging.Auth.OAuth.WithContext(c.Request.Context()).WithFields("remote_ip", c.ClientIP(), "provider", provider, "user_id", user.ID, "reason", "invalid_link_token").
+			Warn("OAuth account link failed: invalid token")
+		security.NewEvent(c, security.AuthFailure, "oauth_link").WithReason("invalid_link_token").WithDetail("provider", provider).Emit()
+		apierror.Write(c, http.StatusBadRequest, "Invalid or expired link token")
+		return
+	}
+	if err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to verify link token")
+		return
+	}
+
+	linkOAuthIdentity(c, user, &auth.OAuthUserInfo{
+		ID:       link.Subject,
+		Email:    link.Email,
+		Username: link.Username,
+		Provider: link.Provider,
+	}, func() {
+		c.JSON(http.StatusOK, gin.H{"message": "OAuth account linked", "provider": provider})
+	})
+}
+
+// UnlinkOAuthProviderHandler removes the account at an OAuth provider linked to the
+// current user.
+//
+// @Summary      Unlink OAuth account
+// @Description  Removes the account at the provider linked to the current user, who can no longer log in with it
+// @Tags         auth
+// @Produce      json
+// @Security     BearerAuth
+// @Param        provider  path      string             true  "OAuth provider: authentik, github or google"
+// @Success      200       {object}  map[string]string  "Account unlinked"
+// @Failure      401       {object}  apierror.Error     "Authentication required"
+// @Failure      404       {object}  apierror.Error     "No account of the provider linked"
+// @Failure      500       {object}  apierror.Error     "Server error"
+// @Router       /auth/me/oauth/{provider} [delete]
+func UnlinkOAuthProviderHandler(c *gin.Context) {
+	user, ok := auth.RequireCurrentUser(c)
+	if !ok {
+		return
+	}
+
+	provider := c.Param("provider")
+	err := database.GetDB().DeleteOAuthIdentity(c.Request.Context(), user.ID, provider)
+	if errors.Is(err, database.ErrOAuthIdentityNotFound) {
+		apierror.Write(c, http.StatusNotFound, "No "+provider+" account linked")
+		return
+	}
+	if err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to unlink OAuth account")
+		return
+	}
+
+	logging.Auth.OAuth.WithContext(c.Request.Context()).WithFields("remote_ip", c.ClientIP(), "provider", provider, "user_id", user.ID, "username", user.Username).
+		Info("OAuth account unlinked")
+	c.JSON(http.StatusOK, gin.H{"message": "OAuth account unlinked"})
+}
+
+// completeOAuthLink links the account of an OAuth callback to the user who started
+// linking it, and sends them back to the frontend.
+func completeOAuthLink(c *gin.Context, userID int64, userInfo *auth.OAuthUserInfo) {
+	user, err := database.GetDB().GetUserByID(c.Request.Context(), userID)
+	if err != nil || !user.Active {
+		logging.Auth.OAuth.WithContext(c.Request.Context()).WithFields("remote_ip", c.ClientIP(), "provider", userInfo.Provider, "user_id", userID, "reason", "user_unavailable").
+			Warn("OAuth account link failed: linking user not found or inactive")
+		apierror.Write(c, http.StatusBadRequest, "The account linking the provider is no longer available")
+		return
+	}
+
+	linkOAuthIdentity(c, user, userInfo, func() {
+		c.Redirect(http.StatusTemporaryRedirect, config.Live().FrontendURL+"/oauth-callback?linked="+url.QueryEscape(userInfo.Provider))
+	})
+}
+
+// offerOAuthLink answers the login with an OAuth account whose email belongs to a
+// user. When the provider verified the email, the frontend gets a token the user
+// confirms the link with once logged in to their account.
+func offerOAuthLink(c *gin.Context, user *database.User, userInfo *auth.OAuthUserInfo) {
+	security.NewEvent(c, security.AuthFailure, "oauth_callback").WithReason("email_taken").WithDetail("provider", userInfo.Provider).Emit()
+	if !userInfo.EmailVerified {
+		apierror.Write(c, http.StatusConflict, "An account already uses this email: log in to it and link "+userInfo.Provider+" from the account")
+		return
+	}
+
+	token, err := GenerateStateValue()
+	if err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to generate link token")
+		return
+	}
+	data, _ := json.Marshal(pendingOAuthLink{
+		Provider: userInfo.Provider,
+		Subject:  userInfo.ID,
+		Email:    userInfo.Email,
+		Username: userInfo.Username,
+	})
+	if err := database.GetDB().CreateNonce(c.Request.Context(), oauthLinkPurpose, linkCodeNonce(user.ID, token), string(data), time.Now().Add(config.OAuthStateTTL)); err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to store link token")
+		return
+	}
+
+	logging.Auth.OAuth.WithContext(c.Request.Context()).WithFields("remote_ip", c.ClientIP(), "provider", userInfo.Provider, "user_id", user.ID).
+		Info("OAuth login matched the verified email of a user, link offered")
+
+	query := url.Values{"link_token": {token}, "provider": {userInfo.Provider}}
+	c.Redirect(http.StatusTemporaryRedirect, config.Live().FrontendURL+"/oauth-callback?"+query.Encode())
+}
+
+// linkOAuthIdentity links an OAuth account to a user and calls done, or answers why
+// it cannot be linked.
+func linkOAuthIdentity(c *gin.Context, user *database.User, userInfo *auth.OAuthUserInfo, done func()) {
+	err := auth.LinkOAuthIdentity(c.Request.Context(), user.ID, userInfo)
+	if errors.Is(err, database.ErrOAuthIdentityLinked) {
+		apierror.Write(c, http.StatusConflict, "This "+userInfo.Provider+" account is linked to a user already, or the user has another one linked")
+		return
+	}
+	if err != nil {
+		logging.Auth.OAuth.WithContext(c.Request.Context()).WithFields("remote_ip", c.ClientIP(), "provider", userInfo.Provider, "user_id", user.ID, "error", err.Error()).
+			Error("Failed to link OAuth account")
+		apierror.Write(c, http.StatusInternalServerError, "Failed to link OAuth account")
+		return
+	}
+
+	done()
+}
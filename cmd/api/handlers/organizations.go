@@ -0,0 +1,556 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"minecharts/cmd/apierror"
+	"minecharts/cmd/auth"
+	"minecharts/cmd/database"
+	"minecharts/cmd/kubernetes"
+	"minecharts/cmd/logging"
+
+	"github.com/gin-gonic/gin"
+)
+
+// OrganizationRequest creates or renames an organization.
+type OrganizationRequest struct {
+	Name        string `json:"name" binding:"required,min=1,max=50" example:"blocky-friends"`
+	Description string `json:"description" example:"The servers of the Blocky Friends community"`
+}
+
+// OrganizationMemberRequest adds a user to an organization, or changes their role.
+type OrganizationMemberRequest struct {
+	Username string `json:"username" binding:"required" example:"steve"`
+	Role     string `json:"role" binding:"required,oneof=member admin owner" example:"member"`
+}
+
+// ServerOrganizationRequest moves a server into an organization, or back to the
+// user who created it with an organizationId of 0.
+type ServerOrganizationRequest struct {
+	OrganizationID int64 `json:"organizationId" example:"1"`
+}
+
+// OrganizationResponse is an organization with its members and servers.
+type OrganizationResponse struct {
+	*database.Organization
+	Members []*database.OrganizationMember `json:"members"`
+	Servers []ServerSummary                `json:"servers"`
+}
+
+// ListOrganizationsHandler lists the organizations of the current user, or every
+// organization for admins.
+//
+// @Summary      List organizations
+// @Description  Lists the organizations of the current user with their role in each, or every organization for admins
+// @Tags         organizations
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200  {array}   database.Organization  "Organizations"
+// @Failure      401  {object}  apierror.Error         "Authentication required"
+// @Failure      500  {object}  apierror.Error         "Server error"
+// @Router       /organizations [get]
+func ListOrganizationsHandler(c *gin.Context) {
+	user, ok := auth.RequireCurrentUser(c)
+	if !ok {
+		return
+	}
+
+	db := database.GetDB()
+	var orgs []*database.Organization
+	var err error
+	if user.IsAdmin() {
+		orgs, err = db.ListOrganizations(c.Request.Context())
+	} else {
+		orgs, err = db.ListUserOrganizations(c.Request.Context(), user.ID)
+	}
+	if err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to list organizations")
+		return
+	}
+	c.JSON(http.StatusOK, orgs)
+}
+
+// CreateOrganizationHandler creates an organization owned by the current user.
+//
+// @Summary      Create organization
+// @Description  Creates an organization, of which the current user becomes the owner
+// @Tags         organizations
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        request  body      OrganizationRequest    true  "Organization"
+// @Success      201      {object}  database.Organization  "Organization created"
+// @Failure      400      {object}  apierror.Error         "Invalid request"
+// @Failure      401      {object}  apierror.Error         "Authentication required"
+// @Failure      409      {object}  apierror.Error         "Organization name already exists"
+// @Failure      500      {object}  apierror.Error         "Server error"
+// @Router       /organizations [post]
+func CreateOrganizationHandler(c *gin.Context) {
+	user, ok := auth.RequireCurrentUser(c)
+	if !ok {
+		return
+	}
+	var req OrganizationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Invalid(c, err)
+		return
+	}
+
+	org := &database.Organization{Name: strings.TrimSpace(req.Name), Description: req.Description}
+	if err := database.GetDB().CreateOrganization(c.Request.Context(), org, user.ID); err != nil {
+		if errors.Is(err, database.ErrOrganizationExists) {
+			apierror.Write(c, http.StatusConflict, "An organization with this name already exists")
+		} else {
+			apierror.Write(c, http.StatusInternalServerError, "Failed to create organization")
+		}
+		return
+	}
+
+	logging.API.WithContext(c.Request.Context()).WithFields(
+		"org_id", org.ID,
+		"org_name", org.Name,
+		"user_id", user.ID,
+		"username", user.Username,
+	).Info("Organization created")
+
+	c.JSON(http.StatusCreated, org)
+}
+
+// GetOrganizationHandler returns an organization with its members and servers
+// (its members and admins only).
+//
+// @Summary      Get organization
+// @Description  Returns an organization with its members and the servers it owns (its members and admins only)
+// @Tags         organizations
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id   path      int                   true  "Organization ID"
+// @Success      200  {object}  OrganizationResponse  "Organization"
+// @Failure      400  {object}  apierror.Error        "Invalid organization ID"
+// @Failure      401  {object}  apierror.Error        "Authentication required"
+// @Failure      404  {object}  apierror.Error        "Organization not found"
+// @Failure      500  {object}  apierror.Error        "Server error"
+// @Router       /organizations/{id} [get]
+func GetOrganizationHandler(c *gin.Context) {
+	_, org, member, ok := loadOrganization(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	db := database.GetDB()
+	members, err := db.ListOrganizationMembers(ctx, org.ID)
+	if err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to list organization members")
+		return
+	}
+	servers, err := db.ListServersByOrganization(ctx, org.ID)
+	if err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to list organization servers")
+		return
+	}
+
+	if member != nil {
+		org.Role = member.Role
+	}
+	response := OrganizationResponse{Organization: org, Members: members, Servers: []ServerSummary{}}
+	for _, server := range servers {
+		response.Servers = append(response.Servers, serverSummary(server))
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// UpdateOrganizationHandler renames an organization (its owners and admins only).
+//
+// @Summary      Update organization
+// @Description  Changes the name and description of an organization (its owners and admins only)
+// @Tags         organizations
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id       path      int                    true  "Organization ID"
+// @Param        request  body      OrganizationRequest    true  "Organization"
+// @Success      200      {object}  database.Organization  "Organization updated"
+// @Failure      400      {object}  apierror.Error         "Invalid request"
+// @Failure      401      {object}  apierror.Error         "Authentication required"
+// @Failure      403      {object}  apierror.Error         "Not an owner of the organization"
+// @Failure      404      {object}  apierror.Error         "Organization not found"
+// @Failure      409      {object}  apierror.Error         "Organization name already exists"
+// @Failure      500      {object}  apierror.Error         "Server error"
+// @Router       /organizations/{id} [put]
+func UpdateOrganizationHandler(c *gin.Context) {
+	user, org, member, ok := loadOrganization(c)
+	if !ok {
+		return
+	}
+	if !ownsOrganization(c, user, member) {
+		return
+	}
+	var req OrganizationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Invalid(c, err)
+		return
+	}
+
+	org.Name, org.Description = strings.TrimSpace(req.Name), req.Description
+	if err := database.GetDB().UpdateOrganization(c.Request.Context(), org); err != nil {
+		switch {
+		case errors.Is(err, database.ErrOrganizationExists):
+			apierror.Write(c, http.StatusConflict, "An organization with this name already exists")
+		case errors.Is(err, database.ErrOrganizationNotFound):
+			apierror.Write(c, http.StatusNotFound, "Organization not found")
+		default:
+			apierror.Write(c, http.StatusInternalServerError, "Failed to update organization")
+		}
+		return
+	}
+
+	logging.API.WithContext(c.Request.Context()).WithFields(
+		"org_id", org.ID,
+		"org_name", org.Name,
+		"user_id", user.ID,
+	).Info("Organization updated")
+
+	c.JSON(http.StatusOK, org)
+}
+
+// DeleteOrganizationHandler deletes an organization (its owners and admins only).
+// Its servers go back to the users who created them.
+//
+// @Summary      Delete organization
+// @Description  Deletes an organization; the servers it owns go back to the users who created them (its owners and admins only)
+// @Tags         organizations
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id   path      int                true  "Organization ID"
+// @Success      200  {object}  map[string]string  "Organization deleted"
+// @Failure      400  {object}  apierror.Error     "Invalid organization ID"
+// @Failure      401  {object}  apierror.Error     "Authentication required"
+// @Failure      403  {object}  apierror.Error     "Not an owner of the organization"
+// @Failure      404  {object}  apierror.Error     "Organization not found"
+// @Failure      500  {object}  apierror.Error     "Server error"
+// @Router       /organizations/{id} [delete]
+func DeleteOrganizationHandler(c *gin.Context) {
+	user, org, member, ok := loadOrganization(c)
+	if !ok {
+		return
+	}
+	if !ownsOrganization(c, user, member) {
+		return
+	}
+
+	if err := database.GetDB().DeleteOrganization(c.Request.Context(), org.ID); err != nil {
+		if errors.Is(err, database.ErrOrganizationNotFound) {
+			apierror.Write(c, http.StatusNotFound, "Organization not found")
+		} else {
+			apierror.Write(c, http.StatusInternalServerError, "Failed to delete organization")
+		}
+		return
+	}
+
+	logging.API.WithContext(c.Request.Context()).WithFields(
+		"org_id", org.ID,
+		"org_name", org.Name,
+		"user_id", user.ID,
+	).Info("Organization deleted")
+	kubernetes.RequestWhitelistSync()
+
+	c.JSON(http.StatusOK, gin.H{"message": "Organization deleted"})
+}
+
+// SaveOrganizationMemberHandler adds a user to an organization, or changes their
+// role (its admins and owners only; only owners manage the owners).
+//
+// @Summary      Add organization member
+// @Description  Adds a user to the organization with a role, member, admin or owner, or changes the role of a member. Admins of the organization manage the members and admins, its owners also the owners
+// @Tags         organizations
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id       path      int                          true  "Organization ID"
+// @Param        request  body      OrganizationMemberRequest    true  "User and role"
+// @Success      200      {object}  database.OrganizationMember  "Member saved"
+// @Failure      400      {object}  apierror.Error               "Invalid request"
+// @Failure      401      {object}  apierror.Error               "Authentication required"
+// @Failure      403      {object}  apierror.Error               "Not allowed to manage this member"
+// @Failure      404      {object}  apierror.Error               "Organization or user not found"
+// @Failure      409      {object}  apierror.Error               "The organization would have no owner left"
+// @Failure      500      {object}  apierror.Error               "Server error"
+// @Router       /organizations/{id}/members [post]
+func SaveOrganizationMemberHandler(c *gin.Context) {
+	user, org, member, ok := loadOrganization(c)
+	if !ok {
+		return
+	}
+	var req OrganizationMemberRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Invalid(c, err)
+		return
+	}
+
+	ctx := c.Request.Context()
+	db := database.GetDB()
+	target, err := db.GetUserByUsername(ctx, req.Username)
+	if errors.Is(err, database.ErrUserNotFound) {
+		apierror.Write(c, http.StatusNotFound, "User not found")
+		return
+	}
+	if err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to get user")
+		return
+	}
+	current, err := db.GetOrganizationMember(ctx, org.ID, target.ID)
+	if err != nil && !errors.Is(err, database.ErrOrgMemberNotFound) {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to get organization member")
+		return
+	}
+	if !canManageOrgMember(c, user, member, current, req.Role) {
+		return
+	}
+	if current != nil && current.Role == database.OrgRoleOwner && req.Role != database.OrgRoleOwner && !hasOtherOwner(c, org.ID, target.ID) {
+		return
+	}
+
+	saved := &database.OrganizationMember{OrgID: org.ID, UserID: target.ID, Username: target.Username, Role: req.Role}
+	if err := db.SaveOrganizationMember(ctx, saved); err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to save organization member")
+		return
+	}
+
+	logging.API.WithContext(ctx).WithFields(
+		"org_id", org.ID,
+		"member_user_id", saved.UserID,
+		"member_username", saved.Username,
+		"role", saved.Role,
+		"user_id", user.ID,
+	).Info("Organization member saved")
+	kubernetes.RequestWhitelistSync()
+
+	c.JSON(http.StatusOK, saved)
+}
+
+// RemoveOrganizationMemberHandler removes a user from an organization (its admins
+// and owners, or the member leaving).
+//
+// @Summary      Remove organization member
+// @Description  Removes a user from the organization. Members can leave it; admins of the organization remove the members and admins, its owners also the owners. The last owner cannot be removed
+// @Tags         organizations
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id      path      int                true  "Organization ID"
+// @Param        userId  path      int                true  "User ID"
+// @Success      200     {object}  map[string]string  "Member removed"
+// @Failure      400     {object}  apierror.Error     "Invalid ID"
+// @Failure      401     {object}  apierror.Error     "Authentication required"
+// @Failure      403     {object}  apierror.Error     "Not allowed to manage this member"
+// @Failure      404     {object}  apierror.Error     "Organization or member not found"
+// @Failure      409     {object}  apierror.Error     "The organization would have no owner left"
+// @Failure      500     {object}  apierror.Error     "Server error"
+// @Router       /organizations/{id}/members/{userId} [delete]
+func RemoveOrganizationMemberHandler(c *gin.Context) {
+	user, org, member, ok := loadOrganization(c)
+	if !ok {
+		return
+	}
+	targetID, err := strconv.ParseInt(c.Param("userId"), 10, 64)
+	if err != nil {
+		apierror.Write(c, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	ctx := c.Request.Context()
+	db := database.GetDB()
+	current, err := db.GetOrganizationMember(ctx, org.ID, targetID)
+	if errors.Is(err, database.ErrOrgMemberNotFound) {
+		apierror.Write(c, http.StatusNotFound, "Member not found")
+		return
+	}
+	if err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to get organization member")
+		return
+	}
+	// Members can always leave
+	if targetID != user.ID && !canManageOrgMember(c, user, member, current, current.Role) {
+		return
+	}
+	if current.Role == database.OrgRoleOwner && !hasOtherOwner(c, org.ID, targetID) {
+		return
+	}
+
+	if err := db.DeleteOrganizationMember(ctx, org.ID, targetID); err != nil {
+		if errors.Is(err, database.ErrOrgMemberNotFound) {
+			apierror.Write(c, http.StatusNotFound, "Member not found")
+		} else {
+			apierror.Write(c, http.StatusInternalServerError, "Failed to remove organization member")
+		}
+		return
+	}
+
+	logging.API.WithContext(ctx).WithFields(
+		"org_id", org.ID,
+		"member_user_id", targetID,
+		"user_id", user.ID,
+	).Info("Organization member removed")
+	kubernetes.RequestWhitelistSync()
+
+	c.JSON(http.StatusOK, gin.H{"message": "Member removed"})
+}
+
+// SetServerOrganizationHandler moves a server into an organization, or back to the
+// user who created it. The caller must manage both the server and the organization.
+//
+// @Summary      Set server organization
+// @Description  Moves the server into an organization whose members get access to it by their role, or back to the user who created it with an organizationId of 0. Requires managing the server (its owner, or an admin or owner of its organization) and being an admin or owner of the target organization
+// @Tags         organizations
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Security     APIKeyAuth
+// @Param        serverName  path      string                     true  "Server name"
+// @Param        request     body      ServerOrganizationRequest  true  "Organization"
+// @Success      200         {object}  map[string]interface{}     "Server moved"
+// @Failure      400         {object}  apierror.Error             "Invalid request"
+// @Failure      401         {object}  apierror.Error             "Authentication required"
+// @Failure      403         {object}  apierror.Error             "Not allowed to move the server"
+// @Failure      404         {object}  apierror.Error             "Server or organization not found"
+// @Failure      500         {object}  apierror.Error             "Server error"
+// @Router       /servers/{serverName}/organization [put]
+func SetServerOrganizationHandler(c *gin.Context) {
+	user, ok := auth.RequireCurrentUser(c)
+	if !ok {
+		return
+	}
+	var req ServerOrganizationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Invalid(c, err)
+		return
+	}
+
+	ctx := c.Request.Context()
+	db := database.GetDB()
+	server, err := db.GetServerByName(ctx, c.Param("serverName"))
+	if err != nil {
+		apierror.Respond(c, apierror.New(http.StatusNotFound, apierror.CodeServerNotFound, "Server not found"))
+		return
+	}
+	if !auth.ManagesServer(ctx, user, server) {
+		apierror.Write(c, http.StatusForbidden, "Only the owner of the server can move it")
+		return
+	}
+	if req.OrganizationID != 0 {
+		if _, err := db.GetOrganization(ctx, req.OrganizationID); err != nil {
+			if errors.Is(err, database.ErrOrganizationNotFound) {
+				apierror.Write(c, http.StatusNotFound, "Organization not found")
+			} else {
+				apierror.Write(c, http.StatusInternalServerError, "Failed to get organization")
+			}
+			return
+		}
+		member, err := db.GetOrganizationMember(ctx, req.OrganizationID, user.ID)
+		if !user.IsAdmin() && (err != nil || !member.ManagesOrganization()) {
+			apierror.Write(c, http.StatusForbidden, "Only the admins and owners of the organization can move servers into it")
+			return
+		}
+	}
+
+	if err := db.SetServerOrganization(ctx, server.ServerName, req.OrganizationID); err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to move server")
+		return
+	}
+
+	logging.API.WithContext(ctx).WithFields(
+		"server_name", server.ServerName,
+		"old_org_id", server.OrgID,
+		"new_org_id", req.OrganizationID,
+		"user_id", user.ID,
+	).Info("Server organization changed")
+	kubernetes.RequestWhitelistSync()
+
+	c.JSON(http.StatusOK, gin.H{"serverName": server.ServerName, "organizationId": req.OrganizationID})
+}
+
+// loadOrganization resolves the :id organization for its members and admins, with
+// the membership of the current user, nil for admins outside the organization. It
+// writes the error response and returns false when the organization cannot be
+// loaded; the other users get a 404, as for an organization that does not exist.
+func loadOrganization(c *gin.Context) (*database.User, *database.Organization, *database.OrganizationMember, bool) {
+	user, ok := auth.RequireCurrentUser(c)
+	if !ok {
+		return nil, nil, nil, false
+	}
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		apierror.Write(c, http.StatusBadRequest, "Invalid organization ID")
+		return nil, nil, nil, false
+	}
+
+	ctx := c.Request.Context()
+	db := database.GetDB()
+	org, err := db.GetOrganization(ctx, id)
+	if err != nil {
+		if errors.Is(err, database.ErrOrganizationNotFound) {
+			apierror.Write(c, http.StatusNotFound, "Organization not found")
+		} else {
+			apierror.Write(c, http.StatusInternalServerError, "Failed to get organization")
+		}
+		return nil, nil, nil, false
+	}
+	member, err := db.GetOrganizationMember(ctx, org.ID, user.ID)
+	if err != nil && !errors.Is(err, database.ErrOrgMemberNotFound) {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to get organization member")
+		return nil, nil, nil, false
+	}
+	if member == nil && !user.IsAdmin() {
+		apierror.Write(c, http.StatusNotFound, "Organization not found")
+		return nil, nil, nil, false
+	}
+	return user, org, member, true
+}
+
+// ownsOrganization checks that the user is an owner of the organization or an
+// admin, writing the 403 otherwise.
+func ownsOrganization(c *gin.Context, user *database.User, member *database.OrganizationMember) bool {
+	if user.IsAdmin() || member != nil && member.Role == database.OrgRoleOwner {
+		return true
+	}
+	apierror.Write(c, http.StatusForbidden, "Only the owners of the organization can change it")
+	return false
+}
+
+// canManageOrgMember checks that the user may give a member, nil for a new one, the
+// role: admins of the organization manage the members and admins, its owners also
+// the owners. It writes the 403 otherwise.
+func canManageOrgMember(c *gin.Context, user *database.User, member, target *database.OrganizationMember, role string) bool {
+	if user.IsAdmin() || member != nil && member.Role == database.OrgRoleOwner {
+		return true
+	}
+	if member == nil || !member.ManagesOrganization() {
+		apierror.Write(c, http.StatusForbidden, "Only the admins and owners of the organization can manage its members")
+		return false
+	}
+	if role == database.OrgRoleOwner || target != nil && target.Role == database.OrgRoleOwner {
+		apierror.Write(c, http.StatusForbidden, "Only the owners of the organization can manage its owners")
+		return false
+	}
+	return true
+}
+
+// hasOtherOwner checks that the organization keeps an owner besides the user,
+// writing the 409 otherwise.
+func hasOtherOwner(c *gin.Context, orgID, userID int64) bool {
+	members, err := database.GetDB().ListOrganizationMembers(c.Request.Context(), orgID)
+	if err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to list organization members")
+		return false
+	}
+	for _, member := range members {
+		if member.Role == database.OrgRoleOwner && member.UserID != userID {
+			return true
+		}
+	}
+	apierror.Write(c, http.StatusConflict, "The organization must keep an owner, make another member owner first")
+	return false
+}
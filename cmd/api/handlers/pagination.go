@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"minecharts/cmd/apierror"
+	"minecharts/cmd/database"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 500
+)
+
+// totalCountHeader carries the number of items of a paginated list, on every page.
+const totalCountHeader = "X-Total-Count"
+
+// parsePage reads the limit, offset and sort query parameters of a list sorted by
+// one of sortFields. sort names a field, prefixed with - for a descending sort.
+// Without limit nor offset the whole list is returned, as before lists were paged.
+// It writes the error response and returns false for invalid parameters.
+func parsePage(c *gin.Context, sortFields map[string]string) (database.Page, bool) {
+	var page database.Page
+	limit, offset := c.Query("limit"), c.Query("offset")
+	if offset != "" {
+		parsed, err := strconv.Atoi(offset)
+		if err != nil || parsed < 0 {
+			apierror.Write(c, http.StatusBadRequest, "offset must be a positive integer")
+			return page, false
+		}
+		page.Offset = parsed
+		page.Limit = defaultPageLimit
+	}
+	if limit != "" {
+		parsed, err := strconv.Atoi(limit)
+		if err != nil || parsed < 1 || parsed > maxPageLimit {
+			apierror.Write(c, http.StatusBadRequest, "limit must be between 1 and "+strconv.Itoa(maxPageLimit))
+			return page, false
+		}
+		page.Limit = parsed
+	}
+
+	if field := c.Query("sort"); field != "" {
+		page.Desc = strings.HasPrefix(field, "-")
+		page.Sort = strings.TrimPrefix(field, "-")
+		if _, ok := sortFields[page.Sort]; !ok {
+			names := make([]string, 0, len(sortFields))
+			for name := range sortFields {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			apierror.Write(c, http.StatusBadRequest, "sort must be one of "+strings.Join(names, ", ")+", prefixed with - for a descending sort")
+			return page, false
+		}
+	}
+	return page, true
+}
+
+// parseBoolQuery reads an optional boolean query parameter, nil when it is not set.
+// It writes the error response and returns false for invalid values.
+func parseBoolQuery(c *gin.Context, param string) (*bool, bool) {
+	value := c.Query(param)
+	if value == "" {
+		return nil, true
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		apierror.Write(c, http.StatusBadRequest, param+" must be true or false")
+		return nil, false
+	}
+	return &parsed, true
+}
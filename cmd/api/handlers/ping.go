@@ -15,6 +15,6 @@ import (
 // @Success      200  {object}  map[string]string  "Pong response"
 // @Router       /ping [get]
 func PingHandler(c *gin.Context) {
-	logging.API.WithFields("remote_ip", c.ClientIP()).Info("Ping request received")
+	logging.API.WithContext(c.Request.Context()).WithFields("remote_ip", c.ClientIP()).Info("Ping request received")
 	c.JSON(200, gin.H{"message": "pong"})
 }
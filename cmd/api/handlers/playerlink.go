@@ -0,0 +1,373 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"minecharts/cmd/apierror"
+	"minecharts/cmd/auth"
+	"minecharts/cmd/config"
+	"minecharts/cmd/database"
+	"minecharts/cmd/kubernetes"
+	"minecharts/cmd/logging"
+
+	"github.com/gin-gonic/gin"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// minecraftLinkPurpose is the purpose of the nonces holding the pending Minecraft
+// account verifications.
+const minecraftLinkPurpose = "minecraft-link"
+
+// linkCodeAlphabet leaves out the characters read alike in the game font.
+const linkCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// playerNamePattern matches the Minecraft player names, which are passed to console
+// commands.
+var playerNamePattern = regexp.MustCompile(`^[A-Za-z0-9_]{3,16}$`)
+
+// LinkMinecraftAccountRequest names the player to send a verification code to.
+type LinkMinecraftAccountRequest struct {
+	PlayerName string `json:"playerName" binding:"required" example:"Steve"`
+}
+
+// VerifyMinecraftAccountRequest holds the verification code received in game.
+type VerifyMinecraftAccountRequest struct {
+	Code string `json:"code" binding:"required" example:"K7QX2MPA"`
+}
+
+// pendingMinecraftLink is the player a verification code was sent to.
+type pendingMinecraftLink struct {
+	UUID string `json:"uuid"`
+	Name string `json:"name"`
+}
+
+// LinkMinecraftAccountHandler sends a verification code to a player connected to a
+// server, which the user confirms with VerifyMinecraftAccountHandler to link their
+// account to the player.
+//
+// @Summary      Send Minecraft account verification code
+// @Description  Sends a one-time code with tellraw to a player connected to the server. Entering the code with POST /auth/me/minecraft links the player to the account of the user
+// @Tags         players
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Security     APIKeyAuth
+// @Param        serverName  path      string                       true  "Server name"
+// @Param        request     body      LinkMinecraftAccountRequest  true  "Player name"
+// @Success      202         {object}  map[string]interface{}       "Verification code sent"
+// @Failure      400         {object}  apierror.Error               "Invalid player name"
+// @Failure      401         {object}  apierror.Error               "Authentication required"
+// @Failure      403         {object}  apierror.Error               "Permission denied"
+// @Failure      404         {object}  apierror.Error               "Server or player not found"
+// @Failure      409         {object}  apierror.Error               "Server not running or player not connected"
+// @Failure      500         {object}  apierror.Error               "Server error"
+// @Router       /servers/{serverName}/players/link [post]
+func LinkMinecraftAccountHandler(c *gin.Context) {
+	var req LinkMinecraftAccountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Invalid(c, err)
+		return
+	}
+	if !playerNamePattern.MatchString(req.PlayerName) {
+		apierror.Write(c, http.StatusBadRequest, "Invalid player name")
+		return
+	}
+	user, ok := auth.GetCurrentUser(c)
+	if !ok {
+		apierror.Write(c, http.StatusUnauthorized, "Authentication required")
+		return
+	}
+
+	deploymentName, _ := kubernetes.GetServerInfo(c)
+	serverName := c.Param("serverName")
+	namespace := serverNamespace(c)
+	deployment, ok := kubernetes.CheckDeploymentExists(c, namespace, deploymentName)
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+	pod, err := kubernetes.GetMinecraftPod(ctx, namespace, deploymentName)
+	if err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to get server pod: "+err.Error())
+		return
+	}
+	if pod == nil || pod.Status.Phase != corev1.PodRunning {
+		apierror.Write(c, http.StatusConflict, "The server must be running to send a verification code")
+		return
+	}
+
+	player, err := kubernetes.FindCachedPlayer(ctx, namespace, pod, req.PlayerName)
+	if errors.Is(err, kubernetes.ErrPlayerNotFound) {
+		apierror.Write(c, http.StatusNotFound, req.PlayerName+" never joined this server")
+		return
+	}
+	if err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to look up player: "+err.Error())
+		return
+	}
+
+	code, err := generateLinkCode()
+	if err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to generate verification code")
+		return
+	}
+	data, _ := json.Marshal(pendingMinecraftLink{UUID: player.UUID, Name: player.Name})
+	expiresAt := time.Now().Add(config.MinecraftLinkCodeTTL)
+	// Codes are scoped to the user, so they cannot be entered by another account
+	if err := database.GetDB().CreateNonce(ctx, minecraftLinkPurpose, linkCodeNonce(user.ID, code), string(data), expiresAt); err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to store verification code")
+		return
+	}
+
+	message, _ := json.Marshal([]interface{}{
+		"",
+		map[string]interface{}{"text": "[Minecharts] ", "color": "gold"},
+		map[string]interface{}{"text": "Verification code of " + user.Username + ": "},
+		map[string]interface{}{"text": code, "color": "green", "bold": true},
+	})
+	output, _, err := kubernetes.SendConsoleCommand(ctx, namespace, deployment, pod, "tellraw "+player.Name+" "+string(message))
+	if err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to send verification code: "+err.Error())
+		return
+	}
+	// Only RCON reports that the player is not connected
+	if strings.Contains(output, "No player was found") {
+		apierror.Write(c, http.StatusConflict, player.Name+" must be connected to "+serverName+" to receive the code")
+		return
+	}
+
+	logging.Auth.Session.WithContext(ctx).WithFields(
+		"user_id", user.ID,
+		"username", user.Username,
+		"server_name", serverName,
+		"minecraft_name", player.Name,
+		"minecraft_uuid", player.UUID,
+	).Info("Minecraft account verification code sent")
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message":    "Verification code sent in game",
+		"playerName": player.Name,
+		"expiresAt":  expiresAt,
+	})
+}
+
+// VerifyMinecraftAccountHandler links the account of the user to the player a
+// verification code was sent to, and whitelists the player on the running servers
+// the user can access.
+//
+// @Summary      Verify Minecraft account
+// @Description  Links the Minecraft account that received the code in game to the user, and adds it to the whitelist of the running servers the user can view
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        request  body      VerifyMinecraftAccountRequest  true  "Verification code"
+// @Success      200      {object}  map[string]interface{}         "Account linked"
+// @Failure      400      {object}  apierror.Error                 "Invalid or expired code"
+// @Failure      401      {object}  apierror.Error                 "Authentication required"
+// @Failure      409      {object}  apierror.Error                 "Minecraft account linked to another user"
+// @Failure      500      {object}  apierror.Error                 "Server error"
+// @Router       /auth/me/minecraft [post]
+func VerifyMinecraftAccountHandler(c *gin.Context) {
+	var req VerifyMinecraftAccountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Invalid(c, err)
+		return
+	}
+	user, ok := auth.GetCurrentUser(c)
+	if !ok {
+		apierror.Write(c, http.StatusUnauthorized, "Authentication required")
+		return
+	}
+
+	ctx := c.Request.Context()
+	db := database.GetDB()
+	data, err := db.ConsumeNonce(ctx, minecraftLinkPurpose, linkCodeNonce(user.ID, strings.ToUpper(strings.TrimSpace(req.Code))))
+	if errors.Is(err, database.ErrNonceNotFound) {
+		logging.Auth.Session.WithContext(ctx).WithFields("user_id", user.ID, "username", user.Username, "remote_ip", c.ClientIP()).
+			Warn("Minecraft account verification failed: invalid code")
+		apierror.Write(c, http.StatusBadRequest, "Invalid or expired verification code")
+		return
+	}
+	if err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to verify code")
+		return
+	}
+	var link pendingMinecraftLink
+	if err := json.Unmarshal([]byte(data), &link); err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to read verification")
+		return
+	}
+
+	err = db.SetUserMinecraftAccount(ctx, user.ID, link.UUID, link.Name)
+	if errors.Is(err, database.ErrMinecraftAccountLinked) {
+		apierror.Write(c, http.StatusConflict, link.Name+" is linked to another user")
+		return
+	}
+	if err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to link Minecraft account")
+		return
+	}
+	user.MinecraftUUID, user.MinecraftName = link.UUID, link.Name
+
+	logging.Auth.Session.WithContext(ctx).WithFields(
+		"user_id", user.ID,
+		"username", user.Username,
+		"minecraft_name", link.Name,
+		"minecraft_uuid", link.UUID,
+	).Info("Minecraft account linked")
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "Minecraft account linked",
+		"minecraft_uuid": link.UUID,
+		"minecraft_name": link.Name,
+		"whitelisted":    whitelistLinkedPlayer(ctx, user),
+	})
+}
+
+// UnlinkMinecraftAccountHandler removes the Minecraft account linked to the user.
+//
+// @Summary      Unlink Minecraft account
+// @Description  Removes the Minecraft account linked to the user. The player is removed from the whitelist of the servers with syncWhitelist, and stays in the others
+// @Tags         auth
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200  {object}  map[string]string  "Account unlinked"
+// @Failure      401  {object}  apierror.Error     "Authentication required"
+// @Failure      500  {object}  apierror.Error     "Server error"
+// @Router       /auth/me/minecraft [delete]
+func UnlinkMinecraftAccountHandler(c *gin.Context) {
+	user, ok := auth.GetCurrentUser(c)
+	if !ok {
+		apierror.Write(c, http.StatusUnauthorized, "Authentication required")
+		return
+	}
+	if err := database.GetDB().SetUserMinecraftAccount(c.Request.Context(), user.ID, "", ""); err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to unlink Minecraft account")
+		return
+	}
+
+	logging.Auth.Session.WithContext(c.Request.Context()).WithFields(
+		"user_id", user.ID,
+		"username", user.Username,
+		"minecraft_name", user.MinecraftName,
+	).Info("Minecraft account unlinked")
+	if user.MinecraftName != "" {
+		kubernetes.RequestWhitelistSync(user.MinecraftName)
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Minecraft account unlinked"})
+}
+
+// whitelistLinkedPlayer adds the Minecraft account of a user to the whitelist of the
+// running servers the user can view, and returns their names. Servers that cannot be
+// reached are skipped: new servers whitelist the linked accounts when created.
+func whitelistLinkedPlayer(ctx context.Context, user *database.User) []string {
+	whitelisted := []string{}
+	if !kubernetes.ClusterReachable() {
+		return whitelisted
+	}
+	servers, err := database.GetDB().ListServers(ctx)
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"user_id", user.ID,
+			"error", err.Error(),
+		).Warn("Failed to list servers to whitelist linked player")
+		return whitelisted
+	}
+
+	access, err := auth.LoadServerAccess(ctx, user)
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"user_id", user.ID,
+			"error", err.Error(),
+		).Warn("Failed to load server memberships to whitelist linked player")
+		return whitelisted
+	}
+
+	for _, server := range servers {
+		if !access.Has(server.ServerName, server.OwnerID, database.PermViewServer) {
+			continue
+		}
+		namespace := kubernetes.ServerNamespace(server)
+		deployment, err := kubernetes.GetDeployment(ctx, namespace, server.DeploymentName)
+		if err != nil || deployment == nil {
+			continue
+		}
+		pod, err := kubernetes.GetMinecraftPod(ctx, namespace, server.DeploymentName)
+		if err != nil || pod == nil || pod.Status.Phase != corev1.PodRunning {
+			continue
+		}
+		if _, _, err := kubernetes.SendConsoleCommand(ctx, namespace, deployment, pod, "whitelist add "+user.MinecraftName); err != nil {
+			logging.Server.WithContext(ctx).WithFields(
+				"server_name", server.ServerName,
+				"minecraft_name", user.MinecraftName,
+				"error", err.Error(),
+			).Warn("Failed to whitelist linked player")
+			continue
+		}
+		whitelisted = append(whitelisted, server.ServerName)
+	}
+	return whitelisted
+}
+
+// withLinkedWhitelist returns the environment of a new server with the Minecraft
+// accounts linked to the users who can view it added to WHITELIST, when the server
+// enables its whitelist: its owner's, its members and the members of its organization
+// who can view it. Servers without a whitelist are left open.
+func withLinkedWhitelist(ctx context.Context, env map[string]string, server *database.MinecraftServer) map[string]string {
+	if env["WHITELIST"] == "" && !strings.EqualFold(env["ENABLE_WHITELIST"], "true") {
+		return env
+	}
+	users, err := database.GetDB().ListUsers(ctx)
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"error", err.Error(),
+		).Warn("Failed to list users to whitelist linked players")
+		return env
+	}
+
+	var entries []string
+	if env["WHITELIST"] != "" {
+		entries = append(entries, env["WHITELIST"])
+	}
+	for _, user := range users {
+		if user.MinecraftUUID != "" && user.Active && auth.HasServerAccess(ctx, user, server, database.PermViewServer) {
+			entries = append(entries, user.MinecraftUUID)
+		}
+	}
+	if len(entries) == 0 {
+		return env
+	}
+
+	whitelisted := make(map[string]string, len(env)+1)
+	for key, value := range env {
+		whitelisted[key] = value
+	}
+	whitelisted["WHITELIST"] = strings.Join(entries, ",")
+	return whitelisted
+}
+
+// generateLinkCode returns a random verification code of eight characters.
+func generateLinkCode() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	for i := range b {
+		b[i] = linkCodeAlphabet[int(b[i])%len(linkCodeAlphabet)]
+	}
+	return string(b), nil
+}
+
+// linkCodeNonce returns the nonce value of a verification code sent for a user.
+func linkCodeNonce(userID int64, code string) string {
+	return fmt.Sprintf("%d:%s", userID, code)
+}
@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"minecharts/cmd/database"
+)
+
+func TestLinkedWhitelistIncludesServerMembers(t *testing.T) {
+	ctx := context.Background()
+	db := setupTest(t)
+	owner := createTestUser(t, db, "owner", database.PermCreateServer)
+	member := createTestUser(t, db, "member", 0)
+	outsider := createTestUser(t, db, "outsider", 0)
+	server := createTestServer(t, db, "survival", owner)
+	for _, user := range []*database.User{owner, member, outsider} {
+		if err := db.SetUserMinecraftAccount(ctx, user.ID, "uuid-"+user.Username, user.Username); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.SaveServerMember(ctx, &database.ServerMember{ServerID: server.ID, UserID: member.ID, Permissions: database.PermViewServer}); err != nil {
+		t.Fatal(err)
+	}
+
+	env := withLinkedWhitelist(ctx, map[string]string{"ENABLE_WHITELIST": "true"}, server)
+	whitelist := strings.Split(env["WHITELIST"], ",")
+	for _, want := range []string{"uuid-owner", "uuid-member"} {
+		if !containsString(whitelist, want) {
+			t.Errorf("WHITELIST = %q, missing %s", env["WHITELIST"], want)
+		}
+	}
+	if containsString(whitelist, "uuid-outsider") {
+		t.Errorf("WHITELIST = %q, includes a user who cannot view the server", env["WHITELIST"])
+	}
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"minecharts/cmd/apierror"
+	"minecharts/cmd/auth"
+	"minecharts/cmd/kubernetes"
+	"minecharts/cmd/logging"
+	"minecharts/cmd/mcproto"
+
+	"github.com/gin-gonic/gin"
+	appsv1 "k8s.io/api/apps/v1"
+)
+
+// OnlinePlayersResponse is returned by the online players endpoint.
+type OnlinePlayersResponse struct {
+	ServerName string   `json:"serverName"`
+	Online     int      `json:"online" example:"3"`
+	Max        int      `json:"max" example:"20"`
+	Players    []string `json:"players"`
+	MOTD       string   `json:"motd" example:"A Minecraft Server"`
+	Version    string   `json:"version" example:"1.21.4"`
+	Protocol   int      `json:"protocol,omitempty" example:"769"`
+	Source     string   `json:"source" example:"ping"` // "ping" or "query"
+}
+
+// GetOnlinePlayersHandler returns the players currently connected to a server.
+//
+// @Summary      Get online players
+// @Description  Queries the server with the Minecraft Server List Ping (and Query protocol when enabled) to report players, MOTD and version
+// @Tags         servers
+// @Produce      json
+// @Security     BearerAuth
+// @Security     APIKeyAuth
+// @Param        serverName  path      string  true  "Server name"
+// @Success      200         {object}  OnlinePlayersResponse  "Online players"
+// @Failure      401         {object}  apierror.Error         "Authentication required"
+// @Failure      403         {object}  apierror.Error         "Permission denied"
+// @Failure      404         {object}  apierror.Error         "Server not found"
+// @Failure      502         {object}  apierror.Error         "Server did not answer"
+// @Router       /servers/{serverName}/players/online [get]
+func GetOnlinePlayersHandler(c *gin.Context) {
+	deploymentName, _ := kubernetes.GetServerInfo(c)
+	serverName := c.Param("serverName")
+
+	user, ok := auth.RequireCurrentUser(c)
+	if !ok {
+		return
+	}
+
+	logging.Server.WithContext(c.Request.Context()).WithFields(
+		"server_name", serverName,
+		"deployment", deploymentName,
+		"user_id", user.ID,
+		"remote_ip", c.ClientIP(),
+	).Debug("Online players requested")
+
+	namespace := serverNamespace(c)
+	deployment, ok := kubernetes.CheckDeploymentExists(c, namespace, deploymentName)
+	if !ok {
+		return
+	}
+
+	address, err := resolveGameAddress(c.Request.Context(), namespace, deploymentName)
+	if err != nil {
+		logging.Server.WithContext(c.Request.Context()).WithFields(
+			"server_name", serverName,
+			"deployment", deploymentName,
+			"error", err.Error(),
+		).Warn("Failed to resolve server address for player listing")
+		apierror.Write(c, http.StatusNotFound, "Server is not reachable: "+err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), mcproto.DefaultTimeout)
+	defer cancel()
+
+	status, err := mcproto.Ping(ctx, address)
+	if err != nil {
+		logging.Server.WithContext(c.Request.Context()).WithFields(
+			"server_name", serverName,
+			"address", address,
+			"error", err.Error(),
+		).Warn("Server list ping failed")
+		apierror.Write(c, http.StatusBadGateway, "Server did not answer the status ping: "+err.Error())
+		return
+	}
+
+	response := OnlinePlayersResponse{
+		ServerName: serverName,
+		Online:     status.Online,
+		Max:        status.Max,
+		Players:    status.Players,
+		MOTD:       status.MOTD,
+		Version:    status.Version,
+		Protocol:   status.Protocol,
+		Source:     "ping",
+	}
+
+	// The ping only carries a sample of player names; use the Query protocol
+	// for the full list when the server has it enabled.
+	if status.Online > len(status.Players) && queryEnabled(deployment) {
+		queryAddress, err := resolveQueryAddress(c.Request.Context(), namespace, deploymentName, deployment)
+		if err == nil {
+			result, err := mcproto.Query(ctx, queryAddress)
+			if err == nil {
+				response.Players = result.Players
+				response.Online = result.NumPlayers
+				response.Source = "query"
+			} else {
+				logging.Server.WithContext(c.Request.Context()).WithFields(
+					"server_name", serverName,
+					"address", queryAddress,
+					"error", err.Error(),
+				).Debug("Query protocol request failed, falling back to ping sample")
+			}
+		}
+	}
+
+	logging.Server.WithContext(c.Request.Context()).WithFields(
+		"server_name", serverName,
+		"online", response.Online,
+		"source", response.Source,
+	).Debug("Online players retrieved")
+
+	c.JSON(http.StatusOK, response)
+}
+
+// resolveGameAddress returns the in-cluster address of a server's game port,
+// preferring the Service ClusterIP and falling back to the pod IP.
+func resolveGameAddress(ctx context.Context, namespace, deploymentName string) (string, error) {
+	serviceName := deploymentName + "-svc"
+	if service, err := kubernetes.GetServiceDetails(ctx, namespace, serviceName); err == nil {
+		if service.Spec.ClusterIP != "" && service.Spec.ClusterIP != "None" {
+			port := int32(25565)
+			for _, p := range service.Spec.Ports {
+				if p.Name == "minecraft" {
+					port = p.Port
+					break
+				}
+			}
+			return net.JoinHostPort(service.Spec.ClusterIP, strconv.Itoa(int(port))), nil
+		}
+	}
+
+	pod, err := kubernetes.GetMinecraftPod(ctx, namespace, deploymentName)
+	if err != nil {
+		return "", err
+	}
+	if pod == nil || pod.Status.PodIP == "" {
+		return "", fmt.Errorf("no running pod for %s", deploymentName)
+	}
+	return net.JoinHostPort(pod.Status.PodIP, "25565"), nil
+}
+
+// resolveQueryAddress returns the pod address of the UDP query port.
+// Services only expose the TCP game port, so the query goes to the pod directly.
+func resolveQueryAddress(ctx context.Context, namespace, deploymentName string, deployment *appsv1.Deployment) (string, error) {
+	pod, err := kubernetes.GetMinecraftPod(ctx, namespace, deploymentName)
+	if err != nil {
+		return "", err
+	}
+	if pod == nil || pod.Status.PodIP == "" {
+		return "", fmt.Errorf("no running pod for %s", deploymentName)
+	}
+
+	port := "25565"
+	if value := kubernetes.ContainerEnv(deployment, "QUERY_PORT"); value != "" {
+		port = value
+	}
+	return net.JoinHostPort(pod.Status.PodIP, port), nil
+}
+
+// queryEnabled reports whether the server was started with ENABLE_QUERY.
+func queryEnabled(deployment *appsv1.Deployment) bool {
+	return strings.EqualFold(kubernetes.ContainerEnv(deployment, "ENABLE_QUERY"), "true")
+}
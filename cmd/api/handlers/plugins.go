@@ -0,0 +1,409 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"minecharts/cmd/apierror"
+	"minecharts/cmd/auth"
+	"minecharts/cmd/database"
+	"minecharts/cmd/kubernetes"
+	"minecharts/cmd/logging"
+	"minecharts/cmd/modplatform"
+
+	"github.com/gin-gonic/gin"
+)
+
+// InstallPluginRequest represents the plugin or mod to install on a server.
+type InstallPluginRequest struct {
+	Project   string `json:"project" binding:"required,max=64" example:"coreprotect"` // Modrinth project ID or slug
+	VersionID string `json:"versionId" binding:"max=64" example:"b0Yxyasa"`           // Defaults to the latest version compatible with the server
+}
+
+// InstallPluginResponse describes an installed plugin or mod.
+type InstallPluginResponse struct {
+	Message         string                 `json:"message" example:"Plugin installed"`
+	Plugin          *database.ServerPlugin `json:"plugin"`
+	RestartRequired bool                   `json:"restartRequired"` // The server runs and loads it on its next start
+}
+
+// pluginTarget is where a server loads its plugins or mods from, and what they must
+// be built for.
+type pluginTarget struct {
+	directory string
+	filter    modplatform.Filter
+}
+
+// serverPluginTarget returns the plugin target of a server from its type and version,
+// or false when the server cannot run plugins or mods.
+func serverPluginTarget(spec database.ServerSpec) (pluginTarget, bool) {
+	var target pluginTarget
+	switch spec.ServerType {
+	case "paper":
+		target = pluginTarget{directory: "plugins", filter: modplatform.Filter{Loaders: []string{"paper", "spigot", "bukkit"}}}
+	case "fabric":
+		target = pluginTarget{directory: "mods", filter: modplatform.Filter{Loaders: []string{"fabric"}}}
+	case "forge":
+		target = pluginTarget{directory: "mods", filter: modplatform.Filter{Loaders: []string{"forge"}}}
+	default:
+		return target, false
+	}
+	// LATEST and SNAPSHOT move, any game version is accepted
+	if versionPattern.MatchString(spec.Version) && spec.Version != "LATEST" && spec.Version != "SNAPSHOT" {
+		target.filter.GameVersion = spec.Version
+	}
+	return target, true
+}
+
+// pluginServer returns the server of the request along with its plugin target. It
+// writes the error response and returns false when the server is missing or cannot
+// run plugins or mods.
+func pluginServer(c *gin.Context) (*database.MinecraftServer, pluginTarget, bool) {
+	server, err := database.GetDB().GetServerByName(c.Request.Context(), c.Param("serverName"))
+	if err != nil {
+		apierror.Respond(c, apierror.New(http.StatusNotFound, apierror.CodeServerNotFound, "Server not found"))
+		return nil, pluginTarget{}, false
+	}
+	target, ok := serverPluginTarget(server.Spec)
+	if !ok {
+		apierror.Write(c, http.StatusBadRequest, "Plugins and mods need a paper, fabric or forge server")
+		return nil, pluginTarget{}, false
+	}
+	return server, target, true
+}
+
+// SearchPluginsHandler searches Modrinth for the plugins or mods a server can run.
+//
+// @Summary      Search plugins
+// @Description  Searches Modrinth for plugins (paper servers) or mods (fabric and forge servers) compatible with the loader and Minecraft version of the server
+// @Tags         plugins
+// @Produce      json
+// @Security     BearerAuth
+// @Security     APIKeyAuth
+// @Param        serverName  path      string                 true   "Server name"
+// @Param        query       query     string                 false  "Search terms"
+// @Param        limit       query     int                    false  "Maximum number of projects (default 20, max 100)"
+// @Success      200         {array}   modplatform.Project    "Compatible projects"
+// @Failure      400         {object}  apierror.Error         "The server cannot run plugins or mods"
+// @Failure      401         {object}  apierror.Error         "Authentication required"
+// @Failure      403         {object}  apierror.Error         "Permission denied"
+// @Failure      404         {object}  apierror.Error         "Server not found"
+// @Failure      502         {object}  apierror.Error         "Modrinth unavailable"
+// @Router       /servers/{serverName}/plugins/search [get]
+func SearchPluginsHandler(c *gin.Context) {
+	_, target, ok := pluginServer(c)
+	if !ok {
+		return
+	}
+
+	limit := 20
+	if value := c.Query("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > 100 {
+			apierror.Write(c, http.StatusBadRequest, "limit must be between 1 and 100")
+			return
+		}
+		limit = parsed
+	}
+
+	projects, err := modplatform.NewClient().Search(c.Request.Context(), c.Query("query"), target.filter, limit)
+	if err != nil {
+		apierror.Write(c, http.StatusBadGateway, "Failed to search Modrinth: "+err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, projects)
+}
+
+// ListServerPluginsHandler lists the plugins or mods installed on a server.
+//
+// @Summary      List server plugins
+// @Description  Lists the plugins or mods installed on the server through the API, with their versions
+// @Tags         plugins
+// @Produce      json
+// @Security     BearerAuth
+// @Security     APIKeyAuth
+// @Param        serverName  path      string                   true  "Server name"
+// @Success      200         {array}   database.ServerPlugin    "Installed plugins"
+// @Failure      401         {object}  apierror.Error           "Authentication required"
+// @Failure      403         {object}  apierror.Error           "Permission denied"
+// @Failure      404         {object}  apierror.Error           "Server not found"
+// @Failure      500         {object}  apierror.Error           "Server error"
+// @Router       /servers/{serverName}/plugins [get]
+func ListServerPluginsHandler(c *gin.Context) {
+	db := database.GetDB()
+	server, err := db.GetServerByName(c.Request.Context(), c.Param("serverName"))
+	if err != nil {
+		apierror.Respond(c, apierror.New(http.StatusNotFound, apierror.CodeServerNotFound, "Server not found"))
+		return
+	}
+
+	plugins, err := db.ListServerPlugins(c.Request.Context(), server.ID)
+	if err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to list plugins")
+		return
+	}
+	c.JSON(http.StatusOK, plugins)
+}
+
+// InstallServerPluginHandler installs a plugin or mod version from Modrinth on a server.
+//
+// The file is downloaded and checked against its hash before being written to the
+// server volume, replacing the version of the project installed before.
+//
+// @Summary      Install server plugin
+// @Description  Installs a Modrinth project on the server, into /data/plugins for paper servers and /data/mods for fabric and forge servers. Without versionId, the latest version compatible with the server is installed, releases first. A running server loads it on its next restart
+// @Tags         plugins
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Security     APIKeyAuth
+// @Param        serverName  path      string                 true  "Server name"
+// @Param        request     body      InstallPluginRequest   true  "Project and version"
+// @Success      200         {object}  InstallPluginResponse  "Plugin installed"
+// @Failure      400         {object}  apierror.Error         "Invalid request or incompatible version"
+// @Failure      401         {object}  apierror.Error         "Authentication required"
+// @Failure      403         {object}  apierror.Error         "Permission denied"
+// @Failure      404         {object}  apierror.Error         "Server, project or version not found"
+// @Failure      409         {object}  apierror.Error         "The server is starting"
+// @Failure      502         {object}  apierror.Error         "Modrinth unavailable"
+// @Failure      500         {object}  apierror.Error         "Server error"
+// @Router       /servers/{serverName}/plugins [post]
+func InstallServerPluginHandler(c *gin.Context) {
+	var req InstallPluginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Invalid(c, err)
+		return
+	}
+	server, target, ok := pluginServer(c)
+	if !ok {
+		return
+	}
+	user, ok := auth.RequireCurrentUser(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	client := modplatform.NewClient()
+	project, err := client.GetProject(ctx, req.Project)
+	if err != nil {
+		modplatformError(c, "Project not found on Modrinth", err)
+		return
+	}
+
+	var version *modplatform.Version
+	if req.VersionID != "" {
+		version, err = client.GetVersion(ctx, req.VersionID)
+		if err != nil {
+			modplatformError(c, "Version not found on Modrinth", err)
+			return
+		}
+		if version.ProjectID != project.ID {
+			apierror.Write(c, http.StatusBadRequest, "The version does not belong to the project")
+			return
+		}
+		if !containsAny(version.Loaders, target.filter.Loaders) {
+			apierror.Write(c, http.StatusBadRequest, "The version is built for "+strings.Join(version.Loaders, ", ")+", not for a "+server.Spec.ServerType+" server")
+			return
+		}
+	} else {
+		versions, err := client.ListVersions(ctx, project.ID, target.filter)
+		if err != nil {
+			modplatformError(c, "Project not found on Modrinth", err)
+			return
+		}
+		version = latestVersion(versions)
+		if version == nil {
+			apierror.Write(c, http.StatusNotFound, "No version of "+project.Title+" is compatible with the server")
+			return
+		}
+	}
+
+	file, ok := version.PrimaryFile()
+	if !ok || !strings.HasSuffix(file.FileName, ".jar") || path.Base(file.FileName) != file.FileName || strings.HasPrefix(file.FileName, ".") {
+		apierror.Write(c, http.StatusBadRequest, "The version has no jar file to install")
+		return
+	}
+
+	// Checked against its hash before anything is written to the server
+	download, err := os.CreateTemp("", "minecharts-plugin-*.jar")
+	if err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to create download file")
+		return
+	}
+	defer os.Remove(download.Name())
+	defer download.Close()
+	if err := client.Download(ctx, file, download); err != nil {
+		apierror.Write(c, http.StatusBadGateway, err.Error())
+		return
+	}
+	if _, err := download.Seek(0, 0); err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to read download file")
+		return
+	}
+
+	db := database.GetDB()
+	previous, err := db.GetServerPlugin(ctx, server.ID, project.ID)
+	if err != nil && !errors.Is(err, database.ErrPluginNotFound) {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to get installed plugin")
+		return
+	}
+
+	filePath := "/data/" + target.directory + "/" + file.FileName
+	namespace := kubernetes.ServerNamespace(server)
+	if err := kubernetes.WriteServerFile(ctx, namespace, server.DeploymentName, server.PVCName, filePath, download); err != nil {
+		serverFileError(c, "Failed to install plugin", err)
+		return
+	}
+	if previous != nil {
+		previousPath := "/data/" + previous.Directory + "/" + previous.FileName
+		if previousPath != filePath {
+			if err := kubernetes.RemoveServerFile(ctx, namespace, server.DeploymentName, server.PVCName, previousPath); err != nil {
+				logging.Server.WithContext(ctx).WithFields(
+					"server_name", server.ServerName,
+					"file", previousPath,
+					"error", err.Error(),
+				).Warn("Failed to remove previous plugin version")
+			}
+		}
+	}
+
+	plugin := &database.ServerPlugin{
+		ServerID:    server.ID,
+		Platform:    modplatform.Modrinth,
+		ProjectID:   project.ID,
+		ProjectSlug: project.Slug,
+		Name:        project.Title,
+		VersionID:   version.ID,
+		Version:     version.VersionNumber,
+		Directory:   target.directory,
+		FileName:    file.FileName,
+		SHA512:      file.SHA512,
+		InstalledBy: user.ID,
+	}
+	if err := db.SaveServerPlugin(ctx, plugin); err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Plugin installed, but failed to record it")
+		return
+	}
+
+	logging.Server.WithContext(ctx).WithFields(
+		"server_name", server.ServerName,
+		"project", project.Slug,
+		"version", version.VersionNumber,
+		"file", filePath,
+		"user_id", user.ID,
+		"username", user.Username,
+	).Info("Plugin installed on Minecraft server")
+
+	pod, _ := kubernetes.GetMinecraftPod(ctx, namespace, server.DeploymentName)
+	c.JSON(http.StatusOK, InstallPluginResponse{
+		Message:         "Plugin installed",
+		Plugin:          plugin,
+		RestartRequired: pod != nil,
+	})
+}
+
+// DeleteServerPluginHandler removes a plugin or mod installed on a server.
+//
+// @Summary      Remove server plugin
+// @Description  Removes the file of a plugin or mod installed through the API from the server volume. A running server unloads it on its next restart
+// @Tags         plugins
+// @Produce      json
+// @Security     BearerAuth
+// @Security     APIKeyAuth
+// @Param        serverName  path      string             true  "Server name"
+// @Param        projectId   path      string             true  "Modrinth project ID"
+// @Success      200         {object}  map[string]string  "Plugin removed"
+// @Failure      401         {object}  apierror.Error     "Authentication required"
+// @Failure      403         {object}  apierror.Error     "Permission denied"
+// @Failure      404         {object}  apierror.Error     "Server or plugin not found"
+// @Failure      409         {object}  apierror.Error     "The server is starting"
+// @Failure      500         {object}  apierror.Error     "Server error"
+// @Router       /servers/{serverName}/plugins/{projectId} [delete]
+func DeleteServerPluginHandler(c *gin.Context) {
+	ctx := c.Request.Context()
+	db := database.GetDB()
+	server, err := db.GetServerByName(ctx, c.Param("serverName"))
+	if err != nil {
+		apierror.Respond(c, apierror.New(http.StatusNotFound, apierror.CodeServerNotFound, "Server not found"))
+		return
+	}
+	plugin, err := db.GetServerPlugin(ctx, server.ID, c.Param("projectId"))
+	if errors.Is(err, database.ErrPluginNotFound) {
+		apierror.Write(c, http.StatusNotFound, "Plugin not found")
+		return
+	}
+	if err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to get plugin")
+		return
+	}
+	user, ok := auth.RequireCurrentUser(c)
+	if !ok {
+		return
+	}
+
+	filePath := "/data/" + plugin.Directory + "/" + plugin.FileName
+	namespace := kubernetes.ServerNamespace(server)
+	if err := kubernetes.RemoveServerFile(ctx, namespace, server.DeploymentName, server.PVCName, filePath); err != nil {
+		serverFileError(c, "Failed to remove plugin", err)
+		return
+	}
+	if err := db.DeleteServerPlugin(ctx, server.ID, plugin.ProjectID); err != nil && !errors.Is(err, database.ErrPluginNotFound) {
+		apierror.Write(c, http.StatusInternalServerError, "Plugin removed, but failed to update its record")
+		return
+	}
+
+	logging.Server.WithContext(ctx).WithFields(
+		"server_name", server.ServerName,
+		"project", plugin.ProjectSlug,
+		"file", filePath,
+		"user_id", user.ID,
+		"username", user.Username,
+	).Info("Plugin removed from Minecraft server")
+	c.JSON(http.StatusOK, gin.H{"message": "Plugin removed"})
+}
+
+// latestVersion returns the newest release of the versions, listed newest first, or
+// the newest beta or alpha when there is no release.
+func latestVersion(versions []modplatform.Version) *modplatform.Version {
+	for i := range versions {
+		if versions[i].VersionType == "release" {
+			return &versions[i]
+		}
+	}
+	if len(versions) > 0 {
+		return &versions[0]
+	}
+	return nil
+}
+
+// modplatformError writes the response of a failed mod platform request.
+func modplatformError(c *gin.Context, notFound string, err error) {
+	if errors.Is(err, modplatform.ErrNotFound) {
+		apierror.Write(c, http.StatusNotFound, notFound)
+		return
+	}
+	apierror.Write(c, http.StatusBadGateway, err.Error())
+}
+
+// serverFileError writes the response of a failed write or removal in a server volume.
+func serverFileError(c *gin.Context, message string, err error) {
+	if errors.Is(err, kubernetes.ErrServerStarting) || errors.Is(err, kubernetes.ErrServerFilesBusy) {
+		apierror.Write(c, http.StatusConflict, err.Error())
+		return
+	}
+	apierror.Write(c, http.StatusInternalServerError, message+": "+err.Error())
+}
+
+func containsAny(values, wanted []string) bool {
+	for _, value := range wanted {
+		if contains(values, value) {
+			return true
+		}
+	}
+	return false
+}
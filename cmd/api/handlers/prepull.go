@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"net/http"
+
+	"minecharts/cmd/apierror"
+	"minecharts/cmd/auth"
+	"minecharts/cmd/config"
+	"minecharts/cmd/kubernetes"
+	"minecharts/cmd/logging"
+	"minecharts/cmd/security"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PrePullResponse is returned by the image pre-pull endpoints.
+type PrePullResponse struct {
+	Enabled bool `json:"enabled"` // Maintained by the API, with MINECHARTS_PREPULL_ENABLED
+	kubernetes.PrePullStatus
+}
+
+// GetPrePullHandler reports the pre-pull of the server images on the nodes (admin only).
+//
+// @Summary      Get image pre-pull status
+// @Description  Returns the images pulled on every node by the pre-pull DaemonSet, what last triggered a pull, and on how many nodes it completed (admin only)
+// @Tags         admin
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200  {object}  PrePullResponse    "Pre-pull status"
+// @Failure      401  {object}  apierror.Error     "Authentication required"
+// @Failure      403  {object}  apierror.Error     "Permission denied"
+// @Failure      500  {object}  apierror.Error     "Server error"
+// @Failure      503  {object}  apierror.Error     "Cluster unreachable"
+// @Router       /admin/prepull [get]
+func GetPrePullHandler(c *gin.Context) {
+	status, err := kubernetes.GetPrePullStatus(c.Request.Context(), config.DefaultNamespace)
+	if err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to get pre-pull status")
+		return
+	}
+	c.JSON(http.StatusOK, PrePullResponse{Enabled: config.PrePullEnabled, PrePullStatus: *status})
+}
+
+// TriggerPrePullHandler pulls the server images again on every node, creating the
+// pre-pull DaemonSet if needed (admin only).
+//
+// @Summary      Trigger image pre-pull
+// @Description  Creates or rolls the pre-pull DaemonSet so that every node pulls the server images and the extra MINECHARTS_PREPULL_IMAGES again (admin only)
+// @Tags         admin
+// @Produce      json
+// @Security     BearerAuth
+// @Success      202  {object}  PrePullResponse    "Pre-pull triggered"
+// @Failure      401  {object}  apierror.Error     "Authentication required"
+// @Failure      403  {object}  apierror.Error     "Permission denied"
+// @Failure      500  {object}  apierror.Error     "Server error"
+// @Failure      503  {object}  apierror.Error     "Cluster unreachable"
+// @Router       /admin/prepull [post]
+func TriggerPrePullHandler(c *gin.Context) {
+	user, ok := auth.RequireCurrentUser(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	if err := kubernetes.EnsurePrePull(ctx, config.DefaultNamespace, kubernetes.PrePullImages(), "manual by "+user.Username); err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to trigger image pre-pull")
+		return
+	}
+
+	logging.K8s.WithContext(ctx).WithFields(
+		"username", user.Username,
+	).Info("Server image pre-pull triggered")
+	security.NewEvent(c, security.AdminAction, "trigger_image_prepull").WithUser(user).Emit()
+
+	status, err := kubernetes.GetPrePullStatus(ctx, config.DefaultNamespace)
+	if err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to get pre-pull status")
+		return
+	}
+	c.JSON(http.StatusAccepted, PrePullResponse{Enabled: config.PrePullEnabled, PrePullStatus: *status})
+}
+
+// DeletePrePullHandler deletes the pre-pull DaemonSet (admin only). With image
+// pre-pull enabled, it is created again when the API restarts.
+//
+// @Summary      Delete image pre-pull
+// @Description  Deletes the pre-pull DaemonSet; the images already pulled stay on the nodes until garbage collected (admin only)
+// @Tags         admin
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200  {object}  map[string]string  "Pre-pull deleted"
+// @Failure      401  {object}  apierror.Error     "Authentication required"
+// @Failure      403  {object}  apierror.Error     "Permission denied"
+// @Failure      500  {object}  apierror.Error     "Server error"
+// @Failure      503  {object}  apierror.Error     "Cluster unreachable"
+// @Router       /admin/prepull [delete]
+func DeletePrePullHandler(c *gin.Context) {
+	user, ok := auth.RequireCurrentUser(c)
+	if !ok {
+		return
+	}
+
+	if err := kubernetes.DeletePrePull(c.Request.Context(), config.DefaultNamespace); err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to delete image pre-pull")
+		return
+	}
+
+	security.NewEvent(c, security.AdminAction, "delete_image_prepull").WithUser(user).Emit()
+	c.JSON(http.StatusOK, gin.H{"message": "Image pre-pull deleted"})
+}
@@ -0,0 +1,543 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+
+	"minecharts/cmd/apierror"
+	"minecharts/cmd/auth"
+	"minecharts/cmd/config"
+	"minecharts/cmd/database"
+	"minecharts/cmd/kubernetes"
+	"minecharts/cmd/logging"
+
+	"github.com/gin-gonic/gin"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// CreateProxyRequest represents the request to create a proxy.
+type CreateProxyRequest struct {
+	Name           string `json:"name" binding:"required" example:"network"`
+	ForwardingMode string `json:"forwardingMode" example:"modern"` // "modern" (default), "legacy", "bungeeguard" or "none"
+}
+
+// RegisterProxyServerRequest represents the request to register a server behind a proxy.
+type RegisterProxyServerRequest struct {
+	ServerName string `json:"serverName" binding:"required" example:"lobby"`
+	Lobby      bool   `json:"lobby"` // Players join the lobbies first
+}
+
+// ExposeProxyRequest represents the request to expose a proxy.
+type ExposeProxyRequest struct {
+	ExposureType string `json:"exposureType" binding:"required" example:"LoadBalancer"`
+	Domain       string `json:"domain" example:"play.example.com"`
+	Port         int32  `json:"port" example:"25565"`
+}
+
+// ProxyResponse is a proxy with the servers registered behind it.
+type ProxyResponse struct {
+	*database.Proxy
+	Servers          []*database.ProxyServer `json:"servers"`
+	Addresses        []ServerAddress         `json:"addresses,omitempty"`
+	ForwardingSecret string                  `json:"forwardingSecret,omitempty"` // Only returned to the users who can register servers
+}
+
+// CreateProxyHandler creates a Velocity proxy without members.
+//
+// @Summary      Create proxy
+// @Description  Creates a Velocity proxy deployment, with a random forwarding secret and a velocity.toml rewritten as servers are registered behind it. The proxy is only reachable from the cluster until it is exposed
+// @Tags         proxies
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Security     APIKeyAuth
+// @Param        request  body      CreateProxyRequest  true  "Proxy configuration"
+// @Success      201      {object}  ProxyResponse       "Proxy created"
+// @Failure      400      {object}  apierror.Error      "Invalid request"
+// @Failure      401      {object}  apierror.Error      "Authentication required"
+// @Failure      403      {object}  apierror.Error      "Permission denied"
+// @Failure      409      {object}  apierror.Error      "Proxy already exists"
+// @Failure      500      {object}  apierror.Error      "Server error"
+// @Router       /proxies [post]
+func CreateProxyHandler(c *gin.Context) {
+	var req CreateProxyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Invalid(c, err)
+		return
+	}
+	if len(req.Name) > maxServerNameLength || !serverNamePattern.MatchString(req.Name) {
+		apierror.Write(c, http.StatusBadRequest, "name must consist of lowercase letters, digits and '-', and start and end with an alphanumeric character")
+		return
+	}
+	if req.ForwardingMode == "" {
+		req.ForwardingMode = "modern"
+	}
+	req.ForwardingMode = strings.ToLower(req.ForwardingMode)
+	if !slices.Contains(kubernetes.ProxyForwardingModes, req.ForwardingMode) {
+		apierror.Write(c, http.StatusBadRequest, "forwardingMode must be one of "+strings.Join(kubernetes.ProxyForwardingModes, ", "))
+		return
+	}
+
+	user, ok := auth.RequireCurrentUser(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	proxy := &database.Proxy{
+		Name:           req.Name,
+		OwnerID:        user.ID,
+		DeploymentName: config.ProxyDeploymentPrefix + req.Name,
+		ForwardingMode: req.ForwardingMode,
+	}
+	if err := database.GetDB().CreateProxy(ctx, proxy); err != nil {
+		if errors.Is(err, database.ErrProxyExists) {
+			apierror.Write(c, http.StatusConflict, "Proxy already exists")
+			return
+		}
+		apierror.Write(c, http.StatusInternalServerError, "Failed to create proxy")
+		return
+	}
+
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		_ = database.GetDB().DeleteProxy(ctx, proxy.ID)
+		apierror.Write(c, http.StatusInternalServerError, "Failed to generate forwarding secret")
+		return
+	}
+	secret := hex.EncodeToString(buf)
+
+	if err := kubernetes.CreateProxy(ctx, config.DefaultNamespace, proxy.DeploymentName, proxy.ForwardingMode, secret); err != nil {
+		logging.Server.WithContext(ctx).WithFields(
+			"proxy_name", proxy.Name,
+			"deployment", proxy.DeploymentName,
+			"error", err.Error(),
+		).Error("Failed to create proxy")
+		// Nothing is left half created
+		_ = kubernetes.DeleteProxy(ctx, config.DefaultNamespace, proxy.DeploymentName)
+		_ = database.GetDB().DeleteProxy(ctx, proxy.ID)
+		apierror.Write(c, http.StatusInternalServerError, "Failed to create proxy: "+err.Error())
+		return
+	}
+
+	logging.Server.WithContext(ctx).WithFields(
+		"proxy_name", proxy.Name,
+		"deployment", proxy.DeploymentName,
+		"forwarding_mode", proxy.ForwardingMode,
+		"user_id", user.ID,
+		"username", user.Username,
+	).Info("Proxy created successfully")
+
+	c.JSON(http.StatusCreated, ProxyResponse{
+		Proxy:            proxy,
+		Servers:          []*database.ProxyServer{},
+		ForwardingSecret: secret,
+	})
+}
+
+// ListProxiesHandler lists the proxies the user can view.
+//
+// @Summary      List proxies
+// @Description  Lists the proxies owned by users whose servers the current user can view
+// @Tags         proxies
+// @Produce      json
+// @Security     BearerAuth
+// @Security     APIKeyAuth
+// @Success      200  {array}   database.Proxy     "List of proxies"
+// @Failure      401  {object}  apierror.Error     "Authentication required"
+// @Failure      500  {object}  apierror.Error     "Server error"
+// @Router       /proxies [get]
+func ListProxiesHandler(c *gin.Context) {
+	user, ok := auth.RequireCurrentUser(c)
+	if !ok {
+		return
+	}
+
+	proxies, err := database.GetDB().ListProxies(c.Request.Context())
+	if err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to list proxies")
+		return
+	}
+
+	visible := []*database.Proxy{}
+	for _, proxy := range proxies {
+		if user.HasServerPermission(proxy.OwnerID, database.PermViewServer) {
+			visible = append(visible, proxy)
+		}
+	}
+	c.JSON(http.StatusOK, visible)
+}
+
+// GetProxyHandler returns a proxy with its members and addresses.
+//
+// @Summary      Get proxy
+// @Description  Returns a proxy, the servers registered behind it and the addresses players join it at. The forwarding secret the members need is only returned to the users who can register servers behind the proxy
+// @Tags         proxies
+// @Produce      json
+// @Security     BearerAuth
+// @Security     APIKeyAuth
+// @Param        proxyName  path      string             true  "Proxy name"
+// @Success      200        {object}  ProxyResponse      "Proxy"
+// @Failure      401        {object}  apierror.Error     "Authentication required"
+// @Failure      404        {object}  apierror.Error     "Proxy not found"
+// @Failure      500        {object}  apierror.Error     "Server error"
+// @Router       /proxies/{proxyName} [get]
+func GetProxyHandler(c *gin.Context) {
+	user, proxy, ok := loadProxy(c, database.PermViewServer)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	members, err := database.GetDB().ListProxyServers(ctx, proxy.ID)
+	if err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to list proxy servers")
+		return
+	}
+
+	response := ProxyResponse{Proxy: proxy, Servers: members}
+	if service, err := kubernetes.GetServiceDetails(ctx, config.DefaultNamespace, proxy.DeploymentName+"-svc"); err == nil {
+		response.Addresses = serviceAddresses(service)
+	}
+	if user.HasServerPermission(proxy.OwnerID, database.PermExposeServer) {
+		secret, err := kubernetes.GetSecretValue(ctx, config.DefaultNamespace,
+			kubernetes.ProxyForwardingSecretName(proxy.DeploymentName), kubernetes.ProxyForwardingSecretKey)
+		if err == nil {
+			response.ForwardingSecret = secret
+		}
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// DeleteProxyHandler deletes a proxy. The servers behind it are left running.
+//
+// @Summary      Delete proxy
+// @Description  Deletes the deployment, service, configuration and forwarding secret of a proxy. The servers registered behind it are not deleted
+// @Tags         proxies
+// @Produce      json
+// @Security     BearerAuth
+// @Security     APIKeyAuth
+// @Param        proxyName  path      string             true  "Proxy name"
+// @Success      200        {object}  map[string]string  "Proxy deleted"
+// @Failure      401        {object}  apierror.Error     "Authentication required"
+// @Failure      403        {object}  apierror.Error     "Permission denied"
+// @Failure      404        {object}  apierror.Error     "Proxy not found"
+// @Failure      500        {object}  apierror.Error     "Server error"
+// @Router       /proxies/{proxyName} [delete]
+func DeleteProxyHandler(c *gin.Context) {
+	user, proxy, ok := loadProxy(c, database.PermDeleteServer)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	if err := kubernetes.DeleteProxy(ctx, config.DefaultNamespace, proxy.DeploymentName); err != nil {
+		logging.Server.WithContext(ctx).WithFields(
+			"proxy_name", proxy.Name,
+			"error", err.Error(),
+		).Error("Failed to delete proxy resources")
+		apierror.Write(c, http.StatusInternalServerError, "Failed to delete proxy: "+err.Error())
+		return
+	}
+	if err := database.GetDB().DeleteProxy(ctx, proxy.ID); err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to delete proxy record")
+		return
+	}
+
+	logging.Server.WithContext(ctx).WithFields(
+		"proxy_name", proxy.Name,
+		"deployment", proxy.DeploymentName,
+		"user_id", user.ID,
+		"username", user.Username,
+	).Info("Proxy deleted successfully")
+
+	c.JSON(http.StatusOK, gin.H{"message": "Proxy deleted", "deploymentName": proxy.DeploymentName})
+}
+
+// RegisterProxyServerHandler registers a server behind a proxy.
+//
+// @Summary      Register server behind proxy
+// @Description  Adds a server to the velocity.toml of a proxy, or changes whether it is a lobby, and restarts the proxy. Servers that are not exposed get a ClusterIP service for the proxy to reach them. The server must accept the forwarding of the proxy, with ONLINE_MODE=FALSE and the forwarding secret. Bedrock servers cannot be registered
+// @Tags         proxies
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Security     APIKeyAuth
+// @Param        proxyName  path      string                      true  "Proxy name"
+// @Param        request    body      RegisterProxyServerRequest  true  "Server to register"
+// @Success      200        {object}  ProxyResponse               "Server registered"
+// @Failure      400        {object}  apierror.Error              "Invalid request"
+// @Failure      401        {object}  apierror.Error              "Authentication required"
+// @Failure      403        {object}  apierror.Error              "Permission denied"
+// @Failure      404        {object}  apierror.Error              "Proxy or server not found"
+// @Failure      500        {object}  apierror.Error              "Server error"
+// @Router       /proxies/{proxyName}/servers [post]
+func RegisterProxyServerHandler(c *gin.Context) {
+	user, proxy, ok := loadProxy(c, database.PermExposeServer)
+	if !ok {
+		return
+	}
+	var req RegisterProxyServerRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Invalid(c, err)
+		return
+	}
+
+	ctx := c.Request.Context()
+	server, ok := loadProxyMember(c, user, req.ServerName)
+	if !ok {
+		return
+	}
+	if isBedrock(server.Spec) {
+		apierror.Write(c, http.StatusBadRequest, "Velocity only proxies Java clients, bedrock servers cannot be registered")
+		return
+	}
+
+	if err := database.GetDB().SaveProxyServer(ctx, &database.ProxyServer{
+		ProxyID:  proxy.ID,
+		ServerID: server.ID,
+		Lobby:    req.Lobby,
+		AddedBy:  user.ID,
+	}); err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to register server")
+		return
+	}
+
+	members, err := syncProxyConfig(ctx, proxy)
+	if err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to update proxy configuration: "+err.Error())
+		return
+	}
+
+	logging.Server.WithContext(ctx).WithFields(
+		"proxy_name", proxy.Name,
+		"server_name", server.ServerName,
+		"lobby", req.Lobby,
+		"user_id", user.ID,
+		"username", user.Username,
+	).Info("Server registered behind proxy")
+
+	c.JSON(http.StatusOK, ProxyResponse{Proxy: proxy, Servers: members})
+}
+
+// UnregisterProxyServerHandler removes a server from a proxy.
+//
+// @Summary      Unregister server from proxy
+// @Description  Removes a server from the velocity.toml of a proxy and restarts the proxy. The server itself is left as is
+// @Tags         proxies
+// @Produce      json
+// @Security     BearerAuth
+// @Security     APIKeyAuth
+// @Param        proxyName   path      string             true  "Proxy name"
+// @Param        serverName  path      string             true  "Server name"
+// @Success      200         {object}  ProxyResponse      "Server unregistered"
+// @Failure      401         {object}  apierror.Error     "Authentication required"
+// @Failure      403         {object}  apierror.Error     "Permission denied"
+// @Failure      404         {object}  apierror.Error     "Proxy or server not found"
+// @Failure      500         {object}  apierror.Error     "Server error"
+// @Router       /proxies/{proxyName}/servers/{serverName} [delete]
+func UnregisterProxyServerHandler(c *gin.Context) {
+	user, proxy, ok := loadProxy(c, database.PermExposeServer)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	server, err := database.GetDB().GetServerByName(ctx, c.Param("serverName"))
+	if err != nil {
+		apierror.Respond(c, apierror.New(http.StatusNotFound, apierror.CodeServerNotFound, "Server not found"))
+		return
+	}
+	if err := database.GetDB().DeleteProxyServer(ctx, proxy.ID, server.ID); err != nil {
+		if errors.Is(err, database.ErrProxyServerNotFound) {
+			apierror.Write(c, http.StatusNotFound, "Server not registered behind the proxy")
+			return
+		}
+		apierror.Write(c, http.StatusInternalServerError, "Failed to unregister server")
+		return
+	}
+
+	members, err := syncProxyConfig(ctx, proxy)
+	if err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to update proxy configuration: "+err.Error())
+		return
+	}
+
+	logging.Server.WithContext(ctx).WithFields(
+		"proxy_name", proxy.Name,
+		"server_name", server.ServerName,
+		"user_id", user.ID,
+		"username", user.Username,
+	).Info("Server unregistered from proxy")
+
+	c.JSON(http.StatusOK, ProxyResponse{Proxy: proxy, Servers: members})
+}
+
+// ExposeProxyHandler exposes a proxy for players to join the network through it.
+//
+// @Summary      Expose proxy
+// @Description  Creates the service of a proxy, replacing the previous one, as the expose endpoint of the servers does. The members usually stay unexposed, players reaching them through the proxy
+// @Tags         proxies
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Security     APIKeyAuth
+// @Param        proxyName  path      string              true  "Proxy name"
+// @Param        request    body      ExposeProxyRequest  true  "Exposure configuration"
+// @Success      200        {object}  map[string]interface{}  "Service created"
+// @Failure      400        {object}  apierror.Error          "Invalid request"
+// @Failure      401        {object}  apierror.Error          "Authentication required"
+// @Failure      403        {object}  apierror.Error          "Permission denied"
+// @Failure      404        {object}  apierror.Error          "Proxy not found"
+// @Failure      500        {object}  apierror.Error          "Server error"
+// @Router       /proxies/{proxyName}/expose [post]
+func ExposeProxyHandler(c *gin.Context) {
+	user, proxy, ok := loadProxy(c, database.PermExposeServer)
+	if !ok {
+		return
+	}
+	var req ExposeProxyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Invalid(c, err)
+		return
+	}
+
+	var serviceType corev1.ServiceType
+	annotations := map[string]string{}
+	switch req.ExposureType {
+	case "ClusterIP":
+		serviceType = corev1.ServiceTypeClusterIP
+	case "NodePort":
+		serviceType = corev1.ServiceTypeNodePort
+	case "LoadBalancer":
+		serviceType = corev1.ServiceTypeLoadBalancer
+	case "MCRouter":
+		if req.Domain == "" {
+			apierror.Write(c, http.StatusBadRequest, "Domain is required for MCRouter exposure type")
+			return
+		}
+		serviceType = corev1.ServiceTypeClusterIP
+		annotations[kubernetes.MCRouterAnnotation] = req.Domain
+	default:
+		apierror.Write(c, http.StatusBadRequest, "Invalid exposureType. Must be one of: ClusterIP, NodePort, LoadBalancer, MCRouter")
+		return
+	}
+	if req.Port <= 0 {
+		req.Port = 25565
+	}
+
+	ctx := c.Request.Context()
+	serviceName := proxy.DeploymentName + "-svc"
+	_ = kubernetes.DeleteService(ctx, config.DefaultNamespace, serviceName)
+	service, err := kubernetes.CreateService(ctx, config.DefaultNamespace, proxy.DeploymentName, serviceType, req.Port, 0, annotations)
+	if err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to create service: "+err.Error())
+		return
+	}
+
+	logging.Server.WithContext(ctx).WithFields(
+		"proxy_name", proxy.Name,
+		"service", serviceName,
+		"exposure_type", req.ExposureType,
+		"user_id", user.ID,
+		"username", user.Username,
+	).Info("Proxy exposure completed successfully")
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":      "Service created",
+		"serviceName":  service.Name,
+		"exposureType": req.ExposureType,
+		"serviceType":  string(serviceType),
+		"addresses":    serviceAddresses(service),
+	})
+}
+
+// loadProxy returns the proxy of the request once the current user is checked for
+// the permission on the servers of its owner. Proxies the user cannot view are
+// reported as not found.
+func loadProxy(c *gin.Context, permission int64) (*database.User, *database.Proxy, bool) {
+	user, ok := auth.RequireCurrentUser(c)
+	if !ok {
+		return nil, nil, false
+	}
+
+	proxy, err := database.GetDB().GetProxyByName(c.Request.Context(), c.Param("proxyName"))
+	if err != nil || !user.HasServerPermission(proxy.OwnerID, database.PermViewServer) {
+		apierror.Write(c, http.StatusNotFound, "Proxy not found")
+		return nil, nil, false
+	}
+	if !user.HasServerPermission(proxy.OwnerID, permission) {
+		apierror.Write(c, http.StatusForbidden, "Permission denied")
+		return nil, nil, false
+	}
+	return user, proxy, true
+}
+
+// loadProxyMember returns a server to register behind a proxy, which the user
+// must be allowed to expose.
+func loadProxyMember(c *gin.Context, user *database.User, serverName string) (*database.MinecraftServer, bool) {
+	server, err := database.GetDB().GetServerByName(c.Request.Context(), serverName)
+	if err != nil {
+		apierror.Respond(c, apierror.New(http.StatusNotFound, apierror.CodeServerNotFound, "Server not found"))
+		return nil, false
+	}
+	if !auth.HasServerAccess(c.Request.Context(), user, server, database.PermExposeServer) {
+		auth.DenyServerAccess(c, user, server)
+		return nil, false
+	}
+	return server, true
+}
+
+// syncProxyConfig rewrites the velocity.toml of a proxy from its recorded members,
+// and returns them.
+func syncProxyConfig(ctx context.Context, proxy *database.Proxy) ([]*database.ProxyServer, error) {
+	members, err := database.GetDB().ListProxyServers(ctx, proxy.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	proxyMembers := make([]kubernetes.ProxyMember, 0, len(members))
+	for _, member := range members {
+		proxyMembers = append(proxyMembers, kubernetes.ProxyMember{
+			Name:           member.ServerName,
+			DeploymentName: member.DeploymentName,
+			Namespace:      member.Namespace,
+			Lobby:          member.Lobby,
+		})
+	}
+	if err := kubernetes.UpdateProxyConfig(ctx, config.DefaultNamespace, proxy.DeploymentName, proxy.ForwardingMode, proxyMembers); err != nil {
+		logging.Server.WithContext(ctx).WithFields(
+			"proxy_name", proxy.Name,
+			"error", err.Error(),
+		).Error("Failed to update proxy configuration")
+		return nil, err
+	}
+	return members, nil
+}
+
+// syncServerProxies rewrites the configuration of the proxies a server is registered
+// behind, after it is renamed or, with leave, before it is deleted. Failures are only
+// logged, the proxies are fixed by registering their servers again.
+func syncServerProxies(ctx context.Context, serverID int64, leave bool) {
+	proxies, err := database.GetDB().ListProxies(ctx)
+	if err != nil {
+		return
+	}
+	for _, proxy := range proxies {
+		members, err := database.GetDB().ListProxyServers(ctx, proxy.ID)
+		if err != nil || !slices.ContainsFunc(members, func(member *database.ProxyServer) bool { return member.ServerID == serverID }) {
+			continue
+		}
+		if leave {
+			if err := database.GetDB().DeleteProxyServer(ctx, proxy.ID, serverID); err != nil {
+				continue
+			}
+		}
+		_, _ = syncProxyConfig(ctx, proxy)
+	}
+}
@@ -0,0 +1,344 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"minecharts/cmd/apierror"
+	"minecharts/cmd/auth"
+	"minecharts/cmd/config"
+	"minecharts/cmd/database"
+	"minecharts/cmd/logging"
+	"minecharts/cmd/security"
+
+	"github.com/gin-gonic/gin"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// defaultServerMemory is the heap size the itzg/minecraft-server image uses when MEMORY is not set.
+const defaultServerMemory = "1G"
+
+// UserQuotaRequest represents the limits set on a user. Zero or empty values are unlimited.
+type UserQuotaRequest struct {
+	MaxServers int    `json:"max_servers" binding:"min=0" example:"3"`
+	MaxMemory  string `json:"max_memory" example:"12Gi"`
+	MaxStorage string `json:"max_storage" example:"100Gi"`
+}
+
+// QuotaUsage is the amount of resources used by a user's servers.
+type QuotaUsage struct {
+	Servers int    `json:"servers"`
+	Memory  string `json:"memory"`
+	Storage string `json:"storage"`
+}
+
+// quotaUsage sums the resources held by the servers a user owns.
+type quotaUsage struct {
+	servers int
+	memory  resource.Quantity
+	storage resource.Quantity
+}
+
+func (u quotaUsage) response() QuotaUsage {
+	return QuotaUsage{Servers: u.servers, Memory: u.memory.String(), Storage: u.storage.String()}
+}
+
+// serverMemory returns the memory reserved for a server: its container memory limit
+// or request when set, otherwise the JVM heap size.
+func serverMemory(spec database.ServerSpec) resource.Quantity {
+	if spec.Resources != nil {
+		for _, value := range []string{spec.Resources.MemoryLimit, spec.Resources.MemoryRequest} {
+			if quantity, err := resource.ParseQuantity(value); value != "" && err == nil {
+				return quantity
+			}
+		}
+	}
+
+	memory := spec.Memory
+	if memory == "" {
+		memory = defaultServerMemory
+	}
+	quantity, err := jvmMemory(memory)
+	if err != nil {
+		return resource.MustParse("1Gi")
+	}
+	return quantity
+}
+
+// serverStorage returns the size of a server's volume.
+func serverStorage(spec database.ServerSpec) resource.Quantity {
+	size := spec.StorageSize
+	if size == "" {
+		size = config.StorageSize
+	}
+	quantity, err := resource.ParseQuantity(size)
+	if err != nil {
+		return resource.Quantity{}
+	}
+	return quantity
+}
+
+// currentQuotaUsage computes the resources used by the servers owned by a user.
+func currentQuotaUsage(ctx context.Context, db database.DB, userID int64) (quotaUsage, error) {
+	servers, err := db.ListServersByOwner(ctx, userID)
+	if err != nil {
+		return quotaUsage{}, err
+	}
+	return serversQuotaUsage(servers), nil
+}
+
+// serversQuotaUsage sums the resources held by servers.
+func serversQuotaUsage(servers []*database.MinecraftServer) quotaUsage {
+	usage := quotaUsage{servers: len(servers)}
+	for _, server := range servers {
+		usage.memory.Add(serverMemory(server.Spec))
+		usage.storage.Add(serverStorage(server.Spec))
+	}
+	return usage
+}
+
+// checkQuota verifies that creating a server with the given spec keeps the user within
+// their quota. It returns the 403 error to send when the quota would be exceeded,
+// or nil when the creation is allowed. Within a transaction of db, the creation can
+// be recorded before another one reads the usage.
+func checkQuota(ctx context.Context, db database.DB, userID int64, spec database.ServerSpec) (*apierror.Error, error) {
+	quota, err := db.GetUserQuota(ctx, userID)
+	if errors.Is(err, database.ErrQuotaNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	usage, err := currentQuotaUsage(ctx, db, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	memory := serverMemory(spec)
+	storage := serverStorage(spec)
+
+	var exceeded []string
+	if quota.MaxServers > 0 && usage.servers+1 > quota.MaxServers {
+		exceeded = append(exceeded, "servers")
+	}
+	if quota.MaxMemory != "" {
+		total := usage.memory.DeepCopy()
+		total.Add(memory)
+		if limit, err := resource.ParseQuantity(quota.MaxMemory); err == nil && total.Cmp(limit) > 0 {
+			exceeded = append(exceeded, "memory")
+		}
+	}
+	if quota.MaxStorage != "" {
+		total := usage.storage.DeepCopy()
+		total.Add(storage)
+		if limit, err := resource.ParseQuantity(quota.MaxStorage); err == nil && total.Cmp(limit) > 0 {
+			exceeded = append(exceeded, "storage")
+		}
+	}
+
+	if len(exceeded) == 0 {
+		return nil, nil
+	}
+
+	logging.Server.WithContext(ctx).WithFields(
+		"user_id", userID,
+		"exceeded", strings.Join(exceeded, ","),
+		"servers", usage.servers,
+		"memory", usage.memory.String(),
+		"storage", usage.storage.String(),
+	).Warn("Server creation refused: quota exceeded")
+
+	return apierror.New(http.StatusForbidden, apierror.CodeQuotaExceeded, "Quota exceeded: "+strings.Join(exceeded, ", ")).
+		With("exceeded", exceeded).
+		With("quota", quota).
+		With("usage", usage.response()).
+		With("requested", QuotaUsage{
+			Servers: 1,
+			Memory:  memory.String(),
+			Storage: storage.String(),
+		}), nil
+}
+
+// enforceQuota writes the 403 response and returns false when creating the server
+// would exceed the owner's quota. recordNewServer checks it again when the server is
+// recorded.
+func enforceQuota(c *gin.Context, ownerID int64, spec database.ServerSpec) bool {
+	exceeded, err := checkQuota(c.Request.Context(), database.GetDB(), ownerID, spec)
+	if err != nil {
+		logging.DB.WithContext(c.Request.Context()).WithFields(
+			"user_id", ownerID,
+			"error", err.Error(),
+		).Error("Failed to check user quota")
+		apierror.Write(c, http.StatusInternalServerError, "Failed to check quota")
+		return false
+	}
+	if exceeded != nil {
+		apierror.Respond(c, exceeded)
+		return false
+	}
+	return true
+}
+
+// GetUserQuotaHandler returns the quota and current usage of a user (admin only).
+//
+// @Summary      Get user quota
+// @Description  Returns the quota of a user, or null when unlimited, along with their current usage (admin only)
+// @Tags         users
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id   path      int                     true  "User ID"
+// @Success      200  {object}  map[string]interface{}  "Quota and usage"
+// @Failure      400  {object}  apierror.Error          "Invalid user ID"
+// @Failure      401  {object}  apierror.Error          "Authentication required"
+// @Failure      403  {object}  apierror.Error          "Permission denied"
+// @Failure      500  {object}  apierror.Error          "Server error"
+// @Router       /users/{id}/quota [get]
+func GetUserQuotaHandler(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		apierror.Write(c, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	ctx := c.Request.Context()
+	quota, err := database.GetDB().GetUserQuota(ctx, id)
+	if err != nil && !errors.Is(err, database.ErrQuotaNotFound) {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to get user quota")
+		return
+	}
+
+	usage, err := currentQuotaUsage(ctx, database.GetDB(), id)
+	if err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to compute quota usage")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"quota": quota, "usage": usage.response()})
+}
+
+// SetUserQuotaHandler creates or replaces the quota of a user (admin only).
+// Existing servers are kept even if they exceed the new quota.
+//
+// @Summary      Set user quota
+// @Description  Sets the maximum number of servers, total memory and total storage of a user (admin only); zero or empty values are unlimited
+// @Tags         users
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id       path      int                 true  "User ID"
+// @Param        request  body      UserQuotaRequest    true  "Quota limits"
+// @Success      200      {object}  database.UserQuota  "Quota updated"
+// @Failure      400      {object}  apierror.Error      "Invalid request"
+// @Failure      401      {object}  apierror.Error      "Authentication required"
+// @Failure      403      {object}  apierror.Error      "Permission denied"
+// @Failure      404      {object}  apierror.Error      "User not found"
+// @Failure      500      {object}  apierror.Error      "Server error"
+// @Router       /users/{id}/quota [put]
+func SetUserQuotaHandler(c *gin.Context) {
+	adminUser, ok := auth.RequireCurrentUser(c)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		apierror.Write(c, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	var req UserQuotaRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		logging.API.InvalidRequest.WithContext(c.Request.Context()).WithFields(
+			"error", err.Error(),
+			"remote_ip", c.ClientIP(),
+		).Warn("Invalid user quota request format")
+		apierror.Write(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	for field, value := range map[string]string{"max_memory": req.MaxMemory, "max_storage": req.MaxStorage} {
+		if value == "" {
+			continue
+		}
+		if _, err := resource.ParseQuantity(value); err != nil {
+			apierror.Write(c, http.StatusBadRequest, fmt.Sprintf("%s %q is invalid, expected a quantity such as 16Gi", field, value))
+			return
+		}
+	}
+
+	db := database.GetDB()
+	ctx := c.Request.Context()
+	target, err := db.GetUserByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, database.ErrUserNotFound) {
+			apierror.Write(c, http.StatusNotFound, "User not found")
+		} else {
+			apierror.Write(c, http.StatusInternalServerError, "Failed to get user")
+		}
+		return
+	}
+
+	quota := &database.UserQuota{
+		UserID:     id,
+		MaxServers: req.MaxServers,
+		MaxMemory:  req.MaxMemory,
+		MaxStorage: req.MaxStorage,
+	}
+	if err := db.SetUserQuota(ctx, quota); err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to set user quota")
+		return
+	}
+
+	security.NewEvent(c, security.AdminAction, "set_user_quota").WithUser(adminUser).WithTarget(target.Username).
+		WithDetail("max_servers", strconv.Itoa(quota.MaxServers)).
+		WithDetail("max_memory", quota.MaxMemory).
+		WithDetail("max_storage", quota.MaxStorage).Emit()
+
+	c.JSON(http.StatusOK, quota)
+}
+
+// DeleteUserQuotaHandler removes the quota of a user, leaving them unlimited (admin only).
+//
+// @Summary      Delete user quota
+// @Description  Removes the quota of a user so they are no longer limited (admin only)
+// @Tags         users
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id   path      int                true  "User ID"
+// @Success      200  {object}  map[string]string  "Quota removed"
+// @Failure      400  {object}  apierror.Error     "Invalid user ID"
+// @Failure      401  {object}  apierror.Error     "Authentication required"
+// @Failure      403  {object}  apierror.Error     "Permission denied"
+// @Failure      404  {object}  apierror.Error     "User has no quota"
+// @Failure      500  {object}  apierror.Error     "Server error"
+// @Router       /users/{id}/quota [delete]
+func DeleteUserQuotaHandler(c *gin.Context) {
+	adminUser, ok := auth.RequireCurrentUser(c)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		apierror.Write(c, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	if err := database.GetDB().DeleteUserQuota(c.Request.Context(), id); err != nil {
+		if errors.Is(err, database.ErrQuotaNotFound) {
+			apierror.Write(c, http.StatusNotFound, "User has no quota")
+		} else {
+			apierror.Write(c, http.StatusInternalServerError, "Failed to delete user quota")
+		}
+		return
+	}
+
+	security.NewEvent(c, security.AdminAction, "delete_user_quota").WithUser(adminUser).
+		WithTarget(strconv.FormatInt(id, 10)).Emit()
+
+	c.JSON(http.StatusOK, gin.H{"message": "User quota removed"})
+}
@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"minecharts/cmd/kubernetes"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// rconEnabled reports whether the server is requested with ENABLE_RCON.
+func rconEnabled(env map[string]string) bool {
+	return strings.EqualFold(env["ENABLE_RCON"], "true")
+}
+
+// setupRCONPassword stores the RCON password of a new server in a secret and
+// returns the environment variable that injects it into the container.
+// A random password is generated when the request does not provide one.
+func setupRCONPassword(ctx context.Context, namespace, deploymentName, password string) (corev1.EnvVar, error) {
+	if password == "" {
+		buf := make([]byte, 16)
+		if _, err := rand.Read(buf); err != nil {
+			return corev1.EnvVar{}, fmt.Errorf("failed to generate RCON password: %w", err)
+		}
+		password = hex.EncodeToString(buf)
+	}
+
+	secretName := kubernetes.RCONSecretName(deploymentName)
+	if err := kubernetes.CreateSecret(ctx, namespace, secretName, deploymentName, map[string]string{
+		kubernetes.RCONSecretKey: password,
+	}); err != nil {
+		return corev1.EnvVar{}, fmt.Errorf("failed to store RCON password: %w", err)
+	}
+
+	return corev1.EnvVar{
+		Name: "RCON_PASSWORD",
+		ValueFrom: &corev1.EnvVarSource{
+			SecretKeyRef: &corev1.SecretKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{Name: secretName},
+				Key:                  kubernetes.RCONSecretKey,
+			},
+		},
+	}, nil
+}
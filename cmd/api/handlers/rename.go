@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"net/http"
+
+	"minecharts/cmd/apierror"
+	"minecharts/cmd/auth"
+	"minecharts/cmd/config"
+	"minecharts/cmd/database"
+	"minecharts/cmd/kubernetes"
+	"minecharts/cmd/logging"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RenameServerRequest represents the request to rename a Minecraft server.
+type RenameServerRequest struct {
+	NewName string `json:"newName" binding:"required" example:"hardcore"`
+}
+
+// RenameServerHandler renames a stopped Minecraft server.
+// The deployment, service and RCON secret are recreated under the new name. The PVC
+// keeps its original name and stays attached to the server, the world is not copied.
+//
+// @Summary      Rename Minecraft server
+// @Description  Renames a stopped server. Its deployment, service and RCON secret are recreated under the new name; its PVC keeps its original name (returned as pvcName) and the world data is kept in place. Node ports are kept, a LoadBalancer service may get a new address
+// @Tags         servers
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Security     APIKeyAuth
+// @Param        serverName  path      string               true  "Server name"
+// @Param        request     body      RenameServerRequest  true  "New server name"
+// @Success      200         {object}  map[string]string    "Server renamed"
+// @Failure      400         {object}  apierror.Error       "Invalid request"
+// @Failure      401         {object}  apierror.Error       "Authentication required"
+// @Failure      403         {object}  apierror.Error       "Permission denied"
+// @Failure      404         {object}  apierror.Error       "Server not found"
+// @Failure      409         {object}  apierror.Error       "Server running or name already taken"
+// @Failure      500         {object}  apierror.Error       "Server error"
+// @Router       /servers/{serverName}/rename [post]
+func RenameServerHandler(c *gin.Context) {
+	var req RenameServerRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Invalid(c, err)
+		return
+	}
+
+	serverName := c.Param("serverName")
+	if err := validateServerName(req.NewName); err != nil {
+		apierror.Write(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if req.NewName == serverName {
+		apierror.Write(c, http.StatusBadRequest, "The new name is the current name")
+		return
+	}
+
+	user, ok := auth.RequireCurrentUser(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	db := database.GetDB()
+	server, err := db.GetServerByName(ctx, serverName)
+	if err != nil {
+		apierror.Respond(c, apierror.New(http.StatusNotFound, apierror.CodeServerNotFound, "Server not found"))
+		return
+	}
+	if _, err := db.GetServerByName(ctx, req.NewName); err == nil {
+		apierror.Write(c, http.StatusConflict, "A server with this name already exists")
+		return
+	}
+
+	namespace := kubernetes.ServerNamespace(server)
+	deployment, ok := kubernetes.CheckDeploymentExists(c, namespace, server.DeploymentName)
+	if !ok {
+		return
+	}
+	// The claim of a StatefulSet is named after it, and cannot be renamed or adopted by another
+	if kubernetes.IsStatefulSet(deployment) {
+		apierror.Write(c, http.StatusBadRequest, "StatefulSet servers cannot be renamed")
+		return
+	}
+	// Two deployments must never run on the same world
+	if deployment.Spec.Replicas == nil || *deployment.Spec.Replicas > 0 || deployment.Status.Replicas > 0 {
+		apierror.Write(c, http.StatusConflict, "Stop the server before renaming it")
+		return
+	}
+
+	newDeploymentName := config.DeploymentPrefix + req.NewName
+
+	logging.Server.WithContext(ctx).WithFields(
+		"server_name", serverName,
+		"new_name", req.NewName,
+		"deployment", server.DeploymentName,
+		"new_deployment", newDeploymentName,
+		"username", user.Username,
+		"remote_ip", c.ClientIP(),
+	).Info("Renaming Minecraft server")
+
+	// The record is renamed first, it is easier to roll back than the cluster
+	if err := db.RenameServer(ctx, serverName, req.NewName, newDeploymentName); err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to rename server record")
+		return
+	}
+
+	if err := kubernetes.RenameServerResources(ctx, namespace, server.DeploymentName, newDeploymentName, server.PVCName); err != nil {
+		logging.Server.WithContext(ctx).WithFields(
+			"server_name", serverName,
+			"new_name", req.NewName,
+			"error", err.Error(),
+		).Error("Failed to rename server resources")
+		if rollbackErr := db.RenameServer(ctx, req.NewName, serverName, server.DeploymentName); rollbackErr != nil {
+			logging.Server.WithContext(ctx).WithFields(
+				"server_name", serverName,
+				"new_name", req.NewName,
+				"error", rollbackErr.Error(),
+			).Error("Failed to roll back server record rename")
+		}
+		apierror.Write(c, http.StatusInternalServerError, "Failed to rename server: "+err.Error())
+		return
+	}
+
+	// The proxies reach the server at the service of its new deployment
+	syncServerProxies(ctx, server.ID, false)
+
+	logging.Server.Renamed.WithContext(ctx).WithFields(
+		"server_name", req.NewName,
+		"old_name", serverName,
+		"deployment", newDeploymentName,
+		"pvc", server.PVCName,
+		"username", user.Username,
+	).Info("Minecraft server renamed successfully")
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "Server renamed",
+		"serverName":     req.NewName,
+		"deploymentName": newDeploymentName,
+		"pvcName":        server.PVCName,
+	})
+}
@@ -0,0 +1,403 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"minecharts/cmd/apierror"
+	"minecharts/cmd/auth"
+	"minecharts/cmd/database"
+	"minecharts/cmd/kubernetes"
+	"minecharts/cmd/logging"
+	"minecharts/cmd/security"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RoleRequest creates or replaces a role. Permissions are named as listed by
+// GET /permissions, flags or groups.
+type RoleRequest struct {
+	Name        string   `json:"name" binding:"required,min=1,max=50" example:"moderators"`
+	Description string   `json:"description" example:"Start, stop and restart the servers"`
+	Permissions []string `json:"permissions" binding:"required" example:"PermStartServer,PermStopServer,PermRestartServer,PermViewServer"`
+}
+
+// RoleResponse is a role with the names of its permission flags.
+type RoleResponse struct {
+	*database.Role
+	PermissionNames []string `json:"permission_names" example:"PermRestartServer,PermStartServer,PermStopServer,PermViewServer"`
+}
+
+func roleResponse(role *database.Role) RoleResponse {
+	return RoleResponse{Role: role, PermissionNames: database.PermissionFlagNames(role.Permissions)}
+}
+
+// ListRolesHandler lists the roles (admin only).
+//
+// @Summary      List roles
+// @Description  Lists the roles, the named sets of permissions assigned to users (admin only)
+// @Tags         roles
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200  {array}   RoleResponse       "Roles"
+// @Failure      401  {object}  apierror.Error     "Authentication required"
+// @Failure      403  {object}  apierror.Error     "Permission denied"
+// @Failure      500  {object}  apierror.Error     "Server error"
+// @Router       /roles [get]
+func ListRolesHandler(c *gin.Context) {
+	roles, err := database.GetDB().ListRoles(c.Request.Context())
+	if err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to list roles")
+		return
+	}
+
+	response := make([]RoleResponse, len(roles))
+	for i, role := range roles {
+		response[i] = roleResponse(role)
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// GetRoleHandler returns a role (admin only).
+//
+// @Summary      Get role
+// @Description  Returns a role and its permissions (admin only)
+// @Tags         roles
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id   path      int                true  "Role ID"
+// @Success      200  {object}  RoleResponse       "Role"
+// @Failure      400  {object}  apierror.Error     "Invalid role ID"
+// @Failure      401  {object}  apierror.Error     "Authentication required"
+// @Failure      403  {object}  apierror.Error     "Permission denied"
+// @Failure      404  {object}  apierror.Error     "Role not found"
+// @Failure      500  {object}  apierror.Error     "Server error"
+// @Router       /roles/{id} [get]
+func GetRoleHandler(c *gin.Context) {
+	role, ok := loadRole(c, c.Param("id"))
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, roleResponse(role))
+}
+
+// CreateRoleHandler creates a role (admin only).
+//
+// @Summary      Create role
+// @Description  Creates a named set of permissions to assign to users (admin only)
+// @Tags         roles
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        request  body      RoleRequest        true  "Role"
+// @Success      201      {object}  RoleResponse       "Role created"
+// @Failure      400      {object}  apierror.Error     "Invalid request"
+// @Failure      401      {object}  apierror.Error     "Authentication required"
+// @Failure      403      {object}  apierror.Error     "Permission denied"
+// @Failure      409      {object}  apierror.Error     "Role name already exists"
+// @Failure      500      {object}  apierror.Error     "Server error"
+// @Router       /roles [post]
+func CreateRoleHandler(c *gin.Context) {
+	user, ok := auth.RequireCurrentUser(c)
+	if !ok {
+		return
+	}
+	role, ok := bindRoleRequest(c)
+	if !ok {
+		return
+	}
+
+	if err := database.GetDB().CreateRole(c.Request.Context(), role); err != nil {
+		if errors.Is(err, database.ErrRoleExists) {
+			apierror.Write(c, http.StatusConflict, "A role with this name already exists")
+		} else {
+			apierror.Write(c, http.StatusInternalServerError, "Failed to create role")
+		}
+		return
+	}
+
+	logging.Auth.WithContext(c.Request.Context()).WithFields(
+		"role_id", role.ID,
+		"role_name", role.Name,
+		"permissions", role.Permissions,
+		"admin_user_id", user.ID,
+		"admin_username", user.Username,
+	).Info("Role created")
+	security.NewEvent(c, security.AdminAction, "create_role").WithUser(user).WithTarget(role.Name).
+		WithDetail("permissions", strconv.FormatInt(role.Permissions, 10)).Emit()
+
+	c.JSON(http.StatusCreated, roleResponse(role))
+}
+
+// UpdateRoleHandler replaces a role (admin only). Its users get its new permissions
+// on their next request.
+//
+// @Summary      Update role
+// @Description  Replaces the name, description and permissions of a role, changing the permissions of its users (admin only)
+// @Tags         roles
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id       path      int                true  "Role ID"
+// @Param        request  body      RoleRequest        true  "Role"
+// @Success      200      {object}  RoleResponse       "Role updated"
+// @Failure      400      {object}  apierror.Error     "Invalid request"
+// @Failure      401      {object}  apierror.Error     "Authentication required"
+// @Failure      403      {object}  apierror.Error     "Permission denied"
+// @Failure      404      {object}  apierror.Error     "Role not found"
+// @Failure      409      {object}  apierror.Error     "Role name already exists"
+// @Failure      500      {object}  apierror.Error     "Server error"
+// @Router       /roles/{id} [put]
+func UpdateRoleHandler(c *gin.Context) {
+	user, ok := auth.RequireCurrentUser(c)
+	if !ok {
+		return
+	}
+	existing, ok := loadRole(c, c.Param("id"))
+	if !ok {
+		return
+	}
+	role, ok := bindRoleRequest(c)
+	if !ok {
+		return
+	}
+	role.ID, role.CreatedAt = existing.ID, existing.CreatedAt
+
+	if err := database.GetDB().UpdateRole(c.Request.Context(), role); err != nil {
+		switch {
+		case errors.Is(err, database.ErrRoleExists):
+			apierror.Write(c, http.StatusConflict, "A role with this name already exists")
+		case errors.Is(err, database.ErrRoleNotFound):
+			apierror.Write(c, http.StatusNotFound, "Role not found")
+		default:
+			apierror.Write(c, http.StatusInternalServerError, "Failed to update role")
+		}
+		return
+	}
+
+	logging.Auth.WithContext(c.Request.Context()).WithFields(
+		"role_id", role.ID,
+		"role_name", role.Name,
+		"old_permissions", existing.Permissions,
+		"new_permissions", role.Permissions,
+		"admin_user_id", user.ID,
+		"admin_username", user.Username,
+	).Info("Role updated")
+	security.NewEvent(c, security.AdminAction, "update_role").WithUser(user).WithTarget(role.Name).
+		WithDetail("old_permissions", strconv.FormatInt(existing.Permissions, 10)).
+		WithDetail("new_permissions", strconv.FormatInt(role.Permissions, 10)).Emit()
+	if role.Permissions != existing.Permissions {
+		// Permission changes change the servers the users of the role can access
+		kubernetes.RequestWhitelistSync()
+	}
+
+	c.JSON(http.StatusOK, roleResponse(role))
+}
+
+// DeleteRoleHandler deletes a role (admin only). Its users lose the permissions it
+// granted them.
+//
+// @Summary      Delete role
+// @Description  Deletes a role, unassigning it from its users (admin only)
+// @Tags         roles
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id   path      int                true  "Role ID"
+// @Success      200  {object}  map[string]string  "Role deleted"
+// @Failure      400  {object}  apierror.Error     "Invalid role ID"
+// @Failure      401  {object}  apierror.Error     "Authentication required"
+// @Failure      403  {object}  apierror.Error     "Permission denied"
+// @Failure      404  {object}  apierror.Error     "Role not found"
+// @Failure      500  {object}  apierror.Error     "Server error"
+// @Router       /roles/{id} [delete]
+func DeleteRoleHandler(c *gin.Context) {
+	user, ok := auth.RequireCurrentUser(c)
+	if !ok {
+		return
+	}
+	role, ok := loadRole(c, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	if err := database.GetDB().DeleteRole(c.Request.Context(), role.ID); err != nil {
+		if errors.Is(err, database.ErrRoleNotFound) {
+			apierror.Write(c, http.StatusNotFound, "Role not found")
+		} else {
+			apierror.Write(c, http.StatusInternalServerError, "Failed to delete role")
+		}
+		return
+	}
+
+	logging.Auth.WithContext(c.Request.Context()).WithFields(
+		"role_id", role.ID,
+		"role_name", role.Name,
+		"admin_user_id", user.ID,
+		"admin_username", user.Username,
+	).Info("Role deleted")
+	security.NewEvent(c, security.AdminAction, "delete_role").WithUser(user).WithTarget(role.Name).Emit()
+	kubernetes.RequestWhitelistSync()
+
+	c.JSON(http.StatusOK, gin.H{"message": "Role deleted"})
+}
+
+// AssignUserRoleHandler assigns a role to a user (admin only).
+//
+// @Summary      Assign role
+// @Description  Grants a user the permissions of a role, on top of those granted directly (admin only)
+// @Tags         roles
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id      path      int                     true  "User ID"
+// @Param        roleId  path      int                     true  "Role ID"
+// @Success      200     {object}  map[string]interface{}  "Role assigned"
+// @Failure      400     {object}  apierror.Error          "Invalid user or role ID"
+// @Failure      401     {object}  apierror.Error          "Authentication required"
+// @Failure      403     {object}  apierror.Error          "Permission denied"
+// @Failure      404     {object}  apierror.Error          "User or role not found"
+// @Failure      500     {object}  apierror.Error          "Server error"
+// @Router       /users/{id}/roles/{roleId} [post]
+func AssignUserRoleHandler(c *gin.Context) {
+	changeUserRole(c, true)
+}
+
+// UnassignUserRoleHandler removes a role from a user (admin only).
+//
+// @Summary      Unassign role
+// @Description  Removes a role from a user, who keeps the permissions granted directly (admin only)
+// @Tags         roles
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id      path      int                     true  "User ID"
+// @Param        roleId  path      int                     true  "Role ID"
+// @Success      200     {object}  map[string]interface{}  "Role unassigned"
+// @Failure      400     {object}  apierror.Error          "Invalid user or role ID"
+// @Failure      401     {object}  apierror.Error          "Authentication required"
+// @Failure      403     {object}  apierror.Error          "Permission denied"
+// @Failure      404     {object}  apierror.Error          "User or role not found, or role not assigned"
+// @Failure      500     {object}  apierror.Error          "Server error"
+// @Router       /users/{id}/roles/{roleId} [delete]
+func UnassignUserRoleHandler(c *gin.Context) {
+	changeUserRole(c, false)
+}
+
+// changeUserRole assigns or unassigns the :roleId role of the :id user, and answers
+// with the roles and effective permissions of the user.
+func changeUserRole(c *gin.Context, assign bool) {
+	adminUser, ok := auth.RequireCurrentUser(c)
+	if !ok {
+		return
+	}
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		apierror.Write(c, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+	role, ok := loadRole(c, c.Param("roleId"))
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	db := database.GetDB()
+	user, err := db.GetUserByID(ctx, id)
+	if errors.Is(err, database.ErrUserNotFound) {
+		apierror.Write(c, http.StatusNotFound, "User not found")
+		return
+	}
+	if err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to get user")
+		return
+	}
+
+	action := "assign_role"
+	if assign {
+		err = db.AssignUserRole(ctx, user.ID, role.ID)
+	} else {
+		action = "unassign_role"
+		err = db.UnassignUserRole(ctx, user.ID, role.ID)
+	}
+	if errors.Is(err, database.ErrRoleNotAssigned) {
+		apierror.Write(c, http.StatusNotFound, "Role "+role.Name+" is not assigned to "+user.Username)
+		return
+	}
+	if err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to "+strings.ReplaceAll(action, "_", " "))
+		return
+	}
+
+	oldPermissions := user.Permissions
+	if user, err = db.GetUserByID(ctx, id); err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to get user")
+		return
+	}
+
+	logging.Auth.WithContext(ctx).WithFields(
+		"admin_user_id", adminUser.ID,
+		"admin_username", adminUser.Username,
+		"target_user_id", user.ID,
+		"role_name", role.Name,
+		"assigned", assign,
+		"old_permissions", oldPermissions,
+		"new_permissions", user.Permissions,
+	).Info("User roles updated successfully")
+	security.NewEvent(c, security.AdminAction, action).WithUser(adminUser).WithTarget(user.Username).
+		WithDetail("role", role.Name).
+		WithDetail("new_permissions", strconv.FormatInt(user.Permissions, 10)).Emit()
+	kubernetes.RequestWhitelistSync()
+
+	c.JSON(http.StatusOK, gin.H{
+		"user_id":         user.ID,
+		"roles":           user.Roles,
+		"old_permissions": oldPermissions,
+		"new_permissions": user.Permissions,
+	})
+}
+
+// loadRole resolves the role of an ID parameter.
+// It writes the error response and returns false when the role cannot be loaded.
+func loadRole(c *gin.Context, param string) (*database.Role, bool) {
+	id, err := strconv.ParseInt(param, 10, 64)
+	if err != nil {
+		apierror.Write(c, http.StatusBadRequest, "Invalid role ID")
+		return nil, false
+	}
+
+	role, err := database.GetDB().GetRole(c.Request.Context(), id)
+	if err != nil {
+		if errors.Is(err, database.ErrRoleNotFound) {
+			apierror.Write(c, http.StatusNotFound, "Role not found")
+		} else {
+			apierror.Write(c, http.StatusInternalServerError, "Failed to get role")
+		}
+		return nil, false
+	}
+	return role, true
+}
+
+// bindRoleRequest parses a role body and combines its permissions.
+// It writes the error response and returns false when the body is invalid.
+func bindRoleRequest(c *gin.Context) (*database.Role, bool) {
+	var req RoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Invalid(c, err)
+		return nil, false
+	}
+
+	role := &database.Role{Name: strings.TrimSpace(req.Name), Description: req.Description}
+	for _, name := range req.Permissions {
+		permission, ok := database.PermissionNames[name]
+		if !ok {
+			apierror.Write(c, http.StatusBadRequest, "Unknown permission "+strconv.Quote(name)+", see GET /permissions")
+			return nil, false
+		}
+		role.Permissions |= permission
+	}
+	if role.Name == "" {
+		apierror.Write(c, http.StatusBadRequest, "Role name is required")
+		return nil, false
+	}
+	return role, true
+}
@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"net/http"
+
+	"minecharts/cmd/apierror"
+	"minecharts/cmd/kubernetes"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ServerRolloutResponse is returned by the server rollout endpoint.
+type ServerRolloutResponse struct {
+	ServerName string `json:"serverName"`
+	kubernetes.RolloutStatus
+}
+
+// GetServerRolloutHandler reports the progress of the latest rollout of a server,
+// as after a restart, from the conditions and replica counts of its deployment.
+//
+// @Summary      Get server rollout status
+// @Description  Returns the progress of the latest rollout of the server deployment (progressing, complete, failed past the rollout timeout, or stopped), with its replica counts and conditions
+// @Tags         servers
+// @Produce      json
+// @Security     BearerAuth
+// @Security     APIKeyAuth
+// @Param        serverName  path      string                 true  "Server name"
+// @Success      200         {object}  ServerRolloutResponse  "Rollout status"
+// @Failure      401         {object}  apierror.Error         "Authentication required"
+// @Failure      403         {object}  apierror.Error         "Permission denied"
+// @Failure      404         {object}  apierror.Error         "Server not found"
+// @Failure      500         {object}  apierror.Error         "Server error"
+// @Router       /servers/{serverName}/rollout [get]
+func GetServerRolloutHandler(c *gin.Context) {
+	deploymentName, _ := kubernetes.GetServerInfo(c)
+
+	namespace := serverNamespace(c)
+	deployment, err := kubernetes.GetDeployment(c.Request.Context(), namespace, deploymentName)
+	if err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to get deployment")
+		return
+	}
+	if deployment == nil {
+		apierror.Respond(c, apierror.New(http.StatusNotFound, apierror.CodeServerNotFound, "Deployment not found"))
+		return
+	}
+
+	c.JSON(http.StatusOK, ServerRolloutResponse{
+		ServerName:    c.Param("serverName"),
+		RolloutStatus: kubernetes.GetRolloutStatus(deployment),
+	})
+}
@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"net/http"
+
+	"minecharts/cmd/apierror"
+	"minecharts/cmd/auth"
+	"minecharts/cmd/database"
+	"minecharts/cmd/kubernetes"
+	"minecharts/cmd/logging"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ServerSchedulingRequest changes how a server is scheduled and evicted. The fields
+// left out are kept, empty ones are removed.
+type ServerSchedulingRequest struct {
+	PriorityClass    *string                      `json:"priorityClass,omitempty" example:"game-servers"`
+	DisruptionBudget *bool                        `json:"disruptionBudget,omitempty" example:"true"`
+	NodeSelector     *map[string]string           `json:"nodeSelector,omitempty"`
+	Tolerations      *[]database.ServerToleration `json:"tolerations,omitempty"`
+	Affinity         *database.ServerAffinity     `json:"affinity,omitempty"`
+}
+
+// SetServerSchedulingHandler sets the priority class, the disruption budget and the
+// node placement of a server.
+//
+// @Summary      Set server scheduling
+// @Description  Sets the PriorityClass of the server pod, one of MINECHARTS_PRIORITY_CLASSES, whether the server has a PodDisruptionBudget, and the node selector, tolerations and affinity of its pod. The budget allows no eviction of the running server, so node drains and the cluster autoscaler wait until it is stopped or hibernated. The priority class and placement changes are applied at the next restart of a running server, without restarting it, which pendingRestart tells
+// @Tags         servers
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Security     APIKeyAuth
+// @Param        serverName  path      string                   true  "Server name"
+// @Param        request     body      ServerSchedulingRequest  true  "Scheduling"
+// @Success      200         {object}  map[string]interface{}  "Scheduling updated"
+// @Failure      400         {object}  apierror.Error          "Invalid scheduling"
+// @Failure      401         {object}  apierror.Error          "Authentication required"
+// @Failure      403         {object}  apierror.Error          "Permission denied"
+// @Failure      404         {object}  apierror.Error          "Server not found"
+// @Failure      500         {object}  apierror.Error          "Server error"
+// @Router       /servers/{serverName}/scheduling [put]
+func SetServerSchedulingHandler(c *gin.Context) {
+	var req ServerSchedulingRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Invalid(c, err)
+		return
+	}
+
+	ctx := c.Request.Context()
+	db := database.GetDB()
+	server, err := db.GetServerByName(ctx, c.Param("serverName"))
+	if err != nil {
+		apierror.Respond(c, apierror.New(http.StatusNotFound, apierror.CodeServerNotFound, "Server not found"))
+		return
+	}
+	user, ok := auth.RequireCurrentUser(c)
+	if !ok {
+		return
+	}
+
+	spec := server.Spec
+	if req.PriorityClass != nil {
+		spec.PriorityClass = *req.PriorityClass
+	}
+	if req.DisruptionBudget != nil {
+		spec.DisruptionBudget = *req.DisruptionBudget
+	}
+	placementChanged := req.NodeSelector != nil || req.Tolerations != nil || req.Affinity != nil
+	if req.NodeSelector != nil {
+		spec.NodeSelector = *req.NodeSelector
+		if len(spec.NodeSelector) == 0 {
+			spec.NodeSelector = nil
+		}
+	}
+	if req.Tolerations != nil {
+		spec.Tolerations = *req.Tolerations
+		if len(spec.Tolerations) == 0 {
+			spec.Tolerations = nil
+		}
+	}
+	if req.Affinity != nil {
+		spec.Affinity = req.Affinity
+		if len(spec.Affinity.RequiredNodes) == 0 && len(spec.Affinity.PreferredNodes) == 0 && spec.Affinity.ServerAntiAffinity == "" {
+			spec.Affinity = nil
+		}
+	}
+	if req.PriorityClass != nil {
+		if err := validatePriorityClass(spec.PriorityClass); err != nil {
+			apierror.Write(c, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	if err := validateServerPlacement(&spec); err != nil {
+		apierror.Write(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := db.UpdateServerSpec(ctx, server.ServerName, spec); err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to update server")
+		return
+	}
+
+	namespace := kubernetes.ServerNamespace(server)
+	pending := false
+	if req.PriorityClass != nil {
+		pending, err = kubernetes.SetPriorityClass(ctx, namespace, server.DeploymentName, spec.PriorityClass)
+		if err != nil {
+			logging.Server.WithContext(ctx).WithFields(
+				"server_name", server.ServerName,
+				"error", err.Error(),
+			).Error("Failed to set server priority class")
+			apierror.Write(c, http.StatusInternalServerError, "Scheduling saved but failed to set the priority class: "+err.Error())
+			return
+		}
+	}
+	if placementChanged {
+		placementPending, err := kubernetes.SetPlacement(ctx, namespace, server.DeploymentName, serverPlacement(spec))
+		if err != nil {
+			logging.Server.WithContext(ctx).WithFields(
+				"server_name", server.ServerName,
+				"error", err.Error(),
+			).Error("Failed to set server placement")
+			apierror.Write(c, http.StatusInternalServerError, "Scheduling saved but failed to set the placement: "+err.Error())
+			return
+		}
+		pending = pending || placementPending
+	}
+	if req.DisruptionBudget != nil {
+		if spec.DisruptionBudget {
+			err = kubernetes.EnsureDisruptionBudget(ctx, namespace, server.DeploymentName)
+		} else {
+			err = kubernetes.DeleteDisruptionBudget(ctx, namespace, server.DeploymentName)
+		}
+		if err != nil {
+			apierror.Write(c, http.StatusInternalServerError, "Scheduling saved but failed to update the disruption budget: "+err.Error())
+			return
+		}
+	}
+
+	logging.Server.WithContext(ctx).WithFields(
+		"server_name", server.ServerName,
+		"priority_class", spec.PriorityClass,
+		"disruption_budget", spec.DisruptionBudget,
+		"user_id", user.ID,
+		"username", user.Username,
+	).Info("Server scheduling updated")
+
+	c.JSON(http.StatusOK, gin.H{
+		"serverName":       server.ServerName,
+		"priorityClass":    spec.PriorityClass,
+		"disruptionBudget": spec.DisruptionBudget,
+		"nodeSelector":     spec.NodeSelector,
+		"tolerations":      spec.Tolerations,
+		"affinity":         spec.Affinity,
+		"pendingRestart":   pending,
+	})
+}
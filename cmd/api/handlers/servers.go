@@ -0,0 +1,211 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"minecharts/cmd/apierror"
+	"minecharts/cmd/auth"
+	"minecharts/cmd/database"
+	"minecharts/cmd/kubernetes"
+	"minecharts/cmd/logging"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultChangesLimit = 500
+	maxChangesLimit     = 1000
+)
+
+// ServerSummary describes a server in the server list.
+type ServerSummary struct {
+	ServerName string              `json:"serverName"`
+	Status     string              `json:"status" example:"running"`
+	Reason     string              `json:"reason,omitempty"`
+	OwnerID    int64               `json:"ownerId"`
+	OrgID      int64               `json:"orgId,omitempty"`
+	UpdatedAt  time.Time           `json:"updatedAt"`
+	Spec       database.ServerSpec `json:"spec"`
+}
+
+// ServerListResponse is returned by the server list endpoint.
+type ServerListResponse struct {
+	Servers []ServerSummary `json:"servers"`
+	Deleted []string        `json:"deleted,omitempty"`     // Servers deleted or renamed since the cursor, in delta queries
+	Cursor  int64           `json:"cursor" example:"1042"` // Pass as since to get the servers changed afterwards
+	HasMore bool            `json:"hasMore,omitempty"`     // More changes follow the cursor, query again right away
+}
+
+// ListServersHandler lists the servers visible to the current user.
+//
+// With the since query parameter, or an If-Modified-Since header, only the servers
+// whose status changed since then are returned, as recorded in the status change log,
+// along with the servers deleted meanwhile. The cursor of the response is passed as
+// since by the next query.
+//
+// @Summary      List servers
+// @Description  Lists the servers the user can view. With since (a cursor from a previous response or an RFC 3339 timestamp) or If-Modified-Since, only returns the servers whose status changed afterwards and the deleted ones, limit changes at a time
+// @Tags         servers
+// @Produce      json
+// @Security     BearerAuth
+// @Security     APIKeyAuth
+// @Param        since              query     string  false  "Cursor or RFC 3339 timestamp"
+// @Param        limit              query     int     false  "Maximum number of changes read (default 500, max 1000)"
+// @Param        If-Modified-Since  header    string  false  "HTTP date, used when since is not set"
+// @Success      200                {object}  ServerListResponse  "Servers"
+// @Success      304                "No change since If-Modified-Since"
+// @Failure      400                {object}  apierror.Error      "Invalid since or limit"
+// @Failure      401                {object}  apierror.Error      "Authentication required"
+// @Failure      410                {object}  apierror.Error      "The status change log no longer goes back to since"
+// @Failure      500                {object}  apierror.Error      "Server error"
+// @Router       /servers [get]
+func ListServersHandler(c *gin.Context) {
+	user, ok := auth.RequireCurrentUser(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	db := database.GetDB()
+	access, err := auth.LoadServerAccess(ctx, user)
+	if err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to read server memberships")
+		return
+	}
+
+	since := c.Query("since")
+	modifiedSince := c.GetHeader("If-Modified-Since")
+	if since == "" && modifiedSince == "" {
+		// Read the cursor first so that changes made while listing are not missed
+		cursor, err := db.ServerStatusCursor(ctx, time.Now())
+		if err != nil {
+			apierror.Write(c, http.StatusInternalServerError, "Failed to read the status change log")
+			return
+		}
+		servers, err := db.ListServers(ctx)
+		if err != nil {
+			apierror.Write(c, http.StatusInternalServerError, "Failed to list servers")
+			return
+		}
+
+		response := ServerListResponse{Servers: []ServerSummary{}, Cursor: cursor}
+		for _, server := range servers {
+			if access.Has(server.ServerName, server.OwnerID, database.PermViewServer) {
+				response.Servers = append(response.Servers, serverSummary(server))
+			}
+		}
+		c.JSON(http.StatusOK, response)
+		return
+	}
+
+	limit := defaultChangesLimit
+	if value := c.Query("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxChangesLimit {
+			apierror.Write(c, http.StatusBadRequest, "limit must be between 1 and "+strconv.Itoa(maxChangesLimit))
+			return
+		}
+		limit = parsed
+	}
+
+	// since is a cursor or a timestamp, translated to the last change logged by then
+	afterID := int64(-1)
+	var at time.Time
+	if since != "" {
+		if cursor, err := strconv.ParseInt(since, 10, 64); err == nil && cursor >= 0 {
+			afterID = cursor
+		} else if at, err = time.Parse(time.RFC3339, since); err != nil {
+			apierror.Write(c, http.StatusBadRequest, "since must be a cursor or an RFC 3339 timestamp")
+			return
+		}
+	} else {
+		var err error
+		if at, err = http.ParseTime(modifiedSince); err != nil {
+			apierror.Write(c, http.StatusBadRequest, "If-Modified-Since must be an HTTP date")
+			return
+		}
+	}
+	if afterID < 0 {
+		var err error
+		if afterID, err = db.ServerStatusCursor(ctx, at); err != nil {
+			apierror.Write(c, http.StatusInternalServerError, "Failed to read the status change log")
+			return
+		}
+	}
+
+	// Pruned changes may have been missed, the client must list everything again
+	oldest, err := db.OldestServerStatusChangeID(ctx)
+	if err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to read the status change log")
+		return
+	}
+	if oldest > 0 && afterID < oldest-1 {
+		apierror.Write(c, http.StatusGone, "The status change log no longer goes back to since, list the servers again without it")
+		return
+	}
+
+	changes, err := db.ListServerStatusChanges(ctx, afterID, limit)
+	if err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to read the status change log")
+		return
+	}
+
+	if len(changes) == 0 && since == "" {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	response := ServerListResponse{Servers: []ServerSummary{}, Cursor: afterID, HasMore: len(changes) == limit}
+	seen := map[string]bool{}
+	for _, change := range changes {
+		response.Cursor = change.ID
+		if seen[change.ServerName] || !access.Has(change.ServerName, change.OwnerID, database.PermViewServer) {
+			continue
+		}
+		seen[change.ServerName] = true
+
+		// The current record is returned, it may have changed again since
+		server, err := db.GetServerByName(ctx, change.ServerName)
+		if err != nil {
+			response.Deleted = append(response.Deleted, change.ServerName)
+			continue
+		}
+		if access.Has(server.ServerName, server.OwnerID, database.PermViewServer) {
+			response.Servers = append(response.Servers, serverSummary(server))
+		}
+	}
+	if len(changes) > 0 {
+		c.Header("Last-Modified", changes[len(changes)-1].ChangedAt.UTC().Format(http.TimeFormat))
+	}
+
+	logging.API.WithContext(ctx).WithFields(
+		"user_id", user.ID,
+		"after_id", afterID,
+		"changes", len(changes),
+		"servers", len(response.Servers),
+		"deleted", len(response.Deleted),
+	).Debug("Server changes listed")
+
+	c.JSON(http.StatusOK, response)
+}
+
+func serverSummary(server *database.MinecraftServer) ServerSummary {
+	return ServerSummary{
+		ServerName: server.ServerName,
+		Status:     server.Status,
+		Reason:     server.StatusReason,
+		OwnerID:    server.OwnerID,
+		OrgID:      server.OrgID,
+		UpdatedAt:  server.UpdatedAt,
+		Spec:       redactedSpec(server.Spec),
+	}
+}
+
+// serverNamespace returns the namespace of the resources of the server of a request,
+// from the record loaded by the permission check.
+func serverNamespace(c *gin.Context) string {
+	server, _ := auth.GetCurrentServer(c)
+	return kubernetes.ServerNamespace(server)
+}
@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"minecharts/cmd/apierror"
+	"minecharts/cmd/auth"
+	"minecharts/cmd/database"
+	"minecharts/cmd/logging"
+	"minecharts/cmd/security"
+
+	"github.com/gin-gonic/gin"
+)
+
+// errSetupCompleted is returned in the setup transaction when a user already exists.
+var errSetupCompleted = errors.New("setup already completed")
+
+// SetupAdminRequest represents the initial administrator account payload.
+type SetupAdminRequest struct {
+	Username string `json:"username" binding:"required,min=3,max=50" example:"admin"`
+	Email    string `json:"email" binding:"required,email" example:"admin@example.com"`
+	Password string `json:"password" binding:"required,min=8" example:"securepass123"`
+}
+
+// GetSetupStatusHandler reports whether the first-boot setup still has to be completed.
+//
+// @Summary      Get setup status
+// @Description  Reports whether the initial administrator account still has to be created
+// @Tags         setup
+// @Produce      json
+// @Success      200  {object}  map[string]bool    "Setup status"
+// @Failure      500  {object}  apierror.Error     "Server error"
+// @Router       /setup/status [get]
+func GetSetupStatusHandler(c *gin.Context) {
+	complete, err := auth.IsSetupComplete(c)
+	if err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to check setup state")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"setupRequired": !complete,
+	})
+}
+
+// CreateSetupAdminHandler creates the initial administrator account.
+// It is only available while no user exists.
+//
+// @Summary      Create initial admin
+// @Description  Creates the first administrator account; only allowed while no users exist
+// @Tags         setup
+// @Accept       json
+// @Produce      json
+// @Param        request  body      SetupAdminRequest       true  "Administrator account"
+// @Success      201      {object}  map[string]interface{}  "Administrator created"
+// @Failure      400      {object}  apierror.Error          "Invalid request format"
+// @Failure      409      {object}  apierror.Error          "Setup already completed"
+// @Failure      500      {object}  apierror.Error          "Server error"
+// @Router       /setup/admin [post]
+func CreateSetupAdminHandler(c *gin.Context) {
+	var req SetupAdminRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		logging.API.InvalidRequest.WithContext(c.Request.Context()).WithFields("error", err.Error(), "remote_ip", c.ClientIP()).
+			Warn("Invalid setup request format")
+		apierror.Invalid(c, err)
+		return
+	}
+
+	// Refuse without hashing the password when the setup is already done
+	complete, err := auth.IsSetupComplete(c)
+	if err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to check setup state")
+		return
+	}
+	if complete {
+		refuseCompletedSetup(c, req.Username)
+		return
+	}
+
+	passwordHash, err := auth.HashPassword(req.Password)
+	if err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to hash password")
+		return
+	}
+
+	user := &database.User{
+		Username:     req.Username,
+		Email:        req.Email,
+		PasswordHash: passwordHash,
+		Permissions:  database.PermAll,
+		Active:       true,
+	}
+	// The check and the creation commit together, so that concurrent requests, to
+	// this replica or another, cannot both find no user
+	message := "Failed to create user"
+	err = database.GetDB().WithTx(c.Request.Context(), func(tx database.DB) error {
+		count, err := tx.CountUsers(c.Request.Context())
+		if err != nil {
+			message = "Failed to check setup state"
+			return err
+		}
+		if count > 0 {
+			return errSetupCompleted
+		}
+		return tx.CreateUser(c.Request.Context(), user)
+	})
+	if errors.Is(err, errSetupCompleted) {
+		refuseCompletedSetup(c, req.Username)
+		return
+	}
+	if err != nil {
+		logging.DB.WithContext(c.Request.Context()).WithFields("username", req.Username, "error", err.Error()).
+			Error("Failed to create initial admin")
+		apierror.Write(c, http.StatusInternalServerError, message)
+		return
+	}
+
+	token, err := auth.GenerateJWT(user.ID, user.Username, user.Email, user.Permissions)
+	if err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to generate token")
+		return
+	}
+
+	logging.Auth.Register.WithContext(c.Request.Context()).WithFields("username", user.Username, "user_id", user.ID, "remote_ip", c.ClientIP()).
+		Info("Initial administrator created, setup completed")
+	security.NewEvent(c, security.AdminAction, "setup_admin").WithUser(user).Emit()
+
+	c.JSON(http.StatusCreated, gin.H{
+		"token":       token,
+		"user_id":     user.ID,
+		"username":    user.Username,
+		"email":       user.Email,
+		"permissions": user.Permissions,
+	})
+}
+
+// refuseCompletedSetup answers a setup request made after the initial admin exists.
+func refuseCompletedSetup(c *gin.Context, username string) {
+	logging.Auth.Register.WithContext(c.Request.Context()).WithFields("username", username, "remote_ip", c.ClientIP(), "reason", "setup_completed").
+		Warn("Setup admin creation refused: setup already completed")
+	apierror.Write(c, http.StatusConflict, "Setup has already been completed")
+}
@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+)
+
+// Concurrent setup requests, with different accounts so that the unique username and
+// email do not refuse them, create a single admin.
+func TestCreateSetupAdminConcurrent(t *testing.T) {
+	db := setupTest(t)
+
+	const requests = 8
+	codes := make([]int, requests)
+	var wg sync.WaitGroup
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			body := SetupAdminRequest{
+				Username: fmt.Sprintf("admin%d", i),
+				Email:    fmt.Sprintf("admin%d@minecharts.local", i),
+				Password: "securepass123",
+			}
+			codes[i] = serve(t, nil, http.MethodPost, "/setup/admin", "/setup/admin", body, CreateSetupAdminHandler).Code
+		}(i)
+	}
+	wg.Wait()
+
+	created := 0
+	for i, code := range codes {
+		switch code {
+		case http.StatusCreated:
+			created++
+		case http.StatusConflict:
+		default:
+			t.Errorf("request %d: status %d, want 201 or 409", i, code)
+		}
+	}
+	if created != 1 {
+		t.Errorf("%d requests created an admin, want 1", created)
+	}
+	count, err := db.CountUsers(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("%d users after the setup, want 1", count)
+	}
+}
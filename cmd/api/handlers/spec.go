@@ -0,0 +1,855 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"minecharts/cmd/config"
+	"minecharts/cmd/database"
+	"minecharts/cmd/kubernetes"
+	"minecharts/cmd/logging"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+var (
+	serverNamePattern = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+	versionPattern    = regexp.MustCompile(`^(LATEST|SNAPSHOT|\d+\.\d+(\.\d+)?(-(pre|rc)\d+)?|\d{2}w\d{2}[a-z])$`)
+	bedrockVersion    = regexp.MustCompile(`^(LATEST|PREVIEW|\d+\.\d+\.\d+(\.\d+)?)$`)
+	memoryPattern     = regexp.MustCompile(`^[1-9]\d*[MG]$`)
+	envNamePattern    = regexp.MustCompile(`^[A-Z_][A-Z0-9_]*$`)
+	labelKeyPattern   = regexp.MustCompile(`^[a-z0-9]([a-z0-9_.-]{0,61}[a-z0-9])?$`)
+	labelValuePattern = regexp.MustCompile(`^([A-Za-z0-9]([A-Za-z0-9_.-]{0,61}[A-Za-z0-9])?)?$`)
+)
+
+// Allowed values of the enumerated spec fields, mapped to the image's env values.
+var (
+	serverTypes = map[string]string{
+		"vanilla": "VANILLA",
+		"paper":   "PAPER",
+		"forge":   "FORGE",
+		"fabric":  "FABRIC",
+		"bedrock": "", // Runs the Bedrock dedicated server image, which has no TYPE
+	}
+	// Modrinth projects installed on the server types Bedrock clients can join,
+	// Geyser translating their protocol and Floodgate letting them in without a
+	// Java account
+	bedrockProjects = map[string][]string{
+		"paper":  {"geyser", "floodgate"},
+		"fabric": {"fabric-api", "geyser", "floodgate"},
+	}
+	gamemodes    = []string{"survival", "creative", "adventure", "spectator"}
+	difficulties = []string{"peaceful", "easy", "normal", "hard"}
+	taintEffects = []string{string(corev1.TaintEffectNoSchedule), string(corev1.TaintEffectPreferNoSchedule), string(corev1.TaintEffectNoExecute)}
+)
+
+const (
+	maxServerNameLength = 40
+	maxPlayersLimit     = 1000
+	maxSeedLength       = 64
+	maxMOTDLength       = 256
+	maxServerLabels     = 16
+)
+
+// specFieldEnv maps the env variables set from typed spec fields; they cannot be
+// overridden through the raw env map.
+var specFieldEnv = map[string]string{
+	"VERSION":     "version",
+	"TYPE":        "serverType",
+	"MEMORY":      "memory",
+	"MAX_PLAYERS": "maxPlayers",
+	"SEED":        "seed",
+	"MODE":        "gamemode",
+	"DIFFICULTY":  "difficulty",
+	"MOTD":        "motd",
+	"MODPACK":     "modpack",
+}
+
+// bedrockFieldEnv maps the env variables of the Bedrock server image set from typed
+// spec fields.
+var bedrockFieldEnv = map[string]string{
+	"VERSION":     "version",
+	"MAX_PLAYERS": "maxPlayers",
+	"LEVEL_SEED":  "seed",
+	"GAMEMODE":    "gamemode",
+	"DIFFICULTY":  "difficulty",
+	"SERVER_NAME": "motd",
+}
+
+// isBedrock reports whether a spec runs the Bedrock dedicated server instead of a
+// Java edition server.
+func isBedrock(spec database.ServerSpec) bool {
+	return spec.ServerType == "bedrock"
+}
+
+// serverEdition returns the Kubernetes edition of the server run by a spec.
+func serverEdition(spec database.ServerSpec) kubernetes.Edition {
+	if isBedrock(spec) {
+		return kubernetes.BedrockEdition
+	}
+	return kubernetes.JavaEdition
+}
+
+// validateVersion checks a version of the given server type, already in upper case.
+func validateVersion(serverType, version string) error {
+	if serverType == "bedrock" {
+		if !bedrockVersion.MatchString(version) {
+			return fmt.Errorf("version %q is invalid, expected LATEST, PREVIEW or a Bedrock release such as 1.21.50.07", version)
+		}
+		return nil
+	}
+	if !versionPattern.MatchString(version) {
+		return fmt.Errorf("version %q is invalid, expected LATEST, SNAPSHOT or a release such as 1.21.4", version)
+	}
+	return nil
+}
+
+// validateServerName checks that the name can be used in Kubernetes resource names.
+func validateServerName(name string) error {
+	if len(name) > maxServerNameLength {
+		return fmt.Errorf("serverName must be at most %d characters", maxServerNameLength)
+	}
+	if !serverNamePattern.MatchString(name) {
+		return fmt.Errorf("serverName must consist of lowercase letters, digits and '-', and start and end with an alphanumeric character")
+	}
+	return nil
+}
+
+// normalizeServerSpec validates a spec and normalizes the case of its enumerated fields.
+func normalizeServerSpec(spec *database.ServerSpec) error {
+	if spec.ServerType != "" {
+		spec.ServerType = strings.ToLower(spec.ServerType)
+		if _, ok := serverTypes[spec.ServerType]; !ok {
+			return fmt.Errorf("serverType %q is invalid, expected one of vanilla, paper, forge, fabric, bedrock", spec.ServerType)
+		}
+	}
+
+	if spec.Version != "" {
+		spec.Version = strings.ToUpper(spec.Version)
+		if err := validateVersion(spec.ServerType, spec.Version); err != nil {
+			return err
+		}
+	}
+
+	if spec.Memory != "" {
+		spec.Memory = strings.ToUpper(spec.Memory)
+		if !memoryPattern.MatchString(spec.Memory) {
+			return fmt.Errorf("memory %q is invalid, expected a size such as 2048M or 4G", spec.Memory)
+		}
+	}
+
+	if spec.MaxPlayers < 0 || spec.MaxPlayers > maxPlayersLimit {
+		return fmt.Errorf("maxPlayers must be between 1 and %d", maxPlayersLimit)
+	}
+
+	if len(spec.Seed) > maxSeedLength {
+		return fmt.Errorf("seed must be at most %d characters", maxSeedLength)
+	}
+
+	if spec.Gamemode != "" {
+		spec.Gamemode = strings.ToLower(spec.Gamemode)
+		if !contains(gamemodes, spec.Gamemode) {
+			return fmt.Errorf("gamemode %q is invalid, expected one of %s", spec.Gamemode, strings.Join(gamemodes, ", "))
+		}
+	}
+
+	if spec.Difficulty != "" {
+		spec.Difficulty = strings.ToLower(spec.Difficulty)
+		if !contains(difficulties, spec.Difficulty) {
+			return fmt.Errorf("difficulty %q is invalid, expected one of %s", spec.Difficulty, strings.Join(difficulties, ", "))
+		}
+	}
+
+	if len(spec.MOTD) > maxMOTDLength {
+		return fmt.Errorf("motd must be at most %d characters", maxMOTDLength)
+	}
+
+	if spec.Modpack != "" {
+		u, err := url.Parse(spec.Modpack)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("modpack must be an http or https URL")
+		}
+	}
+
+	if isBedrock(*spec) {
+		// The Bedrock server is no JVM and runs no plugins
+		switch {
+		case spec.Memory != "":
+			return fmt.Errorf("memory sets the JVM heap, which bedrock servers do not have")
+		case spec.Modpack != "":
+			return fmt.Errorf("modpack is not supported by bedrock servers")
+		case spec.Bedrock:
+			return fmt.Errorf("bedrock servers accept Bedrock clients without Geyser, bedrock must not be set")
+		}
+	}
+
+	if spec.Bedrock {
+		if _, ok := bedrockProjects[spec.ServerType]; !ok {
+			return fmt.Errorf("bedrock requires serverType paper or fabric, which can run Geyser and Floodgate")
+		}
+	}
+
+	if err := validateServerStorage(spec); err != nil {
+		return err
+	}
+
+	if spec.Resources != nil {
+		if err := validateServerResources(spec.Resources); err != nil {
+			return err
+		}
+	}
+
+	if err := validatePriorityClass(spec.PriorityClass); err != nil {
+		return err
+	}
+
+	if err := validateServerPlacement(spec); err != nil {
+		return err
+	}
+
+	if spec.Workload != "" {
+		spec.Workload = strings.ToLower(spec.Workload)
+		switch kubernetes.Workload(spec.Workload) {
+		case kubernetes.DeploymentWorkload:
+		case kubernetes.StatefulSetWorkload:
+			if !config.StatefulSets {
+				return fmt.Errorf("workload statefulset is not enabled, see MINECHARTS_STATEFULSETS")
+			}
+			if config.StorageDriver == "hostpath" {
+				return fmt.Errorf("workload statefulset needs a storage driver using PVCs, not hostpath")
+			}
+		default:
+			return fmt.Errorf("workload %q is invalid, expected deployment or statefulset", spec.Workload)
+		}
+	}
+
+	for name := range spec.Env {
+		if !envNamePattern.MatchString(name) {
+			return fmt.Errorf("env variable name %q is invalid", name)
+		}
+		fieldEnv := specFieldEnv
+		if isBedrock(*spec) {
+			fieldEnv = bedrockFieldEnv
+		}
+		if field, ok := fieldEnv[name]; ok {
+			return fmt.Errorf("env variable %s must be set with the %s field", name, field)
+		}
+		if name == "EULA" {
+			return fmt.Errorf("env variable EULA is managed by the API")
+		}
+	}
+
+	return validateServerLabels(spec.Labels)
+}
+
+// validatePriorityClass checks that a priority class is one of those the servers may
+// be given: any PriorityClass would let users preempt the workloads of the cluster.
+func validatePriorityClass(class string) error {
+	if class == "" {
+		return nil
+	}
+	allowed := allowList(config.PriorityClasses)
+	if len(allowed) == 0 {
+		return fmt.Errorf("priorityClass cannot be set, no priority class is allowed for servers")
+	}
+	if !contains(allowed, class) {
+		return fmt.Errorf("priorityClass %q is not allowed, expected one of %s", class, strings.Join(allowed, ", "))
+	}
+	return nil
+}
+
+// validateServerStorage checks the storage size and class of a spec against the ones
+// the servers may pick. Any size is allowed without MINECHARTS_STORAGE_SIZES, as sizes
+// count against the quotas, while a class is only allowed when listed, as it decides
+// which storage system and tier the data of the server lands on.
+func validateServerStorage(spec *database.ServerSpec) error {
+	if spec.StorageSize != "" {
+		size, err := resource.ParseQuantity(spec.StorageSize)
+		if err != nil {
+			return fmt.Errorf("storageSize %q is invalid, expected a quantity such as 20Gi", spec.StorageSize)
+		}
+		if allowed := allowList(config.StorageSizes); len(allowed) > 0 && !containsQuantity(allowed, size) {
+			return fmt.Errorf("storageSize %q is not allowed, expected one of %s", spec.StorageSize, strings.Join(allowed, ", "))
+		}
+	}
+
+	if spec.StorageClass != "" {
+		if config.StorageDriver == "hostpath" {
+			return fmt.Errorf("storageClass needs a storage driver using PVCs, not hostpath")
+		}
+		allowed := allowList(config.StorageClasses)
+		if len(allowed) == 0 {
+			return fmt.Errorf("storageClass cannot be set, no storage class is allowed for servers")
+		}
+		if !contains(allowed, spec.StorageClass) {
+			return fmt.Errorf("storageClass %q is not allowed, expected one of %s", spec.StorageClass, strings.Join(allowed, ", "))
+		}
+	}
+	return nil
+}
+
+// containsQuantity tells whether a list holds a quantity, however it is written.
+func containsQuantity(values []string, quantity resource.Quantity) bool {
+	for _, value := range values {
+		if parsed, err := resource.ParseQuantity(value); err == nil && parsed.Cmp(quantity) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// allowList returns the values of a comma separated setting.
+func allowList(value string) []string {
+	var values []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			values = append(values, item)
+		}
+	}
+	return values
+}
+
+// validateServerPlacement checks the node selector, tolerations and affinity of a spec
+// against what Kubernetes accepts, so that a deployment is not refused once created.
+func validateServerPlacement(spec *database.ServerSpec) error {
+	for key, value := range spec.NodeSelector {
+		if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+			return fmt.Errorf("nodeSelector key %q is invalid: %s", key, strings.Join(errs, "; "))
+		}
+		if errs := validation.IsValidLabelValue(value); len(errs) > 0 {
+			return fmt.Errorf("nodeSelector value %q of %s is invalid: %s", value, key, strings.Join(errs, "; "))
+		}
+	}
+
+	for i := range spec.Tolerations {
+		toleration := &spec.Tolerations[i]
+		if toleration.Key != "" {
+			if errs := validation.IsQualifiedName(toleration.Key); len(errs) > 0 {
+				return fmt.Errorf("toleration key %q is invalid: %s", toleration.Key, strings.Join(errs, "; "))
+			}
+		}
+		switch toleration.Operator {
+		case "", string(corev1.TolerationOpEqual):
+			if toleration.Key == "" {
+				return fmt.Errorf("a toleration without key must use the Exists operator")
+			}
+			if errs := validation.IsValidLabelValue(toleration.Value); len(errs) > 0 {
+				return fmt.Errorf("toleration value %q is invalid: %s", toleration.Value, strings.Join(errs, "; "))
+			}
+		case string(corev1.TolerationOpExists):
+			if toleration.Value != "" {
+				return fmt.Errorf("a toleration with the Exists operator has no value")
+			}
+		default:
+			return fmt.Errorf("toleration operator %q is invalid, expected Equal or Exists", toleration.Operator)
+		}
+		if toleration.Effect != "" && !contains(taintEffects, toleration.Effect) {
+			return fmt.Errorf("toleration effect %q is invalid, expected one of %s", toleration.Effect, strings.Join(taintEffects, ", "))
+		}
+	}
+
+	if spec.Affinity == nil {
+		return nil
+	}
+	for _, requirement := range append(spec.Affinity.RequiredNodes, spec.Affinity.PreferredNodes...) {
+		if err := validateNodeRequirement(requirement); err != nil {
+			return err
+		}
+	}
+	if anti := spec.Affinity.ServerAntiAffinity; anti != "" && anti != "preferred" && anti != "required" {
+		return fmt.Errorf("serverAntiAffinity %q is invalid, expected preferred or required", anti)
+	}
+	return nil
+}
+
+// validateNodeRequirement checks a node affinity rule the way the scheduler reads it.
+func validateNodeRequirement(requirement database.NodeRequirement) error {
+	if errs := validation.IsQualifiedName(requirement.Key); len(errs) > 0 {
+		return fmt.Errorf("node affinity key %q is invalid: %s", requirement.Key, strings.Join(errs, "; "))
+	}
+	switch corev1.NodeSelectorOperator(requirement.Operator) {
+	case corev1.NodeSelectorOpIn, corev1.NodeSelectorOpNotIn:
+		if len(requirement.Values) == 0 {
+			return fmt.Errorf("node affinity on %s with %s needs values", requirement.Key, requirement.Operator)
+		}
+	case corev1.NodeSelectorOpExists, corev1.NodeSelectorOpDoesNotExist:
+		if len(requirement.Values) > 0 {
+			return fmt.Errorf("node affinity on %s with %s takes no values", requirement.Key, requirement.Operator)
+		}
+	case corev1.NodeSelectorOpGt, corev1.NodeSelectorOpLt:
+		if len(requirement.Values) != 1 {
+			return fmt.Errorf("node affinity on %s with %s takes a single value", requirement.Key, requirement.Operator)
+		}
+		if _, err := strconv.ParseInt(requirement.Values[0], 10, 64); err != nil {
+			return fmt.Errorf("node affinity on %s with %s takes an integer", requirement.Key, requirement.Operator)
+		}
+	default:
+		return fmt.Errorf("node affinity operator %q is invalid, expected In, NotIn, Exists, DoesNotExist, Gt or Lt", requirement.Operator)
+	}
+	for _, value := range requirement.Values {
+		if errs := validation.IsValidLabelValue(value); len(errs) > 0 {
+			return fmt.Errorf("node affinity value %q of %s is invalid: %s", value, requirement.Key, strings.Join(errs, "; "))
+		}
+	}
+	return nil
+}
+
+// validateServerLabels checks custom labels against what Kubernetes accepts once
+// they are prefixed with kubernetes.CustomLabelPrefix.
+func validateServerLabels(labels map[string]string) error {
+	if len(labels) > maxServerLabels {
+		return fmt.Errorf("a server has at most %d labels", maxServerLabels)
+	}
+	for key, value := range labels {
+		if !labelKeyPattern.MatchString(key) {
+			return fmt.Errorf("label key %q is invalid, expected at most 63 lower case letters, digits, '-', '_' or '.'", key)
+		}
+		if !labelValuePattern.MatchString(value) {
+			return fmt.Errorf("label value %q of %s is invalid, expected at most 63 letters, digits, '-', '_' or '.'", value, key)
+		}
+	}
+	return nil
+}
+
+// serverEnvVars maps a validated spec to the container environment variables,
+// in a stable order. The RCON password is left out when RCON is enabled,
+// since it is injected from a secret.
+func serverEnvVars(spec database.ServerSpec) []corev1.EnvVar {
+	if isBedrock(spec) {
+		return bedrockEnvVars(spec)
+	}
+
+	values := map[string]string{
+		"EULA":                   "TRUE",
+		"CREATE_CONSOLE_IN_PIPE": "true",
+	}
+
+	for key, value := range spec.Env {
+		if key == "RCON_PASSWORD" && rconEnabled(spec.Env) {
+			continue
+		}
+		values[key] = value
+	}
+
+	if spec.Version != "" {
+		values["VERSION"] = spec.Version
+	}
+	if spec.ServerType != "" {
+		values["TYPE"] = serverTypes[spec.ServerType]
+	}
+	if spec.Memory != "" {
+		values["MEMORY"] = spec.Memory
+	}
+	if spec.MaxPlayers > 0 {
+		values["MAX_PLAYERS"] = strconv.Itoa(spec.MaxPlayers)
+	}
+	if spec.Seed != "" {
+		values["SEED"] = spec.Seed
+	}
+	if spec.Gamemode != "" {
+		values["MODE"] = spec.Gamemode
+	}
+	if spec.Difficulty != "" {
+		values["DIFFICULTY"] = spec.Difficulty
+	}
+	if spec.MOTD != "" {
+		values["MOTD"] = spec.MOTD
+	}
+	if spec.Modpack != "" {
+		values["MODPACK"] = spec.Modpack
+	}
+	if spec.Bedrock {
+		// Added to the projects the env may already install
+		projects := bedrockProjects[spec.ServerType]
+		if existing := values["MODRINTH_PROJECTS"]; existing != "" {
+			projects = append([]string{existing}, projects...)
+		}
+		values["MODRINTH_PROJECTS"] = strings.Join(projects, ",")
+	}
+
+	return sortedEnvVars(values)
+}
+
+// bedrockEnvVars maps a validated bedrock spec to the environment variables of the
+// Bedrock server image.
+func bedrockEnvVars(spec database.ServerSpec) []corev1.EnvVar {
+	values := map[string]string{
+		"EULA": "TRUE",
+	}
+	for key, value := range spec.Env {
+		values[key] = value
+	}
+
+	if spec.Version != "" {
+		values["VERSION"] = spec.Version
+	}
+	if spec.MaxPlayers > 0 {
+		values["MAX_PLAYERS"] = strconv.Itoa(spec.MaxPlayers)
+	}
+	if spec.Seed != "" {
+		values["LEVEL_SEED"] = spec.Seed
+	}
+	if spec.Gamemode != "" {
+		values["GAMEMODE"] = spec.Gamemode
+	}
+	if spec.Difficulty != "" {
+		values["DIFFICULTY"] = spec.Difficulty
+	}
+	if spec.MOTD != "" {
+		values["SERVER_NAME"] = spec.MOTD
+	}
+	return sortedEnvVars(values)
+}
+
+// sortedEnvVars returns environment variables in the order of their names.
+func sortedEnvVars(values map[string]string) []corev1.EnvVar {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	envVars := make([]corev1.EnvVar, 0, len(names))
+	for _, name := range names {
+		envVars = append(envVars, corev1.EnvVar{Name: name, Value: values[name]})
+	}
+	return envVars
+}
+
+// validateServerResources checks that requests and limits are valid quantities
+// and that no request exceeds its limit.
+func validateServerResources(resources *database.ServerResources) error {
+	pairs := []struct {
+		name           string
+		request, limit string
+	}{
+		{"cpu", resources.CPURequest, resources.CPULimit},
+		{"memory", resources.MemoryRequest, resources.MemoryLimit},
+	}
+	for _, pair := range pairs {
+		var request, limit resource.Quantity
+		var err error
+		if pair.request != "" {
+			if request, err = resource.ParseQuantity(pair.request); err != nil {
+				return fmt.Errorf("resources %s request %q is invalid", pair.name, pair.request)
+			}
+		}
+		if pair.limit != "" {
+			if limit, err = resource.ParseQuantity(pair.limit); err != nil {
+				return fmt.Errorf("resources %s limit %q is invalid", pair.name, pair.limit)
+			}
+		}
+		if pair.request != "" && pair.limit != "" && request.Cmp(limit) > 0 {
+			return fmt.Errorf("resources %s request must not exceed its limit", pair.name)
+		}
+	}
+	return nil
+}
+
+// Memory headroom modes, for JVM heaps leaving too little of the container memory limit.
+const (
+	memoryHeadroomAdjust = "adjust"
+	memoryHeadroomWarn   = "warn"
+	memoryHeadroomReject = "reject"
+)
+
+// jvmMemory parses a JVM memory size such as 4G or 2048m. The JVM sizes use binary
+// units: 4G is 4Gi.
+func jvmMemory(value string) (resource.Quantity, error) {
+	return resource.ParseQuantity(strings.ToUpper(value) + "i")
+}
+
+// checkMemoryHeadroom checks that the JVM heap of a validated spec leaves
+// config.MemoryHeadroomPercent of the container memory limit to the rest of the JVM.
+// A heap as large as the limit gets the server OOMKilled as soon as the heap fills up,
+// and again after every restart.
+//
+// Depending on config.MemoryHeadroomMode, a larger heap is lowered to fit, kept, or
+// refused with an error. The returned warning describes the heap found too large.
+func checkMemoryHeadroom(spec *database.ServerSpec) (string, error) {
+	percent := config.MemoryHeadroomPercent
+	if percent <= 0 || isBedrock(*spec) || spec.Resources == nil || spec.Resources.MemoryLimit == "" {
+		return "", nil
+	}
+	limit := resource.MustParse(spec.Resources.MemoryLimit)
+
+	// MAX_MEMORY takes precedence over MEMORY for the maximum heap in the image
+	field, heap := "memory", spec.Memory
+	if value := spec.Env["MAX_MEMORY"]; value != "" {
+		field, heap = "env variable MAX_MEMORY", value
+	}
+	if heap == "" {
+		field, heap = "default memory", defaultServerMemory
+	}
+	heapSize, err := jvmMemory(heap)
+	if err != nil {
+		return "", fmt.Errorf("%s %q is invalid, expected a size such as 2048M or 4G", field, heap)
+	}
+
+	maxHeap := limit.Value() * int64(100-min(percent, 90)) / 100
+	if heapSize.Value() <= maxHeap {
+		return "", nil
+	}
+
+	fitted := fmt.Sprintf("%dM", maxHeap>>20)
+	warning := fmt.Sprintf("%s %s leaves less than %d%% of the %s memory limit outside of the JVM heap, the server would be OOMKilled",
+		field, strings.ToUpper(heap), percent, spec.Resources.MemoryLimit)
+
+	switch config.MemoryHeadroomMode {
+	case memoryHeadroomReject:
+		return "", fmt.Errorf("%s; use at most %s or raise the limit", warning, fitted)
+	case memoryHeadroomWarn:
+		return warning, nil
+	}
+
+	if spec.Env["MAX_MEMORY"] != "" {
+		spec.Env["MAX_MEMORY"] = fitted
+	} else {
+		spec.Memory = fitted
+	}
+	return fmt.Sprintf("%s; lowered to %s", warning, fitted), nil
+}
+
+// serverResourceRequirements maps the validated spec resources to container requirements.
+func serverResourceRequirements(spec database.ServerSpec) corev1.ResourceRequirements {
+	var requirements corev1.ResourceRequirements
+	if spec.Resources == nil {
+		return requirements
+	}
+
+	set := func(list *corev1.ResourceList, name corev1.ResourceName, value string) {
+		if value == "" {
+			return
+		}
+		if *list == nil {
+			*list = corev1.ResourceList{}
+		}
+		(*list)[name] = resource.MustParse(value)
+	}
+	set(&requirements.Requests, corev1.ResourceCPU, spec.Resources.CPURequest)
+	set(&requirements.Limits, corev1.ResourceCPU, spec.Resources.CPULimit)
+	set(&requirements.Requests, corev1.ResourceMemory, spec.Resources.MemoryRequest)
+	set(&requirements.Limits, corev1.ResourceMemory, spec.Resources.MemoryLimit)
+	return requirements
+}
+
+// serverWorkload returns the workload running the pod of a server. The servers created
+// as StatefulSets while they were enabled are still found as such, this only decides
+// how new servers are created.
+func serverWorkload(spec database.ServerSpec) kubernetes.Workload {
+	if config.StatefulSets && kubernetes.Workload(spec.Workload) == kubernetes.StatefulSetWorkload {
+		return kubernetes.StatefulSetWorkload
+	}
+	return kubernetes.DeploymentWorkload
+}
+
+// serverPlacement maps the validated node selector, tolerations and affinity of a spec
+// to the placement of its pod.
+func serverPlacement(spec database.ServerSpec) kubernetes.Placement {
+	placement := kubernetes.Placement{NodeSelector: spec.NodeSelector}
+	for _, toleration := range spec.Tolerations {
+		operator := corev1.TolerationOperator(toleration.Operator)
+		if operator == "" {
+			operator = corev1.TolerationOpEqual
+		}
+		placement.Tolerations = append(placement.Tolerations, corev1.Toleration{
+			Key:      toleration.Key,
+			Operator: operator,
+			Value:    toleration.Value,
+			Effect:   corev1.TaintEffect(toleration.Effect),
+		})
+	}
+	if spec.Affinity == nil {
+		return placement
+	}
+
+	requirements := func(rules []database.NodeRequirement) []corev1.NodeSelectorRequirement {
+		var result []corev1.NodeSelectorRequirement
+		for _, rule := range rules {
+			result = append(result, corev1.NodeSelectorRequirement{
+				Key:      rule.Key,
+				Operator: corev1.NodeSelectorOperator(rule.Operator),
+				Values:   rule.Values,
+			})
+		}
+		return result
+	}
+	affinity := &corev1.Affinity{}
+	if len(spec.Affinity.RequiredNodes) > 0 || len(spec.Affinity.PreferredNodes) > 0 {
+		affinity.NodeAffinity = &corev1.NodeAffinity{}
+	}
+	if len(spec.Affinity.RequiredNodes) > 0 {
+		affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution = &corev1.NodeSelector{
+			NodeSelectorTerms: []corev1.NodeSelectorTerm{{MatchExpressions: requirements(spec.Affinity.RequiredNodes)}},
+		}
+	}
+	if len(spec.Affinity.PreferredNodes) > 0 {
+		affinity.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution = []corev1.PreferredSchedulingTerm{
+			{Weight: 50, Preference: corev1.NodeSelectorTerm{MatchExpressions: requirements(spec.Affinity.PreferredNodes)}},
+		}
+	}
+	switch spec.Affinity.ServerAntiAffinity {
+	case "preferred":
+		affinity.PodAntiAffinity = &corev1.PodAntiAffinity{
+			PreferredDuringSchedulingIgnoredDuringExecution: []corev1.WeightedPodAffinityTerm{
+				{Weight: 100, PodAffinityTerm: kubernetes.ServerAntiAffinity()},
+			},
+		}
+	case "required":
+		affinity.PodAntiAffinity = &corev1.PodAntiAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: []corev1.PodAffinityTerm{kubernetes.ServerAntiAffinity()},
+		}
+	}
+	if affinity.NodeAffinity != nil || affinity.PodAntiAffinity != nil {
+		placement.Affinity = affinity
+	}
+	return placement
+}
+
+// mergeServerSpec applies the fields set in override on top of a base spec, such as
+// a template. Env variables, labels and node selectors are merged, with override taking precedence.
+func mergeServerSpec(base, override database.ServerSpec) database.ServerSpec {
+	merged := base
+	if override.Version != "" {
+		merged.Version = override.Version
+	}
+	if override.ServerType != "" {
+		merged.ServerType = override.ServerType
+	}
+	if override.Memory != "" {
+		merged.Memory = override.Memory
+	}
+	if override.MaxPlayers != 0 {
+		merged.MaxPlayers = override.MaxPlayers
+	}
+	if override.Seed != "" {
+		merged.Seed = override.Seed
+	}
+	if override.Gamemode != "" {
+		merged.Gamemode = override.Gamemode
+	}
+	if override.Difficulty != "" {
+		merged.Difficulty = override.Difficulty
+	}
+	if override.MOTD != "" {
+		merged.MOTD = override.MOTD
+	}
+	if override.Modpack != "" {
+		merged.Modpack = override.Modpack
+	}
+	if override.StorageSize != "" {
+		merged.StorageSize = override.StorageSize
+	}
+	if override.StorageClass != "" {
+		merged.StorageClass = override.StorageClass
+	}
+	if override.Resources != nil {
+		merged.Resources = override.Resources
+	}
+	if override.SyncWhitelist {
+		merged.SyncWhitelist = true
+	}
+	if override.Bedrock {
+		merged.Bedrock = true
+	}
+	if override.PriorityClass != "" {
+		merged.PriorityClass = override.PriorityClass
+	}
+	if override.DisruptionBudget {
+		merged.DisruptionBudget = true
+	}
+	if override.Tolerations != nil {
+		merged.Tolerations = override.Tolerations
+	}
+	if override.Affinity != nil {
+		merged.Affinity = override.Affinity
+	}
+	if override.Workload != "" {
+		merged.Workload = override.Workload
+	}
+
+	if len(base.Env) > 0 || len(override.Env) > 0 {
+		merged.Env = make(map[string]string, len(base.Env)+len(override.Env))
+		for key, value := range base.Env {
+			merged.Env[key] = value
+		}
+		for key, value := range override.Env {
+			merged.Env[key] = value
+		}
+	}
+	if len(base.Labels) > 0 || len(override.Labels) > 0 {
+		merged.Labels = make(map[string]string, len(base.Labels)+len(override.Labels))
+		for key, value := range base.Labels {
+			merged.Labels[key] = value
+		}
+		for key, value := range override.Labels {
+			merged.Labels[key] = value
+		}
+	}
+	if len(base.NodeSelector) > 0 || len(override.NodeSelector) > 0 {
+		merged.NodeSelector = make(map[string]string, len(base.NodeSelector)+len(override.NodeSelector))
+		for key, value := range base.NodeSelector {
+			merged.NodeSelector[key] = value
+		}
+		for key, value := range override.NodeSelector {
+			merged.NodeSelector[key] = value
+		}
+	}
+	return merged
+}
+
+// persistedSpec returns a copy of the spec that is safe to store, without the RCON password.
+func persistedSpec(spec database.ServerSpec) database.ServerSpec {
+	if _, ok := spec.Env["RCON_PASSWORD"]; !ok {
+		return spec
+	}
+	env := make(map[string]string, len(spec.Env))
+	for key, value := range spec.Env {
+		if key != "RCON_PASSWORD" {
+			env[key] = value
+		}
+	}
+	spec.Env = env
+	return spec
+}
+
+// redactedSpec returns a copy of the spec safe to return in API responses,
+// with sensitive env values replaced.
+func redactedSpec(spec database.ServerSpec) database.ServerSpec {
+	spec.Env = logging.RedactEnv(spec.Env)
+	return spec
+}
+
+// redactedServerRequest returns a copy of a server request whose stored
+// creation payload has its sensitive env values replaced.
+func redactedServerRequest(serverRequest *database.ServerRequest) *database.ServerRequest {
+	var req StartMinecraftServerRequest
+	if err := json.Unmarshal(serverRequest.Payload, &req); err != nil {
+		return serverRequest
+	}
+	req.ServerSpec = redactedSpec(req.ServerSpec)
+	payload, err := json.Marshal(req)
+	if err != nil {
+		return serverRequest
+	}
+
+	redacted := *serverRequest
+	redacted.Payload = payload
+	return &redacted
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
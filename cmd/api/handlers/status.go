@@ -0,0 +1,238 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"minecharts/cmd/apierror"
+	"minecharts/cmd/auth"
+	"minecharts/cmd/database"
+	"minecharts/cmd/kubernetes"
+	"minecharts/cmd/logging"
+
+	"github.com/gin-gonic/gin"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// ContainerStatus describes the state of a container of the server pod.
+type ContainerStatus struct {
+	Name         string `json:"name"`
+	Ready        bool   `json:"ready"`
+	State        string `json:"state" example:"running"` // "waiting", "running" or "terminated"
+	Reason       string `json:"reason,omitempty" example:"CrashLoopBackOff"`
+	Message      string `json:"message,omitempty"`
+	RestartCount int32  `json:"restartCount"`
+}
+
+// ServerReadiness holds the readiness details of the server pod.
+type ServerReadiness struct {
+	PodName       string            `json:"podName,omitempty"`
+	Phase         string            `json:"phase,omitempty" example:"Running"`
+	Ready         bool              `json:"ready"`
+	ReadyReplicas int32             `json:"readyReplicas"`
+	Replicas      int32             `json:"replicas"`
+	StartedAt     *time.Time        `json:"startedAt,omitempty"`
+	Containers    []ContainerStatus `json:"containers,omitempty"`
+}
+
+// ServerStatusResponse is returned by the server status endpoint.
+type ServerStatusResponse struct {
+	ServerName string          `json:"serverName"`
+	Status     string          `json:"status" example:"running"` // "creating", "starting", "running", "failed", "stopped" or "hibernated"
+	Reason     string          `json:"reason,omitempty"`
+	UpdatedAt  *time.Time      `json:"updatedAt,omitempty"`
+	Cached     bool            `json:"cached,omitempty"` // Served from the last known state while the cluster is unreachable
+	Readiness  ServerReadiness `json:"readiness"`
+}
+
+// GetServerStatusHandler returns the provisioning state of a server and the readiness of its pod.
+//
+// @Summary      Get server status
+// @Description  Returns the provisioning state of a server (creating, starting, running, failed or stopped), the failure reason if any, and the readiness details of its pod
+// @Tags         servers
+// @Produce      json
+// @Security     BearerAuth
+// @Security     APIKeyAuth
+// @Param        serverName  path      string                true  "Server name"
+// @Success      200         {object}  ServerStatusResponse  "Server status"
+// @Failure      401         {object}  apierror.Error        "Authentication required"
+// @Failure      403         {object}  apierror.Error        "Permission denied"
+// @Failure      404         {object}  apierror.Error        "Server not found"
+// @Failure      500         {object}  apierror.Error        "Server error"
+// @Failure      503         {object}  apierror.Error        "Cluster unreachable and no cached status"
+// @Router       /servers/{serverName}/status [get]
+func GetServerStatusHandler(c *gin.Context) {
+	deploymentName, _ := kubernetes.GetServerInfo(c)
+	serverName := c.Param("serverName")
+
+	user, ok := auth.RequireCurrentUser(c)
+	if !ok {
+		return
+	}
+
+	logging.Server.WithContext(c.Request.Context()).WithFields(
+		"server_name", serverName,
+		"deployment", deploymentName,
+		"user_id", user.ID,
+		"remote_ip", c.ClientIP(),
+	).Debug("Server status requested")
+
+	response, err := lookupServerStatus(c.Request.Context(), serverNamespace(c), serverName, deploymentName)
+	switch {
+	case errors.Is(err, errStatusNotFound):
+		apierror.Respond(c, apierror.New(http.StatusNotFound, apierror.CodeServerNotFound, "Deployment not found"))
+	case errors.Is(err, kubernetes.ErrClusterUnreachable):
+		kubernetes.AbortClusterUnreachable(c)
+	case err != nil:
+		apierror.Write(c, http.StatusInternalServerError, "Failed to get deployment")
+	default:
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// errStatusNotFound is returned for the servers with neither a deployment nor a record.
+var errStatusNotFound = errors.New("server not found")
+
+// lookupServerStatus returns the status of a server from its deployment and pod, or
+// from the recorded state and the server watcher cache while the Kubernetes API is
+// unreachable. It returns kubernetes.ErrClusterUnreachable when nothing is known then.
+func lookupServerStatus(ctx context.Context, namespace, serverName, deploymentName string) (*ServerStatusResponse, error) {
+	if !kubernetes.ClusterReachable() {
+		return cachedServerStatus(ctx, serverName, deploymentName)
+	}
+
+	deployment, err := kubernetes.GetDeployment(ctx, namespace, deploymentName)
+	if errors.Is(err, kubernetes.ErrClusterUnreachable) {
+		return cachedServerStatus(ctx, serverName, deploymentName)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// The recorded state is the fallback when the pod cannot be inspected
+	server, recordErr := database.GetDB().GetServerByName(ctx, serverName)
+
+	if deployment == nil {
+		if recordErr != nil {
+			return nil, errStatusNotFound
+		}
+		// Being created, or failed before its deployment was
+		return &ServerStatusResponse{
+			ServerName: serverName,
+			Status:     server.Status,
+			Reason:     server.StatusReason,
+			UpdatedAt:  &server.UpdatedAt,
+		}, nil
+	}
+
+	response := &ServerStatusResponse{
+		ServerName: serverName,
+		Status:     database.ServerStatusStarting,
+		Readiness: ServerReadiness{
+			ReadyReplicas: deployment.Status.ReadyReplicas,
+			Replicas:      deployment.Status.Replicas,
+		},
+	}
+	if recordErr == nil {
+		response.Status = server.Status
+		response.Reason = server.StatusReason
+		response.UpdatedAt = &server.UpdatedAt
+	}
+
+	if deployment.Spec.Replicas != nil && *deployment.Spec.Replicas == 0 {
+		response.Status = database.ServerStatusStopped
+		response.Reason = ""
+		if reason, ok := deployment.Annotations[kubernetes.HibernatedAnnotation]; ok {
+			response.Status = database.ServerStatusHibernated
+			response.Reason = reason
+		}
+		return response, nil
+	}
+
+	pod, err := kubernetes.GetMinecraftPod(ctx, namespace, deploymentName)
+	if err != nil {
+		logging.Server.WithContext(ctx).WithFields(
+			"server_name", serverName,
+			"deployment", deploymentName,
+			"error", err.Error(),
+		).Warn("Failed to get server pod for status")
+		return response, nil
+	}
+	if pod == nil {
+		return response, nil
+	}
+
+	// The live pod state is more recent than the one recorded by the watcher
+	applyPodStatus(response, pod)
+	return response, nil
+}
+
+// cachedServerStatus returns the recorded state and the server watcher cache
+// while the Kubernetes API is unreachable.
+func cachedServerStatus(ctx context.Context, serverName, deploymentName string) (*ServerStatusResponse, error) {
+	response := &ServerStatusResponse{ServerName: serverName, Cached: true}
+
+	server, err := database.GetDB().GetServerByName(ctx, serverName)
+	if err == nil {
+		response.Status = server.Status
+		response.Reason = server.StatusReason
+		response.UpdatedAt = &server.UpdatedAt
+	}
+
+	pod := kubernetes.CachedMinecraftPod(deploymentName)
+	if pod != nil && response.Status != database.ServerStatusStopped && response.Status != database.ServerStatusHibernated {
+		applyPodStatus(response, pod)
+	}
+
+	if err != nil && pod == nil {
+		return nil, kubernetes.ErrClusterUnreachable
+	}
+
+	logging.Server.WithContext(ctx).WithFields(
+		"server_name", serverName,
+		"status", response.Status,
+	).Debug("Serving cached server status, cluster unreachable")
+
+	return response, nil
+}
+
+// applyPodStatus fills the status and readiness details from the server pod.
+func applyPodStatus(response *ServerStatusResponse, pod *corev1.Pod) {
+	response.Status, response.Reason = kubernetes.PodServerStatus(pod)
+	response.Readiness.PodName = pod.Name
+	response.Readiness.Phase = string(pod.Status.Phase)
+	response.Readiness.Ready = response.Status == database.ServerStatusRunning
+	if pod.Status.StartTime != nil {
+		startedAt := pod.Status.StartTime.Time
+		response.Readiness.StartedAt = &startedAt
+	}
+	for _, container := range pod.Status.ContainerStatuses {
+		response.Readiness.Containers = append(response.Readiness.Containers, containerStatus(container))
+	}
+}
+
+// containerStatus converts the Kubernetes state of a container.
+func containerStatus(status corev1.ContainerStatus) ContainerStatus {
+	container := ContainerStatus{
+		Name:         status.Name,
+		Ready:        status.Ready,
+		RestartCount: status.RestartCount,
+	}
+
+	switch {
+	case status.State.Waiting != nil:
+		container.State = "waiting"
+		container.Reason = status.State.Waiting.Reason
+		container.Message = status.State.Waiting.Message
+	case status.State.Terminated != nil:
+		container.State = "terminated"
+		container.Reason = status.State.Terminated.Reason
+		container.Message = status.State.Terminated.Message
+	case status.State.Running != nil:
+		container.State = "running"
+	}
+
+	return container
+}
@@ -0,0 +1,411 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"minecharts/cmd/apierror"
+	"minecharts/cmd/auth"
+	"minecharts/cmd/config"
+	"minecharts/cmd/database"
+	"minecharts/cmd/kubernetes"
+	"minecharts/cmd/logging"
+	"minecharts/cmd/scheduler"
+	"minecharts/cmd/security"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ScheduledTaskRequest represents the body used to create or replace a scheduled task.
+type ScheduledTaskRequest struct {
+	Name     string `json:"name" binding:"required,max=64" example:"Nightly restart"`
+	Schedule string `json:"schedule" binding:"required,max=128" example:"0 4 * * *"` // Cron expression, in the API timezone
+	Action   string `json:"action" binding:"required,oneof=restart backup command broadcast cleanup" example:"restart"`
+	Payload  string `json:"payload" binding:"max=1024" example:"Restarting in 5 minutes"` // Command or message of the command and broadcast actions, policy of the cleanup action such as logs=7d,crash-reports=30d,backups=5
+	Enabled  *bool  `json:"enabled" example:"true"`                                       // Defaults to true
+}
+
+// ListScheduledTasksHandler lists the scheduled tasks of a server.
+//
+// @Summary      List scheduled tasks
+// @Description  Lists the scheduled tasks of a server with their last and next run
+// @Tags         tasks
+// @Produce      json
+// @Security     BearerAuth
+// @Security     APIKeyAuth
+// @Param        serverName  path      string                   true  "Server name"
+// @Success      200         {array}   database.ScheduledTask   "Scheduled tasks"
+// @Failure      401         {object}  apierror.Error           "Authentication required"
+// @Failure      403         {object}  apierror.Error           "Permission denied"
+// @Failure      404         {object}  apierror.Error           "Server not found"
+// @Failure      500         {object}  apierror.Error           "Server error"
+// @Router       /servers/{serverName}/tasks [get]
+func ListScheduledTasksHandler(c *gin.Context) {
+	server, ok := loadTaskServer(c)
+	if !ok {
+		return
+	}
+
+	tasks, err := database.GetDB().ListScheduledTasks(c.Request.Context(), server.ID)
+	if err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to list scheduled tasks")
+		return
+	}
+
+	c.JSON(http.StatusOK, tasks)
+}
+
+// GetScheduledTaskHandler returns a scheduled task of a server.
+//
+// @Summary      Get scheduled task
+// @Description  Returns a scheduled task of a server by ID
+// @Tags         tasks
+// @Produce      json
+// @Security     BearerAuth
+// @Security     APIKeyAuth
+// @Param        serverName  path      string                  true  "Server name"
+// @Param        taskId      path      int                     true  "Task ID"
+// @Success      200         {object}  database.ScheduledTask  "Scheduled task"
+// @Failure      400         {object}  apierror.Error          "Invalid task ID"
+// @Failure      401         {object}  apierror.Error          "Authentication required"
+// @Failure      403         {object}  apierror.Error          "Permission denied"
+// @Failure      404         {object}  apierror.Error          "Scheduled task not found"
+// @Failure      500         {object}  apierror.Error          "Server error"
+// @Router       /servers/{serverName}/tasks/{taskId} [get]
+func GetScheduledTaskHandler(c *gin.Context) {
+	task, ok := loadScheduledTask(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, task)
+}
+
+// CreateScheduledTaskHandler adds a scheduled task to a server.
+// The user needs the permission of the action on the server: restarting it for
+// restarts, executing commands for the other actions.
+//
+// @Summary      Create scheduled task
+// @Description  Schedules a restart, backup, console command, broadcast or cleanup on a server with a cron expression (minute, hour, day of month, month, day of week, or @hourly, @daily, @weekly, @monthly)
+// @Tags         tasks
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Security     APIKeyAuth
+// @Param        serverName  path      string                  true  "Server name"
+// @Param        request     body      ScheduledTaskRequest    true  "Task definition"
+// @Success      201         {object}  database.ScheduledTask  "Scheduled task created"
+// @Failure      400         {object}  apierror.Error          "Invalid request or schedule"
+// @Failure      401         {object}  apierror.Error          "Authentication required"
+// @Failure      403         {object}  apierror.Error          "Permission denied"
+// @Failure      404         {object}  apierror.Error          "Server not found"
+// @Failure      500         {object}  apierror.Error          "Server error"
+// @Router       /servers/{serverName}/tasks [post]
+func CreateScheduledTaskHandler(c *gin.Context) {
+	server, ok := loadTaskServer(c)
+	if !ok {
+		return
+	}
+	user, ok := auth.RequireCurrentUser(c)
+	if !ok {
+		return
+	}
+
+	task := &database.ScheduledTask{
+		ServerID:   server.ID,
+		ServerName: server.ServerName,
+		CreatedBy:  user.ID,
+	}
+	if !applyScheduledTaskRequest(c, server, task) {
+		return
+	}
+
+	if err := database.GetDB().CreateScheduledTask(c.Request.Context(), task); err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to create scheduled task")
+		return
+	}
+
+	logging.Server.WithContext(c.Request.Context()).WithFields(
+		"server_name", server.ServerName,
+		"task_id", task.ID,
+		"task_name", task.Name,
+		"action", task.Action,
+		"schedule", task.Schedule,
+		"username", user.Username,
+	).Info("Scheduled task created")
+
+	c.JSON(http.StatusCreated, task)
+}
+
+// UpdateScheduledTaskHandler replaces a scheduled task of a server.
+// Its next run is computed again from the new schedule.
+//
+// @Summary      Update scheduled task
+// @Description  Replaces the definition of a scheduled task; the next run follows the new schedule
+// @Tags         tasks
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Security     APIKeyAuth
+// @Param        serverName  path      string                  true  "Server name"
+// @Param        taskId      path      int                     true  "Task ID"
+// @Param        request     body      ScheduledTaskRequest    true  "Task definition"
+// @Success      200         {object}  database.ScheduledTask  "Scheduled task updated"
+// @Failure      400         {object}  apierror.Error          "Invalid request or schedule"
+// @Failure      401         {object}  apierror.Error          "Authentication required"
+// @Failure      403         {object}  apierror.Error          "Permission denied"
+// @Failure      404         {object}  apierror.Error          "Scheduled task not found"
+// @Failure      500         {object}  apierror.Error          "Server error"
+// @Router       /servers/{serverName}/tasks/{taskId} [put]
+func UpdateScheduledTaskHandler(c *gin.Context) {
+	user, ok := auth.RequireCurrentUser(c)
+	if !ok {
+		return
+	}
+
+	server, ok := loadTaskServer(c)
+	if !ok {
+		return
+	}
+	task, ok := loadScheduledTask(c)
+	if !ok {
+		return
+	}
+
+	// Changing a task takes the permission of its current action as well
+	if !requireTaskPermission(c, server, task.Action) {
+		return
+	}
+	if !applyScheduledTaskRequest(c, server, task) {
+		return
+	}
+
+	if err := database.GetDB().UpdateScheduledTask(c.Request.Context(), task); err != nil {
+		if errors.Is(err, database.ErrTaskNotFound) {
+			apierror.Write(c, http.StatusNotFound, "Scheduled task not found")
+		} else {
+			apierror.Write(c, http.StatusInternalServerError, "Failed to update scheduled task")
+		}
+		return
+	}
+
+	logging.Server.WithContext(c.Request.Context()).WithFields(
+		"server_name", server.ServerName,
+		"task_id", task.ID,
+		"task_name", task.Name,
+		"action", task.Action,
+		"schedule", task.Schedule,
+		"enabled", task.Enabled,
+		"username", user.Username,
+	).Info("Scheduled task updated")
+
+	c.JSON(http.StatusOK, task)
+}
+
+// DeleteScheduledTaskHandler deletes a scheduled task of a server and its run history.
+//
+// @Summary      Delete scheduled task
+// @Description  Deletes a scheduled task and its run history
+// @Tags         tasks
+// @Produce      json
+// @Security     BearerAuth
+// @Security     APIKeyAuth
+// @Param        serverName  path      string             true  "Server name"
+// @Param        taskId      path      int                true  "Task ID"
+// @Success      200         {object}  map[string]string  "Scheduled task deleted"
+// @Failure      400         {object}  apierror.Error     "Invalid task ID"
+// @Failure      401         {object}  apierror.Error     "Authentication required"
+// @Failure      403         {object}  apierror.Error     "Permission denied"
+// @Failure      404         {object}  apierror.Error     "Scheduled task not found"
+// @Failure      500         {object}  apierror.Error     "Server error"
+// @Router       /servers/{serverName}/tasks/{taskId} [delete]
+func DeleteScheduledTaskHandler(c *gin.Context) {
+	user, ok := auth.RequireCurrentUser(c)
+	if !ok {
+		return
+	}
+
+	server, ok := loadTaskServer(c)
+	if !ok {
+		return
+	}
+	task, ok := loadScheduledTask(c)
+	if !ok {
+		return
+	}
+	if !requireTaskPermission(c, server, task.Action) {
+		return
+	}
+
+	if err := database.GetDB().DeleteScheduledTask(c.Request.Context(), task.ID); err != nil {
+		if errors.Is(err, database.ErrTaskNotFound) {
+			apierror.Write(c, http.StatusNotFound, "Scheduled task not found")
+		} else {
+			apierror.Write(c, http.StatusInternalServerError, "Failed to delete scheduled task")
+		}
+		return
+	}
+
+	logging.Server.WithContext(c.Request.Context()).WithFields(
+		"server_name", server.ServerName,
+		"task_id", task.ID,
+		"task_name", task.Name,
+		"username", user.Username,
+	).Info("Scheduled task deleted")
+
+	c.JSON(http.StatusOK, gin.H{"message": "Scheduled task deleted"})
+}
+
+// ListTaskRunsHandler returns the run history of a scheduled task, newest first.
+//
+// @Summary      List task runs
+// @Description  Returns the latest runs of a scheduled task with their outcome and output, newest first
+// @Tags         tasks
+// @Produce      json
+// @Security     BearerAuth
+// @Security     APIKeyAuth
+// @Param        serverName  path      string             true  "Server name"
+// @Param        taskId      path      int                true  "Task ID"
+// @Success      200         {array}   database.TaskRun   "Task runs"
+// @Failure      400         {object}  apierror.Error     "Invalid task ID"
+// @Failure      401         {object}  apierror.Error     "Authentication required"
+// @Failure      403         {object}  apierror.Error     "Permission denied"
+// @Failure      404         {object}  apierror.Error     "Scheduled task not found"
+// @Failure      500         {object}  apierror.Error     "Server error"
+// @Router       /servers/{serverName}/tasks/{taskId}/runs [get]
+func ListTaskRunsHandler(c *gin.Context) {
+	task, ok := loadScheduledTask(c)
+	if !ok {
+		return
+	}
+
+	runs, err := database.GetDB().ListTaskRuns(c.Request.Context(), task.ID, config.TaskRunHistory)
+	if err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to list task runs")
+		return
+	}
+
+	c.JSON(http.StatusOK, runs)
+}
+
+// loadTaskServer resolves the server record referenced by the :serverName parameter.
+// It writes the error response and returns false when the server cannot be loaded.
+func loadTaskServer(c *gin.Context) (*database.MinecraftServer, bool) {
+	server, err := database.GetDB().GetServerByName(c.Request.Context(), c.Param("serverName"))
+	if err != nil {
+		apierror.Respond(c, apierror.New(http.StatusNotFound, apierror.CodeServerNotFound, "Server not found"))
+		return nil, false
+	}
+	return server, true
+}
+
+// loadScheduledTask resolves the task referenced by the :taskId parameter, which
+// must belong to the server of the :serverName parameter.
+// It writes the error response and returns false when the task cannot be loaded.
+func loadScheduledTask(c *gin.Context) (*database.ScheduledTask, bool) {
+	id, err := strconv.ParseInt(c.Param("taskId"), 10, 64)
+	if err != nil {
+		apierror.Write(c, http.StatusBadRequest, "Invalid task ID")
+		return nil, false
+	}
+
+	task, err := database.GetDB().GetScheduledTask(c.Request.Context(), id)
+	if err != nil && !errors.Is(err, database.ErrTaskNotFound) {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to get scheduled task")
+		return nil, false
+	}
+	if task == nil || task.ServerName != c.Param("serverName") {
+		apierror.Write(c, http.StatusNotFound, "Scheduled task not found")
+		return nil, false
+	}
+	return task, true
+}
+
+// applyScheduledTaskRequest binds the task definition of the request onto the task
+// and computes its next run. It writes the error response and returns false when
+// the request is invalid or the user lacks the permission of the action.
+func applyScheduledTaskRequest(c *gin.Context, server *database.MinecraftServer, task *database.ScheduledTask) bool {
+	var req ScheduledTaskRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Invalid(c, err)
+		return false
+	}
+	if !requireTaskPermission(c, server, req.Action) {
+		return false
+	}
+
+	req.Payload = strings.TrimSpace(req.Payload)
+	switch req.Action {
+	case database.TaskActionCommand, database.TaskActionBroadcast:
+		if req.Payload == "" {
+			apierror.Write(c, http.StatusBadRequest, "payload is required for "+req.Action+" tasks")
+			return false
+		}
+	case database.TaskActionCleanup:
+		policy, err := kubernetes.ParseCleanupPolicy(req.Payload)
+		if err != nil {
+			apierror.Write(c, http.StatusBadRequest, "Invalid cleanup policy: "+err.Error())
+			return false
+		}
+		req.Payload = policy.String()
+	default:
+		req.Payload = ""
+	}
+
+	next, err := scheduler.NextRun(req.Schedule, time.Now())
+	if err != nil {
+		apierror.Write(c, http.StatusBadRequest, "Invalid schedule: "+err.Error())
+		return false
+	}
+	if next == nil {
+		apierror.Write(c, http.StatusBadRequest, "Invalid schedule: it never runs")
+		return false
+	}
+
+	task.Name = req.Name
+	task.Schedule = req.Schedule
+	task.Action = req.Action
+	task.Payload = req.Payload
+	task.Enabled = req.Enabled == nil || *req.Enabled
+	task.NextRunAt = nil
+	if task.Enabled {
+		task.NextRunAt = next
+	}
+	return true
+}
+
+// requireTaskPermission checks that the current user may schedule the action on the
+// server. It writes the error response and returns false otherwise.
+func requireTaskPermission(c *gin.Context, server *database.MinecraftServer, action string) bool {
+	permission := database.PermExecCommand
+	switch action {
+	case database.TaskActionRestart:
+		permission = database.PermRestartServer
+	case database.TaskActionCleanup:
+		// Cleanups delete backups
+		permission = database.PermDeleteServer
+	}
+
+	user, ok := auth.RequireCurrentUser(c)
+	if !ok {
+		return false
+	}
+	if auth.HasServerAccess(c.Request.Context(), user, server, permission) {
+		return true
+	}
+
+	logging.Auth.Session.WithContext(c.Request.Context()).WithFields(
+		"path", c.Request.URL.Path,
+		"user_id", user.ID,
+		"username", user.Username,
+		"server_name", server.ServerName,
+		"action", action,
+	).Warn("Scheduled task permission check failed")
+	security.NewEvent(c, security.PermissionDenied, "schedule_task").WithUser(user).WithTarget(server.ServerName).
+		WithReason("insufficient_server_permissions").WithDetail("required_permission", strconv.FormatInt(permission, 10)).Emit()
+	auth.DenyServerAccess(c, user, server)
+	return false
+}
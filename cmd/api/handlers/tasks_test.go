@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"minecharts/cmd/database"
+)
+
+func TestCreateScheduledTaskPermissions(t *testing.T) {
+	tests := []struct {
+		name        string
+		permissions int64 // Granted to the user as a member of the server, none when 0
+		status      int
+	}{
+		{"member allowed to run commands", database.PermViewServer | database.PermExecCommand, http.StatusCreated},
+		{"member only allowed to view", database.PermViewServer, http.StatusForbidden},
+		{"not a member", 0, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := setupTest(t)
+			owner := createTestUser(t, db, "owner", database.PermCreateServer|database.PermViewServer)
+			user := createTestUser(t, db, "player", 0)
+			server := createTestServer(t, db, "survival", owner)
+			if tt.permissions != 0 {
+				if err := db.SaveServerMember(context.Background(), &database.ServerMember{
+					ServerID:    server.ID,
+					UserID:      user.ID,
+					Permissions: tt.permissions,
+				}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			recorder := serve(t, user, http.MethodPost, "/servers/:serverName/tasks", "/servers/survival/tasks", map[string]string{
+				"name":     "Announce",
+				"schedule": "0 * * * *",
+				"action":   database.TaskActionCommand,
+				"payload":  "say hello",
+			}, CreateScheduledTaskHandler)
+			expectStatus(t, recorder, tt.status)
+		})
+	}
+}
+
+func TestCreateScheduledTaskAsOrganizationAdmin(t *testing.T) {
+	db := setupTest(t)
+	owner := createTestUser(t, db, "owner", database.PermCreateServer|database.PermViewServer)
+	admin := createTestUser(t, db, "org-admin", 0)
+
+	ctx := context.Background()
+	org := &database.Organization{Name: "builders"}
+	if err := db.CreateOrganization(ctx, org, owner.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SaveOrganizationMember(ctx, &database.OrganizationMember{OrgID: org.ID, UserID: admin.ID, Role: database.OrgRoleAdmin}); err != nil {
+		t.Fatal(err)
+	}
+	server := &database.MinecraftServer{
+		ServerName:     "creative",
+		DeploymentName: "minecraft-server-creative",
+		PVCName:        "minecraft-server-creative-pvc",
+		OwnerID:        owner.ID,
+		OrgID:          org.ID,
+		Status:         database.ServerStatusRunning,
+	}
+	if err := db.CreateServerRecord(ctx, server); err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := serve(t, admin, http.MethodPost, "/servers/:serverName/tasks", "/servers/creative/tasks", map[string]string{
+		"name":     "Nightly restart",
+		"schedule": "0 4 * * *",
+		"action":   database.TaskActionRestart,
+	}, CreateScheduledTaskHandler)
+	expectStatus(t, recorder, http.StatusCreated)
+}
@@ -0,0 +1,274 @@
+package handlers
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"minecharts/cmd/database"
+)
+
+const (
+	maxTemplateParameters   = 32
+	maxParameterValueLength = 256
+)
+
+var (
+	// placeholderPattern matches the {{NAME}} placeholders of the parameters in a template spec.
+	placeholderPattern = regexp.MustCompile(`\{\{([A-Z][A-Z0-9_]*)\}\}`)
+	// parameterNamePattern limits parameter names to what placeholders can reference.
+	parameterNamePattern = regexp.MustCompile(`^[A-Z][A-Z0-9_]{0,63}$`)
+)
+
+// specStrings returns the string fields of a spec that can hold placeholders.
+// The environment values are handled separately.
+func specStrings(spec *database.ServerSpec) []*string {
+	fields := []*string{
+		&spec.Version, &spec.ServerType, &spec.Memory, &spec.Seed, &spec.Gamemode,
+		&spec.Difficulty, &spec.MOTD, &spec.Modpack, &spec.StorageSize, &spec.StorageClass,
+	}
+	if spec.Resources != nil {
+		fields = append(fields,
+			&spec.Resources.CPURequest, &spec.Resources.CPULimit,
+			&spec.Resources.MemoryRequest, &spec.Resources.MemoryLimit,
+		)
+	}
+	return fields
+}
+
+// specPlaceholders returns the names of the parameters referenced in a spec.
+func specPlaceholders(spec database.ServerSpec) map[string]bool {
+	names := make(map[string]bool)
+	add := func(value string) {
+		for _, match := range placeholderPattern.FindAllStringSubmatch(value, -1) {
+			names[match[1]] = true
+		}
+	}
+	for _, field := range specStrings(&spec) {
+		add(*field)
+	}
+	for _, value := range spec.Env {
+		add(value)
+	}
+	return names
+}
+
+// validateTemplateParameters checks the parameters of a template against its spec:
+// each parameter is valid and referenced, and each placeholder has its parameter.
+func validateTemplateParameters(parameters database.TemplateParameters, spec database.ServerSpec) error {
+	if len(parameters) > maxTemplateParameters {
+		return fmt.Errorf("a template has at most %d parameters", maxTemplateParameters)
+	}
+
+	used := specPlaceholders(spec)
+	declared := make(map[string]bool, len(parameters))
+	for i := range parameters {
+		parameter := &parameters[i]
+		if !parameterNamePattern.MatchString(parameter.Name) {
+			return fmt.Errorf("parameter name %q is invalid, expected upper case letters, digits and underscores", parameter.Name)
+		}
+		if declared[parameter.Name] {
+			return fmt.Errorf("parameter %s is declared twice", parameter.Name)
+		}
+		declared[parameter.Name] = true
+		if !used[parameter.Name] {
+			return fmt.Errorf("parameter %s is not used in the spec, reference it as {{%s}}", parameter.Name, parameter.Name)
+		}
+		if err := validateTemplateParameter(parameter); err != nil {
+			return err
+		}
+	}
+
+	missing := []string{}
+	for name := range used {
+		if !declared[name] {
+			missing = append(missing, "{{"+name+"}}")
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("the spec references undeclared parameters: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// validateTemplateParameter checks that the constraints of a parameter fit its type,
+// and normalizes its default value.
+func validateTemplateParameter(parameter *database.TemplateParameter) error {
+	parameter.Type = strings.ToLower(parameter.Type)
+	switch parameter.Type {
+	case database.TemplateParameterString, database.TemplateParameterInteger, database.TemplateParameterBoolean,
+		database.TemplateParameterMemory, database.TemplateParameterEnum:
+	default:
+		return fmt.Errorf("parameter %s has type %q, expected one of string, integer, boolean, memory, enum", parameter.Name, parameter.Type)
+	}
+
+	bounded := parameter.Type == database.TemplateParameterInteger || parameter.Type == database.TemplateParameterMemory
+	switch {
+	case (parameter.Min != nil || parameter.Max != nil) && !bounded:
+		return fmt.Errorf("parameter %s: min and max only apply to integer and memory parameters", parameter.Name)
+	case parameter.Min != nil && parameter.Max != nil && *parameter.Min > *parameter.Max:
+		return fmt.Errorf("parameter %s: min is greater than max", parameter.Name)
+	case len(parameter.Options) > 0 && parameter.Type != database.TemplateParameterEnum:
+		return fmt.Errorf("parameter %s: options only apply to enum parameters", parameter.Name)
+	case len(parameter.Options) == 0 && parameter.Type == database.TemplateParameterEnum:
+		return fmt.Errorf("parameter %s: enum parameters need options", parameter.Name)
+	case parameter.Pattern != "" && parameter.Type != database.TemplateParameterString:
+		return fmt.Errorf("parameter %s: pattern only applies to string parameters", parameter.Name)
+	}
+	if parameter.Pattern != "" {
+		if _, err := regexp.Compile(parameter.Pattern); err != nil {
+			return fmt.Errorf("parameter %s: pattern is invalid: %v", parameter.Name, err)
+		}
+	}
+
+	if parameter.Default != "" {
+		value, err := parameterValue(*parameter, parameter.Default)
+		if err != nil {
+			return fmt.Errorf("default of %w", err)
+		}
+		parameter.Default = value
+	}
+	return nil
+}
+
+// parameterValue checks a value given for a parameter and returns it normalized.
+func parameterValue(parameter database.TemplateParameter, value string) (string, error) {
+	if len(value) > maxParameterValueLength {
+		return "", fmt.Errorf("parameter %s must be at most %d characters", parameter.Name, maxParameterValueLength)
+	}
+
+	switch parameter.Type {
+	case database.TemplateParameterInteger:
+		n, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		if err != nil {
+			return "", fmt.Errorf("parameter %s must be an integer", parameter.Name)
+		}
+		if err := checkParameterBounds(parameter, n, ""); err != nil {
+			return "", err
+		}
+		return strconv.FormatInt(n, 10), nil
+
+	case database.TemplateParameterBoolean:
+		b, err := strconv.ParseBool(strings.TrimSpace(value))
+		if err != nil {
+			return "", fmt.Errorf("parameter %s must be true or false", parameter.Name)
+		}
+		return strconv.FormatBool(b), nil
+
+	case database.TemplateParameterMemory:
+		value = strings.ToUpper(strings.TrimSpace(value))
+		if !memoryPattern.MatchString(value) {
+			return "", fmt.Errorf("parameter %s must be a size such as 2048M or 4G", parameter.Name)
+		}
+		quantity, err := jvmMemory(value)
+		if err != nil {
+			return "", fmt.Errorf("parameter %s must be a size such as 2048M or 4G", parameter.Name)
+		}
+		if err := checkParameterBounds(parameter, quantity.Value()>>20, "M"); err != nil {
+			return "", err
+		}
+		return value, nil
+
+	case database.TemplateParameterEnum:
+		if !contains(parameter.Options, value) {
+			return "", fmt.Errorf("parameter %s must be one of %s", parameter.Name, strings.Join(parameter.Options, ", "))
+		}
+		return value, nil
+	}
+
+	if parameter.Pattern != "" && !regexp.MustCompile(parameter.Pattern).MatchString(value) {
+		return "", fmt.Errorf("parameter %s must match %s", parameter.Name, parameter.Pattern)
+	}
+	return value, nil
+}
+
+// checkParameterBounds checks a number against the min and max of a parameter,
+// reported with unit.
+func checkParameterBounds(parameter database.TemplateParameter, n int64, unit string) error {
+	if parameter.Min != nil && n < *parameter.Min {
+		return fmt.Errorf("parameter %s must be at least %d%s", parameter.Name, *parameter.Min, unit)
+	}
+	if parameter.Max != nil && n > *parameter.Max {
+		return fmt.Errorf("parameter %s must be at most %d%s", parameter.Name, *parameter.Max, unit)
+	}
+	return nil
+}
+
+// resolveTemplateParameters returns the value of each parameter of a template from
+// the values given at creation, falling back to the defaults.
+func resolveTemplateParameters(parameters database.TemplateParameters, given map[string]string) (map[string]string, error) {
+	values := make(map[string]string, len(parameters))
+	for _, parameter := range parameters {
+		value, ok := given[parameter.Name]
+		switch {
+		case ok:
+			normalized, err := parameterValue(parameter, value)
+			if err != nil {
+				return nil, err
+			}
+			values[parameter.Name] = normalized
+		case parameter.Default != "":
+			values[parameter.Name] = parameter.Default
+		case parameter.Optional:
+			values[parameter.Name] = ""
+		default:
+			return nil, fmt.Errorf("parameter %s is required by the template", parameter.Name)
+		}
+	}
+
+	for name := range given {
+		if _, ok := values[name]; !ok {
+			return nil, fmt.Errorf("the template has no parameter %s", name)
+		}
+	}
+	return values, nil
+}
+
+// renderTemplateSpec returns a copy of a template spec with its placeholders
+// replaced by the parameter values.
+func renderTemplateSpec(spec database.ServerSpec, values map[string]string) database.ServerSpec {
+	replace := func(value string) string {
+		return placeholderPattern.ReplaceAllStringFunc(value, func(placeholder string) string {
+			return values[placeholder[2:len(placeholder)-2]]
+		})
+	}
+
+	rendered := spec
+	if spec.Resources != nil {
+		resources := *spec.Resources
+		rendered.Resources = &resources
+	}
+	for _, field := range specStrings(&rendered) {
+		*field = replace(*field)
+	}
+	if spec.Env != nil {
+		rendered.Env = make(map[string]string, len(spec.Env))
+		for key, value := range spec.Env {
+			rendered.Env[key] = replace(value)
+		}
+	}
+	return rendered
+}
+
+// normalizeTemplateSpec validates a template spec like normalizeServerSpec, leaving
+// out the fields holding placeholders: they are validated once rendered, when a
+// server is created from the template.
+func normalizeTemplateSpec(spec *database.ServerSpec) error {
+	fields := specStrings(spec)
+	templated := make(map[*string]string)
+	for _, field := range fields {
+		if placeholderPattern.MatchString(*field) {
+			templated[field] = *field
+			*field = ""
+		}
+	}
+
+	err := normalizeServerSpec(spec)
+	for field, value := range templated {
+		*field = value
+	}
+	return err
+}
@@ -0,0 +1,358 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"minecharts/cmd/api/middleware"
+	"minecharts/cmd/apierror"
+	"minecharts/cmd/auth"
+	"minecharts/cmd/config"
+	"minecharts/cmd/database"
+	"minecharts/cmd/kubernetes"
+	"minecharts/cmd/logging"
+	"minecharts/cmd/security"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ServerTemplateRequest represents the body used to create or replace a server template.
+// The string fields and env values of the spec may reference the parameters as {{NAME}}.
+type ServerTemplateRequest struct {
+	Name        string                      `json:"name" binding:"required,max=64" example:"modded-fabric"`
+	Description string                      `json:"description" binding:"max=256" example:"Fabric 1.21.4 with the community modpack"`
+	Category    string                      `json:"category" binding:"max=32" example:"modded"`
+	Spec        database.ServerSpec         `json:"spec"`
+	Parameters  database.TemplateParameters `json:"parameters,omitempty"`
+}
+
+// ListServerTemplatesHandler lists the server templates available to create servers from.
+//
+// @Summary      List server templates
+// @Description  Lists the server templates that can be referenced with templateId when creating a server
+// @Tags         templates
+// @Produce      json
+// @Security     BearerAuth
+// @Security     APIKeyAuth
+// @Param        category  query     string                   false  "Only the templates of this category"
+// @Success      200       {array}   database.ServerTemplate  "List of server templates"
+// @Failure      401       {object}  apierror.Error           "Authentication required"
+// @Failure      500       {object}  apierror.Error           "Server error"
+// @Router       /templates [get]
+func ListServerTemplatesHandler(c *gin.Context) {
+	templates, err := database.GetDB().ListServerTemplates(c.Request.Context())
+	if err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to list server templates")
+		return
+	}
+
+	category := strings.ToLower(c.Query("category"))
+	listed := make([]*database.ServerTemplate, 0, len(templates))
+	for _, template := range templates {
+		if category != "" && template.Category != category {
+			continue
+		}
+		template.Spec = redactedSpec(template.Spec)
+		listed = append(listed, template)
+	}
+
+	c.JSON(http.StatusOK, listed)
+}
+
+// GetServerTemplateHandler returns a single server template.
+//
+// @Summary      Get server template
+// @Description  Returns a server template by ID
+// @Tags         templates
+// @Produce      json
+// @Security     BearerAuth
+// @Security     APIKeyAuth
+// @Param        id   path      int                      true  "Template ID"
+// @Success      200  {object}  database.ServerTemplate  "Server template"
+// @Failure      400  {object}  apierror.Error           "Invalid template ID"
+// @Failure      401  {object}  apierror.Error           "Authentication required"
+// @Failure      404  {object}  apierror.Error           "Server template not found"
+// @Failure      500  {object}  apierror.Error           "Server error"
+// @Router       /templates/{id} [get]
+func GetServerTemplateHandler(c *gin.Context) {
+	template, ok := loadServerTemplate(c)
+	if !ok {
+		return
+	}
+
+	template.Spec = redactedSpec(template.Spec)
+	c.JSON(http.StatusOK, template)
+}
+
+// CreateServerTemplateHandler creates a server template (admin only).
+//
+// @Summary      Create server template
+// @Description  Creates a reusable server preset (admin only)
+// @Tags         templates
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Security     APIKeyAuth
+// @Param        request  body      ServerTemplateRequest    true  "Template definition"
+// @Success      201      {object}  database.ServerTemplate  "Server template created"
+// @Failure      400      {object}  apierror.Error           "Invalid request"
+// @Failure      401      {object}  apierror.Error           "Authentication required"
+// @Failure      403      {object}  apierror.Error           "Permission denied"
+// @Failure      409      {object}  apierror.Error           "Template name already exists"
+// @Failure      500      {object}  apierror.Error           "Server error"
+// @Router       /templates [post]
+func CreateServerTemplateHandler(c *gin.Context) {
+	user, ok := auth.RequireCurrentUser(c)
+	if !ok {
+		return
+	}
+
+	req, ok := bindServerTemplateRequest(c)
+	if !ok {
+		return
+	}
+
+	createServerTemplate(c, user, req, "")
+}
+
+// createServerTemplate stores a validated template, imported from source when set,
+// and answers 201 with it.
+func createServerTemplate(c *gin.Context, user *database.User, req ServerTemplateRequest, source string) {
+	template := &database.ServerTemplate{
+		Name:        req.Name,
+		Description: req.Description,
+		Category:    req.Category,
+		Spec:        persistedSpec(req.Spec),
+		Parameters:  req.Parameters,
+		Source:      source,
+		CreatedBy:   user.ID,
+	}
+	if err := database.GetDB().CreateServerTemplate(c.Request.Context(), template); err != nil {
+		if errors.Is(err, database.ErrTemplateExists) {
+			apierror.Write(c, http.StatusConflict, "A template with this name already exists")
+		} else {
+			apierror.Write(c, http.StatusInternalServerError, "Failed to create server template")
+		}
+		return
+	}
+	middleware.InvalidateCache(TemplatesCacheGroup)
+
+	logging.Server.WithContext(c.Request.Context()).WithFields(
+		"template_id", template.ID,
+		"template_name", template.Name,
+		"source", source,
+		"user_id", user.ID,
+		"username", user.Username,
+	).Info("Server template created")
+	security.NewEvent(c, security.AdminAction, "create_server_template").WithUser(user).WithTarget(template.Name).Emit()
+	prePullTemplateVersion(c, template, "")
+
+	template.Spec = redactedSpec(template.Spec)
+	c.JSON(http.StatusCreated, template)
+}
+
+// UpdateServerTemplateHandler replaces a server template (admin only).
+// Servers already created from the template are not changed.
+//
+// @Summary      Update server template
+// @Description  Replaces the name, description and spec of a server template (admin only); existing servers are not changed
+// @Tags         templates
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Security     APIKeyAuth
+// @Param        id       path      int                      true  "Template ID"
+// @Param        request  body      ServerTemplateRequest    true  "Template definition"
+// @Success      200      {object}  database.ServerTemplate  "Server template updated"
+// @Failure      400      {object}  apierror.Error           "Invalid request"
+// @Failure      401      {object}  apierror.Error           "Authentication required"
+// @Failure      403      {object}  apierror.Error           "Permission denied"
+// @Failure      404      {object}  apierror.Error           "Server template not found"
+// @Failure      409      {object}  apierror.Error           "Template name already exists"
+// @Failure      500      {object}  apierror.Error           "Server error"
+// @Router       /templates/{id} [put]
+func UpdateServerTemplateHandler(c *gin.Context) {
+	user, ok := auth.RequireCurrentUser(c)
+	if !ok {
+		return
+	}
+
+	template, ok := loadServerTemplate(c)
+	if !ok {
+		return
+	}
+
+	req, ok := bindServerTemplateRequest(c)
+	if !ok {
+		return
+	}
+
+	previousVersion := template.Spec.Version
+	template.Name = req.Name
+	template.Description = req.Description
+	template.Category = req.Category
+	template.Spec = persistedSpec(req.Spec)
+	template.Parameters = req.Parameters
+	if err := database.GetDB().UpdateServerTemplate(c.Request.Context(), template); err != nil {
+		switch {
+		case errors.Is(err, database.ErrTemplateExists):
+			apierror.Write(c, http.StatusConflict, "A template with this name already exists")
+		case errors.Is(err, database.ErrTemplateNotFound):
+			apierror.Write(c, http.StatusNotFound, "Server template not found")
+		default:
+			apierror.Write(c, http.StatusInternalServerError, "Failed to update server template")
+		}
+		return
+	}
+	middleware.InvalidateCache(TemplatesCacheGroup)
+
+	logging.Server.WithContext(c.Request.Context()).WithFields(
+		"template_id", template.ID,
+		"template_name", template.Name,
+		"username", user.Username,
+	).Info("Server template updated")
+	security.NewEvent(c, security.AdminAction, "update_server_template").WithUser(user).WithTarget(template.Name).Emit()
+	prePullTemplateVersion(c, template, previousVersion)
+
+	template.Spec = redactedSpec(template.Spec)
+	c.JSON(http.StatusOK, template)
+}
+
+// DeleteServerTemplateHandler deletes a server template (admin only).
+// Servers already created from the template keep running.
+//
+// @Summary      Delete server template
+// @Description  Deletes a server template (admin only); existing servers are not changed
+// @Tags         templates
+// @Produce      json
+// @Security     BearerAuth
+// @Security     APIKeyAuth
+// @Param        id   path      int                true  "Template ID"
+// @Success      200  {object}  map[string]string  "Server template deleted"
+// @Failure      400  {object}  apierror.Error     "Invalid template ID"
+// @Failure      401  {object}  apierror.Error     "Authentication required"
+// @Failure      403  {object}  apierror.Error     "Permission denied"
+// @Failure      404  {object}  apierror.Error     "Server template not found"
+// @Failure      500  {object}  apierror.Error     "Server error"
+// @Router       /templates/{id} [delete]
+func DeleteServerTemplateHandler(c *gin.Context) {
+	user, ok := auth.RequireCurrentUser(c)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		apierror.Write(c, http.StatusBadRequest, "Invalid template ID")
+		return
+	}
+
+	if err := database.GetDB().DeleteServerTemplate(c.Request.Context(), id); err != nil {
+		if errors.Is(err, database.ErrTemplateNotFound) {
+			apierror.Write(c, http.StatusNotFound, "Server template not found")
+		} else {
+			apierror.Write(c, http.StatusInternalServerError, "Failed to delete server template")
+		}
+		return
+	}
+	middleware.InvalidateCache(TemplatesCacheGroup)
+
+	logging.Server.WithContext(c.Request.Context()).WithFields(
+		"template_id", id,
+		"username", user.Username,
+	).Info("Server template deleted")
+	security.NewEvent(c, security.AdminAction, "delete_server_template").WithUser(user).
+		WithTarget(strconv.FormatInt(id, 10)).Emit()
+
+	c.JSON(http.StatusOK, gin.H{"message": "Server template deleted"})
+}
+
+// loadServerTemplate resolves the template referenced by the :id parameter.
+// It writes the error response and returns false when the template cannot be loaded.
+func loadServerTemplate(c *gin.Context) (*database.ServerTemplate, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		apierror.Write(c, http.StatusBadRequest, "Invalid template ID")
+		return nil, false
+	}
+
+	template, err := database.GetDB().GetServerTemplate(c.Request.Context(), id)
+	if err != nil {
+		if errors.Is(err, database.ErrTemplateNotFound) {
+			apierror.Write(c, http.StatusNotFound, "Server template not found")
+		} else {
+			apierror.Write(c, http.StatusInternalServerError, "Failed to get server template")
+		}
+		return nil, false
+	}
+	return template, true
+}
+
+// bindServerTemplateRequest parses and validates a template body.
+// It writes the error response and returns false when the body is invalid.
+func bindServerTemplateRequest(c *gin.Context) (ServerTemplateRequest, bool) {
+	var req ServerTemplateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		logging.API.InvalidRequest.WithContext(c.Request.Context()).WithFields(
+			"error", err.Error(),
+			"remote_ip", c.ClientIP(),
+		).Warn("Invalid server template request format")
+		apierror.Write(c, http.StatusBadRequest, err.Error())
+		return req, false
+	}
+
+	if err := validateServerTemplateRequest(&req); err != nil {
+		logging.API.InvalidRequest.WithContext(c.Request.Context()).WithFields(
+			"template_name", req.Name,
+			"error", err.Error(),
+			"remote_ip", c.ClientIP(),
+		).Warn("Invalid server template spec")
+		apierror.Write(c, http.StatusBadRequest, err.Error())
+		return req, false
+	}
+	return req, true
+}
+
+// validateServerTemplateRequest checks the parameters and the spec of a template,
+// and normalizes them.
+func validateServerTemplateRequest(req *ServerTemplateRequest) error {
+	req.Category = strings.ToLower(strings.TrimSpace(req.Category))
+	if err := validateTemplateParameters(req.Parameters, req.Spec); err != nil {
+		return err
+	}
+	return normalizeTemplateSpec(&req.Spec)
+}
+
+// prePullTemplateVersion pulls the server images again on every node when a template
+// gets a Minecraft version no other template uses, with image pre-pull enabled, so the
+// servers created from it start right away. Versions taken from a parameter are not
+// known in advance and are not pre-pulled. Failures are only logged.
+func prePullTemplateVersion(c *gin.Context, template *database.ServerTemplate, previousVersion string) {
+	version := template.Spec.Version
+	if !config.PrePullEnabled || version == "" || version == previousVersion || placeholderPattern.MatchString(version) || !kubernetes.ClusterReachable() {
+		return
+	}
+
+	ctx := c.Request.Context()
+	templates, err := database.GetDB().ListServerTemplates(ctx)
+	if err != nil {
+		return
+	}
+	for _, other := range templates {
+		if other.ID != template.ID && other.Spec.Version == version {
+			return
+		}
+	}
+
+	trigger := fmt.Sprintf("template %s (%s)", template.Name, version)
+	if err := kubernetes.EnsurePrePull(ctx, config.DefaultNamespace, kubernetes.PrePullImages(), trigger); err != nil {
+		logging.K8s.WithContext(ctx).WithFields(
+			"template_id", template.ID,
+			"version", version,
+			"error", err.Error(),
+		).Warn("Failed to pre-pull server images for new template version")
+	}
+}
@@ -0,0 +1,243 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"minecharts/cmd/apierror"
+	"minecharts/cmd/auth"
+	"minecharts/cmd/config"
+	"minecharts/cmd/database"
+	"minecharts/cmd/jobs"
+	"minecharts/cmd/kubernetes"
+	"minecharts/cmd/logging"
+
+	"github.com/gin-gonic/gin"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// UpgradeServerRequest represents the Minecraft version to move a server to.
+type UpgradeServerRequest struct {
+	Version        string `json:"version" binding:"required" example:"1.21.4"`
+	TimeoutSeconds int    `json:"timeoutSeconds" binding:"omitempty,min=30,max=3600" example:"600"` // How long the server may take to come up, defaults to MINECHARTS_UPGRADE_TIMEOUT
+}
+
+// UpgradeServerHandler moves a running server to another Minecraft version.
+//
+// The upgrade runs as a job: the world is saved and the server data backed up, then
+// the VERSION of the deployment is changed and the new pod must be ready in time.
+// Otherwise the previous pod template and version are put back, and the backup is
+// left to restore the world the new version may have converted.
+//
+// @Summary      Upgrade Minecraft server
+// @Description  Backs the server data up into /data/backups, sets the VERSION of the server and restarts it, then waits for it to come up. When the server is not ready within the timeout, the previous version is put back and the job fails. The server must be running. Follow the upgrade with the job URL of the response
+// @Tags         servers
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Security     APIKeyAuth
+// @Param        serverName  path      string                  true  "Server name"
+// @Param        request     body      UpgradeServerRequest    true  "Target version"
+// @Success      202         {object}  map[string]interface{}  "Upgrade started, with its job ID"
+// @Failure      400         {object}  apierror.Error          "Invalid version"
+// @Failure      401         {object}  apierror.Error          "Authentication required"
+// @Failure      403         {object}  apierror.Error          "Permission denied"
+// @Failure      404         {object}  apierror.Error          "Server not found"
+// @Failure      409         {object}  apierror.Error          "The server is not running, already runs the version or is being upgraded"
+// @Failure      500         {object}  apierror.Error          "Server error"
+// @Router       /servers/{serverName}/upgrade [post]
+func UpgradeServerHandler(c *gin.Context) {
+	var req UpgradeServerRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Invalid(c, err)
+		return
+	}
+	version := strings.ToUpper(req.Version)
+	timeout := config.UpgradeTimeout
+	if req.TimeoutSeconds > 0 {
+		timeout = time.Duration(req.TimeoutSeconds) * time.Second
+	}
+
+	ctx := c.Request.Context()
+	db := database.GetDB()
+	server, err := db.GetServerByName(ctx, c.Param("serverName"))
+	if err != nil {
+		apierror.Respond(c, apierror.New(http.StatusNotFound, apierror.CodeServerNotFound, "Server not found"))
+		return
+	}
+	if err := validateVersion(server.Spec.ServerType, version); err != nil {
+		apierror.Write(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	namespace := kubernetes.ServerNamespace(server)
+	deployment, ok := kubernetes.CheckDeploymentExists(c, namespace, server.DeploymentName)
+	if !ok {
+		return
+	}
+	previousVersion := kubernetes.ContainerEnv(deployment, "VERSION")
+	if previousVersion == "" {
+		previousVersion = "LATEST"
+	}
+	if previousVersion == version {
+		apierror.Write(c, http.StatusConflict, "The server already runs version "+version)
+		return
+	}
+
+	pod, err := kubernetes.GetMinecraftPod(ctx, namespace, server.DeploymentName)
+	if err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to get server pod: "+err.Error())
+		return
+	}
+	// Coming up again is what tells the upgrade succeeded
+	if pod == nil || pod.Status.Phase != corev1.PodRunning {
+		apierror.Write(c, http.StatusConflict, "The server must be running to be upgraded")
+		return
+	}
+
+	recent, err := db.ListServerJobs(ctx, server.ServerName, 20)
+	if err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to list server jobs")
+		return
+	}
+	for _, job := range recent {
+		if job.Type == database.JobTypeUpgrade && (job.Status == database.JobPending || job.Status == database.JobRunning) {
+			apierror.Respond(c, apierror.New(http.StatusConflict, "", "The server is already being upgraded").With("jobId", job.ID))
+			return
+		}
+	}
+
+	user, ok := auth.RequireCurrentUser(c)
+	if !ok {
+		return
+	}
+	logging.Server.WithContext(ctx).WithFields(
+		"server_name", server.ServerName,
+		"deployment", server.DeploymentName,
+		"from_version", previousVersion,
+		"to_version", version,
+		"user_id", user.ID,
+		"username", user.Username,
+	).Info("Upgrading Minecraft server")
+
+	job := &database.Job{
+		Type:       database.JobTypeUpgrade,
+		ServerName: server.ServerName,
+		OwnerID:    server.OwnerID,
+		CreatedBy:  user.ID,
+	}
+	err = jobs.Start(ctx, job, config.BackupTimeout+config.ExecTimeout+timeout, func(ctx context.Context, progress jobs.Progress) (string, error) {
+		return upgradeServer(ctx, server, version, timeout, progress)
+	})
+	if err != nil {
+		jobStartFailed(c, err, "Failed to start upgrade job")
+		return
+	}
+
+	acceptJob(c, job, gin.H{
+		"message":         "Server upgrade started",
+		"serverName":      server.ServerName,
+		"previousVersion": previousVersion,
+		"version":         version,
+	})
+}
+
+// upgradeServer backs a server up, sets its version and waits for it to come up,
+// putting the previous version back when it does not.
+func upgradeServer(ctx context.Context, server *database.MinecraftServer, version string, timeout time.Duration, progress jobs.Progress) (string, error) {
+	namespace := kubernetes.ServerNamespace(server)
+
+	progress(5, "Saving the world")
+	pod, err := kubernetes.GetMinecraftPod(ctx, namespace, server.DeploymentName)
+	if err != nil {
+		return "", fmt.Errorf("failed to get server pod: %w", err)
+	}
+	// The Bedrock server saves its world on its own and has no save-all
+	if pod != nil && pod.Status.Phase == corev1.PodRunning && !isBedrock(server.Spec) {
+		if _, _, err := kubernetes.SaveWorld(ctx, pod.Name, namespace); err != nil {
+			return "", fmt.Errorf("failed to save world: %w", err)
+		}
+	}
+
+	progress(10, "Backing up the server data")
+	archive, err := kubernetes.BackupVolume(ctx, namespace, server)
+	if err != nil {
+		return "", err
+	}
+
+	progress(50, "Restarting with version "+version)
+	deployment, err := kubernetes.GetDeployment(ctx, namespace, server.DeploymentName)
+	if err != nil {
+		return "", fmt.Errorf("failed to get deployment: %w", err)
+	}
+	if deployment == nil {
+		return "", errors.New("the deployment of the server is gone")
+	}
+	previous := deployment.Spec.Template.DeepCopy()
+	env := withEnvVar(serverContainerEnv(deployment.Spec.Template.Spec.Containers), "VERSION", version)
+	if err := kubernetes.UpdateDeployment(ctx, namespace, server.DeploymentName, env); err != nil {
+		return "", fmt.Errorf("failed to update deployment: %w", err)
+	}
+
+	// The record follows the deployment, so that later changes keep the version
+	spec := server.Spec
+	previousVersion := spec.Version
+	spec.Version = version
+	if err := database.GetDB().UpdateServerSpec(ctx, server.ServerName, spec); err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"server_name", server.ServerName,
+			"error", err.Error(),
+		).Warn("Failed to record upgraded server version")
+	}
+
+	progress(60, "Waiting for the server to come up")
+	if _, err := kubernetes.WaitForRollout(ctx, namespace, server.DeploymentName, previous, timeout); err != nil {
+		if errors.Is(err, kubernetes.ErrRolloutAborted) {
+			spec.Version = previousVersion
+			if err := database.GetDB().UpdateServerSpec(context.WithoutCancel(ctx), server.ServerName, spec); err != nil {
+				logging.DB.WithContext(ctx).WithFields(
+					"server_name", server.ServerName,
+					"error", err.Error(),
+				).Warn("Failed to record rolled back server version")
+			}
+		}
+		logging.Server.WithContext(ctx).WithFields(
+			"server_name", server.ServerName,
+			"version", version,
+			"backup", archive,
+			"error", err.Error(),
+		).Warn("Minecraft server upgrade failed")
+		return "", fmt.Errorf("server did not come up with version %s, the data before the upgrade is in %s: %w", version, archive, err)
+	}
+
+	logging.Server.WithContext(ctx).WithFields(
+		"server_name", server.ServerName,
+		"version", version,
+		"backup", archive,
+	).Info("Minecraft server upgraded successfully")
+	return "Server upgraded to " + version + ", the data before the upgrade is in " + archive, nil
+}
+
+// serverContainerEnv returns a copy of the environment of the minecraft-server container.
+func serverContainerEnv(containers []corev1.Container) []corev1.EnvVar {
+	for _, container := range containers {
+		if container.Name == "minecraft-server" {
+			return append([]corev1.EnvVar(nil), container.Env...)
+		}
+	}
+	return nil
+}
+
+// withEnvVar sets a variable of an environment, adding it when missing.
+func withEnvVar(env []corev1.EnvVar, name, value string) []corev1.EnvVar {
+	for i := range env {
+		if env[i].Name == name {
+			env[i] = corev1.EnvVar{Name: name, Value: value}
+			return env
+		}
+	}
+	return append(env, corev1.EnvVar{Name: name, Value: value})
+}
@@ -1,12 +1,19 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
+	"minecharts/cmd/apierror"
 	"minecharts/cmd/auth"
+	"minecharts/cmd/config"
 	"minecharts/cmd/database"
+	"minecharts/cmd/kubernetes"
 	"minecharts/cmd/logging"
+	"minecharts/cmd/security"
 
 	"github.com/gin-gonic/gin"
 )
@@ -21,6 +28,15 @@ type UpdateUserRequest struct {
 	Active      *bool   `json:"active" example:"true"`
 }
 
+// CreateUserRequest represents a request to create a user (admin only).
+type CreateUserRequest struct {
+	Username               string `json:"username" binding:"required,min=3,max=50" example:"newuser"`
+	Email                  string `json:"email" binding:"required,email" example:"user@example.com"`
+	Password               string `json:"password" binding:"required,min=8" example:"securepass123"`
+	Permissions            *int64 `json:"permissions" example:"128"`               // Bit flags for permissions, read-only when omitted
+	PasswordChangeRequired bool   `json:"password_change_required" example:"true"` // The user must change the password before anything else
+}
+
 // PermissionAction represents a single permission action.
 type PermissionAction struct {
 	Permission int64  `json:"permission" binding:"required" example:"128"`
@@ -35,59 +51,165 @@ type ModifyPermissionsRequest struct {
 // ListUsersHandler returns a list of all users (admin only).
 //
 // @Summary      List all users
-// @Description  Returns a list of all users in the system (admin only)
+// @Description  Returns the users in the system matching the filters, a page at a time with limit or offset, the X-Total-Count header giving the number of users matching (admin only)
 // @Tags         users
 // @Produce      json
 // @Security     BearerAuth
-// @Success      200  {array}   map[string]interface{}  "List of users"
-// @Failure      401  {object}  map[string]string       "Authentication required"
-// @Failure      403  {object}  map[string]string       "Permission denied"
-// @Failure      500  {object}  map[string]string       "Server error"
+// @Param        search   query     string  false  "Part of the username, email or display name"
+// @Param        active   query     bool    false  "Whether the users are active"
+// @Param        deleted  query     bool    false  "Whether the users are deleted, waiting to be purged"
+// @Param        sort     query     string  false  "id, username, email, created_at or last_login, prefixed with - for a descending sort"
+// @Param        limit    query     int     false  "Maximum number of users (max 500, every user when limit and offset are omitted)"
+// @Param        offset   query     int     false  "Number of users skipped (the limit defaults to 50)"
+// @Success      200      {array}   map[string]interface{}  "List of users"
+// @Header       200      {integer} X-Total-Count  "Number of users matching the filters"
+// @Failure      400      {object}  apierror.Error          "Invalid filter or page"
+// @Failure      401      {object}  apierror.Error          "Authentication required"
+// @Failure      403      {object}  apierror.Error          "Permission denied"
+// @Failure      500      {object}  apierror.Error          "Server error"
 // @Router       /users [get]
 func ListUsersHandler(c *gin.Context) {
 	// Get current admin user for logging
-	adminUser, _ := auth.GetCurrentUser(c)
+	adminUser, ok := auth.RequireCurrentUser(c)
+	if !ok {
+		return
+	}
 
-	logging.Auth.WithFields(
+	logging.Auth.WithContext(c.Request.Context()).WithFields(
 		"admin_user_id", adminUser.ID,
 		"username", adminUser.Username,
 		"remote_ip", c.ClientIP(),
 	).Info("Admin requesting list of all users")
 
+	page, ok := parsePage(c, database.UserSortFields)
+	if !ok {
+		return
+	}
+	filter := database.UserFilter{Page: page, Search: c.Query("search")}
+	if filter.Active, ok = parseBoolQuery(c, "active"); !ok {
+		return
+	}
+	if filter.Deleted, ok = parseBoolQuery(c, "deleted"); !ok {
+		return
+	}
+
 	db := database.GetDB()
-	users, err := db.ListUsers(c.Request.Context())
+	users, total, err := db.SearchUsers(c.Request.Context(), filter)
 	if err != nil {
-		logging.DB.WithFields(
+		logging.DB.WithContext(c.Request.Context()).WithFields(
 			"admin_user_id", adminUser.ID,
 			"error", err.Error(),
 		).Error("Failed to list users from database")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list users"})
+		apierror.Write(c, http.StatusInternalServerError, "Failed to list users")
 		return
 	}
 
-	logging.Auth.WithFields(
+	logging.Auth.WithContext(c.Request.Context()).WithFields(
 		"admin_user_id", adminUser.ID,
 		"user_count", len(users),
+		"total", total,
 	).Debug("Successfully retrieved user list")
 
 	// Convert to a safer format without password hashes
 	response := make([]gin.H, len(users))
 	for i, user := range users {
 		response[i] = gin.H{
-			"id":          user.ID,
-			"username":    user.Username,
-			"email":       user.Email,
-			"permissions": user.Permissions,
-			"active":      user.Active,
-			"last_login":  user.LastLogin,
-			"created_at":  user.CreatedAt,
-			"updated_at":  user.UpdatedAt,
+			"id":                 user.ID,
+			"username":           user.Username,
+			"email":              user.Email,
+			"display_name":       user.DisplayName,
+			"permissions":        user.Permissions,
+			"direct_permissions": user.DirectPermissions,
+			"roles":              user.Roles,
+			"active":             user.Active,
+			"last_login":         user.LastLogin,
+			"deleted_at":         user.DeletedAt,
+			"created_at":         user.CreatedAt,
+			"updated_at":         user.UpdatedAt,
+			"minecraft_uuid":     user.MinecraftUUID,
+			"minecraft_name":     user.MinecraftName,
 		}
 	}
 
+	c.Header(totalCountHeader, strconv.Itoa(total))
 	c.JSON(http.StatusOK, response)
 }
 
+// CreateUserHandler creates a user with the permissions chosen by an admin (admin only).
+//
+// @Summary      Create user
+// @Description  Creates an account directly, with the permissions chosen by the admin (read-only when omitted)
+// @Tags         users
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        request  body      CreateUserRequest       true  "User information"
+// @Success      201      {object}  map[string]interface{}  "Created user"
+// @Failure      400      {object}  apierror.Error          "Invalid request"
+// @Failure      401      {object}  apierror.Error          "Authentication required"
+// @Failure      403      {object}  apierror.Error          "Permission denied"
+// @Failure      409      {object}  apierror.Error          "Username or email already exists"
+// @Failure      500      {object}  apierror.Error          "Server error"
+// @Router       /users [post]
+func CreateUserHandler(c *gin.Context) {
+	currentUser, ok := auth.RequireCurrentUser(c)
+	if !ok {
+		return
+	}
+
+	var req CreateUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Invalid(c, err)
+		return
+	}
+	permissions := database.PermReadOnly
+	if req.Permissions != nil {
+		permissions = *req.Permissions
+	}
+
+	passwordHash, err := auth.HashPassword(req.Password)
+	if err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to hash password")
+		return
+	}
+	user := &database.User{
+		Username:               req.Username,
+		Email:                  req.Email,
+		PasswordHash:           passwordHash,
+		Permissions:            permissions,
+		Active:                 true,
+		PasswordChangeRequired: req.PasswordChangeRequired,
+	}
+	if err := database.GetDB().CreateUser(c.Request.Context(), user); err != nil {
+		if errors.Is(err, database.ErrUserExists) {
+			apierror.Write(c, http.StatusConflict, "Username or email already exists")
+			return
+		}
+		apierror.Write(c, http.StatusInternalServerError, "Failed to create user")
+		return
+	}
+
+	logging.Auth.WithContext(c.Request.Context()).WithFields(
+		"current_user_id", currentUser.ID,
+		"username", currentUser.Username,
+		"target_user_id", user.ID,
+		"target_username", user.Username,
+		"permissions", user.Permissions,
+	).Info("User created by admin")
+	security.NewEvent(c, security.AdminAction, "create_user").WithUser(currentUser).WithTarget(user.Username).
+		WithDetail("permissions", strconv.FormatInt(user.Permissions, 10)).Emit()
+
+	c.JSON(http.StatusCreated, gin.H{
+		"id":                       user.ID,
+		"username":                 user.Username,
+		"email":                    user.Email,
+		"permissions":              user.Permissions,
+		"active":                   user.Active,
+		"password_change_required": user.PasswordChangeRequired,
+		"created_at":               user.CreatedAt,
+	})
+}
+
 // GetUserHandler returns details for a specific user.
 //
 // @Summary      Get user details
@@ -97,22 +219,22 @@ func ListUsersHandler(c *gin.Context) {
 // @Security     BearerAuth
 // @Param        id   path      integer  true  "User ID"
 // @Success      200  {object}  map[string]interface{}  "User details"
-// @Failure      400  {object}  map[string]string       "Invalid user ID"
-// @Failure      401  {object}  map[string]string       "Authentication required"
-// @Failure      403  {object}  map[string]string       "Permission denied"
-// @Failure      404  {object}  map[string]string       "User not found"
-// @Failure      500  {object}  map[string]string       "Server error"
+// @Failure      400  {object}  apierror.Error          "Invalid user ID"
+// @Failure      401  {object}  apierror.Error          "Authentication required"
+// @Failure      403  {object}  apierror.Error          "Permission denied"
+// @Failure      404  {object}  apierror.Error          "User not found"
+// @Failure      500  {object}  apierror.Error          "Server error"
 // @Router       /users/{id} [get]
 func GetUserHandler(c *gin.Context) {
 	// Get current user
 	currentUser, ok := auth.GetCurrentUser(c)
 	if !ok {
-		logging.API.InvalidRequest.WithFields(
+		logging.API.InvalidRequest.WithContext(c.Request.Context()).WithFields(
 			"path", c.Request.URL.Path,
 			"remote_ip", c.ClientIP(),
 			"error", "not_authenticated",
 		).Warn("Get user details failed: user not authenticated")
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		apierror.Write(c, http.StatusUnauthorized, "Authentication required")
 		return
 	}
 
@@ -120,18 +242,18 @@ func GetUserHandler(c *gin.Context) {
 	idStr := c.Param("id")
 	id, err := strconv.ParseInt(idStr, 10, 64)
 	if err != nil {
-		logging.API.InvalidRequest.WithFields(
+		logging.API.InvalidRequest.WithContext(c.Request.Context()).WithFields(
 			"current_user_id", currentUser.ID,
 			"username", currentUser.Username,
 			"requested_id", idStr,
 			"remote_ip", c.ClientIP(),
 			"error", "invalid_id_format",
 		).Warn("Get user details failed: invalid user ID format")
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		apierror.Write(c, http.StatusBadRequest, "Invalid user ID")
 		return
 	}
 
-	logging.Auth.Session.WithFields(
+	logging.Auth.Session.WithContext(c.Request.Context()).WithFields(
 		"current_user_id", currentUser.ID,
 		"username", currentUser.Username,
 		"requested_user_id", id,
@@ -140,14 +262,14 @@ func GetUserHandler(c *gin.Context) {
 
 	// Users can only view their own details unless they're an admin
 	if !currentUser.IsAdmin() && currentUser.ID != id {
-		logging.Auth.Session.WithFields(
+		logging.Auth.Session.WithContext(c.Request.Context()).WithFields(
 			"current_user_id", currentUser.ID,
 			"username", currentUser.Username,
 			"requested_user_id", id,
 			"remote_ip", c.ClientIP(),
 			"error", "permission_denied",
 		).Warn("Get user details failed: non-admin attempting to access another user's details")
-		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		apierror.Write(c, http.StatusForbidden, "Permission denied")
 		return
 	}
 
@@ -156,26 +278,26 @@ func GetUserHandler(c *gin.Context) {
 	user, err := db.GetUserByID(c.Request.Context(), id)
 	if err != nil {
 		if err == database.ErrUserNotFound {
-			logging.Auth.Session.WithFields(
+			logging.Auth.Session.WithContext(c.Request.Context()).WithFields(
 				"current_user_id", currentUser.ID,
 				"username", currentUser.Username,
 				"requested_user_id", id,
 				"error", "user_not_found",
 			).Warn("Get user details failed: user not found")
-			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			apierror.Write(c, http.StatusNotFound, "User not found")
 			return
 		}
-		logging.DB.WithFields(
+		logging.DB.WithContext(c.Request.Context()).WithFields(
 			"current_user_id", currentUser.ID,
 			"username", currentUser.Username,
 			"requested_user_id", id,
 			"error", err.Error(),
 		).Error("Get user details failed: database error")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user"})
+		apierror.Write(c, http.StatusInternalServerError, "Failed to get user")
 		return
 	}
 
-	logging.Auth.Session.WithFields(
+	logging.Auth.Session.WithContext(c.Request.Context()).WithFields(
 		"current_user_id", currentUser.ID,
 		"username", currentUser.Username,
 		"requested_user_id", id,
@@ -183,14 +305,20 @@ func GetUserHandler(c *gin.Context) {
 	).Debug("User details retrieved successfully")
 
 	c.JSON(http.StatusOK, gin.H{
-		"id":          user.ID,
-		"username":    user.Username,
-		"email":       user.Email,
-		"permissions": user.Permissions,
-		"active":      user.Active,
-		"last_login":  user.LastLogin,
-		"created_at":  user.CreatedAt,
-		"updated_at":  user.UpdatedAt,
+		"id":                 user.ID,
+		"username":           user.Username,
+		"email":              user.Email,
+		"display_name":       user.DisplayName,
+		"permissions":        user.Permissions,
+		"direct_permissions": user.DirectPermissions,
+		"roles":              user.Roles,
+		"active":             user.Active,
+		"last_login":         user.LastLogin,
+		"deleted_at":         user.DeletedAt,
+		"created_at":         user.CreatedAt,
+		"updated_at":         user.UpdatedAt,
+		"minecraft_uuid":     user.MinecraftUUID,
+		"minecraft_name":     user.MinecraftName,
 	})
 }
 
@@ -205,22 +333,22 @@ func GetUserHandler(c *gin.Context) {
 // @Param        id       path      integer           true  "User ID"
 // @Param        request  body      UpdateUserRequest  true  "User information to update"
 // @Success      200      {object}  map[string]interface{}  "Updated user details"
-// @Failure      400      {object}  map[string]string       "Invalid request"
-// @Failure      401      {object}  map[string]string       "Authentication required"
-// @Failure      403      {object}  map[string]string       "Permission denied"
-// @Failure      404      {object}  map[string]string       "User not found"
-// @Failure      500      {object}  map[string]string       "Server error"
+// @Failure      400      {object}  apierror.Error          "Invalid request"
+// @Failure      401      {object}  apierror.Error          "Authentication required"
+// @Failure      403      {object}  apierror.Error          "Permission denied"
+// @Failure      404      {object}  apierror.Error          "User not found"
+// @Failure      500      {object}  apierror.Error          "Server error"
 // @Router       /users/{id} [put]
 func UpdateUserHandler(c *gin.Context) {
 	// Get current user
 	currentUser, ok := auth.GetCurrentUser(c)
 	if !ok {
-		logging.API.InvalidRequest.WithFields(
+		logging.API.InvalidRequest.WithContext(c.Request.Context()).WithFields(
 			"path", c.Request.URL.Path,
 			"remote_ip", c.ClientIP(),
 			"error", "not_authenticated",
 		).Warn("Update user failed: user not authenticated")
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		apierror.Write(c, http.StatusUnauthorized, "Authentication required")
 		return
 	}
 
@@ -228,18 +356,18 @@ func UpdateUserHandler(c *gin.Context) {
 	idStr := c.Param("id")
 	id, err := strconv.ParseInt(idStr, 10, 64)
 	if err != nil {
-		logging.API.InvalidRequest.WithFields(
+		logging.API.InvalidRequest.WithContext(c.Request.Context()).WithFields(
 			"current_user_id", currentUser.ID,
 			"username", currentUser.Username,
 			"requested_id", idStr,
 			"remote_ip", c.ClientIP(),
 			"error", "invalid_id_format",
 		).Warn("Update user failed: invalid user ID format")
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		apierror.Write(c, http.StatusBadRequest, "Invalid user ID")
 		return
 	}
 
-	logging.Auth.WithFields(
+	logging.Auth.WithContext(c.Request.Context()).WithFields(
 		"current_user_id", currentUser.ID,
 		"username", currentUser.Username,
 		"target_user_id", id,
@@ -251,14 +379,14 @@ func UpdateUserHandler(c *gin.Context) {
 	isSelf := currentUser.ID == id
 
 	if !isAdmin && !isSelf {
-		logging.Auth.WithFields(
+		logging.Auth.WithContext(c.Request.Context()).WithFields(
 			"current_user_id", currentUser.ID,
 			"username", currentUser.Username,
 			"target_user_id", id,
 			"remote_ip", c.ClientIP(),
 			"error", "permission_denied",
 		).Warn("Update user failed: non-admin attempting to update another user")
-		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		apierror.Write(c, http.StatusForbidden, "Permission denied")
 		return
 	}
 
@@ -267,35 +395,39 @@ func UpdateUserHandler(c *gin.Context) {
 	user, err := db.GetUserByID(c.Request.Context(), id)
 	if err != nil {
 		if err == database.ErrUserNotFound {
-			logging.Auth.WithFields(
+			logging.Auth.WithContext(c.Request.Context()).WithFields(
 				"current_user_id", currentUser.ID,
 				"username", currentUser.Username,
 				"target_user_id", id,
 				"error", "user_not_found",
 			).Warn("Update user failed: target user not found")
-			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			apierror.Write(c, http.StatusNotFound, "User not found")
 			return
 		}
-		logging.DB.WithFields(
+		logging.DB.WithContext(c.Request.Context()).WithFields(
 			"current_user_id", currentUser.ID,
 			"username", currentUser.Username,
 			"target_user_id", id,
 			"error", err.Error(),
 		).Error("Update user failed: database error when retrieving user")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user"})
+		apierror.Write(c, http.StatusInternalServerError, "Failed to get user")
+		return
+	}
+	if user.DeletedAt != nil {
+		apierror.Write(c, http.StatusConflict, "User is deleted: restore it first")
 		return
 	}
 
 	// Parse update request
 	var req UpdateUserRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		logging.API.InvalidRequest.WithFields(
+		logging.API.InvalidRequest.WithContext(c.Request.Context()).WithFields(
 			"current_user_id", currentUser.ID,
 			"username", currentUser.Username,
 			"target_user_id", id,
 			"error", err.Error(),
 		).Warn("Update user failed: invalid request format")
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		apierror.Write(c, http.StatusBadRequest, err.Error())
 		return
 	}
 
@@ -316,26 +448,26 @@ func UpdateUserHandler(c *gin.Context) {
 	if req.Password != nil {
 		// Only admins or the user themselves can change passwords
 		if !isAdmin && !isSelf {
-			logging.Auth.WithFields(
+			logging.Auth.WithContext(c.Request.Context()).WithFields(
 				"current_user_id", currentUser.ID,
 				"username", currentUser.Username,
 				"target_user_id", id,
 				"error", "permission_denied",
 			).Warn("Update user failed: attempt to change password without permission")
-			c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+			apierror.Write(c, http.StatusForbidden, "Permission denied")
 			return
 		}
 
 		updateFields = append(updateFields, "password")
 		passwordHash, err := auth.HashPassword(*req.Password)
 		if err != nil {
-			logging.Auth.WithFields(
+			logging.Auth.WithContext(c.Request.Context()).WithFields(
 				"current_user_id", currentUser.ID,
 				"username", currentUser.Username,
 				"target_user_id", id,
 				"error", err.Error(),
 			).Error("Update user failed: password hashing error")
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+			apierror.Write(c, http.StatusInternalServerError, "Failed to hash password")
 			return
 		}
 		user.PasswordHash = passwordHash
@@ -344,36 +476,37 @@ func UpdateUserHandler(c *gin.Context) {
 	if req.Permissions != nil {
 		// Only admins can change permissions
 		if !isAdmin {
-			logging.Auth.WithFields(
+			logging.Auth.WithContext(c.Request.Context()).WithFields(
 				"current_user_id", currentUser.ID,
 				"username", currentUser.Username,
 				"target_user_id", id,
 				"error", "permission_denied",
 			).Warn("Update user failed: non-admin attempting to change permissions")
-			c.JSON(http.StatusForbidden, gin.H{"error": "Only administrators can modify permissions"})
+			apierror.Write(c, http.StatusForbidden, "Only administrators can modify permissions")
 			return
 		}
 		updateFields = append(updateFields, "permissions")
-		user.Permissions = *req.Permissions
+		// Roles grant their permissions on top of these
+		user.SetDirectPermissions(*req.Permissions)
 	}
 
 	if req.Active != nil {
 		// Only admins can change active status
 		if !isAdmin {
-			logging.Auth.WithFields(
+			logging.Auth.WithContext(c.Request.Context()).WithFields(
 				"current_user_id", currentUser.ID,
 				"username", currentUser.Username,
 				"target_user_id", id,
 				"error", "permission_denied",
 			).Warn("Update user failed: non-admin attempting to change account status")
-			c.JSON(http.StatusForbidden, gin.H{"error": "Only administrators can change account status"})
+			apierror.Write(c, http.StatusForbidden, "Only administrators can change account status")
 			return
 		}
 		updateFields = append(updateFields, "active")
 		user.Active = *req.Active
 	}
 
-	logging.Auth.WithFields(
+	logging.Auth.WithContext(c.Request.Context()).WithFields(
 		"current_user_id", currentUser.ID,
 		"username", currentUser.Username,
 		"target_user_id", id,
@@ -383,23 +516,29 @@ func UpdateUserHandler(c *gin.Context) {
 
 	// Update user in database
 	if err := db.UpdateUser(c.Request.Context(), user); err != nil {
-		logging.DB.WithFields(
+		logging.DB.WithContext(c.Request.Context()).WithFields(
 			"current_user_id", currentUser.ID,
 			"username", currentUser.Username,
 			"target_user_id", id,
 			"error", err.Error(),
 		).Error("Update user failed: database error when saving updates")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
+		apierror.Write(c, http.StatusInternalServerError, "Failed to update user")
 		return
 	}
 
-	logging.Auth.WithFields(
+	logging.Auth.WithContext(c.Request.Context()).WithFields(
 		"current_user_id", currentUser.ID,
 		"username", currentUser.Username,
 		"target_user_id", id,
 		"target_username", user.Username,
 		"updated_fields", updateFields,
 	).Info("User updated successfully")
+	if !isSelf {
+		security.NewEvent(c, security.AdminAction, "update_user").WithUser(currentUser).
+			WithTarget(user.Username).WithDetail("updated_fields", strings.Join(updateFields, ",")).Emit()
+	}
+	// Permission or activation changes change the servers the user can access
+	kubernetes.RequestWhitelistSync()
 
 	c.JSON(http.StatusOK, gin.H{
 		"id":          user.ID,
@@ -412,39 +551,47 @@ func UpdateUserHandler(c *gin.Context) {
 	})
 }
 
-// DeleteUserHandler deletes a user (admin only).
+// DeleteUserHandler deletes a user (admin only). The user is deactivated and kept
+// for config.UserDeletionGracePeriod, during which RestoreUserHandler brings them
+// back, then purged. The servers they own must be transferred or deleted with them.
 //
 // @Summary      Delete user
-// @Description  Deletes a user from the system
+// @Description  Deactivates the user and deletes them once the grace period (MINECHARTS_USER_DELETION_GRACE_PERIOD) is over, unless restored. The servers they own are given to another user with servers=transfer and owner, or deleted with servers=delete; users owning servers are not deleted otherwise
 // @Tags         users
 // @Produce      json
 // @Security     BearerAuth
-// @Param        id   path      integer  true  "User ID"
-// @Success      200  {object}  map[string]string  "User deleted successfully"
-// @Failure      400  {object}  map[string]string  "Invalid user ID"
-// @Failure      401  {object}  map[string]string  "Authentication required"
-// @Failure      403  {object}  map[string]string  "Permission denied"
-// @Failure      404  {object}  map[string]string  "User not found"
-// @Failure      500  {object}  map[string]string  "Server error"
+// @Param        id       path      integer  true   "User ID"
+// @Param        servers  query     string   false  "What becomes of the servers of the user: transfer or delete"
+// @Param        owner    query     integer  false  "ID of the user the servers are transferred to"
+// @Success      200      {object}  map[string]interface{}  "User deleted, with the time they will be purged"
+// @Failure      400      {object}  apierror.Error          "Invalid user ID or server handling"
+// @Failure      401      {object}  apierror.Error          "Authentication required"
+// @Failure      403      {object}  apierror.Error          "Permission denied"
+// @Failure      404      {object}  apierror.Error          "User not found"
+// @Failure      409      {object}  apierror.Error          "User already deleted, or owning servers"
+// @Failure      500      {object}  apierror.Error          "Server error"
 // @Router       /users/{id} [delete]
 func DeleteUserHandler(c *gin.Context) {
 	// Get current admin user for logging
-	adminUser, _ := auth.GetCurrentUser(c)
+	adminUser, ok := auth.RequireCurrentUser(c)
+	if !ok {
+		return
+	}
 
 	// Get user ID from URL parameter
 	idStr := c.Param("id")
 	id, err := strconv.ParseInt(idStr, 10, 64)
 	if err != nil {
-		logging.API.InvalidRequest.WithFields(
+		logging.API.InvalidRequest.WithContext(c.Request.Context()).WithFields(
 			"admin_user_id", adminUser.ID,
 			"user_id_param", idStr,
 			"error", "invalid_id_format",
 		).Warn("Invalid user ID format in delete request")
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		apierror.Write(c, http.StatusBadRequest, "Invalid user ID")
 		return
 	}
 
-	logging.Auth.WithFields(
+	logging.Auth.WithContext(c.Request.Context()).WithFields(
 		"admin_user_id", adminUser.ID,
 		"username", adminUser.Username,
 		"target_user_id", id,
@@ -453,42 +600,188 @@ func DeleteUserHandler(c *gin.Context) {
 
 	// Don't allow admins to delete themselves
 	if adminUser.ID == id {
-		logging.Auth.WithFields(
+		logging.Auth.WithContext(c.Request.Context()).WithFields(
 			"admin_user_id", adminUser.ID,
 			"error", "self_deletion_attempt",
 		).Warn("Admin attempted to delete their own account")
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot delete your own account"})
+		apierror.Write(c, http.StatusBadRequest, "Cannot delete your own account")
 		return
 	}
 
-	// Delete user from database
+	ctx := c.Request.Context()
 	db := database.GetDB()
-	if err := db.DeleteUser(c.Request.Context(), id); err != nil {
+	target, err := db.GetUserByID(ctx, id)
+	if err != nil {
 		if err == database.ErrUserNotFound {
-			logging.Auth.WithFields(
+			logging.Auth.WithContext(ctx).WithFields(
 				"admin_user_id", adminUser.ID,
 				"target_user_id", id,
 				"error", "user_not_found",
 			).Warn("Deletion failed: user not found")
-			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			apierror.Write(c, http.StatusNotFound, "User not found")
+			return
+		}
+		apierror.Write(c, http.StatusInternalServerError, "Failed to get user")
+		return
+	}
+	if target.DeletedAt != nil {
+		apierror.Write(c, http.StatusConflict, "User is already deleted")
+		return
+	}
+
+	// The servers of the user are never left without an owner
+	servers, err := db.ListServersByOwner(ctx, id)
+	if err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to list the servers of the user")
+		return
+	}
+	var newOwner *database.User
+	switch mode := c.Query("servers"); {
+	case mode == "" && len(servers) > 0:
+		names := make([]string, len(servers))
+		for i, server := range servers {
+			names[i] = server.ServerName
+		}
+		apierror.Respond(c, apierror.New(http.StatusConflict, "", "User owns servers: transfer them with servers=transfer&owner=<user ID>, or delete them with servers=delete").
+			With("servers", names))
+		return
+	case mode == "transfer":
+		ownerID, err := strconv.ParseInt(c.Query("owner"), 10, 64)
+		if err != nil || ownerID == id {
+			apierror.Write(c, http.StatusBadRequest, "Transferring the servers requires the owner ID of another user")
 			return
 		}
-		logging.DB.WithFields(
+		newOwner, err = db.GetUserByID(ctx, ownerID)
+		if err != nil || !newOwner.Active {
+			apierror.Write(c, http.StatusBadRequest, "The servers can only be transferred to an active user")
+			return
+		}
+	case mode != "" && mode != "delete":
+		apierror.Write(c, http.StatusBadRequest, "servers must be transfer or delete")
+		return
+	}
+
+	// Deactivate the user, keeping them until the grace period is over. The transfer
+	// commits with the deletion, so a failure leaves neither a deleted user owning
+	// servers nor transferred servers of an active user
+	deletedAt := time.Now()
+	message := "Failed to delete user"
+	err = db.WithTx(ctx, func(tx database.DB) error {
+		if newOwner != nil {
+			if _, err := tx.TransferServers(ctx, id, newOwner.ID); err != nil {
+				message = "Failed to transfer the servers"
+				return err
+			}
+		}
+		return tx.SoftDeleteUser(ctx, id, deletedAt)
+	})
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
 			"admin_user_id", adminUser.ID,
 			"target_user_id", id,
 			"error", err.Error(),
 		).Error("Database error when deleting user")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete user"})
+		apierror.Write(c, http.StatusInternalServerError, message)
+		return
+	}
+
+	// The worlds go once the deletion committed, a failed one keeping them
+	if newOwner == nil {
+		for _, server := range servers {
+			deploymentName := server.DeploymentName
+			if deploymentName == "" {
+				deploymentName = config.DeploymentPrefix + server.ServerName
+			}
+			pvcName := server.PVCName
+			if pvcName == "" {
+				pvcName = deploymentName + config.PVCSuffix
+			}
+			deleteServerResources(ctx, server.ServerName, deploymentName, pvcName, server)
+		}
+	}
+
+	fields := []interface{}{
+		"admin_user_id", adminUser.ID,
+		"username", adminUser.Username,
+		"target_user_id", id,
+		"servers", len(servers),
+	}
+	if newOwner != nil {
+		fields = append(fields, "new_owner_id", newOwner.ID)
+	}
+	logging.Auth.WithContext(ctx).WithFields(fields...).Info("User deleted successfully")
+	event := security.NewEvent(c, security.AdminAction, "delete_user").WithUser(adminUser).
+		WithTarget(strconv.FormatInt(id, 10)).WithDetail("servers", strconv.Itoa(len(servers)))
+	if newOwner != nil {
+		event.WithDetail("new_owner_id", strconv.FormatInt(newOwner.ID, 10))
+	}
+	event.Emit()
+	// The user leaves the whitelists, and the servers follow their new owner
+	if target.MinecraftName != "" {
+		kubernetes.RequestWhitelistSync(target.MinecraftName)
+	} else if newOwner != nil && len(servers) > 0 {
+		kubernetes.RequestWhitelistSync()
+	}
+
+	response := gin.H{
+		"message":  "User deleted",
+		"purge_at": deletedAt.Add(config.UserDeletionGracePeriod),
+	}
+	if newOwner != nil {
+		response["servers_transferred"] = len(servers)
+	} else {
+		response["servers_deleted"] = len(servers)
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// RestoreUserHandler restores a deleted user before they are purged (admin only).
+//
+// @Summary      Restore user
+// @Description  Reactivates a user deleted less than the grace period ago. The servers deleted with them are not restored
+// @Tags         users
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id   path      integer  true  "User ID"
+// @Success      200  {object}  map[string]string  "User restored"
+// @Failure      400  {object}  apierror.Error     "Invalid user ID"
+// @Failure      401  {object}  apierror.Error     "Authentication required"
+// @Failure      403  {object}  apierror.Error     "Permission denied"
+// @Failure      404  {object}  apierror.Error     "No deleted user with this ID"
+// @Failure      500  {object}  apierror.Error     "Server error"
+// @Router       /users/{id}/restore [post]
+func RestoreUserHandler(c *gin.Context) {
+	adminUser, ok := auth.RequireCurrentUser(c)
+	if !ok {
+		return
+	}
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		apierror.Write(c, http.StatusBadRequest, "Invalid user ID")
 		return
 	}
 
-	logging.Auth.WithFields(
+	// The users past their grace period are only waiting for the purge
+	deletedAfter := time.Now().Add(-config.UserDeletionGracePeriod)
+	if err := database.GetDB().RestoreUser(c.Request.Context(), id, deletedAfter); err != nil {
+		if err == database.ErrUserNotFound {
+			apierror.Write(c, http.StatusNotFound, "No deleted user with this ID")
+			return
+		}
+		apierror.Write(c, http.StatusInternalServerError, "Failed to restore user")
+		return
+	}
+
+	logging.Auth.WithContext(c.Request.Context()).WithFields(
 		"admin_user_id", adminUser.ID,
 		"username", adminUser.Username,
 		"target_user_id", id,
-	).Info("User deleted successfully")
+	).Info("User restored")
+	security.NewEvent(c, security.AdminAction, "restore_user").WithUser(adminUser).
+		WithTarget(strconv.FormatInt(id, 10)).Emit()
+	kubernetes.RequestWhitelistSync()
 
-	c.JSON(http.StatusOK, gin.H{"message": "User deleted"})
+	c.JSON(http.StatusOK, gin.H{"message": "User restored"})
 }
 
 // GrantUserPermissionsHandler grants permissions to a user (admin only).
@@ -502,87 +795,92 @@ func DeleteUserHandler(c *gin.Context) {
 // @Param        id        path      integer                 true  "User ID"
 // @Param        request   body      ModifyPermissionsRequest true  "Permissions to grant"
 // @Success      200       {object}  map[string]interface{}  "Updated user permissions"
-// @Failure      400       {object}  map[string]string       "Invalid request"
-// @Failure      401       {object}  map[string]string       "Authentication required"
-// @Failure      403       {object}  map[string]string       "Permission denied"
-// @Failure      404       {object}  map[string]string       "User not found"
-// @Failure      500       {object}  map[string]string       "Server error"
+// @Failure      400       {object}  apierror.Error          "Invalid request"
+// @Failure      401       {object}  apierror.Error          "Authentication required"
+// @Failure      403       {object}  apierror.Error          "Permission denied"
+// @Failure      404       {object}  apierror.Error          "User not found"
+// @Failure      500       {object}  apierror.Error          "Server error"
 // @Router       /users/{id}/permissions/grant [post]
 func GrantUserPermissionsHandler(c *gin.Context) {
 	// Get admin user
-	adminUser, _ := auth.GetCurrentUser(c)
+	adminUser, ok := auth.RequireCurrentUser(c)
+	if !ok {
+		return
+	}
 
 	// Get user ID
 	idStr := c.Param("id")
 	id, err := strconv.ParseInt(idStr, 10, 64)
 	if err != nil {
-		logging.API.InvalidRequest.WithFields(
+		logging.API.InvalidRequest.WithContext(c.Request.Context()).WithFields(
 			"admin_user_id", adminUser.ID,
 			"requested_id", idStr,
 			"error", "invalid_id_format",
 		).Warn("Invalid user ID format in grant permissions request")
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		apierror.Write(c, http.StatusBadRequest, "Invalid user ID")
 		return
 	}
 
 	// Parse request
 	var req ModifyPermissionsRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		logging.API.InvalidRequest.WithFields(
+		logging.API.InvalidRequest.WithContext(c.Request.Context()).WithFields(
 			"admin_user_id", adminUser.ID,
 			"target_user_id", id,
 			"error", err.Error(),
 		).Warn("Invalid permission grant request format")
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		apierror.Write(c, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	// Get target user
-	db := database.GetDB()
-	user, err := db.GetUserByID(c.Request.Context(), id)
+	// Get target user and save its permissions in one transaction, so that concurrent
+	// changes add up instead of overwriting each other
+	var user *database.User
+	var oldPermissions int64
+	err = database.GetDB().WithTx(c.Request.Context(), func(tx database.DB) error {
+		var err error
+		user, err = tx.GetUserByID(c.Request.Context(), id)
+		if err != nil {
+			return err
+		}
+
+		// Apply permissions
+		oldPermissions = user.Permissions
+		for _, perm := range req.Permissions {
+			user.SetDirectPermissions(user.DirectPermissions | perm.Permission)
+		}
+		return tx.UpdateUser(c.Request.Context(), user)
+	})
 	if err != nil {
 		if err == database.ErrUserNotFound {
-			logging.Auth.WithFields(
+			logging.Auth.WithContext(c.Request.Context()).WithFields(
 				"admin_user_id", adminUser.ID,
 				"target_user_id", id,
 				"error", "user_not_found",
 			).Warn("Permission grant failed: target user not found")
-			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			apierror.Write(c, http.StatusNotFound, "User not found")
 			return
 		}
-		logging.DB.WithFields(
-			"admin_user_id", adminUser.ID,
-			"target_user_id", id,
-			"error", err.Error(),
-		).Error("Database error when retrieving user for permission grant")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user"})
-		return
-	}
-
-	// Apply permissions
-	oldPermissions := user.Permissions
-	for _, perm := range req.Permissions {
-		user.Permissions |= perm.Permission
-	}
-
-	// Save updated permissions
-	if err := db.UpdateUser(c.Request.Context(), user); err != nil {
-		logging.DB.WithFields(
+		logging.DB.WithContext(c.Request.Context()).WithFields(
 			"admin_user_id", adminUser.ID,
 			"target_user_id", id,
 			"error", err.Error(),
 		).Error("Database error when updating user permissions")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
+		apierror.Write(c, http.StatusInternalServerError, "Failed to update user")
 		return
 	}
 
-	logging.Auth.WithFields(
+	logging.Auth.WithContext(c.Request.Context()).WithFields(
 		"admin_user_id", adminUser.ID,
 		"admin_username", adminUser.Username,
 		"target_user_id", id,
 		"old_permissions", oldPermissions,
 		"new_permissions", user.Permissions,
 	).Info("User permissions updated successfully")
+	security.NewEvent(c, security.AdminAction, "grant_permissions").WithUser(adminUser).WithTarget(user.Username).
+		WithDetail("old_permissions", strconv.FormatInt(oldPermissions, 10)).
+		WithDetail("new_permissions", strconv.FormatInt(user.Permissions, 10)).Emit()
+	kubernetes.RequestWhitelistSync()
 
 	c.JSON(http.StatusOK, gin.H{
 		"user_id":         user.ID,
@@ -603,87 +901,92 @@ func GrantUserPermissionsHandler(c *gin.Context) {
 // @Param        id        path      integer                 true  "User ID"
 // @Param        request   body      ModifyPermissionsRequest true  "Permissions to revoke"
 // @Success      200       {object}  map[string]interface{}  "Updated user permissions"
-// @Failure      400       {object}  map[string]string       "Invalid request"
-// @Failure      401       {object}  map[string]string       "Authentication required"
-// @Failure      403       {object}  map[string]string       "Permission denied"
-// @Failure      404       {object}  map[string]string       "User not found"
-// @Failure      500       {object}  map[string]string       "Server error"
+// @Failure      400       {object}  apierror.Error          "Invalid request"
+// @Failure      401       {object}  apierror.Error          "Authentication required"
+// @Failure      403       {object}  apierror.Error          "Permission denied"
+// @Failure      404       {object}  apierror.Error          "User not found"
+// @Failure      500       {object}  apierror.Error          "Server error"
 // @Router       /users/{id}/permissions/revoke [post]
 func RevokeUserPermissionsHandler(c *gin.Context) {
 	// Get admin user
-	adminUser, _ := auth.GetCurrentUser(c)
+	adminUser, ok := auth.RequireCurrentUser(c)
+	if !ok {
+		return
+	}
 
 	// Get user ID
 	idStr := c.Param("id")
 	id, err := strconv.ParseInt(idStr, 10, 64)
 	if err != nil {
-		logging.API.InvalidRequest.WithFields(
+		logging.API.InvalidRequest.WithContext(c.Request.Context()).WithFields(
 			"admin_user_id", adminUser.ID,
 			"requested_id", idStr,
 			"error", "invalid_id_format",
 		).Warn("Invalid user ID format in revoke permissions request")
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		apierror.Write(c, http.StatusBadRequest, "Invalid user ID")
 		return
 	}
 
 	// Parse request
 	var req ModifyPermissionsRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		logging.API.InvalidRequest.WithFields(
+		logging.API.InvalidRequest.WithContext(c.Request.Context()).WithFields(
 			"admin_user_id", adminUser.ID,
 			"target_user_id", id,
 			"error", err.Error(),
 		).Warn("Invalid permission revoke request format")
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		apierror.Write(c, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	// Get target user
-	db := database.GetDB()
-	user, err := db.GetUserByID(c.Request.Context(), id)
+	// Get target user and save its permissions in one transaction, so that concurrent
+	// changes add up instead of overwriting each other
+	var user *database.User
+	var oldPermissions int64
+	err = database.GetDB().WithTx(c.Request.Context(), func(tx database.DB) error {
+		var err error
+		user, err = tx.GetUserByID(c.Request.Context(), id)
+		if err != nil {
+			return err
+		}
+
+		// Revoke permissions, those granted by the roles of the user stay
+		oldPermissions = user.Permissions
+		for _, perm := range req.Permissions {
+			user.SetDirectPermissions(user.DirectPermissions &^ perm.Permission)
+		}
+		return tx.UpdateUser(c.Request.Context(), user)
+	})
 	if err != nil {
 		if err == database.ErrUserNotFound {
-			logging.Auth.WithFields(
+			logging.Auth.WithContext(c.Request.Context()).WithFields(
 				"admin_user_id", adminUser.ID,
 				"target_user_id", id,
 				"error", "user_not_found",
 			).Warn("Permission revoke failed: target user not found")
-			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			apierror.Write(c, http.StatusNotFound, "User not found")
 			return
 		}
-		logging.DB.WithFields(
-			"admin_user_id", adminUser.ID,
-			"target_user_id", id,
-			"error", err.Error(),
-		).Error("Database error when retrieving user for permission revoke")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user"})
-		return
-	}
-
-	// Revoke permissions
-	oldPermissions := user.Permissions
-	for _, perm := range req.Permissions {
-		user.Permissions &= ^perm.Permission
-	}
-
-	// Save updated permissions
-	if err := db.UpdateUser(c.Request.Context(), user); err != nil {
-		logging.DB.WithFields(
+		logging.DB.WithContext(c.Request.Context()).WithFields(
 			"admin_user_id", adminUser.ID,
 			"target_user_id", id,
 			"error", err.Error(),
 		).Error("Database error when updating user permissions")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
+		apierror.Write(c, http.StatusInternalServerError, "Failed to update user")
 		return
 	}
 
-	logging.Auth.WithFields(
+	logging.Auth.WithContext(c.Request.Context()).WithFields(
 		"admin_user_id", adminUser.ID,
 		"admin_username", adminUser.Username,
 		"target_user_id", id,
 		"old_permissions", oldPermissions,
 		"new_permissions", user.Permissions,
 	).Info("User permissions revoked successfully")
+	security.NewEvent(c, security.AdminAction, "revoke_permissions").WithUser(adminUser).WithTarget(user.Username).
+		WithDetail("old_permissions", strconv.FormatInt(oldPermissions, 10)).
+		WithDetail("new_permissions", strconv.FormatInt(user.Permissions, 10)).Emit()
+	kubernetes.RequestWhitelistSync()
 
 	c.JSON(http.StatusOK, gin.H{
 		"user_id":         user.ID,
@@ -701,25 +1004,9 @@ func RevokeUserPermissionsHandler(c *gin.Context) {
 // @Produce      json
 // @Security     BearerAuth
 // @Success      200  {object}  map[string]int64  "Permissions map"
-// @Failure      401  {object}  map[string]string "Authentication required"
+// @Failure      401  {object}  apierror.Error    "Authentication required"
 // @Router       /permissions [get]
 func GetPermissionsMapHandler(c *gin.Context) {
 	// Return a map of permission names to their values
-	permissionsMap := map[string]int64{
-		"PermAdmin":         database.PermAdmin,
-		"PermCreateServer":  database.PermCreateServer,
-		"PermDeleteServer":  database.PermDeleteServer,
-		"PermStartServer":   database.PermStartServer,
-		"PermStopServer":    database.PermStopServer,
-		"PermRestartServer": database.PermRestartServer,
-		"PermExecCommand":   database.PermExecCommand,
-		"PermViewServer":    database.PermViewServer,
-	}
-
-	// Add permissions for database access
-	permissionsMap["PermOperator"] = database.PermOperator
-	permissionsMap["PermAll"] = database.PermAll
-	permissionsMap["PermReadOnly"] = database.PermReadOnly
-
-	c.JSON(http.StatusOK, permissionsMap)
+	c.JSON(http.StatusOK, database.PermissionNames)
 }
@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"minecharts/cmd/config"
+	"minecharts/cmd/database"
+)
+
+func TestRestoreUserWithinTheGracePeriod(t *testing.T) {
+	tests := []struct {
+		name      string
+		deletedAt time.Duration // Before now
+		status    int
+	}{
+		{"within", config.UserDeletionGracePeriod - time.Hour, http.StatusOK},
+		{"past", config.UserDeletionGracePeriod + time.Hour, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := setupTest(t)
+			admin := createTestUser(t, db, "admin", database.PermAll)
+			user := createTestUser(t, db, "alice", database.PermReadOnly)
+			if err := db.SoftDeleteUser(context.Background(), user.ID, time.Now().Add(-tt.deletedAt)); err != nil {
+				t.Fatal(err)
+			}
+
+			path := fmt.Sprintf("/users/%d/restore", user.ID)
+			rec := serve(t, admin, http.MethodPost, "/users/:id/restore", path, nil, RestoreUserHandler)
+			expectStatus(t, rec, tt.status)
+
+			restored, err := db.GetUserByID(context.Background(), user.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if restored.Active != (tt.status == http.StatusOK) {
+				t.Errorf("active = %v after the restore answered %d", restored.Active, tt.status)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"minecharts/cmd/apierror"
+	"minecharts/cmd/config"
+	"minecharts/cmd/mcversions"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ListMinecraftVersionsHandler returns the Minecraft versions a server type can run,
+// for the version dropdowns of the creation wizards.
+//
+// The upstream manifests are fetched at most once every MINECHARTS_VERSION_MANIFEST_TTL
+// and shared by all users, so that browsers do not query Mojang or PaperMC themselves.
+//
+// @Summary      List Minecraft versions
+// @Description  Lists the versions of vanilla, paper or fabric servers, the most recent first, from the manifests of Mojang, PaperMC and Fabric cached by the API. With stable=true, only the releases are listed. When upstream is unreachable, the last manifest fetched is returned with stale set
+// @Tags         servers
+// @Produce      json
+// @Security     BearerAuth
+// @Security     APIKeyAuth
+// @Param        type    query     string                false  "Server type: vanilla, paper or fabric"  default(vanilla)
+// @Param        stable  query     bool                  false  "Only list the releases"
+// @Success      200     {object}  mcversions.Manifest   "Versions of the server type"
+// @Failure      400     {object}  apierror.Error        "Unknown server type"
+// @Failure      401     {object}  apierror.Error        "Authentication required"
+// @Failure      502     {object}  apierror.Error        "Upstream manifest unreachable"
+// @Router       /mc/versions [get]
+func ListMinecraftVersionsHandler(c *gin.Context) {
+	serverType := strings.ToLower(c.DefaultQuery("type", "vanilla"))
+	stableOnly, _ := strconv.ParseBool(c.Query("stable"))
+
+	manifest, err := mcversions.Get(c.Request.Context(), serverType)
+	if errors.Is(err, mcversions.ErrUnknownType) {
+		apierror.Write(c, http.StatusBadRequest, "type must be one of "+strings.Join(mcversions.Types(), ", "))
+		return
+	}
+	if err != nil {
+		apierror.Write(c, http.StatusBadGateway, "Failed to fetch the version manifest")
+		return
+	}
+
+	response := *manifest
+	if stableOnly {
+		response.Versions = []mcversions.Version{}
+		for _, version := range manifest.Versions {
+			if version.Stable {
+				response.Versions = append(response.Versions, version)
+			}
+		}
+	}
+
+	maxAge := 0
+	if !manifest.Stale {
+		maxAge = int(time.Until(manifest.FetchedAt.Add(config.VersionManifestTTL)).Round(time.Second).Seconds())
+	}
+	c.Header("Cache-Control", "private, max-age="+strconv.Itoa(max(maxAge, 0)))
+	c.JSON(http.StatusOK, response)
+}
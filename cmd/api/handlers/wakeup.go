@@ -0,0 +1,247 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"minecharts/cmd/api/middleware"
+	"minecharts/cmd/apierror"
+	"minecharts/cmd/config"
+	"minecharts/cmd/database"
+	"minecharts/cmd/kubernetes"
+	"minecharts/cmd/logging"
+
+	"github.com/gin-gonic/gin"
+)
+
+// WakeupRequest is the body of the wake-up webhook. It accepts the connection
+// notifications of mc-router, where server is the host name the player connected
+// to, or the name of the server to wake up.
+type WakeupRequest struct {
+	ServerName string `json:"serverName,omitempty" example:"survival"`
+	Server     string `json:"server,omitempty" example:"survival.example.com"`
+	Event      string `json:"event,omitempty" example:"connect"`
+	Status     string `json:"status,omitempty" example:"failed-backend-connection"`
+}
+
+// WakeupWebhookHandler scales a hibernated server back up when a player tries to
+// connect to it. Hibernated servers keep their service and its mc-router
+// annotation, so mc-router still routes the hostname and reports the failed
+// connection with its webhook; the player can join once the server has started.
+//
+// The caller authenticates with the token configured in MINECHARTS_WAKEUP_WEBHOOK_TOKEN,
+// in the X-Webhook-Token header or the token query parameter. When
+// MINECHARTS_WAKEUP_WEBHOOK_SECRET is set, deliveries must be signed instead: see
+// verifyWebhookSignature. Servers stopped by a user are left stopped.
+//
+// @Summary      Wake up hibernated server
+// @Description  Webhook for mc-router (or a connection sniffer): starts the hibernated server routed to the requested host name, or named by serverName
+// @Tags         servers
+// @Accept       json
+// @Produce      json
+// @Param        X-Webhook-Token  header    string         false  "Webhook token"
+// @Param        token            query     string         false  "Webhook token"
+// @Param        X-Webhook-Timestamp  header  string       false  "Unix time of a signed delivery"
+// @Param        X-Webhook-Signature  header  string       false  "sha256= and the HMAC-SHA256 of the timestamp, a dot and the body"
+// @Param        request          body      WakeupRequest  true   "Connection notification"
+// @Success      200              {object}  map[string]string  "Server running or waking up"
+// @Failure      400              {object}  apierror.Error     "Invalid request"
+// @Failure      401              {object}  apierror.Error     "Invalid webhook token or signature"
+// @Failure      409              {object}  apierror.Error     "Delivery already received"
+// @Failure      404              {object}  apierror.Error     "Webhook disabled or server not found"
+// @Failure      409              {object}  apierror.Error     "Server stopped by a user"
+// @Failure      500              {object}  apierror.Error     "Server error"
+// @Router       /webhooks/wakeup [post]
+func WakeupWebhookHandler(c *gin.Context) {
+	cfg := config.Live()
+	switch {
+	case cfg.WakeupWebhookSecret != "":
+		if err := verifyWebhookSignature(c, "wakeup", cfg.WakeupWebhookSecret); err != nil {
+			status := http.StatusInternalServerError
+			switch {
+			case errors.Is(err, errWebhookSignature):
+				status = http.StatusUnauthorized
+			case errors.Is(err, database.ErrNonceUsed):
+				status = http.StatusConflict
+			}
+			logging.API.WithContext(c.Request.Context()).WithFields(
+				"remote_ip", c.ClientIP(),
+				"error", err.Error(),
+			).Warn("Wake-up webhook delivery refused")
+			apierror.Write(c, status, err.Error())
+			return
+		}
+	case cfg.WakeupWebhookToken != "":
+		token := c.GetHeader("X-Webhook-Token")
+		if token == "" {
+			token = c.Query("token")
+		}
+		if subtle.ConstantTimeCompare([]byte(token), []byte(cfg.WakeupWebhookToken)) != 1 {
+			logging.API.WithContext(c.Request.Context()).WithFields(
+				"remote_ip", c.ClientIP(),
+			).Warn("Wake-up webhook called with an invalid token")
+			apierror.Write(c, http.StatusUnauthorized, "Invalid webhook token")
+			return
+		}
+	default:
+		apierror.Write(c, http.StatusNotFound, "Wake-up webhook is disabled")
+		return
+	}
+
+	var req WakeupRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Invalid(c, err)
+		return
+	}
+
+	// Connections that reached the server need no wake-up
+	if req.Status == "success" {
+		c.JSON(http.StatusOK, gin.H{"message": "Server is running"})
+		return
+	}
+
+	ctx := kubernetes.WithActor(c.Request.Context(), kubernetes.Actor{
+		Username:  "system:wakeup-webhook",
+		RequestID: middleware.GetRequestID(c),
+	})
+	var deploymentName string
+	switch {
+	case req.ServerName != "":
+		deploymentName = config.DeploymentPrefix + req.ServerName
+	case req.Server != "":
+		var err error
+		deploymentName, err = kubernetes.FindRoutedDeployment(ctx, kubernetes.WatchedNamespace(), routedHost(req.Server))
+		if err != nil {
+			apierror.Write(c, http.StatusInternalServerError, "Failed to look up routed servers: "+err.Error())
+			return
+		}
+	default:
+		apierror.Write(c, http.StatusBadRequest, "serverName or server is required")
+		return
+	}
+	if deploymentName == "" {
+		apierror.Write(c, http.StatusNotFound, "No server is routed to "+req.Server)
+		return
+	}
+	serverName := strings.TrimPrefix(deploymentName, config.DeploymentPrefix)
+
+	// Servers without a record are looked up in the default namespace
+	server, _ := database.GetDB().GetServerByName(ctx, serverName)
+	namespace := kubernetes.ServerNamespace(server)
+	deployment, err := kubernetes.GetDeployment(ctx, namespace, deploymentName)
+	if err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to get deployment: "+err.Error())
+		return
+	}
+	if deployment == nil {
+		apierror.Respond(c, apierror.New(http.StatusNotFound, apierror.CodeServerNotFound, "Server not found"))
+		return
+	}
+
+	if deployment.Spec.Replicas == nil || *deployment.Spec.Replicas > 0 {
+		c.JSON(http.StatusOK, gin.H{"message": "Server is running", "serverName": serverName})
+		return
+	}
+	if _, hibernated := deployment.Annotations[kubernetes.HibernatedAnnotation]; !hibernated {
+		apierror.Write(c, http.StatusConflict, "Server was stopped by a user, start it with the API")
+		return
+	}
+
+	if err := kubernetes.SetDeploymentReplicas(ctx, namespace, deploymentName, 1); err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to start deployment: "+err.Error())
+		return
+	}
+
+	logging.Server.Started.WithContext(ctx).WithFields(
+		"server_name", serverName,
+		"deployment", deploymentName,
+		"host", req.Server,
+		"remote_ip", c.ClientIP(),
+	).Info("Hibernated Minecraft server woken up on connection")
+
+	if err := database.GetDB().UpdateServerStatus(ctx, serverName, database.ServerStatusStarting, ""); err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"server_name", serverName,
+			"error", err.Error(),
+		).Warn("Failed to record server as starting")
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "Server waking up from hibernation (deployment scaled to 1)",
+		"serverName":     serverName,
+		"deploymentName": deploymentName,
+	})
+}
+
+// routedHost normalizes the server address sent by a client in its handshake:
+// Forge appends markers after a NUL byte and some clients keep the trailing dot.
+func routedHost(address string) string {
+	if i := strings.IndexByte(address, 0); i >= 0 {
+		address = address[:i]
+	}
+	return strings.TrimSuffix(strings.TrimSpace(address), ".")
+}
+
+// errWebhookSignature is returned for the webhook deliveries whose signature is
+// missing, stale or wrong.
+var errWebhookSignature = errors.New("invalid webhook signature")
+
+// maxWebhookBody is the largest webhook delivery read to check its signature.
+const maxWebhookBody = 64 << 10
+
+// verifyWebhookSignature checks that a webhook delivery is signed with the secret and
+// was not received before. The X-Webhook-Timestamp header holds the Unix time of the
+// delivery and X-Webhook-Signature "sha256=" followed by the hex HMAC-SHA256 of the
+// timestamp, a dot and the body. Deliveries older than the signature tolerance are
+// refused, and the newer ones are recorded in the database until they expire, so that
+// a delivery replayed to any replica is refused with database.ErrNonceUsed.
+func verifyWebhookSignature(c *gin.Context, webhook, secret string) error {
+	timestamp := c.GetHeader("X-Webhook-Timestamp")
+	signature, ok := strings.CutPrefix(c.GetHeader("X-Webhook-Signature"), "sha256=")
+	if timestamp == "" || !ok {
+		return fmt.Errorf("%w: the delivery is not signed", errWebhookSignature)
+	}
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: invalid timestamp", errWebhookSignature)
+	}
+	sentAt := time.Unix(unix, 0)
+	if age := time.Since(sentAt); age > config.WebhookSignatureTolerance || age < -config.WebhookSignatureTolerance {
+		return fmt.Errorf("%w: the timestamp is outside the tolerance", errWebhookSignature)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxWebhookBody))
+	if err != nil {
+		return fmt.Errorf("failed to read webhook delivery: %w", err)
+	}
+	// Bound later by the handler
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	expected := hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(strings.ToLower(signature)), []byte(expected)) {
+		return errWebhookSignature
+	}
+
+	// Past the tolerance the timestamp check refuses the delivery by itself
+	err = database.GetDB().CreateNonce(c.Request.Context(), "webhook:"+webhook, expected, "", sentAt.Add(config.WebhookSignatureTolerance))
+	if errors.Is(err, database.ErrNonceUsed) {
+		return fmt.Errorf("webhook delivery already received: %w", err)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to record webhook delivery: %w", err)
+	}
+	return nil
+}
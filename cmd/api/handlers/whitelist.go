@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"net/http"
+
+	"minecharts/cmd/apierror"
+	"minecharts/cmd/auth"
+	"minecharts/cmd/database"
+	"minecharts/cmd/kubernetes"
+	"minecharts/cmd/logging"
+
+	"github.com/gin-gonic/gin"
+)
+
+// WhitelistSyncRequest turns the whitelist sync of a server on or off.
+type WhitelistSyncRequest struct {
+	Enabled *bool `json:"enabled" binding:"required" example:"true"`
+}
+
+// SetWhitelistSyncHandler turns on or off the sync of a server whitelist with the
+// linked Minecraft accounts of the users who can view the server.
+//
+// @Summary      Set whitelist sync
+// @Description  With the sync on, the linked Minecraft accounts of the users who can view the server are added to its whitelist, and removed when they lose access, unlink their account or are deleted. Players whitelisted in game are left alone. The whitelist itself is enabled with ENABLE_WHITELIST
+// @Tags         players
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Security     APIKeyAuth
+// @Param        serverName  path      string                true  "Server name"
+// @Param        request     body      WhitelistSyncRequest  true  "Sync state"
+// @Success      200         {object}  map[string]interface{}  "Sync updated"
+// @Failure      400         {object}  apierror.Error          "Invalid request"
+// @Failure      401         {object}  apierror.Error          "Authentication required"
+// @Failure      403         {object}  apierror.Error          "Permission denied"
+// @Failure      404         {object}  apierror.Error          "Server not found"
+// @Failure      500         {object}  apierror.Error          "Server error"
+// @Router       /servers/{serverName}/whitelist/sync [put]
+func SetWhitelistSyncHandler(c *gin.Context) {
+	var req WhitelistSyncRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Invalid(c, err)
+		return
+	}
+
+	db := database.GetDB()
+	server, err := db.GetServerByName(c.Request.Context(), c.Param("serverName"))
+	if err != nil {
+		apierror.Respond(c, apierror.New(http.StatusNotFound, apierror.CodeServerNotFound, "Server not found"))
+		return
+	}
+	user, ok := auth.RequireCurrentUser(c)
+	if !ok {
+		return
+	}
+
+	spec := server.Spec
+	spec.SyncWhitelist = *req.Enabled
+	if err := db.UpdateServerSpec(c.Request.Context(), server.ServerName, spec); err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to update server")
+		return
+	}
+	if spec.SyncWhitelist {
+		kubernetes.RequestWhitelistSync()
+	}
+
+	logging.Server.WithContext(c.Request.Context()).WithFields(
+		"server_name", server.ServerName,
+		"sync_whitelist", spec.SyncWhitelist,
+		"user_id", user.ID,
+		"username", user.Username,
+	).Info("Server whitelist sync updated")
+
+	c.JSON(http.StatusOK, gin.H{
+		"serverName":    server.ServerName,
+		"syncWhitelist": spec.SyncWhitelist,
+	})
+}
@@ -0,0 +1,309 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"minecharts/cmd/apierror"
+	"minecharts/cmd/auth"
+	"minecharts/cmd/config"
+	"minecharts/cmd/database"
+	"minecharts/cmd/kubernetes"
+	"minecharts/cmd/logging"
+
+	"github.com/gin-gonic/gin"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// worldFormField is the multipart field holding the world archive.
+const worldFormField = "world"
+
+// UploadWorldHandler replaces the world of a server with the one of an uploaded archive.
+//
+// A running server is saved and stopped first, since the volume is only mounted by one pod
+// at a time, and started again once the world is imported, whatever the outcome. The
+// replaced world is kept in the backups directory of the server. Uploads of a named world
+// other than the active one go to AddWorldHandler instead.
+//
+// @Summary      Upload server world
+// @Description  Imports a world from a zip, tar or tar.gz archive sent as the world field of a multipart form. The archive must hold a level.dat, whose directory becomes the world of the server (the LEVEL environment variable, world by default). A running server is stopped during the import and started again afterwards; the replaced world is moved to /data/backups
+// @Tags         servers
+// @Accept       multipart/form-data
+// @Produce      json
+// @Security     BearerAuth
+// @Security     APIKeyAuth
+// @Param        serverName  path      string             true  "Server name"
+// @Param        world       formData  file               true  "World archive (zip, tar or tar.gz)"
+// @Success      200         {object}  map[string]interface{}  "World imported"
+// @Failure      400         {object}  apierror.Error     "Missing or invalid archive"
+// @Failure      401         {object}  apierror.Error     "Authentication required"
+// @Failure      403         {object}  apierror.Error     "Permission denied"
+// @Failure      404         {object}  apierror.Error     "Server not found"
+// @Failure      409         {object}  apierror.Error     "A world import is already running"
+// @Failure      413         {object}  apierror.Error     "Archive too large"
+// @Failure      500         {object}  apierror.Error     "Server error"
+// @Router       /servers/{serverName}/world [put]
+func UploadWorldHandler(c *gin.Context) {
+	serverName := c.Param("serverName")
+	user, ok := auth.RequireCurrentUser(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	server, err := database.GetDB().GetServerByName(ctx, serverName)
+	if err != nil {
+		apierror.Respond(c, apierror.New(http.StatusNotFound, apierror.CodeServerNotFound, "Server not found"))
+		return
+	}
+	namespace := kubernetes.ServerNamespace(server)
+	deployment, ok := kubernetes.CheckDeploymentExists(c, namespace, server.DeploymentName)
+	if !ok {
+		return
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, int64(config.WorldUploadMaxBytes))
+	reader, err := c.Request.MultipartReader()
+	if err != nil {
+		apierror.Write(c, http.StatusBadRequest, "Expected a multipart form with the world archive")
+		return
+	}
+
+	// The archive is streamed to the server volume, not buffered
+	var archive io.Reader
+	for archive == nil {
+		part, err := reader.NextPart()
+		if err != nil {
+			apierror.Write(c, http.StatusBadRequest, "Missing "+worldFormField+" file in the form")
+			return
+		}
+		if part.FormName() == worldFormField && part.FileName() != "" {
+			archive = part
+		}
+	}
+	peeker := bufio.NewReaderSize(archive, 64*1024)
+	format := worldArchiveFormat(peeker)
+	if format == "" {
+		apierror.Write(c, http.StatusBadRequest, "The world archive must be a zip, tar or tar.gz file")
+		return
+	}
+
+	level := kubernetes.ActiveWorld(deployment)
+	if world := c.Param("worldName"); world != "" && world != level {
+		addWorld(c, user, server, world, format, peeker)
+		return
+	}
+
+	logging.Server.WithContext(ctx).WithFields(
+		"server_name", serverName,
+		"deployment", server.DeploymentName,
+		"format", format,
+		"level", level,
+		"user_id", user.ID,
+		"username", user.Username,
+	).Info("Importing world into Minecraft server")
+
+	// The server is stopped while its volume is used by the import
+	running := deployment.Spec.Replicas == nil || *deployment.Spec.Replicas > 0
+	if running {
+		pod, err := kubernetes.GetMinecraftPod(ctx, namespace, server.DeploymentName)
+		if err != nil {
+			apierror.Write(c, http.StatusInternalServerError, "Failed to find pod for deployment: "+server.DeploymentName)
+			return
+		}
+		// Saved so the replaced world kept in backups is complete
+		if pod != nil && pod.Status.Phase == corev1.PodRunning {
+			if _, _, err := kubernetes.SaveWorld(ctx, pod.Name, namespace); err != nil {
+				apierror.Write(c, http.StatusInternalServerError, "Failed to save world: "+err.Error())
+				return
+			}
+		}
+		if err := kubernetes.SetDeploymentReplicas(ctx, namespace, server.DeploymentName, 0); err != nil {
+			apierror.Write(c, http.StatusInternalServerError, "Failed to stop server: "+err.Error())
+			return
+		}
+	}
+
+	previous, importErr := kubernetes.ImportWorld(ctx, namespace, server.DeploymentName, server.PVCName, level, format, peeker)
+
+	if running {
+		if err := kubernetes.SetDeploymentReplicas(ctx, namespace, server.DeploymentName, int32(config.DefaultReplicas)); err != nil {
+			logging.Server.WithContext(ctx).WithFields(
+				"server_name", serverName,
+				"deployment", server.DeploymentName,
+				"error", err.Error(),
+			).Error("Failed to start server after world import")
+			if importErr == nil {
+				apierror.Write(c, http.StatusInternalServerError, "World imported, but failed to start server: "+err.Error())
+				return
+			}
+		}
+	}
+
+	if importErr != nil {
+		logging.Server.WithContext(ctx).WithFields(
+			"server_name", serverName,
+			"deployment", server.DeploymentName,
+			"error", importErr.Error(),
+		).Warn("World import failed")
+
+		var maxBytesErr *http.MaxBytesError
+		switch {
+		case errors.As(importErr, &maxBytesErr):
+			apierror.Write(c, http.StatusRequestEntityTooLarge, "The world archive is larger than the upload limit")
+		case errors.Is(importErr, kubernetes.ErrInvalidWorld):
+			apierror.Write(c, http.StatusBadRequest, "The archive holds no world: "+importErr.Error())
+		case errors.Is(importErr, kubernetes.ErrWorldImportRunning):
+			apierror.Write(c, http.StatusConflict, importErr.Error())
+		default:
+			apierror.Write(c, http.StatusInternalServerError, "Failed to import world: "+importErr.Error())
+		}
+		return
+	}
+
+	logging.Server.WithContext(ctx).WithFields(
+		"server_name", serverName,
+		"deployment", server.DeploymentName,
+		"level", level,
+		"previous_world", previous,
+		"user_id", user.ID,
+		"username", user.Username,
+	).Info("World imported into Minecraft server")
+
+	response := gin.H{
+		"message":    "World imported",
+		"serverName": serverName,
+		"level":      level,
+		"restarted":  running,
+	}
+	if previous != "" {
+		response["previousWorld"] = previous
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// ExportWorldHandler streams a tar.gz archive of the world of a server.
+//
+// The archive is packaged in the server pod, or in a helper pod mounting the volume
+// of a stopped server, and sent as it is written, without being stored.
+//
+// @Summary      Export server world
+// @Description  Streams a tar.gz archive of the world of the server: the directories of its level (the LEVEL environment variable, world by default) and of its nether and end dimensions. The world of a running server is saved first, and automatic saves are paused during the export
+// @Tags         servers
+// @Produce      application/gzip
+// @Security     BearerAuth
+// @Security     APIKeyAuth
+// @Param        serverName  path      string             true  "Server name"
+// @Success      200         {file}    file               "World archive"
+// @Failure      401         {object}  apierror.Error     "Authentication required"
+// @Failure      403         {object}  apierror.Error     "Permission denied"
+// @Failure      404         {object}  apierror.Error     "Server or world not found"
+// @Failure      409         {object}  apierror.Error     "The server is starting, or another export is running"
+// @Failure      500         {object}  apierror.Error     "Server error"
+// @Router       /servers/{serverName}/world/export [get]
+func ExportWorldHandler(c *gin.Context) {
+	serverName := c.Param("serverName")
+	user, ok := auth.RequireCurrentUser(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	server, err := database.GetDB().GetServerByName(ctx, serverName)
+	if err != nil {
+		apierror.Respond(c, apierror.New(http.StatusNotFound, apierror.CodeServerNotFound, "Server not found"))
+		return
+	}
+	namespace := kubernetes.ServerNamespace(server)
+	deployment, ok := kubernetes.CheckDeploymentExists(c, namespace, server.DeploymentName)
+	if !ok {
+		return
+	}
+
+	level := kubernetes.ActiveWorld(deployment)
+
+	logging.Server.WithContext(ctx).WithFields(
+		"server_name", serverName,
+		"deployment", server.DeploymentName,
+		"level", level,
+		"user_id", user.ID,
+		"username", user.Username,
+	).Info("Exporting world of Minecraft server")
+
+	archive := &attachmentWriter{
+		c:        c,
+		filename: fmt.Sprintf("%s-%s-%s.tar.gz", serverName, level, time.Now().UTC().Format("20060102-150405")),
+	}
+	err = kubernetes.ExportWorld(ctx, namespace, server.DeploymentName, server.PVCName, level, archive)
+	if err != nil {
+		logging.Server.WithContext(ctx).WithFields(
+			"server_name", serverName,
+			"deployment", server.DeploymentName,
+			"error", err.Error(),
+		).Warn("World export failed")
+
+		// The archive is cut short, the client sees an incomplete download
+		if archive.written {
+			c.Abort()
+			return
+		}
+		switch {
+		case errors.Is(err, kubernetes.ErrWorldNotFound):
+			apierror.Write(c, http.StatusNotFound, err.Error())
+		case errors.Is(err, kubernetes.ErrServerStarting), errors.Is(err, kubernetes.ErrWorldExportRunning):
+			apierror.Write(c, http.StatusConflict, err.Error())
+		default:
+			apierror.Write(c, http.StatusInternalServerError, "Failed to export world: "+err.Error())
+		}
+		return
+	}
+
+	logging.Server.WithContext(ctx).WithFields(
+		"server_name", serverName,
+		"deployment", server.DeploymentName,
+		"level", level,
+		"user_id", user.ID,
+		"username", user.Username,
+	).Info("World of Minecraft server exported")
+}
+
+// attachmentWriter sends the headers of a file download on its first write, so that
+// errors found before anything is written can still be answered with JSON. Each write
+// is flushed, the download is sent as it is packaged.
+type attachmentWriter struct {
+	c        *gin.Context
+	filename string
+	written  bool
+}
+
+func (w *attachmentWriter) Write(data []byte) (int, error) {
+	if !w.written {
+		w.written = true
+		w.c.Header("Content-Type", "application/gzip")
+		w.c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", w.filename))
+		w.c.Status(http.StatusOK)
+	}
+	n, err := w.c.Writer.Write(data)
+	w.c.Writer.Flush()
+	return n, err
+}
+
+// worldArchiveFormat detects the format of an archive from its first bytes, or returns
+// an empty string when it is neither a zip, a tar nor a gzipped file.
+func worldArchiveFormat(archive *bufio.Reader) string {
+	head, _ := archive.Peek(512)
+	switch {
+	case bytes.HasPrefix(head, []byte("PK\x03\x04")):
+		return kubernetes.WorldArchiveZip
+	case bytes.HasPrefix(head, []byte{0x1f, 0x8b}):
+		return kubernetes.WorldArchiveTarGz
+	case len(head) >= 262 && bytes.HasPrefix(head[257:], []byte("ustar")):
+		return kubernetes.WorldArchiveTar
+	}
+	return ""
+}
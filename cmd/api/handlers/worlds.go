@@ -0,0 +1,283 @@
+package handlers
+
+import (
+	"bufio"
+	"errors"
+	"maps"
+	"net/http"
+
+	"minecharts/cmd/apierror"
+	"minecharts/cmd/auth"
+	"minecharts/cmd/database"
+	"minecharts/cmd/kubernetes"
+	"minecharts/cmd/logging"
+
+	"github.com/gin-gonic/gin"
+	appsv1 "k8s.io/api/apps/v1"
+)
+
+// WorldInfo is a world of a server volume.
+type WorldInfo struct {
+	Name   string `json:"name" example:"skywars"`
+	Active bool   `json:"active"` // Played by the server, its LEVEL
+}
+
+// ListWorldsHandler lists the worlds of a server.
+//
+// @Summary      List server worlds
+// @Description  Lists the worlds of the server volume, the directories holding a level.dat, and which one the server plays. The nether and end dimensions kept apart by some server types are not listed
+// @Tags         servers
+// @Produce      json
+// @Security     BearerAuth
+// @Security     APIKeyAuth
+// @Param        serverName  path      string             true  "Server name"
+// @Success      200         {object}  map[string]interface{}  "Worlds of the server"
+// @Failure      400         {object}  apierror.Error     "Bedrock server"
+// @Failure      401         {object}  apierror.Error     "Authentication required"
+// @Failure      403         {object}  apierror.Error     "Permission denied"
+// @Failure      404         {object}  apierror.Error     "Server not found"
+// @Failure      409         {object}  apierror.Error     "The server is starting"
+// @Failure      500         {object}  apierror.Error     "Server error"
+// @Router       /servers/{serverName}/worlds [get]
+func ListWorldsHandler(c *gin.Context) {
+	server, deployment, ok := loadWorldServer(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	namespace := kubernetes.ServerNamespace(server)
+	names, err := kubernetes.ListWorlds(ctx, namespace, server.DeploymentName, server.PVCName)
+	if err != nil {
+		worldFilesError(c, err, "Failed to list worlds")
+		return
+	}
+
+	active := kubernetes.ActiveWorld(deployment)
+	worlds := make([]WorldInfo, 0, len(names))
+	for _, name := range names {
+		worlds = append(worlds, WorldInfo{Name: name, Active: name == active})
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"serverName": server.ServerName,
+		"active":     active,
+		"worlds":     worlds,
+	})
+}
+
+// AddWorldHandler uploads a world under a name, next to the one the server plays.
+//
+// @Summary      Upload a named server world
+// @Description  Imports a world from a zip, tar or tar.gz archive sent as the world field of a multipart form, as the world of the name given. A running server keeps running, and plays the world once switched to it; uploading the active world replaces it as PUT /servers/{serverName}/world does, stopping the server meanwhile. A replaced world is moved to /data/backups
+// @Tags         servers
+// @Accept       multipart/form-data
+// @Produce      json
+// @Security     BearerAuth
+// @Security     APIKeyAuth
+// @Param        serverName  path      string             true  "Server name"
+// @Param        worldName   path      string             true  "World name"
+// @Param        world       formData  file               true  "World archive (zip, tar or tar.gz)"
+// @Success      200         {object}  map[string]interface{}  "World imported"
+// @Failure      400         {object}  apierror.Error     "Invalid world name, missing or invalid archive"
+// @Failure      401         {object}  apierror.Error     "Authentication required"
+// @Failure      403         {object}  apierror.Error     "Permission denied"
+// @Failure      404         {object}  apierror.Error     "Server not found"
+// @Failure      409         {object}  apierror.Error     "A world import is already running"
+// @Failure      413         {object}  apierror.Error     "Archive too large"
+// @Failure      500         {object}  apierror.Error     "Server error"
+// @Router       /servers/{serverName}/worlds/{worldName} [put]
+func AddWorldHandler(c *gin.Context) {
+	if err := validateWorldName(c.Param("worldName")); err != nil {
+		apierror.Write(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	server, err := database.GetDB().GetServerByName(c.Request.Context(), c.Param("serverName"))
+	if err == nil && isBedrock(server.Spec) {
+		apierror.Write(c, http.StatusBadRequest, "Bedrock servers keep a single world")
+		return
+	}
+	UploadWorldHandler(c)
+}
+
+// addWorld imports the archive of a world other than the active one of a server.
+func addWorld(c *gin.Context, user *database.User, server *database.MinecraftServer, world, format string, archive *bufio.Reader) {
+	logging.Server.WithContext(c.Request.Context()).WithFields(
+		"server_name", server.ServerName,
+		"deployment", server.DeploymentName,
+		"format", format,
+		"world", world,
+		"user_id", user.ID,
+		"username", user.Username,
+	).Info("Adding world to Minecraft server")
+
+	namespace := kubernetes.ServerNamespace(server)
+	previous, err := kubernetes.AddWorld(c.Request.Context(), namespace, server.DeploymentName, server.PVCName, world, format, archive)
+	if err != nil {
+		logging.Server.WithContext(c.Request.Context()).WithFields(
+			"server_name", server.ServerName,
+			"world", world,
+			"error", err.Error(),
+		).Warn("World upload failed")
+
+		var maxBytesErr *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxBytesErr):
+			apierror.Write(c, http.StatusRequestEntityTooLarge, "The world archive is larger than the upload limit")
+		case errors.Is(err, kubernetes.ErrInvalidWorld):
+			apierror.Write(c, http.StatusBadRequest, "The archive holds no world: "+err.Error())
+		case errors.Is(err, kubernetes.ErrWorldImportRunning), errors.Is(err, kubernetes.ErrServerStarting):
+			apierror.Write(c, http.StatusConflict, err.Error())
+		default:
+			apierror.Write(c, http.StatusInternalServerError, "Failed to import world: "+err.Error())
+		}
+		return
+	}
+
+	response := gin.H{
+		"message":    "World imported",
+		"serverName": server.ServerName,
+		"level":      world,
+		"restarted":  false,
+	}
+	if previous != "" {
+		response["previousWorld"] = previous
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// SwitchWorldHandler makes a server play another of its worlds.
+//
+// @Summary      Switch server world
+// @Description  Sets the LEVEL of the server to one of the worlds of its volume. A running server is restarted on the world, saving the previous one as it stops; a stopped server plays it once started
+// @Tags         servers
+// @Produce      json
+// @Security     BearerAuth
+// @Security     APIKeyAuth
+// @Param        serverName  path      string             true  "Server name"
+// @Param        worldName   path      string             true  "World name"
+// @Success      200         {object}  map[string]interface{}  "World switched"
+// @Failure      400         {object}  apierror.Error     "Bedrock server"
+// @Failure      401         {object}  apierror.Error     "Authentication required"
+// @Failure      403         {object}  apierror.Error     "Permission denied"
+// @Failure      404         {object}  apierror.Error     "Server or world not found"
+// @Failure      409         {object}  apierror.Error     "The world is already active, or the server is starting"
+// @Failure      500         {object}  apierror.Error     "Server error"
+// @Router       /servers/{serverName}/worlds/{worldName}/activate [post]
+func SwitchWorldHandler(c *gin.Context) {
+	user, ok := auth.RequireCurrentUser(c)
+	if !ok {
+		return
+	}
+
+	server, deployment, ok := loadWorldServer(c)
+	if !ok {
+		return
+	}
+	world := c.Param("worldName")
+	previous := kubernetes.ActiveWorld(deployment)
+	if world == previous {
+		apierror.Write(c, http.StatusConflict, "The server already plays world "+world)
+		return
+	}
+
+	ctx := c.Request.Context()
+	namespace := kubernetes.ServerNamespace(server)
+	names, err := kubernetes.ListWorlds(ctx, namespace, server.DeploymentName, server.PVCName)
+	if err != nil {
+		worldFilesError(c, err, "Failed to list worlds")
+		return
+	}
+	found := false
+	for _, name := range names {
+		found = found || name == world
+	}
+	if !found {
+		apierror.Write(c, http.StatusNotFound, "World not found")
+		return
+	}
+
+	// Changing the pod template restarts a running server, its pre-stop hook saving the world
+	env := withEnvVar(serverContainerEnv(deployment.Spec.Template.Spec.Containers), "LEVEL", world)
+	if err := kubernetes.UpdateDeployment(ctx, namespace, server.DeploymentName, env); err != nil {
+		apierror.Write(c, http.StatusInternalServerError, "Failed to update deployment: "+err.Error())
+		return
+	}
+
+	// The record follows the deployment, so that later changes keep the world
+	spec := server.Spec
+	spec.Env = maps.Clone(spec.Env)
+	if spec.Env == nil {
+		spec.Env = map[string]string{}
+	}
+	spec.Env["LEVEL"] = world
+	if err := database.GetDB().UpdateServerSpec(ctx, server.ServerName, spec); err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"server_name", server.ServerName,
+			"error", err.Error(),
+		).Warn("Failed to record switched server world")
+	}
+
+	logging.Server.WithContext(ctx).WithFields(
+		"server_name", server.ServerName,
+		"deployment", server.DeploymentName,
+		"previous_world", previous,
+		"world", world,
+		"user_id", user.ID,
+		"username", user.Username,
+	).Info("Minecraft server world switched")
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":       "World switched",
+		"serverName":    server.ServerName,
+		"level":         world,
+		"previousLevel": previous,
+		"restarted":     deployment.Spec.Replicas == nil || *deployment.Spec.Replicas > 0,
+	})
+}
+
+// reservedWorldNames are the directories of the server volume that cannot be uploaded
+// as worlds, since the upload would move them to the backups.
+var reservedWorldNames = map[string]bool{
+	"backups": true, "config": true, "logs": true, "plugins": true, "mods": true,
+	"libraries": true, "versions": true, "cache": true, "crash-reports": true, "datapacks": true,
+}
+
+// validateWorldName checks that a world name is a directory name at the root of the
+// server volume that is not used by the server itself.
+func validateWorldName(name string) error {
+	if !kubernetes.ValidWorldName(name) {
+		return errors.New("world name must consist of letters, digits, '_', '.' and '-', and start with a letter or digit")
+	}
+	if reservedWorldNames[name] {
+		return errors.New("world name " + name + " is a directory of the server")
+	}
+	return nil
+}
+
+// loadWorldServer returns the Java server of the request with its deployment.
+func loadWorldServer(c *gin.Context) (*database.MinecraftServer, *appsv1.Deployment, bool) {
+	server, err := database.GetDB().GetServerByName(c.Request.Context(), c.Param("serverName"))
+	if err != nil {
+		apierror.Respond(c, apierror.New(http.StatusNotFound, apierror.CodeServerNotFound, "Server not found"))
+		return nil, nil, false
+	}
+	if isBedrock(server.Spec) {
+		apierror.Write(c, http.StatusBadRequest, "Bedrock servers keep a single world")
+		return nil, nil, false
+	}
+	namespace := kubernetes.ServerNamespace(server)
+	deployment, ok := kubernetes.CheckDeploymentExists(c, namespace, server.DeploymentName)
+	if !ok {
+		return nil, nil, false
+	}
+	return server, deployment, true
+}
+
+// worldFilesError reports a failure to read the worlds of a server volume.
+func worldFilesError(c *gin.Context, err error, message string) {
+	if errors.Is(err, kubernetes.ErrServerStarting) || errors.Is(err, kubernetes.ErrServerFilesBusy) {
+		apierror.Write(c, http.StatusConflict, err.Error())
+		return
+	}
+	apierror.Write(c, http.StatusInternalServerError, message+": "+err.Error())
+}
@@ -0,0 +1,23 @@
+package middleware
+
+import (
+	"minecharts/cmd/auth"
+	"minecharts/cmd/kubernetes"
+
+	"github.com/gin-gonic/gin"
+)
+
+// KubernetesActor records the authenticated user and the request ID in the request
+// context, so the Kubernetes objects changed by the request are annotated with them.
+// It must run after the authentication middlewares.
+func KubernetesActor() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		actor := kubernetes.Actor{RequestID: GetRequestID(c)}
+		if user, ok := auth.GetCurrentUser(c); ok {
+			actor.Username = user.Username
+			actor.UserID = user.ID
+		}
+		c.Request = c.Request.WithContext(kubernetes.WithActor(c.Request.Context(), actor))
+		c.Next()
+	}
+}
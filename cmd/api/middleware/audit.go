@@ -0,0 +1,159 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"minecharts/cmd/auth"
+	"minecharts/cmd/database"
+	"minecharts/cmd/events"
+	"minecharts/cmd/logging"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// maxAuditedBody is the size of the largest JSON body whose fields are summarized
+	// in the audit log; larger bodies, such as uploads, are only noted by their size.
+	maxAuditedBody = 64 << 10
+	// auditWriteTimeout bounds the recording of an audit event once the request is done.
+	auditWriteTimeout = 5 * time.Second
+)
+
+// recordAuditEvents registers, once, the handler writing the API calls of the event
+// bus to the audit log.
+var recordAuditEvents sync.Once
+
+// Audit publishes every state-changing API call on the event bus, with the user who
+// made it, the server or user it targets, the names of the fields it sent and how
+// it ended, and records them in the audit log. Calls matching no route are not
+// published. It must run before Recovery, so that failed calls are published with
+// the status they were answered with.
+func Audit() gin.HandlerFunc {
+	recordAuditEvents.Do(func() {
+		events.Handle(recordAuditEvent, events.APICall)
+	})
+
+	return func(c *gin.Context) {
+		switch c.Request.Method {
+		case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		default:
+			c.Next()
+			return
+		}
+		route := c.FullPath()
+		if route == "" {
+			c.Next()
+			return
+		}
+
+		summary := summarizeRequest(c)
+		c.Next()
+
+		status := c.Writer.Status()
+		event := &database.AuditEvent{
+			Action:       c.Request.Method + " " + route,
+			Path:         c.Request.URL.Path,
+			TargetServer: c.Param("serverName"),
+			Summary:      summary,
+			Status:       status,
+			Result:       auditResult(status),
+			RemoteIP:     c.ClientIP(),
+			RequestID:    GetRequestID(c),
+		}
+		if strings.HasPrefix(route, "/users/:id") {
+			event.TargetUserID, _ = strconv.ParseInt(c.Param("id"), 10, 64)
+		}
+		if user, ok := auth.GetCurrentUser(c); ok {
+			event.UserID = user.ID
+			event.Username = user.Username
+		}
+
+		// The request context may be cancelled by now, the event is recorded anyway
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(c.Request.Context()), auditWriteTimeout)
+		defer cancel()
+		events.Publish(ctx, events.Event{
+			Type:   events.APICall,
+			Server: event.TargetServer,
+			Data:   event,
+		})
+	}
+}
+
+// recordAuditEvent writes an API call published on the bus to the audit log.
+func recordAuditEvent(ctx context.Context, published events.Event) {
+	event, ok := published.Data.(*database.AuditEvent)
+	if !ok {
+		return
+	}
+	if err := database.GetDB().CreateAuditEvent(ctx, event); err != nil {
+		logging.API.WithContext(ctx).WithFields(
+			"action", event.Action,
+			"request_id", event.RequestID,
+			"error", err.Error(),
+		).Error("Failed to record audit event")
+	}
+}
+
+// auditResult tells how a call ended from the status it was answered with.
+func auditResult(status int) string {
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return database.AuditResultDenied
+	case status >= http.StatusBadRequest:
+		return database.AuditResultFailed
+	}
+	return database.AuditResultSuccess
+}
+
+// summarizeRequest lists the names of the query parameters and of the top-level
+// fields of the JSON body of a request, leaving their values out of the audit log
+// as they may hold passwords or tokens. The body is restored for the handler.
+func summarizeRequest(c *gin.Context) string {
+	var parts []string
+	if query := c.Request.URL.Query(); len(query) > 0 {
+		parts = append(parts, "query: "+strings.Join(sortedKeys(query), ", "))
+	}
+
+	body := c.Request.Body
+	switch {
+	case body == nil || body == http.NoBody || c.Request.ContentLength == 0:
+	case c.ContentType() != "application/json" || c.Request.ContentLength > maxAuditedBody:
+		if c.Request.ContentLength > 0 {
+			parts = append(parts, "body: "+strconv.FormatInt(c.Request.ContentLength, 10)+" bytes of "+c.ContentType())
+		} else {
+			parts = append(parts, "body: "+c.ContentType())
+		}
+	default:
+		data, err := io.ReadAll(io.LimitReader(body, maxAuditedBody+1))
+		c.Request.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(data), body), body}
+		if err != nil {
+			break
+		}
+		var fields map[string]json.RawMessage
+		if json.Unmarshal(data, &fields) == nil && len(fields) > 0 {
+			parts = append(parts, "body: "+strings.Join(sortedKeys(fields), ", "))
+		}
+	}
+	return strings.Join(parts, "; ")
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
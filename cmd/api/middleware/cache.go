@@ -0,0 +1,219 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"minecharts/cmd/logging"
+
+	"github.com/gin-gonic/gin"
+)
+
+// cachedResponse is a response kept by the response cache.
+type cachedResponse struct {
+	contentType string
+	body        []byte
+	etag        string
+	expiresAt   time.Time
+}
+
+// maxCachedResponses bounds the responses kept in a cache group, one per combination
+// of the query parameters the route reads.
+const maxCachedResponses = 256
+
+// responseCache holds the cached responses, keyed by cache group and by the path and
+// the query parameters the route reads.
+var responseCache = struct {
+	sync.RWMutex
+	entries map[string]map[string]*cachedResponse
+}{entries: map[string]map[string]*cachedResponse{}}
+
+// CacheResponse serves the successful GET responses of a route from memory for ttl,
+// with Cache-Control and ETag headers so that clients revalidate them cheaply. It is
+// meant for routes whose response is the same for every authenticated user; the
+// responses are kept in the named group, emptied by InvalidateCache. A ttl of zero
+// or less disables the cache. The responses are told apart by the given query
+// parameters only, the others being ignored by the route.
+func CacheResponse(group string, ttl time.Duration, params ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if ttl <= 0 || c.Request.Method != http.MethodGet {
+			c.Next()
+			return
+		}
+
+		key := cacheKey(c.Request.URL, params)
+		responseCache.RLock()
+		entry := responseCache.entries[group][key]
+		responseCache.RUnlock()
+		if entry != nil {
+			if time.Now().Before(entry.expiresAt) {
+				writeCachedResponse(c, entry)
+				c.Abort()
+				return
+			}
+			responseCache.Lock()
+			if responseCache.entries[group][key] == entry {
+				delete(responseCache.entries[group], key)
+			}
+			responseCache.Unlock()
+		}
+
+		// Buffered, so the first response carries its ETag too
+		writer := &recordingWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+		c.Next()
+		c.Writer = writer.ResponseWriter
+
+		if !writer.Written() {
+			return
+		}
+		if writer.Status() != http.StatusOK {
+			c.Writer.WriteHeader(writer.Status())
+			_, _ = c.Writer.Write(writer.body.Bytes())
+			return
+		}
+		entry = &cachedResponse{
+			contentType: c.Writer.Header().Get("Content-Type"),
+			body:        writer.body.Bytes(),
+			etag:        responseETag(writer.body.Bytes()),
+			expiresAt:   time.Now().Add(ttl),
+		}
+		responseCache.Lock()
+		storeCachedResponse(group, key, entry)
+		responseCache.Unlock()
+		writeCachedResponse(c, entry)
+	}
+}
+
+// cacheKey identifies the response to a request by its path and the values of the
+// query parameters the route reads.
+func cacheKey(u *url.URL, params []string) string {
+	query := u.Query()
+	kept := url.Values{}
+	for _, param := range params {
+		if values, ok := query[param]; ok {
+			kept[param] = values
+		}
+	}
+	if len(kept) == 0 {
+		return u.Path
+	}
+	return u.Path + "?" + kept.Encode()
+}
+
+// storeCachedResponse keeps a response in a group, first dropping the expired ones
+// and, when the group is still full, the one expiring first. The caller holds the
+// write lock.
+func storeCachedResponse(group, key string, entry *cachedResponse) {
+	entries := responseCache.entries[group]
+	if entries == nil {
+		entries = map[string]*cachedResponse{}
+		responseCache.entries[group] = entries
+	}
+	if _, ok := entries[key]; !ok && len(entries) >= maxCachedResponses {
+		now := time.Now()
+		oldest := ""
+		for k, e := range entries {
+			if !now.Before(e.expiresAt) {
+				delete(entries, k)
+			} else if oldest == "" || e.expiresAt.Before(entries[oldest].expiresAt) {
+				oldest = k
+			}
+		}
+		if len(entries) >= maxCachedResponses {
+			delete(entries, oldest)
+		}
+	}
+	entries[key] = entry
+}
+
+// InvalidateCache drops the cached responses of the given groups, or of all of them
+// when none is given. It returns the number of responses dropped.
+func InvalidateCache(groups ...string) int {
+	responseCache.Lock()
+	defer responseCache.Unlock()
+
+	if len(groups) == 0 {
+		for group := range responseCache.entries {
+			groups = append(groups, group)
+		}
+	}
+	dropped := 0
+	for _, group := range groups {
+		dropped += len(responseCache.entries[group])
+		delete(responseCache.entries, group)
+	}
+
+	logging.API.WithFields(
+		"groups", groups,
+		"dropped", dropped,
+	).Debug("Response cache invalidated")
+	return dropped
+}
+
+// writeCachedResponse answers with a cached response, or with 304 Not Modified when
+// the client already has it.
+func writeCachedResponse(c *gin.Context, entry *cachedResponse) {
+	maxAge := int(time.Until(entry.expiresAt).Round(time.Second).Seconds())
+	// Private, the routes need authentication
+	c.Header("Cache-Control", "private, max-age="+strconv.Itoa(maxAge))
+	c.Header("ETag", entry.etag)
+	if c.GetHeader("If-None-Match") == entry.etag {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	c.Data(http.StatusOK, entry.contentType, entry.body)
+}
+
+func responseETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// recordingWriter holds back the response written by a handler.
+type recordingWriter struct {
+	gin.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *recordingWriter) WriteHeader(code int) {
+	w.status = code
+}
+
+func (w *recordingWriter) WriteHeaderNow() {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+}
+
+func (w *recordingWriter) Write(data []byte) (int, error) {
+	w.WriteHeaderNow()
+	return w.body.Write(data)
+}
+
+func (w *recordingWriter) WriteString(s string) (int, error) {
+	w.WriteHeaderNow()
+	return w.body.WriteString(s)
+}
+
+func (w *recordingWriter) Status() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+func (w *recordingWriter) Size() int {
+	return w.body.Len()
+}
+
+func (w *recordingWriter) Written() bool {
+	return w.status != 0
+}
@@ -0,0 +1,86 @@
+package middleware
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"minecharts/cmd/logging"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestMain(m *testing.M) {
+	logging.Init()
+	logging.Logger.SetOutput(io.Discard)
+	gin.SetMode(gin.TestMode)
+	os.Exit(m.Run())
+}
+
+// cachedEntries returns the number of responses kept in a group.
+func cachedEntries(group string) int {
+	responseCache.RLock()
+	defer responseCache.RUnlock()
+	return len(responseCache.entries[group])
+}
+
+func TestCacheResponseKeysOnTheReadParameters(t *testing.T) {
+	t.Cleanup(func() { InvalidateCache("test") })
+	calls := 0
+	router := gin.New()
+	router.GET("/templates", CacheResponse("test", time.Minute, "category"), func(c *gin.Context) {
+		calls++
+		c.String(http.StatusOK, c.Query("category"))
+	})
+
+	for _, uri := range []string{"/templates", "/templates?x=1", "/templates?x=2", "/templates?category=modded", "/templates?category=modded&x=3"} {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, uri, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d", uri, rec.Code)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("handler called %d times, want 2: once per category", calls)
+	}
+	if n := cachedEntries("test"); n != 2 {
+		t.Errorf("%d responses cached, want 2", n)
+	}
+}
+
+func TestCacheResponseBoundsAGroup(t *testing.T) {
+	t.Cleanup(func() { InvalidateCache("test") })
+	router := gin.New()
+	router.GET("/templates", CacheResponse("test", time.Minute, "category"), func(c *gin.Context) {
+		c.String(http.StatusOK, c.Query("category"))
+	})
+
+	for i := 0; i < 2*maxCachedResponses; i++ {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/templates?category=c%d", i), nil))
+	}
+	if n := cachedEntries("test"); n != maxCachedResponses {
+		t.Errorf("%d responses cached, want at most %d", n, maxCachedResponses)
+	}
+}
+
+func TestCacheResponseDropsExpiredEntries(t *testing.T) {
+	t.Cleanup(func() { InvalidateCache("test") })
+	router := gin.New()
+	router.GET("/templates", CacheResponse("test", time.Nanosecond, "category"), func(c *gin.Context) {
+		c.String(http.StatusOK, c.Query("category"))
+	})
+
+	// Every response expires at once, so the group fills with expired entries
+	for i := 0; i <= maxCachedResponses; i++ {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/templates?category=c%d", i), nil))
+	}
+	if n := cachedEntries("test"); n != 1 {
+		t.Errorf("%d responses cached, want the expired ones dropped when the group filled", n)
+	}
+}
@@ -0,0 +1,62 @@
+package middleware
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"minecharts/cmd/apierror"
+	"minecharts/cmd/auth"
+	"minecharts/cmd/logging"
+	"minecharts/cmd/metrics"
+	"minecharts/cmd/ratelimit"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RateLimit refuses the requests of a client past the limit of a bucket with a 429
+// and a Retry-After header. Clients are the authenticated users, then the users of
+// a valid JWT when the request is not authenticated yet, then the client addresses.
+func RateLimit(bucket string) gin.HandlerFunc {
+	if !ratelimit.Enabled(bucket) {
+		return func(c *gin.Context) { c.Next() }
+	}
+
+	return func(c *gin.Context) {
+		allowed, retryAfter := ratelimit.Allow(c.Request.Context(), bucket, rateLimitKey(c))
+		if allowed {
+			c.Next()
+			return
+		}
+
+		seconds := int(math.Ceil(retryAfter.Seconds()))
+		if seconds < 1 {
+			seconds = 1
+		}
+		metrics.RateLimitedRequests.Inc(bucket)
+		logging.API.WithContext(c.Request.Context()).WithFields(
+			"bucket", bucket,
+			"path", c.Request.URL.Path,
+			"remote_ip", c.ClientIP(),
+			"retry_after", seconds,
+		).Debug("Request refused: rate limit reached")
+		c.Header("Retry-After", strconv.Itoa(seconds))
+		apierror.AbortWith(c, apierror.New(http.StatusTooManyRequests, apierror.CodeRateLimited, "Too many requests, retry in "+(time.Duration(seconds)*time.Second).String()).
+			With("retry_after", seconds))
+	}
+}
+
+// rateLimitKey identifies the client of a request for its rate limits.
+func rateLimitKey(c *gin.Context) string {
+	if user, ok := auth.GetCurrentUser(c); ok {
+		return "user:" + strconv.FormatInt(user.ID, 10)
+	}
+	if token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer "); ok {
+		if claims, err := auth.ValidateJWT(token); err == nil {
+			return "user:" + strconv.FormatInt(claims.UserID, 10)
+		}
+	}
+	return "ip:" + c.ClientIP()
+}
@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+
+	"minecharts/cmd/apierror"
+	"minecharts/cmd/auth"
+	"minecharts/cmd/errreport"
+	"minecharts/cmd/logging"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Recovery turns the panics of handlers into a 500 with the request ID, so the client
+// can quote it, instead of a dropped connection. The panic is logged with its stack
+// and reported to the error tracker when one is configured, along with the user and
+// the server of the request. It must run after RequestID.
+func Recovery() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+			// The server aborts its response on purpose, as when a proxied stream breaks
+			if err, ok := recovered.(error); ok && errors.Is(err, http.ErrAbortHandler) {
+				panic(recovered)
+			}
+
+			stack := string(debug.Stack())
+			report := errreport.Report{
+				Message:   fmt.Sprintf("panic: %v", recovered),
+				Stack:     stack,
+				RequestID: GetRequestID(c),
+				Method:    c.Request.Method,
+				Path:      c.Request.URL.Path,
+				Route:     c.FullPath(),
+				Tags:      map[string]string{},
+			}
+			if user, ok := auth.GetCurrentUser(c); ok {
+				report.UserID = user.ID
+				report.Username = user.Username
+			}
+			if serverName := c.Param("serverName"); serverName != "" {
+				report.Tags["minecraft_server"] = serverName
+			}
+
+			logging.API.WithContext(c.Request.Context()).WithFields(
+				"method", report.Method,
+				"path", report.Path,
+				"route", report.Route,
+				"user_id", report.UserID,
+				"panic", fmt.Sprint(recovered),
+				"stack", stack,
+			).Error("Recovered from panic in request handler")
+			errreport.Capture(report)
+
+			if c.Writer.Written() {
+				c.Abort()
+				return
+			}
+			apierror.Abort(c, http.StatusInternalServerError, "Internal server error")
+		}()
+		c.Next()
+	}
+}
@@ -0,0 +1,17 @@
+package middleware
+
+import (
+	"minecharts/cmd/database"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ReadReplica lets the database reads of the request be served by the read replica,
+// when one is configured. It is set on the routes that only read, such as the lists
+// and statuses polled by dashboards, which can tolerate the replication lag.
+func ReadReplica() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Request = c.Request.WithContext(database.WithReplica(c.Request.Context()))
+		c.Next()
+	}
+}
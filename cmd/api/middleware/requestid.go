@@ -0,0 +1,53 @@
+// Package middleware provides the Gin middlewares applied to every API request.
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"regexp"
+
+	"minecharts/cmd/logging"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// RequestIDKey is the key used to store the request ID in the Gin context.
+	RequestIDKey = "request_id"
+	// RequestIDHeader is the header carrying the request ID in requests and responses.
+	RequestIDHeader = "X-Request-ID"
+)
+
+// validRequestID limits the request IDs accepted from clients so they are safe to log.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// RequestID assigns an ID to each request, reusing the one sent by the client or
+// proxy when valid, and returns it in the X-Request-ID response header. The context
+// of the request carries it to the logs of the handlers and of the calls they make.
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = newRequestID()
+		}
+
+		c.Set(RequestIDKey, id)
+		c.Request = c.Request.WithContext(logging.ContextWithRequestID(c.Request.Context(), id))
+		c.Header(RequestIDHeader, id)
+		c.Next()
+	}
+}
+
+// GetRequestID returns the ID of the current request, or an empty string when
+// the RequestID middleware did not run.
+func GetRequestID(c *gin.Context) string {
+	return c.GetString(RequestIDKey)
+}
+
+func newRequestID() string {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(buf)
+}
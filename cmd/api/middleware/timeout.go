@@ -0,0 +1,111 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"minecharts/cmd/apierror"
+	"minecharts/cmd/logging"
+
+	"github.com/gin-gonic/gin"
+)
+
+// slowRequestRatio is the share of its budget after which a request is logged as slow.
+const slowRequestRatio = 0.8
+
+// Timeout bounds each request with a budget: the one set for its route in routes,
+// keyed by method and route path (e.g. "POST /servers/:serverName/exec"), or
+// defaultTimeout otherwise. A budget of zero or less means unlimited, for streams.
+//
+// When the budget runs out the request context is cancelled, so the database and
+// Kubernetes calls made by the handler return early, and the client receives a
+// 504 with the request ID instead of whatever the handler writes afterwards.
+func Timeout(defaultTimeout time.Duration, routes map[string]time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		budget := defaultTimeout
+		if routeTimeout, ok := routes[c.Request.Method+" "+c.FullPath()]; ok {
+			budget = routeTimeout
+		}
+		if budget <= 0 {
+			c.Next()
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), budget)
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+
+		writer := &timeoutWriter{ResponseWriter: c.Writer, ctx: ctx}
+		c.Writer = writer
+
+		start := time.Now()
+		c.Next()
+		elapsed := time.Since(start)
+
+		c.Writer = writer.ResponseWriter
+
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) && !c.Writer.Written() {
+			logging.API.WithContext(c.Request.Context()).WithFields(
+				"method", c.Request.Method,
+				"path", c.Request.URL.Path,
+				"route", c.FullPath(),
+				"budget", budget.String(),
+				"elapsed", elapsed.String(),
+				"remote_ip", c.ClientIP(),
+			).Warn("Request timed out")
+			apierror.Write(c, http.StatusGatewayTimeout, "Request timed out")
+			return
+		}
+
+		if elapsed > time.Duration(float64(budget)*slowRequestRatio) {
+			logging.API.WithContext(c.Request.Context()).WithFields(
+				"method", c.Request.Method,
+				"path", c.Request.URL.Path,
+				"route", c.FullPath(),
+				"budget", budget.String(),
+				"elapsed", elapsed.String(),
+			).Warn("Slow request close to its timeout budget")
+		}
+	}
+}
+
+// timeoutWriter discards the response written by a handler after the request
+// deadline, so the timeout middleware can answer with a 504 instead.
+type timeoutWriter struct {
+	gin.ResponseWriter
+	ctx context.Context
+}
+
+func (w *timeoutWriter) expired() bool {
+	return !w.ResponseWriter.Written() && errors.Is(w.ctx.Err(), context.DeadlineExceeded)
+}
+
+func (w *timeoutWriter) WriteHeader(code int) {
+	if w.expired() {
+		return
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *timeoutWriter) WriteHeaderNow() {
+	if w.expired() {
+		return
+	}
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w *timeoutWriter) Write(data []byte) (int, error) {
+	if w.expired() {
+		return len(data), nil
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *timeoutWriter) WriteString(s string) (int, error) {
+	if w.expired() {
+		return len(s), nil
+	}
+	return w.ResponseWriter.WriteString(s)
+}
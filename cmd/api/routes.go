@@ -2,77 +2,119 @@
 package api
 
 import (
-	"minecharts/cmd/api/handlers"
+	"fmt"
+	"strings"
+	"time"
+
+	"minecharts/cmd/api/middleware"
+	"minecharts/cmd/apierror"
 	"minecharts/cmd/auth"
-	"minecharts/cmd/database"
+	"minecharts/cmd/config"
+	"minecharts/cmd/logging"
+	"minecharts/cmd/metrics"
+	"minecharts/cmd/ratelimit"
+	"minecharts/cmd/tracing"
 
 	"github.com/gin-gonic/gin"
 )
 
-// SetupRoutes registers all the API routes with their respective handlers.
-// It defines the authentication middleware, permissions, and path grouping.
-func SetupRoutes(router *gin.Engine) {
-	// Ping endpoint for health checks
-	router.GET("/ping", handlers.PingHandler)
-
-	// Authentication group
-	authGroup := router.Group("/auth")
-	{
-		authGroup.POST("/login", handlers.LoginHandler)
-		authGroup.POST("/register", handlers.RegisterHandler)
-
-		// OAuth endpoints
-		authGroup.GET("/oauth/:provider", handlers.OAuthLoginHandler)
-		authGroup.GET("/callback/:provider", handlers.OAuthCallbackHandler)
+// routeTimeouts overrides the default request budget of the routes that wait on the
+// Minecraft server itself. A zero budget leaves the route unlimited, for streams.
+func routeTimeouts() map[string]time.Duration {
+	return map[string]time.Duration{
+		// Saving the world takes about ten seconds before the server is stopped or restarted,
+		// and wait-for-ready restarts then wait for the new pod
+		"POST /servers/:serverName/restart":                    config.ExecTimeout + config.RolloutTimeout,
+		"POST /servers/:serverName/stop":                       config.ExecTimeout,
+		"POST /servers/:serverName/delete":                     config.ExecTimeout,
+		"POST /servers/:serverName/players/link":               config.ExecTimeout,
+		"POST /servers/:serverName/exec":                       config.ExecTimeout,
+		"POST /servers/:serverName/rename":                     config.ExecTimeout,
+		"POST /servers/:serverName/clone":                      config.ExecTimeout,
+		"PUT /servers/:serverName/world":                       config.WorldUploadTimeout,
+		"PUT /servers/:serverName/worlds/:worldName":           config.WorldUploadTimeout,
+		"GET /servers/:serverName/worlds":                      config.ExecTimeout,
+		"POST /servers/:serverName/worlds/:worldName/activate": config.ExecTimeout,
+		"GET /servers/:serverName/world/export":                config.WorldExportTimeout,
+		"POST /servers/:serverName/plugins":                    config.PluginInstallTimeout,
+		"DELETE /servers/:serverName/plugins/:projectId":       config.ExecTimeout,
+		"GET /servers/:serverName/datapacks":                   config.ExecTimeout,
+		"POST /servers/:serverName/datapacks":                  config.DatapackUploadTimeout,
+		"POST /servers/:serverName/datapacks/enable":           config.ExecTimeout,
+		"POST /servers/:serverName/datapacks/disable":          config.ExecTimeout,
+		"POST /servers/:serverName":                            config.ExecTimeout,
+		"POST /admin/gc":                                       config.ExecTimeout,
+		"GET /events/stream":                                   0,
+		"GET /servers/:serverName/events/stream":               0,
+	}
+}
 
-		// Protected auth endpoints (require JWT)
-		authProtected := authGroup.Group("")
-		authProtected.Use(auth.JWTMiddleware())
-		{
-			authProtected.GET("/me", handlers.GetUserInfoHandler)
+// trustedProxies returns the addresses of MINECHARTS_TRUSTED_PROXIES, nil for none.
+func trustedProxies() []string {
+	var proxies []string
+	for _, proxy := range strings.Split(config.TrustedProxies, ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
 		}
 	}
+	return proxies
+}
 
-	// API keys management
-	apiKeyGroup := router.Group("/apikeys")
-	apiKeyGroup.Use(auth.JWTMiddleware())
-	{
-		apiKeyGroup.POST("", handlers.CreateAPIKeyHandler)
-		apiKeyGroup.GET("", handlers.ListAPIKeysHandler)
-		apiKeyGroup.DELETE("/:id", handlers.DeleteAPIKeyHandler)
+// SetupRoutes registers all the API routes of the route table with the middlewares
+// guarding them. It fails when a route of the table breaks the rules of checkRoutes.
+func SetupRoutes(router *gin.Engine) error {
+	routes := routeTable()
+	if err := checkRoutes(routes); err != nil {
+		return err
 	}
+	logDisabledRoutes()
 
-	// User management (admin only)
-	userGroup := router.Group("/users")
-	userGroup.Use(auth.JWTMiddleware(), auth.RequirePermission(database.PermAdmin))
-	{
-		userGroup.GET("", handlers.ListUsersHandler)
-		userGroup.GET("/:id", handlers.GetUserHandler)
-		userGroup.PUT("/:id", handlers.UpdateUserHandler)
-		userGroup.DELETE("/:id", handlers.DeleteUserHandler)
-
-		userGroup.POST("/:id/permissions/grant", auth.RequirePermission(database.PermAdmin), handlers.GrantUserPermissionsHandler)
-		userGroup.POST("/:id/permissions/revoke", auth.RequirePermission(database.PermAdmin), handlers.RevokeUserPermissionsHandler)
+	// Only the trusted reverse proxies tell the address of the client, which the rate
+	// limits, the audit log and the security events use
+	if err := router.SetTrustedProxies(trustedProxies()); err != nil {
+		return fmt.Errorf("invalid trusted proxies: %w", err)
 	}
 
-	router.GET("/permissions", auth.JWTMiddleware(), handlers.GetPermissionsMapHandler)
+	// Tag every request with an ID, trace and measure it, record its changes in the
+	// audit log, turn its panics into 500s and bound it with its timeout budget
+	router.Use(middleware.RequestID(), tracing.Middleware(middleware.GetRequestID), metrics.Middleware(), middleware.Audit(), middleware.Recovery(), middleware.Timeout(config.RequestTimeout, routeTimeouts()))
 
-	// Server management endpoints - protected with authentication
-	// First try JWT, then fall back to API key
-	serverGroup := router.Group("/servers")
-	serverGroup.Use(auth.JWTMiddleware(), auth.APIKeyMiddleware())
-	{
-		// Create server (requires PermCreateServer)
-		serverGroup.POST("", auth.RequirePermission(database.PermCreateServer), handlers.StartMinecraftServerHandler)
+	// Throttle the clients making too many requests
+	router.Use(middleware.RateLimit(ratelimit.Global))
 
-		// Server operations
-		serverGroup.POST("/:serverName/restart", auth.RequireServerPermission(database.PermRestartServer), handlers.RestartMinecraftServerHandler)
-		serverGroup.POST("/:serverName/stop", auth.RequireServerPermission(database.PermStopServer), handlers.StopMinecraftServerHandler)
-		serverGroup.POST("/:serverName/start", auth.RequireServerPermission(database.PermStartServer), handlers.StartStoppedServerHandler)
-		serverGroup.POST("/:serverName/delete", auth.RequireServerPermission(database.PermDeleteServer), handlers.DeleteMinecraftServerHandler)
-		serverGroup.POST("/:serverName/exec", auth.RequireServerPermission(database.PermExecCommand), handlers.ExecCommandHandler)
+	// Refuse to serve the API until the initial admin has been created
+	router.Use(auth.RequireSetupComplete())
 
-		// Network exposure endpoint
-		serverGroup.POST("/:serverName/expose", auth.RequireServerPermission(database.PermCreateServer), handlers.ExposeMinecraftServerHandler)
+	// Answer the errors handlers recorded without writing them, and the unknown
+	// routes and methods, in the format of the other errors
+	router.Use(apierror.Renderer())
+	router.HandleMethodNotAllowed = true
+	router.NoRoute(apierror.NoRoute)
+	router.NoMethod(apierror.NoMethod)
+
+	for _, route := range routes {
+		if route.disabled {
+			continue
+		}
+		router.Handle(route.method, route.path, route.chain()...)
+	}
+	return nil
+}
+
+// logDisabledRoutes reports the route groups turned off by the configuration, which
+// are not registered and answer 404.
+func logDisabledRoutes() {
+	for group, enabled := range map[string]bool{
+		"registration":  config.RegistrationEnabled && !config.InvitationOnly,
+		"oauth":         config.OAuthRoutesEnabled,
+		"public_status": config.PublicStatusEnabled,
+		"swagger":       config.SwaggerEnabled,
+		"metrics":       config.MetricsEnabled,
+	} {
+		if !enabled {
+			logging.API.WithFields(
+				"route_group", group,
+			).Info("Route group disabled by configuration")
+		}
 	}
 }
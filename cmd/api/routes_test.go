@@ -0,0 +1,70 @@
+package api
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"minecharts/cmd/config"
+	"minecharts/cmd/database"
+	"minecharts/cmd/logging"
+	"minecharts/cmd/ratelimit"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestForwardedForDoesNotResetTheAuthRateLimit(t *testing.T) {
+	logging.Init()
+	logging.Logger.SetOutput(io.Discard)
+	gin.SetMode(gin.TestMode)
+
+	db, err := database.NewSQLiteDB(filepath.Join(t.TempDir(), "minecharts.db"), database.PoolConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Init(); err != nil {
+		t.Fatal(err)
+	}
+	database.SetDB(db)
+	t.Cleanup(func() {
+		database.SetDB(nil)
+		db.Close()
+	})
+
+	c := config.Current()
+	c.RateLimit, c.AuthRateLimit, c.ExecRateLimit = "", "2/1m", ""
+	c.RateLimitRedisURL, c.RedisURL = "", ""
+	c.TrustedProxies = ""
+	config.Apply(c)
+	if err := ratelimit.Init(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(ratelimit.Close)
+
+	router := gin.New()
+	if err := SetupRoutes(router); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each attempt claims to come from another client behind a proxy
+	statuses := make([]int, 4)
+	for i := range statuses {
+		req := httptest.NewRequest(http.MethodPost, "/setup/admin", strings.NewReader("{}"))
+		req.RemoteAddr = "203.0.113.7:40000"
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Forwarded-For", "198.51.100."+strconv.Itoa(i+1))
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+		statuses[i] = recorder.Code
+	}
+	if statuses[0] == http.StatusTooManyRequests || statuses[1] == http.StatusTooManyRequests {
+		t.Fatalf("expected the first attempts to be allowed, got %v", statuses)
+	}
+	if statuses[2] != http.StatusTooManyRequests || statuses[3] != http.StatusTooManyRequests {
+		t.Fatalf("expected the attempts past the limit to be refused whatever their X-Forwarded-For, got %v", statuses)
+	}
+}
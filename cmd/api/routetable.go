@@ -0,0 +1,335 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"minecharts/cmd/api/handlers"
+	"minecharts/cmd/api/middleware"
+	"minecharts/cmd/auth"
+	"minecharts/cmd/config"
+	"minecharts/cmd/database"
+	"minecharts/cmd/kubernetes"
+	"minecharts/cmd/metrics"
+	"minecharts/cmd/ratelimit"
+
+	"github.com/gin-gonic/gin"
+)
+
+// authMode is how the caller of a route is authenticated.
+type authMode int
+
+const (
+	authNone        authMode = iota // Public, or authenticated by the handler itself
+	authJWT                         // JWT only, for the account and admin routes
+	authJWTOrAPIKey                 // JWT, or an API key
+)
+
+// routeSpec is an entry of the route table: a route with what guards it.
+type routeSpec struct {
+	method string
+	path   string
+	auth   authMode
+	// permission is the global permission the route requires, serverPermission the
+	// one it requires on the server of its :serverName parameter
+	permission       int64
+	serverPermission int64
+	// handlerChecked marks the authenticated routes whose handler checks the
+	// permissions on what it serves, such as the owner of a proxy or an API key
+	handlerChecked bool
+	// public marks the routes changing state that are meant to be called without an
+	// account, such as the login, or with a token of their own, such as webhooks
+	public    bool
+	cluster   bool     // Needs the Kubernetes API
+	replica   bool     // Only reads, its database reads may be served by the read replica
+	cache     string   // Response cache group, empty for none
+	cacheBy   []string // Query parameters the cached responses depend on
+	rateLimit string   // Stricter rate limit bucket than the global one, empty for none
+	disabled  bool     // Turned off by the configuration, not registered
+	handler   gin.HandlerFunc
+}
+
+// chain returns the middlewares guarding the route followed by its handler.
+func (r routeSpec) chain() []gin.HandlerFunc {
+	var chain []gin.HandlerFunc
+	switch r.auth {
+	case authJWT:
+		chain = append(chain, auth.JWTMiddleware())
+	case authJWTOrAPIKey:
+		chain = append(chain, auth.JWTOrAPIKeyMiddleware())
+	}
+	if r.rateLimit != "" {
+		chain = append(chain, middleware.RateLimit(r.rateLimit))
+	}
+	if r.permission != 0 {
+		chain = append(chain, auth.RequirePermission(r.permission))
+	}
+	if r.serverPermission != 0 {
+		chain = append(chain, auth.RequireServerPermission(r.serverPermission))
+	}
+	if r.cluster {
+		chain = append(chain, kubernetes.RequireCluster())
+	}
+	if r.auth != authNone {
+		chain = append(chain, middleware.KubernetesActor())
+	}
+	if r.replica {
+		chain = append(chain, middleware.ReadReplica())
+	}
+	if r.cache != "" {
+		chain = append(chain, middleware.CacheResponse(r.cache, config.ResponseCacheTTL, r.cacheBy...))
+	}
+	return append(chain, r.handler)
+}
+
+// mutating reports whether the method of the route changes state.
+func (r routeSpec) mutating() bool {
+	switch r.method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	}
+	return true
+}
+
+// checkRoutes enforces the rules every route of the table follows, so that a route
+// added without its guards fails the startup instead of being served unguarded:
+// routes changing state are authenticated unless marked public, and require a
+// permission unless their handler checks it.
+func checkRoutes(routes []routeSpec) error {
+	seen := make(map[string]bool, len(routes))
+	for _, r := range routes {
+		name := r.method + " " + r.path
+		switch {
+		case seen[name]:
+			return fmt.Errorf("route %s is declared twice", name)
+		case r.handler == nil:
+			return fmt.Errorf("route %s has no handler", name)
+		case r.public && r.auth != authNone:
+			return fmt.Errorf("route %s is both public and authenticated", name)
+		case r.auth == authNone && (r.permission != 0 || r.serverPermission != 0 || r.handlerChecked):
+			return fmt.Errorf("route %s requires permissions without authentication", name)
+		case r.serverPermission != 0 && !strings.Contains(r.path, ":serverName"):
+			return fmt.Errorf("route %s requires a server permission without a :serverName parameter", name)
+		case r.mutating() && r.auth == authNone && !r.public:
+			return fmt.Errorf("route %s changes state without authentication", name)
+		case r.mutating() && r.auth != authNone && r.permission == 0 && r.serverPermission == 0 && !r.handlerChecked:
+			return fmt.Errorf("route %s changes state without a permission", name)
+		}
+		seen[name] = true
+	}
+	return nil
+}
+
+// routeTable declares every route of the API. The capabilities of the users in
+// handlers/capabilities.go follow the permissions required here.
+func routeTable() []routeSpec {
+	return []routeSpec{
+		// Ping endpoint for health checks
+		{method: http.MethodGet, path: "/ping", disabled: !config.PublicStatusEnabled, handler: handlers.PingHandler},
+
+		// Liveness and readiness probes of the API's own deployment, always registered
+		// as the kubelet restarts the pods answering 404
+		{method: http.MethodGet, path: "/healthz", handler: handlers.HealthzHandler},
+		{method: http.MethodGet, path: "/readyz", handler: handlers.ReadyzHandler},
+
+		// Prometheus metrics of the API, behind their own token when one is set
+		{method: http.MethodGet, path: "/metrics", replica: true, disabled: !config.MetricsEnabled, handler: metrics.Handler()},
+
+		// First-boot setup, the admin can only be created before the setup is complete
+		{method: http.MethodGet, path: "/setup/status", disabled: !config.PublicStatusEnabled, handler: handlers.GetSetupStatusHandler},
+		{method: http.MethodPost, path: "/setup/admin", public: true, rateLimit: ratelimit.Auth, handler: handlers.CreateSetupAdminHandler},
+
+		// Authentication
+		{method: http.MethodPost, path: "/auth/login", public: true, rateLimit: ratelimit.Auth, handler: handlers.LoginHandler},
+		{method: http.MethodPost, path: "/auth/register", public: true, disabled: !config.RegistrationEnabled || config.InvitationOnly, rateLimit: ratelimit.Auth, handler: handlers.RegisterHandler},
+		{method: http.MethodPost, path: "/auth/invitations/accept", public: true, rateLimit: ratelimit.Auth, handler: handlers.AcceptInvitationHandler},
+		{method: http.MethodGet, path: "/auth/oauth/:provider", disabled: !config.OAuthRoutesEnabled, rateLimit: ratelimit.Auth, handler: handlers.OAuthLoginHandler},
+		{method: http.MethodGet, path: "/auth/callback/:provider", disabled: !config.OAuthRoutesEnabled, rateLimit: ratelimit.Auth, handler: handlers.OAuthCallbackHandler},
+
+		// Account of the current user
+		{method: http.MethodPost, path: "/auth/logout", auth: authJWT, handlerChecked: true, handler: handlers.LogoutHandler},
+		{method: http.MethodGet, path: "/auth/me", auth: authJWT, handler: handlers.GetUserInfoHandler},
+		{method: http.MethodPut, path: "/auth/me", auth: authJWT, handlerChecked: true, rateLimit: ratelimit.Auth, handler: handlers.UpdateProfileHandler},
+		{method: http.MethodPost, path: "/auth/me/password", auth: authJWT, handlerChecked: true, rateLimit: ratelimit.Auth, handler: handlers.ChangePasswordHandler},
+		{method: http.MethodPost, path: "/auth/me/minecraft", auth: authJWT, handlerChecked: true, rateLimit: ratelimit.Auth, handler: handlers.VerifyMinecraftAccountHandler},
+		{method: http.MethodDelete, path: "/auth/me/minecraft", auth: authJWT, handlerChecked: true, handler: handlers.UnlinkMinecraftAccountHandler},
+		{method: http.MethodGet, path: "/auth/me/oauth", auth: authJWT, handler: handlers.ListOAuthIdentitiesHandler},
+		{method: http.MethodPost, path: "/auth/me/oauth/:provider", auth: authJWT, handlerChecked: true, disabled: !config.OAuthRoutesEnabled, rateLimit: ratelimit.Auth, handler: handlers.LinkOAuthProviderHandler},
+		{method: http.MethodPost, path: "/auth/me/oauth/:provider/confirm", auth: authJWT, handlerChecked: true, rateLimit: ratelimit.Auth, handler: handlers.ConfirmOAuthLinkHandler},
+		{method: http.MethodDelete, path: "/auth/me/oauth/:provider", auth: authJWT, handlerChecked: true, handler: handlers.UnlinkOAuthProviderHandler},
+
+		// API keys of the current user
+		{method: http.MethodPost, path: "/apikeys", auth: authJWT, handlerChecked: true, handler: handlers.CreateAPIKeyHandler},
+		{method: http.MethodGet, path: "/apikeys", auth: authJWT, handler: handlers.ListAPIKeysHandler},
+		{method: http.MethodDelete, path: "/apikeys/:id", auth: authJWT, handlerChecked: true, handler: handlers.DeleteAPIKeyHandler},
+
+		// User management (admin only)
+		{method: http.MethodGet, path: "/users", auth: authJWT, permission: database.PermAdmin, replica: true, handler: handlers.ListUsersHandler},
+		{method: http.MethodPost, path: "/users", auth: authJWT, permission: database.PermAdmin, handler: handlers.CreateUserHandler},
+		{method: http.MethodPost, path: "/users/invitations", auth: authJWT, permission: database.PermAdmin, handler: handlers.CreateInvitationHandler},
+		{method: http.MethodGet, path: "/users/:id", auth: authJWT, permission: database.PermAdmin, handler: handlers.GetUserHandler},
+		{method: http.MethodPut, path: "/users/:id", auth: authJWT, permission: database.PermAdmin, handler: handlers.UpdateUserHandler},
+		{method: http.MethodDelete, path: "/users/:id", auth: authJWT, permission: database.PermAdmin, handler: handlers.DeleteUserHandler},
+		{method: http.MethodPost, path: "/users/:id/restore", auth: authJWT, permission: database.PermAdmin, handler: handlers.RestoreUserHandler},
+		{method: http.MethodGet, path: "/users/:id/quota", auth: authJWT, permission: database.PermAdmin, handler: handlers.GetUserQuotaHandler},
+		{method: http.MethodPut, path: "/users/:id/quota", auth: authJWT, permission: database.PermAdmin, handler: handlers.SetUserQuotaHandler},
+		{method: http.MethodDelete, path: "/users/:id/quota", auth: authJWT, permission: database.PermAdmin, handler: handlers.DeleteUserQuotaHandler},
+		{method: http.MethodPost, path: "/users/:id/permissions/grant", auth: authJWT, permission: database.PermAdmin, handler: handlers.GrantUserPermissionsHandler},
+		{method: http.MethodPost, path: "/users/:id/permissions/revoke", auth: authJWT, permission: database.PermAdmin, handler: handlers.RevokeUserPermissionsHandler},
+		{method: http.MethodPost, path: "/users/:id/roles/:roleId", auth: authJWT, permission: database.PermAdmin, handler: handlers.AssignUserRoleHandler},
+		{method: http.MethodDelete, path: "/users/:id/roles/:roleId", auth: authJWT, permission: database.PermAdmin, handler: handlers.UnassignUserRoleHandler},
+
+		{method: http.MethodGet, path: "/permissions", auth: authJWT, cache: handlers.PermissionsCacheGroup, handler: handlers.GetPermissionsMapHandler},
+
+		// Roles, the named sets of permissions assigned to users
+		{method: http.MethodGet, path: "/roles", auth: authJWT, permission: database.PermAdmin, handler: handlers.ListRolesHandler},
+		{method: http.MethodPost, path: "/roles", auth: authJWT, permission: database.PermAdmin, handler: handlers.CreateRoleHandler},
+		{method: http.MethodGet, path: "/roles/:id", auth: authJWT, permission: database.PermAdmin, handler: handlers.GetRoleHandler},
+		{method: http.MethodPut, path: "/roles/:id", auth: authJWT, permission: database.PermAdmin, handler: handlers.UpdateRoleHandler},
+		{method: http.MethodDelete, path: "/roles/:id", auth: authJWT, permission: database.PermAdmin, handler: handlers.DeleteRoleHandler},
+
+		// Organizations, the groups of users managing servers together
+		{method: http.MethodGet, path: "/organizations", auth: authJWT, handler: handlers.ListOrganizationsHandler},
+		{method: http.MethodPost, path: "/organizations", auth: authJWT, handlerChecked: true, handler: handlers.CreateOrganizationHandler},
+		{method: http.MethodGet, path: "/organizations/:id", auth: authJWT, handler: handlers.GetOrganizationHandler},
+		{method: http.MethodPut, path: "/organizations/:id", auth: authJWT, handlerChecked: true, handler: handlers.UpdateOrganizationHandler},
+		{method: http.MethodDelete, path: "/organizations/:id", auth: authJWT, handlerChecked: true, handler: handlers.DeleteOrganizationHandler},
+		{method: http.MethodPost, path: "/organizations/:id/members", auth: authJWT, handlerChecked: true, handler: handlers.SaveOrganizationMemberHandler},
+		{method: http.MethodDelete, path: "/organizations/:id/members/:userId", auth: authJWT, handlerChecked: true, handler: handlers.RemoveOrganizationMemberHandler},
+
+		// Actions of the current user, overall and on each server, for frontends
+		{method: http.MethodGet, path: "/capabilities", auth: authJWTOrAPIKey, handler: handlers.GetCapabilitiesHandler},
+
+		// Minecraft versions of each server type, from the cached upstream manifests
+		{method: http.MethodGet, path: "/mc/versions", auth: authJWTOrAPIKey, handler: handlers.ListMinecraftVersionsHandler},
+
+		// Cluster maintenance (admin only)
+		{method: http.MethodPost, path: "/admin/gc", auth: authJWT, permission: database.PermAdmin, cluster: true, handler: handlers.GarbageCollectHandler},
+		{method: http.MethodGet, path: "/admin/prepull", auth: authJWT, permission: database.PermAdmin, cluster: true, handler: handlers.GetPrePullHandler},
+		{method: http.MethodPost, path: "/admin/prepull", auth: authJWT, permission: database.PermAdmin, cluster: true, handler: handlers.TriggerPrePullHandler},
+		{method: http.MethodDelete, path: "/admin/prepull", auth: authJWT, permission: database.PermAdmin, cluster: true, handler: handlers.DeletePrePullHandler},
+		{method: http.MethodDelete, path: "/admin/cache", auth: authJWT, permission: database.PermAdmin, handler: handlers.InvalidateCacheHandler},
+		{method: http.MethodGet, path: "/admin/config", auth: authJWT, permission: database.PermAdmin, handler: handlers.GetConfigHandler},
+		{method: http.MethodGet, path: "/admin/apikeys", auth: authJWT, permission: database.PermAdmin, handler: handlers.ListAllAPIKeysHandler},
+		{method: http.MethodDelete, path: "/admin/apikeys/:id", auth: authJWT, permission: database.PermAdmin, handler: handlers.RevokeAPIKeyHandler},
+
+		// Audit log of the state-changing calls (admin only)
+		{method: http.MethodGet, path: "/audit", auth: authJWT, permission: database.PermAdmin, replica: true, handler: handlers.ListAuditEventsHandler},
+
+		// Wake-up webhook for mc-router, authenticated with its own token
+		{method: http.MethodPost, path: "/webhooks/wakeup", public: true, cluster: true, handler: handlers.WakeupWebhookHandler},
+
+		// Server-Sent Events streams of the servers the user can view, and of one server
+		{method: http.MethodGet, path: "/events/stream", auth: authJWTOrAPIKey, handler: handlers.StreamEventsHandler},
+		{method: http.MethodGet, path: "/servers/:serverName/events/stream", auth: authJWTOrAPIKey, serverPermission: database.PermViewServer, handler: handlers.StreamServerEventsHandler},
+
+		// Server list, with delta queries from the status change log
+		{method: http.MethodGet, path: "/servers", auth: authJWTOrAPIKey, replica: true, handler: handlers.ListServersHandler},
+		{method: http.MethodPost, path: "/servers", auth: authJWTOrAPIKey, permission: database.PermCreateServer, cluster: true, handler: handlers.StartMinecraftServerHandler},
+
+		// Status of several servers, the status:batch custom method, checked server by server
+		{method: http.MethodPost, path: "/servers/:serverName", auth: authJWTOrAPIKey, handlerChecked: true, replica: true, handler: handlers.BatchServerStatusHandler},
+
+		// Server status (served from the last known state while the cluster is unreachable)
+		{method: http.MethodGet, path: "/servers/:serverName/status", auth: authJWTOrAPIKey, serverPermission: database.PermViewServer, replica: true, handler: handlers.GetServerStatusHandler},
+
+		// Scheduled tasks, run by the task scheduler; the handlers also check the
+		// permission of the task action
+		{method: http.MethodGet, path: "/servers/:serverName/tasks", auth: authJWTOrAPIKey, serverPermission: database.PermViewServer, handler: handlers.ListScheduledTasksHandler},
+		{method: http.MethodPost, path: "/servers/:serverName/tasks", auth: authJWTOrAPIKey, serverPermission: database.PermViewServer, handler: handlers.CreateScheduledTaskHandler},
+		{method: http.MethodGet, path: "/servers/:serverName/tasks/:taskId", auth: authJWTOrAPIKey, serverPermission: database.PermViewServer, handler: handlers.GetScheduledTaskHandler},
+		{method: http.MethodPut, path: "/servers/:serverName/tasks/:taskId", auth: authJWTOrAPIKey, serverPermission: database.PermViewServer, handler: handlers.UpdateScheduledTaskHandler},
+		{method: http.MethodDelete, path: "/servers/:serverName/tasks/:taskId", auth: authJWTOrAPIKey, serverPermission: database.PermViewServer, handler: handlers.DeleteScheduledTaskHandler},
+		{method: http.MethodGet, path: "/servers/:serverName/tasks/:taskId/runs", auth: authJWTOrAPIKey, serverPermission: database.PermViewServer, handler: handlers.ListTaskRunsHandler},
+
+		// Long-running operations of the server
+		{method: http.MethodGet, path: "/servers/:serverName/jobs", auth: authJWTOrAPIKey, serverPermission: database.PermViewServer, replica: true, handler: handlers.ListServerJobsHandler},
+
+		// Crashes of the server container
+		{method: http.MethodGet, path: "/servers/:serverName/incidents", auth: authJWTOrAPIKey, serverPermission: database.PermViewServer, replica: true, handler: handlers.ListServerIncidentsHandler},
+
+		// Whitelist synced with the users who can view the server
+		{method: http.MethodPut, path: "/servers/:serverName/whitelist/sync", auth: authJWTOrAPIKey, serverPermission: database.PermDeleteServer, handler: handlers.SetWhitelistSyncHandler},
+
+		// Custom labels of the server objects and metrics
+		{method: http.MethodPut, path: "/servers/:serverName/labels", auth: authJWTOrAPIKey, serverPermission: database.PermDeleteServer, cluster: true, handler: handlers.SetServerLabelsHandler},
+		// Priority class and disruption budget of the server pod
+		{method: http.MethodPut, path: "/servers/:serverName/scheduling", auth: authJWTOrAPIKey, serverPermission: database.PermDeleteServer, cluster: true, handler: handlers.SetServerSchedulingHandler},
+
+		// Members, the users the owner of the server granted permissions on it, and the
+		// organization owning it
+		{method: http.MethodGet, path: "/servers/:serverName/members", auth: authJWTOrAPIKey, serverPermission: database.PermViewServer, handlerChecked: true, handler: handlers.ListServerMembersHandler},
+		{method: http.MethodPost, path: "/servers/:serverName/members", auth: authJWTOrAPIKey, serverPermission: database.PermViewServer, handlerChecked: true, handler: handlers.AddServerMemberHandler},
+		{method: http.MethodDelete, path: "/servers/:serverName/members/:userId", auth: authJWTOrAPIKey, serverPermission: database.PermViewServer, handlerChecked: true, handler: handlers.RemoveServerMemberHandler},
+		{method: http.MethodPut, path: "/servers/:serverName/organization", auth: authJWTOrAPIKey, serverPermission: database.PermViewServer, handlerChecked: true, handler: handlers.SetServerOrganizationHandler},
+
+		// Plugins and mods, searched on Modrinth
+		{method: http.MethodGet, path: "/servers/:serverName/plugins", auth: authJWTOrAPIKey, serverPermission: database.PermViewServer, handler: handlers.ListServerPluginsHandler},
+		{method: http.MethodGet, path: "/servers/:serverName/plugins/search", auth: authJWTOrAPIKey, serverPermission: database.PermViewServer, handler: handlers.SearchPluginsHandler},
+		{method: http.MethodPost, path: "/servers/:serverName/plugins", auth: authJWTOrAPIKey, serverPermission: database.PermExecCommand, cluster: true, handler: handlers.InstallServerPluginHandler},
+		{method: http.MethodDelete, path: "/servers/:serverName/plugins/:projectId", auth: authJWTOrAPIKey, serverPermission: database.PermExecCommand, cluster: true, handler: handlers.DeleteServerPluginHandler},
+
+		// Server operations
+		{method: http.MethodPost, path: "/servers/:serverName/restart", auth: authJWTOrAPIKey, serverPermission: database.PermRestartServer, cluster: true, handler: handlers.RestartMinecraftServerHandler},
+		{method: http.MethodPost, path: "/servers/:serverName/stop", auth: authJWTOrAPIKey, serverPermission: database.PermStopServer, cluster: true, handler: handlers.StopMinecraftServerHandler},
+		{method: http.MethodPost, path: "/servers/:serverName/start", auth: authJWTOrAPIKey, serverPermission: database.PermStartServer, cluster: true, handler: handlers.StartStoppedServerHandler},
+		{method: http.MethodPost, path: "/servers/:serverName/delete", auth: authJWTOrAPIKey, serverPermission: database.PermDeleteServer, cluster: true, handler: handlers.DeleteMinecraftServerHandler},
+		{method: http.MethodPost, path: "/servers/:serverName/rename", auth: authJWTOrAPIKey, serverPermission: database.PermDeleteServer, cluster: true, handler: handlers.RenameServerHandler},
+		{method: http.MethodPost, path: "/servers/:serverName/clone", auth: authJWTOrAPIKey, permission: database.PermCreateServer, serverPermission: database.PermViewServer, cluster: true, handler: handlers.CloneServerHandler},
+		{method: http.MethodPost, path: "/servers/:serverName/upgrade", auth: authJWTOrAPIKey, serverPermission: database.PermDeleteServer, cluster: true, handler: handlers.UpgradeServerHandler},
+		{method: http.MethodPost, path: "/servers/:serverName/exec", auth: authJWTOrAPIKey, serverPermission: database.PermExecCommand, cluster: true, rateLimit: ratelimit.Exec, handler: handlers.ExecCommandHandler},
+		{method: http.MethodGet, path: "/servers/:serverName/rollout", auth: authJWTOrAPIKey, serverPermission: database.PermViewServer, cluster: true, handler: handlers.GetServerRolloutHandler},
+
+		// Worlds
+		{method: http.MethodPut, path: "/servers/:serverName/world", auth: authJWTOrAPIKey, serverPermission: database.PermDeleteServer, cluster: true, handler: handlers.UploadWorldHandler},
+		{method: http.MethodGet, path: "/servers/:serverName/world/export", auth: authJWTOrAPIKey, serverPermission: database.PermExecCommand, cluster: true, handler: handlers.ExportWorldHandler},
+		{method: http.MethodGet, path: "/servers/:serverName/worlds", auth: authJWTOrAPIKey, serverPermission: database.PermViewServer, cluster: true, handler: handlers.ListWorldsHandler},
+		{method: http.MethodPut, path: "/servers/:serverName/worlds/:worldName", auth: authJWTOrAPIKey, serverPermission: database.PermDeleteServer, cluster: true, handler: handlers.AddWorldHandler},
+		{method: http.MethodPost, path: "/servers/:serverName/worlds/:worldName/activate", auth: authJWTOrAPIKey, serverPermission: database.PermRestartServer, cluster: true, handler: handlers.SwitchWorldHandler},
+
+		// Datapacks
+		{method: http.MethodGet, path: "/servers/:serverName/datapacks", auth: authJWTOrAPIKey, serverPermission: database.PermViewServer, cluster: true, handler: handlers.ListDatapacksHandler},
+		{method: http.MethodPost, path: "/servers/:serverName/datapacks", auth: authJWTOrAPIKey, serverPermission: database.PermExecCommand, cluster: true, handler: handlers.UploadDatapackHandler},
+		{method: http.MethodPost, path: "/servers/:serverName/datapacks/enable", auth: authJWTOrAPIKey, serverPermission: database.PermExecCommand, cluster: true, handler: handlers.EnableDatapackHandler},
+		{method: http.MethodPost, path: "/servers/:serverName/datapacks/disable", auth: authJWTOrAPIKey, serverPermission: database.PermExecCommand, cluster: true, handler: handlers.DisableDatapackHandler},
+
+		// Players
+		{method: http.MethodGet, path: "/servers/:serverName/players/online", auth: authJWTOrAPIKey, serverPermission: database.PermViewServer, cluster: true, handler: handlers.GetOnlinePlayersHandler},
+		{method: http.MethodPost, path: "/servers/:serverName/players/link", auth: authJWTOrAPIKey, serverPermission: database.PermViewServer, cluster: true, handler: handlers.LinkMinecraftAccountHandler},
+
+		// Network exposure
+		{method: http.MethodPost, path: "/servers/:serverName/expose", auth: authJWTOrAPIKey, serverPermission: database.PermExposeServer, cluster: true, handler: handlers.ExposeMinecraftServerHandler},
+
+		// Velocity proxies in front of networks of servers, checked against the
+		// permissions on the servers of their owner
+		{method: http.MethodGet, path: "/proxies", auth: authJWTOrAPIKey, replica: true, handler: handlers.ListProxiesHandler},
+		{method: http.MethodGet, path: "/proxies/:proxyName", auth: authJWTOrAPIKey, handler: handlers.GetProxyHandler},
+		{method: http.MethodPost, path: "/proxies", auth: authJWTOrAPIKey, permission: database.PermCreateServer, cluster: true, handler: handlers.CreateProxyHandler},
+		{method: http.MethodDelete, path: "/proxies/:proxyName", auth: authJWTOrAPIKey, handlerChecked: true, cluster: true, handler: handlers.DeleteProxyHandler},
+		{method: http.MethodPost, path: "/proxies/:proxyName/servers", auth: authJWTOrAPIKey, handlerChecked: true, cluster: true, handler: handlers.RegisterProxyServerHandler},
+		{method: http.MethodDelete, path: "/proxies/:proxyName/servers/:serverName", auth: authJWTOrAPIKey, handlerChecked: true, cluster: true, handler: handlers.UnregisterProxyServerHandler},
+		{method: http.MethodPost, path: "/proxies/:proxyName/expose", auth: authJWTOrAPIKey, handlerChecked: true, cluster: true, handler: handlers.ExposeProxyHandler},
+
+		// Jobs of the long-running operations, polled after a 202 Accepted
+		{method: http.MethodGet, path: "/jobs/:id", auth: authJWTOrAPIKey, handler: handlers.GetJobHandler},
+
+		// Server templates (presets used with templateId when creating servers)
+		{method: http.MethodGet, path: "/templates", auth: authJWTOrAPIKey, cache: handlers.TemplatesCacheGroup, cacheBy: []string{"category"}, handler: handlers.ListServerTemplatesHandler},
+		{method: http.MethodGet, path: "/templates/catalog", auth: authJWTOrAPIKey, disabled: config.TemplateCatalogURL == "", handler: handlers.ListTemplateCatalogHandler},
+		{method: http.MethodPost, path: "/templates/import", auth: authJWTOrAPIKey, permission: database.PermAdmin, handler: handlers.ImportServerTemplateHandler},
+		{method: http.MethodGet, path: "/templates/:id", auth: authJWTOrAPIKey, handler: handlers.GetServerTemplateHandler},
+		{method: http.MethodPost, path: "/templates", auth: authJWTOrAPIKey, permission: database.PermAdmin, handler: handlers.CreateServerTemplateHandler},
+		{method: http.MethodPut, path: "/templates/:id", auth: authJWTOrAPIKey, permission: database.PermAdmin, handler: handlers.UpdateServerTemplateHandler},
+		{method: http.MethodDelete, path: "/templates/:id", auth: authJWTOrAPIKey, permission: database.PermAdmin, handler: handlers.DeleteServerTemplateHandler},
+
+		// Server creation requests (approval workflow)
+		{method: http.MethodGet, path: "/server-requests", auth: authJWTOrAPIKey, replica: true, handler: handlers.ListServerRequestsHandler},
+		{method: http.MethodPost, path: "/server-requests/:id/approve", auth: authJWTOrAPIKey, permission: database.PermAdmin, cluster: true, handler: handlers.ApproveServerRequestHandler},
+		{method: http.MethodPost, path: "/server-requests/:id/reject", auth: authJWTOrAPIKey, permission: database.PermAdmin, handler: handlers.RejectServerRequestHandler},
+
+		// Notifications of the current user
+		{method: http.MethodGet, path: "/notifications", auth: authJWTOrAPIKey, replica: true, handler: handlers.ListNotificationsHandler},
+		{method: http.MethodPost, path: "/notifications/:id/read", auth: authJWTOrAPIKey, handlerChecked: true, handler: handlers.MarkNotificationReadHandler},
+	}
+}
@@ -0,0 +1,104 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"minecharts/cmd/config"
+	"minecharts/cmd/database"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRouteTableFollowsTheRules(t *testing.T) {
+	if err := checkRoutes(routeTable()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckRoutesRejectsUnguardedRoutes(t *testing.T) {
+	handler := func(*gin.Context) {}
+	tests := []struct {
+		name   string
+		routes []routeSpec
+		err    string
+	}{
+		{
+			name: "mutating route without authentication",
+			routes: []routeSpec{
+				{method: http.MethodPost, path: "/servers", handler: handler},
+			},
+			err: "changes state without authentication",
+		},
+		{
+			name: "mutating route without permission",
+			routes: []routeSpec{
+				{method: http.MethodDelete, path: "/servers/:serverName", auth: authJWTOrAPIKey, handler: handler},
+			},
+			err: "changes state without a permission",
+		},
+		{
+			name: "duplicate method and path",
+			routes: []routeSpec{
+				{method: http.MethodGet, path: "/servers", auth: authJWTOrAPIKey, handler: handler},
+				{method: http.MethodGet, path: "/servers", auth: authJWTOrAPIKey, handler: handler},
+			},
+			err: "declared twice",
+		},
+		{
+			name: "permission without authentication",
+			routes: []routeSpec{
+				{method: http.MethodGet, path: "/users", permission: database.PermAdmin, handler: handler},
+			},
+			err: "requires permissions without authentication",
+		},
+		{
+			name: "server permission without authentication",
+			routes: []routeSpec{
+				{method: http.MethodGet, path: "/servers/:serverName", serverPermission: database.PermViewServer, handler: handler},
+			},
+			err: "requires permissions without authentication",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkRoutes(tt.routes)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("expected an error containing %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestCheckRoutesAcceptsGuardedRoutes(t *testing.T) {
+	handler := func(*gin.Context) {}
+	routes := []routeSpec{
+		{method: http.MethodPost, path: "/auth/login", public: true, handler: handler},
+		{method: http.MethodGet, path: "/servers", auth: authJWTOrAPIKey, handler: handler},
+		{method: http.MethodPost, path: "/servers/:serverName/start", auth: authJWTOrAPIKey, serverPermission: database.PermStartServer, handler: handler},
+	}
+	if err := checkRoutes(routes); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestProbesStayWithoutPublicStatus(t *testing.T) {
+	previous := config.PublicStatusEnabled
+	config.PublicStatusEnabled = false
+	t.Cleanup(func() { config.PublicStatusEnabled = previous })
+
+	registered := map[string]bool{}
+	for _, route := range routeTable() {
+		registered[route.method+" "+route.path] = !route.disabled
+	}
+	for _, probe := range []string{"GET /healthz", "GET /readyz"} {
+		if !registered[probe] {
+			t.Errorf("%s is not registered without the public status routes", probe)
+		}
+	}
+	if registered["GET /ping"] {
+		t.Error("GET /ping is registered without the public status routes")
+	}
+}
@@ -0,0 +1,201 @@
+// Package apierror renders the error responses of the API in a single format, with
+// a machine-readable code clients can branch on.
+package apierror
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+// Codes of the errors. Every status has a default code, the others name the
+// errors clients handle on their own.
+const (
+	CodeInvalidRequest     = "invalid_request"
+	CodeUnauthorized       = "unauthorized"
+	CodeForbidden          = "forbidden"
+	CodeNotFound           = "not_found"
+	CodeMethodNotAllowed   = "method_not_allowed"
+	CodeConflict           = "conflict"
+	CodeGone               = "gone"
+	CodeTooLarge           = "too_large"
+	CodeUnprocessable      = "unprocessable"
+	CodeRateLimited        = "rate_limited"
+	CodeInternal           = "internal_error"
+	CodeUpstream           = "upstream_error"
+	CodeUnavailable        = "unavailable"
+	CodeTimeout            = "timeout"
+	CodeMissingCredentials = "missing_credentials"
+	CodeInvalidToken       = "invalid_token"
+	CodeTokenExpired       = "token_expired"
+	CodeTokenRevoked       = "token_revoked"
+	CodeInvalidAPIKey      = "invalid_api_key"
+	CodeAPIKeyExpired      = "api_key_expired"
+	CodeAccountInactive    = "account_inactive"
+	CodePasswordChange     = "password_change_required"
+	CodePermissionDenied   = "permission_denied"
+	CodeSetupRequired      = "setup_required"
+	CodeServerNotFound     = "server_not_found"
+	CodeQuotaExceeded      = "quota_exceeded"
+	CodeClusterUnreachable = "cluster_unreachable"
+)
+
+// statusCodes are the default codes of the statuses.
+var statusCodes = map[int]string{
+	http.StatusBadRequest:            CodeInvalidRequest,
+	http.StatusUnauthorized:          CodeUnauthorized,
+	http.StatusForbidden:             CodeForbidden,
+	http.StatusNotFound:              CodeNotFound,
+	http.StatusMethodNotAllowed:      CodeMethodNotAllowed,
+	http.StatusConflict:              CodeConflict,
+	http.StatusGone:                  CodeGone,
+	http.StatusRequestEntityTooLarge: CodeTooLarge,
+	http.StatusUnprocessableEntity:   CodeUnprocessable,
+	http.StatusTooManyRequests:       CodeRateLimited,
+	http.StatusInternalServerError:   CodeInternal,
+	http.StatusBadGateway:            CodeUpstream,
+	http.StatusServiceUnavailable:    CodeUnavailable,
+	http.StatusGatewayTimeout:        CodeTimeout,
+}
+
+// requestIDKey is the context key middleware.RequestID stores the request ID under.
+const requestIDKey = "request_id"
+
+// Error is the body of the error responses. The message stays under the error key
+// clients read before errors had codes.
+type Error struct {
+	Status    int            `json:"-"`
+	Code      string         `json:"code" example:"not_found"`
+	Message   string         `json:"error" example:"Server not found"`
+	Details   map[string]any `json:"details,omitempty"`
+	RequestID string         `json:"request_id,omitempty" example:"3f2a9c1e7b6d4e0f8a5b2c9d1e7f3a6b"`
+}
+
+// New returns an error answered with status. An empty code is the default code of
+// the status.
+func New(status int, code, message string) *Error {
+	if code == "" {
+		code = CodeOf(status)
+	}
+	return &Error{Status: status, Code: code, Message: message}
+}
+
+// Error returns the message, so that errors can be passed to gin.Context.Error.
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// With adds a detail to the error.
+func (e *Error) With(key string, value any) *Error {
+	if e.Details == nil {
+		e.Details = map[string]any{}
+	}
+	e.Details[key] = value
+	return e
+}
+
+// CodeOf returns the default code of a status.
+func CodeOf(status int) string {
+	if code, ok := statusCodes[status]; ok {
+		return code
+	}
+	if status >= http.StatusInternalServerError {
+		return CodeInternal
+	}
+	return CodeInvalidRequest
+}
+
+// Respond writes the error, with the ID of the request.
+func Respond(c *gin.Context, err *Error) {
+	err.RequestID = c.GetString(requestIDKey)
+	c.JSON(err.Status, err)
+}
+
+// AbortWith writes the error, with the ID of the request, and stops the handler chain.
+func AbortWith(c *gin.Context, err *Error) {
+	err.RequestID = c.GetString(requestIDKey)
+	c.AbortWithStatusJSON(err.Status, err)
+}
+
+// Write answers with status and the message, with the default code of the status.
+func Write(c *gin.Context, status int, message string) {
+	Respond(c, New(status, "", message))
+}
+
+// Abort answers with status and the message, with the default code of the status,
+// and stops the handler chain.
+func Abort(c *gin.Context, status int, message string) {
+	AbortWith(c, New(status, "", message))
+}
+
+// init names the fields that failed their validation after their JSON keys, as
+// clients send them, rather than after the Go fields.
+func init() {
+	if validate, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		validate.RegisterTagNameFunc(func(field reflect.StructField) string {
+			name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if name == "-" {
+				return ""
+			}
+			return name
+		})
+	}
+}
+
+// Invalid answers 400 to a request body or query that failed to bind. Fields that
+// failed their validation are listed in the fields detail, with the rule they broke.
+func Invalid(c *gin.Context, err error) {
+	apiErr := New(http.StatusBadRequest, CodeInvalidRequest, err.Error())
+	var validationErrs validator.ValidationErrors
+	if errors.As(err, &validationErrs) {
+		fields := map[string]string{}
+		for _, fieldErr := range validationErrs {
+			fields[fieldName(fieldErr)] = fieldErr.Tag()
+		}
+		apiErr.With("fields", fields)
+	}
+	Respond(c, apiErr)
+}
+
+// fieldName returns the path of a field that failed its validation, without the
+// name of the request type.
+func fieldName(err validator.FieldError) string {
+	namespace := err.Namespace()
+	if _, path, ok := strings.Cut(namespace, "."); ok {
+		return path
+	}
+	return namespace
+}
+
+// Renderer answers the requests whose handlers recorded errors with
+// gin.Context.Error without writing a response. Errors of this package keep their
+// status and code, the others are answered 500 without their message.
+func Renderer() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+		if c.Writer.Written() || len(c.Errors) == 0 {
+			return
+		}
+		var apiErr *Error
+		if errors.As(c.Errors.Last().Err, &apiErr) {
+			Respond(c, apiErr)
+			return
+		}
+		Write(c, http.StatusInternalServerError, "Internal server error")
+	}
+}
+
+// NoRoute answers the requests of unknown routes.
+func NoRoute(c *gin.Context) {
+	Write(c, http.StatusNotFound, "Route not found")
+}
+
+// NoMethod answers the requests of known routes with another method.
+func NoMethod(c *gin.Context) {
+	Write(c, http.StatusMethodNotAllowed, "Method not allowed")
+}
@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"minecharts/cmd/database"
+
+	"github.com/gin-gonic/gin"
+)
+
+// benchCache runs a benchmark without then with the cache in front of the database.
+func benchCache(b *testing.B, run func(b *testing.B)) {
+	for _, cached := range []bool{false, true} {
+		name := "uncached"
+		if cached {
+			name = "cached"
+		}
+		b.Run(name, func(b *testing.B) {
+			setupTest(b)
+			if cached {
+				if err := database.EnableCache(database.CacheConfig{TTL: time.Minute, TouchInterval: time.Minute}); err != nil {
+					b.Fatal(err)
+				}
+				b.Cleanup(func() { database.GetDB().Close() })
+			}
+			run(b)
+		})
+	}
+}
+
+// benchMiddleware sends b.N authenticated requests through a middleware, in parallel.
+func benchMiddleware(b *testing.B, middleware gin.HandlerFunc, header, value string) {
+	b.Helper()
+	router := gin.New()
+	router.GET("/auth/me", middleware, func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			req := httptest.NewRequest(http.MethodGet, "/auth/me", nil)
+			req.Header.Set(header, value)
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			if rec.Code != http.StatusOK {
+				// FailNow is not allowed off the benchmark goroutine
+				b.Errorf("status = %d: %s", rec.Code, rec.Body.String())
+				return
+			}
+		}
+	})
+}
+
+// BenchmarkJWTMiddleware measures authenticating a request with a token: the
+// signature and expiry checks, the revocation lookup and the user read.
+func BenchmarkJWTMiddleware(b *testing.B) {
+	benchCache(b, func(b *testing.B) {
+		user := createTestUser(b, database.GetDB(), "alice", database.PermReadOnly)
+		token, err := GenerateJWT(user.ID, user.Username, user.Email, user.Permissions)
+		if err != nil {
+			b.Fatal(err)
+		}
+		benchMiddleware(b, JWTMiddleware(), "Authorization", "Bearer "+token)
+	})
+}
+
+// BenchmarkAPIKeyMiddleware measures authenticating a request with an API key: the
+// key and user reads and the record of the key use.
+func BenchmarkAPIKeyMiddleware(b *testing.B) {
+	benchCache(b, func(b *testing.B) {
+		db := database.GetDB()
+		user := createTestUser(b, db, "alice", database.PermReadOnly)
+		key := &database.APIKey{UserID: user.ID, Key: "mc_bench"}
+		if err := db.CreateAPIKey(b.Context(), key); err != nil {
+			b.Fatal(err)
+		}
+		benchMiddleware(b, APIKeyMiddleware(), "X-API-Key", key.Key)
+	})
+}
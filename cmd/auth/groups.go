@@ -0,0 +1,125 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"minecharts/cmd/config"
+	"minecharts/cmd/database"
+	"minecharts/cmd/logging"
+)
+
+// groupPermissions caches the parsed MINECHARTS_OAUTH_GROUP_PERMISSIONS, parsed again
+// when a configuration reload changes it.
+var groupPermissions struct {
+	sync.Mutex
+	source  string
+	mapping map[string]int64
+}
+
+// ParseGroupPermissions reads a comma separated list of group=permissions mappings,
+// such as minecraft-admins=PermAll,minecraft-ops=PermStartServer|PermStopServer. The
+// permissions are the names listed by GET /permissions or their values, combined
+// with |. Invalid mappings are skipped.
+func ParseGroupPermissions(value string) map[string]int64 {
+	mapping := map[string]int64{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		group, names, found := strings.Cut(entry, "=")
+		group = strings.TrimSpace(group)
+		if !found || group == "" {
+			logging.Auth.OAuth.WithFields(
+				"mapping", entry,
+			).Warn("Invalid OAuth group permissions mapping, expected group=permissions, ignored")
+			continue
+		}
+		permissions, err := parsePermissions(names)
+		if err != nil {
+			logging.Auth.OAuth.WithFields(
+				"mapping", entry,
+				"error", err.Error(),
+			).Warn("Invalid OAuth group permissions mapping, ignored")
+			continue
+		}
+		mapping[group] |= permissions
+	}
+	return mapping
+}
+
+// parsePermissions combines the permissions of a mapping.
+func parsePermissions(names string) (int64, error) {
+	var permissions int64
+	for _, name := range strings.Split(names, "|") {
+		name = strings.TrimSpace(name)
+		if permission, ok := database.PermissionNames[name]; ok {
+			permissions |= permission
+			continue
+		}
+		permission, err := strconv.ParseInt(name, 10, 64)
+		if err != nil || permission < 0 || permission&^database.PermAll != 0 {
+			return 0, fmt.Errorf("unknown permission %q", name)
+		}
+		permissions |= permission
+	}
+	return permissions, nil
+}
+
+// mappedPermissions returns the permissions the groups of an OAuth user grant with
+// MINECHARTS_OAUTH_GROUP_PERMISSIONS. Users in no mapped group are read-only. It
+// returns false without a mapping, or when the provider did not report the groups.
+func mappedPermissions(groups []string) (int64, bool) {
+	cfg := config.Live()
+	groupPermissions.Lock()
+	if groupPermissions.mapping == nil || groupPermissions.source != cfg.OAuthGroupPermissions {
+		groupPermissions.source = cfg.OAuthGroupPermissions
+		groupPermissions.mapping = ParseGroupPermissions(groupPermissions.source)
+	}
+	mapping := groupPermissions.mapping
+	groupPermissions.Unlock()
+	if len(mapping) == 0 || groups == nil {
+		return 0, false
+	}
+
+	var permissions int64
+	for _, group := range groups {
+		permissions |= mapping[group]
+	}
+	if permissions == 0 {
+		permissions = database.PermReadOnly
+	}
+	return permissions, true
+}
+
+// claimGroups reads the groups of an OpenID Connect user from the claim named by
+// MINECHARTS_OAUTH_GROUPS_CLAIM, a list or a space separated string. It returns nil
+// when the claim is missing.
+func claimGroups(body []byte) []string {
+	cfg := config.Live()
+	var claims map[string]json.RawMessage
+	if err := json.Unmarshal(body, &claims); err != nil {
+		return nil
+	}
+	claim, ok := claims[cfg.OAuthGroupsClaim]
+	if !ok {
+		return nil
+	}
+
+	groups := []string{}
+	if err := json.Unmarshal(claim, &groups); err == nil {
+		return groups
+	}
+	var value string
+	if err := json.Unmarshal(claim, &value); err == nil {
+		return strings.Fields(value)
+	}
+	logging.Auth.OAuth.WithFields(
+		"claim", cfg.OAuthGroupsClaim,
+	).Warn("OAuth groups claim is neither a list nor a string, ignored")
+	return nil
+}
@@ -1,21 +1,72 @@
 package auth
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"sync"
 	"time"
 
+	"minecharts/cmd/clock"
 	"minecharts/cmd/config"
+	"minecharts/cmd/database"
 	"minecharts/cmd/logging"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
 var (
-	ErrInvalidToken = errors.New("invalid token")
-	ErrExpiredToken = errors.New("token has expired")
+	ErrInvalidToken      = errors.New("invalid token")
+	ErrExpiredToken      = errors.New("token has expired")
+	ErrTokenNotRevocable = errors.New("token has no ID to revoke")
 )
 
+// revokedTokenPurpose records the IDs of the revoked tokens as nonces, shared by the
+// replicas and removed once the tokens expire.
+const revokedTokenPurpose = "jwt-revoked"
+
+// authClock tells the time tokens are issued at, and tokens and API keys expire against.
+var authClock clock.Clock = clock.System
+
+// SetClock replaces the clock tokens and API keys expire against.
+func SetClock(c clock.Clock) {
+	authClock = c
+}
+
+// Now returns the time of the clock tokens and API keys expire against.
+func Now() time.Time {
+	return authClock.Now()
+}
+
+// jwtSecrets follows the rotations of the JWT secret, so that the tokens signed
+// before the last rotation stay valid until they expire.
+var jwtSecrets struct {
+	sync.Mutex
+	current, previous string
+}
+
+// jwtKeys returns the keys the tokens are verified with: the JWT secret and, after a
+// rotation, the secret before it.
+func jwtKeys() jwt.VerificationKeySet {
+	cfg := config.Live()
+	jwtSecrets.Lock()
+	defer jwtSecrets.Unlock()
+	if cfg.JWTSecret != jwtSecrets.current {
+		if jwtSecrets.current != "" {
+			jwtSecrets.previous = jwtSecrets.current
+			logging.Auth.JWT.Info("JWT secret rotated, the tokens signed with the previous secret stay valid")
+		}
+		jwtSecrets.current = cfg.JWTSecret
+	}
+	keys := jwt.VerificationKeySet{Keys: []jwt.VerificationKey{[]byte(jwtSecrets.current)}}
+	if jwtSecrets.previous != "" {
+		keys.Keys = append(keys.Keys, []byte(jwtSecrets.previous))
+	}
+	return keys
+}
+
 // Claims represents the JWT claims used for authentication
 type Claims struct {
 	UserID      int64  `json:"user_id"`
@@ -32,7 +83,14 @@ func GenerateJWT(userID int64, username, email string, permissions int64) (strin
 		"username", username,
 	).Debug("Generating JWT token")
 
-	expirationTime := time.Now().Add(time.Duration(config.JWTExpiryHours) * time.Hour)
+	now := authClock.Now()
+	expirationTime := now.Add(time.Duration(config.JWTExpiryHours) * time.Hour)
+
+	// The token ID is what logging out revokes
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", fmt.Errorf("failed to generate token ID: %w", err)
+	}
 
 	claims := &Claims{
 		UserID:      userID,
@@ -40,13 +98,14 @@ func GenerateJWT(userID int64, username, email string, permissions int64) (strin
 		Email:       email,
 		Permissions: permissions,
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        hex.EncodeToString(id),
 			ExpiresAt: jwt.NewNumericDate(expirationTime),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			IssuedAt:  jwt.NewNumericDate(now),
 		},
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString([]byte(config.JWTSecret))
+	tokenString, err := token.SignedString([]byte(config.Live().JWTSecret))
 
 	if err != nil {
 		logging.Auth.JWT.WithFields(
@@ -85,8 +144,9 @@ func ValidateJWT(tokenString string) (*Claims, error) {
 				).Warn("JWT validation failed: incorrect signing method")
 				return nil, errors.New(errMsg)
 			}
-			return []byte(config.JWTSecret), nil
+			return jwtKeys(), nil
 		},
+		jwt.WithTimeFunc(authClock.Now),
 	)
 
 	if err != nil {
@@ -118,3 +178,35 @@ func ValidateJWT(tokenString string) (*Claims, error) {
 
 	return claims, nil
 }
+
+// RevokeToken revokes a validated token until it expires, such as when its user logs
+// out. Tokens issued without an ID cannot be revoked and return ErrTokenNotRevocable.
+func RevokeToken(ctx context.Context, claims *Claims) error {
+	if claims.ID == "" {
+		return ErrTokenNotRevocable
+	}
+	expiresAt := authClock.Now()
+	if claims.ExpiresAt != nil {
+		expiresAt = claims.ExpiresAt.Time
+	}
+
+	err := database.GetDB().CreateNonce(ctx, revokedTokenPurpose, claims.ID, "", expiresAt)
+	if err != nil && !errors.Is(err, database.ErrNonceUsed) {
+		return fmt.Errorf("failed to revoke token: %w", err)
+	}
+
+	logging.Auth.JWT.WithContext(ctx).WithFields(
+		"user_id", claims.UserID,
+		"username", claims.Username,
+		"expires_at", expiresAt,
+	).Info("JWT token revoked")
+	return nil
+}
+
+// tokenRevoked tells whether a validated token was revoked.
+func tokenRevoked(ctx context.Context, claims *Claims) (bool, error) {
+	if claims.ID == "" {
+		return false, nil
+	}
+	return database.GetDB().NonceExists(ctx, revokedTokenPurpose, claims.ID)
+}
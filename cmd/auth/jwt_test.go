@@ -0,0 +1,57 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"minecharts/cmd/config"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestValidateJWTExpiry(t *testing.T) {
+	issuedAt := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	expiresAt := issuedAt.Add(time.Duration(config.JWTExpiryHours) * time.Hour)
+
+	setClock(t, issuedAt)
+	token, err := GenerateJWT(1, "alice", "alice@minecharts.local", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		now     time.Time
+		wantErr error
+	}{
+		{"just before", expiresAt.Add(-time.Second), nil},
+		{"at", expiresAt, ErrExpiredToken},
+		{"after", expiresAt.Add(time.Second), ErrExpiredToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setClock(t, tt.now)
+			claims, err := ValidateJWT(token)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ValidateJWT() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && claims.UserID != 1 {
+				t.Errorf("ValidateJWT() user ID = %d, want 1", claims.UserID)
+			}
+		})
+	}
+}
+
+func TestValidateJWTWithoutExpiry(t *testing.T) {
+	setClock(t, time.Date(2125, 6, 1, 12, 0, 0, 0, time.UTC))
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, &Claims{UserID: 1}).
+		SignedString([]byte(config.Live().JWTSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ValidateJWT(token); err != nil {
+		t.Errorf("ValidateJWT() error = %v, want a token without exp to stay valid", err)
+	}
+}
@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"minecharts/cmd/database"
+	"minecharts/cmd/logging"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestMain(m *testing.M) {
+	logging.Init()
+	logging.Logger.SetOutput(io.Discard)
+	gin.SetMode(gin.TestMode)
+	os.Exit(m.Run())
+}
+
+// fixedClock is a clock stopped at a time.
+type fixedClock time.Time
+
+func (c fixedClock) Now() time.Time {
+	return time.Time(c)
+}
+
+// setClock stops the clock of the package at now for the test.
+func setClock(t *testing.T, now time.Time) {
+	t.Helper()
+	previous := authClock
+	SetClock(fixedClock(now))
+	t.Cleanup(func() { SetClock(previous) })
+}
+
+// setupTest gives the test a throwaway SQLite database.
+func setupTest(t testing.TB) database.DB {
+	t.Helper()
+	db, err := database.NewSQLiteDB(filepath.Join(t.TempDir(), "minecharts.db"), database.PoolConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Init(); err != nil {
+		t.Fatal(err)
+	}
+	database.SetDB(db)
+	t.Cleanup(func() {
+		database.SetDB(nil)
+		db.Close()
+	})
+	return db
+}
+
+// createTestUser records an active user with the given permissions.
+func createTestUser(t testing.TB, db database.DB, username string, permissions int64) *database.User {
+	t.Helper()
+	user := &database.User{
+		Username:    username,
+		Email:       username + "@minecharts.local",
+		Permissions: permissions,
+		Active:      true,
+	}
+	if err := db.CreateUser(context.Background(), user); err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
@@ -6,18 +6,24 @@ package auth
 
 import (
 	"net/http"
+	"strconv"
 	"strings"
-	"time"
 
+	"minecharts/cmd/apierror"
 	"minecharts/cmd/database"
 	"minecharts/cmd/logging"
+	"minecharts/cmd/security"
 
 	"github.com/gin-gonic/gin"
 )
 
-// AuthUserKey is the key used to store authenticated user in the Gin context.
+// AuthUserKey is the key used to store authenticated user in the Gin context,
+// AuthClaimsKey the one of the claims of the JWT it authenticated with, and
+// ServerKey the one of the server record RequireServerPermission checked.
 const (
-	AuthUserKey = "auth_user"
+	AuthUserKey   = "auth_user"
+	AuthClaimsKey = "auth_claims"
+	ServerKey     = "auth_server"
 )
 
 // JWTMiddleware validates JWT tokens in the Authorization header.
@@ -28,24 +34,24 @@ func JWTMiddleware() gin.HandlerFunc {
 		// Get Authorization header
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
-			logging.Auth.JWT.WithFields(
+			logging.Auth.JWT.WithContext(c.Request.Context()).WithFields(
 				"path", c.Request.URL.Path,
 				"remote_ip", c.ClientIP(),
 				"error", "missing_auth_header",
 			).Warn("Authentication failed: missing Authorization header")
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authorization header is required"})
+			apierror.AbortWith(c, apierror.New(http.StatusUnauthorized, apierror.CodeMissingCredentials, "Authorization header is required"))
 			return
 		}
 
 		// Check for Bearer token format
 		parts := strings.SplitN(authHeader, " ", 2)
 		if !(len(parts) == 2 && parts[0] == "Bearer") {
-			logging.Auth.JWT.WithFields(
+			logging.Auth.JWT.WithContext(c.Request.Context()).WithFields(
 				"path", c.Request.URL.Path,
 				"remote_ip", c.ClientIP(),
 				"error", "invalid_auth_format",
 			).Warn("Authentication failed: invalid Authorization header format")
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authorization header format must be 'Bearer {token}'"})
+			apierror.AbortWith(c, apierror.New(http.StatusUnauthorized, apierror.CodeInvalidToken, "Authorization header format must be 'Bearer {token}'"))
 			return
 		}
 
@@ -53,25 +59,51 @@ func JWTMiddleware() gin.HandlerFunc {
 		claims, err := ValidateJWT(parts[1])
 		if err != nil {
 			if err == ErrExpiredToken {
-				logging.Auth.JWT.WithFields(
+				logging.Auth.SessionExpired.WithContext(c.Request.Context()).WithFields(
 					"path", c.Request.URL.Path,
 					"remote_ip", c.ClientIP(),
 					"error", "token_expired",
 				).Warn("Authentication failed: token expired")
-				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Token has expired"})
+				security.NewEvent(c, security.AuthFailure, "jwt_authentication").WithReason("token_expired").Emit()
+				apierror.AbortWith(c, apierror.New(http.StatusUnauthorized, apierror.CodeTokenExpired, "Token has expired"))
 				return
 			}
-			logging.Auth.JWT.WithFields(
+			logging.Auth.JWT.WithContext(c.Request.Context()).WithFields(
 				"path", c.Request.URL.Path,
 				"remote_ip", c.ClientIP(),
 				"error", "invalid_token",
 				"error_details", err.Error(),
 			).Warn("Authentication failed: invalid token")
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+			security.NewEvent(c, security.AuthFailure, "jwt_authentication").WithReason("invalid_token").Emit()
+			apierror.AbortWith(c, apierror.New(http.StatusUnauthorized, apierror.CodeInvalidToken, "Invalid token"))
 			return
 		}
 
-		logging.Auth.JWT.WithFields(
+		// Logged out tokens stop working before they expire
+		revoked, err := tokenRevoked(c.Request.Context(), claims)
+		if err != nil {
+			logging.Auth.JWT.WithContext(c.Request.Context()).WithFields(
+				"path", c.Request.URL.Path,
+				"user_id", claims.UserID,
+				"error", err.Error(),
+			).Error("Authentication failed: could not check token revocation")
+			apierror.Abort(c, http.StatusInternalServerError, "Failed to verify token")
+			return
+		}
+		if revoked {
+			logging.Auth.JWT.WithContext(c.Request.Context()).WithFields(
+				"path", c.Request.URL.Path,
+				"remote_ip", c.ClientIP(),
+				"user_id", claims.UserID,
+				"error", "token_revoked",
+			).Warn("Authentication failed: token revoked")
+			security.NewEvent(c, security.AuthFailure, "jwt_authentication").
+				WithUsername(claims.Username).WithReason("token_revoked").Emit()
+			apierror.AbortWith(c, apierror.New(http.StatusUnauthorized, apierror.CodeTokenRevoked, "Token has been revoked"))
+			return
+		}
+
+		logging.Auth.JWT.WithContext(c.Request.Context()).WithFields(
 			"path", c.Request.URL.Path,
 			"user_id", claims.UserID,
 			"username", claims.Username,
@@ -81,32 +113,40 @@ func JWTMiddleware() gin.HandlerFunc {
 		db := database.GetDB()
 		user, err := db.GetUserByID(c.Request.Context(), claims.UserID)
 		if err != nil {
-			logging.Auth.JWT.WithFields(
+			logging.Auth.JWT.WithContext(c.Request.Context()).WithFields(
 				"path", c.Request.URL.Path,
 				"user_id", claims.UserID,
 				"error", "user_not_found",
 				"error_details", err.Error(),
 			).Warn("Authentication failed: user not found")
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+			security.NewEvent(c, security.AuthFailure, "jwt_authentication").
+				WithUsername(claims.Username).WithReason("user_not_found").Emit()
+			apierror.AbortWith(c, apierror.New(http.StatusUnauthorized, apierror.CodeInvalidToken, "User not found"))
 			return
 		}
 
 		// Check if user is active
 		if !user.Active {
-			logging.Auth.Session.WithFields(
+			logging.Auth.Session.WithContext(c.Request.Context()).WithFields(
 				"path", c.Request.URL.Path,
 				"user_id", user.ID,
 				"username", user.Username,
 				"error", "account_inactive",
 			).Warn("Authentication failed: account inactive")
-			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "User account is inactive"})
+			security.NewEvent(c, security.AuthFailure, "jwt_authentication").WithUser(user).WithReason("account_inactive").Emit()
+			apierror.AbortWith(c, apierror.New(http.StatusForbidden, apierror.CodeAccountInactive, "User account is inactive"))
+			return
+		}
+
+		if !checkPasswordChange(c, user) {
 			return
 		}
 
 		// Set user in context for handlers to use
 		c.Set(AuthUserKey, user)
+		c.Set(AuthClaimsKey, claims)
 
-		logging.Auth.Session.WithFields(
+		logging.Auth.Session.WithContext(c.Request.Context()).WithFields(
 			"path", c.Request.URL.Path,
 			"user_id", user.ID,
 			"username", user.Username,
@@ -117,6 +157,19 @@ func JWTMiddleware() gin.HandlerFunc {
 	}
 }
 
+// JWTOrAPIKeyMiddleware authenticates requests with the JWT of their Authorization
+// header, and those without one with the API key of their X-API-Key header.
+func JWTOrAPIKeyMiddleware() gin.HandlerFunc {
+	jwtMiddleware, apiKeyMiddleware := JWTMiddleware(), APIKeyMiddleware()
+	return func(c *gin.Context) {
+		if c.GetHeader("Authorization") != "" {
+			jwtMiddleware(c)
+			return
+		}
+		apiKeyMiddleware(c)
+	}
+}
+
 // APIKeyMiddleware validates API key in the X-API-Key header.
 // It attempts API key authentication if JWT authentication hasn't already succeeded.
 func APIKeyMiddleware() gin.HandlerFunc {
@@ -130,12 +183,12 @@ func APIKeyMiddleware() gin.HandlerFunc {
 		// Get API key from header
 		apiKey := c.GetHeader("X-API-Key")
 		if apiKey == "" {
-			logging.API.InvalidRequest.WithFields(
+			logging.API.InvalidRequest.WithContext(c.Request.Context()).WithFields(
 				"path", c.Request.URL.Path,
 				"remote_ip", c.ClientIP(),
 				"error", "missing_api_key",
 			).Warn("API key authentication failed: missing API key")
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "API key is required"})
+			apierror.AbortWith(c, apierror.New(http.StatusUnauthorized, apierror.CodeMissingCredentials, "API key is required"))
 			return
 		}
 
@@ -143,65 +196,88 @@ func APIKeyMiddleware() gin.HandlerFunc {
 		db := database.GetDB()
 		key, err := db.GetAPIKey(c.Request.Context(), apiKey)
 		if err != nil {
-			logging.API.Keys.WithFields(
+			logging.API.Keys.WithContext(c.Request.Context()).WithFields(
 				"path", c.Request.URL.Path,
 				"remote_ip", c.ClientIP(),
 				"error", "invalid_api_key",
 				"error_details", err.Error(),
 			).Warn("API key authentication failed: invalid API key")
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid API key"})
+			security.NewEvent(c, security.AuthFailure, "api_key_authentication").WithReason("invalid_api_key").Emit()
+			apierror.AbortWith(c, apierror.New(http.StatusUnauthorized, apierror.CodeInvalidAPIKey, "Invalid API key"))
 			return
 		}
 
-		logging.API.Keys.WithFields(
+		logging.API.Keys.WithContext(c.Request.Context()).WithFields(
 			"path", c.Request.URL.Path,
 			"api_key_id", key.ID,
 			"user_id", key.UserID,
 		).Debug("API key validated")
 
 		// Check if API key is expired
-		if !key.ExpiresAt.IsZero() && key.ExpiresAt.Before(c.Request.Context().Value("now").(time.Time)) {
-			logging.API.Keys.WithFields(
+		if key.Expired(authClock.Now()) {
+			logging.API.Keys.WithContext(c.Request.Context()).WithFields(
 				"path", c.Request.URL.Path,
 				"api_key_id", key.ID,
 				"user_id", key.UserID,
 				"error", "expired_api_key",
 			).Warn("API key authentication failed: expired API key")
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "API key has expired"})
+			security.NewEvent(c, security.AuthFailure, "api_key_authentication").
+				WithReason("expired_api_key").WithDetail("api_key_id", strconv.FormatInt(key.ID, 10)).Emit()
+			apierror.AbortWith(c, apierror.New(http.StatusUnauthorized, apierror.CodeAPIKeyExpired, "API key has expired"))
 			return
 		}
 
 		// Get user associated with API key
 		user, err := db.GetUserByID(c.Request.Context(), key.UserID)
 		if err != nil {
-			logging.DB.WithFields(
+			logging.DB.WithContext(c.Request.Context()).WithFields(
 				"path", c.Request.URL.Path,
 				"api_key_id", key.ID,
 				"user_id", key.UserID,
 				"error", "user_not_found",
 				"error_details", err.Error(),
 			).Warn("API key authentication failed: user not found")
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+			security.NewEvent(c, security.AuthFailure, "api_key_authentication").WithReason("user_not_found").Emit()
+			apierror.AbortWith(c, apierror.New(http.StatusUnauthorized, apierror.CodeInvalidAPIKey, "User not found"))
 			return
 		}
 
 		// Check if user is active
 		if !user.Active {
-			logging.Auth.Session.WithFields(
+			logging.Auth.Session.WithContext(c.Request.Context()).WithFields(
 				"path", c.Request.URL.Path,
 				"api_key_id", key.ID,
 				"user_id", user.ID,
 				"username", user.Username,
 				"error", "account_inactive",
 			).Warn("API key authentication failed: account inactive")
-			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "User account is inactive"})
+			security.NewEvent(c, security.AuthFailure, "api_key_authentication").WithUser(user).WithReason("account_inactive").Emit()
+			apierror.AbortWith(c, apierror.New(http.StatusForbidden, apierror.CodeAccountInactive, "User account is inactive"))
+			return
+		}
+
+		if !checkPasswordChange(c, user) {
 			return
 		}
 
+		// Restrict the user to the scope of the key
+		user.ScopePermissions(key.Permissions)
+		if !checkAPIKeyServers(c, key) {
+			return
+		}
+
+		if err := db.TouchAPIKey(c.Request.Context(), key.ID); err != nil {
+			logging.API.Keys.WithContext(c.Request.Context()).WithFields(
+				"path", c.Request.URL.Path,
+				"api_key_id", key.ID,
+				"error", err.Error(),
+			).Warn("Failed to record API key use")
+		}
+
 		// Set user in context for handlers to use
 		c.Set(AuthUserKey, user)
 
-		logging.Auth.Session.WithFields(
+		logging.Auth.Session.WithContext(c.Request.Context()).WithFields(
 			"path", c.Request.URL.Path,
 			"api_key_id", key.ID,
 			"user_id", user.ID,
@@ -213,31 +289,86 @@ func APIKeyMiddleware() gin.HandlerFunc {
 	}
 }
 
+// checkAPIKeyServers refuses the requests of a key restricted to servers that are
+// not about one of them. Other servers get the same 404 as a server that does not
+// exist, and the routes not about a server a 403.
+func checkAPIKeyServers(c *gin.Context, key *database.APIKey) bool {
+	if len(key.ServerIDs) == 0 {
+		return true
+	}
+
+	serverName := c.Param("serverName")
+	if serverName == "" {
+		logging.API.Keys.WithContext(c.Request.Context()).WithFields(
+			"path", c.Request.URL.Path,
+			"api_key_id", key.ID,
+			"user_id", key.UserID,
+		).Warn("Request refused: API key restricted to servers")
+		apierror.AbortWith(c, apierror.New(http.StatusForbidden, apierror.CodePermissionDenied, "API key is restricted to servers"))
+		return false
+	}
+
+	server, err := database.GetDB().GetServerByName(c.Request.Context(), serverName)
+	if err != nil || !key.AllowsServer(server.ID) {
+		logging.API.Keys.WithContext(c.Request.Context()).WithFields(
+			"path", c.Request.URL.Path,
+			"api_key_id", key.ID,
+			"user_id", key.UserID,
+			"server_name", serverName,
+		).Warn("Request refused: server not allowed for API key")
+		apierror.AbortWith(c, apierror.New(http.StatusNotFound, apierror.CodeServerNotFound, serverNotFoundMessage))
+		return false
+	}
+	return true
+}
+
+// checkPasswordChange refuses every request except reading the profile and
+// changing the password while the user must change their password.
+func checkPasswordChange(c *gin.Context, user *database.User) bool {
+	if !user.PasswordChangeRequired {
+		return true
+	}
+
+	switch c.FullPath() {
+	case "/auth/me", "/auth/me/password", "/auth/logout":
+		return true
+	}
+
+	logging.Auth.Session.WithContext(c.Request.Context()).WithFields(
+		"path", c.Request.URL.Path,
+		"user_id", user.ID,
+		"username", user.Username,
+		"error", "password_change_required",
+	).Warn("Request refused: password change required")
+	apierror.AbortWith(c, apierror.New(http.StatusForbidden, apierror.CodePasswordChange, "Password change required"))
+	return false
+}
+
 // extractAuthenticatedUser extracts the user from the context and verifies authentication.
 // Returns the user and a boolean indicating if the extraction was successful.
 func extractAuthenticatedUser(c *gin.Context, permission int64) (*database.User, bool) {
 	// Get user from context
 	value, exists := c.Get(AuthUserKey)
 	if !exists {
-		logging.API.InvalidRequest.WithFields(
+		logging.API.InvalidRequest.WithContext(c.Request.Context()).WithFields(
 			"path", c.Request.URL.Path,
 			"remote_ip", c.ClientIP(),
 			"required_permission", permission,
 			"error", "not_authenticated",
 		).Warn("Permission check failed: user not authenticated")
-		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		apierror.AbortWith(c, apierror.New(http.StatusUnauthorized, apierror.CodeMissingCredentials, "Authentication required"))
 		return nil, false
 	}
 
 	user, ok := value.(*database.User)
-	if !ok {
-		logging.API.InvalidRequest.WithFields(
+	if !ok || user == nil {
+		logging.API.InvalidRequest.WithContext(c.Request.Context()).WithFields(
 			"path", c.Request.URL.Path,
 			"remote_ip", c.ClientIP(),
 			"required_permission", permission,
 			"error", "invalid_user_object",
 		).Error("Permission check failed: invalid user object in context")
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Invalid user object in context"})
+		apierror.Abort(c, http.StatusInternalServerError, "Invalid user object in context")
 		return nil, false
 	}
 
@@ -255,7 +386,7 @@ func RequirePermission(permission int64) gin.HandlerFunc {
 
 		// Check permission
 		if !user.HasPermission(permission) {
-			logging.Auth.Session.WithFields(
+			logging.Auth.Session.WithContext(c.Request.Context()).WithFields(
 				"path", c.Request.URL.Path,
 				"user_id", user.ID,
 				"username", user.Username,
@@ -264,11 +395,13 @@ func RequirePermission(permission int64) gin.HandlerFunc {
 				"user_permissions", user.Permissions,
 				"error", "permission_denied",
 			).Warn("Permission check failed: insufficient permissions")
-			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+			security.NewEvent(c, security.PermissionDenied, "require_permission").WithUser(user).
+				WithReason("insufficient_permissions").WithDetail("required_permission", strconv.FormatInt(permission, 10)).Emit()
+			apierror.AbortWith(c, apierror.New(http.StatusForbidden, apierror.CodePermissionDenied, "Permission denied"))
 			return
 		}
 
-		logging.Auth.Session.WithFields(
+		logging.Auth.Session.WithContext(c.Request.Context()).WithFields(
 			"path", c.Request.URL.Path,
 			"user_id", user.ID,
 			"username", user.Username,
@@ -279,7 +412,8 @@ func RequirePermission(permission int64) gin.HandlerFunc {
 	}
 }
 
-// RequireServerPermission checks if the user has permission for the specific server
+// RequireServerPermission checks if the user has permission for the specific server.
+// Refusals follow the DenyServerAccess policy: servers the user cannot see are reported as not found.
 func RequireServerPermission(permission int64) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		user, ok := extractAuthenticatedUser(c, permission)
@@ -292,7 +426,7 @@ func RequireServerPermission(permission int64) gin.HandlerFunc {
 		if serverName == "" {
 			// If no serverName, use standard permission check
 			if !user.HasPermission(permission) {
-				logging.Auth.Session.WithFields(
+				logging.Auth.Session.WithContext(c.Request.Context()).WithFields(
 					"path", c.Request.URL.Path,
 					"user_id", user.ID,
 					"username", user.Username,
@@ -300,7 +434,9 @@ func RequireServerPermission(permission int64) gin.HandlerFunc {
 					"permission", permission,
 					"error", "permission_denied",
 				).Warn("Permission check failed: insufficient permissions")
-				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+				security.NewEvent(c, security.PermissionDenied, "require_server_permission").WithUser(user).
+					WithReason("insufficient_permissions").WithDetail("required_permission", strconv.FormatInt(permission, 10)).Emit()
+				apierror.AbortWith(c, apierror.New(http.StatusForbidden, apierror.CodePermissionDenied, "Permission denied"))
 				return
 			}
 			c.Next()
@@ -313,7 +449,7 @@ func RequireServerPermission(permission int64) gin.HandlerFunc {
 		if err != nil {
 			// If server not found in DB but exists in K8s, default to standard permission check
 			if !user.HasPermission(permission) {
-				logging.Auth.Session.WithFields(
+				logging.Auth.Session.WithContext(c.Request.Context()).WithFields(
 					"path", c.Request.URL.Path,
 					"user_id", user.ID,
 					"username", user.Username,
@@ -321,16 +457,18 @@ func RequireServerPermission(permission int64) gin.HandlerFunc {
 					"server_name", serverName,
 					"error", "server_not_found_and_insufficient_permissions",
 				).Warn("Permission check failed: server not found and insufficient permissions")
-				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+				security.NewEvent(c, security.PermissionDenied, "require_server_permission").WithUser(user).WithTarget(serverName).
+					WithReason("insufficient_permissions").WithDetail("required_permission", strconv.FormatInt(permission, 10)).Emit()
+				DenyServerAccess(c, user, nil)
 				return
 			}
 			c.Next()
 			return
 		}
 
-		// Check permission with ownership logic
-		if !user.HasServerPermission(server.OwnerID, permission) {
-			logging.Auth.Session.WithFields(
+		// Check permission with ownership and membership logic
+		if !HasServerAccess(c.Request.Context(), user, server, permission) {
+			logging.Auth.Session.WithContext(c.Request.Context()).WithFields(
 				"path", c.Request.URL.Path,
 				"user_id", user.ID,
 				"username", user.Username,
@@ -339,10 +477,13 @@ func RequireServerPermission(permission int64) gin.HandlerFunc {
 				"server_owner_id", server.OwnerID,
 				"error", "insufficient_server_permissions",
 			).Warn("Server permission check failed")
-			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+			security.NewEvent(c, security.PermissionDenied, "require_server_permission").WithUser(user).WithTarget(serverName).
+				WithReason("insufficient_server_permissions").WithDetail("required_permission", strconv.FormatInt(permission, 10)).Emit()
+			DenyServerAccess(c, user, server)
 			return
 		}
 
+		c.Set(ServerKey, server)
 		c.Next()
 	}
 }
@@ -356,5 +497,47 @@ func GetCurrentUser(c *gin.Context) (*database.User, bool) {
 	}
 
 	user, ok := value.(*database.User)
-	return user, ok
+	return user, ok && user != nil
+}
+
+// GetCurrentClaims retrieves the claims of the JWT the request authenticated with.
+// There are none for the requests authenticated with an API key.
+func GetCurrentClaims(c *gin.Context) (*Claims, bool) {
+	value, exists := c.Get(AuthClaimsKey)
+	if !exists {
+		return nil, false
+	}
+
+	claims, ok := value.(*Claims)
+	return claims, ok && claims != nil
+}
+
+// GetCurrentServer retrieves the record of the server of the request, loaded by
+// RequireServerPermission. There is none for the servers without a record.
+func GetCurrentServer(c *gin.Context) (*database.MinecraftServer, bool) {
+	value, exists := c.Get(ServerKey)
+	if !exists {
+		return nil, false
+	}
+
+	server, ok := value.(*database.MinecraftServer)
+	return server, ok && server != nil
+}
+
+// RequireCurrentUser retrieves the authenticated user of a handler. When there is
+// none, as when a route is registered without the authentication middlewares, it
+// aborts the request with a 401 and returns false; the handler must then return.
+func RequireCurrentUser(c *gin.Context) (*database.User, bool) {
+	user, ok := GetCurrentUser(c)
+	if !ok {
+		logging.API.InvalidRequest.WithContext(c.Request.Context()).WithFields(
+			"path", c.Request.URL.Path,
+			"route", c.FullPath(),
+			"remote_ip", c.ClientIP(),
+			"error", "not_authenticated",
+		).Error("Handler reached without an authenticated user")
+		apierror.AbortWith(c, apierror.New(http.StatusUnauthorized, apierror.CodeMissingCredentials, "Authentication required"))
+		return nil, false
+	}
+	return user, true
 }
@@ -0,0 +1,71 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"minecharts/cmd/apierror"
+	"minecharts/cmd/database"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAPIKeyMiddlewareExpiry(t *testing.T) {
+	db := setupTest(t)
+	user := createTestUser(t, db, "alice", database.PermReadOnly)
+	expiresAt := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	expiring := &database.APIKey{UserID: user.ID, Key: "mc_expiring", ExpiresAt: &expiresAt}
+	permanent := &database.APIKey{UserID: user.ID, Key: "mc_permanent"}
+	for _, key := range []*database.APIKey{expiring, permanent} {
+		if err := db.CreateAPIKey(context.Background(), key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	router := gin.New()
+	router.GET("/servers", APIKeyMiddleware(), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	tests := []struct {
+		name     string
+		key      string
+		now      time.Time
+		want     int
+		wantCode string
+	}{
+		{"just before", expiring.Key, expiresAt.Add(-time.Second), http.StatusOK, ""},
+		{"at", expiring.Key, expiresAt, http.StatusUnauthorized, apierror.CodeAPIKeyExpired},
+		{"after", expiring.Key, expiresAt.Add(time.Hour), http.StatusUnauthorized, apierror.CodeAPIKeyExpired},
+		{"no expiry", permanent.Key, expiresAt.Add(100 * 365 * 24 * time.Hour), http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setClock(t, tt.now)
+			req := httptest.NewRequest(http.MethodGet, "/servers", nil)
+			req.Header.Set("X-API-Key", tt.key)
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body.String())
+			}
+			if tt.wantCode == "" {
+				return
+			}
+			var body struct {
+				Code string `json:"code"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if body.Code != tt.wantCode {
+				t.Errorf("code = %q, want %q", body.Code, tt.wantCode)
+			}
+		})
+	}
+}
@@ -4,16 +4,21 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	"minecharts/cmd/config"
 	"minecharts/cmd/database"
+	"minecharts/cmd/kubernetes"
 	"minecharts/cmd/logging"
 
 	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/endpoints"
 )
 
 var (
@@ -24,12 +29,63 @@ var (
 	ErrUnsupportedProvider   = errors.New("unsupported oauth provider")
 	ErrMissingProviderConfig = errors.New("missing oauth provider configuration")
 	ErrUserInfoRetrieval     = errors.New("failed to retrieve user information")
+	ErrOAuthAccountExists    = errors.New("an account with this email exists, link the provider to it")
+	ErrUsernameUnavailable   = errors.New("no username available for the OAuth user")
+	ErrSignupDisabled        = errors.New("accounts are created by invitation only")
+	ErrAccountInactive       = errors.New("user account is inactive")
 )
 
 // OAuthProvider represents an OAuth 2.0 provider
 type OAuthProvider struct {
 	Config *oauth2.Config
 	Name   string
+	// userInfo maps the user of the provider, fetched with an authorized client
+	userInfo func(ctx context.Context, client *http.Client) (*OAuthUserInfo, error)
+}
+
+// oauthProviders registers the OAuth providers by the name they are served under,
+// /auth/oauth/{provider}.
+var oauthProviders = map[string]struct {
+	enabled func() bool
+	create  func() (*OAuthProvider, error)
+}{
+	"authentik": {func() bool { return config.Live().AuthentikEnabled }, NewAuthentikProvider},
+	"github":    {func() bool { return config.Live().GitHubEnabled }, NewGitHubProvider},
+	"google":    {func() bool { return config.Live().GoogleEnabled }, NewGoogleProvider},
+}
+
+// GetOAuthProvider returns the OAuth provider registered under a name. It returns
+// ErrUnsupportedProvider for unknown names, and ErrOAuthNotEnabled when OAuth or
+// the provider is disabled.
+func GetOAuthProvider(name string) (*OAuthProvider, error) {
+	provider, ok := oauthProviders[name]
+	if !ok {
+		return nil, ErrUnsupportedProvider
+	}
+	if !config.Live().OAuthEnabled || !provider.enabled() {
+		return nil, ErrOAuthNotEnabled
+	}
+	return provider.create()
+}
+
+// checkProviderConfig fails with ErrMissingProviderConfig when a setting of a
+// provider is empty, logging which.
+func checkProviderConfig(provider string, settings map[string]string) error {
+	missing := []string{}
+	for name, value := range settings {
+		if value == "" {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	sort.Strings(missing)
+	logging.Auth.OAuth.WithFields(
+		"provider", provider,
+		"missing", strings.Join(missing, ", "),
+	).Error("OAuth provider configuration is incomplete")
+	return ErrMissingProviderConfig
 }
 
 // OAuthUserInfo contains user information from the OAuth provider
@@ -43,79 +99,139 @@ type OAuthUserInfo struct {
 	LastName      string
 	Picture       string
 	Provider      string
+	Groups        []string // Groups or roles reported by the provider, nil when it reports none
 }
 
 // NewAuthentikProvider creates a new OAuth provider for Authentik
 func NewAuthentikProvider() (*OAuthProvider, error) {
+	cfg := config.Live()
 	logging.Auth.OAuth.Debug("Initializing Authentik OAuth provider")
 
-	if !config.OAuthEnabled || !config.AuthentikEnabled {
+	if !cfg.OAuthEnabled || !cfg.AuthentikEnabled {
 		logging.Auth.OAuth.WithFields(
-			"oauth_enabled", config.OAuthEnabled,
-			"authentik_enabled", config.AuthentikEnabled,
+			"oauth_enabled", cfg.OAuthEnabled,
+			"authentik_enabled", cfg.AuthentikEnabled,
 		).Warn("Authentik OAuth is not enabled")
 		return nil, ErrOAuthNotEnabled
 	}
 
-	if config.AuthentikClientID == "" || config.AuthentikClientSecret == "" ||
-		config.AuthentikIssuer == "" || config.AuthentikRedirectURL == "" {
+	if cfg.AuthentikClientID == "" || cfg.AuthentikClientSecret == "" ||
+		cfg.AuthentikIssuer == "" || cfg.AuthentikRedirectURL == "" {
 		logging.Auth.OAuth.WithFields(
-			"client_id_set", config.AuthentikClientID != "",
-			"client_secret_set", config.AuthentikClientSecret != "",
-			"issuer_set", config.AuthentikIssuer != "",
-			"redirect_url_set", config.AuthentikRedirectURL != "",
+			"client_id_set", cfg.AuthentikClientID != "",
+			"client_secret_set", cfg.AuthentikClientSecret != "",
+			"issuer_set", cfg.AuthentikIssuer != "",
+			"redirect_url_set", cfg.AuthentikRedirectURL != "",
 		).Error("Authentik OAuth configuration is incomplete")
 		return nil, ErrMissingProviderConfig
 	}
 
 	// Construct OAuth2 config
 	oauthConfig := &oauth2.Config{
-		ClientID:     config.AuthentikClientID,
-		ClientSecret: config.AuthentikClientSecret,
-		RedirectURL:  config.AuthentikRedirectURL,
+		ClientID:     cfg.AuthentikClientID,
+		ClientSecret: cfg.AuthentikClientSecret,
+		RedirectURL:  cfg.AuthentikRedirectURL,
 		Scopes:       []string{"openid", "email", "profile"},
 		Endpoint: oauth2.Endpoint{
-			AuthURL:  config.AuthentikIssuer + "/oauth2/authorize",
-			TokenURL: config.AuthentikIssuer + "/oauth2/token",
+			AuthURL:  cfg.AuthentikIssuer + "/oauth2/authorize",
+			TokenURL: cfg.AuthentikIssuer + "/oauth2/token",
 		},
 	}
 
 	logging.Auth.OAuth.WithFields(
-		"issuer", config.AuthentikIssuer,
-		"redirect_url", config.AuthentikRedirectURL,
+		"issuer", cfg.AuthentikIssuer,
+		"redirect_url", cfg.AuthentikRedirectURL,
 	).Info("Authentik OAuth provider initialized successfully")
 
 	return &OAuthProvider{
-		Config: oauthConfig,
-		Name:   "authentik",
+		Config:   oauthConfig,
+		Name:     "authentik",
+		userInfo: authentikUserInfo,
+	}, nil
+}
+
+// NewGitHubProvider creates a new OAuth provider for GitHub
+func NewGitHubProvider() (*OAuthProvider, error) {
+	cfg := config.Live()
+	if err := checkProviderConfig("github", map[string]string{
+		"MINECHARTS_GITHUB_CLIENT_ID":     cfg.GitHubClientID,
+		"MINECHARTS_GITHUB_CLIENT_SECRET": cfg.GitHubClientSecret,
+		"MINECHARTS_GITHUB_REDIRECT_URL":  cfg.GitHubRedirectURL,
+	}); err != nil {
+		return nil, err
+	}
+
+	return &OAuthProvider{
+		Config: &oauth2.Config{
+			ClientID:     cfg.GitHubClientID,
+			ClientSecret: cfg.GitHubClientSecret,
+			RedirectURL:  cfg.GitHubRedirectURL,
+			Scopes:       []string{"read:user", "user:email"},
+			Endpoint:     endpoints.GitHub,
+		},
+		Name:     "github",
+		userInfo: githubUserInfo,
+	}, nil
+}
+
+// NewGoogleProvider creates a new OAuth provider for Google
+func NewGoogleProvider() (*OAuthProvider, error) {
+	cfg := config.Live()
+	if err := checkProviderConfig("google", map[string]string{
+		"MINECHARTS_GOOGLE_CLIENT_ID":     cfg.GoogleClientID,
+		"MINECHARTS_GOOGLE_CLIENT_SECRET": cfg.GoogleClientSecret,
+		"MINECHARTS_GOOGLE_REDIRECT_URL":  cfg.GoogleRedirectURL,
+	}); err != nil {
+		return nil, err
+	}
+
+	return &OAuthProvider{
+		Config: &oauth2.Config{
+			ClientID:     cfg.GoogleClientID,
+			ClientSecret: cfg.GoogleClientSecret,
+			RedirectURL:  cfg.GoogleRedirectURL,
+			Scopes:       []string{"openid", "email", "profile"},
+			Endpoint:     endpoints.Google,
+		},
+		Name:     "google",
+		userInfo: googleUserInfo,
 	}, nil
 }
 
-// GetAuthURL returns the URL to redirect the user to for authorization
-func (p *OAuthProvider) GetAuthURL(state string) string {
-	url := p.Config.AuthCodeURL(state, oauth2.AccessTypeOnline)
+// NewVerifier returns a random PKCE code verifier, kept by the API for the duration
+// of a flow.
+func NewVerifier() string {
+	return oauth2.GenerateVerifier()
+}
+
+// GetAuthURL returns the URL to redirect the user to for authorization, with the
+// PKCE challenge of the verifier of the flow
+func (p *OAuthProvider) GetAuthURL(state, verifier string) string {
+	url := p.Config.AuthCodeURL(state, oauth2.AccessTypeOnline, oauth2.S256ChallengeOption(verifier))
 
 	logging.Auth.OAuth.WithFields(
 		"url", url,
 		"state", state,
-	).Debug("Generated Authentik OAuth authorization URL")
+		"provider", p.Name,
+	).Debug("Generated OAuth authorization URL")
 
 	return url
 }
 
-// Exchange exchanges the authorization code for a token
-func (p *OAuthProvider) Exchange(ctx context.Context, code string) (*oauth2.Token, error) {
-	logging.Auth.OAuth.Debug("Exchanging OAuth code for token")
+// Exchange exchanges the authorization code for a token, proving with the PKCE
+// verifier that the flow started here
+func (p *OAuthProvider) Exchange(ctx context.Context, code, verifier string) (*oauth2.Token, error) {
+	logging.Auth.OAuth.WithContext(ctx).Debug("Exchanging OAuth code for token")
 
-	token, err := p.Config.Exchange(ctx, code)
+	token, err := p.Config.Exchange(ctx, code, oauth2.VerifierOption(verifier))
 	if err != nil {
-		logging.Auth.OAuth.WithFields(
+		logging.Auth.OAuth.WithContext(ctx).WithFields(
 			"error", err.Error(),
 		).Error("Failed to exchange OAuth code for token")
 		return nil, err
 	}
 
-	logging.Auth.OAuth.WithFields(
+	logging.Auth.OAuth.WithContext(ctx).WithFields(
 		"token_type", token.TokenType,
 		"expiry", token.Expiry,
 	).Debug("Successfully exchanged OAuth code for token")
@@ -125,26 +241,59 @@ func (p *OAuthProvider) Exchange(ctx context.Context, code string) (*oauth2.Toke
 
 // GetUserInfo retrieves user information from the OAuth provider
 func (p *OAuthProvider) GetUserInfo(ctx context.Context, token *oauth2.Token) (*OAuthUserInfo, error) {
-	logging.Auth.OAuth.Debug("Fetching user info from Authentik")
-
-	client := p.Config.Client(ctx, token)
+	logging.Auth.OAuth.WithContext(ctx).WithFields(
+		"provider", p.Name,
+	).Debug("Fetching user info from OAuth provider")
 
-	// Get user info from Authentik's userinfo endpoint
-	resp, err := client.Get(config.AuthentikIssuer + "/oauth2/userinfo")
+	userInfo, err := p.userInfo(ctx, p.Config.Client(ctx, token))
 	if err != nil {
 		return nil, err
 	}
+	userInfo.Provider = p.Name
+
+	logging.Auth.OAuth.WithContext(ctx).WithFields(
+		"provider", p.Name,
+		"username", userInfo.Username,
+	).Debug("Successfully retrieved user info from OAuth provider")
+
+	return userInfo, nil
+}
+
+// getJSON decodes the JSON answered by an endpoint of a provider.
+func getJSON(ctx context.Context, client *http.Client, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, ErrOAuthUserInfoFailed
+		return fmt.Errorf("%w: %s answered %s", ErrOAuthUserInfoFailed, url, resp.Status)
 	}
 
-	body, err := io.ReadAll(resp.Body)
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
 	if err != nil {
-		return nil, err
+		return err
+	}
+	return json.Unmarshal(body, v)
+}
+
+// usernameFromEmail derives a username from the local part of an email, or from the
+// subject of the user at the provider when there is no email.
+func usernameFromEmail(email, subject string) string {
+	if local, _, ok := strings.Cut(email, "@"); ok && local != "" {
+		return local
 	}
+	return "user_" + subject
+}
 
+// authentikUserInfo maps the user of the OpenID Connect userinfo endpoint of Authentik.
+func authentikUserInfo(ctx context.Context, client *http.Client) (*OAuthUserInfo, error) {
 	var userInfo struct {
 		Sub               string `json:"sub"`
 		Email             string `json:"email"`
@@ -152,7 +301,10 @@ func (p *OAuthProvider) GetUserInfo(ctx context.Context, token *oauth2.Token) (*
 		PreferredUsername string `json:"preferred_username"`
 		Name              string `json:"name"`
 	}
-
+	var body json.RawMessage
+	if err := getJSON(ctx, client, config.Live().AuthentikIssuer+"/oauth2/userinfo", &body); err != nil {
+		return nil, err
+	}
 	if err := json.Unmarshal(body, &userInfo); err != nil {
 		return nil, err
 	}
@@ -160,32 +312,99 @@ func (p *OAuthProvider) GetUserInfo(ctx context.Context, token *oauth2.Token) (*
 	// Use preferred_username or derive username from email if not provided
 	username := userInfo.PreferredUsername
 	if username == "" {
-		if userInfo.Email != "" {
-			parts := strings.Split(userInfo.Email, "@")
-			username = parts[0]
-		} else {
-			username = "user_" + userInfo.Sub
+		username = usernameFromEmail(userInfo.Email, userInfo.Sub)
+	}
+
+	return &OAuthUserInfo{
+		ID:            userInfo.Sub,
+		Email:         userInfo.Email,
+		Username:      username,
+		Name:          userInfo.Name,
+		EmailVerified: userInfo.EmailVerified,
+		Groups:        claimGroups(body),
+	}, nil
+}
+
+// githubUserInfo maps the GitHub user. Their public email may be hidden, the primary
+// one is then read from their emails with its verification.
+func githubUserInfo(ctx context.Context, client *http.Client) (*OAuthUserInfo, error) {
+	var user struct {
+		ID     int64  `json:"id"`
+		Login  string `json:"login"`
+		Name   string `json:"name"`
+		Email  string `json:"email"`
+		Avatar string `json:"avatar_url"`
+	}
+	if err := getJSON(ctx, client, "https://api.github.com/user", &user); err != nil {
+		return nil, err
+	}
+
+	userInfo := &OAuthUserInfo{
+		ID:       strconv.FormatInt(user.ID, 10),
+		Username: user.Login,
+		Name:     user.Name,
+		Picture:  user.Avatar,
+	}
+
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err := getJSON(ctx, client, "https://api.github.com/user/emails", &emails); err != nil {
+		logging.Auth.OAuth.WithContext(ctx).WithFields(
+			"provider", "github",
+			"error", err.Error(),
+		).Warn("Failed to read GitHub emails, using the public one unverified")
+		userInfo.Email = user.Email
+		return userInfo, nil
+	}
+	for _, email := range emails {
+		if email.Primary {
+			userInfo.Email, userInfo.EmailVerified = email.Email, email.Verified
 		}
 	}
+	return userInfo, nil
+}
 
-	logging.Auth.OAuth.WithFields(
-		"provider", "authentik",
-		"username", username,
-	).Debug("Successfully retrieved user info from Authentik")
+// googleUserInfo maps the user of the OpenID Connect userinfo endpoint of Google.
+func googleUserInfo(ctx context.Context, client *http.Client) (*OAuthUserInfo, error) {
+	var userInfo struct {
+		Sub           string `json:"sub"`
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+		Name          string `json:"name"`
+		GivenName     string `json:"given_name"`
+		FamilyName    string `json:"family_name"`
+		Picture       string `json:"picture"`
+	}
+	var body json.RawMessage
+	if err := getJSON(ctx, client, "https://openidconnect.googleapis.com/v1/userinfo", &body); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(body, &userInfo); err != nil {
+		return nil, err
+	}
 
 	return &OAuthUserInfo{
 		ID:            userInfo.Sub,
 		Email:         userInfo.Email,
-		Username:      username,
+		Username:      usernameFromEmail(userInfo.Email, userInfo.Sub),
 		Name:          userInfo.Name,
 		EmailVerified: userInfo.EmailVerified,
-		Provider:      "authentik",
+		FirstName:     userInfo.GivenName,
+		LastName:      userInfo.FamilyName,
+		Picture:       userInfo.Picture,
+		Groups:        claimGroups(body),
 	}, nil
 }
 
-// SyncOAuthUser creates or updates a user based on OAuth information
+// SyncOAuthUser returns the user linked to the account of the OAuth user, creating
+// one when the account is new. Accounts are only matched by their identity at the
+// provider: when their email belongs to an existing user, SyncOAuthUser returns
+// ErrOAuthAccountExists and the user has to link the account to theirs.
 func SyncOAuthUser(ctx context.Context, userInfo *OAuthUserInfo) (*database.User, error) {
-	logging.Auth.OAuth.WithFields(
+	logging.Auth.OAuth.WithContext(ctx).WithFields(
 		"provider", userInfo.Provider,
 		"username", userInfo.Username,
 		"email", userInfo.Email,
@@ -193,77 +412,220 @@ func SyncOAuthUser(ctx context.Context, userInfo *OAuthUserInfo) (*database.User
 
 	db := database.GetDB()
 
-	// Check if user exists by email
-	user, err := db.GetUserByUsername(ctx, userInfo.Username)
-
-	// If user doesn't exist, create one
-	if err == database.ErrUserNotFound {
-		// Generate a secure random password (user will login via OAuth)
-		randomPassword, err := GenerateRandomString(32)
+	// Check if the account is linked to a user
+	identity, err := db.GetOAuthIdentity(ctx, userInfo.Provider, userInfo.ID)
+	if err == nil {
+		user, err := db.GetUserByID(ctx, identity.UserID)
 		if err != nil {
-			logging.Auth.OAuth.WithFields(
+			logging.DB.WithContext(ctx).WithFields(
+				"user_id", identity.UserID,
+				"provider", userInfo.Provider,
 				"error", err.Error(),
-			).Error("Failed to generate random password for OAuth user")
+			).Error("Failed to get user linked to OAuth identity")
 			return nil, err
 		}
 
-		passwordHash, err := HashPassword(randomPassword)
-		if err != nil {
-			logging.Auth.OAuth.WithFields(
-				"error", err.Error(),
-			).Error("Failed to hash random password for OAuth user")
-			return nil, err
+		// Deactivated and deleted users cannot log in, as with a password
+		if !user.Active || user.DeletedAt != nil {
+			logging.Auth.OAuth.WithContext(ctx).WithFields(
+				"user_id", user.ID,
+				"username", user.Username,
+				"provider", userInfo.Provider,
+				"deleted", user.DeletedAt != nil,
+			).Warn("OAuth login refused: account inactive")
+			return user, ErrAccountInactive
 		}
 
-		// Create new user with read-only permissions by default
-		now := time.Now()
-		newUser := &database.User{
-			Username:     userInfo.Username,
-			Email:        userInfo.Email,
-			PasswordHash: passwordHash,
-			Permissions:  int64(database.PermReadOnly), // Default to read-only permissions
-			Active:       true,
-			LastLogin:    &now,
+		// The groups of the user at the provider drive their permissions
+		permissions, mapped := mappedPermissions(userInfo.Groups)
+		mapped = mapped && permissions != user.DirectPermissions
+		if mapped {
+			logging.Auth.OAuth.WithContext(ctx).WithFields(
+				"user_id", user.ID,
+				"username", user.Username,
+				"provider", userInfo.Provider,
+				"groups", strings.Join(userInfo.Groups, ","),
+				"old_permissions", user.DirectPermissions,
+				"new_permissions", permissions,
+			).Info("OAuth groups changed the permissions of user")
+			user.SetDirectPermissions(permissions)
 		}
 
-		if err := db.CreateUser(ctx, newUser); err != nil {
-			logging.DB.WithFields(
-				"username", userInfo.Username,
-				"email", userInfo.Email,
+		// Update last login time
+		now := time.Now()
+		user.LastLogin = &now
+		if err := db.UpdateUser(ctx, user); err != nil {
+			if mapped {
+				logging.DB.WithContext(ctx).WithFields(
+					"user_id", user.ID,
+					"username", user.Username,
+					"error", err.Error(),
+				).Error("Failed to update permissions of OAuth user")
+				return nil, err
+			}
+			logging.DB.WithContext(ctx).WithFields(
+				"user_id", user.ID,
+				"username", user.Username,
 				"error", err.Error(),
-			).Error("Failed to create user from OAuth information")
+			).Warn("Failed to update last login time for OAuth user")
+		}
+		if mapped {
+			// Permission changes change the servers the user can access
+			kubernetes.RequestWhitelistSync()
+		}
+
+		logging.Auth.OAuth.WithContext(ctx).WithFields(
+			"user_id", user.ID,
+			"username", user.Username,
+			"provider", userInfo.Provider,
+		).Info("Existing user logged in with linked OAuth identity")
+		return user, nil
+	}
+	if !errors.Is(err, database.ErrOAuthIdentityNotFound) {
+		logging.DB.WithContext(ctx).WithFields(
+			"provider", userInfo.Provider,
+			"error", err.Error(),
+		).Error("Database error while looking up OAuth identity")
+		return nil, err
+	}
+
+	// An existing user owns the email, the account is not theirs until they link it
+	if userInfo.Email != "" {
+		existing, err := db.GetUserByEmail(ctx, userInfo.Email)
+		if err == nil {
+			logging.Auth.OAuth.WithContext(ctx).WithFields(
+				"user_id", existing.ID,
+				"provider", userInfo.Provider,
+				"email_verified", userInfo.EmailVerified,
+			).Warn("OAuth account email belongs to an existing user, linking required")
+			return existing, ErrOAuthAccountExists
+		}
+		if !errors.Is(err, database.ErrUserNotFound) {
 			return nil, err
 		}
+	}
 
-		logging.Auth.OAuth.WithFields(
-			"user_id", newUser.ID,
-			"username", newUser.Username,
-		).Info("New user created from OAuth information")
-
-		return newUser, nil
-	} else if err != nil {
-		logging.DB.WithFields(
-			"username", userInfo.Username,
+	// Private deployments only get the accounts of their admins and invitations
+	if config.InvitationOnly {
+		logging.Auth.OAuth.WithContext(ctx).WithFields(
+			"provider", userInfo.Provider,
+			"email", userInfo.Email,
+		).Warn("OAuth login refused: accounts are created by invitation only")
+		return nil, ErrSignupDisabled
+	}
+
+	// Generate a secure random password (user will login via OAuth)
+	randomPassword, err := GenerateRandomString(32)
+	if err != nil {
+		logging.Auth.OAuth.WithContext(ctx).WithFields(
 			"error", err.Error(),
-		).Error("Database error while looking up user by username")
+		).Error("Failed to generate random password for OAuth user")
 		return nil, err
 	}
 
-	// Update last login time
+	passwordHash, err := HashPassword(randomPassword)
+	if err != nil {
+		logging.Auth.OAuth.WithContext(ctx).WithFields(
+			"error", err.Error(),
+		).Error("Failed to hash random password for OAuth user")
+		return nil, err
+	}
+
+	username, err := availableUsername(ctx, userInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create new user with read-only permissions by default, or those of their groups
+	permissions, mapped := mappedPermissions(userInfo.Groups)
+	if !mapped {
+		permissions = database.PermReadOnly
+	}
 	now := time.Now()
-	user.LastLogin = &now
-	if err := db.UpdateUser(ctx, user); err != nil {
-		logging.DB.WithFields(
-			"user_id", user.ID,
-			"username", user.Username,
+	newUser := &database.User{
+		Username:     username,
+		Email:        userInfo.Email,
+		PasswordHash: passwordHash,
+		Permissions:  permissions,
+		Active:       true,
+		LastLogin:    &now,
+	}
+
+	if err := db.CreateUser(ctx, newUser); err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"username", username,
+			"email", userInfo.Email,
 			"error", err.Error(),
-		).Warn("Failed to update last login time for OAuth user")
+		).Error("Failed to create user from OAuth information")
+		return nil, err
 	}
 
-	logging.Auth.OAuth.WithFields(
-		"user_id", user.ID,
-		"username", user.Username,
-	).Info("Existing user updated from OAuth information")
+	if err := LinkOAuthIdentity(ctx, newUser.ID, userInfo); err != nil {
+		// Without its identity the user could never log in again
+		if deleteErr := db.DeleteUser(ctx, newUser.ID); deleteErr != nil {
+			logging.DB.WithContext(ctx).WithFields(
+				"user_id", newUser.ID,
+				"error", deleteErr.Error(),
+			).Error("Failed to delete OAuth user left without identity")
+		}
+		return nil, err
+	}
+
+	logging.Auth.OAuth.WithContext(ctx).WithFields(
+		"user_id", newUser.ID,
+		"username", newUser.Username,
+		"provider", userInfo.Provider,
+	).Info("New user created from OAuth information")
 
-	return user, nil
+	return newUser, nil
+}
+
+// LinkOAuthIdentity links the account of the OAuth user to a user. It returns
+// database.ErrOAuthIdentityLinked when the account is linked already, or when the
+// user has another account of the provider linked.
+func LinkOAuthIdentity(ctx context.Context, userID int64, userInfo *OAuthUserInfo) error {
+	err := database.GetDB().CreateOAuthIdentity(ctx, &database.OAuthIdentity{
+		UserID:   userID,
+		Provider: userInfo.Provider,
+		Subject:  userInfo.ID,
+		Email:    userInfo.Email,
+	})
+	if err != nil {
+		return err
+	}
+
+	logging.Auth.OAuth.WithContext(ctx).WithFields(
+		"user_id", userID,
+		"provider", userInfo.Provider,
+		"oauth_username", userInfo.Username,
+	).Info("OAuth identity linked to user")
+	return nil
+}
+
+// availableUsername returns the username of the OAuth user, suffixed when another
+// user has it: usernames do not identify the accounts at the providers.
+func availableUsername(ctx context.Context, userInfo *OAuthUserInfo) (string, error) {
+	base := userInfo.Username
+	if base == "" {
+		base = "user_" + userInfo.ID
+	}
+	candidates := []string{base, base + "_" + userInfo.Provider}
+	for range 3 {
+		suffix, err := GenerateRandomString(4)
+		if err != nil {
+			return "", err
+		}
+		candidates = append(candidates, base+"_"+suffix)
+	}
+
+	for _, username := range candidates {
+		_, err := database.GetDB().GetUserByUsername(ctx, username)
+		if errors.Is(err, database.ErrUserNotFound) {
+			return username, nil
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	return "", ErrUsernameUnavailable
 }
@@ -0,0 +1,55 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"minecharts/cmd/database"
+)
+
+func TestSyncOAuthUserLinkedAccountState(t *testing.T) {
+	tests := []struct {
+		name    string
+		disable func(db database.DB, user *database.User) error
+		wantErr error
+	}{
+		{"active", nil, nil},
+		{"inactive", func(db database.DB, user *database.User) error {
+			user.Active = false
+			return db.UpdateUser(context.Background(), user)
+		}, ErrAccountInactive},
+		{"deleted", func(db database.DB, user *database.User) error {
+			return db.SoftDeleteUser(context.Background(), user.ID, time.Now())
+		}, ErrAccountInactive},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			db := setupTest(t)
+			user := createTestUser(t, db, "alice", database.PermReadOnly)
+			identity := &database.OAuthIdentity{UserID: user.ID, Provider: "github", Subject: "583231"}
+			if err := db.CreateOAuthIdentity(ctx, identity); err != nil {
+				t.Fatal(err)
+			}
+			if tt.disable != nil {
+				if err := tt.disable(db, user); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			synced, err := SyncOAuthUser(ctx, &OAuthUserInfo{ID: "583231", Username: "alice", Provider: "github"})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("SyncOAuthUser() error = %v, want %v", err, tt.wantErr)
+			}
+			if synced == nil || synced.ID != user.ID {
+				t.Fatalf("SyncOAuthUser() user = %+v, want the linked user %d", synced, user.ID)
+			}
+
+			if gotLogin := synced.LastLogin != nil; gotLogin != (tt.wantErr == nil) {
+				t.Errorf("last login updated = %v, want %v", gotLogin, tt.wantErr == nil)
+			}
+		})
+	}
+}
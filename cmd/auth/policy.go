@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+
+	"minecharts/cmd/apierror"
+	"minecharts/cmd/database"
+	"minecharts/cmd/logging"
+
+	"github.com/gin-gonic/gin"
+)
+
+// serverNotFoundMessage is the error returned for servers that do not exist.
+// It matches the response of kubernetes.CheckDeploymentExists so hidden and
+// missing servers cannot be told apart.
+const serverNotFoundMessage = "Deployment not found"
+
+// DenyServerAccess aborts a request refused by RequireServerPermission, or by the
+// permission checks of a handler.
+// Users who are not allowed to see the server get the same 404 as for a server
+// that does not exist, so other tenants' server names cannot be enumerated.
+// Users who can view the server but lack the required permission get a 403.
+func DenyServerAccess(c *gin.Context, user *database.User, server *database.MinecraftServer) {
+	canView := user.HasPermission(database.PermViewServer)
+	if server != nil {
+		canView = HasServerAccess(c.Request.Context(), user, server, database.PermViewServer)
+	}
+
+	if !canView {
+		logging.Auth.Session.WithContext(c.Request.Context()).WithFields(
+			"path", c.Request.URL.Path,
+			"user_id", user.ID,
+			"server_name", c.Param("serverName"),
+		).Debug("Hiding server from user without view permission")
+		apierror.AbortWith(c, apierror.New(http.StatusNotFound, apierror.CodeServerNotFound, serverNotFoundMessage))
+		return
+	}
+
+	apierror.AbortWith(c, apierror.New(http.StatusForbidden, apierror.CodePermissionDenied, "Permission denied"))
+}
+
+// HasServerAccess checks if the user has a permission on a server: as an admin, its
+// owner, with their global permissions, as a member the owner granted it to, or
+// with their role in the organization owning the server.
+func HasServerAccess(ctx context.Context, user *database.User, server *database.MinecraftServer, permission int64) bool {
+	if user.HasServerPermission(server.OwnerID, permission) {
+		return true
+	}
+	db := database.GetDB()
+	if member, err := db.GetServerMember(ctx, server.ID, user.ID); err == nil && user.Scoped(member.Permissions)&permission != 0 {
+		return true
+	}
+	if server.OrgID == 0 {
+		return false
+	}
+	orgMember, err := db.GetOrganizationMember(ctx, server.OrgID, user.ID)
+	return err == nil && user.Scoped(database.OrgRolePermissions[orgMember.Role])&permission != 0
+}
+
+// ManagesServer checks if the user manages a server, deciding who else can access
+// it: admins, its owner, and the admins and owners of the organization owning it.
+// API keys restricted to some permissions of their user only manage servers as admins.
+func ManagesServer(ctx context.Context, user *database.User, server *database.MinecraftServer) bool {
+	if user.IsAdmin() {
+		return true
+	}
+	if user.HasPermissionScope() {
+		return false
+	}
+	if server.OwnerID == user.ID {
+		return true
+	}
+	if server.OrgID == 0 {
+		return false
+	}
+	orgMember, err := database.GetDB().GetOrganizationMember(ctx, server.OrgID, user.ID)
+	return err == nil && orgMember.ManagesOrganization()
+}
+
+// ServerAccess checks the permissions of a user on many servers, reading their
+// memberships once.
+type ServerAccess struct {
+	user        *database.User
+	memberships map[string]int64
+}
+
+// LoadServerAccess reads the server memberships of a user.
+func LoadServerAccess(ctx context.Context, user *database.User) (*ServerAccess, error) {
+	memberships, err := database.GetDB().ListUserServerMemberships(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &ServerAccess{user: user, memberships: memberships}, nil
+}
+
+// Has checks if the user has a permission on the named server, as HasServerAccess.
+func (a *ServerAccess) Has(serverName string, ownerID int64, permission int64) bool {
+	return a.user.HasServerPermission(ownerID, permission) || a.user.Scoped(a.memberships[serverName])&permission != 0
+}
@@ -0,0 +1,69 @@
+package auth
+
+import (
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"minecharts/cmd/apierror"
+	"minecharts/cmd/database"
+	"minecharts/cmd/logging"
+
+	"github.com/gin-gonic/gin"
+)
+
+// setupComplete caches the setup state once the first user exists,
+// so the users table is only counted until setup has been completed.
+var setupComplete atomic.Bool
+
+// IsSetupComplete reports whether the initial admin account has been created.
+func IsSetupComplete(c *gin.Context) (bool, error) {
+	if setupComplete.Load() {
+		return true, nil
+	}
+
+	count, err := database.GetDB().CountUsers(c.Request.Context())
+	if err != nil {
+		return false, err
+	}
+	if count > 0 {
+		setupComplete.Store(true)
+		return true, nil
+	}
+	return false, nil
+}
+
+// RequireSetupComplete refuses to serve requests until the first-boot setup
+// has created the initial admin account. The setup, health check and
+// documentation endpoints stay available.
+func RequireSetupComplete() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		path := c.Request.URL.Path
+		if path == "/ping" || path == "/healthz" || path == "/readyz" || path == "/metrics" || strings.HasPrefix(path, "/setup") || strings.HasPrefix(path, "/swagger") {
+			c.Next()
+			return
+		}
+
+		complete, err := IsSetupComplete(c)
+		if err != nil {
+			logging.DB.WithContext(c.Request.Context()).WithFields(
+				"path", path,
+				"error", err.Error(),
+			).Error("Failed to check setup state")
+			apierror.Abort(c, http.StatusInternalServerError, "Failed to check setup state")
+			return
+		}
+		if !complete {
+			logging.API.InvalidRequest.WithContext(c.Request.Context()).WithFields(
+				"path", path,
+				"remote_ip", c.ClientIP(),
+				"error", "setup_required",
+			).Warn("Request refused: initial setup not completed")
+			apierror.AbortWith(c, apierror.New(http.StatusServiceUnavailable, apierror.CodeSetupRequired, "Initial setup required").
+				With("status", "/setup/status"))
+			return
+		}
+
+		c.Next()
+	}
+}
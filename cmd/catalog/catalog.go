@@ -0,0 +1,211 @@
+// Package catalog fetches the community server templates shared between minecharts
+// instances: the catalog index configured with MINECHARTS_TEMPLATE_CATALOG_URL, which
+// lists the templates by category with the checksum of their definition, and the
+// template definitions themselves, verified against their checksum.
+package catalog
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"minecharts/cmd/config"
+	"minecharts/cmd/logging"
+)
+
+// userAgent identifies the API to the catalogs.
+const userAgent = "ZenT0x/minecharts-api (https://github.com/ZenT0x/minecharts)"
+
+const (
+	// maxIndexSize bounds the size of a catalog index.
+	maxIndexSize = 1 << 20
+	// maxDefinitionSize bounds the size of a template definition.
+	maxDefinitionSize = 256 << 10
+)
+
+var (
+	// ErrDisabled is returned when no catalog is configured.
+	ErrDisabled = errors.New("no template catalog configured")
+	// ErrChecksumMismatch is returned for a definition that does not match its checksum.
+	ErrChecksumMismatch = errors.New("template definition does not match its checksum")
+	// ErrInvalidURL is returned for the template URLs that are not https.
+	ErrInvalidURL = errors.New("template URL must be an https URL")
+	// ErrInvalidChecksum is returned for the checksums that are not hex SHA-256 sums.
+	ErrInvalidChecksum = errors.New("sha256 must be the hex SHA-256 checksum of the template definition")
+)
+
+// Entry is a template listed in the catalog.
+type Entry struct {
+	Name        string `json:"name" example:"paper-survival"`
+	Description string `json:"description,omitempty" example:"Paper survival server with sensible defaults"`
+	Category    string `json:"category" example:"survival"`
+	URL         string `json:"url" example:"https://raw.githubusercontent.com/example/templates/main/paper-survival.json"`
+	SHA256      string `json:"sha256" example:"9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"`
+}
+
+// Index lists the templates of the catalog.
+type Index struct {
+	Templates  []Entry   `json:"templates"`
+	Categories []string  `json:"categories"`
+	FetchedAt  time.Time `json:"fetchedAt"`
+	Stale      bool      `json:"stale,omitempty"` // Served from an expired fetch because the catalog is unreachable
+}
+
+// Find returns the entry of a template by name.
+func (i *Index) Find(name string) (Entry, bool) {
+	for _, entry := range i.Templates {
+		if entry.Name == name {
+			return entry, true
+		}
+	}
+	return Entry{}, false
+}
+
+// cache holds the last index fetched.
+var cache struct {
+	sync.Mutex
+	index *Index
+}
+
+// Get returns the catalog index, fetched at most once every config.TemplateCatalogTTL.
+// When the catalog fails, the last index fetched is returned as stale, if any.
+func Get(ctx context.Context) (*Index, error) {
+	if config.TemplateCatalogURL == "" {
+		return nil, ErrDisabled
+	}
+
+	// One fetch at a time, the other requests wait for its result
+	cache.Lock()
+	defer cache.Unlock()
+	if cache.index != nil && time.Since(cache.index.FetchedAt) < config.TemplateCatalogTTL {
+		return cache.index, nil
+	}
+
+	index, err := fetchIndex(ctx, config.TemplateCatalogURL)
+	if err != nil {
+		if cache.index == nil {
+			return nil, err
+		}
+		logging.API.WithContext(ctx).WithFields(
+			"catalog", config.TemplateCatalogURL,
+			"error", err.Error(),
+		).Warn("Failed to refresh the template catalog, serving the last one")
+		stale := *cache.index
+		stale.Stale = true
+		return &stale, nil
+	}
+	cache.index = index
+	return index, nil
+}
+
+func fetchIndex(ctx context.Context, indexURL string) (*Index, error) {
+	data, err := fetch(ctx, indexURL, maxIndexSize)
+	if err != nil {
+		return nil, err
+	}
+	var index Index
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("failed to decode template catalog: %w", err)
+	}
+
+	// Entries without a valid URL and checksum cannot be imported and are left out
+	valid := make([]Entry, 0, len(index.Templates))
+	seen := make(map[string]bool)
+	for _, entry := range index.Templates {
+		if entry.Name == "" || checkURL(entry.URL) != nil || !validChecksum(entry.SHA256) {
+			logging.API.WithContext(ctx).WithFields(
+				"catalog", indexURL,
+				"template_name", entry.Name,
+			).Warn("Skipping invalid template catalog entry")
+			continue
+		}
+		entry.Category = strings.ToLower(strings.TrimSpace(entry.Category))
+		entry.SHA256 = strings.ToLower(entry.SHA256)
+		valid = append(valid, entry)
+		if !seen[entry.Category] {
+			seen[entry.Category] = true
+			index.Categories = append(index.Categories, entry.Category)
+		}
+	}
+	index.Templates = valid
+	if index.Categories == nil {
+		index.Categories = []string{}
+	}
+	index.FetchedAt = time.Now()
+	return &index, nil
+}
+
+// FetchDefinition downloads a template definition and verifies it against its
+// hex SHA-256 checksum, so that a changed or tampered file is never imported.
+func FetchDefinition(ctx context.Context, definitionURL, checksum string) ([]byte, error) {
+	if err := checkURL(definitionURL); err != nil {
+		return nil, err
+	}
+	if !validChecksum(checksum) {
+		return nil, ErrInvalidChecksum
+	}
+
+	data, err := fetch(ctx, definitionURL, maxDefinitionSize)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != strings.ToLower(checksum) {
+		return nil, ErrChecksumMismatch
+	}
+	return data, nil
+}
+
+// checkURL accepts the https URLs, and the http ones of local catalogs in dev mode.
+func checkURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" || (u.Scheme != "https" && !(u.Scheme == "http" && config.DevMode)) {
+		return ErrInvalidURL
+	}
+	return nil
+}
+
+func validChecksum(checksum string) bool {
+	decoded, err := hex.DecodeString(checksum)
+	return err == nil && len(decoded) == sha256.Size
+}
+
+// httpClient fetches the catalogs, which are small.
+var httpClient = &http.Client{Timeout: 15 * time.Second}
+
+func fetch(ctx context.Context, target string, limit int64) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create template catalog request: %w", err)
+	}
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %w", target, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch %s: %s", target, resp.Status)
+	}
+
+	var body bytes.Buffer
+	if _, err := io.Copy(&body, io.LimitReader(resp.Body, limit+1)); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", target, err)
+	}
+	if int64(body.Len()) > limit {
+		return nil, fmt.Errorf("%s is larger than %d bytes", target, limit)
+	}
+	return body.Bytes(), nil
+}
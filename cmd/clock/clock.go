@@ -0,0 +1,20 @@
+// Package clock tells the time to the code checking expiry, so that it can be given
+// another time than the system one, such as to test the expiry windows.
+package clock
+
+import "time"
+
+// Clock tells the current time.
+type Clock interface {
+	Now() time.Time
+}
+
+// System is the clock of the system.
+var System Clock = systemClock{}
+
+type systemClock struct{}
+
+// Now returns the current time of the system.
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
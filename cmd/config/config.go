@@ -1,69 +1,848 @@
+// Package config holds the configuration of the API. It is read from an optional
+// YAML or TOML file and from the MINECHARTS_ environment variables, which take
+// precedence, and validated before the API starts.
 package config
 
 import (
-	"os"
-	"strconv"
+	"reflect"
+	"sync/atomic"
+	"time"
 )
 
-// Global configuration variables, configurable via environment variables.
-var (
+// DefaultReplicas is the number of pods of the running servers.
+const DefaultReplicas = 1
+
+// DefaultJWTSecret is the JWT secret of the default configuration, refused outside
+// development mode.
+const DefaultJWTSecret = "your-secret-key-change-me-in-production"
+
+// Config is the configuration of the API. Each setting is read from the environment
+// variable of its env tag, and from the file key named after it: lowercase, without
+// the MINECHARTS_ prefix. Settings tagged reload are applied again on SIGHUP, and read
+// through Live rather than a package variable; settings tagged secret are redacted
+// from GET /admin/config.
+type Config struct {
+	// Development mode: in-memory fake cluster, throwaway SQLite database and demo data.
+	// Refused when running inside a cluster, never enable it in production.
+	DevMode bool `env:"MINECHARTS_DEV_MODE"`
+
 	// Server configuration
-	DefaultNamespace = getEnv("MINECHARTS_NAMESPACE", "minecharts")
-	DeploymentPrefix = getEnv("MINECHARTS_DEPLOYMENT_PREFIX", "minecraft-server-")
-	PVCSuffix        = getEnv("MINECHARTS_PVC_SUFFIX", "-pvc")
-	StorageSize      = getEnv("MINECHARTS_STORAGE_SIZE", "10Gi")
-	StorageClass     = getEnv("MINECHARTS_STORAGE_CLASS", "rook-ceph-block")
-	DefaultReplicas  = 1
+	DefaultNamespace string `env:"MINECHARTS_NAMESPACE"`
+	DeploymentPrefix string `env:"MINECHARTS_DEPLOYMENT_PREFIX"`
+	PVCSuffix        string `env:"MINECHARTS_PVC_SUFFIX"`
+	StorageSize      string `env:"MINECHARTS_STORAGE_SIZE"`
+	StorageClass     string `env:"MINECHARTS_STORAGE_CLASS"`
+	StorageClasses   string `env:"MINECHARTS_STORAGE_CLASSES"`                                                  // Comma separated storage classes the servers may pick; empty allows only the default
+	StorageSizes     string `env:"MINECHARTS_STORAGE_SIZES"`                                                    // Comma separated storage sizes the servers may pick; empty allows any
+	StorageDriver    string `env:"MINECHARTS_STORAGE_DRIVER" validate:"oneof=pvc nfs-subdir longhorn hostpath"` // Possible values: pvc, nfs-subdir, longhorn, hostpath
+	StorageOptions   string `env:"MINECHARTS_STORAGE_OPTIONS"`                                                  // Driver options as key=value pairs, e.g., storageClass=longhorn,node=worker-1
+	ServerImage      string `env:"MINECHARTS_SERVER_IMAGE"`                                                     // Image of the Minecraft server containers
+
+	BedrockServerImage string `env:"MINECHARTS_BEDROCK_SERVER_IMAGE"` // Image of the containers of the bedrock servers
+
+	// Proxy configuration
+	ProxyDeploymentPrefix string `env:"MINECHARTS_PROXY_DEPLOYMENT_PREFIX"`
+	ProxyImage            string `env:"MINECHARTS_PROXY_IMAGE"`  // Image of the Velocity proxy containers
+	ProxyMemory           string `env:"MINECHARTS_PROXY_MEMORY"` // JVM heap of the proxies
+
+	// Tenant namespace configuration
+	TenantNamespaces    bool   `env:"MINECHARTS_TENANT_NAMESPACES"`                  // Creates the servers of each user in a namespace of their own, minecharts-user-<id>, with a quota, default limits and a network policy; false creates them in MINECHARTS_NAMESPACE
+	TenantQuotaCPU      string `env:"MINECHARTS_TENANT_QUOTA_CPU"`                   // CPU the pods of a tenant namespace may request in total; empty is unlimited
+	TenantQuotaMemory   string `env:"MINECHARTS_TENANT_QUOTA_MEMORY"`                // Memory limit of the pods of a tenant namespace in total; empty is unlimited
+	TenantQuotaStorage  string `env:"MINECHARTS_TENANT_QUOTA_STORAGE"`               // Storage the volumes of a tenant namespace may request in total; empty is unlimited
+	TenantQuotaPods     int    `env:"MINECHARTS_TENANT_QUOTA_PODS" validate:"min=0"` // Pods of a tenant namespace at most; 0 is unlimited
+	TenantDefaultCPU    string `env:"MINECHARTS_TENANT_DEFAULT_CPU"`                 // CPU request of the containers of a tenant namespace that set none
+	TenantDefaultMemory string `env:"MINECHARTS_TENANT_DEFAULT_MEMORY"`              // Memory request and limit of the containers of a tenant namespace that set none
+
+	// Server network policy configuration
+	NetworkPolicies             bool   `env:"MINECHARTS_NETWORK_POLICIES"`               // Creates a NetworkPolicy with each new server, restricting who reaches its pod; the expose endpoint turns it on or off per server
+	NetworkPolicyEgress         string `env:"MINECHARTS_NETWORK_POLICY_EGRESS"`          // Comma separated CIDRs the server pods may connect to, besides DNS; empty allows DNS only
+	NetworkPolicyAPISelector    string `env:"MINECHARTS_NETWORK_POLICY_API_SELECTOR"`    // Label selector of the API pods in MINECHARTS_NAMESPACE, which reach every port of the servers
+	NetworkPolicyRouterSelector string `env:"MINECHARTS_NETWORK_POLICY_ROUTER_SELECTOR"` // Label selector of the mc-router pods, in any namespace, which reach the game port
+
+	// Server scheduling configuration
+	PriorityClasses string `env:"MINECHARTS_PRIORITY_CLASSES"` // Comma separated PriorityClasses the servers may be given; empty allows none
+
+	// JVM memory configuration
+	MemoryHeadroomPercent int    `env:"MINECHARTS_MEMORY_HEADROOM_PERCENT" validate:"min=0,max=90"`          // Share of the container memory limit left out of the JVM heap, for metaspace, threads and native memory; 0 disables the check
+	MemoryHeadroomMode    string `env:"MINECHARTS_MEMORY_HEADROOM_MODE" validate:"oneof=adjust warn reject"` // What to do with larger heaps. Possible values: adjust (lower the heap), warn, reject
 
 	// Database configuration
-	DatabaseType             = getEnv("MINECHARTS_DB_TYPE", "sqlite")                         // "sqlite" or "postgres"
-	DatabaseConnectionString = getEnv("MINECHARTS_DB_CONNECTION", "./app/data/minecharts.db") // File path for SQLite or connection string for Postgres
+	DatabaseType                    string        `env:"MINECHARTS_DB_TYPE" validate:"oneof=sqlite postgres"`        // "sqlite" or "postgres"
+	DatabaseConnectionString        string        `env:"MINECHARTS_DB_CONNECTION" validate:"required" secret:"true"` // File path for SQLite or connection string for Postgres
+	DatabaseReplicaConnectionString string        `env:"MINECHARTS_DB_REPLICA_CONNECTION" secret:"true"`             // Connection string of a Postgres read replica serving the lists, statuses and metrics; empty reads everything from the primary
+	DatabaseMaxOpenConns            int           `env:"MINECHARTS_DB_MAX_OPEN_CONNS" validate:"min=0"`              // Connections each pool opens at most, the primary and the replica each; 0 is unlimited
+	DatabaseMaxIdleConns            int           `env:"MINECHARTS_DB_MAX_IDLE_CONNS" validate:"min=0"`              // Connections each pool keeps open while idle
+	DatabaseConnMaxLifetime         time.Duration `env:"MINECHARTS_DB_CONN_MAX_LIFETIME" validate:"min=0"`           // Age at which connections are closed and opened again, e.g., to follow a failover; 0 keeps them
+	DatabasePingInterval            time.Duration `env:"MINECHARTS_DB_PING_INTERVAL" validate:"min=0"`               // How often the database is pinged for the metrics and the logs; 0 disables the pings
+
+	// Redis configuration
+	RedisURL string `env:"MINECHARTS_REDIS_URL" validate:"omitempty,url" secret:"true"` // redis://[:password@]host[:port][/db] shared by the replicas: relays the events between them, and keeps the rate limits and the cache unless they set their own; empty keeps each replica on its own
+
+	// Cache configuration, for the user and API key lookups authenticating each request
+	CacheTTL            time.Duration `env:"MINECHARTS_CACHE_TTL" validate:"min=0"`                             // How long a user or API key is served from the cache; 0 reads them from the database on each request
+	CacheRedisURL       string        `env:"MINECHARTS_CACHE_REDIS_URL" validate:"omitempty,url" secret:"true"` // redis://[:password@]host[:port][/db] sharing the cache between the replicas, so that they all see the changes at once; empty uses MINECHARTS_REDIS_URL, or caches in the memory of each replica without it
+	APIKeyTouchInterval time.Duration `env:"MINECHARTS_API_KEY_TOUCH_INTERVAL" validate:"min=0"`                // How often the last use times of the API keys are written, in one batch; 0 writes them on each request
 
 	// Authentication configuration
-	JWTSecret      = getEnv("MINECHARTS_JWT_SECRET", "your-secret-key-change-me-in-production")
-	JWTExpiryHours = getEnvInt("MINECHARTS_JWT_EXPIRY_HOURS", 24)
-	APIKeyPrefix   = getEnv("MINECHARTS_API_KEY_PREFIX", "mcapi")
+	JWTSecret      string `env:"MINECHARTS_JWT_SECRET" validate:"required" reload:"true" secret:"true"`
+	JWTExpiryHours int    `env:"MINECHARTS_JWT_EXPIRY_HOURS" validate:"gt=0"`
+	APIKeyPrefix   string `env:"MINECHARTS_API_KEY_PREFIX"`
+
+	// Secret provider configuration, for the secret settings set to a file: or vault: reference
+	SecretsRefreshInterval time.Duration `env:"MINECHARTS_SECRETS_REFRESH_INTERVAL" validate:"min=0"` // How often the referenced secrets are read again to follow their rotations; 0 reads them once
+	VaultAddr              string        `env:"MINECHARTS_VAULT_ADDR" validate:"omitempty,url"`       // e.g., https://vault.example.com:8200
+	VaultToken             string        `env:"MINECHARTS_VAULT_TOKEN" secret:"true"`                 // Token read with; empty logs in with the service account of the pod
+	VaultRole              string        `env:"MINECHARTS_VAULT_ROLE"`                                // Role of the Kubernetes auth method the service account logs in to
+	VaultAuthPath          string        `env:"MINECHARTS_VAULT_AUTH_PATH"`                           // Mount path of the Kubernetes auth method
+
+	// Route exposure configuration, disabled routes answer 404
+	RegistrationEnabled bool   `env:"MINECHARTS_REGISTRATION_ENABLED"`                      // Self-registration with POST /auth/register
+	OAuthRoutesEnabled  bool   `env:"MINECHARTS_OAUTH_ROUTES_ENABLED"`                      // OAuth login and callback endpoints
+	PublicStatusEnabled bool   `env:"MINECHARTS_PUBLIC_STATUS_ENABLED"`                     // Unauthenticated /ping and /setup/status; the /healthz and /readyz probes stay
+	SwaggerEnabled      bool   `env:"MINECHARTS_SWAGGER_ENABLED"`                           // API documentation at /swagger
+	MetricsEnabled      bool   `env:"MINECHARTS_METRICS_ENABLED"`                           // Prometheus metrics at /metrics
+	MetricsToken        string `env:"MINECHARTS_METRICS_TOKEN" reload:"true" secret:"true"` // Bearer token scrapers must send to /metrics; empty leaves it open
+
+	// Account configuration
+	InvitationOnly          bool          `env:"MINECHARTS_INVITATION_ONLY"`                            // Only admins and their invitations create accounts: no POST /auth/register, and first OAuth logins are refused
+	InvitationTTL           time.Duration `env:"MINECHARTS_INVITATION_TTL" validate:"gt=0"`             // How long the signup link of an invitation stays valid
+	UserDeletionGracePeriod time.Duration `env:"MINECHARTS_USER_DELETION_GRACE_PERIOD" validate:"gt=0"` // How long deleted users can be restored before they are purged
+
+	// Email configuration, email is disabled without an SMTP host
+	SMTPHost     string `env:"MINECHARTS_SMTP_HOST"`
+	SMTPPort     int    `env:"MINECHARTS_SMTP_PORT" validate:"min=1,max=65535"`
+	SMTPUsername string `env:"MINECHARTS_SMTP_USERNAME"` // Empty sends the emails without authentication
+	SMTPPassword string `env:"MINECHARTS_SMTP_PASSWORD" reload:"true" secret:"true"`
+	SMTPFrom     string `env:"MINECHARTS_SMTP_FROM"` // Sender of the emails
+
+	// Server approval configuration
+	RequireServerApproval bool `env:"MINECHARTS_REQUIRE_SERVER_APPROVAL"` // Non-admin server creations must be approved by an admin
+
+	// Security event stream configuration
+	SecurityEventsSink   string `env:"MINECHARTS_SECURITY_EVENTS_SINK" secret:"true"`               // e.g., syslog://siem:514, syslog+tcp://siem:601 or https://siem/events; empty disables the stream
+	SecurityEventsFormat string `env:"MINECHARTS_SECURITY_EVENTS_FORMAT" validate:"oneof=json cef"` // Possible values: json, cef
+
+	// Outgoing webhook configuration
+	WebhookURLs   string `env:"MINECHARTS_WEBHOOK_URLS" secret:"true"`   // Comma separated http(s) endpoints notified of the server incidents; empty disables the webhooks
+	WebhookSecret string `env:"MINECHARTS_WEBHOOK_SECRET" secret:"true"` // Secret the deliveries are signed with, as for the wake-up webhook; empty sends them unsigned
+
+	// Error reporting configuration
+	ErrorReportingDSN         string `env:"MINECHARTS_ERROR_REPORTING_DSN" secret:"true"` // Sentry or GlitchTip DSN, e.g., https://<key>@glitchtip.example.com/1; empty disables reporting
+	ErrorReportingEnvironment string `env:"MINECHARTS_ERROR_REPORTING_ENVIRONMENT"`       // Environment the reports are filed under
+
+	// Tracing configuration
+	TracingEndpoint      string `env:"MINECHARTS_OTLP_ENDPOINT"`                                   // OTLP/HTTP collector, e.g., http://otel-collector:4318; empty disables tracing
+	TracingServiceName   string `env:"MINECHARTS_TRACING_SERVICE_NAME"`                            // service.name of the exported spans
+	TracingSamplePercent int    `env:"MINECHARTS_TRACING_SAMPLE_PERCENT" validate:"min=0,max=100"` // Share of the traces started by the API that are recorded; traces continued from a caller follow its decision
+
+	// Request timeout configuration
+	RequestTimeout time.Duration `env:"MINECHARTS_REQUEST_TIMEOUT" validate:"gt=0"` // Default budget of API requests
+	ExecTimeout    time.Duration `env:"MINECHARTS_EXEC_TIMEOUT" validate:"gt=0"`    // Budget of requests that run commands in the server pod
+
+	// Shutdown configuration
+	ShutdownTimeout time.Duration `env:"MINECHARTS_SHUTDOWN_TIMEOUT" validate:"gt=0"` // How long the in-flight requests and background jobs have to finish on SIGTERM, below the termination grace period of the pod
+
+	// Response cache configuration
+	ResponseCacheTTL time.Duration `env:"MINECHARTS_RESPONSE_CACHE_TTL" validate:"min=0"` // How long the permissions map and the template list are served from memory; 0 disables the cache
+
+	// Batch status configuration
+	BatchStatusConcurrency int `env:"MINECHARTS_BATCH_STATUS_CONCURRENCY" validate:"gt=0"` // Servers queried at the same time by the batch status endpoint
+
+	// Rollout configuration
+	RolloutStrategy string        `env:"MINECHARTS_ROLLOUT_STRATEGY" validate:"oneof=recreate wait-for-ready"` // Default strategy of the restarts. Possible values: recreate, wait-for-ready
+	RolloutTimeout  time.Duration `env:"MINECHARTS_ROLLOUT_TIMEOUT" validate:"gt=0"`                           // How long a rollout may take before it is reported failed, and rolled back with wait-for-ready
+
+	// Server probe configuration, the probes run the health check of the image in the server containers
+	ProbePeriod               time.Duration `env:"MINECHARTS_PROBE_PERIOD" validate:"gt=0"`                // Interval of the health checks of the servers
+	ProbeTimeout              time.Duration `env:"MINECHARTS_PROBE_TIMEOUT" validate:"gt=0"`               // How long a health check may take before it counts as failed
+	StartupProbeTimeout       time.Duration `env:"MINECHARTS_STARTUP_PROBE_TIMEOUT" validate:"gt=0"`       // How long a server has to load its world before it is restarted
+	LivenessFailureThreshold  int           `env:"MINECHARTS_LIVENESS_FAILURE_THRESHOLD" validate:"min=0"` // Failed health checks in a row before a hung server is restarted; 0 disables the liveness probe
+	ReadinessFailureThreshold int           `env:"MINECHARTS_READINESS_FAILURE_THRESHOLD" validate:"gt=0"` // Failed health checks in a row before a server is taken out of its service endpoints
+
+	// Server workload configuration
+	StatefulSets bool `env:"MINECHARTS_STATEFULSETS"` // Lets servers be created as StatefulSets, which needs the statefulsets permissions of rbac.yaml
+
+	// Kubernetes API circuit breaker configuration
+	KubernetesBreakerThreshold int           `env:"MINECHARTS_K8S_BREAKER_THRESHOLD" validate:"gt=0"` // Consecutive API server errors before the breaker opens
+	KubernetesBreakerCooldown  time.Duration `env:"MINECHARTS_K8S_BREAKER_COOLDOWN" validate:"gt=0"`  // Time before a request probes the API server again
+
+	// Rate limiting configuration, as requests/period; an empty limit is disabled
+	RateLimit         string `env:"MINECHARTS_RATE_LIMIT" reload:"true"`           // Requests each user, or each address without a token, may make, refilled evenly over the period
+	AuthRateLimit     string `env:"MINECHARTS_AUTH_RATE_LIMIT" reload:"true"`      // Stricter limit of the logins, registrations and other credential checks, per address or user
+	ExecRateLimit     string `env:"MINECHARTS_EXEC_RATE_LIMIT" reload:"true"`      // Stricter limit of the commands run in the servers, per user
+	RateLimitRedisURL string `env:"MINECHARTS_RATE_LIMIT_REDIS_URL" secret:"true"` // redis://[:password@]host[:port][/db] sharing the buckets between the replicas; empty uses MINECHARTS_REDIS_URL, or keeps them in the memory of each replica without it
+
+	// Client address configuration
+	TrustedProxies string `env:"MINECHARTS_TRUSTED_PROXIES"` // Comma separated addresses or CIDRs of the reverse proxies whose X-Forwarded-For and X-Real-IP headers are trusted; empty trusts none
+
+	// Reconciliation configuration
+	ReconcileInterval     time.Duration `env:"MINECHARTS_RECONCILE_INTERVAL" validate:"gt=0"`      // How often deployments are reconciled with the server records
+	StatusChangeRetention time.Duration `env:"MINECHARTS_STATUS_CHANGE_RETENTION" validate:"gt=0"` // How long server status changes are kept for delta queries of the server list
+	StartupCheck          bool          `env:"MINECHARTS_STARTUP_CHECK"`                           // Whether the records are compared with the cluster at startup, to report the drift accumulated while the API was down
+	StartupRepair         string        `env:"MINECHARTS_STARTUP_REPAIR"`                          // Repairs of the drift made at startup, comma separated: statuses, orphans, volumes; empty only reports it
+
+	// Event stream configuration
+	EventsPollInterval time.Duration `env:"MINECHARTS_EVENTS_POLL_INTERVAL" validate:"gt=0"` // How often the status change log is polled for the event streams while clients are connected
+
+	// Server clone configuration
+	CloneImage   string        `env:"MINECHARTS_CLONE_IMAGE"`                   // Image of the jobs copying and archiving server data
+	CloneTimeout time.Duration `env:"MINECHARTS_CLONE_TIMEOUT" validate:"gt=0"` // How long the data copy of a clone may take
+
+	// Image pre-pull configuration
+	PrePullEnabled    bool   `env:"MINECHARTS_PREPULL_ENABLED"`     // Keep a DaemonSet pulling the server images on every node, and pull again when templates get a new version
+	PrePullImages     string `env:"MINECHARTS_PREPULL_IMAGES"`      // Extra images pulled along the server image, comma separated, e.g., itzg/minecraft-server:java8
+	PrePullPauseImage string `env:"MINECHARTS_PREPULL_PAUSE_IMAGE"` // Image the pre-pull pods idle with once the images are pulled
+
+	// World upload and export configuration
+	WorldUploadMaxBytes int           `env:"MINECHARTS_WORLD_UPLOAD_MAX_BYTES" validate:"gt=0"` // Largest world archive accepted by the upload endpoint
+	WorldUploadTimeout  time.Duration `env:"MINECHARTS_WORLD_UPLOAD_TIMEOUT" validate:"gt=0"`   // How long the upload and extraction of a world archive may take
+	WorldExportTimeout  time.Duration `env:"MINECHARTS_WORLD_EXPORT_TIMEOUT" validate:"gt=0"`   // How long the packaging and download of a world archive may take
+
+	// Plugin and mod installation configuration
+	ModrinthURL          string        `env:"MINECHARTS_MODRINTH_URL" validate:"url"`            // Modrinth API the plugins and mods are installed from
+	PluginInstallTimeout time.Duration `env:"MINECHARTS_PLUGIN_INSTALL_TIMEOUT" validate:"gt=0"` // How long the download and installation of a plugin may take
+
+	// Version manifest configuration
+	MojangVersionManifestURL string        `env:"MINECHARTS_MOJANG_VERSION_MANIFEST_URL" validate:"url"` // Versions of the vanilla servers
+	PaperAPIURL              string        `env:"MINECHARTS_PAPER_API_URL" validate:"url"`               // PaperMC API listing the versions of the paper servers
+	FabricMetaURL            string        `env:"MINECHARTS_FABRIC_META_URL" validate:"url"`             // Fabric Meta API listing the versions of the fabric servers
+	VersionManifestTTL       time.Duration `env:"MINECHARTS_VERSION_MANIFEST_TTL" validate:"gt=0"`       // How long the fetched version manifests are kept
+
+	// Template catalog configuration
+	TemplateCatalogURL string        `env:"MINECHARTS_TEMPLATE_CATALOG_URL" validate:"omitempty,url"` // Index of the community templates listed by GET /templates/catalog, the catalog is disabled when empty
+	TemplateCatalogTTL time.Duration `env:"MINECHARTS_TEMPLATE_CATALOG_TTL" validate:"gt=0"`          // How long the fetched catalog index is kept
+
+	// Datapack upload configuration
+	DatapackUploadMaxBytes int           `env:"MINECHARTS_DATAPACK_UPLOAD_MAX_BYTES" validate:"gt=0"` // Largest datapack archive accepted by the upload endpoint
+	DatapackUploadTimeout  time.Duration `env:"MINECHARTS_DATAPACK_UPLOAD_TIMEOUT" validate:"gt=0"`   // How long the upload of a datapack and the reload of the server may take
+
+	// Version upgrade configuration
+	UpgradeTimeout time.Duration `env:"MINECHARTS_UPGRADE_TIMEOUT" validate:"gt=0"` // How long an upgraded server may take to come up before its previous version is put back
+
+	// Scheduled task configuration
+	SchedulerInterval time.Duration `env:"MINECHARTS_SCHEDULER_INTERVAL" validate:"gt=0"` // How often due scheduled tasks are looked up
+	BackupTimeout     time.Duration `env:"MINECHARTS_BACKUP_TIMEOUT" validate:"gt=0"`     // How long the archive of a server backup may take
+	TaskRunHistory    int           `env:"MINECHARTS_TASK_RUN_HISTORY" validate:"gt=0"`   // Runs kept in the history of each scheduled task
+	CleanupTimeout    time.Duration `env:"MINECHARTS_CLEANUP_TIMEOUT" validate:"gt=0"`    // How long the cleanup of a server volume may take
+
+	// Downtime warning configuration
+	DowntimeWarnings       string `env:"MINECHARTS_DOWNTIME_WARNINGS"`        // How long before the scheduled restarts and backups the players are warned in game, comma separated; empty disables the warnings
+	DowntimeWarningActions string `env:"MINECHARTS_DOWNTIME_WARNING_ACTIONS"` // Scheduled task actions the players are warned of
+
+	// Idle shutdown configuration
+	IdleShutdownAfter   time.Duration `env:"MINECHARTS_IDLE_SHUTDOWN_AFTER" validate:"min=0"`              // How long a server may run without players before it is hibernated; 0 disables idle shutdown
+	IdleCheckInterval   time.Duration `env:"MINECHARTS_IDLE_CHECK_INTERVAL" validate:"gt=0"`               // How often the player count of the running servers is checked
+	WakeupWebhookToken  string        `env:"MINECHARTS_WAKEUP_WEBHOOK_TOKEN" reload:"true" secret:"true"`  // Token of the webhook waking hibernated servers on connection; without a token or a secret the webhook is disabled
+	WakeupWebhookSecret string        `env:"MINECHARTS_WAKEUP_WEBHOOK_SECRET" reload:"true" secret:"true"` // Secret the deliveries of the wake-up webhook are signed with; when set, unsigned deliveries are refused
+
+	// Replay protection configuration
+	WebhookSignatureTolerance time.Duration `env:"MINECHARTS_WEBHOOK_SIGNATURE_TOLERANCE" validate:"gt=0"` // How far the timestamp of a signed webhook delivery may be from the clock
+	OAuthStateTTL             time.Duration `env:"MINECHARTS_OAUTH_STATE_TTL" validate:"gt=0"`             // How long an OAuth login may take before its state expires
+
+	// Minecraft account linking configuration
+	MinecraftLinkCodeTTL  time.Duration `env:"MINECHARTS_MINECRAFT_LINK_CODE_TTL" validate:"gt=0"` // How long a verification code delivered in game stays valid
+	WhitelistSyncInterval time.Duration `env:"MINECHARTS_WHITELIST_SYNC_INTERVAL" validate:"gt=0"` // How often the synced whitelists are checked, besides access changes
 
 	// OAuth configuration
-	OAuthEnabled = getEnvBool("MINECHARTS_OAUTH_ENABLED", false)
+	OAuthEnabled          bool   `env:"MINECHARTS_OAUTH_ENABLED" reload:"true"`
+	OAuthGroupsClaim      string `env:"MINECHARTS_OAUTH_GROUPS_CLAIM" reload:"true"`      // Userinfo claim listing the groups or roles of the OpenID Connect users
+	OAuthGroupPermissions string `env:"MINECHARTS_OAUTH_GROUP_PERMISSIONS" reload:"true"` // e.g., minecraft-admins=PermAll,minecraft-ops=PermStartServer|PermStopServer
 
 	// Authentik OAuth configuration
-	AuthentikEnabled      = getEnvBool("MINECHARTS_AUTHENTIK_ENABLED", false)
-	AuthentikIssuer       = getEnv("MINECHARTS_AUTHENTIK_ISSUER", "") // e.g., https://auth.example.com/application/o/
-	AuthentikClientID     = getEnv("MINECHARTS_AUTHENTIK_CLIENT_ID", "")
-	AuthentikClientSecret = getEnv("MINECHARTS_AUTHENTIK_CLIENT_SECRET", "")
-	AuthentikRedirectURL  = getEnv("MINECHARTS_AUTHENTIK_REDIRECT_URL", "") // e.g., http://localhost:8080/api/auth/callback/authentik
+	AuthentikEnabled      bool   `env:"MINECHARTS_AUTHENTIK_ENABLED" reload:"true"`
+	AuthentikIssuer       string `env:"MINECHARTS_AUTHENTIK_ISSUER" reload:"true"` // e.g., https://auth.example.com/application/o/
+	AuthentikClientID     string `env:"MINECHARTS_AUTHENTIK_CLIENT_ID" reload:"true"`
+	AuthentikClientSecret string `env:"MINECHARTS_AUTHENTIK_CLIENT_SECRET" reload:"true" secret:"true"`
+	AuthentikRedirectURL  string `env:"MINECHARTS_AUTHENTIK_REDIRECT_URL" reload:"true"` // e.g., http://localhost:8080/api/auth/callback/authentik
+
+	// GitHub OAuth configuration
+	GitHubEnabled      bool   `env:"MINECHARTS_GITHUB_ENABLED" reload:"true"`
+	GitHubClientID     string `env:"MINECHARTS_GITHUB_CLIENT_ID" reload:"true"`
+	GitHubClientSecret string `env:"MINECHARTS_GITHUB_CLIENT_SECRET" reload:"true" secret:"true"`
+	GitHubRedirectURL  string `env:"MINECHARTS_GITHUB_REDIRECT_URL" reload:"true"` // e.g., http://localhost:8080/api/auth/callback/github
+
+	// Google OAuth configuration
+	GoogleEnabled      bool   `env:"MINECHARTS_GOOGLE_ENABLED" reload:"true"`
+	GoogleClientID     string `env:"MINECHARTS_GOOGLE_CLIENT_ID" reload:"true"`
+	GoogleClientSecret string `env:"MINECHARTS_GOOGLE_CLIENT_SECRET" reload:"true" secret:"true"`
+	GoogleRedirectURL  string `env:"MINECHARTS_GOOGLE_REDIRECT_URL" reload:"true"` // e.g., http://localhost:8080/api/auth/callback/google
 
 	// URL Frontend configuration
-	FrontendURL = "http://localhost:3000"
+	FrontendURL string `env:"MINECHARTS_FRONTEND_URL" validate:"url" reload:"true"` // Base of the links to the frontend, such as the OAuth callbacks and the signup links
+
+	// Timezone configuration
+	TimeZone string `env:"MINECHARTS_TIMEZONE" validate:"timezone"` // Valeur par défaut: UTC
+
+	// Logging configuration
+	LogLevel  string `env:"MINECHARTS_LOG_LEVEL" validate:"oneof=trace debug info warn warning error fatal panic" reload:"true"` // Possible values: trace, debug, info, warn, error, fatal, panic
+	LogFormat string `env:"MINECHARTS_LOG_FORMAT" validate:"oneof=json text"`                                                    // Possible values: json, text
+}
+
+// Default returns the configuration used for the settings neither the file nor the
+// environment set.
+func Default() *Config {
+	return &Config{
+		DevMode:                         false,
+		DefaultNamespace:                "minecharts",
+		DeploymentPrefix:                "minecraft-server-",
+		PVCSuffix:                       "-pvc",
+		StorageSize:                     "10Gi",
+		StorageClass:                    "rook-ceph-block",
+		StorageClasses:                  "",
+		StorageSizes:                    "",
+		StorageDriver:                   "pvc",
+		StorageOptions:                  "",
+		ServerImage:                     "itzg/minecraft-server",
+		BedrockServerImage:              "itzg/minecraft-bedrock-server",
+		ProxyDeploymentPrefix:           "minecraft-proxy-",
+		ProxyImage:                      "itzg/mc-proxy",
+		ProxyMemory:                     "512M",
+		TenantNamespaces:                false,
+		TenantQuotaCPU:                  "8",
+		TenantQuotaMemory:               "32Gi",
+		TenantQuotaStorage:              "200Gi",
+		TenantQuotaPods:                 20,
+		TenantDefaultCPU:                "100m",
+		TenantDefaultMemory:             "512Mi",
+		NetworkPolicies:                 false,
+		NetworkPolicyEgress:             "0.0.0.0/0",
+		NetworkPolicyAPISelector:        "app=minecharts",
+		NetworkPolicyRouterSelector:     "app.kubernetes.io/name=mc-router",
+		PriorityClasses:                 "",
+		MemoryHeadroomPercent:           25,
+		MemoryHeadroomMode:              "adjust",
+		DatabaseType:                    "sqlite",
+		DatabaseConnectionString:        "./app/data/minecharts.db",
+		DatabaseReplicaConnectionString: "",
+		DatabaseMaxOpenConns:            25,
+		DatabaseMaxIdleConns:            10,
+		DatabaseConnMaxLifetime:         30 * time.Minute,
+		DatabasePingInterval:            30 * time.Second,
+		RedisURL:                        "",
+		CacheTTL:                        30 * time.Second,
+		CacheRedisURL:                   "",
+		APIKeyTouchInterval:             time.Minute,
+		JWTSecret:                       DefaultJWTSecret,
+		JWTExpiryHours:                  24,
+		APIKeyPrefix:                    "mcapi",
+		SecretsRefreshInterval:          time.Minute,
+		VaultAddr:                       "",
+		VaultToken:                      "",
+		VaultRole:                       "minecharts",
+		VaultAuthPath:                   "kubernetes",
+		RegistrationEnabled:             true,
+		OAuthRoutesEnabled:              true,
+		PublicStatusEnabled:             true,
+		SwaggerEnabled:                  true,
+		MetricsEnabled:                  true,
+		MetricsToken:                    "",
+		InvitationOnly:                  false,
+		InvitationTTL:                   72 * time.Hour,
+		UserDeletionGracePeriod:         30 * 24 * time.Hour,
+		SMTPHost:                        "",
+		SMTPPort:                        587,
+		SMTPUsername:                    "",
+		SMTPPassword:                    "",
+		SMTPFrom:                        "minecharts@localhost",
+		RequireServerApproval:           false,
+		SecurityEventsSink:              "",
+		SecurityEventsFormat:            "json",
+		WebhookURLs:                     "",
+		WebhookSecret:                   "",
+		ErrorReportingDSN:               "",
+		ErrorReportingEnvironment:       "production",
+		TracingEndpoint:                 "",
+		TracingServiceName:              "minecharts-api",
+		TracingSamplePercent:            100,
+		RequestTimeout:                  5 * time.Second,
+		ExecTimeout:                     60 * time.Second,
+		ShutdownTimeout:                 25 * time.Second,
+		ResponseCacheTTL:                5 * time.Minute,
+		BatchStatusConcurrency:          8,
+		RolloutStrategy:                 "recreate",
+		RolloutTimeout:                  5 * time.Minute,
+		ProbePeriod:                     10 * time.Second,
+		ProbeTimeout:                    5 * time.Second,
+		StartupProbeTimeout:             5 * time.Minute,
+		LivenessFailureThreshold:        6,
+		ReadinessFailureThreshold:       3,
+		StatefulSets:                    false,
+		KubernetesBreakerThreshold:      5,
+		KubernetesBreakerCooldown:       30 * time.Second,
+		RateLimit:                       "600/1m",
+		AuthRateLimit:                   "20/1m",
+		ExecRateLimit:                   "30/1m",
+		RateLimitRedisURL:               "",
+		TrustedProxies:                  "",
+		ReconcileInterval:               time.Minute,
+		StatusChangeRetention:           24 * time.Hour,
+		StartupCheck:                    true,
+		StartupRepair:                   "",
+		EventsPollInterval:              time.Second,
+		CloneImage:                      "busybox:1.36",
+		CloneTimeout:                    30 * time.Minute,
+		PrePullEnabled:                  false,
+		PrePullImages:                   "",
+		PrePullPauseImage:               "registry.k8s.io/pause:3.10",
+		WorldUploadMaxBytes:             10 << 30,
+		WorldUploadTimeout:              30 * time.Minute,
+		WorldExportTimeout:              30 * time.Minute,
+		ModrinthURL:                     "https://api.modrinth.com/v2",
+		PluginInstallTimeout:            5 * time.Minute,
+		MojangVersionManifestURL:        "https://piston-meta.mojang.com/mc/game/version_manifest_v2.json",
+		PaperAPIURL:                     "https://api.papermc.io/v2",
+		FabricMetaURL:                   "https://meta.fabricmc.net/v2",
+		VersionManifestTTL:              time.Hour,
+		TemplateCatalogURL:              "",
+		TemplateCatalogTTL:              time.Hour,
+		DatapackUploadMaxBytes:          100 << 20,
+		DatapackUploadTimeout:           5 * time.Minute,
+		UpgradeTimeout:                  10 * time.Minute,
+		SchedulerInterval:               30 * time.Second,
+		BackupTimeout:                   30 * time.Minute,
+		TaskRunHistory:                  50,
+		CleanupTimeout:                  10 * time.Minute,
+		DowntimeWarnings:                "5m,1m,10s",
+		DowntimeWarningActions:          "restart,backup",
+		IdleShutdownAfter:               0,
+		IdleCheckInterval:               time.Minute,
+		WakeupWebhookToken:              "",
+		WakeupWebhookSecret:             "",
+		WebhookSignatureTolerance:       5 * time.Minute,
+		OAuthStateTTL:                   15 * time.Minute,
+		MinecraftLinkCodeTTL:            10 * time.Minute,
+		WhitelistSyncInterval:           5 * time.Minute,
+		OAuthEnabled:                    false,
+		OAuthGroupsClaim:                "groups",
+		OAuthGroupPermissions:           "",
+		AuthentikEnabled:                false,
+		AuthentikIssuer:                 "",
+		AuthentikClientID:               "",
+		AuthentikClientSecret:           "",
+		AuthentikRedirectURL:            "",
+		GitHubEnabled:                   false,
+		GitHubClientID:                  "",
+		GitHubClientSecret:              "",
+		GitHubRedirectURL:               "",
+		GoogleEnabled:                   false,
+		GoogleClientID:                  "",
+		GoogleClientSecret:              "",
+		GoogleRedirectURL:               "",
+		FrontendURL:                     "http://localhost:3000",
+		TimeZone:                        "UTC",
+		LogLevel:                        "info",
+		LogFormat:                       "json",
+	}
+}
+
+// The applied configuration, read by the packages, but for the reloadable settings
+// read through Live. Apply sets it at startup, and the development mode and the tools
+// adjust it before the packages are initialized.
+var (
+	// Development mode
+	DevMode bool
+
+	// Server configuration
+	DefaultNamespace string
+	DeploymentPrefix string
+	PVCSuffix        string
+	StorageSize      string
+	StorageClass     string
+	StorageClasses   string
+	StorageSizes     string
+	StorageDriver    string
+	StorageOptions   string
+	ServerImage      string
+
+	BedrockServerImage string
+
+	// Proxy configuration
+	ProxyDeploymentPrefix string
+	ProxyImage            string
+	ProxyMemory           string
+
+	// Tenant namespace configuration
+	TenantNamespaces    bool
+	TenantQuotaCPU      string
+	TenantQuotaMemory   string
+	TenantQuotaStorage  string
+	TenantQuotaPods     int
+	TenantDefaultCPU    string
+	TenantDefaultMemory string
+
+	// Server network policy configuration
+	NetworkPolicies             bool
+	NetworkPolicyEgress         string
+	NetworkPolicyAPISelector    string
+	NetworkPolicyRouterSelector string
+
+	// Server scheduling configuration
+	PriorityClasses string
+
+	// JVM memory configuration
+	MemoryHeadroomPercent int
+	MemoryHeadroomMode    string
+
+	// Database configuration
+	DatabaseType                    string
+	DatabaseConnectionString        string
+	DatabaseReplicaConnectionString string
+	DatabaseMaxOpenConns            int
+	DatabaseMaxIdleConns            int
+	DatabaseConnMaxLifetime         time.Duration
+	DatabasePingInterval            time.Duration
+
+	// Redis configuration
+	RedisURL string
+
+	// Cache configuration
+	CacheTTL            time.Duration
+	CacheRedisURL       string
+	APIKeyTouchInterval time.Duration
+
+	// Authentication configuration
+	JWTExpiryHours int
+	APIKeyPrefix   string
+
+	// Secret provider configuration
+	SecretsRefreshInterval time.Duration
+	VaultAddr              string
+	VaultToken             string
+	VaultRole              string
+	VaultAuthPath          string
+
+	// Route exposure configuration, disabled routes answer 404
+	RegistrationEnabled bool
+	OAuthRoutesEnabled  bool
+	PublicStatusEnabled bool
+	SwaggerEnabled      bool
+	MetricsEnabled      bool
+
+	// Account configuration
+	InvitationOnly          bool
+	InvitationTTL           time.Duration
+	UserDeletionGracePeriod time.Duration
+
+	// Email configuration, email is disabled without an SMTP host
+	SMTPHost     string
+	SMTPPort     int
+	SMTPUsername string
+	SMTPFrom     string
+
+	// Server approval configuration
+	RequireServerApproval bool
+
+	// Security event stream configuration
+	SecurityEventsSink   string
+	SecurityEventsFormat string
+
+	// Outgoing webhook configuration
+	WebhookURLs   string
+	WebhookSecret string
+
+	// Error reporting configuration
+	ErrorReportingDSN         string
+	ErrorReportingEnvironment string
+
+	// Tracing configuration
+	TracingEndpoint      string
+	TracingServiceName   string
+	TracingSamplePercent int
+
+	// Request timeout configuration
+	RequestTimeout time.Duration
+	ExecTimeout    time.Duration
+
+	// Shutdown configuration
+	ShutdownTimeout time.Duration
+
+	// Response cache configuration
+	ResponseCacheTTL time.Duration
+
+	// Batch status configuration
+	BatchStatusConcurrency int
+
+	// Rollout configuration
+	RolloutStrategy string
+	RolloutTimeout  time.Duration
+
+	// Server probe configuration
+	ProbePeriod               time.Duration
+	ProbeTimeout              time.Duration
+	StartupProbeTimeout       time.Duration
+	LivenessFailureThreshold  int
+	ReadinessFailureThreshold int
+
+	// Server workload configuration
+	StatefulSets bool
+
+	// Kubernetes API circuit breaker configuration
+	KubernetesBreakerThreshold int
+	KubernetesBreakerCooldown  time.Duration
+
+	// Rate limiting configuration, as requests/period; an empty limit is disabled
+	RateLimitRedisURL string
+
+	// Client address configuration
+	TrustedProxies string
+
+	// Reconciliation configuration
+	ReconcileInterval     time.Duration
+	StatusChangeRetention time.Duration
+	StartupCheck          bool
+	StartupRepair         string
+
+	// Event stream configuration
+	EventsPollInterval time.Duration
+
+	// Server clone configuration
+	CloneImage   string
+	CloneTimeout time.Duration
+
+	// Image pre-pull configuration
+	PrePullEnabled    bool
+	PrePullImages     string
+	PrePullPauseImage string
+
+	// World upload and export configuration
+	WorldUploadMaxBytes int
+	WorldUploadTimeout  time.Duration
+	WorldExportTimeout  time.Duration
+
+	// Plugin and mod installation configuration
+	ModrinthURL          string
+	PluginInstallTimeout time.Duration
+
+	// Version manifest configuration
+	MojangVersionManifestURL string
+	PaperAPIURL              string
+	FabricMetaURL            string
+	VersionManifestTTL       time.Duration
+
+	// Template catalog configuration
+	TemplateCatalogURL string
+	TemplateCatalogTTL time.Duration
+
+	// Datapack upload configuration
+	DatapackUploadMaxBytes int
+	DatapackUploadTimeout  time.Duration
+
+	// Version upgrade configuration
+	UpgradeTimeout time.Duration
+
+	// Scheduled task configuration
+	SchedulerInterval time.Duration
+	BackupTimeout     time.Duration
+	TaskRunHistory    int
+	CleanupTimeout    time.Duration
+
+	// Downtime warning configuration
+	DowntimeWarnings       string
+	DowntimeWarningActions string
+
+	// Idle shutdown configuration
+	IdleShutdownAfter time.Duration
+	IdleCheckInterval time.Duration
+
+	// Replay protection configuration
+	WebhookSignatureTolerance time.Duration
+	OAuthStateTTL             time.Duration
+
+	// Minecraft account linking configuration
+	MinecraftLinkCodeTTL  time.Duration
+	WhitelistSyncInterval time.Duration
 
 	// Timezone configuration
-	TimeZone = getEnv("MINECHARTS_TIMEZONE", "UTC") // Valeur par défaut: UTC
+	TimeZone string
 
 	// Logging configuration
-	LogLevel  = getEnv("MINECHARTS_LOG_LEVEL", "info")  // Possible values: trace, debug, info, warn, error, fatal, panic
-	LogFormat = getEnv("MINECHARTS_LOG_FORMAT", "json") // Possible values: json, text
+	LogFormat string
 )
 
-func getEnv(key, fallback string) string {
-	if value, exists := os.LookupEnv(key); exists {
-		return value
+func init() {
+	// Keep the tools and tests that never call Load on the environment, as before
+	// the configuration had a file
+	c := Default()
+	c.loadEnvironment(true)
+	Apply(c)
+}
+
+// variables are the package variables of the settings, by field name.
+var variables = map[string]any{
+	"DevMode":                         &DevMode,
+	"DefaultNamespace":                &DefaultNamespace,
+	"DeploymentPrefix":                &DeploymentPrefix,
+	"PVCSuffix":                       &PVCSuffix,
+	"StorageSize":                     &StorageSize,
+	"StorageClass":                    &StorageClass,
+	"StorageClasses":                  &StorageClasses,
+	"StorageSizes":                    &StorageSizes,
+	"StorageDriver":                   &StorageDriver,
+	"StorageOptions":                  &StorageOptions,
+	"ServerImage":                     &ServerImage,
+	"BedrockServerImage":              &BedrockServerImage,
+	"ProxyDeploymentPrefix":           &ProxyDeploymentPrefix,
+	"ProxyImage":                      &ProxyImage,
+	"ProxyMemory":                     &ProxyMemory,
+	"TenantNamespaces":                &TenantNamespaces,
+	"TenantQuotaCPU":                  &TenantQuotaCPU,
+	"TenantQuotaMemory":               &TenantQuotaMemory,
+	"TenantQuotaStorage":              &TenantQuotaStorage,
+	"TenantQuotaPods":                 &TenantQuotaPods,
+	"TenantDefaultCPU":                &TenantDefaultCPU,
+	"TenantDefaultMemory":             &TenantDefaultMemory,
+	"NetworkPolicies":                 &NetworkPolicies,
+	"NetworkPolicyEgress":             &NetworkPolicyEgress,
+	"NetworkPolicyAPISelector":        &NetworkPolicyAPISelector,
+	"NetworkPolicyRouterSelector":     &NetworkPolicyRouterSelector,
+	"PriorityClasses":                 &PriorityClasses,
+	"MemoryHeadroomPercent":           &MemoryHeadroomPercent,
+	"MemoryHeadroomMode":              &MemoryHeadroomMode,
+	"DatabaseType":                    &DatabaseType,
+	"DatabaseConnectionString":        &DatabaseConnectionString,
+	"DatabaseReplicaConnectionString": &DatabaseReplicaConnectionString,
+	"DatabaseMaxOpenConns":            &DatabaseMaxOpenConns,
+	"DatabaseMaxIdleConns":            &DatabaseMaxIdleConns,
+	"DatabaseConnMaxLifetime":         &DatabaseConnMaxLifetime,
+	"DatabasePingInterval":            &DatabasePingInterval,
+	"RedisURL":                        &RedisURL,
+	"CacheTTL":                        &CacheTTL,
+	"CacheRedisURL":                   &CacheRedisURL,
+	"APIKeyTouchInterval":             &APIKeyTouchInterval,
+	"JWTExpiryHours":                  &JWTExpiryHours,
+	"APIKeyPrefix":                    &APIKeyPrefix,
+	"SecretsRefreshInterval":          &SecretsRefreshInterval,
+	"VaultAddr":                       &VaultAddr,
+	"VaultToken":                      &VaultToken,
+	"VaultRole":                       &VaultRole,
+	"VaultAuthPath":                   &VaultAuthPath,
+	"RegistrationEnabled":             &RegistrationEnabled,
+	"OAuthRoutesEnabled":              &OAuthRoutesEnabled,
+	"PublicStatusEnabled":             &PublicStatusEnabled,
+	"SwaggerEnabled":                  &SwaggerEnabled,
+	"MetricsEnabled":                  &MetricsEnabled,
+	"InvitationOnly":                  &InvitationOnly,
+	"InvitationTTL":                   &InvitationTTL,
+	"UserDeletionGracePeriod":         &UserDeletionGracePeriod,
+	"SMTPHost":                        &SMTPHost,
+	"SMTPPort":                        &SMTPPort,
+	"SMTPUsername":                    &SMTPUsername,
+	"SMTPFrom":                        &SMTPFrom,
+	"RequireServerApproval":           &RequireServerApproval,
+	"SecurityEventsSink":              &SecurityEventsSink,
+	"SecurityEventsFormat":            &SecurityEventsFormat,
+	"WebhookURLs":                     &WebhookURLs,
+	"WebhookSecret":                   &WebhookSecret,
+	"ErrorReportingDSN":               &ErrorReportingDSN,
+	"ErrorReportingEnvironment":       &ErrorReportingEnvironment,
+	"TracingEndpoint":                 &TracingEndpoint,
+	"TracingServiceName":              &TracingServiceName,
+	"TracingSamplePercent":            &TracingSamplePercent,
+	"RequestTimeout":                  &RequestTimeout,
+	"ExecTimeout":                     &ExecTimeout,
+	"ShutdownTimeout":                 &ShutdownTimeout,
+	"ResponseCacheTTL":                &ResponseCacheTTL,
+	"BatchStatusConcurrency":          &BatchStatusConcurrency,
+	"RolloutStrategy":                 &RolloutStrategy,
+	"RolloutTimeout":                  &RolloutTimeout,
+	"ProbePeriod":                     &ProbePeriod,
+	"ProbeTimeout":                    &ProbeTimeout,
+	"StartupProbeTimeout":             &StartupProbeTimeout,
+	"LivenessFailureThreshold":        &LivenessFailureThreshold,
+	"ReadinessFailureThreshold":       &ReadinessFailureThreshold,
+	"StatefulSets":                    &StatefulSets,
+	"KubernetesBreakerThreshold":      &KubernetesBreakerThreshold,
+	"KubernetesBreakerCooldown":       &KubernetesBreakerCooldown,
+	"RateLimitRedisURL":               &RateLimitRedisURL,
+	"TrustedProxies":                  &TrustedProxies,
+	"ReconcileInterval":               &ReconcileInterval,
+	"StatusChangeRetention":           &StatusChangeRetention,
+	"StartupCheck":                    &StartupCheck,
+	"StartupRepair":                   &StartupRepair,
+	"EventsPollInterval":              &EventsPollInterval,
+	"CloneImage":                      &CloneImage,
+	"CloneTimeout":                    &CloneTimeout,
+	"PrePullEnabled":                  &PrePullEnabled,
+	"PrePullImages":                   &PrePullImages,
+	"PrePullPauseImage":               &PrePullPauseImage,
+	"WorldUploadMaxBytes":             &WorldUploadMaxBytes,
+	"WorldUploadTimeout":              &WorldUploadTimeout,
+	"WorldExportTimeout":              &WorldExportTimeout,
+	"ModrinthURL":                     &ModrinthURL,
+	"PluginInstallTimeout":            &PluginInstallTimeout,
+	"MojangVersionManifestURL":        &MojangVersionManifestURL,
+	"PaperAPIURL":                     &PaperAPIURL,
+	"FabricMetaURL":                   &FabricMetaURL,
+	"VersionManifestTTL":              &VersionManifestTTL,
+	"TemplateCatalogURL":              &TemplateCatalogURL,
+	"TemplateCatalogTTL":              &TemplateCatalogTTL,
+	"DatapackUploadMaxBytes":          &DatapackUploadMaxBytes,
+	"DatapackUploadTimeout":           &DatapackUploadTimeout,
+	"UpgradeTimeout":                  &UpgradeTimeout,
+	"SchedulerInterval":               &SchedulerInterval,
+	"BackupTimeout":                   &BackupTimeout,
+	"TaskRunHistory":                  &TaskRunHistory,
+	"CleanupTimeout":                  &CleanupTimeout,
+	"DowntimeWarnings":                &DowntimeWarnings,
+	"DowntimeWarningActions":          &DowntimeWarningActions,
+	"IdleShutdownAfter":               &IdleShutdownAfter,
+	"IdleCheckInterval":               &IdleCheckInterval,
+	"WebhookSignatureTolerance":       &WebhookSignatureTolerance,
+	"OAuthStateTTL":                   &OAuthStateTTL,
+	"MinecraftLinkCodeTTL":            &MinecraftLinkCodeTTL,
+	"WhitelistSyncInterval":           &WhitelistSyncInterval,
+	"TimeZone":                        &TimeZone,
+	"LogFormat":                       &LogFormat,
+}
+
+// variable returns the package variable of a setting. A setting added to Config
+// needs one, unless it is reloadable.
+func variable(name string) reflect.Value {
+	pointer, ok := variables[name]
+	if !ok {
+		panic("config: no package variable for the setting " + name)
 	}
-	return fallback
+	return reflect.ValueOf(pointer).Elem()
 }
 
-func getEnvBool(key string, fallback bool) bool {
-	if value, exists := os.LookupEnv(key); exists {
-		return value == "true" || value == "1" || value == "yes"
+// live is the configuration the reloadable settings are read from. A reload stores
+// a new one rather than changing it, so that the requests reading it meanwhile see
+// either configuration as a whole.
+var live atomic.Pointer[Config]
+
+// Live returns the configuration applied last, for its reloadable settings. It must
+// not be modified; a request reads it once for the settings that go together, such
+// as the client ID and secret of a provider.
+func Live() *Config {
+	return live.Load()
+}
+
+// Apply makes c the configuration read by the packages.
+func Apply(c *Config) {
+	value := reflect.ValueOf(c).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		if field.Tag.Get("reload") == "true" {
+			continue
+		}
+		variable(field.Name).Set(value.Field(i))
 	}
-	return fallback
+	applied := *c
+	live.Store(&applied)
 }
 
-func getEnvInt(key string, fallback int) int {
-	if value, exists := os.LookupEnv(key); exists {
-		if intVal, err := strconv.Atoi(value); err == nil {
-			return intVal
+// Current returns the configuration read by the packages, with the adjustments of the
+// development mode.
+func Current() *Config {
+	c := *Live()
+	value := reflect.ValueOf(&c).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		if field.Tag.Get("reload") == "true" {
+			continue
 		}
+		value.Field(i).Set(variable(field.Name))
 	}
-	return fallback
+	return &c
 }
@@ -0,0 +1,50 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+// Each setting but the reloadable ones, read through Live, needs its package
+// variable: Apply and Current panic on a missing one.
+func TestVariablesCoverSettings(t *testing.T) {
+	fields := reflect.TypeOf(Config{})
+	names := map[string]bool{}
+	for i := 0; i < fields.NumField(); i++ {
+		field := fields.Field(i)
+		names[field.Name] = true
+		variable, ok := variables[field.Name]
+		reloadable := field.Tag.Get("reload") == "true"
+		switch {
+		case reloadable && ok:
+			t.Errorf("%s is reloadable, read through Live, but has a package variable", field.Name)
+		case !reloadable && !ok:
+			t.Errorf("%s has no entry in variables", field.Name)
+		case ok && reflect.TypeOf(variable) != reflect.PointerTo(field.Type):
+			t.Errorf("variables[%q] is a %T, want a *%s", field.Name, variable, field.Type)
+		}
+	}
+	for name := range variables {
+		if !names[name] {
+			t.Errorf("variables[%q] is not a Config field", name)
+		}
+	}
+}
+
+func TestApplyCurrentRoundTrip(t *testing.T) {
+	previous := Current()
+	t.Cleanup(func() { Apply(previous) })
+
+	c := Default()
+	c.DefaultNamespace = "round-trip"
+	c.JWTExpiryHours = 7
+	c.FrontendURL = "https://minecharts.example"
+	Apply(c)
+
+	if got := Current(); !reflect.DeepEqual(got, c) {
+		t.Errorf("Current() after Apply differs from the applied configuration:\n got %+v\nwant %+v", got, c)
+	}
+	if DefaultNamespace != "round-trip" || Live().FrontendURL != "https://minecharts.example" {
+		t.Errorf("Apply did not set the package variables and the live configuration")
+	}
+}
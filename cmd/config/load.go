@@ -0,0 +1,184 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// envPrefix starts the environment variables of the settings.
+const envPrefix = "MINECHARTS_"
+
+// Load reads the configuration: the defaults, then the file at path when it is not
+// empty, then the environment variables. The secret settings set to a reference are
+// read from their file or from Vault. The configuration is validated, and invalid
+// values are errors rather than falling back to their default.
+func Load(path string) (*Config, error) {
+	c := Default()
+	if path != "" {
+		if err := c.loadFile(path); err != nil {
+			return nil, fmt.Errorf("failed to load configuration file %s: %w", path, err)
+		}
+	}
+	if err := c.loadEnvironment(false); err != nil {
+		return nil, err
+	}
+	if err := c.resolveSecrets(); err != nil {
+		return nil, fmt.Errorf("failed to read secrets: %w", err)
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// FileKey returns the key of a setting in the configuration file, from its
+// environment variable: MINECHARTS_DB_TYPE is db_type.
+func FileKey(env string) string {
+	return strings.ToLower(strings.TrimPrefix(env, envPrefix))
+}
+
+// settings calls fn with each setting of c and its environment variable.
+func (c *Config) settings(fn func(setting reflect.Value, env string)) {
+	value := reflect.ValueOf(c).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		fn(value.Field(i), value.Type().Field(i).Tag.Get("env"))
+	}
+}
+
+// loadEnvironment overrides the settings whose environment variable is set. Leniently,
+// invalid values are ignored, as the configuration did before it was validated.
+func (c *Config) loadEnvironment(lenient bool) error {
+	var errs []error
+	c.settings(func(setting reflect.Value, env string) {
+		value, ok := os.LookupEnv(env)
+		if !ok {
+			return
+		}
+		if err := set(setting, value); err != nil && !lenient {
+			errs = append(errs, fmt.Errorf("%s: %w", env, err))
+		}
+	})
+	return errors.Join(errs...)
+}
+
+// loadFile overrides the settings set in a YAML or TOML file, chosen by its extension.
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	values := map[string]any{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &values)
+	case ".toml":
+		err = toml.Unmarshal(data, &values)
+	default:
+		return errors.New("unknown format, the file must end with .yaml, .yml or .toml")
+	}
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	c.settings(func(setting reflect.Value, env string) {
+		key := FileKey(env)
+		value, ok := values[key]
+		if !ok {
+			return
+		}
+		delete(values, key)
+		text, err := fileValue(value)
+		if err == nil {
+			err = set(setting, text)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", key, err))
+		}
+	})
+	unknown := make([]string, 0, len(values))
+	for key := range values {
+		unknown = append(unknown, key)
+	}
+	sort.Strings(unknown)
+	for _, key := range unknown {
+		errs = append(errs, fmt.Errorf("%s: unknown setting", key))
+	}
+	return errors.Join(errs...)
+}
+
+// fileValue returns a value of the file as the text of its environment variable.
+// Lists are comma separated and maps are key=value pairs, such as the storage options.
+func fileValue(value any) (string, error) {
+	switch value := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return value, nil
+	case bool, int, int64, uint64, float64:
+		return fmt.Sprint(value), nil
+	case []any:
+		items := make([]string, 0, len(value))
+		for _, item := range value {
+			text, err := fileValue(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, text)
+		}
+		return strings.Join(items, ","), nil
+	case map[string]any:
+		pairs := make([]string, 0, len(value))
+		for key, item := range value {
+			text, err := fileValue(item)
+			if err != nil {
+				return "", err
+			}
+			pairs = append(pairs, key+"="+text)
+		}
+		sort.Strings(pairs)
+		return strings.Join(pairs, ","), nil
+	default:
+		return "", fmt.Errorf("unsupported value %v", value)
+	}
+}
+
+// set parses the text of a setting, as written in its environment variable.
+func set(setting reflect.Value, text string) error {
+	switch setting.Interface().(type) {
+	case string:
+		setting.SetString(text)
+	case bool:
+		switch strings.ToLower(text) {
+		case "true", "1", "yes":
+			setting.SetBool(true)
+		case "false", "0", "no", "":
+			setting.SetBool(false)
+		default:
+			return fmt.Errorf("invalid boolean %q", text)
+		}
+	case int:
+		value, err := strconv.Atoi(text)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", text)
+		}
+		setting.SetInt(int64(value))
+	case time.Duration:
+		value, err := time.ParseDuration(text)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q, e.g., 30s or 5m", text)
+		}
+		setting.SetInt(int64(value))
+	}
+	return nil
+}
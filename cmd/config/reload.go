@@ -0,0 +1,79 @@
+package config
+
+import (
+	"reflect"
+	"sync"
+	"time"
+)
+
+// Setting is a setting of a configuration, as GET /admin/config lists it.
+type Setting struct {
+	Key        string
+	Value      any // Durations are formatted as in the environment variables
+	Secret     bool
+	Reloadable bool
+}
+
+// Settings lists the settings of c, in the order of the Config fields.
+func (c *Config) Settings() []Setting {
+	value := reflect.ValueOf(c).Elem()
+	settings := make([]Setting, 0, value.NumField())
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		setting := Setting{
+			Key:        FileKey(field.Tag.Get("env")),
+			Value:      value.Field(i).Interface(),
+			Secret:     field.Tag.Get("secret") == "true",
+			Reloadable: field.Tag.Get("reload") == "true",
+		}
+		if duration, ok := setting.Value.(time.Duration); ok {
+			setting.Value = duration.String()
+		}
+		settings = append(settings, setting)
+	}
+	return settings
+}
+
+var reloads struct {
+	sync.Mutex
+	last time.Time
+}
+
+// Reload applies the settings tagged reload that next changes from previous, the
+// configuration loaded before it. The other settings are left to the next restart.
+// It returns the keys of the applied settings and of the settings waiting for a
+// restart. Settings next does not change keep the adjustments of the development mode.
+func Reload(previous, next *Config) (applied, pending []string) {
+	reloads.Lock()
+	defer reloads.Unlock()
+
+	updated := *Live()
+	before, after := reflect.ValueOf(previous).Elem(), reflect.ValueOf(next).Elem()
+	for i := 0; i < after.NumField(); i++ {
+		if reflect.DeepEqual(before.Field(i).Interface(), after.Field(i).Interface()) {
+			continue
+		}
+		field := after.Type().Field(i)
+		key := FileKey(field.Tag.Get("env"))
+		if field.Tag.Get("reload") != "true" {
+			pending = append(pending, key)
+			continue
+		}
+		reflect.ValueOf(&updated).Elem().Field(i).Set(after.Field(i))
+		applied = append(applied, key)
+	}
+	if len(applied) > 0 {
+		live.Store(&updated)
+	}
+	if len(applied) > 0 || len(pending) > 0 {
+		reloads.last = time.Now()
+	}
+	return applied, pending
+}
+
+// ReloadedAt returns when a reload last changed the configuration, zero before.
+func ReloadedAt() time.Time {
+	reloads.Lock()
+	defer reloads.Unlock()
+	return reloads.last
+}
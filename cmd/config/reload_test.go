@@ -0,0 +1,72 @@
+package config
+
+import (
+	"slices"
+	"sync"
+	"testing"
+)
+
+func TestReloadAppliesReloadableSettings(t *testing.T) {
+	previous := Current()
+	t.Cleanup(func() { Apply(previous) })
+
+	next := *previous
+	next.RateLimit = "5/1m"
+	next.JWTExpiryHours = previous.JWTExpiryHours + 1
+	applied, pending := Reload(previous, &next)
+
+	if !slices.Equal(applied, []string{"rate_limit"}) {
+		t.Errorf("applied = %v, want [rate_limit]", applied)
+	}
+	if !slices.Equal(pending, []string{"jwt_expiry_hours"}) {
+		t.Errorf("pending = %v, want [jwt_expiry_hours]", pending)
+	}
+	if Live().RateLimit != "5/1m" {
+		t.Errorf("Live().RateLimit = %q, want 5/1m", Live().RateLimit)
+	}
+	if JWTExpiryHours != previous.JWTExpiryHours {
+		t.Errorf("JWTExpiryHours = %d, want %d until a restart", JWTExpiryHours, previous.JWTExpiryHours)
+	}
+}
+
+// Run with -race: the readers of the reloadable settings must not race the SIGHUP
+// reloads.
+func TestReloadWhileReading(t *testing.T) {
+	previous := Current()
+	t.Cleanup(func() { Apply(previous) })
+
+	var readers sync.WaitGroup
+	done := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				c := Live()
+				if (c.GitHubClientID == "") != (c.GitHubClientSecret == "") {
+					t.Error("read the client ID and secret of different configurations")
+					return
+				}
+			}
+		}()
+	}
+
+	before := previous
+	for i := 0; i < 100; i++ {
+		next := *before
+		if i%2 == 0 {
+			next.GitHubClientID, next.GitHubClientSecret = "id", "secret"
+		} else {
+			next.GitHubClientID, next.GitHubClientSecret = "", ""
+		}
+		Reload(before, &next)
+		before = &next
+	}
+	close(done)
+	readers.Wait()
+}
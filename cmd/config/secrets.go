@@ -0,0 +1,73 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"minecharts/cmd/secrets"
+)
+
+// secretsTimeout bounds the reads of the referenced secrets of a load.
+const secretsTimeout = 30 * time.Second
+
+// vault is kept between the loads, so that the refreshes reuse its Vault login.
+var vault struct {
+	sync.Mutex
+	provider *secrets.VaultProvider
+}
+
+// resolveSecrets replaces the file: and vault: references of the secret settings with
+// the secrets they point to.
+func (c *Config) resolveSecrets() error {
+	ctx, cancel := context.WithTimeout(context.Background(), secretsTimeout)
+	defer cancel()
+
+	// The Vault token may itself come from a mounted Secret
+	if secrets.IsReference(c.VaultToken) {
+		token, err := secrets.NewResolver(nil).Resolve(ctx, c.VaultToken)
+		if err != nil {
+			return fmt.Errorf("vault_token: %w", err)
+		}
+		c.VaultToken = token
+	}
+
+	var resolver *secrets.Resolver
+	var errs []error
+	value := reflect.ValueOf(c).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		ref := value.Field(i).String()
+		if field.Tag.Get("secret") != "true" || !secrets.IsReference(ref) {
+			continue
+		}
+		if resolver == nil {
+			resolver = secrets.NewResolver(c.vaultProvider())
+		}
+		secret, err := resolver.Resolve(ctx, ref)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", FileKey(field.Tag.Get("env")), err))
+			continue
+		}
+		value.Field(i).SetString(secret)
+	}
+	return errors.Join(errs...)
+}
+
+// vaultProvider returns the provider of the Vault settings of c, nil without Vault.
+func (c *Config) vaultProvider() secrets.Provider {
+	if c.VaultAddr == "" {
+		return nil
+	}
+	vault.Lock()
+	defer vault.Unlock()
+	p := vault.provider
+	if p == nil || p.Addr != c.VaultAddr || p.Token != c.VaultToken || p.Role != c.VaultRole || p.AuthPath != c.VaultAuthPath {
+		p = secrets.NewVaultProvider(c.VaultAddr, c.VaultToken, c.VaultRole, c.VaultAuthPath)
+		vault.provider = p
+	}
+	return p
+}
@@ -0,0 +1,128 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// validate checks the validate tags of the settings, naming them after their keys in
+// the configuration file.
+var validate = func() *validator.Validate {
+	v := validator.New()
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		return FileKey(field.Tag.Get("env"))
+	})
+	return v
+}()
+
+// Validate reports every invalid setting of c, such as an unknown database type or a
+// negative timeout, and the settings that must change before running in production.
+func (c *Config) Validate() error {
+	var errs []error
+	var validationErrs validator.ValidationErrors
+	if err := validate.Struct(c); errors.As(err, &validationErrs) {
+		for _, fieldErr := range validationErrs {
+			errs = append(errs, fmt.Errorf("%s: %s", fieldErr.Field(), rule(fieldErr)))
+		}
+	} else if err != nil {
+		errs = append(errs, err)
+	}
+
+	if c.JWTSecret == DefaultJWTSecret && !c.DevMode {
+		errs = append(errs, errors.New("jwt_secret: the default secret lets anyone sign tokens, set MINECHARTS_JWT_SECRET"))
+	}
+	if c.DatabaseReplicaConnectionString != "" && c.DatabaseType != "postgres" {
+		errs = append(errs, errors.New("db_replica_connection: read replicas need the postgres database type"))
+	}
+	// Quantities are checked in the order of the settings, for stable errors
+	for _, quantity := range []struct{ key, value string }{
+		{"tenant_quota_cpu", c.TenantQuotaCPU},
+		{"tenant_quota_memory", c.TenantQuotaMemory},
+		{"tenant_quota_storage", c.TenantQuotaStorage},
+		{"tenant_default_cpu", c.TenantDefaultCPU},
+		{"tenant_default_memory", c.TenantDefaultMemory},
+	} {
+		if _, err := resource.ParseQuantity(quantity.value); quantity.value != "" && err != nil {
+			errs = append(errs, fmt.Errorf("%s: must be a Kubernetes quantity such as 500m or 4Gi, not %q", quantity.key, quantity.value))
+		}
+	}
+	for _, size := range strings.Split(c.StorageSizes, ",") {
+		if size = strings.TrimSpace(size); size == "" {
+			continue
+		}
+		if _, err := resource.ParseQuantity(size); err != nil {
+			errs = append(errs, fmt.Errorf("storage_sizes: %q is not a quantity such as 20Gi", size))
+		}
+	}
+	for _, proxy := range strings.Split(c.TrustedProxies, ",") {
+		if proxy = strings.TrimSpace(proxy); proxy == "" {
+			continue
+		}
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			errs = append(errs, fmt.Errorf("trusted_proxies: %q is not an address or a CIDR such as 10.0.0.0/8", proxy))
+		}
+	}
+	for _, cidr := range strings.Split(c.NetworkPolicyEgress, ",") {
+		if cidr = strings.TrimSpace(cidr); cidr == "" {
+			continue
+		}
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			errs = append(errs, fmt.Errorf("network_policy_egress: %q is not a CIDR such as 10.0.0.0/8", cidr))
+		}
+	}
+	for _, selector := range []struct{ key, value string }{
+		{"network_policy_api_selector", c.NetworkPolicyAPISelector},
+		{"network_policy_router_selector", c.NetworkPolicyRouterSelector},
+	} {
+		if _, err := metav1.ParseToLabelSelector(selector.value); err != nil {
+			errs = append(errs, fmt.Errorf("%s: must be a label selector such as app=minecharts: %w", selector.key, err))
+		}
+	}
+	// Kubernetes counts the probe timings in whole seconds
+	for _, timing := range []struct {
+		key   string
+		value time.Duration
+	}{
+		{"probe_period", c.ProbePeriod},
+		{"probe_timeout", c.ProbeTimeout},
+	} {
+		if timing.value < time.Second {
+			errs = append(errs, fmt.Errorf("%s: must be at least 1s, not %s", timing.key, timing.value))
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// rule describes the validation rule a setting broke.
+func rule(err validator.FieldError) string {
+	switch err.Tag() {
+	case "required":
+		return "must be set"
+	case "oneof":
+		return "must be one of " + strings.ReplaceAll(err.Param(), " ", ", ") + fmt.Sprintf(", not %q", err.Value())
+	case "gt":
+		return "must be greater than " + err.Param()
+	case "min":
+		return "must be at least " + err.Param()
+	case "max":
+		return "must be at most " + err.Param()
+	case "url":
+		return fmt.Sprintf("must be a URL, not %q", err.Value())
+	case "timezone":
+		return fmt.Sprintf("unknown timezone %q", err.Value())
+	default:
+		return "must satisfy " + err.Tag()
+	}
+}
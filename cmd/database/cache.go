@@ -0,0 +1,409 @@
+package database
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"minecharts/cmd/logging"
+	"minecharts/cmd/redis"
+)
+
+// CacheConfig configures the cache of the lookups authenticating each request, the
+// users by ID and the API keys, and the batching of the API key uses.
+type CacheConfig struct {
+	TTL           time.Duration // How long an entry is served, 0 disables the cache
+	RedisURL      string        // Redis sharing the entries between the replicas, empty keeps them in memory
+	TouchInterval time.Duration // How often the API key uses are written, 0 on each use
+}
+
+// cacheTimeout bounds each access to the cache store, and flushTimeout each write of
+// the API key uses.
+const (
+	cacheTimeout = 500 * time.Millisecond
+	flushTimeout = 10 * time.Second
+)
+
+// Prefixes of the cache keys.
+const (
+	userCachePrefix   = "user:"
+	apiKeyCachePrefix = "apikey:"
+)
+
+// EnableCache puts a cache in front of the database initialized by InitDB. The
+// writes made through the database invalidate the entries they change, so a replica
+// sees its own changes at once; the other replicas see them once the entries expire,
+// or at once when they share the cache in Redis.
+func EnableCache(cfg CacheConfig) error {
+	if db == nil {
+		return errors.New("database not initialized")
+	}
+	if cfg.TTL <= 0 && cfg.TouchInterval <= 0 {
+		return nil
+	}
+
+	toucher, ok := db.(keyToucher)
+	if !ok {
+		return errors.New("database does not support batched API key uses")
+	}
+	c := &cache{
+		ttl:      cfg.TTL,
+		interval: cfg.TouchInterval,
+		toucher:  toucher,
+		uses:     map[int64]time.Time{},
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	switch {
+	case cfg.TTL > 0 && cfg.RedisURL != "":
+		client, err := redis.NewClient(cfg.RedisURL)
+		if err != nil {
+			return fmt.Errorf("cache Redis: %w", err)
+		}
+		c.store, c.storeName = &redisStore{client: client}, "redis"
+	case cfg.TTL > 0:
+		c.store, c.storeName = newMemoryStore(), "memory"
+	}
+
+	if c.interval > 0 {
+		go c.flushLoop()
+	} else {
+		close(c.done)
+	}
+	db = &cachedDB{DB: db, cache: c}
+
+	logging.DB.WithFields(
+		"ttl", cfg.TTL.String(),
+		"store", c.storeName,
+		"touch_interval", cfg.TouchInterval.String(),
+	).Info("Database cache enabled")
+	return nil
+}
+
+// keyToucher writes the uses of API keys in a batch, by key ID.
+type keyToucher interface {
+	touchAPIKeys(ctx context.Context, uses map[int64]time.Time) error
+}
+
+// cache holds the entries and the pending API key uses, shared by a cachedDB and the
+// cachedDBs of its transactions.
+type cache struct {
+	store     cacheStore // nil when the lookups are not cached
+	storeName string
+	ttl       time.Duration
+	interval  time.Duration
+	toucher   keyToucher
+	failures  atomic.Int64
+
+	mu   sync.Mutex
+	uses map[int64]time.Time // Last use of the API keys not written yet, by key ID
+
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// cachedDB serves the users and API keys from the cache, and invalidates them when
+// they are changed through it.
+type cachedDB struct {
+	DB
+	cache   *cache
+	pending *invalidations // Those of the transaction the DB runs in, nil outside WithTx
+}
+
+// invalidations are the entries a transaction changes, invalidated once it ends so
+// that no lookup caches them again before the commit.
+type invalidations struct {
+	keys     []string
+	allUsers bool
+}
+
+func userCacheKey(id int64) string {
+	return userCachePrefix + strconv.FormatInt(id, 10)
+}
+
+// apiKeyCacheKey names the entry of an API key after its hash, keeping the key out
+// of Redis.
+func apiKeyCacheKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return apiKeyCachePrefix + hex.EncodeToString(sum[:])
+}
+
+// cachedUser is a user as cached, with the fields its JSON leaves out.
+type cachedUser struct {
+	User
+	PasswordHash    string `json:"password_hash"`
+	RolePermissions int64  `json:"role_permissions"`
+}
+
+// GetUserByID serves the user from the cache. Transactions read the database, so
+// that what they write is based on the current user.
+func (c *cachedDB) GetUserByID(ctx context.Context, id int64) (*User, error) {
+	if c.cache.store == nil || c.pending != nil {
+		return c.DB.GetUserByID(ctx, id)
+	}
+
+	key := userCacheKey(id)
+	var cached cachedUser
+	if c.cache.read(ctx, key, &cached) {
+		user := cached.User
+		user.PasswordHash, user.rolePermissions = cached.PasswordHash, cached.RolePermissions
+		return &user, nil
+	}
+	user, err := c.DB.GetUserByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	c.cache.write(ctx, key, cachedUser{User: *user, PasswordHash: user.PasswordHash, RolePermissions: user.rolePermissions})
+	return user, nil
+}
+
+// GetAPIKey serves the API key from the cache. Its last use is the one written to
+// the database when it was cached.
+func (c *cachedDB) GetAPIKey(ctx context.Context, keyStr string) (*APIKey, error) {
+	if c.cache.store == nil || c.pending != nil {
+		return c.DB.GetAPIKey(ctx, keyStr)
+	}
+
+	cacheKey := apiKeyCacheKey(keyStr)
+	var key APIKey
+	if c.cache.read(ctx, cacheKey, &key) {
+		return &key, nil
+	}
+	found, err := c.DB.GetAPIKey(ctx, keyStr)
+	if err != nil {
+		return nil, err
+	}
+	c.cache.write(ctx, cacheKey, found)
+	return found, nil
+}
+
+// TouchAPIKey records the use of an API key, written with the others of the batch.
+func (c *cachedDB) TouchAPIKey(ctx context.Context, id int64) error {
+	if c.cache.interval <= 0 || c.pending != nil {
+		return c.DB.TouchAPIKey(ctx, id)
+	}
+	c.cache.mu.Lock()
+	c.cache.uses[id] = dbClock.Now()
+	c.cache.mu.Unlock()
+	return nil
+}
+
+func (c *cachedDB) UpdateUser(ctx context.Context, user *User) error {
+	defer c.invalidate(ctx, userCacheKey(user.ID))
+	return c.DB.UpdateUser(ctx, user)
+}
+
+func (c *cachedDB) DeleteUser(ctx context.Context, id int64) error {
+	defer c.invalidate(ctx, userCacheKey(id))
+	return c.DB.DeleteUser(ctx, id)
+}
+
+func (c *cachedDB) SoftDeleteUser(ctx context.Context, id int64, at time.Time) error {
+	defer c.invalidate(ctx, userCacheKey(id))
+	return c.DB.SoftDeleteUser(ctx, id, at)
+}
+
+func (c *cachedDB) RestoreUser(ctx context.Context, id int64, deletedAfter time.Time) error {
+	defer c.invalidate(ctx, userCacheKey(id))
+	return c.DB.RestoreUser(ctx, id, deletedAfter)
+}
+
+func (c *cachedDB) PurgeDeletedUsers(ctx context.Context, before time.Time) (int64, error) {
+	defer c.invalidateUsers(ctx)
+	return c.DB.PurgeDeletedUsers(ctx, before)
+}
+
+func (c *cachedDB) SetUserMinecraftAccount(ctx context.Context, userID int64, uuid, name string) error {
+	defer c.invalidate(ctx, userCacheKey(userID))
+	return c.DB.SetUserMinecraftAccount(ctx, userID, uuid, name)
+}
+
+// UpdateRole invalidates every user, as the permissions of those holding the role change.
+func (c *cachedDB) UpdateRole(ctx context.Context, role *Role) error {
+	defer c.invalidateUsers(ctx)
+	return c.DB.UpdateRole(ctx, role)
+}
+
+func (c *cachedDB) DeleteRole(ctx context.Context, id int64) error {
+	defer c.invalidateUsers(ctx)
+	return c.DB.DeleteRole(ctx, id)
+}
+
+func (c *cachedDB) AssignUserRole(ctx context.Context, userID, roleID int64) error {
+	defer c.invalidate(ctx, userCacheKey(userID))
+	return c.DB.AssignUserRole(ctx, userID, roleID)
+}
+
+func (c *cachedDB) UnassignUserRole(ctx context.Context, userID, roleID int64) error {
+	defer c.invalidate(ctx, userCacheKey(userID))
+	return c.DB.UnassignUserRole(ctx, userID, roleID)
+}
+
+func (c *cachedDB) DeleteAPIKey(ctx context.Context, id int64) error {
+	if key, err := c.DB.GetAPIKeyByID(ctx, id); err == nil {
+		defer c.invalidate(ctx, apiKeyCacheKey(key.Key))
+	}
+	return c.DB.DeleteAPIKey(ctx, id)
+}
+
+// WithTx runs fn in a transaction of the database, and invalidates the entries it
+// changed once the transaction ends.
+func (c *cachedDB) WithTx(ctx context.Context, fn func(tx DB) error) error {
+	if c.pending != nil {
+		return fn(c)
+	}
+	pending := &invalidations{}
+	defer func() {
+		if pending.allUsers {
+			c.cache.deletePrefix(ctx, userCachePrefix)
+		}
+		c.cache.delete(ctx, pending.keys...)
+	}()
+	return c.DB.WithTx(ctx, func(tx DB) error {
+		return fn(&cachedDB{DB: tx, cache: c.cache, pending: pending})
+	})
+}
+
+// Close writes the pending API key uses, then closes the cache and the database.
+func (c *cachedDB) Close() error {
+	c.cache.close()
+	return c.DB.Close()
+}
+
+func (c *cachedDB) invalidate(ctx context.Context, keys ...string) {
+	if c.pending != nil {
+		c.pending.keys = append(c.pending.keys, keys...)
+		return
+	}
+	c.cache.delete(ctx, keys...)
+}
+
+func (c *cachedDB) invalidateUsers(ctx context.Context) {
+	if c.pending != nil {
+		c.pending.allUsers = true
+		return
+	}
+	c.cache.deletePrefix(ctx, userCachePrefix)
+}
+
+// read decodes the entry of key into out, and reports whether it was found. A
+// failing store is a miss.
+func (c *cache) read(ctx context.Context, key string, out any) bool {
+	ctx, cancel := context.WithTimeout(ctx, cacheTimeout)
+	defer cancel()
+	value, err := c.store.get(ctx, key)
+	if err != nil {
+		c.failed(ctx, "read", err)
+		return false
+	}
+	return value != nil && json.Unmarshal(value, out) == nil
+}
+
+func (c *cache) write(ctx context.Context, key string, value any) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, cacheTimeout)
+	defer cancel()
+	if err := c.store.set(ctx, key, data, c.ttl); err != nil {
+		c.failed(ctx, "write", err)
+	}
+}
+
+func (c *cache) delete(ctx context.Context, keys ...string) {
+	if c.store == nil || len(keys) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), cacheTimeout)
+	defer cancel()
+	if err := c.store.delete(ctx, keys...); err != nil {
+		c.failed(ctx, "invalidate", err)
+	}
+}
+
+func (c *cache) deletePrefix(ctx context.Context, prefix string) {
+	if c.store == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), cacheTimeout)
+	defer cancel()
+	if err := c.store.deletePrefix(ctx, prefix); err != nil {
+		c.failed(ctx, "invalidate", err)
+	}
+}
+
+// failed logs a failure of the store, the first and then one in a hundred. The
+// lookups fall back to the database, and entries not invalidated expire.
+func (c *cache) failed(ctx context.Context, operation string, err error) {
+	if n := c.failures.Add(1); n == 1 || n%100 == 0 {
+		logging.DB.WithContext(ctx).WithFields(
+			"store", c.storeName,
+			"operation", operation,
+			"failures", n,
+			"error", err.Error(),
+		).Warn("Database cache failed")
+	}
+}
+
+// flushLoop writes the API key uses every interval, and a last time on close.
+func (c *cache) flushLoop() {
+	defer close(c.done)
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.stop:
+			c.flush()
+			return
+		case <-ticker.C:
+			c.flush()
+		}
+	}
+}
+
+// flush writes the pending API key uses. Those that fail are kept for the next
+// flush, unless the key was used again since.
+func (c *cache) flush() {
+	c.mu.Lock()
+	uses := c.uses
+	c.uses = map[int64]time.Time{}
+	c.mu.Unlock()
+	if len(uses) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), flushTimeout)
+	defer cancel()
+	if err := c.toucher.touchAPIKeys(ctx, uses); err != nil {
+		logging.DB.WithFields(
+			"keys", len(uses),
+			"error", err.Error(),
+		).Warn("Failed to write API key uses, retrying at the next flush")
+		c.mu.Lock()
+		for id, at := range uses {
+			if _, used := c.uses[id]; !used {
+				c.uses[id] = at
+			}
+		}
+		c.mu.Unlock()
+	}
+}
+
+func (c *cache) close() {
+	c.stopOnce.Do(func() {
+		close(c.stop)
+		<-c.done
+		if c.store != nil {
+			_ = c.store.close()
+		}
+	})
+}
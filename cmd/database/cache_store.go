@@ -0,0 +1,164 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"minecharts/cmd/redis"
+)
+
+// cacheStore keeps the entries of the cache, encoded.
+type cacheStore interface {
+	get(ctx context.Context, key string) ([]byte, error) // nil without error when missing
+	set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	delete(ctx context.Context, keys ...string) error
+	deletePrefix(ctx context.Context, prefix string) error
+	close() error
+}
+
+// memoryStore keeps the entries in the memory of the replica.
+type memoryStore struct {
+	mu        sync.Mutex
+	entries   map[string]memoryEntry
+	lastSweep time.Time
+}
+
+type memoryEntry struct {
+	value     []byte
+	expiresAt time.Time
+}
+
+// sweepInterval spaces the removals of the expired entries never read again.
+const sweepInterval = time.Minute
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{entries: map[string]memoryEntry{}, lastSweep: time.Now()}
+}
+
+func (m *memoryStore) get(_ context.Context, key string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry, ok := m.entries[key]
+	if !ok {
+		return nil, nil
+	}
+	if !time.Now().Before(entry.expiresAt) {
+		delete(m.entries, key)
+		return nil, nil
+	}
+	return entry.value, nil
+}
+
+func (m *memoryStore) set(_ context.Context, key string, value []byte, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	if now.Sub(m.lastSweep) > sweepInterval {
+		for k, entry := range m.entries {
+			if !now.Before(entry.expiresAt) {
+				delete(m.entries, k)
+			}
+		}
+		m.lastSweep = now
+	}
+	m.entries[key] = memoryEntry{value: value, expiresAt: now.Add(ttl)}
+	return nil
+}
+
+func (m *memoryStore) delete(_ context.Context, keys ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, key := range keys {
+		delete(m.entries, key)
+	}
+	return nil
+}
+
+func (m *memoryStore) deletePrefix(_ context.Context, prefix string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for key := range m.entries {
+		if strings.HasPrefix(key, prefix) {
+			delete(m.entries, key)
+		}
+	}
+	return nil
+}
+
+func (m *memoryStore) close() error {
+	return nil
+}
+
+// redisKeyPrefix namespaces the entries in a shared Redis.
+const redisKeyPrefix = "minecharts:cache:"
+
+// redisStore keeps the entries in Redis, shared by the replicas.
+type redisStore struct {
+	client *redis.Client
+}
+
+func (r *redisStore) get(ctx context.Context, key string) ([]byte, error) {
+	reply, err := r.client.Do(ctx, "GET", redisKeyPrefix+key)
+	if err != nil || reply == nil {
+		return nil, err
+	}
+	value, ok := reply.(string)
+	if !ok {
+		return nil, fmt.Errorf("unexpected Redis reply %v", reply)
+	}
+	return []byte(value), nil
+}
+
+func (r *redisStore) set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	_, err := r.client.Do(ctx, "SET", redisKeyPrefix+key, string(value), "PX", strconv.FormatInt(max(ttl.Milliseconds(), 1), 10))
+	return err
+}
+
+func (r *redisStore) delete(ctx context.Context, keys ...string) error {
+	args := []string{"DEL"}
+	for _, key := range keys {
+		args = append(args, redisKeyPrefix+key)
+	}
+	_, err := r.client.Do(ctx, args...)
+	return err
+}
+
+// deletePrefix deletes the entries of a prefix a batch at a time, with SCAN rather
+// than KEYS so that Redis keeps serving the other clients.
+func (r *redisStore) deletePrefix(ctx context.Context, prefix string) error {
+	cursor := "0"
+	for {
+		reply, err := r.client.Do(ctx, "SCAN", cursor, "MATCH", redisKeyPrefix+prefix+"*", "COUNT", "500")
+		if err != nil {
+			return err
+		}
+		page, ok := reply.([]interface{})
+		if !ok || len(page) != 2 {
+			return fmt.Errorf("unexpected Redis reply %v", reply)
+		}
+		cursor, _ = page[0].(string)
+		keys, _ := page[1].([]interface{})
+		if len(keys) > 0 {
+			args := []string{"DEL"}
+			for _, key := range keys {
+				if key, ok := key.(string); ok {
+					args = append(args, key)
+				}
+			}
+			if _, err := r.client.Do(ctx, args...); err != nil {
+				return err
+			}
+		}
+		if cursor == "0" || cursor == "" {
+			return nil
+		}
+	}
+}
+
+func (r *redisStore) close() error {
+	return r.client.Close()
+}
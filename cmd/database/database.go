@@ -2,10 +2,13 @@ package database
 
 import (
 	"context"
+	"database/sql"
 	"errors"
 	"os"
 	"sync"
+	"time"
 
+	"minecharts/cmd/clock"
 	"minecharts/cmd/logging"
 )
 
@@ -16,10 +19,34 @@ const (
 )
 
 var (
-	ErrUserExists      = errors.New("user already exists")
-	ErrUserNotFound    = errors.New("user not found")
-	ErrInvalidPassword = errors.New("invalid password")
-	ErrInvalidAPIKey   = errors.New("invalid API key")
+	ErrUserExists             = errors.New("user already exists")
+	ErrUserNotFound           = errors.New("user not found")
+	ErrInvalidPassword        = errors.New("invalid password")
+	ErrInvalidAPIKey          = errors.New("invalid API key")
+	ErrAPIKeyNotFound         = errors.New("API key not found")
+	ErrRequestNotFound        = errors.New("server request not found")
+	ErrNotificationNotFound   = errors.New("notification not found")
+	ErrTemplateExists         = errors.New("server template already exists")
+	ErrTemplateNotFound       = errors.New("server template not found")
+	ErrQuotaNotFound          = errors.New("user quota not found")
+	ErrTaskNotFound           = errors.New("scheduled task not found")
+	ErrJobNotFound            = errors.New("job not found")
+	ErrPluginNotFound         = errors.New("plugin not found")
+	ErrProxyNotFound          = errors.New("proxy not found")
+	ErrProxyExists            = errors.New("proxy already exists")
+	ErrProxyServerNotFound    = errors.New("server not registered behind the proxy")
+	ErrNonceUsed              = errors.New("nonce already used")
+	ErrNonceNotFound          = errors.New("nonce not found or expired")
+	ErrMinecraftAccountLinked = errors.New("minecraft account linked to another user")
+	ErrOAuthIdentityLinked    = errors.New("oauth identity already linked")
+	ErrOAuthIdentityNotFound  = errors.New("oauth identity not found")
+	ErrRoleExists             = errors.New("role already exists")
+	ErrRoleNotFound           = errors.New("role not found")
+	ErrRoleNotAssigned        = errors.New("role not assigned to the user")
+	ErrServerMemberNotFound   = errors.New("server member not found")
+	ErrOrganizationExists     = errors.New("organization already exists")
+	ErrOrganizationNotFound   = errors.New("organization not found")
+	ErrOrgMemberNotFound      = errors.New("organization member not found")
 )
 
 // DB is the interface that must be implemented by database providers
@@ -28,25 +55,163 @@ type DB interface {
 	CreateUser(ctx context.Context, user *User) error
 	GetUserByID(ctx context.Context, id int64) (*User, error)
 	GetUserByUsername(ctx context.Context, username string) (*User, error)
+	GetUserByEmail(ctx context.Context, email string) (*User, error)
 	UpdateUser(ctx context.Context, user *User) error
 	DeleteUser(ctx context.Context, id int64) error
+	SoftDeleteUser(ctx context.Context, id int64, at time.Time) error
+	RestoreUser(ctx context.Context, id int64, deletedAfter time.Time) error
+	PurgeDeletedUsers(ctx context.Context, before time.Time) (int64, error)
 	ListUsers(ctx context.Context) ([]*User, error)
+	SearchUsers(ctx context.Context, filter UserFilter) ([]*User, int, error)
+	CountUsers(ctx context.Context) (int, error)
+	SetUserMinecraftAccount(ctx context.Context, userID int64, uuid, name string) error
+
+	// User quota operations
+	GetUserQuota(ctx context.Context, userID int64) (*UserQuota, error)
+	SetUserQuota(ctx context.Context, quota *UserQuota) error
+	DeleteUserQuota(ctx context.Context, userID int64) error
+
+	// Role operations
+	CreateRole(ctx context.Context, role *Role) error
+	GetRole(ctx context.Context, id int64) (*Role, error)
+	ListRoles(ctx context.Context) ([]*Role, error)
+	UpdateRole(ctx context.Context, role *Role) error
+	DeleteRole(ctx context.Context, id int64) error
+	AssignUserRole(ctx context.Context, userID, roleID int64) error
+	UnassignUserRole(ctx context.Context, userID, roleID int64) error
+
+	// OAuth identity operations
+	CreateOAuthIdentity(ctx context.Context, identity *OAuthIdentity) error
+	GetOAuthIdentity(ctx context.Context, provider, subject string) (*OAuthIdentity, error)
+	ListUserOAuthIdentities(ctx context.Context, userID int64) ([]*OAuthIdentity, error)
+	DeleteOAuthIdentity(ctx context.Context, userID int64, provider string) error
 
 	// API Key operations
 	CreateAPIKey(ctx context.Context, key *APIKey) error
 	GetAPIKey(ctx context.Context, key string) (*APIKey, error)
+	TouchAPIKey(ctx context.Context, id int64) error
 	DeleteAPIKey(ctx context.Context, id int64) error
 	ListAPIKeysByUser(ctx context.Context, userID int64) ([]*APIKey, error)
+	GetAPIKeyByID(ctx context.Context, id int64) (*APIKey, error)
+	SearchAPIKeys(ctx context.Context, filter APIKeyFilter) ([]*APIKey, int, error)
 
 	// Server methods
 	CreateServerRecord(ctx context.Context, server *MinecraftServer) error
 	GetServerByName(ctx context.Context, serverName string) (*MinecraftServer, error)
 	ListServersByOwner(ctx context.Context, ownerID int64) ([]*MinecraftServer, error)
-	UpdateServerStatus(ctx context.Context, serverName string, status string) error
+	ListServers(ctx context.Context) ([]*MinecraftServer, error)
+	UpdateServerStatus(ctx context.Context, serverName string, status string, reason string) error
+	RenameServer(ctx context.Context, serverName, newName, deploymentName string) error
+	UpdateServerSpec(ctx context.Context, serverName string, spec ServerSpec) error
 	DeleteServerRecord(ctx context.Context, serverName string) error
 
+	// Server status change log operations
+	ListServerStatusChanges(ctx context.Context, afterID int64, limit int) ([]*ServerStatusChange, error)
+	ServerStatusCursor(ctx context.Context, at time.Time) (int64, error)
+	OldestServerStatusChangeID(ctx context.Context) (int64, error)
+	PruneServerStatusChanges(ctx context.Context, before time.Time) (int64, error)
+
+	// Server member operations
+	SaveServerMember(ctx context.Context, member *ServerMember) error
+	GetServerMember(ctx context.Context, serverID, userID int64) (*ServerMember, error)
+	ListServerMembers(ctx context.Context, serverID int64) ([]*ServerMember, error)
+	ListUserServerMemberships(ctx context.Context, userID int64) (map[string]int64, error)
+	DeleteServerMember(ctx context.Context, serverID, userID int64) error
+
+	// Organization operations
+	CreateOrganization(ctx context.Context, org *Organization, ownerID int64) error
+	GetOrganization(ctx context.Context, id int64) (*Organization, error)
+	ListOrganizations(ctx context.Context) ([]*Organization, error)
+	ListUserOrganizations(ctx context.Context, userID int64) ([]*Organization, error)
+	UpdateOrganization(ctx context.Context, org *Organization) error
+	DeleteOrganization(ctx context.Context, id int64) error
+	SaveOrganizationMember(ctx context.Context, member *OrganizationMember) error
+	GetOrganizationMember(ctx context.Context, orgID, userID int64) (*OrganizationMember, error)
+	ListOrganizationMembers(ctx context.Context, orgID int64) ([]*OrganizationMember, error)
+	DeleteOrganizationMember(ctx context.Context, orgID, userID int64) error
+	ListServersByOrganization(ctx context.Context, orgID int64) ([]*MinecraftServer, error)
+	SetServerOrganization(ctx context.Context, serverName string, orgID int64) error
+	TransferServers(ctx context.Context, fromUserID, toUserID int64) (int64, error)
+
+	// Server request operations
+	CreateServerRequest(ctx context.Context, req *ServerRequest) error
+	GetServerRequest(ctx context.Context, id int64) (*ServerRequest, error)
+	ListServerRequests(ctx context.Context, status string) ([]*ServerRequest, error)
+	ListServerRequestsByUser(ctx context.Context, userID int64) ([]*ServerRequest, error)
+	UpdateServerRequest(ctx context.Context, req *ServerRequest) error
+
+	// Server template operations
+	CreateServerTemplate(ctx context.Context, template *ServerTemplate) error
+	GetServerTemplate(ctx context.Context, id int64) (*ServerTemplate, error)
+	ListServerTemplates(ctx context.Context) ([]*ServerTemplate, error)
+	UpdateServerTemplate(ctx context.Context, template *ServerTemplate) error
+	DeleteServerTemplate(ctx context.Context, id int64) error
+
+	// Scheduled task operations
+	CreateScheduledTask(ctx context.Context, task *ScheduledTask) error
+	GetScheduledTask(ctx context.Context, id int64) (*ScheduledTask, error)
+	ListScheduledTasks(ctx context.Context, serverID int64) ([]*ScheduledTask, error)
+	ListDueScheduledTasks(ctx context.Context, at time.Time) ([]*ScheduledTask, error)
+	UpdateScheduledTask(ctx context.Context, task *ScheduledTask) error
+	ClaimScheduledTask(ctx context.Context, task *ScheduledTask, runAt time.Time, next *time.Time) (bool, error)
+	DeleteScheduledTask(ctx context.Context, id int64) error
+
+	// Task run operations
+	CreateTaskRun(ctx context.Context, run *TaskRun) error
+	FinishTaskRun(ctx context.Context, run *TaskRun) error
+	ListTaskRuns(ctx context.Context, taskID int64, limit int) ([]*TaskRun, error)
+	PruneTaskRuns(ctx context.Context, taskID int64, keep int) error
+
+	// Job operations
+	CreateJob(ctx context.Context, job *Job) error
+	GetJob(ctx context.Context, id int64) (*Job, error)
+	ListServerJobs(ctx context.Context, serverName string, limit int) ([]*Job, error)
+	UpdateJob(ctx context.Context, job *Job) error
+	FailUnfinishedJobs(ctx context.Context, reason string) (int64, error)
+
+	// Server plugin operations
+	SaveServerPlugin(ctx context.Context, plugin *ServerPlugin) error
+	GetServerPlugin(ctx context.Context, serverID int64, projectID string) (*ServerPlugin, error)
+	ListServerPlugins(ctx context.Context, serverID int64) ([]*ServerPlugin, error)
+	DeleteServerPlugin(ctx context.Context, serverID int64, projectID string) error
+
+	// Proxy operations
+	CreateProxy(ctx context.Context, proxy *Proxy) error
+	GetProxyByName(ctx context.Context, name string) (*Proxy, error)
+	ListProxies(ctx context.Context) ([]*Proxy, error)
+	DeleteProxy(ctx context.Context, id int64) error
+	SaveProxyServer(ctx context.Context, member *ProxyServer) error
+	ListProxyServers(ctx context.Context, proxyID int64) ([]*ProxyServer, error)
+	DeleteProxyServer(ctx context.Context, proxyID, serverID int64) error
+
+	// Nonce operations, the one-time values shared by the replicas
+	CreateNonce(ctx context.Context, purpose, value, data string, expiresAt time.Time) error
+	ConsumeNonce(ctx context.Context, purpose, value string) (string, error)
+	NonceExists(ctx context.Context, purpose, value string) (bool, error)
+
+	// Notification operations
+	CreateNotification(ctx context.Context, notification *Notification) error
+	ListNotificationsByUser(ctx context.Context, userID int64, unreadOnly bool) ([]*Notification, error)
+	MarkNotificationRead(ctx context.Context, userID int64, id int64) error
+
+	// Audit log operations
+	CreateAuditEvent(ctx context.Context, event *AuditEvent) error
+	ListAuditEvents(ctx context.Context, filter AuditFilter) ([]*AuditEvent, error)
+
+	// Incident operations
+	CreateIncident(ctx context.Context, incident *Incident) error
+	ListServerIncidents(ctx context.Context, serverID, beforeID int64, limit int) ([]*Incident, error)
+
 	// Database operations
+	// WithTx calls fn with a DB running its operations in a transaction, committed when
+	// fn returns nil and rolled back otherwise, its error returned as is. fn must make
+	// all its operations through tx, and may be called again when the transaction
+	// conflicts with a concurrent one, so it must not have other side effects. Within a
+	// transaction, WithTx joins it.
+	WithTx(ctx context.Context, fn func(tx DB) error) error
 	Init() error
+	Ping(ctx context.Context) error
+	PoolStats() map[string]sql.DBStats
 	Close() error
 }
 
@@ -56,8 +221,23 @@ var (
 	dbOnce sync.Once
 )
 
-// InitDB initializes the database with the provided configuration
-func InitDB(dbType string, connectionString string) error {
+// dbClock tells the time records are created and API keys used at.
+var dbClock clock.Clock = clock.System
+
+// SetClock replaces the clock the database records times with.
+func SetClock(c clock.Clock) {
+	dbClock = c
+}
+
+// SetDB replaces the global database instance, such as with a throwaway SQLite
+// database in the tests.
+func SetDB(d DB) {
+	db = d
+}
+
+// InitDB initializes the database with the provided configuration. The read
+// replica connection string is only used by PostgreSQL, and may be empty.
+func InitDB(dbType, connectionString, replicaConnectionString string, pool PoolConfig) error {
 	logging.DB.WithFields(
 		"db_type", dbType,
 	).Info("Initializing database")
@@ -70,20 +250,20 @@ func InitDB(dbType string, connectionString string) error {
 				"connection", connectionString,
 				"db_type", "sqlite",
 			).Info("Creating SQLite database connection")
-			db, err = NewSQLiteDB(connectionString)
+			db, err = NewSQLiteDB(connectionString, pool)
 		case PostgreSQL:
 			logging.DB.WithFields(
 				"connection", connectionString,
 				"db_type", "postgres",
 			).Info("Creating PostgreSQL database connection")
-			db, err = NewPostgresDB(connectionString)
+			db, err = NewPostgresDB(connectionString, replicaConnectionString, pool)
 		default:
 			// Default to SQLite if not specified
 			logging.DB.WithFields(
 				"requested_type", dbType,
 				"using_type", "sqlite",
 			).Warn("Unknown database type, using SQLite as default")
-			db, err = NewSQLiteDB(connectionString)
+			db, err = NewSQLiteDB(connectionString, pool)
 		}
 
 		if err != nil {
@@ -146,7 +326,7 @@ func GetDB() DB {
 		logging.DB.WithFields(
 			"db_path", dbPath,
 		).Info("Initializing default SQLite database")
-		InitDB(SQLite, dbPath)
+		InitDB(SQLite, dbPath, "", PoolConfig{})
 	} else {
 		logging.DB.Debug("Using existing database instance")
 	}
@@ -5,7 +5,12 @@
 package database
 
 import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
 	"minecharts/cmd/logging"
+	"sort"
+	"strings"
 	"time"
 )
 
@@ -19,56 +24,778 @@ const (
 	PermRestartServer                   // Can restart servers
 	PermExecCommand                     // Can execute commands on servers
 	PermViewServer                      // Can view server details
+	PermExposeServer                    // Can expose servers on the network
 )
 
 // Common permissions groups provide pre-defined combinations of permissions.
 var (
 	// PermAll grants all permissions
 	PermAll int64 = PermAdmin | PermCreateServer | PermDeleteServer | PermStartServer |
-		PermStopServer | PermRestartServer | PermExecCommand | PermViewServer
+		PermStopServer | PermRestartServer | PermExecCommand | PermViewServer | PermExposeServer
 
 	// PermReadOnly grants only view permissions
 	PermReadOnly int64 = PermViewServer
 
 	// PermOperator grants everything except admin permissions
 	PermOperator int64 = PermCreateServer | PermDeleteServer | PermStartServer |
-		PermStopServer | PermRestartServer | PermExecCommand | PermViewServer
+		PermStopServer | PermRestartServer | PermExecCommand | PermViewServer | PermExposeServer
+
+	// PermServerMember are the permissions the owner of a server can grant its members
+	PermServerMember int64 = PermViewServer | PermExecCommand | PermRestartServer
 )
 
+// PermissionFlagNames returns the names of the permission flags set in permissions,
+// sorted.
+func PermissionFlagNames(permissions int64) []string {
+	names := []string{}
+	for name, flag := range PermissionNames {
+		// PermReadOnly is PermViewServer under another name
+		if flag&(flag-1) == 0 && permissions&flag != 0 && name != "PermReadOnly" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// PermissionNames names the permission flags and groups, as listed by GET /permissions
+// and mapped to OAuth groups.
+var PermissionNames = map[string]int64{
+	"PermAdmin":         PermAdmin,
+	"PermCreateServer":  PermCreateServer,
+	"PermDeleteServer":  PermDeleteServer,
+	"PermStartServer":   PermStartServer,
+	"PermStopServer":    PermStopServer,
+	"PermRestartServer": PermRestartServer,
+	"PermExecCommand":   PermExecCommand,
+	"PermViewServer":    PermViewServer,
+	"PermExposeServer":  PermExposeServer,
+	"PermOperator":      PermOperator,
+	"PermAll":           PermAll,
+	"PermReadOnly":      PermReadOnly,
+}
+
+// legacyDefaultAdminHashes are the password hashes of the admin/admin account
+// that older releases created on first start.
+var legacyDefaultAdminHashes = []string{
+	"$2a$10$lCLlDMorzUH3R9pwSehyau1DISGeEdL21xpSzy7mjFwQ.CYYnydrW",
+	"$2a$10$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p92ldGxad68LJZdL17lhWy",
+}
+
 // APIKey represents an API key for machine authentication.
 type APIKey struct {
 	ID          int64      `json:"id"`
 	UserID      int64      `json:"user_id"`
+	Username    string     `json:"username,omitempty"` // Username of the user, read with the keys of every user
 	Key         string     `json:"key"`
 	Description string     `json:"description"`
-	LastUsed    time.Time  `json:"last_used"`
+	Permissions int64      `json:"permissions"`          // Permissions the key is restricted to, 0 for all those of its user
+	ServerIDs   ServerIDs  `json:"server_ids,omitempty"` // Servers the key is restricted to, none for all
+	LastUsed    *time.Time `json:"last_used"`            // Nil until the key is first used
 	ExpiresAt   *time.Time `json:"expires_at,omitempty"` // Make this a pointer to allow null values
 	CreatedAt   time.Time  `json:"created_at"`
 }
 
+// Expired checks if the key has expired at a time. Keys expire at their ExpiresAt
+// time, and those without one never do.
+func (k *APIKey) Expired(now time.Time) bool {
+	return k.ExpiresAt != nil && !k.ExpiresAt.IsZero() && !now.Before(*k.ExpiresAt)
+}
+
+// AllowsServer checks if the key can be used on a server.
+func (k *APIKey) AllowsServer(serverID int64) bool {
+	if len(k.ServerIDs) == 0 {
+		return true
+	}
+	for _, id := range k.ServerIDs {
+		if id == serverID {
+			return true
+		}
+	}
+	return false
+}
+
+// ServerIDs are the IDs of the servers an API key is restricted to, stored as a
+// JSON document.
+type ServerIDs []int64
+
+// Value stores the IDs as a JSON document.
+func (ids ServerIDs) Value() (driver.Value, error) {
+	if len(ids) == 0 {
+		return "", nil
+	}
+	data, err := json.Marshal(ids)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// Scan reads IDs stored as a JSON document. Keys created before they could be
+// restricted to servers have an empty value and no IDs.
+func (ids *ServerIDs) Scan(src interface{}) error {
+	var data []byte
+	switch v := src.(type) {
+	case nil:
+		*ids = nil
+		return nil
+	case string:
+		data = []byte(v)
+	case []byte:
+		data = v
+	default:
+		return fmt.Errorf("unsupported server IDs type %T", src)
+	}
+	if len(data) == 0 {
+		*ids = nil
+		return nil
+	}
+	return json.Unmarshal(data, ids)
+}
+
+// OAuthIdentity links a user to their account at an OAuth provider, by the subject
+// the provider identifies it with.
+type OAuthIdentity struct {
+	ID        int64     `json:"id"`
+	UserID    int64     `json:"user_id"`
+	Provider  string    `json:"provider" example:"github"`
+	Subject   string    `json:"subject" example:"583231"`
+	Email     string    `json:"email,omitempty"` // Email of the account at the provider when it was linked
+	CreatedAt time.Time `json:"created_at"`
+}
+
 // User represents a user in the system with their permissions and account details.
 type User struct {
-	ID           int64      `json:"id"`
-	Username     string     `json:"username"`
-	Email        string     `json:"email"`
-	PasswordHash string     `json:"-"` // Never expose in JSON
-	Permissions  int64      `json:"permissions"`
-	Active       bool       `json:"active"`
-	LastLogin    *time.Time `json:"last_login"`
-	CreatedAt    time.Time  `json:"created_at"`
-	UpdatedAt    time.Time  `json:"updated_at"`
+	ID                     int64      `json:"id"`
+	Username               string     `json:"username"`
+	Email                  string     `json:"email"`
+	DisplayName            string     `json:"display_name,omitempty"` // Name the user is shown with, their username when empty
+	PasswordHash           string     `json:"-"`                      // Never expose in JSON
+	Permissions            int64      `json:"permissions"`            // Effective permissions, those granted directly and by the roles
+	DirectPermissions      int64      `json:"direct_permissions"`     // Permissions granted to the user besides their roles
+	Roles                  []string   `json:"roles"`
+	Active                 bool       `json:"active"`
+	PasswordChangeRequired bool       `json:"password_change_required"` // Blocks everything but a password change
+	MinecraftUUID          string     `json:"minecraft_uuid,omitempty"` // Verified Minecraft account of the user
+	MinecraftName          string     `json:"minecraft_name,omitempty"`
+	LastLogin              *time.Time `json:"last_login"`
+	DeletedAt              *time.Time `json:"deleted_at,omitempty"` // When the user was deleted, until purged past the grace period
+	CreatedAt              time.Time  `json:"created_at"`
+	UpdatedAt              time.Time  `json:"updated_at"`
+
+	rolePermissions int64 // Permissions granted by the roles
+	permissionScope int64 // Permissions of the API key the user authenticated with, 0 for all
+}
+
+// ScopePermissions restricts the user to the permissions of the API key they
+// authenticated with: their global permissions, those on the servers they own and
+// those granted to them on other servers. A zero mask keeps every permission.
+func (u *User) ScopePermissions(mask int64) {
+	if mask == 0 {
+		return
+	}
+	u.permissionScope = mask
+	u.Permissions &= mask
+}
+
+// Scoped restricts permissions granted to the user to those of the API key they
+// authenticated with, as ScopePermissions.
+func (u *User) Scoped(permissions int64) int64 {
+	if u.permissionScope == 0 {
+		return permissions
+	}
+	return permissions & u.permissionScope
+}
+
+// HasPermissionScope checks if the user authenticated with an API key restricted
+// to some of their permissions.
+func (u *User) HasPermissionScope() bool {
+	return u.permissionScope != 0
+}
+
+// SetDirectPermissions sets the permissions granted to the user besides their roles,
+// and their effective permissions.
+func (u *User) SetDirectPermissions(permissions int64) {
+	u.DirectPermissions = permissions
+	u.Permissions = permissions | u.rolePermissions
+}
+
+// Role is a named set of permissions. Users get the permissions of their roles on top
+// of those granted to them directly.
+type Role struct {
+	ID          int64     `json:"id"`
+	Name        string    `json:"name" example:"moderators"`
+	Description string    `json:"description,omitempty"`
+	Permissions int64     `json:"permissions" example:"184"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
 }
 
 // MinecraftServer represents a Minecraft server record
 type MinecraftServer struct {
+	ID             int64      `json:"id"`
+	ServerName     string     `json:"server_name"`
+	DeploymentName string     `json:"deployment_name"`
+	PVCName        string     `json:"pvc_name"`
+	OwnerID        int64      `json:"owner_id"`
+	OrgID          int64      `json:"org_id,omitempty"`    // Organization owning the server, 0 for the servers of OwnerID
+	Namespace      string     `json:"namespace,omitempty"` // Kubernetes namespace of its resources, empty for the default namespace
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+	Status         string     `json:"status"`
+	StatusReason   string     `json:"status_reason,omitempty"`
+	Spec           ServerSpec `json:"spec"`
+}
+
+// ServerMember is a user the owner of a server granted permissions on it, on top of
+// their global permissions.
+type ServerMember struct {
+	ServerID    int64     `json:"server_id"`
+	UserID      int64     `json:"user_id"`
+	Username    string    `json:"username"`
+	Permissions int64     `json:"permissions"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// Organization is a group of users managing servers together. The servers it owns
+// remain counted in the quota of the users who created them.
+type Organization struct {
+	ID          int64     `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	Role        string    `json:"role,omitempty"` // Role of the user the organizations are listed for
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// OrganizationMember is a user of an organization with their role in it.
+type OrganizationMember struct {
+	OrgID     int64     `json:"org_id"`
+	UserID    int64     `json:"user_id"`
+	Username  string    `json:"username"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Organization roles. Members operate the servers of the organization, admins have
+// every permission on them and manage the members, and owners also manage the
+// organization and its owners.
+const (
+	OrgRoleMember = "member"
+	OrgRoleAdmin  = "admin"
+	OrgRoleOwner  = "owner"
+)
+
+// OrgRolePermissions are the permissions the roles of an organization grant on the
+// servers it owns.
+var OrgRolePermissions = map[string]int64{
+	OrgRoleMember: PermViewServer | PermStartServer | PermStopServer | PermRestartServer,
+	OrgRoleAdmin:  PermOperator &^ PermCreateServer,
+	OrgRoleOwner:  PermOperator &^ PermCreateServer,
+}
+
+// ManagesOrganization reports whether the role can manage the members of an
+// organization and move servers in and out of it.
+func (m *OrganizationMember) ManagesOrganization() bool {
+	return m.Role == OrgRoleAdmin || m.Role == OrgRoleOwner
+}
+
+// Server provisioning states. A server is created in ServerStatusCreating, moves to
+// ServerStatusStarting once its deployment exists and to ServerStatusRunning when its
+// pod is ready; ServerStatusFailed records the reason it could not start.
+// ServerStatusHibernated is a server stopped by the idle shutdown rather than a user.
+const (
+	ServerStatusCreating   = "creating"
+	ServerStatusStarting   = "starting"
+	ServerStatusRunning    = "running"
+	ServerStatusFailed     = "failed"
+	ServerStatusStopped    = "stopped"
+	ServerStatusHibernated = "hibernated"
+)
+
+// ServerStatusDeleted is logged in the status change log when a server record is
+// deleted, or renamed away from its name.
+const ServerStatusDeleted = "deleted"
+
+// ServerStatusChange is an entry of the server status change log, which records
+// every status a server went through so that clients can fetch the servers that
+// changed since they last listed them.
+type ServerStatusChange struct {
+	ID           int64     `json:"id"`
+	ServerName   string    `json:"server_name"`
+	OwnerID      int64     `json:"owner_id"`
+	Status       string    `json:"status"`
+	StatusReason string    `json:"status_reason,omitempty"`
+	ChangedAt    time.Time `json:"changed_at"`
+}
+
+// ServerSpec is the typed configuration a server was created with.
+// Env holds additional raw environment variables for the itzg/minecraft-server image.
+// Labels are custom key/value pairs set on the Kubernetes objects and the metrics of the server.
+type ServerSpec struct {
+	Version       string            `json:"version,omitempty" example:"1.21.4"`
+	ServerType    string            `json:"serverType,omitempty" example:"paper"`
+	Memory        string            `json:"memory,omitempty" example:"4G"`
+	MaxPlayers    int               `json:"maxPlayers,omitempty" example:"20"`
+	Seed          string            `json:"seed,omitempty" example:"-4172144997902289642"`
+	Gamemode      string            `json:"gamemode,omitempty" example:"survival"`
+	Difficulty    string            `json:"difficulty,omitempty" example:"normal"`
+	MOTD          string            `json:"motd,omitempty" example:"Welcome to survival"`
+	Modpack       string            `json:"modpack,omitempty" example:"https://example.com/modpack.zip"`
+	StorageSize   string            `json:"storageSize,omitempty" example:"20Gi"`
+	StorageClass  string            `json:"storageClass,omitempty" example:"fast-ssd"`
+	Resources     *ServerResources  `json:"resources,omitempty"`
+	Env           map[string]string `json:"env,omitempty" example:"{\"VIEW_DISTANCE\":\"12\"}"`
+	Labels        map[string]string `json:"labels,omitempty" example:"{\"environment\":\"prod\"}"`
+	SyncWhitelist bool              `json:"syncWhitelist,omitempty"` // Keeps the linked accounts of the users who can view the server whitelisted
+	Bedrock       bool              `json:"bedrock,omitempty"`       // Installs Geyser and Floodgate so that Bedrock clients can join, paper and fabric only
+	// PriorityClass of the server pod, one of MINECHARTS_PRIORITY_CLASSES
+	PriorityClass string `json:"priorityClass,omitempty" example:"game-servers"`
+	// Creates a PodDisruptionBudget, so that drains and autoscaling do not evict the running server
+	DisruptionBudget bool `json:"disruptionBudget,omitempty"`
+	// Node labels the server pod must be scheduled on, such as a dedicated node pool
+	NodeSelector map[string]string  `json:"nodeSelector,omitempty" example:"{\"pool\":\"minecraft\"}"`
+	Tolerations  []ServerToleration `json:"tolerations,omitempty"`
+	Affinity     *ServerAffinity    `json:"affinity,omitempty"`
+	// Runs the server pod with a Deployment (default) or, with MINECHARTS_STATEFULSETS, a StatefulSet
+	Workload string `json:"workload,omitempty" example:"statefulset"`
+}
+
+// ServerResources holds the CPU and memory requests and limits of the server container,
+// as Kubernetes quantities.
+type ServerResources struct {
+	CPURequest    string `json:"cpuRequest,omitempty" example:"500m"`
+	CPULimit      string `json:"cpuLimit,omitempty" example:"2"`
+	MemoryRequest string `json:"memoryRequest,omitempty" example:"4Gi"`
+	MemoryLimit   string `json:"memoryLimit,omitempty" example:"5Gi"`
+}
+
+// ServerToleration lets the server pod run on the nodes with a matching taint.
+type ServerToleration struct {
+	Key      string `json:"key,omitempty" example:"dedicated"`
+	Operator string `json:"operator,omitempty" example:"Equal"` // Equal (default) or Exists
+	Value    string `json:"value,omitempty" example:"minecraft"`
+	Effect   string `json:"effect,omitempty" example:"NoSchedule"` // NoSchedule, PreferNoSchedule or NoExecute, empty for all
+}
+
+// ServerAffinity attracts the server pod to nodes and keeps it away from the other servers.
+type ServerAffinity struct {
+	RequiredNodes  []NodeRequirement `json:"requiredNodes,omitempty"`  // Rules the node must match
+	PreferredNodes []NodeRequirement `json:"preferredNodes,omitempty"` // Rules the scheduler favours the nodes matching
+	// Keeps the pod off the nodes running other servers: preferred or required
+	ServerAntiAffinity string `json:"serverAntiAffinity,omitempty" example:"preferred"`
+}
+
+// NodeRequirement matches the labels of a node.
+type NodeRequirement struct {
+	Key      string   `json:"key" example:"node.kubernetes.io/instance-type"`
+	Operator string   `json:"operator" example:"In"` // In, NotIn, Exists, DoesNotExist, Gt or Lt
+	Values   []string `json:"values,omitempty"`
+}
+
+// Value stores the spec as a JSON document.
+func (s ServerSpec) Value() (driver.Value, error) {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// Scan reads a spec stored as a JSON document. Servers created before specs
+// were recorded have an empty value and get a zero spec.
+func (s *ServerSpec) Scan(src interface{}) error {
+	var data []byte
+	switch v := src.(type) {
+	case nil:
+		*s = ServerSpec{}
+		return nil
+	case string:
+		data = []byte(v)
+	case []byte:
+		data = v
+	default:
+		return fmt.Errorf("unsupported server spec type %T", src)
+	}
+	if len(data) == 0 {
+		*s = ServerSpec{}
+		return nil
+	}
+	return json.Unmarshal(data, s)
+}
+
+// UserQuota limits the servers a user can own. A zero or empty limit is unlimited,
+// and users without a quota are not limited.
+type UserQuota struct {
+	UserID     int64     `json:"user_id"`
+	MaxServers int       `json:"max_servers" example:"3"`
+	MaxMemory  string    `json:"max_memory,omitempty" example:"12Gi"`
+	MaxStorage string    `json:"max_storage,omitempty" example:"100Gi"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// ServerTemplate is a reusable server preset defined by administrators.
+// Servers created with a template start from its spec, where the {{NAME}}
+// placeholders of its parameters are replaced by the values given at creation.
+type ServerTemplate struct {
+	ID          int64              `json:"id"`
+	Name        string             `json:"name"`
+	Description string             `json:"description,omitempty"`
+	Category    string             `json:"category,omitempty" example:"modded"`
+	Spec        ServerSpec         `json:"spec"`
+	Parameters  TemplateParameters `json:"parameters,omitempty"`
+	Source      string             `json:"source,omitempty"` // URL the template was imported from
+	CreatedBy   int64              `json:"created_by"`
+	CreatedAt   time.Time          `json:"created_at"`
+	UpdatedAt   time.Time          `json:"updated_at"`
+}
+
+// TemplateParameter is a value filled in when creating a server from a template.
+// Parameters without a default must be given unless they are optional, in which
+// case their placeholders are replaced by an empty value.
+type TemplateParameter struct {
+	Name        string   `json:"name" example:"MEMORY"`
+	Description string   `json:"description,omitempty" example:"JVM heap size"`
+	Type        string   `json:"type" example:"memory"`
+	Default     string   `json:"default,omitempty" example:"4G"`
+	Optional    bool     `json:"optional,omitempty"`
+	Min         *int64   `json:"min,omitempty"`     // Smallest integer, or smallest size in megabytes for memory
+	Max         *int64   `json:"max,omitempty"`     // Largest integer, or largest size in megabytes for memory
+	Options     []string `json:"options,omitempty"` // Values allowed for enum parameters
+	Pattern     string   `json:"pattern,omitempty"` // Regular expression string values must match
+}
+
+// Template parameter types
+const (
+	TemplateParameterString  = "string"
+	TemplateParameterInteger = "integer"
+	TemplateParameterBoolean = "boolean"
+	TemplateParameterMemory  = "memory" // JVM heap size, such as 4G or 2048M
+	TemplateParameterEnum    = "enum"
+)
+
+// TemplateParameters are the parameters of a template, stored as a JSON document.
+type TemplateParameters []TemplateParameter
+
+// Value stores the parameters as a JSON document.
+func (p TemplateParameters) Value() (driver.Value, error) {
+	if len(p) == 0 {
+		return "", nil
+	}
+	data, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// Scan reads parameters stored as a JSON document. Templates created before
+// parameters were supported have an empty value and no parameters.
+func (p *TemplateParameters) Scan(src interface{}) error {
+	var data []byte
+	switch v := src.(type) {
+	case nil:
+		*p = nil
+		return nil
+	case string:
+		data = []byte(v)
+	case []byte:
+		data = v
+	default:
+		return fmt.Errorf("unsupported template parameters type %T", src)
+	}
+	if len(data) == 0 {
+		*p = nil
+		return nil
+	}
+	return json.Unmarshal(data, p)
+}
+
+// Server request statuses.
+const (
+	ServerRequestPending  = "pending"
+	ServerRequestApproved = "approved"
+	ServerRequestRejected = "rejected"
+)
+
+// ServerRequest is a server creation request awaiting administrator review.
+// Payload holds the original creation request so it can be replayed on approval.
+type ServerRequest struct {
+	ID          int64           `json:"id"`
+	ServerName  string          `json:"server_name"`
+	RequesterID int64           `json:"requester_id"`
+	Payload     json.RawMessage `json:"payload"`
+	Status      string          `json:"status"`
+	Reason      string          `json:"reason,omitempty"`
+	ReviewerID  *int64          `json:"reviewer_id,omitempty"`
+	CreatedAt   time.Time       `json:"created_at"`
+	ReviewedAt  *time.Time      `json:"reviewed_at,omitempty"`
+}
+
+// Notification is a message addressed to a user.
+type Notification struct {
+	ID        int64     `json:"id"`
+	UserID    int64     `json:"user_id"`
+	Message   string    `json:"message"`
+	Read      bool      `json:"read"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Scheduled task actions.
+const (
+	TaskActionRestart   = "restart"   // Saves the world and restarts the server
+	TaskActionBackup    = "backup"    // Archives the server data into its backups directory
+	TaskActionCommand   = "command"   // Runs the payload on the server console
+	TaskActionBroadcast = "broadcast" // Announces the payload to the players
+	TaskActionCleanup   = "cleanup"   // Removes old logs, crash reports and backups, as selected by the payload
+)
+
+// ScheduledTask is an action run on a server on a cron schedule.
+// NextRunAt is empty while the task is disabled.
+type ScheduledTask struct {
+	ID         int64      `json:"id"`
+	ServerID   int64      `json:"server_id"`
+	ServerName string     `json:"server_name"`
+	Name       string     `json:"name"`
+	Schedule   string     `json:"schedule"`
+	Action     string     `json:"action"`
+	Payload    string     `json:"payload,omitempty"`
+	Enabled    bool       `json:"enabled"`
+	CreatedBy  int64      `json:"created_by"`
+	RunCount   int64      `json:"run_count"`
+	LastRunAt  *time.Time `json:"last_run_at,omitempty"`
+	NextRunAt  *time.Time `json:"next_run_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+}
+
+// Task run statuses.
+const (
+	TaskRunRunning   = "running"
+	TaskRunSucceeded = "succeeded"
+	TaskRunFailed    = "failed"
+	TaskRunSkipped   = "skipped" // The server was not running
+)
+
+// TaskRun records a run of a scheduled task.
+type TaskRun struct {
+	ID         int64      `json:"id"`
+	TaskID     int64      `json:"task_id"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	Status     string     `json:"status"`
+	Output     string     `json:"output,omitempty"`
+}
+
+// Job types.
+const (
+	JobTypeClone   = "clone"   // Copies a server and its data under a new name
+	JobTypeUpgrade = "upgrade" // Backs a server up and moves it to another Minecraft version
+)
+
+// Job statuses.
+const (
+	JobPending   = "pending"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+)
+
+// Job records a long-running operation on a server, run in the background.
+// OwnerID is the owner of the server when the job was created, for permission
+// checks once the server is gone.
+type Job struct {
+	ID         int64      `json:"id"`
+	Type       string     `json:"type"`
+	ServerName string     `json:"server_name"`
+	OwnerID    int64      `json:"owner_id"`
+	Status     string     `json:"status"`
+	Progress   int        `json:"progress"` // Percent done
+	Message    string     `json:"message,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	CreatedBy  int64      `json:"created_by"`
+	CreatedAt  time.Time  `json:"created_at"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+}
+
+// ServerPlugin is a plugin or mod installed on a server from a mod platform.
+// A server has at most one version of each project.
+type ServerPlugin struct {
+	ID          int64     `json:"id"`
+	ServerID    int64     `json:"server_id"`
+	Platform    string    `json:"platform" example:"modrinth"`
+	ProjectID   string    `json:"project_id" example:"Lu3KuzdV"`
+	ProjectSlug string    `json:"project_slug" example:"coreprotect"`
+	Name        string    `json:"name" example:"CoreProtect"`
+	VersionID   string    `json:"version_id" example:"b0Yxyasa"`
+	Version     string    `json:"version" example:"22.4"`
+	Directory   string    `json:"directory" example:"plugins"` // "plugins" or "mods", in the server volume
+	FileName    string    `json:"file_name" example:"CoreProtect-22.4.jar"`
+	SHA512      string    `json:"sha512"`
+	InstalledBy int64     `json:"installed_by"`
+	InstalledAt time.Time `json:"installed_at"`
+}
+
+// Proxy is a Velocity proxy players join a network of servers through.
+type Proxy struct {
 	ID             int64     `json:"id"`
-	ServerName     string    `json:"server_name"`
-	DeploymentName string    `json:"deployment_name"`
-	PVCName        string    `json:"pvc_name"`
+	Name           string    `json:"name" example:"network"`
 	OwnerID        int64     `json:"owner_id"`
+	DeploymentName string    `json:"deployment_name" example:"minecraft-proxy-network"`
+	ForwardingMode string    `json:"forwarding_mode" example:"modern"` // Player info forwarding of Velocity: "none", "legacy", "bungeeguard" or "modern"
 	CreatedAt      time.Time `json:"created_at"`
 	UpdatedAt      time.Time `json:"updated_at"`
-	Status         string    `json:"status"`
+}
+
+// ProxyServer is a server registered behind a proxy.
+type ProxyServer struct {
+	ProxyID        int64     `json:"proxy_id"`
+	ServerID       int64     `json:"server_id"`
+	ServerName     string    `json:"server_name" example:"lobby"`
+	DeploymentName string    `json:"deployment_name" example:"minecraft-server-lobby"`
+	Namespace      string    `json:"namespace,omitempty"` // Of the server, empty for the default namespace
+	Lobby          bool      `json:"lobby"`               // Players join the lobbies first, in the order they were added
+	AddedBy        int64     `json:"added_by"`
+	AddedAt        time.Time `json:"added_at"`
+}
+
+// AuditEvent is a state-changing API call, recorded with who made it and how it ended.
+// The request body is summarized by the names of its fields, never their values.
+type AuditEvent struct {
+	ID           int64     `json:"id"`
+	UserID       int64     `json:"user_id,omitempty"` // Zero for unauthenticated calls, such as logins
+	Username     string    `json:"username,omitempty"`
+	Action       string    `json:"action" example:"POST /servers/:serverName/restart"`
+	Path         string    `json:"path" example:"/servers/survival/restart"`
+	TargetServer string    `json:"target_server,omitempty" example:"survival"`
+	TargetUserID int64     `json:"target_user_id,omitempty"`
+	Summary      string    `json:"summary,omitempty" example:"body: strategy"`
+	Status       int       `json:"status" example:"200"`
+	Result       string    `json:"result" example:"success"`
+	RemoteIP     string    `json:"remote_ip"`
+	RequestID    string    `json:"request_id"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// Audit event results
+const (
+	AuditResultSuccess = "success"
+	AuditResultDenied  = "denied" // Rejected by authentication or permission checks
+	AuditResultFailed  = "failed"
+)
+
+// Incident is a crash of a server container detected by the server watcher: the
+// container was killed for running out of memory, or restarts in a loop.
+type Incident struct {
+	ID           int64     `json:"id"`
+	ServerID     int64     `json:"server_id"`
+	ServerName   string    `json:"server_name" example:"survival"` // Name of the server when the incident happened
+	Kind         string    `json:"kind" example:"oom_killed"`
+	Reason       string    `json:"reason,omitempty" example:"OOMKilled"` // Termination reason and message of the container
+	ExitCode     int32     `json:"exit_code" example:"137"`
+	RestartCount int32     `json:"restart_count" example:"3"`
+	PodName      string    `json:"pod_name" example:"minecraft-server-survival-7d9c8f6b5-x2k4q"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// Incident kinds
+const (
+	IncidentOOMKilled = "oom_killed" // The container exceeded its memory limit
+	IncidentCrashLoop = "crash_loop" // The container keeps exiting and is restarted with a backoff
+)
+
+// AuditFilter selects the audit events to list. Zero fields match every event.
+type AuditFilter struct {
+	UserID       int64
+	Action       string
+	TargetServer string
+	TargetUserID int64
+	Result       string
+	Since        time.Time
+	Until        time.Time
+	BeforeID     int64 // Lists the events older than this one, to page through the log
+	Limit        int
+}
+
+// Page selects a page of a sorted list. A zero Limit lists everything from Offset.
+type Page struct {
+	Limit  int
+	Offset int
+	Sort   string // Field the list is sorted by, among the sort fields of the list; the ID when empty
+	Desc   bool
+}
+
+// UserSortFields are the fields users are sorted by, with their columns.
+var UserSortFields = map[string]string{
+	"id":         "users.id",
+	"username":   "users.username",
+	"email":      "users.email",
+	"created_at": "users.created_at",
+	"last_login": "users.last_login",
+}
+
+// APIKeySortFields are the fields API keys are sorted by, with their columns.
+var APIKeySortFields = map[string]string{
+	"id":          "api_keys.id",
+	"description": "api_keys.description",
+	"created_at":  "api_keys.created_at",
+	"last_used":   "api_keys.last_used",
+	"expires_at":  "api_keys.expires_at",
+}
+
+// orderBy returns the ORDER BY and LIMIT clauses of the page, sorted by one of
+// fields. The rows without a value come last, and the rows sorted equal by their ID.
+func (p Page) orderBy(fields map[string]string) string {
+	column, ok := fields[p.Sort]
+	if !ok {
+		column = fields["id"]
+	}
+	direction := " ASC"
+	if p.Desc {
+		direction = " DESC"
+	}
+	clause := " ORDER BY " + column + " IS NULL, " + column + direction
+	if column != fields["id"] {
+		clause += ", " + fields["id"] + direction
+	}
+	if p.Limit > 0 {
+		clause += fmt.Sprintf(" LIMIT %d OFFSET %d", p.Limit, p.Offset)
+	}
+	return clause
+}
+
+// likePattern returns the LIKE pattern, escaped with \, matching the values that
+// contain text, case insensitively.
+func likePattern(text string) string {
+	return "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(strings.ToLower(text)) + "%"
+}
+
+// boolFilter returns the flag and value of the (? = 0 OR column = ?) condition
+// matching every row for a nil filter.
+func boolFilter(filter *bool) (int, bool) {
+	if filter == nil {
+		return 0, false
+	}
+	return 1, *filter
+}
+
+// UserFilter selects the users to list. Zero fields match every user.
+type UserFilter struct {
+	Page
+	Search  string // Part of the username, email or display name
+	Active  *bool
+	Deleted *bool // Whether the users are deleted, waiting to be purged
+}
+
+// APIKeyFilter selects the API keys to list. Zero fields match every key.
+type APIKeyFilter struct {
+	Page
+	UserID int64
+	Search string // Part of the description
 }
 
 // HasPermission checks if the user has the specified permission.
@@ -124,8 +851,8 @@ func (u *User) HasServerPermission(serverOwnerID int64, permission int64) bool {
 		return true
 	}
 
-	// Server owner has all permissions for their own server
-	if u.ID == serverOwnerID {
+	// Server owner has all permissions for their own server, those of their API key when it is scoped
+	if u.ID == serverOwnerID && u.Scoped(permission) != 0 {
 		logging.Auth.Session.WithFields(
 			"user_id", u.ID,
 			"username", u.Username,
@@ -0,0 +1,32 @@
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAPIKeyExpired(t *testing.T) {
+	expiresAt := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	zero := time.Time{}
+
+	tests := []struct {
+		name      string
+		expiresAt *time.Time
+		now       time.Time
+		want      bool
+	}{
+		{"just before", &expiresAt, expiresAt.Add(-time.Nanosecond), false},
+		{"at", &expiresAt, expiresAt, true},
+		{"after", &expiresAt, expiresAt.Add(time.Second), true},
+		{"no expiry", nil, expiresAt.Add(100 * 365 * 24 * time.Hour), false},
+		{"zero expiry", &zero, expiresAt, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := &APIKey{ExpiresAt: tt.expiresAt}
+			if got := key.Expired(tt.now); got != tt.want {
+				t.Errorf("Expired(%v) = %v, want %v", tt.now, got, tt.want)
+			}
+		})
+	}
+}
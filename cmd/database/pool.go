@@ -0,0 +1,115 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+
+	"minecharts/cmd/logging"
+)
+
+// PoolConfig sizes the connection pools of a database, that of the primary and that
+// of its read replica each.
+type PoolConfig struct {
+	MaxOpenConns    int           // 0 is unlimited
+	MaxIdleConns    int           // Connections kept open while idle
+	ConnMaxLifetime time.Duration // 0 never closes a connection for its age
+}
+
+// apply sizes the pool of db.
+func (p PoolConfig) apply(db *sql.DB) {
+	db.SetMaxOpenConns(p.MaxOpenConns)
+	db.SetMaxIdleConns(p.MaxIdleConns)
+	db.SetConnMaxLifetime(p.ConnMaxLifetime)
+}
+
+// Names of the pools in PoolStats.
+const (
+	PoolPrimary = "primary"
+	PoolReplica = "replica"
+)
+
+// poolStats returns the statistics of the pools of d, by pool name.
+func (d *tracedDB) poolStats() map[string]sql.DBStats {
+	stats := map[string]sql.DBStats{PoolPrimary: d.DB.Stats()}
+	if d.replica != nil {
+		stats[PoolReplica] = d.replica.Stats()
+	}
+	return stats
+}
+
+// pingTimeout bounds each periodic ping.
+const pingTimeout = 5 * time.Second
+
+// PingResult is the outcome of the last periodic ping of the database.
+type PingResult struct {
+	At      time.Time
+	Latency time.Duration
+	Err     error
+}
+
+var lastPing struct {
+	sync.Mutex
+	result PingResult
+}
+
+// LastPing returns the outcome of the last periodic ping, with a zero At before the
+// first one or when the pings are disabled.
+func LastPing() PingResult {
+	lastPing.Lock()
+	defer lastPing.Unlock()
+	return lastPing.result
+}
+
+// StartPinger pings db every interval until ctx is done, logging when it stops
+// answering and when it answers again. A zero interval disables the pings.
+func StartPinger(ctx context.Context, db DB, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	logging.DB.WithFields(
+		"interval", interval.String(),
+	).Info("Database pinger started")
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			ping(ctx, db)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// ping pings db once and records the outcome.
+func ping(ctx context.Context, db DB) {
+	pingCtx, cancel := context.WithTimeout(ctx, pingTimeout)
+	defer cancel()
+	start := time.Now()
+	err := db.Ping(pingCtx)
+	if ctx.Err() != nil {
+		return
+	}
+	result := PingResult{At: start, Latency: time.Since(start), Err: err}
+
+	lastPing.Lock()
+	previous := lastPing.result
+	lastPing.result = result
+	lastPing.Unlock()
+
+	switch {
+	case err != nil && (previous.At.IsZero() || previous.Err == nil):
+		logging.DB.WithFields(
+			"error", err.Error(),
+		).Error("Database ping failed")
+	case err == nil && previous.Err != nil:
+		logging.DB.WithFields(
+			"down_since", previous.At.Format(time.RFC3339),
+		).Info("Database answers pings again")
+	}
+}
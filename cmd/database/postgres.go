@@ -3,21 +3,24 @@ package database
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"minecharts/cmd/logging"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
 // PostgresDB implements the DB interface for PostgreSQL
 type PostgresDB struct {
-	db *sql.DB
+	db *tracedDB
 }
 
-// NewPostgresDB creates a new PostgreSQL database connection
-func NewPostgresDB(connString string) (*PostgresDB, error) {
+// NewPostgresDB creates a new PostgreSQL database connection, with a connection to
+// its read replica unless replicaConnString is empty
+func NewPostgresDB(connString, replicaConnString string, pool PoolConfig) (*PostgresDB, error) {
 	logging.DB.WithFields(
 		"db_type", "postgres",
 	).Info("Creating new PostgreSQL database connection")
@@ -30,9 +33,48 @@ func NewPostgresDB(connString string) (*PostgresDB, error) {
 		).Error("Failed to open PostgreSQL database connection")
 		return nil, err
 	}
+	pool.apply(db)
+	traced := &tracedDB{DB: db, system: "postgresql"}
+
+	if replicaConnString != "" {
+		replica, err := sql.Open("postgres", replicaConnString)
+		if err != nil {
+			db.Close()
+			logging.DB.WithFields(
+				"db_type", "postgres",
+				"error", err.Error(),
+			).Error("Failed to open PostgreSQL read replica connection")
+			return nil, fmt.Errorf("failed to open read replica connection: %w", err)
+		}
+		pool.apply(replica)
+		traced.replica = replica
+		logging.DB.Info("PostgreSQL read replica configured for the list, status and metrics reads")
+	}
 
 	logging.DB.Debug("PostgreSQL database connection established")
-	return &PostgresDB{db: db}, nil
+	return &PostgresDB{db: traced}, nil
+}
+
+// maxTxAttempts bounds the attempts of a transaction conflicting with concurrent ones.
+const maxTxAttempts = 3
+
+// WithTx runs fn in a serializable transaction, so that the ones reading then writing
+// behave as if they ran one after the other. A transaction failing to serialize with a
+// concurrent one is run again.
+func (p *PostgresDB) WithTx(ctx context.Context, fn func(tx DB) error) error {
+	for attempt := 1; ; attempt++ {
+		err := p.db.inTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable}, func(tx *tracedDB) error {
+			return fn(&PostgresDB{db: tx})
+		})
+		var pqErr *pq.Error
+		if p.db.tx == nil && attempt < maxTxAttempts && errors.As(err, &pqErr) && pqErr.Code == "40001" {
+			logging.DB.WithContext(ctx).WithFields(
+				"attempt", attempt,
+			).Debug("Transaction failed to serialize with a concurrent one, retrying")
+			continue
+		}
+		return err
+	}
 }
 
 // Init initializes the database schema
@@ -101,546 +143,4080 @@ func (p *PostgresDB) Init() error {
 		return fmt.Errorf("failed to create minecraft_servers table: %w", err)
 	}
 
-	// Check if we need to create an admin user
-	logging.DB.Debug("Checking if admin user needs to be created")
-	var count int
-	err = p.db.QueryRow("SELECT COUNT(*) FROM users").Scan(&count)
+	// Create server requests table
+	logging.DB.Debug("Creating server_requests table if not exists")
+	_, err = p.db.Exec(`
+		CREATE TABLE IF NOT EXISTS server_requests (
+			id SERIAL PRIMARY KEY,
+			server_name TEXT NOT NULL,
+			requester_id INTEGER NOT NULL,
+			payload TEXT NOT NULL,
+			status TEXT NOT NULL,
+			reason TEXT NOT NULL DEFAULT '',
+			reviewer_id INTEGER,
+			created_at TIMESTAMP NOT NULL,
+			reviewed_at TIMESTAMP,
+			FOREIGN KEY (requester_id) REFERENCES users(id) ON DELETE CASCADE
+		)
+	`)
 	if err != nil {
 		logging.DB.WithFields(
 			"error", err.Error(),
-		).Error("Failed to count users")
-		return err
+		).Error("Failed to create server_requests table")
+		return fmt.Errorf("failed to create server_requests table: %w", err)
 	}
 
-	// If no users exist, create a default admin user
-	if count == 0 {
-		logging.DB.Info("Creating default admin user")
-		now := time.Now()
-		_, err = p.db.Exec(
-			"INSERT INTO users (username, email, password_hash, permissions, active, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6, $7)",
-			"admin",
-			"admin@example.com",
-			"$2a$10$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p92ldGxad68LJZdL17lhWy", // password: admin
-			PermAll,
-			true,
-			now,
-			now,
+	// Create notifications table
+	logging.DB.Debug("Creating notifications table if not exists")
+	_, err = p.db.Exec(`
+		CREATE TABLE IF NOT EXISTS notifications (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER NOT NULL,
+			message TEXT NOT NULL,
+			read BOOLEAN NOT NULL DEFAULT FALSE,
+			created_at TIMESTAMP NOT NULL,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
 		)
-		if err != nil {
-			logging.DB.WithFields(
-				"error", err.Error(),
-			).Error("Failed to create default admin user")
-			return err
-		}
-		logging.DB.Info("Default admin user created successfully")
+	`)
+	if err != nil {
+		logging.DB.WithFields(
+			"error", err.Error(),
+		).Error("Failed to create notifications table")
+		return fmt.Errorf("failed to create notifications table: %w", err)
 	}
 
-	logging.DB.Info("PostgreSQL database schema initialized successfully")
-	return nil
-}
-
-// Close closes the database connection
-func (p *PostgresDB) Close() error {
-	logging.DB.Info("Closing PostgreSQL database connection")
-	err := p.db.Close()
+	// Create server templates table
+	logging.DB.Debug("Creating server_templates table if not exists")
+	_, err = p.db.Exec(`
+		CREATE TABLE IF NOT EXISTS server_templates (
+			id SERIAL PRIMARY KEY,
+			name TEXT UNIQUE NOT NULL,
+			description TEXT NOT NULL DEFAULT '',
+			spec TEXT NOT NULL,
+			created_by INTEGER NOT NULL,
+			created_at TIMESTAMP NOT NULL,
+			updated_at TIMESTAMP NOT NULL
+		)
+	`)
 	if err != nil {
 		logging.DB.WithFields(
 			"error", err.Error(),
-		).Error("Error closing PostgreSQL database connection")
-		return err
+		).Error("Failed to create server_templates table")
+		return fmt.Errorf("failed to create server_templates table: %w", err)
 	}
-	logging.DB.Debug("PostgreSQL database connection closed successfully")
-	return nil
-}
-
-// User operations
-
-// CreateUser creates a new user
-func (p *PostgresDB) CreateUser(ctx context.Context, user *User) error {
-	logging.DB.WithFields(
-		"username", user.Username,
-		"email", user.Email,
-	).Info("Creating new user in PostgreSQL")
 
-	// Check if user already exists
-	var exists bool
-	err := p.db.QueryRowContext(ctx,
-		"SELECT EXISTS(SELECT 1 FROM users WHERE username = $1 OR email = $2)",
-		user.Username, user.Email,
-	).Scan(&exists)
+	// Create user quotas table
+	logging.DB.Debug("Creating user_quotas table if not exists")
+	_, err = p.db.Exec(`
+		CREATE TABLE IF NOT EXISTS user_quotas (
+			user_id INTEGER PRIMARY KEY,
+			max_servers INTEGER NOT NULL DEFAULT 0,
+			max_memory TEXT NOT NULL DEFAULT '',
+			max_storage TEXT NOT NULL DEFAULT '',
+			updated_at TIMESTAMP NOT NULL,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		)
+	`)
 	if err != nil {
 		logging.DB.WithFields(
-			"username", user.Username,
-			"email", user.Email,
 			"error", err.Error(),
-		).Error("Database error when checking if user exists")
-		return err
+		).Error("Failed to create user_quotas table")
+		return fmt.Errorf("failed to create user_quotas table: %w", err)
 	}
-	if exists {
+
+	// Create server status change log
+	logging.DB.Debug("Creating server_status_changes table if not exists")
+	_, err = p.db.Exec(`
+		CREATE TABLE IF NOT EXISTS server_status_changes (
+			id SERIAL PRIMARY KEY,
+			server_name TEXT NOT NULL,
+			owner_id INTEGER NOT NULL,
+			status TEXT NOT NULL,
+			status_reason TEXT NOT NULL DEFAULT '',
+			changed_at TIMESTAMP NOT NULL
+		)
+	`)
+	if err != nil {
 		logging.DB.WithFields(
-			"username", user.Username,
-			"email", user.Email,
-			"error", "user_exists",
-		).Warn("Cannot create user: username or email already exists")
-		return ErrUserExists
+			"error", err.Error(),
+		).Error("Failed to create server_status_changes table")
+		return fmt.Errorf("failed to create server_status_changes table: %w", err)
 	}
 
-	// Set timestamps
-	now := time.Now()
-	user.CreatedAt = now
-	user.UpdatedAt = now
-
-	// Insert user
-	err = p.db.QueryRowContext(ctx,
-		"INSERT INTO users (username, email, password_hash, permissions, active, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id",
-		user.Username, user.Email, user.PasswordHash, user.Permissions, user.Active, user.CreatedAt, user.UpdatedAt,
-	).Scan(&user.ID)
+	// Create scheduled tasks table
+	logging.DB.Debug("Creating scheduled_tasks table if not exists")
+	_, err = p.db.Exec(`
+		CREATE TABLE IF NOT EXISTS scheduled_tasks (
+			id SERIAL PRIMARY KEY,
+			server_id INTEGER NOT NULL,
+			name TEXT NOT NULL,
+			schedule TEXT NOT NULL,
+			action TEXT NOT NULL,
+			payload TEXT NOT NULL DEFAULT '',
+			enabled BOOLEAN NOT NULL DEFAULT TRUE,
+			created_by INTEGER NOT NULL,
+			run_count INTEGER NOT NULL DEFAULT 0,
+			last_run_at TIMESTAMP,
+			next_run_at TIMESTAMP,
+			created_at TIMESTAMP NOT NULL,
+			updated_at TIMESTAMP NOT NULL,
+			FOREIGN KEY (server_id) REFERENCES minecraft_servers(id) ON DELETE CASCADE
+		)
+	`)
 	if err != nil {
 		logging.DB.WithFields(
-			"username", user.Username,
-			"email", user.Email,
 			"error", err.Error(),
-		).Error("Failed to insert new user")
-		return err
+		).Error("Failed to create scheduled_tasks table")
+		return fmt.Errorf("failed to create scheduled_tasks table: %w", err)
 	}
 
-	logging.DB.WithFields(
-		"username", user.Username,
-		"email", user.Email,
-		"user_id", user.ID,
-	).Info("User created successfully in PostgreSQL")
-	return nil
-}
-
-// GetUserByID retrieves a user by ID
-func (p *PostgresDB) GetUserByID(ctx context.Context, id int64) (*User, error) {
-	logging.DB.WithFields(
-		"user_id", id,
-		"db_type", "postgres",
-	).Debug("Getting user by ID")
-
-	user := &User{}
-	err := p.db.QueryRowContext(ctx,
-		"SELECT id, username, email, password_hash, permissions, active, last_login, created_at, updated_at FROM users WHERE id = $1",
-		id,
-	).Scan(
-		&user.ID, &user.Username, &user.Email, &user.PasswordHash, &user.Permissions,
-		&user.Active, &user.LastLogin, &user.CreatedAt, &user.UpdatedAt,
-	)
-	if err == sql.ErrNoRows {
+	// Create task runs table
+	logging.DB.Debug("Creating task_runs table if not exists")
+	_, err = p.db.Exec(`
+		CREATE TABLE IF NOT EXISTS task_runs (
+			id SERIAL PRIMARY KEY,
+			task_id INTEGER NOT NULL,
+			started_at TIMESTAMP NOT NULL,
+			finished_at TIMESTAMP,
+			status TEXT NOT NULL,
+			output TEXT NOT NULL DEFAULT '',
+			FOREIGN KEY (task_id) REFERENCES scheduled_tasks(id) ON DELETE CASCADE
+		)
+	`)
+	if err != nil {
 		logging.DB.WithFields(
-			"user_id", id,
-			"error", "user_not_found",
-		).Debug("User not found by ID")
-		return nil, ErrUserNotFound
+			"error", err.Error(),
+		).Error("Failed to create task_runs table")
+		return fmt.Errorf("failed to create task_runs table: %w", err)
 	}
+
+	// Create jobs table
+	logging.DB.Debug("Creating jobs table if not exists")
+	_, err = p.db.Exec(`
+		CREATE TABLE IF NOT EXISTS jobs (
+			id SERIAL PRIMARY KEY,
+			type TEXT NOT NULL,
+			server_name TEXT NOT NULL,
+			owner_id INTEGER NOT NULL,
+			status TEXT NOT NULL,
+			progress INTEGER NOT NULL DEFAULT 0,
+			message TEXT NOT NULL DEFAULT '',
+			error TEXT NOT NULL DEFAULT '',
+			created_by INTEGER NOT NULL,
+			created_at TIMESTAMP NOT NULL,
+			started_at TIMESTAMP,
+			finished_at TIMESTAMP,
+			updated_at TIMESTAMP NOT NULL
+		)
+	`)
 	if err != nil {
 		logging.DB.WithFields(
-			"user_id", id,
 			"error", err.Error(),
-		).Error("Database error when getting user by ID")
-		return nil, err
+		).Error("Failed to create jobs table")
+		return fmt.Errorf("failed to create jobs table: %w", err)
 	}
 
-	logging.DB.WithFields(
-		"user_id", id,
-		"username", user.Username,
-	).Debug("Successfully retrieved user by ID")
-	return user, nil
-}
-
-// GetUserByUsername retrieves a user by username
-func (p *PostgresDB) GetUserByUsername(ctx context.Context, username string) (*User, error) {
-	logging.DB.WithFields(
-		"username", username,
-		"db_type", "postgres",
-	).Debug("Getting user by username")
-
-	user := &User{}
-	query := "SELECT id, username, email, password_hash, permissions, active, last_login, created_at, updated_at FROM users WHERE username = $1"
-
-	logging.DB.WithFields(
-		"username", username,
-		"query", query,
-	).Debug("Executing database query")
-
-	err := p.db.QueryRowContext(ctx, query, username).Scan(
-		&user.ID, &user.Username, &user.Email, &user.PasswordHash, &user.Permissions,
-		&user.Active, &user.LastLogin, &user.CreatedAt, &user.UpdatedAt,
-	)
-	if err == sql.ErrNoRows {
+	// Create server plugins table
+	logging.DB.Debug("Creating server_plugins table if not exists")
+	_, err = p.db.Exec(`
+		CREATE TABLE IF NOT EXISTS server_plugins (
+			id SERIAL PRIMARY KEY,
+			server_id INTEGER NOT NULL,
+			platform TEXT NOT NULL,
+			project_id TEXT NOT NULL,
+			project_slug TEXT NOT NULL DEFAULT '',
+			name TEXT NOT NULL DEFAULT '',
+			version_id TEXT NOT NULL,
+			version TEXT NOT NULL DEFAULT '',
+			directory TEXT NOT NULL,
+			file_name TEXT NOT NULL,
+			sha512 TEXT NOT NULL DEFAULT '',
+			installed_by INTEGER NOT NULL,
+			installed_at TIMESTAMP NOT NULL,
+			UNIQUE (server_id, project_id),
+			FOREIGN KEY (server_id) REFERENCES minecraft_servers(id) ON DELETE CASCADE
+		)
+	`)
+	if err != nil {
 		logging.DB.WithFields(
-			"username", username,
-			"error", "user_not_found",
-		).Debug("User not found")
-		return nil, ErrUserNotFound
+			"error", err.Error(),
+		).Error("Failed to create server_plugins table")
+		return fmt.Errorf("failed to create server_plugins table: %w", err)
 	}
+
+	// Create nonces table
+	logging.DB.Debug("Creating nonces table if not exists")
+	_, err = p.db.Exec(`
+		CREATE TABLE IF NOT EXISTS nonces (
+			purpose TEXT NOT NULL,
+			value TEXT NOT NULL,
+			expires_at TIMESTAMP NOT NULL,
+			created_at TIMESTAMP NOT NULL,
+			PRIMARY KEY (purpose, value)
+		)
+	`)
 	if err != nil {
 		logging.DB.WithFields(
-			"username", username,
 			"error", err.Error(),
-		).Error("Database error when getting user by username")
-		return nil, err
+		).Error("Failed to create nonces table")
+		return fmt.Errorf("failed to create nonces table: %w", err)
 	}
 
-	logging.DB.WithFields(
-		"username", user.Username,
-		"user_id", user.ID,
-	).Debug("Successfully retrieved user")
-	return user, nil
-}
-
-// UpdateUser updates a user's information
-func (p *PostgresDB) UpdateUser(ctx context.Context, user *User) error {
-	logging.DB.WithFields(
-		"user_id", user.ID,
-		"username", user.Username,
-	).Info("Updating user information in PostgreSQL")
-
-	user.UpdatedAt = time.Now()
-
-	_, err := p.db.ExecContext(ctx,
-		"UPDATE users SET username = $1, email = $2, password_hash = $3, permissions = $4, active = $5, updated_at = $6 WHERE id = $7",
-		user.Username, user.Email, user.PasswordHash, user.Permissions, user.Active, user.UpdatedAt, user.ID,
-	)
+	_, err = p.db.Exec(`CREATE INDEX IF NOT EXISTS idx_nonces_expires_at ON nonces(expires_at)`)
 	if err != nil {
 		logging.DB.WithFields(
-			"user_id", user.ID,
-			"username", user.Username,
 			"error", err.Error(),
-		).Error("Failed to update user")
-		return err
+		).Error("Failed to create nonces expiry index")
+		return fmt.Errorf("failed to create nonces expiry index: %w", err)
 	}
 
-	logging.DB.WithFields(
-		"user_id", user.ID,
-		"username", user.Username,
-	).Info("User updated successfully")
-	return nil
-}
-
-// DeleteUser deletes a user by ID
-func (p *PostgresDB) DeleteUser(ctx context.Context, id int64) error {
-	logging.DB.WithFields(
-		"user_id", id,
-	).Info("Deleting user from PostgreSQL")
-
-	_, err := p.db.ExecContext(ctx, "DELETE FROM users WHERE id = $1", id)
+	// Create proxies table
+	logging.DB.Debug("Creating proxies table if not exists")
+	_, err = p.db.Exec(`
+		CREATE TABLE IF NOT EXISTS proxies (
+			id SERIAL PRIMARY KEY,
+			name TEXT UNIQUE NOT NULL,
+			owner_id INTEGER NOT NULL,
+			deployment_name TEXT NOT NULL,
+			forwarding_mode TEXT NOT NULL,
+			created_at TIMESTAMP NOT NULL,
+			updated_at TIMESTAMP NOT NULL
+		)
+	`)
 	if err != nil {
 		logging.DB.WithFields(
-			"user_id", id,
 			"error", err.Error(),
-		).Error("Failed to delete user")
-		return err
+		).Error("Failed to create proxies table")
+		return fmt.Errorf("failed to create proxies table: %w", err)
 	}
 
-	logging.DB.WithFields(
-		"user_id", id,
-	).Info("User deleted successfully")
-	return nil
-}
-
-// ListUsers returns a list of all users
-func (p *PostgresDB) ListUsers(ctx context.Context) ([]*User, error) {
-	logging.DB.Debug("Listing all users from PostgreSQL")
+	// Create proxy servers table
+	logging.DB.Debug("Creating proxy_servers table if not exists")
+	_, err = p.db.Exec(`
+		CREATE TABLE IF NOT EXISTS proxy_servers (
+			id SERIAL PRIMARY KEY,
+			proxy_id INTEGER NOT NULL,
+			server_id INTEGER NOT NULL,
+			lobby BOOLEAN NOT NULL DEFAULT FALSE,
+			added_by INTEGER NOT NULL,
+			added_at TIMESTAMP NOT NULL,
+			UNIQUE (proxy_id, server_id),
+			FOREIGN KEY (proxy_id) REFERENCES proxies(id) ON DELETE CASCADE,
+			FOREIGN KEY (server_id) REFERENCES minecraft_servers(id) ON DELETE CASCADE
+		)
+	`)
+	if err != nil {
+		logging.DB.WithFields(
+			"error", err.Error(),
+		).Error("Failed to create proxy_servers table")
+		return fmt.Errorf("failed to create proxy_servers table: %w", err)
+	}
 
-	rows, err := p.db.QueryContext(ctx,
-		"SELECT id, username, email, password_hash, permissions, active, last_login, created_at, updated_at FROM users",
-	)
+	// Create audit log
+	logging.DB.Debug("Creating audit_events table if not exists")
+	_, err = p.db.Exec(`
+		CREATE TABLE IF NOT EXISTS audit_events (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER NOT NULL DEFAULT 0,
+			username TEXT NOT NULL DEFAULT '',
+			action TEXT NOT NULL,
+			path TEXT NOT NULL,
+			target_server TEXT NOT NULL DEFAULT '',
+			target_user_id INTEGER NOT NULL DEFAULT 0,
+			summary TEXT NOT NULL DEFAULT '',
+			status INTEGER NOT NULL,
+			result TEXT NOT NULL,
+			remote_ip TEXT NOT NULL DEFAULT '',
+			request_id TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMP NOT NULL
+		)
+	`)
 	if err != nil {
 		logging.DB.WithFields(
 			"error", err.Error(),
-		).Error("Failed to query users")
-		return nil, err
+		).Error("Failed to create audit_events table")
+		return fmt.Errorf("failed to create audit_events table: %w", err)
 	}
-	defer rows.Close()
+
+	_, err = p.db.Exec(`CREATE INDEX IF NOT EXISTS idx_audit_events_created_at ON audit_events(created_at)`)
+	if err != nil {
+		logging.DB.WithFields(
+			"error", err.Error(),
+		).Error("Failed to create audit events time index")
+		return fmt.Errorf("failed to create audit events time index: %w", err)
+	}
+
+	// Create incident log
+	logging.DB.Debug("Creating incidents table if not exists")
+	_, err = p.db.Exec(`
+		CREATE TABLE IF NOT EXISTS incidents (
+			id SERIAL PRIMARY KEY,
+			server_id INTEGER NOT NULL,
+			server_name TEXT NOT NULL,
+			kind TEXT NOT NULL,
+			reason TEXT NOT NULL DEFAULT '',
+			exit_code INTEGER NOT NULL DEFAULT 0,
+			restart_count INTEGER NOT NULL DEFAULT 0,
+			pod_name TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMP NOT NULL
+		)
+	`)
+	if err != nil {
+		logging.DB.WithFields(
+			"error", err.Error(),
+		).Error("Failed to create incidents table")
+		return fmt.Errorf("failed to create incidents table: %w", err)
+	}
+
+	_, err = p.db.Exec(`CREATE INDEX IF NOT EXISTS idx_incidents_server_id ON incidents(server_id)`)
+	if err != nil {
+		logging.DB.WithFields(
+			"error", err.Error(),
+		).Error("Failed to create incidents server index")
+		return fmt.Errorf("failed to create incidents server index: %w", err)
+	}
+
+	// Create OAuth identities table, the accounts at OAuth providers linked to the users
+	logging.DB.Debug("Creating oauth_identities table if not exists")
+	_, err = p.db.Exec(`
+		CREATE TABLE IF NOT EXISTS oauth_identities (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER NOT NULL,
+			provider TEXT NOT NULL,
+			subject TEXT NOT NULL,
+			email TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMP NOT NULL,
+			UNIQUE (provider, subject),
+			UNIQUE (user_id, provider),
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		)
+	`)
+	if err != nil {
+		logging.DB.WithFields(
+			"error", err.Error(),
+		).Error("Failed to create oauth_identities table")
+		return fmt.Errorf("failed to create oauth_identities table: %w", err)
+	}
+
+	// Create roles tables, the named sets of permissions assigned to users
+	logging.DB.Debug("Creating roles tables if not exist")
+	_, err = p.db.Exec(`
+		CREATE TABLE IF NOT EXISTS roles (
+			id SERIAL PRIMARY KEY,
+			name TEXT UNIQUE NOT NULL,
+			description TEXT NOT NULL DEFAULT '',
+			permissions INTEGER NOT NULL DEFAULT 0,
+			created_at TIMESTAMP NOT NULL,
+			updated_at TIMESTAMP NOT NULL
+		)
+	`)
+	if err != nil {
+		logging.DB.WithFields(
+			"error", err.Error(),
+		).Error("Failed to create roles table")
+		return fmt.Errorf("failed to create roles table: %w", err)
+	}
+
+	_, err = p.db.Exec(`
+		CREATE TABLE IF NOT EXISTS user_roles (
+			user_id INTEGER NOT NULL,
+			role_id INTEGER NOT NULL,
+			created_at TIMESTAMP NOT NULL,
+			PRIMARY KEY (user_id, role_id),
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+			FOREIGN KEY (role_id) REFERENCES roles(id) ON DELETE CASCADE
+		)
+	`)
+	if err != nil {
+		logging.DB.WithFields(
+			"error", err.Error(),
+		).Error("Failed to create user_roles table")
+		return fmt.Errorf("failed to create user_roles table: %w", err)
+	}
+
+	_, err = p.db.Exec(`CREATE INDEX IF NOT EXISTS idx_user_roles_role_id ON user_roles(role_id)`)
+	if err != nil {
+		logging.DB.WithFields(
+			"error", err.Error(),
+		).Error("Failed to create user_roles role index")
+		return fmt.Errorf("failed to create user_roles role index: %w", err)
+	}
+
+	// Create server members table, the users the owner of a server granted permissions on it
+	logging.DB.Debug("Creating server_members table if not exists")
+	_, err = p.db.Exec(`
+		CREATE TABLE IF NOT EXISTS server_members (
+			server_id INTEGER NOT NULL,
+			user_id INTEGER NOT NULL,
+			permissions INTEGER NOT NULL DEFAULT 0,
+			created_at TIMESTAMP NOT NULL,
+			updated_at TIMESTAMP NOT NULL,
+			PRIMARY KEY (server_id, user_id),
+			FOREIGN KEY (server_id) REFERENCES minecraft_servers(id) ON DELETE CASCADE,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		)
+	`)
+	if err != nil {
+		logging.DB.WithFields(
+			"error", err.Error(),
+		).Error("Failed to create server_members table")
+		return fmt.Errorf("failed to create server_members table: %w", err)
+	}
+
+	_, err = p.db.Exec(`CREATE INDEX IF NOT EXISTS idx_server_members_user_id ON server_members(user_id)`)
+	if err != nil {
+		logging.DB.WithFields(
+			"error", err.Error(),
+		).Error("Failed to create server_members user index")
+		return fmt.Errorf("failed to create server_members user index: %w", err)
+	}
+
+	// Create organizations tables, the groups of users managing servers together
+	logging.DB.Debug("Creating organizations tables if not exist")
+	_, err = p.db.Exec(`
+		CREATE TABLE IF NOT EXISTS organizations (
+			id SERIAL PRIMARY KEY,
+			name TEXT UNIQUE NOT NULL,
+			description TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMP NOT NULL,
+			updated_at TIMESTAMP NOT NULL
+		)
+	`)
+	if err != nil {
+		logging.DB.WithFields(
+			"error", err.Error(),
+		).Error("Failed to create organizations table")
+		return fmt.Errorf("failed to create organizations table: %w", err)
+	}
+
+	_, err = p.db.Exec(`
+		CREATE TABLE IF NOT EXISTS organization_members (
+			org_id INTEGER NOT NULL,
+			user_id INTEGER NOT NULL,
+			role TEXT NOT NULL,
+			created_at TIMESTAMP NOT NULL,
+			updated_at TIMESTAMP NOT NULL,
+			PRIMARY KEY (org_id, user_id),
+			FOREIGN KEY (org_id) REFERENCES organizations(id) ON DELETE CASCADE,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		)
+	`)
+	if err != nil {
+		logging.DB.WithFields(
+			"error", err.Error(),
+		).Error("Failed to create organization_members table")
+		return fmt.Errorf("failed to create organization_members table: %w", err)
+	}
+
+	_, err = p.db.Exec(`CREATE INDEX IF NOT EXISTS idx_organization_members_user_id ON organization_members(user_id)`)
+	if err != nil {
+		logging.DB.WithFields(
+			"error", err.Error(),
+		).Error("Failed to create organization_members user index")
+		return fmt.Errorf("failed to create organization_members user index: %w", err)
+	}
+
+	// Create schema migrations table
+	logging.DB.Debug("Creating schema_migrations table if not exists")
+	_, err = p.db.Exec(`
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			name TEXT PRIMARY KEY,
+			applied_at TIMESTAMP NOT NULL
+		)
+	`)
+	if err != nil {
+		logging.DB.WithFields(
+			"error", err.Error(),
+		).Error("Failed to create schema_migrations table")
+		return fmt.Errorf("failed to create schema_migrations table: %w", err)
+	}
+
+	// Existing server creators keep the ability to expose their servers
+	// now that exposure has its own permission bit.
+	if err := p.applyMigration("0001_perm_expose_server",
+		fmt.Sprintf("UPDATE users SET permissions = permissions | %d WHERE (permissions & %d) != 0", PermExposeServer, PermCreateServer),
+	); err != nil {
+		return err
+	}
+
+	// The default admin/admin account was replaced by the setup flow; accounts
+	// still using that password must change it before doing anything else.
+	if err := p.applyMigration("0002_password_change_required",
+		"ALTER TABLE users ADD COLUMN password_change_required BOOLEAN NOT NULL DEFAULT FALSE",
+		"UPDATE users SET password_change_required = TRUE WHERE password_hash IN ('"+strings.Join(legacyDefaultAdminHashes, "', '")+"')",
+	); err != nil {
+		return err
+	}
+
+	// Servers keep the typed creation spec they were created with
+	if err := p.applyMigration("0003_server_spec",
+		"ALTER TABLE minecraft_servers ADD COLUMN spec TEXT NOT NULL DEFAULT ''",
+	); err != nil {
+		return err
+	}
+
+	// Servers record why they are in their current provisioning state
+	if err := p.applyMigration("0004_server_status_reason",
+		"ALTER TABLE minecraft_servers ADD COLUMN status_reason TEXT NOT NULL DEFAULT ''",
+	); err != nil {
+		return err
+	}
+
+	// Users link the Minecraft account they play with, once per account
+	if err := p.applyMigration("0005_user_minecraft_account",
+		"ALTER TABLE users ADD COLUMN minecraft_uuid TEXT NOT NULL DEFAULT ''",
+		"ALTER TABLE users ADD COLUMN minecraft_name TEXT NOT NULL DEFAULT ''",
+		"CREATE UNIQUE INDEX IF NOT EXISTS idx_users_minecraft_uuid ON users(minecraft_uuid) WHERE minecraft_uuid <> ''",
+	); err != nil {
+		return err
+	}
+
+	// Nonces carry the data of the operation they complete
+	if err := p.applyMigration("0006_nonce_data",
+		"ALTER TABLE nonces ADD COLUMN data TEXT NOT NULL DEFAULT ''",
+	); err != nil {
+		return err
+	}
+
+	// Templates declare the parameters filled in when creating servers from them
+	if err := p.applyMigration("0007_template_parameters",
+		"ALTER TABLE server_templates ADD COLUMN parameters TEXT NOT NULL DEFAULT ''",
+	); err != nil {
+		return err
+	}
+
+	// Templates are sorted in categories, and remember the URL they were imported from
+	if err := p.applyMigration("0008_template_catalog",
+		"ALTER TABLE server_templates ADD COLUMN category TEXT NOT NULL DEFAULT ''",
+		"ALTER TABLE server_templates ADD COLUMN source TEXT NOT NULL DEFAULT ''",
+	); err != nil {
+		return err
+	}
+
+	// Servers can be owned by an organization rather than by the user who created them
+	if err := p.applyMigration("0009_server_organization",
+		"ALTER TABLE minecraft_servers ADD COLUMN org_id BIGINT NOT NULL DEFAULT 0",
+		"CREATE INDEX IF NOT EXISTS idx_minecraft_servers_org_id ON minecraft_servers(org_id)",
+	); err != nil {
+		return err
+	}
+
+	// API keys can be restricted to some of the permissions of their user, and to some servers
+	if err := p.applyMigration("0010_api_key_scopes",
+		"ALTER TABLE api_keys ADD COLUMN permissions BIGINT NOT NULL DEFAULT 0",
+		"ALTER TABLE api_keys ADD COLUMN server_ids TEXT NOT NULL DEFAULT ''",
+	); err != nil {
+		return err
+	}
+
+	// Users can give the name they are shown with
+	if err := p.applyMigration("0011_user_display_name",
+		"ALTER TABLE users ADD COLUMN display_name TEXT NOT NULL DEFAULT ''",
+	); err != nil {
+		return err
+	}
+
+	// Deleted users are kept, deactivated, until purged past their grace period
+	if err := p.applyMigration("0012_user_deleted_at",
+		"ALTER TABLE users ADD COLUMN deleted_at TIMESTAMP",
+	); err != nil {
+		return err
+	}
+
+	// Servers record the namespace they were created in, empty for the default one
+	if err := p.applyMigration("0013_server_namespace",
+		"ALTER TABLE minecraft_servers ADD COLUMN namespace TEXT NOT NULL DEFAULT ''",
+	); err != nil {
+		return err
+	}
+
+	logging.DB.Info("PostgreSQL database schema initialized successfully")
+	return nil
+}
+
+// Ping checks that the database answers. The read replica is left out, as its
+// reads fall back to the primary.
+func (p *PostgresDB) Ping(ctx context.Context) error {
+	return p.db.PingContext(ctx)
+}
+
+// PoolStats returns the statistics of the connection pools of the primary and of the
+// read replica
+func (p *PostgresDB) PoolStats() map[string]sql.DBStats {
+	return p.db.poolStats()
+}
+
+// Close closes the database connection
+func (p *PostgresDB) Close() error {
+	logging.DB.Info("Closing PostgreSQL database connection")
+	if p.db.replica != nil {
+		if err := p.db.replica.Close(); err != nil {
+			logging.DB.WithFields(
+				"error", err.Error(),
+			).Warn("Error closing PostgreSQL read replica connection")
+		}
+	}
+	err := p.db.Close()
+	if err != nil {
+		logging.DB.WithFields(
+			"error", err.Error(),
+		).Error("Error closing PostgreSQL database connection")
+		return err
+	}
+	logging.DB.Debug("PostgreSQL database connection closed successfully")
+	return nil
+}
+
+// applyMigration runs the given statements in a single transaction, once.
+// Applied migrations are recorded in schema_migrations by name.
+func (p *PostgresDB) applyMigration(name string, statements ...string) error {
+	var applied bool
+	err := p.db.QueryRow("SELECT EXISTS(SELECT 1 FROM schema_migrations WHERE name = $1)", name).Scan(&applied)
+	if err != nil {
+		logging.DB.WithFields(
+			"migration", name,
+			"error", err.Error(),
+		).Error("Failed to check schema migration state")
+		return fmt.Errorf("failed to check migration %s: %w", name, err)
+	}
+	if applied {
+		logging.DB.WithFields(
+			"migration", name,
+		).Debug("Schema migration already applied")
+		return nil
+	}
+
+	logging.DB.WithFields(
+		"migration", name,
+	).Info("Applying PostgreSQL schema migration")
+
+	tx, err := p.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin migration %s: %w", name, err)
+	}
+	defer tx.Rollback()
+
+	for _, stmt := range statements {
+		if _, err := tx.Exec(stmt); err != nil {
+			logging.DB.WithFields(
+				"migration", name,
+				"error", err.Error(),
+			).Error("Schema migration failed")
+			return fmt.Errorf("migration %s failed: %w", name, err)
+		}
+	}
+
+	if _, err := tx.Exec("INSERT INTO schema_migrations (name, applied_at) VALUES ($1, $2)", name, time.Now()); err != nil {
+		return fmt.Errorf("failed to record migration %s: %w", name, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit migration %s: %w", name, err)
+	}
+
+	logging.DB.WithFields(
+		"migration", name,
+	).Info("Schema migration applied successfully")
+	return nil
+}
+
+// insertReturningID executes an INSERT statement and returns the generated row ID.
+func (p *PostgresDB) insertReturningID(ctx context.Context, query string, args ...interface{}) (int64, error) {
+	var id int64
+	err := p.db.QueryRowContext(ctx, query+" RETURNING id", args...).Scan(&id)
+	return id, err
+}
+
+// User operations
+
+// CreateUser creates a new user
+func (p *PostgresDB) CreateUser(ctx context.Context, user *User) error {
+	logging.DB.WithContext(ctx).WithFields(
+		"username", user.Username,
+		"email", user.Email,
+	).Info("Creating new user in PostgreSQL")
+
+	// Check if user already exists
+	var exists bool
+	err := p.db.QueryRowContext(ctx,
+		"SELECT EXISTS(SELECT 1 FROM users WHERE username = $1 OR email = $2)",
+		user.Username, user.Email,
+	).Scan(&exists)
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"username", user.Username,
+			"email", user.Email,
+			"error", err.Error(),
+		).Error("Database error when checking if user exists")
+		return err
+	}
+	if exists {
+		logging.DB.WithContext(ctx).WithFields(
+			"username", user.Username,
+			"email", user.Email,
+			"error", "user_exists",
+		).Warn("Cannot create user: username or email already exists")
+		return ErrUserExists
+	}
+
+	// Set timestamps
+	now := time.Now()
+	user.CreatedAt = now
+	user.UpdatedAt = now
+	// New users have no roles yet
+	user.DirectPermissions, user.Roles = user.Permissions, []string{}
+
+	// Insert user
+	err = p.db.QueryRowContext(ctx,
+		"INSERT INTO users (username, email, display_name, password_hash, permissions, active, password_change_required, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING id",
+		user.Username, user.Email, user.DisplayName, user.PasswordHash, user.Permissions, user.Active, user.PasswordChangeRequired, user.CreatedAt, user.UpdatedAt,
+	).Scan(&user.ID)
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"username", user.Username,
+			"email", user.Email,
+			"error", err.Error(),
+		).Error("Failed to insert new user")
+		return err
+	}
+
+	logging.DB.WithContext(ctx).WithFields(
+		"username", user.Username,
+		"email", user.Email,
+		"user_id", user.ID,
+	).Info("User created successfully in PostgreSQL")
+	return nil
+}
+
+// GetUserByID retrieves a user by ID
+func (p *PostgresDB) GetUserByID(ctx context.Context, id int64) (*User, error) {
+	logging.DB.WithContext(ctx).WithFields(
+		"user_id", id,
+		"db_type", "postgres",
+	).Debug("Getting user by ID")
+
+	user := &User{}
+	err := p.db.QueryRowContext(ctx,
+		"SELECT id, username, email, display_name, password_hash, permissions, active, password_change_required, minecraft_uuid, minecraft_name, last_login, deleted_at, created_at, updated_at FROM users WHERE id = $1",
+		id,
+	).Scan(
+		&user.ID, &user.Username, &user.Email, &user.DisplayName, &user.PasswordHash, &user.DirectPermissions,
+		&user.Active, &user.PasswordChangeRequired, &user.MinecraftUUID, &user.MinecraftName, &user.LastLogin, &user.DeletedAt, &user.CreatedAt, &user.UpdatedAt,
+	)
+	if err == sql.ErrNoRows {
+		logging.DB.WithContext(ctx).WithFields(
+			"user_id", id,
+			"error", "user_not_found",
+		).Debug("User not found by ID")
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"user_id", id,
+			"error", err.Error(),
+		).Error("Database error when getting user by ID")
+		return nil, err
+	}
+	if err := p.withRoles(ctx, user); err != nil {
+		return nil, err
+	}
+
+	logging.DB.WithContext(ctx).WithFields(
+		"user_id", id,
+		"username", user.Username,
+	).Debug("Successfully retrieved user by ID")
+	return user, nil
+}
+
+// GetUserByUsername retrieves a user by username
+func (p *PostgresDB) GetUserByUsername(ctx context.Context, username string) (*User, error) {
+	logging.DB.WithContext(ctx).WithFields(
+		"username", username,
+		"db_type", "postgres",
+	).Debug("Getting user by username")
+
+	user := &User{}
+	query := "SELECT id, username, email, display_name, password_hash, permissions, active, password_change_required, minecraft_uuid, minecraft_name, last_login, deleted_at, created_at, updated_at FROM users WHERE username = $1"
+
+	logging.DB.WithContext(ctx).WithFields(
+		"username", username,
+		"query", query,
+	).Debug("Executing database query")
+
+	err := p.db.QueryRowContext(ctx, query, username).Scan(
+		&user.ID, &user.Username, &user.Email, &user.DisplayName, &user.PasswordHash, &user.DirectPermissions,
+		&user.Active, &user.PasswordChangeRequired, &user.MinecraftUUID, &user.MinecraftName, &user.LastLogin, &user.DeletedAt, &user.CreatedAt, &user.UpdatedAt,
+	)
+	if err == sql.ErrNoRows {
+		logging.DB.WithContext(ctx).WithFields(
+			"username", username,
+			"error", "user_not_found",
+		).Debug("User not found")
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"username", username,
+			"error", err.Error(),
+		).Error("Database error when getting user by username")
+		return nil, err
+	}
+	if err := p.withRoles(ctx, user); err != nil {
+		return nil, err
+	}
+
+	logging.DB.WithContext(ctx).WithFields(
+		"username", user.Username,
+		"user_id", user.ID,
+	).Debug("Successfully retrieved user")
+	return user, nil
+}
+
+// GetUserByEmail retrieves a user by email, ignoring its case
+func (p *PostgresDB) GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	logging.DB.WithContext(ctx).WithFields(
+		"email", email,
+		"db_type", "postgres",
+	).Debug("Getting user by email")
+
+	user := &User{}
+	query := "SELECT id, username, email, display_name, password_hash, permissions, active, password_change_required, minecraft_uuid, minecraft_name, last_login, deleted_at, created_at, updated_at FROM users WHERE LOWER(email) = LOWER($1)"
+
+	logging.DB.WithContext(ctx).WithFields(
+		"email", email,
+		"query", query,
+	).Debug("Executing database query")
+
+	err := p.db.QueryRowContext(ctx, query, email).Scan(
+		&user.ID, &user.Username, &user.Email, &user.DisplayName, &user.PasswordHash, &user.DirectPermissions,
+		&user.Active, &user.PasswordChangeRequired, &user.MinecraftUUID, &user.MinecraftName, &user.LastLogin, &user.DeletedAt, &user.CreatedAt, &user.UpdatedAt,
+	)
+	if err == sql.ErrNoRows {
+		logging.DB.WithContext(ctx).WithFields(
+			"email", email,
+			"error", "user_not_found",
+		).Debug("User not found by email")
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"email", email,
+			"error", err.Error(),
+		).Error("Database error when getting user by email")
+		return nil, err
+	}
+	if err := p.withRoles(ctx, user); err != nil {
+		return nil, err
+	}
+
+	logging.DB.WithContext(ctx).WithFields(
+		"username", user.Username,
+		"user_id", user.ID,
+	).Debug("Successfully retrieved user")
+	return user, nil
+}
+
+// UpdateUser updates a user's information
+func (p *PostgresDB) UpdateUser(ctx context.Context, user *User) error {
+	logging.DB.WithContext(ctx).WithFields(
+		"user_id", user.ID,
+		"username", user.Username,
+	).Info("Updating user information in PostgreSQL")
+
+	user.UpdatedAt = time.Now()
+
+	_, err := p.db.ExecContext(ctx,
+		"UPDATE users SET username = $1, email = $2, display_name = $3, password_hash = $4, permissions = $5, active = $6, password_change_required = $7, updated_at = $8 WHERE id = $9",
+		user.Username, user.Email, user.DisplayName, user.PasswordHash, user.DirectPermissions, user.Active, user.PasswordChangeRequired, user.UpdatedAt, user.ID,
+	)
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"user_id", user.ID,
+			"username", user.Username,
+			"error", err.Error(),
+		).Error("Failed to update user")
+		return err
+	}
+
+	logging.DB.WithContext(ctx).WithFields(
+		"user_id", user.ID,
+		"username", user.Username,
+	).Info("User updated successfully")
+	return nil
+}
+
+// DeleteUser deletes a user by ID
+func (p *PostgresDB) DeleteUser(ctx context.Context, id int64) error {
+	logging.DB.WithContext(ctx).WithFields(
+		"user_id", id,
+	).Info("Deleting user from PostgreSQL")
+
+	_, err := p.db.ExecContext(ctx, "DELETE FROM users WHERE id = $1", id)
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"user_id", id,
+			"error", err.Error(),
+		).Error("Failed to delete user")
+		return err
+	}
+
+	logging.DB.WithContext(ctx).WithFields(
+		"user_id", id,
+	).Info("User deleted successfully")
+	return nil
+}
+
+// ListUsers returns a list of all users
+func (p *PostgresDB) ListUsers(ctx context.Context) ([]*User, error) {
+	logging.DB.WithContext(ctx).Debug("Listing all users from PostgreSQL")
+
+	rows, err := p.db.QueryContext(ctx,
+		"SELECT id, username, email, display_name, password_hash, permissions, active, password_change_required, minecraft_uuid, minecraft_name, last_login, deleted_at, created_at, updated_at FROM users",
+	)
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"error", err.Error(),
+		).Error("Failed to query users")
+		return nil, err
+	}
+	defer rows.Close()
 
 	users := []*User{}
 	for rows.Next() {
-		user := &User{}
-		if err := rows.Scan(
-			&user.ID, &user.Username, &user.Email, &user.PasswordHash, &user.Permissions,
-			&user.Active, &user.LastLogin, &user.CreatedAt, &user.UpdatedAt,
-		); err != nil {
-			logging.DB.WithFields(
+		user := &User{}
+		if err := rows.Scan(
+			&user.ID, &user.Username, &user.Email, &user.DisplayName, &user.PasswordHash, &user.DirectPermissions,
+			&user.Active, &user.PasswordChangeRequired, &user.MinecraftUUID, &user.MinecraftName, &user.LastLogin, &user.DeletedAt, &user.CreatedAt, &user.UpdatedAt,
+		); err != nil {
+			logging.DB.WithContext(ctx).WithFields(
+				"error", err.Error(),
+			).Error("Failed to scan user row")
+			return nil, err
+		}
+		users = append(users, user)
+	}
+
+	if err = rows.Err(); err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"error", err.Error(),
+		).Error("Error during user rows iteration")
+		return nil, err
+	}
+	if err := p.withRoles(ctx, users...); err != nil {
+		return nil, err
+	}
+
+	logging.DB.WithContext(ctx).WithFields(
+		"count", len(users),
+	).Debug("Successfully retrieved user list from PostgreSQL")
+	return users, nil
+}
+
+// CountUsers returns the number of user accounts
+func (p *PostgresDB) CountUsers(ctx context.Context) (int, error) {
+	var count int
+	if err := p.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM users").Scan(&count); err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"error", err.Error(),
+		).Error("Failed to count users")
+		return 0, err
+	}
+	return count, nil
+}
+
+// API Key operations
+
+// CreateAPIKey creates a new API key
+func (p *PostgresDB) CreateAPIKey(ctx context.Context, key *APIKey) error {
+	logging.DB.WithContext(ctx).WithFields(
+		"user_id", key.UserID,
+		"description", key.Description,
+	).Info("Creating new API key in PostgreSQL")
+
+	key.CreatedAt = dbClock.Now()
+
+	err := p.db.QueryRowContext(ctx,
+		"INSERT INTO api_keys (user_id, key, description, permissions, server_ids, expires_at, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id",
+		key.UserID, key.Key, key.Description, key.Permissions, key.ServerIDs, key.ExpiresAt, key.CreatedAt,
+	).Scan(&key.ID)
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"user_id", key.UserID,
+			"error", err.Error(),
+		).Error("Failed to insert API key")
+		return err
+	}
+
+	logging.DB.WithContext(ctx).WithFields(
+		"user_id", key.UserID,
+		"key_id", key.ID,
+	).Info("API key created successfully in PostgreSQL")
+	return nil
+}
+
+// GetAPIKey retrieves an API key by the key string
+func (p *PostgresDB) GetAPIKey(ctx context.Context, keyStr string) (*APIKey, error) {
+	// Mask the full key in logs for security
+	maskedKey := keyStr
+	if len(keyStr) > 8 {
+		maskedKey = keyStr[:4] + "..." + keyStr[len(keyStr)-4:]
+	}
+
+	logging.DB.WithContext(ctx).WithFields(
+		"key", maskedKey,
+	).Debug("Looking up API key in PostgreSQL")
+
+	key := &APIKey{}
+	err := p.db.QueryRowContext(ctx,
+		"SELECT id, user_id, key, description, permissions, server_ids, last_used, expires_at, created_at FROM api_keys WHERE key = $1",
+		keyStr,
+	).Scan(
+		&key.ID, &key.UserID, &key.Key, &key.Description, &key.Permissions, &key.ServerIDs, &key.LastUsed, &key.ExpiresAt, &key.CreatedAt,
+	)
+	if err == sql.ErrNoRows {
+		logging.DB.WithContext(ctx).WithFields(
+			"key", maskedKey,
+			"error", "invalid_api_key",
+		).Warn("API key not found")
+		return nil, ErrInvalidAPIKey
+	}
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"key", maskedKey,
+			"error", err.Error(),
+		).Error("Database error when retrieving API key")
+		return nil, err
+	}
+
+	logging.DB.WithContext(ctx).WithFields(
+		"key_id", key.ID,
+		"user_id", key.UserID,
+	).Debug("API key found")
+	return key, nil
+}
+
+// DeleteAPIKey deletes an API key by ID
+func (p *PostgresDB) DeleteAPIKey(ctx context.Context, id int64) error {
+	logging.DB.WithContext(ctx).WithFields(
+		"key_id", id,
+	).Info("Deleting API key from PostgreSQL")
+
+	_, err := p.db.ExecContext(ctx, "DELETE FROM api_keys WHERE id = $1", id)
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"key_id", id,
+			"error", err.Error(),
+		).Error("Failed to delete API key")
+		return err
+	}
+
+	logging.DB.WithContext(ctx).WithFields(
+		"key_id", id,
+	).Info("API key deleted successfully")
+	return nil
+}
+
+// ListAPIKeysByUser lists all API keys for a user
+func (p *PostgresDB) ListAPIKeysByUser(ctx context.Context, userID int64) ([]*APIKey, error) {
+	logging.DB.WithContext(ctx).WithFields(
+		"user_id", userID,
+	).Debug("Listing API keys for user from PostgreSQL")
+
+	rows, err := p.db.QueryContext(ctx,
+		"SELECT id, user_id, key, description, permissions, server_ids, last_used, expires_at, created_at FROM api_keys WHERE user_id = $1",
+		userID,
+	)
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"user_id", userID,
+			"error", err.Error(),
+		).Error("Failed to query API keys")
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []*APIKey{}
+	for rows.Next() {
+		key := &APIKey{}
+		if err := rows.Scan(
+			&key.ID, &key.UserID, &key.Key, &key.Description, &key.Permissions, &key.ServerIDs, &key.LastUsed, &key.ExpiresAt, &key.CreatedAt,
+		); err != nil {
+			logging.DB.WithContext(ctx).WithFields(
+				"user_id", userID,
+				"error", err.Error(),
+			).Error("Failed to scan API key row")
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	if err = rows.Err(); err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"user_id", userID,
+			"error", err.Error(),
+		).Error("Error during API key rows iteration")
+		return nil, err
+	}
+
+	logging.DB.WithContext(ctx).WithFields(
+		"user_id", userID,
+		"count", len(keys),
+	).Debug("Successfully retrieved API keys from PostgreSQL")
+	return keys, nil
+}
+
+// CreateServerRecord creates a new Minecraft server record
+func (p *PostgresDB) CreateServerRecord(ctx context.Context, server *MinecraftServer) error {
+	query := `INSERT INTO minecraft_servers
+              (server_name, deployment_name, pvc_name, owner_id, org_id, namespace, status, status_reason, spec, created_at, updated_at)
+              VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+              RETURNING id`
+
+	now := time.Now()
+	server.CreatedAt = now
+	server.UpdatedAt = now
+
+	err := p.db.QueryRowContext(ctx, query,
+		server.ServerName,
+		server.DeploymentName,
+		server.PVCName,
+		server.OwnerID,
+		server.OrgID,
+		server.Namespace,
+		server.Status,
+		server.StatusReason,
+		server.Spec,
+		server.CreatedAt,
+		server.UpdatedAt,
+	).Scan(&server.ID)
+
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"server_name", server.ServerName,
+			"error", err.Error(),
+		).Error("Failed to create server record")
+		return fmt.Errorf("failed to create server record: %w", err)
+	}
+
+	if err := p.logServerState(ctx, server.ServerName, now); err != nil {
+		return err
+	}
+
+	logging.DB.WithContext(ctx).WithFields(
+		"server_name", server.ServerName,
+		"server_id", server.ID,
+	).Info("Server record created successfully")
+	return nil
+}
+
+// GetServerByName gets a Minecraft server by its name
+func (p *PostgresDB) GetServerByName(ctx context.Context, serverName string) (*MinecraftServer, error) {
+	query := `SELECT id, server_name, deployment_name, pvc_name, owner_id, org_id, namespace,
+              status, status_reason, spec, created_at, updated_at
+              FROM minecraft_servers WHERE server_name = $1`
+
+	var server MinecraftServer
+	err := p.db.QueryRowContext(ctx, query, serverName).Scan(
+		&server.ID,
+		&server.ServerName,
+		&server.DeploymentName,
+		&server.PVCName,
+		&server.OwnerID,
+		&server.OrgID,
+		&server.Namespace,
+		&server.Status,
+		&server.StatusReason,
+		&server.Spec,
+		&server.CreatedAt,
+		&server.UpdatedAt,
+	)
+
+	if err == sql.ErrNoRows {
+		logging.DB.WithContext(ctx).WithFields(
+			"server_name", serverName,
+			"error", "server_not_found",
+		).Warn("Server not found")
+		return nil, fmt.Errorf("server not found: %s", serverName)
+	}
+
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"server_name", serverName,
+			"error", err.Error(),
+		).Error("Failed to get server")
+		return nil, fmt.Errorf("failed to get server: %w", err)
+	}
+
+	logging.DB.WithContext(ctx).WithFields(
+		"server_name", serverName,
+		"server_id", server.ID,
+	).Info("Server retrieved successfully")
+	return &server, nil
+}
+
+// ListServersByOwner list all Minecraft servers by owner ID
+func (p *PostgresDB) ListServersByOwner(ctx context.Context, ownerID int64) ([]*MinecraftServer, error) {
+	query := `SELECT id, server_name, deployment_name, pvc_name, owner_id, org_id, namespace,
+              status, status_reason, spec, created_at, updated_at
+              FROM minecraft_servers WHERE owner_id = $1`
+
+	rows, err := p.db.QueryContext(ctx, query, ownerID)
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"owner_id", ownerID,
+			"error", err.Error(),
+		).Error("Failed to list servers")
+		return nil, fmt.Errorf("failed to list servers: %w", err)
+	}
+	defer rows.Close()
+
+	var servers []*MinecraftServer
+	for rows.Next() {
+		var server MinecraftServer
+		if err := rows.Scan(
+			&server.ID,
+			&server.ServerName,
+			&server.DeploymentName,
+			&server.PVCName,
+			&server.OwnerID,
+			&server.OrgID,
+			&server.Namespace,
+			&server.Status,
+			&server.StatusReason,
+			&server.Spec,
+			&server.CreatedAt,
+			&server.UpdatedAt,
+		); err != nil {
+			logging.DB.WithContext(ctx).WithFields(
+				"owner_id", ownerID,
+				"error", err.Error(),
+			).Error("Failed to scan server row")
+			return nil, fmt.Errorf("failed to scan server row: %w", err)
+		}
+		servers = append(servers, &server)
+	}
+
+	if err := rows.Err(); err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"owner_id", ownerID,
+			"error", err.Error(),
+		).Error("Error iterating server rows")
+		return nil, fmt.Errorf("error iterating server rows: %w", err)
+	}
+
+	logging.DB.WithContext(ctx).WithFields(
+		"owner_id", ownerID,
+		"count", len(servers),
+	).Info("Servers listed successfully")
+	return servers, nil
+}
+
+// UpdateServerStatus updates the status of a Minecraft server
+func (p *PostgresDB) UpdateServerStatus(ctx context.Context, serverName string, status string, reason string) error {
+	query := `UPDATE minecraft_servers SET status = $1, status_reason = $2, updated_at = $3 WHERE server_name = $4`
+
+	now := time.Now()
+	if err := p.logServerStatusChange(ctx, serverName, status, reason, now); err != nil {
+		return err
+	}
+	_, err := p.db.ExecContext(ctx, query, status, reason, now, serverName)
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"server_name", serverName,
+			"status", status,
+			"error", err.Error(),
+		).Error("Failed to update server status")
+		return fmt.Errorf("failed to update server status: %w", err)
+	}
+
+	logging.DB.WithContext(ctx).WithFields(
+		"server_name", serverName,
+		"status", status,
+	).Info("Server status updated successfully")
+	return nil
+}
+
+// DeleteServerRecord deletes a Minecraft server record
+func (p *PostgresDB) DeleteServerRecord(ctx context.Context, serverName string) error {
+	query := `DELETE FROM minecraft_servers WHERE server_name = $1`
+
+	if err := p.logServerStatusChange(ctx, serverName, ServerStatusDeleted, "", time.Now()); err != nil {
+		return err
+	}
+	if err := p.deleteServerTasks(ctx, serverName); err != nil {
+		return err
+	}
+	if err := p.deleteServerIncidents(ctx, serverName); err != nil {
+		return err
+	}
+	_, err := p.db.ExecContext(ctx, query, serverName)
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"server_name", serverName,
+			"error", err.Error(),
+		).Error("Failed to delete server record")
+		return fmt.Errorf("failed to delete server record: %w", err)
+	}
+
+	logging.DB.WithContext(ctx).WithFields(
+		"server_name", serverName,
+	).Info("Server record deleted successfully")
+	return nil
+}
+
+// Server request operations
+
+// CreateServerRequest stores a new server creation request
+func (p *PostgresDB) CreateServerRequest(ctx context.Context, req *ServerRequest) error {
+	logging.DB.WithContext(ctx).WithFields(
+		"server_name", req.ServerName,
+		"requester_id", req.RequesterID,
+	).Info("Creating server request")
+
+	req.CreatedAt = time.Now()
+	if req.Status == "" {
+		req.Status = ServerRequestPending
+	}
+
+	id, err := p.insertReturningID(ctx,
+		`INSERT INTO server_requests (server_name, requester_id, payload, status, reason, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)`,
+		req.ServerName, req.RequesterID, string(req.Payload), req.Status, req.Reason, req.CreatedAt,
+	)
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"server_name", req.ServerName,
+			"error", err.Error(),
+		).Error("Failed to create server request")
+		return fmt.Errorf("failed to create server request: %w", err)
+	}
+	req.ID = id
+
+	logging.DB.WithContext(ctx).WithFields(
+		"server_name", req.ServerName,
+		"server_request_id", req.ID,
+	).Info("Server request created successfully")
+	return nil
+}
+
+// GetServerRequest retrieves a server request by ID
+func (p *PostgresDB) GetServerRequest(ctx context.Context, id int64) (*ServerRequest, error) {
+	logging.DB.WithContext(ctx).WithFields(
+		"server_request_id", id,
+	).Debug("Getting server request")
+
+	req, err := scanServerRequest(p.db.QueryRowContext(ctx,
+		"SELECT "+serverRequestColumns+" FROM server_requests WHERE id = $1", id,
+	))
+	if err == sql.ErrNoRows {
+		logging.DB.WithContext(ctx).WithFields(
+			"server_request_id", id,
+		).Debug("Server request not found")
+		return nil, ErrRequestNotFound
+	}
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"server_request_id", id,
+			"error", err.Error(),
+		).Error("Failed to get server request")
+		return nil, fmt.Errorf("failed to get server request: %w", err)
+	}
+	return req, nil
+}
+
+// ListServerRequests lists server requests, optionally filtered by status
+func (p *PostgresDB) ListServerRequests(ctx context.Context, status string) ([]*ServerRequest, error) {
+	logging.DB.WithContext(ctx).WithFields(
+		"status", status,
+	).Debug("Listing server requests")
+
+	if status == "" {
+		return p.queryServerRequests(ctx, "SELECT "+serverRequestColumns+" FROM server_requests ORDER BY created_at DESC")
+	}
+	return p.queryServerRequests(ctx, "SELECT "+serverRequestColumns+" FROM server_requests WHERE status = $1 ORDER BY created_at DESC", status)
+}
+
+// ListServerRequestsByUser lists the server requests submitted by a user
+func (p *PostgresDB) ListServerRequestsByUser(ctx context.Context, userID int64) ([]*ServerRequest, error) {
+	logging.DB.WithContext(ctx).WithFields(
+		"user_id", userID,
+	).Debug("Listing server requests by user")
+
+	return p.queryServerRequests(ctx, "SELECT "+serverRequestColumns+" FROM server_requests WHERE requester_id = $1 ORDER BY created_at DESC", userID)
+}
+
+func (p *PostgresDB) queryServerRequests(ctx context.Context, query string, args ...interface{}) ([]*ServerRequest, error) {
+	rows, err := p.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"error", err.Error(),
+		).Error("Failed to query server requests")
+		return nil, fmt.Errorf("failed to list server requests: %w", err)
+	}
+	defer rows.Close()
+
+	requests := []*ServerRequest{}
+	for rows.Next() {
+		req, err := scanServerRequest(rows)
+		if err != nil {
+			logging.DB.WithContext(ctx).WithFields(
+				"error", err.Error(),
+			).Error("Failed to scan server request row")
+			return nil, fmt.Errorf("failed to scan server request row: %w", err)
+		}
+		requests = append(requests, req)
+	}
+
+	if err := rows.Err(); err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"error", err.Error(),
+		).Error("Error iterating server request rows")
+		return nil, fmt.Errorf("error iterating server request rows: %w", err)
+	}
+
+	logging.DB.WithContext(ctx).WithFields(
+		"count", len(requests),
+	).Debug("Server requests listed successfully")
+	return requests, nil
+}
+
+// UpdateServerRequest records the review outcome of a server request
+func (p *PostgresDB) UpdateServerRequest(ctx context.Context, req *ServerRequest) error {
+	logging.DB.WithContext(ctx).WithFields(
+		"server_request_id", req.ID,
+		"status", req.Status,
+	).Info("Updating server request")
+
+	result, err := p.db.ExecContext(ctx,
+		"UPDATE server_requests SET status = $1, reason = $2, reviewer_id = $3, reviewed_at = $4 WHERE id = $5",
+		req.Status, req.Reason, req.ReviewerID, req.ReviewedAt, req.ID,
+	)
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"server_request_id", req.ID,
+			"error", err.Error(),
+		).Error("Failed to update server request")
+		return fmt.Errorf("failed to update server request: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrRequestNotFound
+	}
+
+	logging.DB.WithContext(ctx).WithFields(
+		"server_request_id", req.ID,
+		"status", req.Status,
+	).Info("Server request updated successfully")
+	return nil
+}
+
+// Notification operations
+
+// CreateNotification stores a notification for a user
+func (p *PostgresDB) CreateNotification(ctx context.Context, notification *Notification) error {
+	logging.DB.WithContext(ctx).WithFields(
+		"user_id", notification.UserID,
+	).Debug("Creating notification")
+
+	notification.CreatedAt = time.Now()
+
+	id, err := p.insertReturningID(ctx,
+		"INSERT INTO notifications (user_id, message, read, created_at) VALUES ($1, $2, $3, $4)",
+		notification.UserID, notification.Message, notification.Read, notification.CreatedAt,
+	)
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"user_id", notification.UserID,
+			"error", err.Error(),
+		).Error("Failed to create notification")
+		return fmt.Errorf("failed to create notification: %w", err)
+	}
+	notification.ID = id
+	return nil
+}
+
+// ListNotificationsByUser lists a user's notifications, newest first
+func (p *PostgresDB) ListNotificationsByUser(ctx context.Context, userID int64, unreadOnly bool) ([]*Notification, error) {
+	logging.DB.WithContext(ctx).WithFields(
+		"user_id", userID,
+		"unread_only", unreadOnly,
+	).Debug("Listing notifications")
+
+	query := "SELECT id, user_id, message, read, created_at FROM notifications WHERE user_id = $1"
+	if unreadOnly {
+		query += " AND read = FALSE"
+	}
+	query += " ORDER BY created_at DESC"
+
+	rows, err := p.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"user_id", userID,
+			"error", err.Error(),
+		).Error("Failed to query notifications")
+		return nil, fmt.Errorf("failed to list notifications: %w", err)
+	}
+	defer rows.Close()
+
+	notifications := []*Notification{}
+	for rows.Next() {
+		var n Notification
+		if err := rows.Scan(&n.ID, &n.UserID, &n.Message, &n.Read, &n.CreatedAt); err != nil {
+			logging.DB.WithContext(ctx).WithFields(
+				"user_id", userID,
+				"error", err.Error(),
+			).Error("Failed to scan notification row")
+			return nil, fmt.Errorf("failed to scan notification row: %w", err)
+		}
+		notifications = append(notifications, &n)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating notification rows: %w", err)
+	}
+	return notifications, nil
+}
+
+// MarkNotificationRead marks one of the user's notifications as read
+func (p *PostgresDB) MarkNotificationRead(ctx context.Context, userID int64, id int64) error {
+	logging.DB.WithContext(ctx).WithFields(
+		"user_id", userID,
+		"notification_id", id,
+	).Debug("Marking notification as read")
+
+	result, err := p.db.ExecContext(ctx,
+		"UPDATE notifications SET read = TRUE WHERE id = $1 AND user_id = $2", id, userID,
+	)
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"notification_id", id,
+			"error", err.Error(),
+		).Error("Failed to mark notification as read")
+		return fmt.Errorf("failed to mark notification as read: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrNotificationNotFound
+	}
+	return nil
+}
+
+// Server template operations
+
+// CreateServerTemplate stores a new server template
+func (p *PostgresDB) CreateServerTemplate(ctx context.Context, template *ServerTemplate) error {
+	logging.DB.WithContext(ctx).WithFields(
+		"template_name", template.Name,
+		"created_by", template.CreatedBy,
+	).Info("Creating server template")
+
+	var exists bool
+	err := p.db.QueryRowContext(ctx,
+		"SELECT EXISTS(SELECT 1 FROM server_templates WHERE name = $1)", template.Name,
+	).Scan(&exists)
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"template_name", template.Name,
+			"error", err.Error(),
+		).Error("Database error when checking if server template exists")
+		return fmt.Errorf("failed to check server template: %w", err)
+	}
+	if exists {
+		logging.DB.WithContext(ctx).WithFields(
+			"template_name", template.Name,
+		).Warn("Cannot create server template: name already exists")
+		return ErrTemplateExists
+	}
+
+	now := time.Now()
+	template.CreatedAt = now
+	template.UpdatedAt = now
+
+	id, err := p.insertReturningID(ctx,
+		`INSERT INTO server_templates (name, description, category, spec, parameters, source, created_by, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		template.Name, template.Description, template.Category, template.Spec, template.Parameters, template.Source, template.CreatedBy, template.CreatedAt, template.UpdatedAt,
+	)
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"template_name", template.Name,
+			"error", err.Error(),
+		).Error("Failed to create server template")
+		return fmt.Errorf("failed to create server template: %w", err)
+	}
+	template.ID = id
+
+	logging.DB.WithContext(ctx).WithFields(
+		"template_name", template.Name,
+		"template_id", template.ID,
+	).Info("Server template created successfully")
+	return nil
+}
+
+// GetServerTemplate retrieves a server template by ID
+func (p *PostgresDB) GetServerTemplate(ctx context.Context, id int64) (*ServerTemplate, error) {
+	logging.DB.WithContext(ctx).WithFields(
+		"template_id", id,
+	).Debug("Getting server template")
+
+	template, err := scanServerTemplate(p.db.QueryRowContext(ctx,
+		"SELECT "+serverTemplateColumns+" FROM server_templates WHERE id = $1", id,
+	))
+	if err == sql.ErrNoRows {
+		logging.DB.WithContext(ctx).WithFields(
+			"template_id", id,
+		).Debug("Server template not found")
+		return nil, ErrTemplateNotFound
+	}
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"template_id", id,
+			"error", err.Error(),
+		).Error("Failed to get server template")
+		return nil, fmt.Errorf("failed to get server template: %w", err)
+	}
+	return template, nil
+}
+
+// ListServerTemplates lists all server templates by name
+func (p *PostgresDB) ListServerTemplates(ctx context.Context) ([]*ServerTemplate, error) {
+	logging.DB.WithContext(ctx).Debug("Listing server templates")
+
+	rows, err := p.db.QueryContext(ctx, "SELECT "+serverTemplateColumns+" FROM server_templates ORDER BY name")
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"error", err.Error(),
+		).Error("Failed to query server templates")
+		return nil, fmt.Errorf("failed to list server templates: %w", err)
+	}
+	defer rows.Close()
+
+	templates := []*ServerTemplate{}
+	for rows.Next() {
+		template, err := scanServerTemplate(rows)
+		if err != nil {
+			logging.DB.WithContext(ctx).WithFields(
+				"error", err.Error(),
+			).Error("Failed to scan server template row")
+			return nil, fmt.Errorf("failed to scan server template row: %w", err)
+		}
+		templates = append(templates, template)
+	}
+
+	if err := rows.Err(); err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"error", err.Error(),
+		).Error("Error iterating server template rows")
+		return nil, fmt.Errorf("error iterating server template rows: %w", err)
+	}
+
+	logging.DB.WithContext(ctx).WithFields(
+		"count", len(templates),
+	).Debug("Server templates listed successfully")
+	return templates, nil
+}
+
+// UpdateServerTemplate updates the name, description and spec of a server template
+func (p *PostgresDB) UpdateServerTemplate(ctx context.Context, template *ServerTemplate) error {
+	logging.DB.WithContext(ctx).WithFields(
+		"template_id", template.ID,
+		"template_name", template.Name,
+	).Info("Updating server template")
+
+	var exists bool
+	err := p.db.QueryRowContext(ctx,
+		"SELECT EXISTS(SELECT 1 FROM server_templates WHERE name = $1 AND id <> $2)", template.Name, template.ID,
+	).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("failed to check server template: %w", err)
+	}
+	if exists {
+		logging.DB.WithContext(ctx).WithFields(
+			"template_id", template.ID,
+			"template_name", template.Name,
+		).Warn("Cannot rename server template: name already exists")
+		return ErrTemplateExists
+	}
+
+	template.UpdatedAt = time.Now()
+
+	result, err := p.db.ExecContext(ctx,
+		"UPDATE server_templates SET name = $1, description = $2, category = $3, spec = $4, parameters = $5, updated_at = $6 WHERE id = $7",
+		template.Name, template.Description, template.Category, template.Spec, template.Parameters, template.UpdatedAt, template.ID,
+	)
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"template_id", template.ID,
+			"error", err.Error(),
+		).Error("Failed to update server template")
+		return fmt.Errorf("failed to update server template: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrTemplateNotFound
+	}
+
+	logging.DB.WithContext(ctx).WithFields(
+		"template_id", template.ID,
+		"template_name", template.Name,
+	).Info("Server template updated successfully")
+	return nil
+}
+
+// DeleteServerTemplate deletes a server template by ID
+func (p *PostgresDB) DeleteServerTemplate(ctx context.Context, id int64) error {
+	logging.DB.WithContext(ctx).WithFields(
+		"template_id", id,
+	).Info("Deleting server template")
+
+	result, err := p.db.ExecContext(ctx, "DELETE FROM server_templates WHERE id = $1", id)
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"template_id", id,
+			"error", err.Error(),
+		).Error("Failed to delete server template")
+		return fmt.Errorf("failed to delete server template: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrTemplateNotFound
+	}
+
+	logging.DB.WithContext(ctx).WithFields(
+		"template_id", id,
+	).Info("Server template deleted successfully")
+	return nil
+}
+
+// User quota operations
+
+// GetUserQuota retrieves the quota of a user
+func (p *PostgresDB) GetUserQuota(ctx context.Context, userID int64) (*UserQuota, error) {
+	logging.DB.WithContext(ctx).WithFields(
+		"user_id", userID,
+	).Debug("Getting user quota")
+
+	var quota UserQuota
+	err := p.db.QueryRowContext(ctx,
+		"SELECT user_id, max_servers, max_memory, max_storage, updated_at FROM user_quotas WHERE user_id = $1", userID,
+	).Scan(&quota.UserID, &quota.MaxServers, &quota.MaxMemory, &quota.MaxStorage, &quota.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrQuotaNotFound
+	}
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"user_id", userID,
+			"error", err.Error(),
+		).Error("Failed to get user quota")
+		return nil, fmt.Errorf("failed to get user quota: %w", err)
+	}
+	return &quota, nil
+}
+
+// SetUserQuota creates or replaces the quota of a user
+func (p *PostgresDB) SetUserQuota(ctx context.Context, quota *UserQuota) error {
+	logging.DB.WithContext(ctx).WithFields(
+		"user_id", quota.UserID,
+		"max_servers", quota.MaxServers,
+		"max_memory", quota.MaxMemory,
+		"max_storage", quota.MaxStorage,
+	).Info("Setting user quota")
+
+	quota.UpdatedAt = time.Now()
+
+	_, err := p.db.ExecContext(ctx,
+		`INSERT INTO user_quotas (user_id, max_servers, max_memory, max_storage, updated_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (user_id) DO UPDATE SET
+			max_servers = excluded.max_servers,
+			max_memory = excluded.max_memory,
+			max_storage = excluded.max_storage,
+			updated_at = excluded.updated_at`,
+		quota.UserID, quota.MaxServers, quota.MaxMemory, quota.MaxStorage, quota.UpdatedAt,
+	)
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"user_id", quota.UserID,
+			"error", err.Error(),
+		).Error("Failed to set user quota")
+		return fmt.Errorf("failed to set user quota: %w", err)
+	}
+
+	logging.DB.WithContext(ctx).WithFields(
+		"user_id", quota.UserID,
+	).Info("User quota set successfully")
+	return nil
+}
+
+// DeleteUserQuota removes the quota of a user, leaving them unlimited
+func (p *PostgresDB) DeleteUserQuota(ctx context.Context, userID int64) error {
+	logging.DB.WithContext(ctx).WithFields(
+		"user_id", userID,
+	).Info("Deleting user quota")
+
+	result, err := p.db.ExecContext(ctx, "DELETE FROM user_quotas WHERE user_id = $1", userID)
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"user_id", userID,
+			"error", err.Error(),
+		).Error("Failed to delete user quota")
+		return fmt.Errorf("failed to delete user quota: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrQuotaNotFound
+	}
+	return nil
+}
+
+// ListServers lists every server record
+func (p *PostgresDB) ListServers(ctx context.Context) ([]*MinecraftServer, error) {
+	logging.DB.WithContext(ctx).Debug("Listing all servers")
+
+	rows, err := p.db.QueryContext(ctx,
+		`SELECT id, server_name, deployment_name, pvc_name, owner_id, org_id, namespace,
+		status, status_reason, spec, created_at, updated_at
+		FROM minecraft_servers ORDER BY server_name`)
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"error", err.Error(),
+		).Error("Failed to list servers")
+		return nil, fmt.Errorf("failed to list servers: %w", err)
+	}
+	defer rows.Close()
+
+	var servers []*MinecraftServer
+	for rows.Next() {
+		var server MinecraftServer
+		if err := rows.Scan(
+			&server.ID,
+			&server.ServerName,
+			&server.DeploymentName,
+			&server.PVCName,
+			&server.OwnerID,
+			&server.OrgID,
+			&server.Namespace,
+			&server.Status,
+			&server.StatusReason,
+			&server.Spec,
+			&server.CreatedAt,
+			&server.UpdatedAt,
+		); err != nil {
+			logging.DB.WithContext(ctx).WithFields(
+				"error", err.Error(),
+			).Error("Failed to scan server row")
+			return nil, fmt.Errorf("failed to scan server row: %w", err)
+		}
+		servers = append(servers, &server)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating server rows: %w", err)
+	}
+
+	logging.DB.WithContext(ctx).WithFields(
+		"server_count", len(servers),
+	).Debug("Servers listed successfully")
+	return servers, nil
+}
+
+// RenameServer renames a server record and points it to its new deployment
+func (p *PostgresDB) RenameServer(ctx context.Context, serverName, newName, deploymentName string) error {
+	logging.DB.WithContext(ctx).WithFields(
+		"server_name", serverName,
+		"new_name", newName,
+	).Info("Renaming server record")
+
+	now := time.Now()
+	if err := p.logServerStatusChange(ctx, serverName, ServerStatusDeleted, "", now); err != nil {
+		return err
+	}
+	result, err := p.db.ExecContext(ctx,
+		`UPDATE minecraft_servers SET server_name = $1, deployment_name = $2, updated_at = $3 WHERE server_name = $4`,
+		newName, deploymentName, now, serverName)
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"server_name", serverName,
+			"new_name", newName,
+			"error", err.Error(),
+		).Error("Failed to rename server record")
+		return fmt.Errorf("failed to rename server record: %w", err)
+	}
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return fmt.Errorf("server not found: %s", serverName)
+	}
+
+	// The job history follows the server
+	if _, err := p.db.ExecContext(ctx, `UPDATE jobs SET server_name = $1 WHERE server_name = $2`, newName, serverName); err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"server_name", serverName,
+			"new_name", newName,
+			"error", err.Error(),
+		).Error("Failed to rename server jobs")
+		return fmt.Errorf("failed to rename server jobs: %w", err)
+	}
+
+	if err := p.logServerState(ctx, newName, now); err != nil {
+		return err
+	}
+
+	logging.DB.WithContext(ctx).WithFields(
+		"server_name", serverName,
+		"new_name", newName,
+	).Info("Server record renamed successfully")
+	return nil
+}
+
+// Server status change log operations
+
+// logServerState appends the recorded state of a server to the status change log
+func (p *PostgresDB) logServerState(ctx context.Context, serverName string, at time.Time) error {
+	_, err := p.db.ExecContext(ctx,
+		`INSERT INTO server_status_changes (server_name, owner_id, status, status_reason, changed_at)
+		SELECT server_name, owner_id, status, status_reason, $1 FROM minecraft_servers WHERE server_name = $2`,
+		at, serverName)
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"server_name", serverName,
+			"error", err.Error(),
+		).Error("Failed to log server status change")
+		return fmt.Errorf("failed to log server status change: %w", err)
+	}
+	return nil
+}
+
+// logServerStatusChange appends a new status of a server to the status change log,
+// unless the server is already recorded in that status for the same reason
+func (p *PostgresDB) logServerStatusChange(ctx context.Context, serverName, status, reason string, at time.Time) error {
+	_, err := p.db.ExecContext(ctx,
+		`INSERT INTO server_status_changes (server_name, owner_id, status, status_reason, changed_at)
+		SELECT server_name, owner_id, $1, $2, $3 FROM minecraft_servers
+		WHERE server_name = $4 AND (status <> $5 OR status_reason <> $6)`,
+		status, reason, at, serverName, status, reason)
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"server_name", serverName,
+			"status", status,
+			"error", err.Error(),
+		).Error("Failed to log server status change")
+		return fmt.Errorf("failed to log server status change: %w", err)
+	}
+	return nil
+}
+
+// ListServerStatusChanges lists the status changes logged after the given ID, oldest first
+func (p *PostgresDB) ListServerStatusChanges(ctx context.Context, afterID int64, limit int) ([]*ServerStatusChange, error) {
+	logging.DB.WithContext(ctx).WithFields(
+		"after_id", afterID,
+		"limit", limit,
+	).Debug("Listing server status changes")
+
+	rows, err := p.db.QueryContext(ctx,
+		`SELECT id, server_name, owner_id, status, status_reason, changed_at
+		FROM server_status_changes WHERE id > $1 ORDER BY id LIMIT $2`,
+		afterID, limit)
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"after_id", afterID,
+			"error", err.Error(),
+		).Error("Failed to list server status changes")
+		return nil, fmt.Errorf("failed to list server status changes: %w", err)
+	}
+	defer rows.Close()
+
+	var changes []*ServerStatusChange
+	for rows.Next() {
+		var change ServerStatusChange
+		if err := rows.Scan(
+			&change.ID,
+			&change.ServerName,
+			&change.OwnerID,
+			&change.Status,
+			&change.StatusReason,
+			&change.ChangedAt,
+		); err != nil {
+			logging.DB.WithContext(ctx).WithFields(
+				"error", err.Error(),
+			).Error("Failed to scan server status change row")
+			return nil, fmt.Errorf("failed to scan server status change row: %w", err)
+		}
+		changes = append(changes, &change)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating server status change rows: %w", err)
+	}
+	return changes, nil
+}
+
+// ServerStatusCursor returns the ID of the last status change logged at or before the given time, 0 if none
+func (p *PostgresDB) ServerStatusCursor(ctx context.Context, at time.Time) (int64, error) {
+	var cursor int64
+	err := p.db.QueryRowContext(ctx,
+		`SELECT COALESCE(MAX(id), 0) FROM server_status_changes WHERE changed_at <= $1`, at,
+	).Scan(&cursor)
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"error", err.Error(),
+		).Error("Failed to get server status cursor")
+		return 0, fmt.Errorf("failed to get server status cursor: %w", err)
+	}
+	return cursor, nil
+}
+
+// OldestServerStatusChangeID returns the ID of the oldest status change still logged, 0 if none
+func (p *PostgresDB) OldestServerStatusChangeID(ctx context.Context) (int64, error) {
+	var id int64
+	err := p.db.QueryRowContext(ctx,
+		`SELECT COALESCE(MIN(id), 0) FROM server_status_changes`,
+	).Scan(&id)
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"error", err.Error(),
+		).Error("Failed to get oldest server status change")
+		return 0, fmt.Errorf("failed to get oldest server status change: %w", err)
+	}
+	return id, nil
+}
+
+// PruneServerStatusChanges deletes the status changes logged before the given time.
+// The last change is always kept so the log tells how far back it goes.
+func (p *PostgresDB) PruneServerStatusChanges(ctx context.Context, before time.Time) (int64, error) {
+	result, err := p.db.ExecContext(ctx,
+		`DELETE FROM server_status_changes
+		WHERE changed_at < $1 AND id < (SELECT MAX(id) FROM server_status_changes)`,
+		before)
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"error", err.Error(),
+		).Error("Failed to prune server status changes")
+		return 0, fmt.Errorf("failed to prune server status changes: %w", err)
+	}
+
+	pruned, _ := result.RowsAffected()
+	return pruned, nil
+}
+
+// Scheduled task operations
+
+// CreateScheduledTask stores a new scheduled task
+func (p *PostgresDB) CreateScheduledTask(ctx context.Context, task *ScheduledTask) error {
+	logging.DB.WithContext(ctx).WithFields(
+		"server_id", task.ServerID,
+		"task_name", task.Name,
+		"action", task.Action,
+	).Info("Creating scheduled task")
+
+	now := time.Now()
+	task.CreatedAt = now
+	task.UpdatedAt = now
+
+	id, err := p.insertReturningID(ctx,
+		`INSERT INTO scheduled_tasks (server_id, name, schedule, action, payload, enabled, created_by, next_run_at, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+		task.ServerID, task.Name, task.Schedule, task.Action, task.Payload, task.Enabled, task.CreatedBy, task.NextRunAt, task.CreatedAt, task.UpdatedAt,
+	)
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"server_id", task.ServerID,
+			"task_name", task.Name,
+			"error", err.Error(),
+		).Error("Failed to create scheduled task")
+		return fmt.Errorf("failed to create scheduled task: %w", err)
+	}
+	task.ID = id
+
+	logging.DB.WithContext(ctx).WithFields(
+		"task_id", task.ID,
+		"task_name", task.Name,
+	).Info("Scheduled task created successfully")
+	return nil
+}
+
+// GetScheduledTask retrieves a scheduled task by ID
+func (p *PostgresDB) GetScheduledTask(ctx context.Context, id int64) (*ScheduledTask, error) {
+	logging.DB.WithContext(ctx).WithFields(
+		"task_id", id,
+	).Debug("Getting scheduled task")
+
+	task, err := scanScheduledTask(p.db.QueryRowContext(ctx,
+		"SELECT "+scheduledTaskColumns+" FROM scheduled_tasks t JOIN minecraft_servers s ON s.id = t.server_id WHERE t.id = $1", id,
+	))
+	if err == sql.ErrNoRows {
+		logging.DB.WithContext(ctx).WithFields(
+			"task_id", id,
+		).Debug("Scheduled task not found")
+		return nil, ErrTaskNotFound
+	}
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"task_id", id,
+			"error", err.Error(),
+		).Error("Failed to get scheduled task")
+		return nil, fmt.Errorf("failed to get scheduled task: %w", err)
+	}
+	return task, nil
+}
+
+// ListScheduledTasks lists the scheduled tasks of a server
+func (p *PostgresDB) ListScheduledTasks(ctx context.Context, serverID int64) ([]*ScheduledTask, error) {
+	logging.DB.WithContext(ctx).WithFields(
+		"server_id", serverID,
+	).Debug("Listing scheduled tasks")
+
+	return p.queryScheduledTasks(ctx,
+		"SELECT "+scheduledTaskColumns+" FROM scheduled_tasks t JOIN minecraft_servers s ON s.id = t.server_id WHERE t.server_id = $1 ORDER BY t.id",
+		serverID)
+}
+
+// ListDueScheduledTasks lists the enabled scheduled tasks due to run at the given time
+func (p *PostgresDB) ListDueScheduledTasks(ctx context.Context, at time.Time) ([]*ScheduledTask, error) {
+	return p.queryScheduledTasks(ctx,
+		"SELECT "+scheduledTaskColumns+" FROM scheduled_tasks t JOIN minecraft_servers s ON s.id = t.server_id WHERE t.enabled = $1 AND t.next_run_at <= $2 ORDER BY t.next_run_at",
+		true, at)
+}
+
+// queryScheduledTasks runs a scheduled task query and scans the resulting rows
+func (p *PostgresDB) queryScheduledTasks(ctx context.Context, query string, args ...interface{}) ([]*ScheduledTask, error) {
+	rows, err := p.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"error", err.Error(),
+		).Error("Failed to query scheduled tasks")
+		return nil, fmt.Errorf("failed to list scheduled tasks: %w", err)
+	}
+	defer rows.Close()
+
+	tasks := []*ScheduledTask{}
+	for rows.Next() {
+		task, err := scanScheduledTask(rows)
+		if err != nil {
+			logging.DB.WithContext(ctx).WithFields(
+				"error", err.Error(),
+			).Error("Failed to scan scheduled task row")
+			return nil, fmt.Errorf("failed to scan scheduled task row: %w", err)
+		}
+		tasks = append(tasks, task)
+	}
+
+	if err := rows.Err(); err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"error", err.Error(),
+		).Error("Error iterating scheduled task rows")
+		return nil, fmt.Errorf("error iterating scheduled task rows: %w", err)
+	}
+	return tasks, nil
+}
+
+// UpdateScheduledTask updates the definition and next run of a scheduled task
+func (p *PostgresDB) UpdateScheduledTask(ctx context.Context, task *ScheduledTask) error {
+	logging.DB.WithContext(ctx).WithFields(
+		"task_id", task.ID,
+		"task_name", task.Name,
+	).Info("Updating scheduled task")
+
+	task.UpdatedAt = time.Now()
+
+	result, err := p.db.ExecContext(ctx,
+		`UPDATE scheduled_tasks SET name = $1, schedule = $2, action = $3, payload = $4, enabled = $5, next_run_at = $6, updated_at = $7
+		WHERE id = $8`,
+		task.Name, task.Schedule, task.Action, task.Payload, task.Enabled, task.NextRunAt, task.UpdatedAt, task.ID,
+	)
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"task_id", task.ID,
+			"error", err.Error(),
+		).Error("Failed to update scheduled task")
+		return fmt.Errorf("failed to update scheduled task: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrTaskNotFound
+	}
+
+	logging.DB.WithContext(ctx).WithFields(
+		"task_id", task.ID,
+		"task_name", task.Name,
+	).Info("Scheduled task updated successfully")
+	return nil
+}
+
+// ClaimScheduledTask records that a due task runs now and when it runs next.
+// It returns false when the task was claimed meanwhile, by another scheduler
+func (p *PostgresDB) ClaimScheduledTask(ctx context.Context, task *ScheduledTask, runAt time.Time, next *time.Time) (bool, error) {
+	result, err := p.db.ExecContext(ctx,
+		`UPDATE scheduled_tasks SET run_count = run_count + 1, last_run_at = $1, next_run_at = $2
+		WHERE id = $3 AND run_count = $4`,
+		runAt, next, task.ID, task.RunCount,
+	)
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"task_id", task.ID,
+			"error", err.Error(),
+		).Error("Failed to claim scheduled task")
+		return false, fmt.Errorf("failed to claim scheduled task: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	if rowsAffected == 0 {
+		return false, nil
+	}
+
+	task.RunCount++
+	task.LastRunAt = &runAt
+	task.NextRunAt = next
+	return true, nil
+}
+
+// DeleteScheduledTask deletes a scheduled task and its run history
+func (p *PostgresDB) DeleteScheduledTask(ctx context.Context, id int64) error {
+	logging.DB.WithContext(ctx).WithFields(
+		"task_id", id,
+	).Info("Deleting scheduled task")
+
+	if _, err := p.db.ExecContext(ctx, "DELETE FROM task_runs WHERE task_id = $1", id); err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"task_id", id,
+			"error", err.Error(),
+		).Error("Failed to delete task runs")
+		return fmt.Errorf("failed to delete task runs: %w", err)
+	}
+
+	result, err := p.db.ExecContext(ctx, "DELETE FROM scheduled_tasks WHERE id = $1", id)
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"task_id", id,
+			"error", err.Error(),
+		).Error("Failed to delete scheduled task")
+		return fmt.Errorf("failed to delete scheduled task: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrTaskNotFound
+	}
+
+	logging.DB.WithContext(ctx).WithFields(
+		"task_id", id,
+	).Info("Scheduled task deleted successfully")
+	return nil
+}
+
+// deleteServerTasks deletes the scheduled tasks of a server and their run history
+func (p *PostgresDB) deleteServerTasks(ctx context.Context, serverName string) error {
+	_, err := p.db.ExecContext(ctx,
+		`DELETE FROM task_runs WHERE task_id IN (
+			SELECT t.id FROM scheduled_tasks t JOIN minecraft_servers s ON s.id = t.server_id WHERE s.server_name = $1
+		)`, serverName)
+	if err == nil {
+		_, err = p.db.ExecContext(ctx,
+			"DELETE FROM scheduled_tasks WHERE server_id IN (SELECT id FROM minecraft_servers WHERE server_name = $1)",
+			serverName)
+	}
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"server_name", serverName,
+			"error", err.Error(),
+		).Error("Failed to delete scheduled tasks of server")
+		return fmt.Errorf("failed to delete scheduled tasks: %w", err)
+	}
+	return nil
+}
+
+// Task run operations
+
+// CreateTaskRun records the start of a scheduled task run
+func (p *PostgresDB) CreateTaskRun(ctx context.Context, run *TaskRun) error {
+	id, err := p.insertReturningID(ctx,
+		"INSERT INTO task_runs (task_id, started_at, status, output) VALUES ($1, $2, $3, $4)",
+		run.TaskID, run.StartedAt, run.Status, run.Output,
+	)
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"task_id", run.TaskID,
+			"error", err.Error(),
+		).Error("Failed to create task run")
+		return fmt.Errorf("failed to create task run: %w", err)
+	}
+	run.ID = id
+	return nil
+}
+
+// FinishTaskRun records the outcome of a scheduled task run
+func (p *PostgresDB) FinishTaskRun(ctx context.Context, run *TaskRun) error {
+	_, err := p.db.ExecContext(ctx,
+		"UPDATE task_runs SET finished_at = $1, status = $2, output = $3 WHERE id = $4",
+		run.FinishedAt, run.Status, run.Output, run.ID,
+	)
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"task_run_id", run.ID,
+			"error", err.Error(),
+		).Error("Failed to finish task run")
+		return fmt.Errorf("failed to finish task run: %w", err)
+	}
+	return nil
+}
+
+// ListTaskRuns lists the latest runs of a scheduled task, newest first
+func (p *PostgresDB) ListTaskRuns(ctx context.Context, taskID int64, limit int) ([]*TaskRun, error) {
+	logging.DB.WithContext(ctx).WithFields(
+		"task_id", taskID,
+		"limit", limit,
+	).Debug("Listing task runs")
+
+	rows, err := p.db.QueryContext(ctx,
+		"SELECT "+taskRunColumns+" FROM task_runs WHERE task_id = $1 ORDER BY id DESC LIMIT $2",
+		taskID, limit)
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"task_id", taskID,
+			"error", err.Error(),
+		).Error("Failed to query task runs")
+		return nil, fmt.Errorf("failed to list task runs: %w", err)
+	}
+	defer rows.Close()
+
+	runs := []*TaskRun{}
+	for rows.Next() {
+		run, err := scanTaskRun(rows)
+		if err != nil {
+			logging.DB.WithContext(ctx).WithFields(
+				"error", err.Error(),
+			).Error("Failed to scan task run row")
+			return nil, fmt.Errorf("failed to scan task run row: %w", err)
+		}
+		runs = append(runs, run)
+	}
+
+	if err := rows.Err(); err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"error", err.Error(),
+		).Error("Error iterating task run rows")
+		return nil, fmt.Errorf("error iterating task run rows: %w", err)
+	}
+	return runs, nil
+}
+
+// PruneTaskRuns deletes the runs of a scheduled task but the latest ones
+func (p *PostgresDB) PruneTaskRuns(ctx context.Context, taskID int64, keep int) error {
+	_, err := p.db.ExecContext(ctx,
+		`DELETE FROM task_runs WHERE task_id = $1 AND id NOT IN (
+			SELECT id FROM task_runs WHERE task_id = $2 ORDER BY id DESC LIMIT $3
+		)`,
+		taskID, taskID, keep)
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"task_id", taskID,
+			"error", err.Error(),
+		).Error("Failed to prune task runs")
+		return fmt.Errorf("failed to prune task runs: %w", err)
+	}
+	return nil
+}
+
+// Job operations
+
+// CreateJob stores a new job
+func (p *PostgresDB) CreateJob(ctx context.Context, job *Job) error {
+	logging.DB.WithContext(ctx).WithFields(
+		"job_type", job.Type,
+		"server_name", job.ServerName,
+	).Debug("Creating job")
+
+	now := time.Now()
+	job.CreatedAt = now
+	job.UpdatedAt = now
+
+	id, err := p.insertReturningID(ctx,
+		`INSERT INTO jobs (type, server_name, owner_id, status, progress, message, error, created_by, created_at, started_at, finished_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`,
+		job.Type, job.ServerName, job.OwnerID, job.Status, job.Progress, job.Message, job.Error, job.CreatedBy, job.CreatedAt, job.StartedAt, job.FinishedAt, job.UpdatedAt,
+	)
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"job_type", job.Type,
+			"server_name", job.ServerName,
+			"error", err.Error(),
+		).Error("Failed to create job")
+		return fmt.Errorf("failed to create job: %w", err)
+	}
+	job.ID = id
+	return nil
+}
+
+// GetJob retrieves a job by ID
+func (p *PostgresDB) GetJob(ctx context.Context, id int64) (*Job, error) {
+	job, err := scanJob(p.db.QueryRowContext(ctx,
+		"SELECT "+jobColumns+" FROM jobs WHERE id = $1", id,
+	))
+	if err == sql.ErrNoRows {
+		return nil, ErrJobNotFound
+	}
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"job_id", id,
+			"error", err.Error(),
+		).Error("Failed to get job")
+		return nil, fmt.Errorf("failed to get job: %w", err)
+	}
+	return job, nil
+}
+
+// ListServerJobs lists the latest jobs of a server, newest first
+func (p *PostgresDB) ListServerJobs(ctx context.Context, serverName string, limit int) ([]*Job, error) {
+	rows, err := p.db.QueryContext(ctx,
+		"SELECT "+jobColumns+" FROM jobs WHERE server_name = $1 ORDER BY id DESC LIMIT $2",
+		serverName, limit)
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"server_name", serverName,
+			"error", err.Error(),
+		).Error("Failed to list jobs")
+		return nil, fmt.Errorf("failed to list jobs: %w", err)
+	}
+	defer rows.Close()
+
+	jobs := []*Job{}
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			logging.DB.WithContext(ctx).WithFields(
+				"error", err.Error(),
+			).Error("Failed to scan job row")
+			return nil, fmt.Errorf("failed to scan job row: %w", err)
+		}
+		jobs = append(jobs, job)
+	}
+
+	if err := rows.Err(); err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"error", err.Error(),
+		).Error("Error iterating job rows")
+		return nil, fmt.Errorf("error iterating job rows: %w", err)
+	}
+	return jobs, nil
+}
+
+// UpdateJob records the status and progress of a job
+func (p *PostgresDB) UpdateJob(ctx context.Context, job *Job) error {
+	job.UpdatedAt = time.Now()
+
+	result, err := p.db.ExecContext(ctx,
+		`UPDATE jobs SET status = $1, progress = $2, message = $3, error = $4, started_at = $5, finished_at = $6, updated_at = $7
+		WHERE id = $8`,
+		job.Status, job.Progress, job.Message, job.Error, job.StartedAt, job.FinishedAt, job.UpdatedAt, job.ID,
+	)
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"job_id", job.ID,
+			"error", err.Error(),
+		).Error("Failed to update job")
+		return fmt.Errorf("failed to update job: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrJobNotFound
+	}
+	return nil
+}
+
+// FailUnfinishedJobs records the pending and running jobs as failed for the given
+// reason, as when the API restarted while they ran. It returns the number of jobs failed
+func (p *PostgresDB) FailUnfinishedJobs(ctx context.Context, reason string) (int64, error) {
+	now := time.Now()
+	result, err := p.db.ExecContext(ctx,
+		`UPDATE jobs SET status = $1, error = $2, finished_at = $3, updated_at = $4
+		WHERE status IN ($5, $6)`,
+		JobFailed, reason, now, now, JobPending, JobRunning,
+	)
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"error", err.Error(),
+		).Error("Failed to fail unfinished jobs")
+		return 0, fmt.Errorf("failed to fail unfinished jobs: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+// Server plugin operations
+
+// SaveServerPlugin records a plugin installed on a server, replacing the version
+// previously recorded for its project
+func (p *PostgresDB) SaveServerPlugin(ctx context.Context, plugin *ServerPlugin) error {
+	logging.DB.WithContext(ctx).WithFields(
+		"server_id", plugin.ServerID,
+		"project_id", plugin.ProjectID,
+		"version_id", plugin.VersionID,
+	).Debug("Saving server plugin")
+
+	plugin.InstalledAt = time.Now()
+
+	_, err := p.db.ExecContext(ctx,
+		`INSERT INTO server_plugins (server_id, platform, project_id, project_slug, name, version_id, version, directory, file_name, sha512, installed_by, installed_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+		ON CONFLICT (server_id, project_id) DO UPDATE SET
+			platform = excluded.platform,
+			project_slug = excluded.project_slug,
+			name = excluded.name,
+			version_id = excluded.version_id,
+			version = excluded.version,
+			directory = excluded.directory,
+			file_name = excluded.file_name,
+			sha512 = excluded.sha512,
+			installed_by = excluded.installed_by,
+			installed_at = excluded.installed_at`,
+		plugin.ServerID, plugin.Platform, plugin.ProjectID, plugin.ProjectSlug, plugin.Name, plugin.VersionID, plugin.Version,
+		plugin.Directory, plugin.FileName, plugin.SHA512, plugin.InstalledBy, plugin.InstalledAt,
+	)
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"server_id", plugin.ServerID,
+			"project_id", plugin.ProjectID,
+			"error", err.Error(),
+		).Error("Failed to save server plugin")
+		return fmt.Errorf("failed to save server plugin: %w", err)
+	}
+
+	saved, err := p.GetServerPlugin(ctx, plugin.ServerID, plugin.ProjectID)
+	if err != nil {
+		return err
+	}
+	plugin.ID = saved.ID
+	return nil
+}
+
+// GetServerPlugin retrieves the plugin of a project installed on a server
+func (p *PostgresDB) GetServerPlugin(ctx context.Context, serverID int64, projectID string) (*ServerPlugin, error) {
+	plugin, err := scanServerPlugin(p.db.QueryRowContext(ctx,
+		"SELECT "+serverPluginColumns+" FROM server_plugins WHERE server_id = $1 AND project_id = $2", serverID, projectID,
+	))
+	if err == sql.ErrNoRows {
+		return nil, ErrPluginNotFound
+	}
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"server_id", serverID,
+			"project_id", projectID,
+			"error", err.Error(),
+		).Error("Failed to get server plugin")
+		return nil, fmt.Errorf("failed to get server plugin: %w", err)
+	}
+	return plugin, nil
+}
+
+// ListServerPlugins lists the plugins installed on a server, by name
+func (p *PostgresDB) ListServerPlugins(ctx context.Context, serverID int64) ([]*ServerPlugin, error) {
+	rows, err := p.db.QueryContext(ctx,
+		"SELECT "+serverPluginColumns+" FROM server_plugins WHERE server_id = $1 ORDER BY name, id", serverID)
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"server_id", serverID,
+			"error", err.Error(),
+		).Error("Failed to list server plugins")
+		return nil, fmt.Errorf("failed to list server plugins: %w", err)
+	}
+	defer rows.Close()
+
+	plugins := []*ServerPlugin{}
+	for rows.Next() {
+		plugin, err := scanServerPlugin(rows)
+		if err != nil {
+			logging.DB.WithContext(ctx).WithFields(
+				"error", err.Error(),
+			).Error("Failed to scan server plugin row")
+			return nil, fmt.Errorf("failed to scan server plugin row: %w", err)
+		}
+		plugins = append(plugins, plugin)
+	}
+
+	if err := rows.Err(); err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"error", err.Error(),
+		).Error("Error iterating server plugin rows")
+		return nil, fmt.Errorf("error iterating server plugin rows: %w", err)
+	}
+	return plugins, nil
+}
+
+// DeleteServerPlugin removes the plugin of a project from the plugins of a server
+func (p *PostgresDB) DeleteServerPlugin(ctx context.Context, serverID int64, projectID string) error {
+	result, err := p.db.ExecContext(ctx,
+		"DELETE FROM server_plugins WHERE server_id = $1 AND project_id = $2", serverID, projectID)
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"server_id", serverID,
+			"project_id", projectID,
+			"error", err.Error(),
+		).Error("Failed to delete server plugin")
+		return fmt.Errorf("failed to delete server plugin: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrPluginNotFound
+	}
+	return nil
+}
+
+// Nonce operations
+
+// CreateNonce records a one-time value until it expires. It returns ErrNonceUsed when
+// the value is already recorded for the purpose, and removes the expired values.
+func (p *PostgresDB) CreateNonce(ctx context.Context, purpose, value, data string, expiresAt time.Time) error {
+	now := time.Now()
+	if _, err := p.db.ExecContext(ctx, "DELETE FROM nonces WHERE expires_at <= $1", now); err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"error", err.Error(),
+		).Warn("Failed to delete expired nonces")
+	}
+
+	// The primary key settles concurrent uses across replicas
+	result, err := p.db.ExecContext(ctx,
+		`INSERT INTO nonces (purpose, value, data, expires_at, created_at) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (purpose, value) DO NOTHING`,
+		purpose, value, data, expiresAt, now,
+	)
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"purpose", purpose,
+			"error", err.Error(),
+		).Error("Failed to create nonce")
+		return fmt.Errorf("failed to create nonce: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrNonceUsed
+	}
+	return nil
+}
+
+// ConsumeNonce removes a value recorded with CreateNonce, so that it is used once, and
+// returns its data. It returns ErrNonceNotFound when the value is unknown, expired or
+// already consumed.
+func (p *PostgresDB) ConsumeNonce(ctx context.Context, purpose, value string) (string, error) {
+	var data string
+	err := p.db.QueryRowContext(ctx,
+		"DELETE FROM nonces WHERE purpose = $1 AND value = $2 AND expires_at > $3 RETURNING data", purpose, value, time.Now(),
+	).Scan(&data)
+	if err == sql.ErrNoRows {
+		return "", ErrNonceNotFound
+	}
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"purpose", purpose,
+			"error", err.Error(),
+		).Error("Failed to consume nonce")
+		return "", fmt.Errorf("failed to consume nonce: %w", err)
+	}
+	return data, nil
+}
+
+// NonceExists tells whether a value is recorded for the purpose and unexpired,
+// without consuming it.
+func (p *PostgresDB) NonceExists(ctx context.Context, purpose, value string) (bool, error) {
+	var exists bool
+	err := p.db.QueryRowContext(ctx,
+		"SELECT EXISTS(SELECT 1 FROM nonces WHERE purpose = $1 AND value = $2 AND expires_at > $3)", purpose, value, time.Now(),
+	).Scan(&exists)
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"purpose", purpose,
+			"error", err.Error(),
+		).Error("Failed to look up nonce")
+		return false, fmt.Errorf("failed to look up nonce: %w", err)
+	}
+	return exists, nil
+}
+
+// SetUserMinecraftAccount links a user to a Minecraft account, or unlinks it when uuid
+// is empty. It returns ErrMinecraftAccountLinked when another user has the account.
+func (p *PostgresDB) SetUserMinecraftAccount(ctx context.Context, userID int64, uuid, name string) error {
+	logging.DB.WithContext(ctx).WithFields(
+		"user_id", userID,
+		"minecraft_uuid", uuid,
+		"minecraft_name", name,
+	).Info("Setting user Minecraft account")
+
+	if uuid != "" {
+		var linked bool
+		err := p.db.QueryRowContext(ctx,
+			"SELECT EXISTS(SELECT 1 FROM users WHERE minecraft_uuid = $1 AND id <> $2)", uuid, userID,
+		).Scan(&linked)
+		if err != nil {
+			return fmt.Errorf("failed to check Minecraft account: %w", err)
+		}
+		if linked {
+			logging.DB.WithContext(ctx).WithFields(
+				"user_id", userID,
+				"minecraft_uuid", uuid,
+			).Warn("Cannot link Minecraft account: linked to another user")
+			return ErrMinecraftAccountLinked
+		}
+	}
+
+	result, err := p.db.ExecContext(ctx,
+		"UPDATE users SET minecraft_uuid = $1, minecraft_name = $2, updated_at = $3 WHERE id = $4",
+		uuid, name, time.Now(), userID,
+	)
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"user_id", userID,
+			"error", err.Error(),
+		).Error("Failed to set user Minecraft account")
+		return fmt.Errorf("failed to set Minecraft account: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// UpdateServerSpec replaces the creation spec recorded for a server
+func (p *PostgresDB) UpdateServerSpec(ctx context.Context, serverName string, spec ServerSpec) error {
+	logging.DB.WithContext(ctx).WithFields(
+		"server_name", serverName,
+	).Debug("Updating server spec")
+
+	result, err := p.db.ExecContext(ctx,
+		"UPDATE minecraft_servers SET spec = $1, updated_at = $2 WHERE server_name = $3", spec, time.Now(), serverName)
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"server_name", serverName,
+			"error", err.Error(),
+		).Error("Failed to update server spec")
+		return fmt.Errorf("failed to update server spec: %w", err)
+	}
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return fmt.Errorf("server not found: %s", serverName)
+	}
+	return nil
+}
+
+// Proxy operations
+
+// CreateProxy stores a new proxy
+func (p *PostgresDB) CreateProxy(ctx context.Context, proxy *Proxy) error {
+	logging.DB.WithContext(ctx).WithFields(
+		"proxy_name", proxy.Name,
+		"owner_id", proxy.OwnerID,
+	).Info("Creating proxy")
+
+	var exists bool
+	err := p.db.QueryRowContext(ctx,
+		"SELECT EXISTS(SELECT 1 FROM proxies WHERE name = $1)", proxy.Name,
+	).Scan(&exists)
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"proxy_name", proxy.Name,
+			"error", err.Error(),
+		).Error("Database error when checking if proxy exists")
+		return fmt.Errorf("failed to check proxy: %w", err)
+	}
+	if exists {
+		logging.DB.WithContext(ctx).WithFields(
+			"proxy_name", proxy.Name,
+		).Warn("Cannot create proxy: name already exists")
+		return ErrProxyExists
+	}
+
+	now := time.Now()
+	proxy.CreatedAt = now
+	proxy.UpdatedAt = now
+
+	id, err := p.insertReturningID(ctx,
+		`INSERT INTO proxies (name, owner_id, deployment_name, forwarding_mode, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6)`,
+		proxy.Name, proxy.OwnerID, proxy.DeploymentName, proxy.ForwardingMode, proxy.CreatedAt, proxy.UpdatedAt,
+	)
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"proxy_name", proxy.Name,
+			"error", err.Error(),
+		).Error("Failed to create proxy")
+		return fmt.Errorf("failed to create proxy: %w", err)
+	}
+	proxy.ID = id
+	return nil
+}
+
+// GetProxyByName retrieves a proxy by name
+func (p *PostgresDB) GetProxyByName(ctx context.Context, name string) (*Proxy, error) {
+	proxy, err := scanProxy(p.db.QueryRowContext(ctx,
+		"SELECT "+proxyColumns+" FROM proxies WHERE name = $1", name,
+	))
+	if err == sql.ErrNoRows {
+		return nil, ErrProxyNotFound
+	}
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"proxy_name", name,
+			"error", err.Error(),
+		).Error("Failed to get proxy")
+		return nil, fmt.Errorf("failed to get proxy: %w", err)
+	}
+	return proxy, nil
+}
+
+// ListProxies lists all proxies, by name
+func (p *PostgresDB) ListProxies(ctx context.Context) ([]*Proxy, error) {
+	rows, err := p.db.QueryContext(ctx, "SELECT "+proxyColumns+" FROM proxies ORDER BY name")
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"error", err.Error(),
+		).Error("Failed to list proxies")
+		return nil, fmt.Errorf("failed to list proxies: %w", err)
+	}
+	defer rows.Close()
+
+	proxies := []*Proxy{}
+	for rows.Next() {
+		proxy, err := scanProxy(rows)
+		if err != nil {
+			logging.DB.WithContext(ctx).WithFields(
+				"error", err.Error(),
+			).Error("Failed to scan proxy row")
+			return nil, fmt.Errorf("failed to scan proxy row: %w", err)
+		}
+		proxies = append(proxies, proxy)
+	}
+
+	if err := rows.Err(); err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"error", err.Error(),
+		).Error("Error iterating proxy rows")
+		return nil, fmt.Errorf("error iterating proxy rows: %w", err)
+	}
+	return proxies, nil
+}
+
+// DeleteProxy deletes a proxy and its member list
+func (p *PostgresDB) DeleteProxy(ctx context.Context, id int64) error {
+	logging.DB.WithContext(ctx).WithFields(
+		"proxy_id", id,
+	).Info("Deleting proxy")
+
+	// Members are removed explicitly, foreign keys may not be enforced
+	if _, err := p.db.ExecContext(ctx, "DELETE FROM proxy_servers WHERE proxy_id = $1", id); err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"proxy_id", id,
+			"error", err.Error(),
+		).Error("Failed to delete proxy servers")
+		return fmt.Errorf("failed to delete proxy servers: %w", err)
+	}
+
+	result, err := p.db.ExecContext(ctx, "DELETE FROM proxies WHERE id = $1", id)
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"proxy_id", id,
+			"error", err.Error(),
+		).Error("Failed to delete proxy")
+		return fmt.Errorf("failed to delete proxy: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrProxyNotFound
+	}
+	return nil
+}
+
+// SaveProxyServer registers a server behind a proxy, or updates its lobby flag when
+// it is registered already
+func (p *PostgresDB) SaveProxyServer(ctx context.Context, member *ProxyServer) error {
+	logging.DB.WithContext(ctx).WithFields(
+		"proxy_id", member.ProxyID,
+		"server_id", member.ServerID,
+		"lobby", member.Lobby,
+	).Debug("Saving proxy server")
+
+	member.AddedAt = time.Now()
+	_, err := p.db.ExecContext(ctx,
+		`INSERT INTO proxy_servers (proxy_id, server_id, lobby, added_by, added_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (proxy_id, server_id) DO UPDATE SET lobby = excluded.lobby`,
+		member.ProxyID, member.ServerID, member.Lobby, member.AddedBy, member.AddedAt,
+	)
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"proxy_id", member.ProxyID,
+			"server_id", member.ServerID,
+			"error", err.Error(),
+		).Error("Failed to save proxy server")
+		return fmt.Errorf("failed to save proxy server: %w", err)
+	}
+	return nil
+}
+
+// ListProxyServers lists the servers registered behind a proxy, in the order they
+// were added
+func (p *PostgresDB) ListProxyServers(ctx context.Context, proxyID int64) ([]*ProxyServer, error) {
+	rows, err := p.db.QueryContext(ctx,
+		`SELECT m.proxy_id, m.server_id, s.server_name, s.deployment_name, s.namespace, m.lobby, m.added_by, m.added_at
+		FROM proxy_servers m JOIN minecraft_servers s ON s.id = m.server_id
+		WHERE m.proxy_id = $1 ORDER BY m.id`, proxyID)
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"proxy_id", proxyID,
+			"error", err.Error(),
+		).Error("Failed to list proxy servers")
+		return nil, fmt.Errorf("failed to list proxy servers: %w", err)
+	}
+	defer rows.Close()
+
+	members := []*ProxyServer{}
+	for rows.Next() {
+		var member ProxyServer
+		if err := rows.Scan(&member.ProxyID, &member.ServerID, &member.ServerName, &member.DeploymentName,
+			&member.Namespace, &member.Lobby, &member.AddedBy, &member.AddedAt); err != nil {
+			logging.DB.WithContext(ctx).WithFields(
+				"error", err.Error(),
+			).Error("Failed to scan proxy server row")
+			return nil, fmt.Errorf("failed to scan proxy server row: %w", err)
+		}
+		members = append(members, &member)
+	}
+
+	if err := rows.Err(); err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"error", err.Error(),
+		).Error("Error iterating proxy server rows")
+		return nil, fmt.Errorf("error iterating proxy server rows: %w", err)
+	}
+	return members, nil
+}
+
+// DeleteProxyServer unregisters a server from a proxy
+func (p *PostgresDB) DeleteProxyServer(ctx context.Context, proxyID, serverID int64) error {
+	result, err := p.db.ExecContext(ctx,
+		"DELETE FROM proxy_servers WHERE proxy_id = $1 AND server_id = $2", proxyID, serverID)
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"proxy_id", proxyID,
+			"server_id", serverID,
+			"error", err.Error(),
+		).Error("Failed to delete proxy server")
+		return fmt.Errorf("failed to delete proxy server: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrProxyServerNotFound
+	}
+	return nil
+}
+
+// Audit log operations
+
+// CreateAuditEvent appends an event to the audit log
+func (p *PostgresDB) CreateAuditEvent(ctx context.Context, event *AuditEvent) error {
+	event.CreatedAt = time.Now()
+
+	id, err := p.insertReturningID(ctx,
+		`INSERT INTO audit_events (user_id, username, action, path, target_server, target_user_id, summary,
+			status, result, remote_ip, request_id, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`,
+		event.UserID, event.Username, event.Action, event.Path, event.TargetServer, event.TargetUserID, event.Summary,
+		event.Status, event.Result, event.RemoteIP, event.RequestID, event.CreatedAt,
+	)
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"action", event.Action,
+			"request_id", event.RequestID,
+			"error", err.Error(),
+		).Error("Failed to record audit event")
+		return fmt.Errorf("failed to record audit event: %w", err)
+	}
+	event.ID = id
+	return nil
+}
+
+// ListAuditEvents lists the audit events matching a filter, newest first
+func (p *PostgresDB) ListAuditEvents(ctx context.Context, filter AuditFilter) ([]*AuditEvent, error) {
+	until := filter.Until
+	if until.IsZero() {
+		until = time.Now().Add(time.Hour)
+	}
+
+	rows, err := p.db.QueryContext(ctx,
+		`SELECT `+auditEventColumns+` FROM audit_events
+		WHERE ($1 = 0 OR user_id = $2)
+			AND ($3 = '' OR action = $4)
+			AND ($5 = '' OR target_server = $6)
+			AND ($7 = 0 OR target_user_id = $8)
+			AND ($9 = '' OR result = $10)
+			AND ($11 = 0 OR id < $12)
+			AND created_at >= $13 AND created_at < $14
+		ORDER BY id DESC LIMIT $15`,
+		filter.UserID, filter.UserID,
+		filter.Action, filter.Action,
+		filter.TargetServer, filter.TargetServer,
+		filter.TargetUserID, filter.TargetUserID,
+		filter.Result, filter.Result,
+		filter.BeforeID, filter.BeforeID,
+		filter.Since, until,
+		filter.Limit,
+	)
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"error", err.Error(),
+		).Error("Failed to list audit events")
+		return nil, fmt.Errorf("failed to list audit events: %w", err)
+	}
+	defer rows.Close()
+
+	events := []*AuditEvent{}
+	for rows.Next() {
+		event, err := scanAuditEvent(rows)
+		if err != nil {
+			logging.DB.WithContext(ctx).WithFields(
+				"error", err.Error(),
+			).Error("Failed to scan audit event row")
+			return nil, fmt.Errorf("failed to scan audit event row: %w", err)
+		}
+		events = append(events, event)
+	}
+
+	if err := rows.Err(); err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"error", err.Error(),
+		).Error("Error iterating audit event rows")
+		return nil, fmt.Errorf("error iterating audit event rows: %w", err)
+	}
+	return events, nil
+}
+
+// Incident operations
+
+// CreateIncident records a crash of a server container
+func (p *PostgresDB) CreateIncident(ctx context.Context, incident *Incident) error {
+	incident.CreatedAt = time.Now()
+
+	id, err := p.insertReturningID(ctx,
+		`INSERT INTO incidents (server_id, server_name, kind, reason, exit_code, restart_count, pod_name, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		incident.ServerID, incident.ServerName, incident.Kind, incident.Reason, incident.ExitCode,
+		incident.RestartCount, incident.PodName, incident.CreatedAt,
+	)
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"server_name", incident.ServerName,
+			"kind", incident.Kind,
+			"error", err.Error(),
+		).Error("Failed to record incident")
+		return fmt.Errorf("failed to record incident: %w", err)
+	}
+	incident.ID = id
+	return nil
+}
+
+// ListServerIncidents lists the incidents of a server older than beforeID (any when 0), newest first
+func (p *PostgresDB) ListServerIncidents(ctx context.Context, serverID, beforeID int64, limit int) ([]*Incident, error) {
+	rows, err := p.db.QueryContext(ctx,
+		`SELECT `+incidentColumns+` FROM incidents
+		WHERE server_id = $1 AND ($2 = 0 OR id < $3)
+		ORDER BY id DESC LIMIT $4`,
+		serverID, beforeID, beforeID, limit,
+	)
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"server_id", serverID,
+			"error", err.Error(),
+		).Error("Failed to list incidents")
+		return nil, fmt.Errorf("failed to list incidents: %w", err)
+	}
+	defer rows.Close()
+
+	incidents := []*Incident{}
+	for rows.Next() {
+		incident, err := scanIncident(rows)
+		if err != nil {
+			logging.DB.WithContext(ctx).WithFields(
+				"error", err.Error(),
+			).Error("Failed to scan incident row")
+			return nil, fmt.Errorf("failed to scan incident row: %w", err)
+		}
+		incidents = append(incidents, incident)
+	}
+
+	if err := rows.Err(); err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"error", err.Error(),
+		).Error("Error iterating incident rows")
+		return nil, fmt.Errorf("error iterating incident rows: %w", err)
+	}
+	return incidents, nil
+}
+
+// deleteServerIncidents deletes the incidents of a server
+func (p *PostgresDB) deleteServerIncidents(ctx context.Context, serverName string) error {
+	_, err := p.db.ExecContext(ctx,
+		"DELETE FROM incidents WHERE server_id IN (SELECT id FROM minecraft_servers WHERE server_name = $1)",
+		serverName)
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"server_name", serverName,
+			"error", err.Error(),
+		).Error("Failed to delete incidents of server")
+		return fmt.Errorf("failed to delete incidents: %w", err)
+	}
+	return nil
+}
+
+// OAuth identity operations
+
+// CreateOAuthIdentity links a user to their account at an OAuth provider. It returns
+// ErrOAuthIdentityLinked when the account is linked to a user already, or when the
+// user has another account of the provider linked.
+func (p *PostgresDB) CreateOAuthIdentity(ctx context.Context, identity *OAuthIdentity) error {
+	logging.DB.WithContext(ctx).WithFields(
+		"user_id", identity.UserID,
+		"provider", identity.Provider,
+	).Info("Linking OAuth identity")
+
+	var linked bool
+	err := p.db.QueryRowContext(ctx,
+		"SELECT EXISTS(SELECT 1 FROM oauth_identities WHERE (provider = $1 AND subject = $2) OR (provider = $3 AND user_id = $4))",
+		identity.Provider, identity.Subject, identity.Provider, identity.UserID,
+	).Scan(&linked)
+	if err != nil {
+		return fmt.Errorf("failed to check OAuth identity: %w", err)
+	}
+	if linked {
+		logging.DB.WithContext(ctx).WithFields(
+			"user_id", identity.UserID,
+			"provider", identity.Provider,
+		).Warn("Cannot link OAuth identity: already linked")
+		return ErrOAuthIdentityLinked
+	}
+
+	identity.CreatedAt = time.Now()
+	id, err := p.insertReturningID(ctx,
+		`INSERT INTO oauth_identities (user_id, provider, subject, email, created_at)
+		VALUES ($1, $2, $3, $4, $5)`,
+		identity.UserID, identity.Provider, identity.Subject, identity.Email, identity.CreatedAt,
+	)
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"user_id", identity.UserID,
+			"provider", identity.Provider,
+			"error", err.Error(),
+		).Error("Failed to link OAuth identity")
+		return fmt.Errorf("failed to link OAuth identity: %w", err)
+	}
+	identity.ID = id
+	return nil
+}
+
+// GetOAuthIdentity returns the identity of an account at an OAuth provider
+func (p *PostgresDB) GetOAuthIdentity(ctx context.Context, provider, subject string) (*OAuthIdentity, error) {
+	identity, err := scanOAuthIdentity(p.db.QueryRowContext(ctx,
+		`SELECT `+oauthIdentityColumns+` FROM oauth_identities WHERE provider = $1 AND subject = $2`,
+		provider, subject,
+	))
+	if err == sql.ErrNoRows {
+		return nil, ErrOAuthIdentityNotFound
+	}
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"provider", provider,
+			"error", err.Error(),
+		).Error("Failed to get OAuth identity")
+		return nil, fmt.Errorf("failed to get OAuth identity: %w", err)
+	}
+	return identity, nil
+}
+
+// ListUserOAuthIdentities lists the OAuth identities linked to a user
+func (p *PostgresDB) ListUserOAuthIdentities(ctx context.Context, userID int64) ([]*OAuthIdentity, error) {
+	rows, err := p.db.QueryContext(ctx,
+		`SELECT `+oauthIdentityColumns+` FROM oauth_identities WHERE user_id = $1 ORDER BY provider`,
+		userID,
+	)
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"user_id", userID,
+			"error", err.Error(),
+		).Error("Failed to list OAuth identities")
+		return nil, fmt.Errorf("failed to list OAuth identities: %w", err)
+	}
+	defer rows.Close()
+
+	identities := []*OAuthIdentity{}
+	for rows.Next() {
+		identity, err := scanOAuthIdentity(rows)
+		if err != nil {
+			logging.DB.WithContext(ctx).WithFields(
+				"error", err.Error(),
+			).Error("Failed to scan OAuth identity row")
+			return nil, fmt.Errorf("failed to scan OAuth identity row: %w", err)
+		}
+		identities = append(identities, identity)
+	}
+
+	if err := rows.Err(); err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"error", err.Error(),
+		).Error("Error iterating OAuth identity rows")
+		return nil, fmt.Errorf("error iterating OAuth identity rows: %w", err)
+	}
+	return identities, nil
+}
+
+// DeleteOAuthIdentity unlinks the account of a user at an OAuth provider
+func (p *PostgresDB) DeleteOAuthIdentity(ctx context.Context, userID int64, provider string) error {
+	logging.DB.WithContext(ctx).WithFields(
+		"user_id", userID,
+		"provider", provider,
+	).Info("Unlinking OAuth identity")
+
+	result, err := p.db.ExecContext(ctx,
+		"DELETE FROM oauth_identities WHERE user_id = $1 AND provider = $2", userID, provider)
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"user_id", userID,
+			"provider", provider,
+			"error", err.Error(),
+		).Error("Failed to unlink OAuth identity")
+		return fmt.Errorf("failed to unlink OAuth identity: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrOAuthIdentityNotFound
+	}
+	return nil
+}
+
+// Role operations
+
+// CreateRole creates a role. It returns ErrRoleExists when another role has its name.
+func (p *PostgresDB) CreateRole(ctx context.Context, role *Role) error {
+	logging.DB.WithContext(ctx).WithFields(
+		"role_name", role.Name,
+		"permissions", role.Permissions,
+	).Info("Creating role")
+
+	if err := p.checkRoleName(ctx, role); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	role.CreatedAt = now
+	role.UpdatedAt = now
+
+	id, err := p.insertReturningID(ctx,
+		`INSERT INTO roles (name, description, permissions, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5)`,
+		role.Name, role.Description, role.Permissions, role.CreatedAt, role.UpdatedAt,
+	)
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"role_name", role.Name,
+			"error", err.Error(),
+		).Error("Failed to create role")
+		return fmt.Errorf("failed to create role: %w", err)
+	}
+	role.ID = id
+	return nil
+}
+
+// checkRoleName returns ErrRoleExists when a role other than the given one has its name
+func (p *PostgresDB) checkRoleName(ctx context.Context, role *Role) error {
+	var exists bool
+	err := p.db.QueryRowContext(ctx,
+		"SELECT EXISTS(SELECT 1 FROM roles WHERE name = $1 AND id <> $2)", role.Name, role.ID,
+	).Scan(&exists)
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"role_name", role.Name,
+			"error", err.Error(),
+		).Error("Database error when checking if role exists")
+		return fmt.Errorf("failed to check role: %w", err)
+	}
+	if exists {
+		logging.DB.WithContext(ctx).WithFields(
+			"role_name", role.Name,
+		).Warn("Cannot save role: name already exists")
+		return ErrRoleExists
+	}
+	return nil
+}
+
+// GetRole retrieves a role by ID
+func (p *PostgresDB) GetRole(ctx context.Context, id int64) (*Role, error) {
+	role, err := scanRole(p.db.QueryRowContext(ctx,
+		"SELECT "+roleColumns+" FROM roles WHERE id = $1", id,
+	))
+	if err == sql.ErrNoRows {
+		return nil, ErrRoleNotFound
+	}
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"role_id", id,
+			"error", err.Error(),
+		).Error("Failed to get role")
+		return nil, fmt.Errorf("failed to get role: %w", err)
+	}
+	return role, nil
+}
+
+// ListRoles lists the roles by name
+func (p *PostgresDB) ListRoles(ctx context.Context) ([]*Role, error) {
+	rows, err := p.db.QueryContext(ctx, "SELECT "+roleColumns+" FROM roles ORDER BY name")
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"error", err.Error(),
+		).Error("Failed to list roles")
+		return nil, fmt.Errorf("failed to list roles: %w", err)
+	}
+	defer rows.Close()
+
+	roles := []*Role{}
+	for rows.Next() {
+		role, err := scanRole(rows)
+		if err != nil {
+			logging.DB.WithContext(ctx).WithFields(
 				"error", err.Error(),
-			).Error("Failed to scan user row")
-			return nil, err
+			).Error("Failed to scan role row")
+			return nil, fmt.Errorf("failed to scan role row: %w", err)
 		}
-		users = append(users, user)
+		roles = append(roles, role)
 	}
 
-	if err = rows.Err(); err != nil {
-		logging.DB.WithFields(
+	if err := rows.Err(); err != nil {
+		logging.DB.WithContext(ctx).WithFields(
 			"error", err.Error(),
-		).Error("Error during user rows iteration")
-		return nil, err
+		).Error("Error iterating role rows")
+		return nil, fmt.Errorf("error iterating role rows: %w", err)
 	}
+	return roles, nil
+}
 
-	logging.DB.WithFields(
-		"count", len(users),
-	).Debug("Successfully retrieved user list from PostgreSQL")
-	return users, nil
+// UpdateRole updates the name, description and permissions of a role. It returns
+// ErrRoleExists when another role has its new name.
+func (p *PostgresDB) UpdateRole(ctx context.Context, role *Role) error {
+	logging.DB.WithContext(ctx).WithFields(
+		"role_id", role.ID,
+		"role_name", role.Name,
+		"permissions", role.Permissions,
+	).Info("Updating role")
+
+	if err := p.checkRoleName(ctx, role); err != nil {
+		return err
+	}
+
+	role.UpdatedAt = time.Now()
+	result, err := p.db.ExecContext(ctx,
+		"UPDATE roles SET name = $1, description = $2, permissions = $3, updated_at = $4 WHERE id = $5",
+		role.Name, role.Description, role.Permissions, role.UpdatedAt, role.ID,
+	)
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"role_id", role.ID,
+			"error", err.Error(),
+		).Error("Failed to update role")
+		return fmt.Errorf("failed to update role: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrRoleNotFound
+	}
+	return nil
 }
 
-// API Key operations
+// DeleteRole deletes a role, unassigning it from its users
+func (p *PostgresDB) DeleteRole(ctx context.Context, id int64) error {
+	logging.DB.WithContext(ctx).WithFields(
+		"role_id", id,
+	).Info("Deleting role")
 
-// CreateAPIKey creates a new API key
-func (p *PostgresDB) CreateAPIKey(ctx context.Context, key *APIKey) error {
-	logging.DB.WithFields(
-		"user_id", key.UserID,
-		"description", key.Description,
-	).Info("Creating new API key in PostgreSQL")
+	if _, err := p.db.ExecContext(ctx, "DELETE FROM user_roles WHERE role_id = $1", id); err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"role_id", id,
+			"error", err.Error(),
+		).Error("Failed to unassign role")
+		return fmt.Errorf("failed to unassign role: %w", err)
+	}
 
-	now := time.Now()
-	key.CreatedAt = now
+	result, err := p.db.ExecContext(ctx, "DELETE FROM roles WHERE id = $1", id)
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"role_id", id,
+			"error", err.Error(),
+		).Error("Failed to delete role")
+		return fmt.Errorf("failed to delete role: %w", err)
+	}
 
-	err := p.db.QueryRowContext(ctx,
-		"INSERT INTO api_keys (user_id, key, description, expires_at, created_at) VALUES ($1, $2, $3, $4, $5) RETURNING id",
-		key.UserID, key.Key, key.Description, key.ExpiresAt, key.CreatedAt,
-	).Scan(&key.ID)
+	rowsAffected, err := result.RowsAffected()
 	if err != nil {
-		logging.DB.WithFields(
-			"user_id", key.UserID,
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrRoleNotFound
+	}
+	return nil
+}
+
+// AssignUserRole assigns a role to a user, doing nothing when it is assigned already
+func (p *PostgresDB) AssignUserRole(ctx context.Context, userID, roleID int64) error {
+	logging.DB.WithContext(ctx).WithFields(
+		"user_id", userID,
+		"role_id", roleID,
+	).Info("Assigning role to user")
+
+	_, err := p.db.ExecContext(ctx,
+		`INSERT INTO user_roles (user_id, role_id, created_at)
+		VALUES ($1, $2, $3) ON CONFLICT (user_id, role_id) DO NOTHING`,
+		userID, roleID, time.Now(),
+	)
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"user_id", userID,
+			"role_id", roleID,
 			"error", err.Error(),
-		).Error("Failed to insert API key")
-		return err
+		).Error("Failed to assign role to user")
+		return fmt.Errorf("failed to assign role: %w", err)
 	}
+	return nil
+}
 
-	logging.DB.WithFields(
-		"user_id", key.UserID,
-		"key_id", key.ID,
-	).Info("API key created successfully in PostgreSQL")
+// UnassignUserRole removes a role from a user
+func (p *PostgresDB) UnassignUserRole(ctx context.Context, userID, roleID int64) error {
+	logging.DB.WithContext(ctx).WithFields(
+		"user_id", userID,
+		"role_id", roleID,
+	).Info("Unassigning role from user")
+
+	result, err := p.db.ExecContext(ctx,
+		"DELETE FROM user_roles WHERE user_id = $1 AND role_id = $2", userID, roleID)
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"user_id", userID,
+			"role_id", roleID,
+			"error", err.Error(),
+		).Error("Failed to unassign role from user")
+		return fmt.Errorf("failed to unassign role: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrRoleNotAssigned
+	}
 	return nil
 }
 
-// GetAPIKey retrieves an API key by the key string
-func (p *PostgresDB) GetAPIKey(ctx context.Context, keyStr string) (*APIKey, error) {
-	// Mask the full key in logs for security
-	maskedKey := keyStr
-	if len(keyStr) > 8 {
-		maskedKey = keyStr[:4] + "..." + keyStr[len(keyStr)-4:]
+// withRoles reads the roles of users, along with the permissions they grant. The
+// roles of every user are read when more than one is given.
+func (p *PostgresDB) withRoles(ctx context.Context, users ...*User) error {
+	byID := make(map[int64]*User, len(users))
+	for _, user := range users {
+		user.Roles = []string{}
+		user.SetDirectPermissions(user.DirectPermissions)
+		byID[user.ID] = user
+	}
+	if len(users) == 0 {
+		return nil
 	}
 
-	logging.DB.WithFields(
-		"key", maskedKey,
-	).Debug("Looking up API key in PostgreSQL")
+	query := `SELECT user_roles.user_id, roles.name, roles.permissions FROM user_roles
+		JOIN roles ON roles.id = user_roles.role_id`
+	args := []interface{}{}
+	if len(users) == 1 {
+		query += " WHERE user_roles.user_id = $1"
+		args = append(args, users[0].ID)
+	}
+	rows, err := p.db.QueryContext(ctx, query+" ORDER BY roles.name", args...)
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"error", err.Error(),
+		).Error("Failed to read user roles")
+		return fmt.Errorf("failed to read user roles: %w", err)
+	}
+	defer rows.Close()
 
-	key := &APIKey{}
+	for rows.Next() {
+		var userID, permissions int64
+		var name string
+		if err := rows.Scan(&userID, &name, &permissions); err != nil {
+			return fmt.Errorf("failed to scan user role row: %w", err)
+		}
+		if user := byID[userID]; user != nil {
+			user.Roles = append(user.Roles, name)
+			user.rolePermissions |= permissions
+			user.SetDirectPermissions(user.DirectPermissions)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating user role rows: %w", err)
+	}
+	return nil
+}
+
+// Server member operations
+
+// SaveServerMember grants a user permissions on a server, replacing those granted before
+func (p *PostgresDB) SaveServerMember(ctx context.Context, member *ServerMember) error {
+	logging.DB.WithContext(ctx).WithFields(
+		"server_id", member.ServerID,
+		"user_id", member.UserID,
+		"permissions", member.Permissions,
+	).Info("Saving server member")
+
+	now := dbClock.Now()
+	member.UpdatedAt = now
 	err := p.db.QueryRowContext(ctx,
-		"SELECT id, user_id, key, description, last_used, expires_at, created_at FROM api_keys WHERE key = $1",
-		keyStr,
-	).Scan(
-		&key.ID, &key.UserID, &key.Key, &key.Description, &key.LastUsed, &key.ExpiresAt, &key.CreatedAt,
-	)
+		`INSERT INTO server_members (server_id, user_id, permissions, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (server_id, user_id) DO UPDATE SET
+			permissions = excluded.permissions,
+			updated_at = excluded.updated_at
+		RETURNING created_at`,
+		member.ServerID, member.UserID, member.Permissions, now, now,
+	).Scan(&member.CreatedAt)
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"server_id", member.ServerID,
+			"user_id", member.UserID,
+			"error", err.Error(),
+		).Error("Failed to save server member")
+		return fmt.Errorf("failed to save server member: %w", err)
+	}
+	return nil
+}
+
+// GetServerMember retrieves the membership of a user in a server
+func (p *PostgresDB) GetServerMember(ctx context.Context, serverID, userID int64) (*ServerMember, error) {
+	member, err := scanServerMember(p.db.QueryRowContext(ctx,
+		`SELECT `+serverMemberColumns+` FROM server_members
+		JOIN users ON users.id = server_members.user_id
+		WHERE server_members.server_id = $1 AND server_members.user_id = $2`, serverID, userID,
+	))
 	if err == sql.ErrNoRows {
-		logging.DB.WithFields(
-			"key", maskedKey,
-			"error", "invalid_api_key",
-		).Warn("API key not found")
-		return nil, ErrInvalidAPIKey
+		return nil, ErrServerMemberNotFound
 	}
 	if err != nil {
-		logging.DB.WithFields(
-			"key", maskedKey,
+		logging.DB.WithContext(ctx).WithFields(
+			"server_id", serverID,
+			"user_id", userID,
 			"error", err.Error(),
-		).Error("Database error when retrieving API key")
-		return nil, err
+		).Error("Failed to get server member")
+		return nil, fmt.Errorf("failed to get server member: %w", err)
 	}
+	return member, nil
+}
 
-	// Update last used time
-	now := time.Now()
-	key.LastUsed = now
-	_, err = p.db.ExecContext(ctx, "UPDATE api_keys SET last_used = $1 WHERE id = $2", now, key.ID)
+// ListServerMembers lists the members of a server by username
+func (p *PostgresDB) ListServerMembers(ctx context.Context, serverID int64) ([]*ServerMember, error) {
+	rows, err := p.db.QueryContext(ctx,
+		`SELECT `+serverMemberColumns+` FROM server_members
+		JOIN users ON users.id = server_members.user_id
+		WHERE server_members.server_id = $1 ORDER BY users.username`, serverID)
 	if err != nil {
-		logging.DB.WithFields(
-			"key_id", key.ID,
+		logging.DB.WithContext(ctx).WithFields(
+			"server_id", serverID,
 			"error", err.Error(),
-		).Error("Failed to update API key last used time")
-		return nil, err
+		).Error("Failed to list server members")
+		return nil, fmt.Errorf("failed to list server members: %w", err)
 	}
+	defer rows.Close()
 
-	// Check if the key has expired
-	if !key.ExpiresAt.IsZero() && key.ExpiresAt.Before(now) {
-		logging.DB.WithFields(
-			"key_id", key.ID,
-			"user_id", key.UserID,
-			"expired_at", key.ExpiresAt,
-		).Warn("Attempted to use expired API key")
-		return nil, ErrInvalidAPIKey
+	members := []*ServerMember{}
+	for rows.Next() {
+		member, err := scanServerMember(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan server member row: %w", err)
+		}
+		members = append(members, member)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating server member rows: %w", err)
+	}
+	return members, nil
+}
 
-	logging.DB.WithFields(
-		"key_id", key.ID,
-		"user_id", key.UserID,
-	).Debug("API key found and last used time updated")
-	return key, nil
+// ListUserServerMemberships returns the permissions a user was granted on servers
+// as a member, of the server or of the organization owning it, by server name
+func (p *PostgresDB) ListUserServerMemberships(ctx context.Context, userID int64) (map[string]int64, error) {
+	rows, err := p.db.QueryContext(ctx,
+		`SELECT minecraft_servers.server_name, server_members.permissions FROM server_members
+		JOIN minecraft_servers ON minecraft_servers.id = server_members.server_id
+		WHERE server_members.user_id = $1`, userID)
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"user_id", userID,
+			"error", err.Error(),
+		).Error("Failed to list server memberships of user")
+		return nil, fmt.Errorf("failed to list server memberships: %w", err)
+	}
+	defer rows.Close()
+
+	memberships := map[string]int64{}
+	for rows.Next() {
+		var serverName string
+		var permissions int64
+		if err := rows.Scan(&serverName, &permissions); err != nil {
+			return nil, fmt.Errorf("failed to scan server membership row: %w", err)
+		}
+		memberships[serverName] = permissions
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating server membership rows: %w", err)
+	}
+
+	orgRows, err := p.db.QueryContext(ctx,
+		`SELECT minecraft_servers.server_name, organization_members.role FROM organization_members
+		JOIN minecraft_servers ON minecraft_servers.org_id = organization_members.org_id
+		WHERE organization_members.user_id = $1`, userID)
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"user_id", userID,
+			"error", err.Error(),
+		).Error("Failed to list organization servers of user")
+		return nil, fmt.Errorf("failed to list organization servers: %w", err)
+	}
+	defer orgRows.Close()
+
+	for orgRows.Next() {
+		var serverName, role string
+		if err := orgRows.Scan(&serverName, &role); err != nil {
+			return nil, fmt.Errorf("failed to scan organization server row: %w", err)
+		}
+		memberships[serverName] |= OrgRolePermissions[role]
+	}
+	if err := orgRows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating organization server rows: %w", err)
+	}
+	return memberships, nil
 }
 
-// DeleteAPIKey deletes an API key by ID
-func (p *PostgresDB) DeleteAPIKey(ctx context.Context, id int64) error {
-	logging.DB.WithFields(
-		"key_id", id,
-	).Info("Deleting API key from PostgreSQL")
+// DeleteServerMember removes a user from the members of a server
+func (p *PostgresDB) DeleteServerMember(ctx context.Context, serverID, userID int64) error {
+	logging.DB.WithContext(ctx).WithFields(
+		"server_id", serverID,
+		"user_id", userID,
+	).Info("Deleting server member")
 
-	_, err := p.db.ExecContext(ctx, "DELETE FROM api_keys WHERE id = $1", id)
+	result, err := p.db.ExecContext(ctx,
+		"DELETE FROM server_members WHERE server_id = $1 AND user_id = $2", serverID, userID)
 	if err != nil {
-		logging.DB.WithFields(
-			"key_id", id,
+		logging.DB.WithContext(ctx).WithFields(
+			"server_id", serverID,
+			"user_id", userID,
 			"error", err.Error(),
-		).Error("Failed to delete API key")
+		).Error("Failed to delete server member")
+		return fmt.Errorf("failed to delete server member: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrServerMemberNotFound
+	}
+	return nil
+}
+
+// Organization operations
+
+// CreateOrganization creates an organization owned by a user. It returns
+// ErrOrganizationExists when another organization has its name.
+func (p *PostgresDB) CreateOrganization(ctx context.Context, org *Organization, ownerID int64) error {
+	logging.DB.WithContext(ctx).WithFields(
+		"org_name", org.Name,
+		"owner_id", ownerID,
+	).Info("Creating organization")
+
+	if err := p.checkOrganizationName(ctx, org); err != nil {
 		return err
 	}
 
-	logging.DB.WithFields(
-		"key_id", id,
-	).Info("API key deleted successfully")
+	now := time.Now()
+	org.CreatedAt = now
+	org.UpdatedAt = now
+
+	return p.db.inTx(ctx, nil, func(tx *tracedDB) error {
+		err := tx.QueryRowContext(ctx,
+			`INSERT INTO organizations (name, description, created_at, updated_at)
+			VALUES ($1, $2, $3, $4) RETURNING id`,
+			org.Name, org.Description, org.CreatedAt, org.UpdatedAt,
+		).Scan(&org.ID)
+		if err != nil {
+			logging.DB.WithContext(ctx).WithFields(
+				"org_name", org.Name,
+				"error", err.Error(),
+			).Error("Failed to create organization")
+			return fmt.Errorf("failed to create organization: %w", err)
+		}
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO organization_members (org_id, user_id, role, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5)`,
+			org.ID, ownerID, OrgRoleOwner, now, now,
+		); err != nil {
+			logging.DB.WithContext(ctx).WithFields(
+				"org_name", org.Name,
+				"owner_id", ownerID,
+				"error", err.Error(),
+			).Error("Failed to add organization owner")
+			return fmt.Errorf("failed to add organization owner: %w", err)
+		}
+		org.Role = OrgRoleOwner
+		return nil
+	})
+}
+
+// checkOrganizationName returns ErrOrganizationExists when an organization other
+// than the given one has its name
+func (p *PostgresDB) checkOrganizationName(ctx context.Context, org *Organization) error {
+	var exists bool
+	err := p.db.QueryRowContext(ctx,
+		"SELECT EXISTS(SELECT 1 FROM organizations WHERE name = $1 AND id <> $2)", org.Name, org.ID,
+	).Scan(&exists)
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"org_name", org.Name,
+			"error", err.Error(),
+		).Error("Database error when checking if organization exists")
+		return fmt.Errorf("failed to check organization: %w", err)
+	}
+	if exists {
+		logging.DB.WithContext(ctx).WithFields(
+			"org_name", org.Name,
+		).Warn("Cannot save organization: name already exists")
+		return ErrOrganizationExists
+	}
 	return nil
 }
 
-// ListAPIKeysByUser lists all API keys for a user
-func (p *PostgresDB) ListAPIKeysByUser(ctx context.Context, userID int64) ([]*APIKey, error) {
-	logging.DB.WithFields(
-		"user_id", userID,
-	).Debug("Listing API keys for user from PostgreSQL")
+// GetOrganization retrieves an organization by ID
+func (p *PostgresDB) GetOrganization(ctx context.Context, id int64) (*Organization, error) {
+	org, err := scanOrganization(p.db.QueryRowContext(ctx,
+		"SELECT "+organizationColumns+" FROM organizations WHERE id = $1", id,
+	))
+	if err == sql.ErrNoRows {
+		return nil, ErrOrganizationNotFound
+	}
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"org_id", id,
+			"error", err.Error(),
+		).Error("Failed to get organization")
+		return nil, fmt.Errorf("failed to get organization: %w", err)
+	}
+	return org, nil
+}
 
-	rows, err := p.db.QueryContext(ctx,
-		"SELECT id, user_id, key, description, last_used, expires_at, created_at FROM api_keys WHERE user_id = $1",
-		userID,
-	)
+// ListOrganizations lists every organization by name
+func (p *PostgresDB) ListOrganizations(ctx context.Context) ([]*Organization, error) {
+	return p.queryOrganizations(ctx, false,
+		"SELECT "+organizationColumns+" FROM organizations ORDER BY name")
+}
+
+// ListUserOrganizations lists the organizations of a user by name, with their role
+// in each
+func (p *PostgresDB) ListUserOrganizations(ctx context.Context, userID int64) ([]*Organization, error) {
+	return p.queryOrganizations(ctx, true,
+		`SELECT `+organizationColumns+`, organization_members.role FROM organizations
+		JOIN organization_members ON organization_members.org_id = organizations.id
+		WHERE organization_members.user_id = $1 ORDER BY organizations.name`, userID)
+}
+
+// queryOrganizations reads the organizations of a query, followed by the role of
+// the user when withRole is set
+func (p *PostgresDB) queryOrganizations(ctx context.Context, withRole bool, query string, args ...interface{}) ([]*Organization, error) {
+	rows, err := p.db.QueryContext(ctx, query, args...)
 	if err != nil {
-		logging.DB.WithFields(
-			"user_id", userID,
+		logging.DB.WithContext(ctx).WithFields(
 			"error", err.Error(),
-		).Error("Failed to query API keys")
-		return nil, err
+		).Error("Failed to list organizations")
+		return nil, fmt.Errorf("failed to list organizations: %w", err)
 	}
 	defer rows.Close()
 
-	keys := []*APIKey{}
+	orgs := []*Organization{}
 	for rows.Next() {
-		key := &APIKey{}
-		if err := rows.Scan(
-			&key.ID, &key.UserID, &key.Key, &key.Description, &key.LastUsed, &key.ExpiresAt, &key.CreatedAt,
-		); err != nil {
-			logging.DB.WithFields(
-				"user_id", userID,
-				"error", err.Error(),
-			).Error("Failed to scan API key row")
-			return nil, err
+		var role string
+		var extra []interface{}
+		if withRole {
+			extra = append(extra, &role)
 		}
-		keys = append(keys, key)
+		org, err := scanOrganization(rows, extra...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan organization row: %w", err)
+		}
+		org.Role = role
+		orgs = append(orgs, org)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating organization rows: %w", err)
 	}
+	return orgs, nil
+}
 
-	if err = rows.Err(); err != nil {
-		logging.DB.WithFields(
-			"user_id", userID,
+// UpdateOrganization updates the name and description of an organization. It
+// returns ErrOrganizationExists when another organization has its new name.
+func (p *PostgresDB) UpdateOrganization(ctx context.Context, org *Organization) error {
+	logging.DB.WithContext(ctx).WithFields(
+		"org_id", org.ID,
+		"org_name", org.Name,
+	).Info("Updating organization")
+
+	if err := p.checkOrganizationName(ctx, org); err != nil {
+		return err
+	}
+
+	org.UpdatedAt = time.Now()
+	result, err := p.db.ExecContext(ctx,
+		"UPDATE organizations SET name = $1, description = $2, updated_at = $3 WHERE id = $4",
+		org.Name, org.Description, org.UpdatedAt, org.ID,
+	)
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"org_id", org.ID,
 			"error", err.Error(),
-		).Error("Error during API key rows iteration")
-		return nil, err
+		).Error("Failed to update organization")
+		return fmt.Errorf("failed to update organization: %w", err)
 	}
 
-	logging.DB.WithFields(
-		"user_id", userID,
-		"count", len(keys),
-	).Debug("Successfully retrieved API keys from PostgreSQL")
-	return keys, nil
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrOrganizationNotFound
+	}
+	return nil
 }
 
-// CreateServerRecord creates a new Minecraft server record
-func (p *PostgresDB) CreateServerRecord(ctx context.Context, server *MinecraftServer) error {
-	query := `INSERT INTO minecraft_servers
-              (server_name, deployment_name, pvc_name, owner_id, status, created_at, updated_at)
-              VALUES ($1, $2, $3, $4, $5, $6, $7)
-              RETURNING id`
+// DeleteOrganization deletes an organization and its memberships. Its servers go
+// back to the users who created them.
+func (p *PostgresDB) DeleteOrganization(ctx context.Context, id int64) error {
+	logging.DB.WithContext(ctx).WithFields(
+		"org_id", id,
+	).Info("Deleting organization")
 
-	now := time.Now()
-	server.CreatedAt = now
-	server.UpdatedAt = now
+	return p.db.inTx(ctx, nil, func(tx *tracedDB) error {
+		if _, err := tx.ExecContext(ctx, "UPDATE minecraft_servers SET org_id = 0, updated_at = $1 WHERE org_id = $2", time.Now(), id); err != nil {
+			logging.DB.WithContext(ctx).WithFields(
+				"org_id", id,
+				"error", err.Error(),
+			).Error("Failed to release organization servers")
+			return fmt.Errorf("failed to release organization servers: %w", err)
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM organization_members WHERE org_id = $1", id); err != nil {
+			logging.DB.WithContext(ctx).WithFields(
+				"org_id", id,
+				"error", err.Error(),
+			).Error("Failed to delete organization members")
+			return fmt.Errorf("failed to delete organization members: %w", err)
+		}
+		result, err := tx.ExecContext(ctx, "DELETE FROM organizations WHERE id = $1", id)
+		if err != nil {
+			logging.DB.WithContext(ctx).WithFields(
+				"org_id", id,
+				"error", err.Error(),
+			).Error("Failed to delete organization")
+			return fmt.Errorf("failed to delete organization: %w", err)
+		}
 
-	err := p.db.QueryRowContext(ctx, query,
-		server.ServerName,
-		server.DeploymentName,
-		server.PVCName,
-		server.OwnerID,
-		server.Status,
-		server.CreatedAt,
-		server.UpdatedAt,
-	).Scan(&server.ID)
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("failed to get affected rows: %w", err)
+		}
+		if rowsAffected == 0 {
+			return ErrOrganizationNotFound
+		}
+		return nil
+	})
+}
 
+// SaveOrganizationMember adds a user to an organization, or changes their role
+func (p *PostgresDB) SaveOrganizationMember(ctx context.Context, member *OrganizationMember) error {
+	logging.DB.WithContext(ctx).WithFields(
+		"org_id", member.OrgID,
+		"user_id", member.UserID,
+		"role", member.Role,
+	).Info("Saving organization member")
+
+	now := time.Now()
+	member.UpdatedAt = now
+	err := p.db.QueryRowContext(ctx,
+		`INSERT INTO organization_members (org_id, user_id, role, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (org_id, user_id) DO UPDATE SET
+			role = excluded.role,
+			updated_at = excluded.updated_at
+		RETURNING created_at`,
+		member.OrgID, member.UserID, member.Role, now, now,
+	).Scan(&member.CreatedAt)
 	if err != nil {
-		logging.DB.WithFields(
-			"server_name", server.ServerName,
+		logging.DB.WithContext(ctx).WithFields(
+			"org_id", member.OrgID,
+			"user_id", member.UserID,
 			"error", err.Error(),
-		).Error("Failed to create server record")
-		return fmt.Errorf("failed to create server record: %w", err)
+		).Error("Failed to save organization member")
+		return fmt.Errorf("failed to save organization member: %w", err)
 	}
-
-	logging.DB.WithFields(
-		"server_name", server.ServerName,
-		"server_id", server.ID,
-	).Info("Server record created successfully")
 	return nil
 }
 
-// GetServerByName gets a Minecraft server by its name
-func (p *PostgresDB) GetServerByName(ctx context.Context, serverName string) (*MinecraftServer, error) {
-	query := `SELECT id, server_name, deployment_name, pvc_name, owner_id,
-              status, created_at, updated_at
-              FROM minecraft_servers WHERE server_name = $1`
-
-	var server MinecraftServer
-	err := p.db.QueryRowContext(ctx, query, serverName).Scan(
-		&server.ID,
-		&server.ServerName,
-		&server.DeploymentName,
-		&server.PVCName,
-		&server.OwnerID,
-		&server.Status,
-		&server.CreatedAt,
-		&server.UpdatedAt,
-	)
-
+// GetOrganizationMember retrieves the membership of a user in an organization
+func (p *PostgresDB) GetOrganizationMember(ctx context.Context, orgID, userID int64) (*OrganizationMember, error) {
+	member, err := scanOrgMember(p.db.QueryRowContext(ctx,
+		`SELECT `+orgMemberColumns+` FROM organization_members
+		JOIN users ON users.id = organization_members.user_id
+		WHERE organization_members.org_id = $1 AND organization_members.user_id = $2`, orgID, userID,
+	))
 	if err == sql.ErrNoRows {
-		logging.DB.WithFields(
-			"server_name", serverName,
-			"error", "server_not_found",
-		).Warn("Server not found")
-		return nil, fmt.Errorf("server not found: %s", serverName)
+		return nil, ErrOrgMemberNotFound
+	}
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"org_id", orgID,
+			"user_id", userID,
+			"error", err.Error(),
+		).Error("Failed to get organization member")
+		return nil, fmt.Errorf("failed to get organization member: %w", err)
 	}
+	return member, nil
+}
 
+// ListOrganizationMembers lists the members of an organization by username
+func (p *PostgresDB) ListOrganizationMembers(ctx context.Context, orgID int64) ([]*OrganizationMember, error) {
+	rows, err := p.db.QueryContext(ctx,
+		`SELECT `+orgMemberColumns+` FROM organization_members
+		JOIN users ON users.id = organization_members.user_id
+		WHERE organization_members.org_id = $1 ORDER BY users.username`, orgID)
 	if err != nil {
-		logging.DB.WithFields(
-			"server_name", serverName,
+		logging.DB.WithContext(ctx).WithFields(
+			"org_id", orgID,
 			"error", err.Error(),
-		).Error("Failed to get server")
-		return nil, fmt.Errorf("failed to get server: %w", err)
+		).Error("Failed to list organization members")
+		return nil, fmt.Errorf("failed to list organization members: %w", err)
 	}
+	defer rows.Close()
 
-	logging.DB.WithFields(
-		"server_name", serverName,
-		"server_id", server.ID,
-	).Info("Server retrieved successfully")
-	return &server, nil
+	members := []*OrganizationMember{}
+	for rows.Next() {
+		member, err := scanOrgMember(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan organization member row: %w", err)
+		}
+		members = append(members, member)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating organization member rows: %w", err)
+	}
+	return members, nil
 }
 
-// ListServersByOwner list all Minecraft servers by owner ID
-func (p *PostgresDB) ListServersByOwner(ctx context.Context, ownerID int64) ([]*MinecraftServer, error) {
-	query := `SELECT id, server_name, deployment_name, pvc_name, owner_id,
-              status, created_at, updated_at
-              FROM minecraft_servers WHERE owner_id = $1`
+// DeleteOrganizationMember removes a user from an organization
+func (p *PostgresDB) DeleteOrganizationMember(ctx context.Context, orgID, userID int64) error {
+	logging.DB.WithContext(ctx).WithFields(
+		"org_id", orgID,
+		"user_id", userID,
+	).Info("Deleting organization member")
 
-	rows, err := p.db.QueryContext(ctx, query, ownerID)
+	result, err := p.db.ExecContext(ctx,
+		"DELETE FROM organization_members WHERE org_id = $1 AND user_id = $2", orgID, userID)
 	if err != nil {
-		logging.DB.WithFields(
-			"owner_id", ownerID,
+		logging.DB.WithContext(ctx).WithFields(
+			"org_id", orgID,
+			"user_id", userID,
 			"error", err.Error(),
-		).Error("Failed to list servers")
+		).Error("Failed to delete organization member")
+		return fmt.Errorf("failed to delete organization member: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrOrgMemberNotFound
+	}
+	return nil
+}
+
+// ListServersByOrganization lists the servers owned by an organization
+func (p *PostgresDB) ListServersByOrganization(ctx context.Context, orgID int64) ([]*MinecraftServer, error) {
+	rows, err := p.db.QueryContext(ctx,
+		`SELECT id, server_name, deployment_name, pvc_name, owner_id, org_id, namespace,
+		status, status_reason, spec, created_at, updated_at
+		FROM minecraft_servers WHERE org_id = $1 ORDER BY server_name`, orgID)
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"org_id", orgID,
+			"error", err.Error(),
+		).Error("Failed to list organization servers")
 		return nil, fmt.Errorf("failed to list servers: %w", err)
 	}
 	defer rows.Close()
 
-	var servers []*MinecraftServer
+	servers := []*MinecraftServer{}
 	for rows.Next() {
 		var server MinecraftServer
 		if err := rows.Scan(
@@ -649,71 +4225,307 @@ func (p *PostgresDB) ListServersByOwner(ctx context.Context, ownerID int64) ([]*
 			&server.DeploymentName,
 			&server.PVCName,
 			&server.OwnerID,
+			&server.OrgID,
+			&server.Namespace,
 			&server.Status,
+			&server.StatusReason,
+			&server.Spec,
 			&server.CreatedAt,
 			&server.UpdatedAt,
 		); err != nil {
-			logging.DB.WithFields(
-				"owner_id", ownerID,
-				"error", err.Error(),
-			).Error("Failed to scan server row")
 			return nil, fmt.Errorf("failed to scan server row: %w", err)
 		}
 		servers = append(servers, &server)
 	}
-
 	if err := rows.Err(); err != nil {
-		logging.DB.WithFields(
-			"owner_id", ownerID,
-			"error", err.Error(),
-		).Error("Error iterating server rows")
 		return nil, fmt.Errorf("error iterating server rows: %w", err)
 	}
-
-	logging.DB.WithFields(
-		"owner_id", ownerID,
-		"count", len(servers),
-	).Info("Servers listed successfully")
 	return servers, nil
 }
 
-// UpdateServerStatus updates the status of a Minecraft server
-func (p *PostgresDB) UpdateServerStatus(ctx context.Context, serverName string, status string) error {
-	query := `UPDATE minecraft_servers SET status = $1, updated_at = $2 WHERE server_name = $3`
+// SetServerOrganization moves a server into an organization, or back to the user
+// who created it with an orgID of 0
+func (p *PostgresDB) SetServerOrganization(ctx context.Context, serverName string, orgID int64) error {
+	logging.DB.WithContext(ctx).WithFields(
+		"server_name", serverName,
+		"org_id", orgID,
+	).Info("Setting server organization")
 
-	now := time.Now()
-	_, err := p.db.ExecContext(ctx, query, status, now, serverName)
+	result, err := p.db.ExecContext(ctx,
+		"UPDATE minecraft_servers SET org_id = $1, updated_at = $2 WHERE server_name = $3",
+		orgID, time.Now(), serverName,
+	)
 	if err != nil {
-		logging.DB.WithFields(
+		logging.DB.WithContext(ctx).WithFields(
 			"server_name", serverName,
-			"status", status,
+			"org_id", orgID,
 			"error", err.Error(),
-		).Error("Failed to update server status")
-		return fmt.Errorf("failed to update server status: %w", err)
+		).Error("Failed to set server organization")
+		return fmt.Errorf("failed to set server organization: %w", err)
 	}
 
-	logging.DB.WithFields(
-		"server_name", serverName,
-		"status", status,
-	).Info("Server status updated successfully")
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("server not found: %s", serverName)
+	}
 	return nil
 }
 
-// DeleteServerRecord deletes a Minecraft server record
-func (p *PostgresDB) DeleteServerRecord(ctx context.Context, serverName string) error {
-	query := `DELETE FROM minecraft_servers WHERE server_name = $1`
+// GetAPIKeyByID retrieves an API key by ID, with the username of its user
+func (p *PostgresDB) GetAPIKeyByID(ctx context.Context, id int64) (*APIKey, error) {
+	key, err := scanAPIKey(p.db.QueryRowContext(ctx,
+		`SELECT `+apiKeyColumns+` FROM api_keys
+		JOIN users ON users.id = api_keys.user_id
+		WHERE api_keys.id = $1`, id))
+	if err == sql.ErrNoRows {
+		return nil, ErrAPIKeyNotFound
+	}
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"key_id", id,
+			"error", err.Error(),
+		).Error("Failed to get API key")
+		return nil, fmt.Errorf("failed to get API key: %w", err)
+	}
+	return key, nil
+}
 
-	_, err := p.db.ExecContext(ctx, query, serverName)
+// TouchAPIKey records that an API key was just used
+func (p *PostgresDB) TouchAPIKey(ctx context.Context, id int64) error {
+	if _, err := p.db.ExecContext(ctx, "UPDATE api_keys SET last_used = $1 WHERE id = $2", dbClock.Now(), id); err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"key_id", id,
+			"error", err.Error(),
+		).Error("Failed to update API key last used time")
+		return fmt.Errorf("failed to update API key last used time: %w", err)
+	}
+	return nil
+}
+
+// touchAPIKeys records the last uses of API keys, by key ID, in one transaction
+func (p *PostgresDB) touchAPIKeys(ctx context.Context, uses map[int64]time.Time) error {
+	return p.db.inTx(ctx, nil, func(tx *tracedDB) error {
+		for id, at := range uses {
+			if _, err := tx.ExecContext(ctx, "UPDATE api_keys SET last_used = $1 WHERE id = $2", at, id); err != nil {
+				logging.DB.WithContext(ctx).WithFields(
+					"key_id", id,
+					"error", err.Error(),
+				).Error("Failed to update API key last used time")
+				return fmt.Errorf("failed to update API key last used time: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// SoftDeleteUser deactivates a user and marks them deleted at the given time, to be
+// purged by PurgeDeletedUsers or restored by RestoreUser
+func (p *PostgresDB) SoftDeleteUser(ctx context.Context, id int64, at time.Time) error {
+	logging.DB.WithContext(ctx).WithFields(
+		"user_id", id,
+	).Info("Soft deleting user")
+
+	result, err := p.db.ExecContext(ctx,
+		"UPDATE users SET active = FALSE, deleted_at = $1, updated_at = $2 WHERE id = $3 AND deleted_at IS NULL",
+		at, at, id,
+	)
 	if err != nil {
-		logging.DB.WithFields(
-			"server_name", serverName,
+		logging.DB.WithContext(ctx).WithFields(
+			"user_id", id,
 			"error", err.Error(),
-		).Error("Failed to delete server record")
-		return fmt.Errorf("failed to delete server record: %w", err)
+		).Error("Failed to soft delete user")
+		return fmt.Errorf("failed to soft delete user: %w", err)
 	}
 
-	logging.DB.WithFields(
-		"server_name", serverName,
-	).Info("Server record deleted successfully")
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// RestoreUser reactivates a user soft deleted after the given time, the ones deleted
+// before it being past their grace period
+func (p *PostgresDB) RestoreUser(ctx context.Context, id int64, deletedAfter time.Time) error {
+	logging.DB.WithContext(ctx).WithFields(
+		"user_id", id,
+	).Info("Restoring user")
+
+	result, err := p.db.ExecContext(ctx,
+		"UPDATE users SET active = TRUE, deleted_at = NULL, updated_at = $1 WHERE id = $2 AND deleted_at IS NOT NULL AND deleted_at > $3",
+		dbClock.Now(), id, deletedAfter,
+	)
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"user_id", id,
+			"error", err.Error(),
+		).Error("Failed to restore user")
+		return fmt.Errorf("failed to restore user: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrUserNotFound
+	}
 	return nil
 }
+
+// PurgeDeletedUsers deletes for good the users soft deleted before the given time,
+// and returns how many were purged
+func (p *PostgresDB) PurgeDeletedUsers(ctx context.Context, before time.Time) (int64, error) {
+	rows, err := p.db.QueryContext(ctx, "SELECT id FROM users WHERE deleted_at IS NOT NULL AND deleted_at < $1", before)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list deleted users: %w", err)
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan deleted user: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to list deleted users: %w", err)
+	}
+
+	var purged int64
+	for _, id := range ids {
+		if err := p.DeleteUser(ctx, id); err != nil && err != ErrUserNotFound {
+			return purged, fmt.Errorf("failed to purge user %d: %w", id, err)
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+// TransferServers gives every server of a user to another user, and returns how
+// many were transferred
+func (p *PostgresDB) TransferServers(ctx context.Context, fromUserID, toUserID int64) (int64, error) {
+	logging.DB.WithContext(ctx).WithFields(
+		"from_user_id", fromUserID,
+		"to_user_id", toUserID,
+	).Info("Transferring servers")
+
+	result, err := p.db.ExecContext(ctx,
+		"UPDATE minecraft_servers SET owner_id = $1, updated_at = $2 WHERE owner_id = $3",
+		toUserID, time.Now(), fromUserID,
+	)
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"from_user_id", fromUserID,
+			"to_user_id", toUserID,
+			"error", err.Error(),
+		).Error("Failed to transfer servers")
+		return 0, fmt.Errorf("failed to transfer servers: %w", err)
+	}
+	transferred, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	return transferred, nil
+}
+
+// SearchUsers lists a page of the users matching the filter, with their roles, and
+// the number of users matching it on every page
+func (p *PostgresDB) SearchUsers(ctx context.Context, filter UserFilter) ([]*User, int, error) {
+	search := ""
+	if filter.Search != "" {
+		search = likePattern(filter.Search)
+	}
+	activeSet, active := boolFilter(filter.Active)
+	deletedSet, deleted := boolFilter(filter.Deleted)
+	where := ` WHERE ($1 = '' OR LOWER(users.username) LIKE $2 ESCAPE '\' OR LOWER(users.email) LIKE $3 ESCAPE '\' OR LOWER(users.display_name) LIKE $4 ESCAPE '\')
+		AND ($5 = 0 OR users.active = $6)
+		AND ($7 = 0 OR (users.deleted_at IS NOT NULL) = $8)`
+	args := []interface{}{search, search, search, search, activeSet, active, deletedSet, deleted}
+
+	var total int
+	if err := p.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM users"+where, args...).Scan(&total); err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"error", err.Error(),
+		).Error("Failed to count users")
+		return nil, 0, fmt.Errorf("failed to count users: %w", err)
+	}
+
+	rows, err := p.db.QueryContext(ctx, "SELECT "+userColumns+" FROM users"+where+filter.orderBy(UserSortFields), args...)
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"error", err.Error(),
+		).Error("Failed to search users")
+		return nil, 0, fmt.Errorf("failed to search users: %w", err)
+	}
+	defer rows.Close()
+
+	users := []*User{}
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to scan user row: %w", err)
+		}
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("error iterating user rows: %w", err)
+	}
+	if err := p.withRoles(ctx, users...); err != nil {
+		return nil, 0, err
+	}
+	return users, total, nil
+}
+
+// SearchAPIKeys lists a page of the API keys matching the filter, with the username
+// of their user, and the number of keys matching it on every page
+func (p *PostgresDB) SearchAPIKeys(ctx context.Context, filter APIKeyFilter) ([]*APIKey, int, error) {
+	search := ""
+	if filter.Search != "" {
+		search = likePattern(filter.Search)
+	}
+	where := ` WHERE ($1 = 0 OR api_keys.user_id = $2)
+		AND ($3 = '' OR LOWER(api_keys.description) LIKE $4 ESCAPE '\')`
+	args := []interface{}{filter.UserID, filter.UserID, search, search}
+
+	var total int
+	if err := p.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM api_keys"+where, args...).Scan(&total); err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"error", err.Error(),
+		).Error("Failed to count API keys")
+		return nil, 0, fmt.Errorf("failed to count API keys: %w", err)
+	}
+
+	rows, err := p.db.QueryContext(ctx,
+		"SELECT "+apiKeyColumns+" FROM api_keys JOIN users ON users.id = api_keys.user_id"+where+filter.orderBy(APIKeySortFields),
+		args...,
+	)
+	if err != nil {
+		logging.DB.WithContext(ctx).WithFields(
+			"error", err.Error(),
+		).Error("Failed to search API keys")
+		return nil, 0, fmt.Errorf("failed to search API keys: %w", err)
+	}
+	defer rows.Close()
+
+	keys := []*APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to scan API key row: %w", err)
+		}
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("error iterating API key rows: %w", err)
+	}
+	return keys, total, nil
+}
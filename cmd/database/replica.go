@@ -0,0 +1,39 @@
+package database
+
+import (
+	"context"
+	"strings"
+
+	"minecharts/cmd/logging"
+)
+
+type replicaKey struct{}
+
+// WithReplica marks the reads made with a context as tolerating the lag of the read
+// replica, such as the lists, statuses and metrics served to dashboards. The writes
+// and the reads of the other contexts always go to the primary, so that a handler
+// reading what it just wrote sees it.
+func WithReplica(ctx context.Context) context.Context {
+	return context.WithValue(ctx, replicaKey{}, true)
+}
+
+// readsFromReplica reports whether a query is a read to send to the replica.
+// Statements such as INSERT ... RETURNING are queries too, but go to the primary.
+func (d *tracedDB) readsFromReplica(ctx context.Context, query string) bool {
+	if d.replica == nil {
+		return false
+	}
+	if marked, _ := ctx.Value(replicaKey{}).(bool); !marked {
+		return false
+	}
+	words := strings.Fields(query)
+	return len(words) > 0 && strings.EqualFold(words[0], "SELECT")
+}
+
+// replicaFailed logs a read the replica failed, which is then made on the primary.
+func replicaFailed(query string, err error) {
+	logging.DB.WithFields(
+		"query", queryName(query),
+		"error", err.Error(),
+	).Warn("Read replica query failed, reading from the primary")
+}
@@ -0,0 +1,376 @@
+package database
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// serverRequestColumns lists the server_requests columns in the order expected by scanServerRequest.
+const serverRequestColumns = `id, server_name, requester_id, payload, status, reason, reviewer_id, created_at, reviewed_at`
+
+// scanServerRequest reads a server_requests row selected with serverRequestColumns.
+func scanServerRequest(row rowScanner) (*ServerRequest, error) {
+	var req ServerRequest
+	var payload string
+	if err := row.Scan(
+		&req.ID,
+		&req.ServerName,
+		&req.RequesterID,
+		&payload,
+		&req.Status,
+		&req.Reason,
+		&req.ReviewerID,
+		&req.CreatedAt,
+		&req.ReviewedAt,
+	); err != nil {
+		return nil, err
+	}
+	req.Payload = []byte(payload)
+	return &req, nil
+}
+
+// serverTemplateColumns lists the server_templates columns in the order expected by scanServerTemplate.
+const serverTemplateColumns = `id, name, description, category, spec, parameters, source, created_by, created_at, updated_at`
+
+// scanServerTemplate reads a server_templates row selected with serverTemplateColumns.
+func scanServerTemplate(row rowScanner) (*ServerTemplate, error) {
+	var template ServerTemplate
+	if err := row.Scan(
+		&template.ID,
+		&template.Name,
+		&template.Description,
+		&template.Category,
+		&template.Spec,
+		&template.Parameters,
+		&template.Source,
+		&template.CreatedBy,
+		&template.CreatedAt,
+		&template.UpdatedAt,
+	); err != nil {
+		return nil, err
+	}
+	return &template, nil
+}
+
+// scheduledTaskColumns lists the scheduled_tasks columns, joined as t with minecraft_servers
+// as s, in the order expected by scanScheduledTask.
+const scheduledTaskColumns = `t.id, t.server_id, s.server_name, t.name, t.schedule, t.action, t.payload, t.enabled,
+	t.created_by, t.run_count, t.last_run_at, t.next_run_at, t.created_at, t.updated_at`
+
+// scanScheduledTask reads a scheduled_tasks row selected with scheduledTaskColumns.
+func scanScheduledTask(row rowScanner) (*ScheduledTask, error) {
+	var task ScheduledTask
+	if err := row.Scan(
+		&task.ID,
+		&task.ServerID,
+		&task.ServerName,
+		&task.Name,
+		&task.Schedule,
+		&task.Action,
+		&task.Payload,
+		&task.Enabled,
+		&task.CreatedBy,
+		&task.RunCount,
+		&task.LastRunAt,
+		&task.NextRunAt,
+		&task.CreatedAt,
+		&task.UpdatedAt,
+	); err != nil {
+		return nil, err
+	}
+	return &task, nil
+}
+
+// taskRunColumns lists the task_runs columns in the order expected by scanTaskRun.
+const taskRunColumns = `id, task_id, started_at, finished_at, status, output`
+
+// scanTaskRun reads a task_runs row selected with taskRunColumns.
+func scanTaskRun(row rowScanner) (*TaskRun, error) {
+	var run TaskRun
+	if err := row.Scan(
+		&run.ID,
+		&run.TaskID,
+		&run.StartedAt,
+		&run.FinishedAt,
+		&run.Status,
+		&run.Output,
+	); err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
+
+// jobColumns lists the jobs columns in the order expected by scanJob.
+const jobColumns = `id, type, server_name, owner_id, status, progress, message, error,
+	created_by, created_at, started_at, finished_at, updated_at`
+
+// serverPluginColumns lists the server_plugins columns in the order expected by scanServerPlugin.
+const serverPluginColumns = `id, server_id, platform, project_id, project_slug, name, version_id, version,
+	directory, file_name, sha512, installed_by, installed_at`
+
+// scanServerPlugin reads a server_plugins row selected with serverPluginColumns.
+func scanServerPlugin(row rowScanner) (*ServerPlugin, error) {
+	var plugin ServerPlugin
+	if err := row.Scan(
+		&plugin.ID,
+		&plugin.ServerID,
+		&plugin.Platform,
+		&plugin.ProjectID,
+		&plugin.ProjectSlug,
+		&plugin.Name,
+		&plugin.VersionID,
+		&plugin.Version,
+		&plugin.Directory,
+		&plugin.FileName,
+		&plugin.SHA512,
+		&plugin.InstalledBy,
+		&plugin.InstalledAt,
+	); err != nil {
+		return nil, err
+	}
+	return &plugin, nil
+}
+
+// scanJob reads a jobs row selected with jobColumns.
+func scanJob(row rowScanner) (*Job, error) {
+	var job Job
+	if err := row.Scan(
+		&job.ID,
+		&job.Type,
+		&job.ServerName,
+		&job.OwnerID,
+		&job.Status,
+		&job.Progress,
+		&job.Message,
+		&job.Error,
+		&job.CreatedBy,
+		&job.CreatedAt,
+		&job.StartedAt,
+		&job.FinishedAt,
+		&job.UpdatedAt,
+	); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// proxyColumns lists the proxies columns in the order expected by scanProxy.
+const proxyColumns = `id, name, owner_id, deployment_name, forwarding_mode, created_at, updated_at`
+
+// scanProxy reads a proxies row selected with proxyColumns.
+func scanProxy(row rowScanner) (*Proxy, error) {
+	var proxy Proxy
+	if err := row.Scan(
+		&proxy.ID,
+		&proxy.Name,
+		&proxy.OwnerID,
+		&proxy.DeploymentName,
+		&proxy.ForwardingMode,
+		&proxy.CreatedAt,
+		&proxy.UpdatedAt,
+	); err != nil {
+		return nil, err
+	}
+	return &proxy, nil
+}
+
+// auditEventColumns lists the audit_events columns in the order expected by scanAuditEvent.
+const auditEventColumns = `id, user_id, username, action, path, target_server, target_user_id, summary,
+	status, result, remote_ip, request_id, created_at`
+
+// scanAuditEvent reads an audit_events row selected with auditEventColumns.
+func scanAuditEvent(row rowScanner) (*AuditEvent, error) {
+	var event AuditEvent
+	if err := row.Scan(
+		&event.ID,
+		&event.UserID,
+		&event.Username,
+		&event.Action,
+		&event.Path,
+		&event.TargetServer,
+		&event.TargetUserID,
+		&event.Summary,
+		&event.Status,
+		&event.Result,
+		&event.RemoteIP,
+		&event.RequestID,
+		&event.CreatedAt,
+	); err != nil {
+		return nil, err
+	}
+	return &event, nil
+}
+
+// incidentColumns lists the incidents columns in the order expected by scanIncident.
+const incidentColumns = `id, server_id, server_name, kind, reason, exit_code, restart_count, pod_name, created_at`
+
+// scanIncident reads an incidents row selected with incidentColumns.
+func scanIncident(row rowScanner) (*Incident, error) {
+	var incident Incident
+	if err := row.Scan(
+		&incident.ID,
+		&incident.ServerID,
+		&incident.ServerName,
+		&incident.Kind,
+		&incident.Reason,
+		&incident.ExitCode,
+		&incident.RestartCount,
+		&incident.PodName,
+		&incident.CreatedAt,
+	); err != nil {
+		return nil, err
+	}
+	return &incident, nil
+}
+
+// oauthIdentityColumns lists the oauth_identities columns in the order expected by
+// scanOAuthIdentity.
+const oauthIdentityColumns = `id, user_id, provider, subject, email, created_at`
+
+// scanOAuthIdentity reads an oauth_identities row selected with oauthIdentityColumns.
+func scanOAuthIdentity(row rowScanner) (*OAuthIdentity, error) {
+	var identity OAuthIdentity
+	if err := row.Scan(
+		&identity.ID,
+		&identity.UserID,
+		&identity.Provider,
+		&identity.Subject,
+		&identity.Email,
+		&identity.CreatedAt,
+	); err != nil {
+		return nil, err
+	}
+	return &identity, nil
+}
+
+// roleColumns lists the roles columns in the order expected by scanRole.
+const roleColumns = `id, name, description, permissions, created_at, updated_at`
+
+// scanRole reads a roles row selected with roleColumns.
+func scanRole(row rowScanner) (*Role, error) {
+	var role Role
+	if err := row.Scan(
+		&role.ID,
+		&role.Name,
+		&role.Description,
+		&role.Permissions,
+		&role.CreatedAt,
+		&role.UpdatedAt,
+	); err != nil {
+		return nil, err
+	}
+	return &role, nil
+}
+
+// serverMemberColumns lists the server_members columns, joined with users, in the
+// order expected by scanServerMember.
+const serverMemberColumns = `server_members.server_id, server_members.user_id, users.username, server_members.permissions, server_members.created_at, server_members.updated_at`
+
+// scanServerMember reads a server_members row selected with serverMemberColumns.
+func scanServerMember(row rowScanner) (*ServerMember, error) {
+	var member ServerMember
+	if err := row.Scan(
+		&member.ServerID,
+		&member.UserID,
+		&member.Username,
+		&member.Permissions,
+		&member.CreatedAt,
+		&member.UpdatedAt,
+	); err != nil {
+		return nil, err
+	}
+	return &member, nil
+}
+
+// organizationColumns lists the organizations columns in the order expected by
+// scanOrganization.
+const organizationColumns = `organizations.id, organizations.name, organizations.description, organizations.created_at, organizations.updated_at`
+
+// scanOrganization reads an organizations row selected with organizationColumns,
+// followed by the extra columns given.
+func scanOrganization(row rowScanner, extra ...interface{}) (*Organization, error) {
+	var org Organization
+	dest := []interface{}{
+		&org.ID,
+		&org.Name,
+		&org.Description,
+		&org.CreatedAt,
+		&org.UpdatedAt,
+	}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return nil, err
+	}
+	return &org, nil
+}
+
+// orgMemberColumns lists the organization_members columns, joined with users, in
+// the order expected by scanOrgMember.
+const orgMemberColumns = `organization_members.org_id, organization_members.user_id, users.username, organization_members.role, organization_members.created_at, organization_members.updated_at`
+
+// scanOrgMember reads an organization_members row selected with orgMemberColumns.
+func scanOrgMember(row rowScanner) (*OrganizationMember, error) {
+	var member OrganizationMember
+	if err := row.Scan(
+		&member.OrgID,
+		&member.UserID,
+		&member.Username,
+		&member.Role,
+		&member.CreatedAt,
+		&member.UpdatedAt,
+	); err != nil {
+		return nil, err
+	}
+	return &member, nil
+}
+
+// apiKeyColumns lists the api_keys columns, joined with users, in the order expected
+// by scanAPIKey.
+const apiKeyColumns = `api_keys.id, api_keys.user_id, users.username, api_keys.key, api_keys.description, api_keys.permissions, api_keys.server_ids, api_keys.last_used, api_keys.expires_at, api_keys.created_at`
+
+// scanAPIKey reads an api_keys row selected with apiKeyColumns.
+func scanAPIKey(row rowScanner) (*APIKey, error) {
+	var key APIKey
+	if err := row.Scan(
+		&key.ID,
+		&key.UserID,
+		&key.Username,
+		&key.Key,
+		&key.Description,
+		&key.Permissions,
+		&key.ServerIDs,
+		&key.LastUsed,
+		&key.ExpiresAt,
+		&key.CreatedAt,
+	); err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// userColumns lists the users columns in the order expected by scanUser.
+const userColumns = `users.id, users.username, users.email, users.display_name, users.password_hash, users.permissions, users.active, users.password_change_required, users.minecraft_uuid, users.minecraft_name, users.last_login, users.deleted_at, users.created_at, users.updated_at`
+
+// scanUser reads a users row selected with userColumns. The roles of the user are
+// loaded separately.
+func scanUser(row rowScanner) (*User, error) {
+	var user User
+	if err := row.Scan(
+		&user.ID,
+		&user.Username,
+		&user.Email,
+		&user.DisplayName,
+		&user.PasswordHash,
+		&user.DirectPermissions,
+		&user.Active,
+		&user.PasswordChangeRequired,
+		&user.MinecraftUUID,
+		&user.MinecraftName,
+		&user.LastLogin,
+		&user.DeletedAt,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+	); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
@@ -0,0 +1,93 @@
+package database
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"minecharts/cmd/logging"
+)
+
+func TestMain(m *testing.M) {
+	logging.Init()
+	logging.Logger.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+type fixedClock time.Time
+
+func (c fixedClock) Now() time.Time {
+	return time.Time(c)
+}
+
+// setupTest gives the test a throwaway SQLite database recording times with a
+// clock stopped at now.
+func setupTest(t *testing.T, now time.Time) *SQLiteDB {
+	t.Helper()
+	db, err := NewSQLiteDB(filepath.Join(t.TempDir(), "minecharts.db"), PoolConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Init(); err != nil {
+		t.Fatal(err)
+	}
+	previous := dbClock
+	SetClock(fixedClock(now))
+	t.Cleanup(func() {
+		SetClock(previous)
+		db.Close()
+	})
+	return db
+}
+
+func TestServerMemberAndRestoreTimesFollowTheClock(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	db := setupTest(t, now)
+	ctx := context.Background()
+
+	owner := &User{Username: "alice", Email: "alice@minecharts.local", Active: true}
+	member := &User{Username: "bob", Email: "bob@minecharts.local", Active: true}
+	for _, user := range []*User{owner, member} {
+		if err := db.CreateUser(ctx, user); err != nil {
+			t.Fatal(err)
+		}
+	}
+	server := &MinecraftServer{
+		ServerName:     "survival",
+		DeploymentName: "minecraft-server-survival",
+		PVCName:        "minecraft-server-survival-pvc",
+		OwnerID:        owner.ID,
+		Status:         ServerStatusRunning,
+	}
+	if err := db.CreateServerRecord(ctx, server); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.SaveServerMember(ctx, &ServerMember{ServerID: server.ID, UserID: member.ID, Permissions: PermViewServer}); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := db.GetServerMember(ctx, server.ID, member.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !saved.CreatedAt.Equal(now) || !saved.UpdatedAt.Equal(now) {
+		t.Errorf("member created at %v and updated at %v, want the clock time %v", saved.CreatedAt, saved.UpdatedAt, now)
+	}
+
+	if err := db.SoftDeleteUser(ctx, member.ID, now.Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.RestoreUser(ctx, member.ID, now.Add(-2*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := db.GetUserByID(ctx, member.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !restored.UpdatedAt.Equal(now) {
+		t.Errorf("user restored at %v, want the clock time %v", restored.UpdatedAt, now)
+	}
+}
@@ -0,0 +1,121 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"minecharts/cmd/tracing"
+)
+
+// maxTracedStatement bounds the length of the statements recorded in spans.
+const maxTracedStatement = 512
+
+// tracedDB is the connection pool of a database, recording a span for each query
+// made with a context. Queries never carry their arguments into the spans.
+type tracedDB struct {
+	*sql.DB
+	system  string  // db.system of the spans, such as "sqlite" or "postgresql"
+	replica *sql.DB // Read replica serving the reads of the contexts marked with WithReplica, nil for none
+	tx      *sql.Tx // Transaction running the queries made with a context, nil outside WithTx
+}
+
+func (d *tracedDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	ctx, span := d.startSpan(ctx, query)
+	defer span.End()
+	result, err := d.conn().ExecContext(ctx, query, args...)
+	span.SetError(err)
+	return result, err
+}
+
+func (d *tracedDB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	if d.readsFromReplica(ctx, query) {
+		ctx, span := d.startSpan(ctx, query)
+		span.SetAttributes("db.replica", "true")
+		rows, err := d.replica.QueryContext(ctx, query, args...)
+		span.SetError(err)
+		span.End()
+		if err == nil {
+			return rows, nil
+		}
+		replicaFailed(query, err)
+	}
+
+	ctx, span := d.startSpan(ctx, query)
+	defer span.End()
+	rows, err := d.conn().QueryContext(ctx, query, args...)
+	span.SetError(err)
+	return rows, err
+}
+
+func (d *tracedDB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	if d.readsFromReplica(ctx, query) {
+		ctx, span := d.startSpan(ctx, query)
+		span.SetAttributes("db.replica", "true")
+		row := d.replica.QueryRowContext(ctx, query, args...)
+		err := row.Err()
+		if err == nil || err == sql.ErrNoRows {
+			span.End()
+			return row
+		}
+		span.SetError(err)
+		span.End()
+		replicaFailed(query, err)
+	}
+
+	ctx, span := d.startSpan(ctx, query)
+	defer span.End()
+	row := d.conn().QueryRowContext(ctx, query, args...)
+	if err := row.Err(); err != nil && err != sql.ErrNoRows {
+		span.SetError(err)
+	}
+	return row
+}
+
+// startSpan starts the client span of a query, named after its operation and table
+// such as "SELECT minecraft_servers".
+func (d *tracedDB) startSpan(ctx context.Context, query string) (context.Context, *tracing.Span) {
+	ctx, span := tracing.Start(ctx, tracing.KindClient, queryName(query))
+	if span == nil {
+		return ctx, nil
+	}
+	statement := strings.Join(strings.Fields(query), " ")
+	if len(statement) > maxTracedStatement {
+		statement = statement[:maxTracedStatement] + "..."
+	}
+	span.SetAttributes("db.system", d.system, "db.statement", statement)
+	return ctx, span
+}
+
+// queryName returns the operation of a query with the table it acts on.
+func queryName(query string) string {
+	words := strings.Fields(query)
+	if len(words) == 0 {
+		return "query"
+	}
+	operation := strings.ToUpper(words[0])
+	marker := ""
+	switch operation {
+	case "SELECT", "DELETE":
+		marker = "FROM"
+	case "INSERT":
+		marker = "INTO"
+	case "UPDATE":
+		return operation + " " + tableName(words, 1)
+	default:
+		return operation
+	}
+	for i, word := range words {
+		if strings.EqualFold(word, marker) {
+			return operation + " " + tableName(words, i+1)
+		}
+	}
+	return operation
+}
+
+func tableName(words []string, i int) string {
+	if i >= len(words) {
+		return ""
+	}
+	return strings.Trim(words[i], "(),;\"")
+}
@@ -0,0 +1,47 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// querier runs the statements of a tracedDB: its connection pool, or the
+// transaction it is bound to.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// conn returns the transaction d is bound to, else its connection pool.
+func (d *tracedDB) conn() querier {
+	if d.tx != nil {
+		return d.tx
+	}
+	return d.DB
+}
+
+// inTx calls fn with a tracedDB running its statements in a transaction, committed
+// when fn returns nil and rolled back otherwise. When d is already bound to a
+// transaction, fn joins it and the outermost call commits.
+func (d *tracedDB) inTx(ctx context.Context, opts *sql.TxOptions, fn func(tx *tracedDB) error) error {
+	if d.tx != nil {
+		return fn(d)
+	}
+
+	tx, err := d.DB.BeginTx(ctx, opts)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Without replica, the reads of the transaction see its writes
+	if err := fn(&tracedDB{DB: d.DB, system: d.system, tx: tx}); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
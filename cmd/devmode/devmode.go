@@ -0,0 +1,183 @@
+// Package devmode runs the API against an in-memory fake cluster with demo data,
+// so the frontend can be developed without Kubernetes.
+package devmode
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"minecharts/cmd/auth"
+	"minecharts/cmd/config"
+	"minecharts/cmd/database"
+	"minecharts/cmd/kubernetes"
+	"minecharts/cmd/logging"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// ListenAddress only accepts local connections: the demo accounts have well-known passwords.
+const ListenAddress = "127.0.0.1:8080"
+
+// demoUser is an account created with the demo data.
+type demoUser struct {
+	username    string
+	password    string
+	permissions int64
+}
+
+var demoUsers = []demoUser{
+	{username: "admin", password: "admin", permissions: database.PermAll},
+	{username: "operator", password: "operator", permissions: database.PermOperator},
+	{username: "viewer", password: "viewer", permissions: database.PermReadOnly},
+}
+
+// demoServer is a server created with the demo data.
+type demoServer struct {
+	name    string
+	owner   string
+	spec    database.ServerSpec
+	stopped bool
+}
+
+var demoServers = []demoServer{
+	{
+		name:  "survival",
+		owner: "admin",
+		spec:  database.ServerSpec{Version: "1.21.4", ServerType: "paper", Memory: "4G", MaxPlayers: 20, Gamemode: "survival", MOTD: "Demo survival server"},
+	},
+	{
+		name:  "creative",
+		owner: "operator",
+		spec:  database.ServerSpec{Version: "1.21.4", ServerType: "vanilla", Memory: "2G", Gamemode: "creative", MOTD: "Demo creative server"},
+	},
+	{
+		name:    "archive",
+		owner:   "operator",
+		spec:    database.ServerSpec{Version: "1.20.1", ServerType: "fabric", Memory: "2G"},
+		stopped: true,
+	},
+}
+
+// Prepare refuses to enable development mode outside a developer machine and
+// overrides the configuration: a throwaway SQLite database and no OAuth.
+func Prepare() error {
+	if os.Getenv("KUBERNETES_SERVICE_HOST") != "" {
+		return errors.New("development mode refused: running inside a Kubernetes cluster")
+	}
+	if os.Getenv("GIN_MODE") == "release" {
+		return errors.New("development mode refused: GIN_MODE is release")
+	}
+
+	path := filepath.Join(os.TempDir(), "minecharts-dev.db")
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to reset development database: %w", err)
+	}
+
+	config.DatabaseType = database.SQLite
+	config.DatabaseConnectionString = path
+	c := config.Current()
+	c.OAuthEnabled, c.AuthentikEnabled, c.GitHubEnabled, c.GoogleEnabled = false, false, false, false
+	config.Apply(c)
+
+	logging.WithFields(
+		logging.F("database", path),
+		logging.F("listen_address", ListenAddress),
+	).Warn("DEVELOPMENT MODE: fake cluster, throwaway database and demo accounts, do not use in production")
+	return nil
+}
+
+// Seed creates the demo accounts, a template and servers on the fake cluster.
+func Seed(ctx context.Context) error {
+	db := database.GetDB()
+
+	owners := map[string]int64{}
+	for _, demo := range demoUsers {
+		hash, err := auth.HashPassword(demo.password)
+		if err != nil {
+			return err
+		}
+		user := &database.User{
+			Username:     demo.username,
+			Email:        demo.username + "@minecharts.local",
+			PasswordHash: hash,
+			Permissions:  demo.permissions,
+			Active:       true,
+			CreatedAt:    time.Now(),
+			UpdatedAt:    time.Now(),
+		}
+		if err := db.CreateUser(ctx, user); err != nil {
+			return fmt.Errorf("failed to create demo user %s: %w", demo.username, err)
+		}
+		owners[demo.username] = user.ID
+	}
+
+	if err := db.CreateServerTemplate(ctx, &database.ServerTemplate{
+		Name:        "Paper survival",
+		Description: "Demo template for a Paper survival server",
+		Spec:        database.ServerSpec{Version: "1.21.4", ServerType: "paper", Memory: "4G", Gamemode: "survival", Difficulty: "normal"},
+		CreatedBy:   owners["admin"],
+	}); err != nil {
+		return fmt.Errorf("failed to create demo template: %w", err)
+	}
+
+	for _, demo := range demoServers {
+		if err := seedServer(ctx, demo, owners[demo.owner]); err != nil {
+			return err
+		}
+	}
+
+	logging.WithFields(
+		logging.F("users", len(demoUsers)),
+		logging.F("servers", len(demoServers)),
+	).Info("Development mode: demo data seeded, log in as admin/admin, operator/operator or viewer/viewer")
+	return nil
+}
+
+func seedServer(ctx context.Context, demo demoServer, ownerID int64) error {
+	namespace := config.DefaultNamespace
+	deploymentName := config.DeploymentPrefix + demo.name
+	pvcName := deploymentName + config.PVCSuffix
+
+	status := database.ServerStatusStarting
+	if demo.stopped {
+		status = database.ServerStatusStopped
+	}
+	if err := database.GetDB().CreateServerRecord(ctx, &database.MinecraftServer{
+		ServerName:     demo.name,
+		DeploymentName: deploymentName,
+		PVCName:        pvcName,
+		OwnerID:        ownerID,
+		Status:         status,
+		Spec:           demo.spec,
+		CreatedAt:      time.Now(),
+		UpdatedAt:      time.Now(),
+	}); err != nil {
+		return fmt.Errorf("failed to record demo server %s: %w", demo.name, err)
+	}
+
+	if err := kubernetes.EnsureStorage(ctx, namespace, pvcName, demo.spec.StorageSize, demo.spec.StorageClass); err != nil {
+		return fmt.Errorf("failed to create demo server %s storage: %w", demo.name, err)
+	}
+
+	envVars := []corev1.EnvVar{
+		{Name: "EULA", Value: "TRUE"},
+		{Name: "TYPE", Value: strings.ToUpper(demo.spec.ServerType)},
+		{Name: "VERSION", Value: demo.spec.Version},
+		{Name: "MEMORY", Value: demo.spec.Memory},
+	}
+	if err := kubernetes.CreateDeployment(ctx, namespace, deploymentName, pvcName, kubernetes.JavaEdition, envVars, corev1.ResourceRequirements{}, nil, "", kubernetes.Placement{}, kubernetes.DeploymentWorkload); err != nil {
+		return fmt.Errorf("failed to create demo server %s: %w", demo.name, err)
+	}
+
+	if demo.stopped {
+		if err := kubernetes.SetDeploymentReplicas(ctx, namespace, deploymentName, 0); err != nil {
+			return fmt.Errorf("failed to stop demo server %s: %w", demo.name, err)
+		}
+	}
+	return nil
+}
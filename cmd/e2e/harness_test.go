@@ -0,0 +1,228 @@
+//go:build e2e
+
+package e2e
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"minecharts/cmd/api"
+	"minecharts/cmd/config"
+	"minecharts/cmd/database"
+	"minecharts/cmd/kubernetes"
+	"minecharts/cmd/scheduler"
+
+	"github.com/gin-gonic/gin"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// harness runs the API in-process against a kind cluster, in a namespace of its own.
+type harness struct {
+	cluster        string
+	createdCluster bool
+	workDir        string
+	server         *httptest.Server
+	db             database.DB // Nil until startAPI opened the database
+	token          string
+	stopWatchers   context.CancelFunc
+}
+
+// startCluster creates the kind cluster unless it already exists, and writes its
+// kubeconfig to the work directory without changing the current context.
+func (h *harness) startCluster() (string, error) {
+	out, err := exec.Command("kind", "get", "clusters").Output()
+	if err != nil {
+		return "", fmt.Errorf("kind is required to run the e2e suite: %w", err)
+	}
+	exists := false
+	for _, name := range strings.Fields(string(out)) {
+		exists = exists || name == h.cluster
+	}
+	if !exists {
+		logf("Creating kind cluster %s", h.cluster)
+		cmd := exec.Command("kind", "create", "cluster", "--name", h.cluster, "--wait", "2m",
+			"--kubeconfig", filepath.Join(h.workDir, "kind-create.kubeconfig"))
+		cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+		if err := cmd.Run(); err != nil {
+			return "", fmt.Errorf("failed to create kind cluster: %w", err)
+		}
+		h.createdCluster = true
+	}
+
+	kubeconfig, err := exec.Command("kind", "get", "kubeconfig", "--name", h.cluster).Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to get kind kubeconfig: %w", err)
+	}
+	path := filepath.Join(h.workDir, "kubeconfig")
+	if err := os.WriteFile(path, kubeconfig, 0o600); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// startAPI initializes the API like main does, with a throwaway SQLite database,
+// and serves it on a local port.
+func (h *harness) startAPI(kubeconfig string) error {
+	config.DefaultNamespace = fmt.Sprintf("minecharts-e2e-%d", time.Now().Unix())
+	config.StorageClass = "standard" // kind's local-path provisioner
+	config.DatabaseType = database.SQLite
+	config.DatabaseConnectionString = filepath.Join(h.workDir, "minecharts.db")
+	config.RequireServerApproval = false
+	config.DowntimeWarnings = "" // The backup step runs the task as soon as it is due
+	c := config.Current()
+	c.OAuthEnabled, c.AuthentikEnabled, c.GitHubEnabled, c.GoogleEnabled = false, false, false, false
+	config.Apply(c)
+
+	// kubernetes.Init reads the kubeconfig from its own flag
+	if err := flag.Set("kubeconfig", kubeconfig); err != nil {
+		return err
+	}
+	if err := kubernetes.Init(); err != nil {
+		return fmt.Errorf("failed to initialize Kubernetes client: %w", err)
+	}
+	if err := database.InitDB(config.DatabaseType, config.DatabaseConnectionString, config.DatabaseReplicaConnectionString, database.PoolConfig{
+		MaxOpenConns:    config.DatabaseMaxOpenConns,
+		MaxIdleConns:    config.DatabaseMaxIdleConns,
+		ConnMaxLifetime: config.DatabaseConnMaxLifetime,
+	}); err != nil {
+		return fmt.Errorf("failed to initialize database: %w", err)
+	}
+	if err := database.EnableCache(database.CacheConfig{
+		TTL:           config.CacheTTL,
+		RedisURL:      config.CacheRedisURL,
+		TouchInterval: config.APIKeyTouchInterval,
+	}); err != nil {
+		return fmt.Errorf("failed to initialize the database cache: %w", err)
+	}
+	h.db = database.GetDB()
+
+	ctx := context.Background()
+	if _, err := kubernetes.Clientset.CoreV1().Namespaces().Create(ctx, &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: config.DefaultNamespace},
+	}, metav1.CreateOptions{}); err != nil {
+		return fmt.Errorf("failed to create namespace: %w", err)
+	}
+
+	watcherCtx, stop := context.WithCancel(ctx)
+	h.stopWatchers = stop
+	kubernetes.StartServerWatcher(watcherCtx, config.DefaultNamespace, database.GetDB())
+	kubernetes.StartReconciler(watcherCtx, config.DefaultNamespace, database.GetDB(), 10*time.Second)
+	scheduler.Start(watcherCtx, config.DefaultNamespace, database.GetDB(), 5*time.Second)
+
+	gin.SetMode(gin.ReleaseMode)
+	router := gin.New()
+	if err := api.SetupRoutes(router); err != nil {
+		return fmt.Errorf("failed to set up routes: %w", err)
+	}
+	h.server = httptest.NewServer(router)
+	logf("API serving on %s, namespace %s", h.server.URL, config.DefaultNamespace)
+	return nil
+}
+
+// close tears down what the harness created. The cluster is only deleted when the
+// harness created it and keep is false.
+func (h *harness) close(keep bool) {
+	if h.server != nil {
+		h.server.Close()
+	}
+	if h.stopWatchers != nil {
+		h.stopWatchers()
+	}
+	if kubernetes.Clientset != nil && config.DefaultNamespace != "" && !keep {
+		_ = kubernetes.Clientset.CoreV1().Namespaces().Delete(context.Background(), config.DefaultNamespace, metav1.DeleteOptions{})
+	}
+	// database.GetDB would open a default database in the package directory
+	if h.db != nil {
+		h.db.Close()
+	}
+	if h.createdCluster && !keep {
+		logf("Deleting kind cluster %s", h.cluster)
+		_ = exec.Command("kind", "delete", "cluster", "--name", h.cluster).Run()
+	}
+}
+
+// call sends a request to the API with the harness token and decodes the JSON
+// response into out when it is not nil.
+func (h *harness) call(method, path string, body interface{}, out interface{}) (int, error) {
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		reader = bytes.NewReader(payload)
+	}
+
+	req, err := http.NewRequest(method, h.server.URL+path, reader)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if h.token != "" {
+		req.Header.Set("Authorization", "Bearer "+h.token)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, err
+	}
+	if out != nil && len(data) > 0 {
+		if err := json.Unmarshal(data, out); err != nil {
+			return resp.StatusCode, fmt.Errorf("invalid response %q: %w", data, err)
+		}
+	}
+	return resp.StatusCode, nil
+}
+
+// expect calls the API and fails unless it answers with the given status.
+func (h *harness) expect(status int, method, path string, body interface{}, out interface{}) error {
+	var raw json.RawMessage
+	code, err := h.call(method, path, body, &raw)
+	if err != nil {
+		return fmt.Errorf("%s %s: %w", method, path, err)
+	}
+	if code != status {
+		return fmt.Errorf("%s %s: expected status %d, got %d: %s", method, path, status, code, raw)
+	}
+	if out != nil {
+		return json.Unmarshal(raw, out)
+	}
+	return nil
+}
+
+// eventually polls check until it succeeds or the timeout expires, returning its last error.
+func eventually(timeout, interval time.Duration, check func() error) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		err := check()
+		if err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out after %s: %w", timeout, err)
+		}
+		time.Sleep(interval)
+	}
+}
+
+func logf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "e2e: "+format+"\n", args...)
+}
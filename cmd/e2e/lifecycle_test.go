@@ -0,0 +1,231 @@
+//go:build e2e
+
+package e2e
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"minecharts/cmd/config"
+	"minecharts/cmd/database"
+	"minecharts/cmd/kubernetes"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// step is one stage of a scenario, run in order; a failed step stops the scenario.
+type step struct {
+	name string
+	run  func(h *harness) error
+}
+
+// lifecycleServer is the server created by the lifecycle scenario.
+const lifecycleServer = "e2e-lifecycle"
+
+// lifecycleSteps creates a server, waits for it to accept players, exposes it,
+// runs a command on it, backs it up and deletes it, checking the cluster and the
+// database after each call. readyTimeout covers the image pull on a fresh cluster.
+func lifecycleSteps(readyTimeout time.Duration) []step {
+	deploymentName := config.DeploymentPrefix + lifecycleServer
+	pvcName := deploymentName + config.PVCSuffix
+	serviceName := deploymentName + "-svc"
+	tasksPath := "/servers/" + lifecycleServer + "/tasks"
+	var backupTask int64
+
+	return []step{
+		{"set up the administrator", func(h *harness) error {
+			var resp struct {
+				Token string `json:"token"`
+			}
+			if err := h.expect(http.StatusCreated, http.MethodPost, "/setup/admin", map[string]string{
+				"username": "e2e-admin",
+				"email":    "e2e-admin@minecharts.local",
+				"password": "e2e-password",
+			}, &resp); err != nil {
+				return err
+			}
+			h.token = resp.Token
+			return nil
+		}},
+
+		{"create the server", func(h *harness) error {
+			if err := h.expect(http.StatusOK, http.MethodPost, "/servers", map[string]interface{}{
+				"serverName":  lifecycleServer,
+				"serverType":  "vanilla",
+				"memory":      "1G",
+				"storageSize": "1Gi",
+			}, nil); err != nil {
+				return err
+			}
+
+			ctx := context.Background()
+			deployment, err := kubernetes.Clientset.AppsV1().Deployments(config.DefaultNamespace).Get(ctx, deploymentName, metav1.GetOptions{})
+			if err != nil {
+				return fmt.Errorf("deployment not created: %w", err)
+			}
+			if deployment.Labels["created-by"] != "minecharts-api" || deployment.Labels["app"] != deploymentName {
+				return fmt.Errorf("unexpected deployment labels %v", deployment.Labels)
+			}
+			if _, err := kubernetes.Clientset.CoreV1().PersistentVolumeClaims(config.DefaultNamespace).Get(ctx, pvcName, metav1.GetOptions{}); err != nil {
+				return fmt.Errorf("PVC not created: %w", err)
+			}
+
+			server, err := database.GetDB().GetServerByName(ctx, lifecycleServer)
+			if err != nil {
+				return fmt.Errorf("server not recorded: %w", err)
+			}
+			if server.DeploymentName != deploymentName || server.PVCName != pvcName {
+				return fmt.Errorf("unexpected server record %+v", server)
+			}
+			if server.Status != database.ServerStatusStarting && server.Status != database.ServerStatusRunning {
+				return fmt.Errorf("expected the server to be starting, got %s", server.Status)
+			}
+			return nil
+		}},
+
+		{"wait for the server to be running", func(h *harness) error {
+			return eventually(readyTimeout, 5*time.Second, func() error {
+				var status struct {
+					Status string `json:"status"`
+					Reason string `json:"reason"`
+				}
+				if err := h.expect(http.StatusOK, http.MethodGet, "/servers/"+lifecycleServer+"/status", nil, &status); err != nil {
+					return err
+				}
+				if status.Status != database.ServerStatusRunning {
+					return fmt.Errorf("server is %s %s", status.Status, status.Reason)
+				}
+
+				server, err := database.GetDB().GetServerByName(context.Background(), lifecycleServer)
+				if err != nil {
+					return err
+				}
+				if server.Status != database.ServerStatusRunning {
+					return fmt.Errorf("server recorded as %s", server.Status)
+				}
+				return nil
+			})
+		}},
+
+		{"expose the server", func(h *harness) error {
+			if err := h.expect(http.StatusOK, http.MethodPost, "/servers/"+lifecycleServer+"/expose", map[string]interface{}{
+				"exposureType": "NodePort",
+			}, nil); err != nil {
+				return err
+			}
+
+			service, err := kubernetes.Clientset.CoreV1().Services(config.DefaultNamespace).Get(context.Background(), serviceName, metav1.GetOptions{})
+			if err != nil {
+				return fmt.Errorf("service not created: %w", err)
+			}
+			if service.Spec.Type != corev1.ServiceTypeNodePort || service.Spec.Selector["app"] != deploymentName {
+				return fmt.Errorf("unexpected service type %s and selector %v", service.Spec.Type, service.Spec.Selector)
+			}
+			if len(service.Spec.Ports) == 0 || service.Spec.Ports[0].NodePort == 0 {
+				return fmt.Errorf("no node port allocated")
+			}
+			return nil
+		}},
+
+		{"execute a command", func(h *harness) error {
+			var resp map[string]interface{}
+			if err := h.expect(http.StatusOK, http.MethodPost, "/servers/"+lifecycleServer+"/exec", map[string]string{
+				"command": "list",
+			}, &resp); err != nil {
+				return err
+			}
+			if output := fmt.Sprint(resp); !strings.Contains(output, "players online") {
+				return fmt.Errorf("unexpected command output %s", output)
+			}
+			return nil
+		}},
+
+		{"trigger a backup", func(h *harness) error {
+			// Backups run as scheduled tasks, this one due at the next minute
+			var task database.ScheduledTask
+			if err := h.expect(http.StatusCreated, http.MethodPost, tasksPath, map[string]interface{}{
+				"name":     "e2e backup",
+				"schedule": "* * * * *",
+				"action":   database.TaskActionBackup,
+			}, &task); err != nil {
+				return err
+			}
+			if task.ID == 0 || task.Action != database.TaskActionBackup {
+				return fmt.Errorf("unexpected scheduled task %+v", task)
+			}
+			backupTask = task.ID
+			return nil
+		}},
+
+		{"wait for the backup", func(h *harness) error {
+			runsPath := fmt.Sprintf("%s/%d/runs", tasksPath, backupTask)
+			return eventually(2*time.Minute+config.BackupTimeout, 5*time.Second, func() error {
+				var runs []database.TaskRun
+				if err := h.expect(http.StatusOK, http.MethodGet, runsPath, nil, &runs); err != nil {
+					return err
+				}
+				for _, run := range runs {
+					switch run.Status {
+					case database.TaskRunSucceeded:
+						return nil
+					case database.TaskRunFailed, database.TaskRunSkipped:
+						return fmt.Errorf("backup run %s: %s", run.Status, run.Output)
+					}
+				}
+				return fmt.Errorf("no finished backup run among %d runs", len(runs))
+			})
+		}},
+
+		{"list the backups", func(h *harness) error {
+			var runs []database.TaskRun
+			if err := h.expect(http.StatusOK, http.MethodGet, fmt.Sprintf("%s/%d/runs", tasksPath, backupTask), nil, &runs); err != nil {
+				return err
+			}
+			var archives []string
+			for _, run := range runs {
+				if archive, ok := strings.CutPrefix(run.Output, "Backup written to "); ok && run.Status == database.TaskRunSucceeded {
+					archives = append(archives, archive)
+				}
+			}
+			if len(archives) == 0 {
+				return fmt.Errorf("no backup archive among the runs %+v", runs)
+			}
+			if !strings.HasPrefix(archives[0], kubernetes.BackupDir+"/") || !strings.HasSuffix(archives[0], ".tar.gz") {
+				return fmt.Errorf("unexpected backup archive %q", archives[0])
+			}
+
+			// No other backup is started while the server is deleted
+			return h.expect(http.StatusOK, http.MethodDelete, fmt.Sprintf("%s/%d", tasksPath, backupTask), nil, nil)
+		}},
+
+		{"delete the server", func(h *harness) error {
+			if err := h.expect(http.StatusOK, http.MethodPost, "/servers/"+lifecycleServer+"/delete", nil, nil); err != nil {
+				return err
+			}
+
+			if _, err := database.GetDB().GetServerByName(context.Background(), lifecycleServer); err == nil {
+				return fmt.Errorf("server record not deleted")
+			}
+
+			// The PVC is released once the pod is gone
+			ctx := context.Background()
+			return eventually(2*time.Minute, 2*time.Second, func() error {
+				if _, err := kubernetes.Clientset.AppsV1().Deployments(config.DefaultNamespace).Get(ctx, deploymentName, metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+					return fmt.Errorf("deployment not deleted: %v", err)
+				}
+				if _, err := kubernetes.Clientset.CoreV1().Services(config.DefaultNamespace).Get(ctx, serviceName, metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+					return fmt.Errorf("service not deleted: %v", err)
+				}
+				if _, err := kubernetes.Clientset.CoreV1().PersistentVolumeClaims(config.DefaultNamespace).Get(ctx, pvcName, metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+					return fmt.Errorf("PVC not deleted: %v", err)
+				}
+				return nil
+			})
+		}},
+	}
+}
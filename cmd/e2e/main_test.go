@@ -0,0 +1,76 @@
+//go:build e2e
+
+// Package e2e holds the end-to-end suite: the API is started in-process against a
+// kind cluster and a server is taken through its whole lifecycle, checking the
+// Kubernetes resources and the database along the way.
+//
+// It requires Docker and kind, and is excluded from regular builds:
+//
+//	go test -tags e2e -timeout 30m ./cmd/e2e
+//
+// The kind cluster is created when missing and deleted afterwards unless it
+// already existed or -keep is set, passed after -args.
+package e2e
+
+import (
+	"flag"
+	"os"
+	"testing"
+	"time"
+
+	"minecharts/cmd/logging"
+)
+
+var (
+	clusterName  = flag.String("cluster", "minecharts-e2e", "name of the kind cluster")
+	keep         = flag.Bool("keep", false, "keep the cluster and the test namespace after the run")
+	readyTimeout = flag.Duration("ready-timeout", 10*time.Minute, "how long to wait for a server to accept players")
+)
+
+// suite is the harness shared by the tests, set up once by TestMain.
+var suite *harness
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	logging.Init()
+	os.Exit(run(m))
+}
+
+// run sets up the cluster and the API, then runs the tests against them.
+func run(m *testing.M) int {
+	workDir, err := os.MkdirTemp("", "minecharts-e2e-")
+	if err != nil {
+		logf("FAIL: %v", err)
+		return 1
+	}
+	defer os.RemoveAll(workDir)
+
+	suite = &harness{cluster: *clusterName, workDir: workDir}
+	defer suite.close(*keep)
+
+	kubeconfig, err := suite.startCluster()
+	if err != nil {
+		logf("FAIL: %v", err)
+		return 1
+	}
+	if err := suite.startAPI(kubeconfig); err != nil {
+		logf("FAIL: %v", err)
+		return 1
+	}
+	return m.Run()
+}
+
+// TestLifecycle runs the lifecycle steps in order, as subtests; the steps after a
+// failed one are skipped as they build on it.
+func TestLifecycle(t *testing.T) {
+	for _, s := range lifecycleSteps(*readyTimeout) {
+		ok := t.Run(s.name, func(t *testing.T) {
+			if err := s.run(suite); err != nil {
+				t.Fatal(err)
+			}
+		})
+		if !ok {
+			t.Fatalf("step %q failed, skipping the next steps", s.name)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+// Package errreport reports the panics of API requests to an error tracker speaking
+// the Sentry protocol, such as Sentry itself or GlitchTip. Reports are delivered
+// asynchronously so a slow tracker never delays API requests.
+package errreport
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"minecharts/cmd/config"
+	"minecharts/cmd/logging"
+)
+
+// queueSize bounds the number of reports waiting for delivery; reports are dropped
+// when the tracker cannot keep up.
+const queueSize = 64
+
+// clientName identifies the API to the tracker.
+const clientName = "minecharts-api/0.1"
+
+// Report is a panic of a request.
+type Report struct {
+	Message   string
+	Stack     string
+	RequestID string
+	Method    string
+	Path      string
+	Route     string
+	UserID    int64
+	Username  string
+	Tags      map[string]string // Context such as the server the request was about
+}
+
+// sentryEvent is the event payload of the Sentry store endpoint.
+type sentryEvent struct {
+	EventID     string            `json:"event_id"`
+	Timestamp   string            `json:"timestamp"`
+	Level       string            `json:"level"`
+	Platform    string            `json:"platform"`
+	Logger      string            `json:"logger"`
+	ServerName  string            `json:"server_name,omitempty"`
+	Environment string            `json:"environment,omitempty"`
+	Message     string            `json:"message"`
+	Transaction string            `json:"transaction,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+	User        *sentryUser       `json:"user,omitempty"`
+	Request     *sentryRequest    `json:"request,omitempty"`
+	Extra       map[string]string `json:"extra,omitempty"`
+}
+
+type sentryUser struct {
+	ID       string `json:"id"`
+	Username string `json:"username,omitempty"`
+}
+
+type sentryRequest struct {
+	Method string `json:"method"`
+	URL    string `json:"url"`
+}
+
+type reporter struct {
+	storeURL string
+	auth     string
+	client   *http.Client
+	queue    chan *sentryEvent
+	dropped  atomic.Int64
+	done     chan struct{}
+}
+
+var (
+	current  atomic.Pointer[reporter]
+	initOnce sync.Once
+)
+
+// Init starts the reporting configured by MINECHARTS_ERROR_REPORTING_DSN. Reporting
+// stays disabled when no DSN is configured.
+func Init() error {
+	var err error
+	initOnce.Do(func() {
+		if config.ErrorReportingDSN == "" {
+			logging.API.Debug("Error reporting disabled: no DSN configured")
+			return
+		}
+
+		var r *reporter
+		r, err = newReporter(config.ErrorReportingDSN)
+		if err != nil {
+			logging.API.WithFields(
+				"error", err.Error(),
+			).Error("Failed to configure error reporting")
+			return
+		}
+		go r.run()
+		current.Store(r)
+
+		logging.API.WithFields(
+			"store_url", r.storeURL,
+			"environment", config.ErrorReportingEnvironment,
+		).Info("Error reporting enabled")
+	})
+	return err
+}
+
+// newReporter parses a DSN such as https://<key>@glitchtip.example.com/<project>.
+func newReporter(dsn string) (*reporter, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("invalid error reporting DSN: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported error reporting DSN scheme %q", u.Scheme)
+	}
+	if u.User == nil || u.User.Username() == "" {
+		return nil, fmt.Errorf("error reporting DSN has no public key")
+	}
+	path := strings.Trim(u.Path, "/")
+	slash := strings.LastIndex(path, "/")
+	project := path[slash+1:]
+	if project == "" {
+		return nil, fmt.Errorf("error reporting DSN has no project ID")
+	}
+	prefix := ""
+	if slash >= 0 {
+		prefix = "/" + path[:slash]
+	}
+
+	auth := "Sentry sentry_version=7, sentry_client=" + clientName + ", sentry_key=" + u.User.Username()
+	if secret, ok := u.User.Password(); ok {
+		auth += ", sentry_secret=" + secret
+	}
+	return &reporter{
+		storeURL: fmt.Sprintf("%s://%s%s/api/%s/store/", u.Scheme, u.Host, prefix, project),
+		auth:     auth,
+		client:   &http.Client{Timeout: 5 * time.Second},
+		queue:    make(chan *sentryEvent, queueSize),
+		done:     make(chan struct{}),
+	}, nil
+}
+
+// Capture queues a report for delivery. It never blocks; when reporting is disabled
+// the report is discarded.
+func Capture(report Report) {
+	r := current.Load()
+	if r == nil {
+		return
+	}
+
+	event := &sentryEvent{
+		EventID:     newEventID(),
+		Timestamp:   time.Now().UTC().Format(time.RFC3339),
+		Level:       "error",
+		Platform:    "go",
+		Logger:      "minecharts-api",
+		Environment: config.ErrorReportingEnvironment,
+		Message:     logging.Redact(report.Message),
+		Transaction: report.Route,
+		Tags:        map[string]string{"request_id": report.RequestID},
+		Request:     &sentryRequest{Method: report.Method, URL: logging.Redact(report.Path)},
+		Extra:       map[string]string{"stack": report.Stack},
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		event.ServerName = hostname
+	}
+	for key, value := range report.Tags {
+		event.Tags[key] = value
+	}
+	if report.UserID != 0 {
+		event.User = &sentryUser{ID: fmt.Sprint(report.UserID), Username: report.Username}
+	}
+
+	select {
+	case r.queue <- event:
+	default:
+		if dropped := r.dropped.Add(1); dropped == 1 || dropped%10 == 0 {
+			logging.API.WithFields(
+				"dropped_reports", dropped,
+			).Warn("Error report queue full, dropping reports")
+		}
+	}
+}
+
+// Close flushes queued reports, waiting at most timeout.
+func Close(timeout time.Duration) {
+	r := current.Swap(nil)
+	if r == nil {
+		return
+	}
+	close(r.queue)
+	select {
+	case <-r.done:
+	case <-time.After(timeout):
+		logging.API.Warn("Timed out flushing error reports")
+	}
+}
+
+func (r *reporter) run() {
+	defer close(r.done)
+	for event := range r.queue {
+		if err := r.send(event); err != nil {
+			logging.API.WithFields(
+				"event_id", event.EventID,
+				"error", err.Error(),
+			).Warn("Failed to deliver error report")
+		}
+	}
+}
+
+func (r *reporter) send(event *sentryEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, r.storeURL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Sentry-Auth", r.auth)
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("error tracker answered %s", resp.Status)
+	}
+	return nil
+}
+
+// newEventID returns a random event ID, 32 hex characters as the protocol expects.
+func newEventID() string {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return strings.Repeat("0", 32)
+	}
+	return hex.EncodeToString(buf)
+}
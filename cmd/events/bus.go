@@ -0,0 +1,188 @@
+// Package events is the in-process event bus of the API. The request handlers, the
+// Kubernetes watchers, the reconciler, the backups and the jobs publish what happens
+// on it, and the side effects consume it: the audit log and the webhooks register
+// handlers for the types they record or deliver, and the event streams served to
+// the web UI subscribe to it. With several replicas, the relay shares the events
+// that only one replica publishes through Redis.
+package events
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Type is the type of an event, which tells the type of its data.
+type Type string
+
+// Event types.
+const (
+	ServerStatus       Type = "server.status"       // A server changed status, Data is the *database.ServerStatusChange
+	ServerIncident     Type = "server.incident"     // A server container crashed, Data is the *database.Incident
+	ServerLog          Type = "server.log"          // A line of the server console, Data is a LogLine
+	JobProgress        Type = "job.progress"        // A job started, progressed or finished, Data is the *database.Job
+	BackupCompleted    Type = "backup.completed"    // A server volume was backed up, Data is a Backup
+	BackupFailed       Type = "backup.failed"       // The backup of a server volume failed, Data is a Backup
+	DeploymentOrphaned Type = "deployment.orphaned" // The reconciler found a deployment without server record, Data is an Orphan
+	APICall            Type = "api.call"            // A state-changing API call was answered, Data is the *database.AuditEvent
+)
+
+// subscriberBuffer is how many events a subscriber may lag behind before it is
+// dropped from the bus.
+const subscriberBuffer = 256
+
+// Event is a change published on the bus.
+type Event struct {
+	ID      uint64    `json:"id"` // Increasing within a run of the API
+	Type    Type      `json:"type"`
+	Server  string    `json:"server,omitempty"`
+	OwnerID int64     `json:"-"` // Owner of the server, for the access checks of the subscribers
+	Time    time.Time `json:"time"`
+	Data    any       `json:"data"`
+}
+
+// LogLine is the data of a ServerLog event.
+type LogLine struct {
+	Pod  string `json:"pod"`
+	Line string `json:"line"`
+}
+
+// Backup is the data of the BackupCompleted and BackupFailed events.
+type Backup struct {
+	Archive         string  `json:"archive,omitempty"`
+	DurationSeconds float64 `json:"duration_seconds"`
+	Error           string  `json:"error,omitempty"`
+}
+
+// Orphan is the data of a DeploymentOrphaned event.
+type Orphan struct {
+	Namespace  string `json:"namespace"`
+	Deployment string `json:"deployment"`
+}
+
+// Handler consumes the events of the types it is registered for, in the goroutine
+// publishing them. It must not block for long: handlers whose work is slow, such as
+// the webhooks, queue it.
+type Handler func(ctx context.Context, event Event)
+
+// Subscription receives the events of the bus matching its filter.
+type Subscription struct {
+	// Events delivers the events, and is closed when the subscription is closed,
+	// dropped for lagging behind or ended by the shutdown of the API.
+	Events <-chan Event
+
+	events chan Event
+	filter func(Event) bool
+	closed bool
+}
+
+var bus struct {
+	sync.Mutex
+	handlers    map[Type][]Handler
+	subscribers map[*Subscription]struct{}
+}
+
+var lastID atomic.Uint64
+
+// Handle registers a handler for the events of the given types. Handlers are
+// registered at startup and stay registered.
+func Handle(handler Handler, types ...Type) {
+	bus.Lock()
+	defer bus.Unlock()
+	if bus.handlers == nil {
+		bus.handlers = map[Type][]Handler{}
+	}
+	for _, eventType := range types {
+		bus.handlers[eventType] = append(bus.handlers[eventType], handler)
+	}
+}
+
+// Publish runs the handlers of an event, with ctx, then delivers it to the
+// subscribers whose filter it matches, and relays it to the other replicas when the
+// relay is started. Delivering never blocks: a subscriber whose buffer is full is
+// dropped, and sees its channel closed.
+func Publish(ctx context.Context, event Event) {
+	event.ID = lastID.Add(1)
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	bus.Lock()
+	handlers := bus.handlers[event.Type]
+	bus.Unlock()
+	for _, handler := range handlers {
+		handler(ctx, event)
+	}
+
+	if r := currentRelay.Load(); r != nil && relayedEvents[event.Type] {
+		r.enqueue(ctx, event)
+	}
+	deliver(event)
+}
+
+// deliver delivers an event to the subscribers whose filter it matches.
+func deliver(event Event) {
+	bus.Lock()
+	defer bus.Unlock()
+	for sub := range bus.subscribers {
+		if sub.filter != nil && !sub.filter(event) {
+			continue
+		}
+		select {
+		case sub.events <- event:
+		default:
+			sub.close()
+		}
+	}
+}
+
+// Subscribe subscribes to the events matching filter, every event when it is nil.
+// The filter runs while publishing and must be fast.
+func Subscribe(filter func(Event) bool) *Subscription {
+	sub := &Subscription{events: make(chan Event, subscriberBuffer), filter: filter}
+	sub.Events = sub.events
+
+	bus.Lock()
+	defer bus.Unlock()
+	if bus.subscribers == nil {
+		bus.subscribers = map[*Subscription]struct{}{}
+	}
+	bus.subscribers[sub] = struct{}{}
+	return sub
+}
+
+// Close ends the subscription. It may be called more than once.
+func (s *Subscription) Close() {
+	bus.Lock()
+	defer bus.Unlock()
+	s.close()
+}
+
+// close ends a subscription, with the bus locked.
+func (s *Subscription) close() {
+	if s.closed {
+		return
+	}
+	s.closed = true
+	delete(bus.subscribers, s)
+	close(s.events)
+}
+
+// CloseSubscriptions ends every subscription, so that the event streams end when the
+// API shuts down instead of holding it up.
+func CloseSubscriptions() {
+	bus.Lock()
+	defer bus.Unlock()
+	for sub := range bus.subscribers {
+		sub.close()
+	}
+}
+
+// Subscribers returns how many subscriptions are open, so that publishers can skip
+// work nobody listens to.
+func Subscribers() int {
+	bus.Lock()
+	defer bus.Unlock()
+	return len(bus.subscribers)
+}
@@ -0,0 +1,171 @@
+package events
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"minecharts/cmd/logging"
+	"minecharts/cmd/redis"
+)
+
+// relayChannel is the Redis channel on which the replicas relay their events.
+const relayChannel = "minecharts:events"
+
+// relayBuffer bounds the events waiting to be relayed. Past it they are dropped, so
+// that a slow Redis does not hold up the publishers.
+const relayBuffer = 1024
+
+// relayRetry spaces the subscriptions to the channel after a failure.
+const relayRetry = 5 * time.Second
+
+// relayTimeout bounds the relay of an event.
+const relayTimeout = 2 * time.Second
+
+// relayedEvents are the types of the events published by the replica where they
+// happen only. Every replica publishes the others on its own: the status transitions
+// read from the status change log, the incidents and orphans its watchers and
+// reconciler see, and the console lines it follows for its subscribers. The API
+// calls have no subscribers, and the handlers run where the events are published.
+var relayedEvents = map[Type]bool{
+	JobProgress:     true,
+	BackupCompleted: true,
+	BackupFailed:    true,
+}
+
+// relayedEvent is an event as relayed between the replicas.
+type relayedEvent struct {
+	Origin  string          `json:"origin"`
+	Type    Type            `json:"type"`
+	Server  string          `json:"server,omitempty"`
+	OwnerID int64           `json:"owner_id"`
+	Time    time.Time       `json:"time"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// relay sends the events published on this replica to the others, and delivers theirs.
+type relay struct {
+	origin   string // Tells the events of this replica apart when they come back
+	queue    chan relayedEvent
+	failures atomic.Int64
+}
+
+var currentRelay atomic.Pointer[relay]
+
+// StartRelay relays the events between the replicas of the API through the Redis of
+// a redis:// or rediss:// URL, until the context is cancelled, so that the event
+// streams of every replica see the job progress and backups of the others. The
+// events received are delivered to the subscribers only: their side effects, such as
+// the webhooks, ran on the replica publishing them.
+func StartRelay(ctx context.Context, url string) error {
+	client, err := redis.NewClient(url)
+	if err != nil {
+		return fmt.Errorf("event bus Redis: %w", err)
+	}
+	origin := make([]byte, 8)
+	if _, err := rand.Read(origin); err != nil {
+		client.Close()
+		return err
+	}
+	r := &relay{origin: hex.EncodeToString(origin), queue: make(chan relayedEvent, relayBuffer)}
+	currentRelay.Store(r)
+
+	go r.send(ctx, client)
+	go r.receive(ctx, client)
+	return nil
+}
+
+// enqueue queues an event published on this replica to be relayed. The data is
+// encoded at once, as publishers may keep changing it.
+func (r *relay) enqueue(ctx context.Context, event Event) {
+	data, err := json.Marshal(event.Data)
+	if err != nil {
+		r.failed(ctx, "encode", err)
+		return
+	}
+	select {
+	case r.queue <- relayedEvent{
+		Origin:  r.origin,
+		Type:    event.Type,
+		Server:  event.Server,
+		OwnerID: event.OwnerID,
+		Time:    event.Time,
+		Data:    data,
+	}:
+	default:
+		r.failed(ctx, "queue", fmt.Errorf("%d events waiting to be relayed", relayBuffer))
+	}
+}
+
+// send publishes the queued events on the channel until the context is cancelled.
+func (r *relay) send(ctx context.Context, client *redis.Client) {
+	defer client.Close()
+	for {
+		select {
+		case <-ctx.Done():
+			currentRelay.CompareAndSwap(r, nil)
+			return
+		case event := <-r.queue:
+			payload, _ := json.Marshal(event)
+			sendCtx, cancel := context.WithTimeout(ctx, relayTimeout)
+			_, err := client.Do(sendCtx, "PUBLISH", relayChannel, string(payload))
+			cancel()
+			if err != nil && ctx.Err() == nil {
+				r.failed(ctx, "publish", err)
+			}
+		}
+	}
+}
+
+// receive delivers the events of the other replicas until the context is cancelled,
+// subscribing again after a failure. The events relayed meanwhile are lost.
+func (r *relay) receive(ctx context.Context, client *redis.Client) {
+	for {
+		err := client.Subscribe(ctx, relayChannel, r.deliver)
+		if ctx.Err() != nil {
+			return
+		}
+		r.failed(ctx, "subscribe", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(relayRetry):
+		}
+	}
+}
+
+// deliver delivers an event relayed by another replica to the subscribers.
+func (r *relay) deliver(message string) {
+	var event relayedEvent
+	if err := json.Unmarshal([]byte(message), &event); err != nil {
+		r.failed(context.Background(), "decode", err)
+		return
+	}
+	if event.Origin == r.origin || !relayedEvents[event.Type] {
+		return
+	}
+	deliver(Event{
+		ID:      lastID.Add(1),
+		Type:    event.Type,
+		Server:  event.Server,
+		OwnerID: event.OwnerID,
+		Time:    event.Time,
+		Data:    event.Data,
+	})
+}
+
+// failed logs a failure of the relay, the first and then one in a hundred. The
+// events it failed to relay are only streamed by the replica publishing them.
+func (r *relay) failed(ctx context.Context, operation string, err error) {
+	if n := r.failures.Add(1); n == 1 || n%100 == 0 {
+		logging.API.WithContext(ctx).WithFields(
+			"operation", operation,
+			"failures", n,
+			"error", err.Error(),
+		).Warn("Event bus relay failed")
+	}
+}
@@ -0,0 +1,69 @@
+package events
+
+import (
+	"context"
+	"time"
+
+	"minecharts/cmd/database"
+	"minecharts/cmd/logging"
+)
+
+// statusFeedBatch bounds the status changes read at each poll.
+const statusFeedBatch = 100
+
+// StatusLog is the server status change log the status feed reads.
+type StatusLog interface {
+	ServerStatusCursor(ctx context.Context, at time.Time) (int64, error)
+	ListServerStatusChanges(ctx context.Context, afterID int64, limit int) ([]*database.ServerStatusChange, error)
+}
+
+// StartStatusFeed publishes the server status transitions on the bus until the context
+// is cancelled. They are read from the status change log, every interval while the
+// bus has subscribers, so that the transitions recorded by every replica of the API
+// are published, whichever replica the subscribers are connected to.
+func StartStatusFeed(ctx context.Context, store StatusLog, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		cursor := int64(-1)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			// Nobody missed the changes made while nobody listened
+			if Subscribers() == 0 {
+				cursor = -1
+				continue
+			}
+			if cursor < 0 {
+				var err error
+				if cursor, err = store.ServerStatusCursor(ctx, time.Now()); err != nil {
+					cursor = -1
+					continue
+				}
+			}
+
+			changes, err := store.ListServerStatusChanges(ctx, cursor, statusFeedBatch)
+			if err != nil {
+				logging.API.WithContext(ctx).WithFields(
+					"error", err.Error(),
+				).Warn("Failed to read the status change log for the event streams")
+				continue
+			}
+			for _, change := range changes {
+				cursor = change.ID
+				Publish(ctx, Event{
+					Type:    ServerStatus,
+					Server:  change.ServerName,
+					OwnerID: change.OwnerID,
+					Time:    change.ChangedAt,
+					Data:    change,
+				})
+			}
+		}
+	}()
+}
@@ -0,0 +1,137 @@
+// Package jobs runs the long-running operations of the API, such as clones, in the
+// background and records their progress, so handlers can answer 202 Accepted with a
+// job ID that clients poll.
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"minecharts/cmd/database"
+	"minecharts/cmd/events"
+	"minecharts/cmd/logging"
+	"minecharts/cmd/shutdown"
+)
+
+// Func performs the work of a job, reporting its progress as it goes, and returns
+// the message recorded when it succeeds.
+type Func func(ctx context.Context, progress Progress) (string, error)
+
+// Progress records how far a running job is, in percent, and what it is doing.
+type Progress func(percent int, message string)
+
+// Start records a pending job and runs fn in the background. The job keeps the values
+// of ctx, such as the actor of its Kubernetes changes, but not its cancellation, as it
+// outlives the request: it is bounded by timeout instead, and the API waits for it
+// when it shuts down. It returns shutdown.ErrShuttingDown once the API is stopping.
+func Start(ctx context.Context, job *database.Job, timeout time.Duration, fn Func) error {
+	db := database.GetDB()
+
+	tracked, done, ok := shutdown.Track(ctx)
+	if !ok {
+		return shutdown.ErrShuttingDown
+	}
+	job.Status = database.JobPending
+	if err := db.CreateJob(ctx, job); err != nil {
+		done()
+		return err
+	}
+
+	logging.API.WithContext(ctx).WithFields(
+		"job_id", job.ID,
+		"job_type", job.Type,
+		"server_name", job.ServerName,
+		"user_id", job.CreatedBy,
+	).Info("Job started")
+
+	jobCtx, cancel := context.WithTimeout(tracked, timeout)
+	go func() {
+		defer done()
+		defer cancel()
+		run(jobCtx, job, timeout, fn)
+	}()
+	return nil
+}
+
+// run performs a job and records its outcome.
+func run(ctx context.Context, job *database.Job, timeout time.Duration, fn Func) {
+	db := database.GetDB()
+	// The outcome is recorded even when the job ran out of time
+	storeCtx := context.WithoutCancel(ctx)
+
+	started := time.Now()
+	job.Status = database.JobRunning
+	job.StartedAt = &started
+	update(storeCtx, db, job)
+
+	progress := func(percent int, message string) {
+		job.Progress = min(max(percent, 0), 100)
+		job.Message = message
+		update(storeCtx, db, job)
+	}
+
+	message, err := fn(ctx, progress)
+
+	finished := time.Now()
+	job.FinishedAt = &finished
+	if err != nil {
+		switch {
+		case shutdown.Interrupted(ctx):
+			err = fmt.Errorf("job %w: %w", shutdown.ErrInterrupted, err)
+		case errors.Is(err, context.DeadlineExceeded) && ctx.Err() != nil:
+			err = fmt.Errorf("job did not complete within %s: %w", timeout, err)
+		}
+		job.Status = database.JobFailed
+		job.Error = err.Error()
+		logging.API.WithContext(ctx).WithFields(
+			"job_id", job.ID,
+			"job_type", job.Type,
+			"server_name", job.ServerName,
+			"error", err.Error(),
+		).Warn("Job failed")
+	} else {
+		job.Status = database.JobSucceeded
+		job.Progress = 100
+		job.Message = message
+		logging.API.WithContext(ctx).WithFields(
+			"job_id", job.ID,
+			"job_type", job.Type,
+			"server_name", job.ServerName,
+			"duration", finished.Sub(started).String(),
+		).Info("Job completed")
+	}
+	update(storeCtx, db, job)
+}
+
+// update records the progress of a job and publishes it on the event bus.
+func update(ctx context.Context, db database.DB, job *database.Job) {
+	if err := db.UpdateJob(ctx, job); err != nil {
+		logging.API.WithContext(ctx).WithFields(
+			"job_id", job.ID,
+			"error", err.Error(),
+		).Warn("Failed to record job progress")
+	}
+	snapshot := *job
+	events.Publish(ctx, events.Event{
+		Type:    events.JobProgress,
+		Server:  job.ServerName,
+		OwnerID: job.OwnerID,
+		Data:    &snapshot,
+	})
+}
+
+// FailInterrupted records the jobs left unfinished by a previous run of the API as
+// failed, as nothing runs them anymore.
+func FailInterrupted(ctx context.Context) {
+	count, err := database.GetDB().FailUnfinishedJobs(ctx, "Interrupted by a restart of the API")
+	if err != nil {
+		return
+	}
+	if count > 0 {
+		logging.API.WithContext(ctx).WithFields(
+			"jobs", count,
+		).Warn("Jobs interrupted by a restart of the API marked as failed")
+	}
+}
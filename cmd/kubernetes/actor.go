@@ -0,0 +1,69 @@
+package kubernetes
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Annotations recording who last changed a managed object, so that changes seen
+// with kubectl can be traced back to the user and API request behind them.
+const (
+	LastModifiedByAnnotation = "minecharts.io/last-modified-by"
+	LastRequestIDAnnotation  = "minecharts.io/last-request-id"
+	LastModifiedAtAnnotation = "minecharts.io/last-modified-at"
+)
+
+// FieldManager is the manager recorded in the managed fields of the objects the
+// API creates and updates.
+const FieldManager = "minecharts-api"
+
+// Actor is the initiator of the changes made to the cluster with a context.
+// Background components use a "system:" username.
+type Actor struct {
+	Username  string
+	UserID    int64
+	RequestID string
+}
+
+type actorKey struct{}
+
+// WithActor returns a context recording the actor of the changes made with it.
+func WithActor(ctx context.Context, actor Actor) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFrom returns the actor recorded in the context.
+func ActorFrom(ctx context.Context) (Actor, bool) {
+	actor, ok := ctx.Value(actorKey{}).(Actor)
+	return actor, ok
+}
+
+// annotateChange records the actor of the context on an object about to be created
+// or updated. Changes without an actor are recorded as made by the API itself.
+func annotateChange(ctx context.Context, obj metav1.Object) {
+	actor, _ := ActorFrom(ctx)
+
+	annotations := obj.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+
+	modifiedBy := actor.Username
+	switch {
+	case modifiedBy == "":
+		modifiedBy = "system:" + FieldManager
+	case actor.UserID != 0:
+		modifiedBy += " (" + strconv.FormatInt(actor.UserID, 10) + ")"
+	}
+	annotations[LastModifiedByAnnotation] = modifiedBy
+	annotations[LastModifiedAtAnnotation] = time.Now().UTC().Format(time.RFC3339)
+	if actor.RequestID != "" {
+		annotations[LastRequestIDAnnotation] = actor.RequestID
+	} else {
+		delete(annotations, LastRequestIDAnnotation)
+	}
+	obj.SetAnnotations(annotations)
+}
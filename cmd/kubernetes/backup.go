@@ -0,0 +1,75 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"minecharts/cmd/config"
+	"minecharts/cmd/database"
+	"minecharts/cmd/events"
+	"minecharts/cmd/logging"
+	"minecharts/cmd/metrics"
+)
+
+// BackupDir is the directory of the server volume where backups are written.
+const BackupDir = "/data/backups"
+
+// BackupJobName returns the name of the job backing up a server's volume.
+func BackupJobName(deploymentName string) string {
+	return deploymentName + "-backup"
+}
+
+// BackupVolume archives the data of a server's volume into its backups directory
+// with a job, and waits for the job to complete. It returns the path of the archive
+// in the server container, and publishes the outcome on the event bus. The world
+// should be saved beforehand when the server runs.
+func BackupVolume(ctx context.Context, namespace string, server *database.MinecraftServer) (string, error) {
+	deploymentName, volume := server.DeploymentName, server.PVCName
+	jobName := BackupJobName(deploymentName)
+	archive := fmt.Sprintf("%s/%s.tar.gz", BackupDir, time.Now().UTC().Format("20060102-150405"))
+
+	logging.K8s.WithContext(ctx).WithFields(
+		"namespace", namespace,
+		"job_name", jobName,
+		"volume", volume,
+		"archive", archive,
+	).Info("Backing up server data")
+
+	start := time.Now()
+	err := runVolumeJob(ctx, namespace, volumeJob{
+		name: jobName,
+		app:  deploymentName,
+		near: deploymentName,
+		command: fmt.Sprintf("mkdir -p %s && tar czf %s.tmp --exclude=./backups -C /data . && mv %s.tmp %s",
+			BackupDir, archive, archive, archive),
+		mounts:   []volumeJobMount{{volume: volume, path: "/data"}},
+		deadline: config.BackupTimeout,
+	})
+	backup := events.Backup{DurationSeconds: time.Since(start).Seconds()}
+	if err != nil {
+		metrics.BackupDuration.Observe(backup.DurationSeconds, "failed")
+		backup.Error = err.Error()
+		publishBackup(ctx, server, events.BackupFailed, backup)
+		return "", fmt.Errorf("failed to back up server data: %w", err)
+	}
+	metrics.BackupDuration.Observe(backup.DurationSeconds, "succeeded")
+	backup.Archive = archive
+	publishBackup(ctx, server, events.BackupCompleted, backup)
+
+	logging.K8s.WithContext(ctx).WithFields(
+		"namespace", namespace,
+		"job_name", jobName,
+		"archive", archive,
+	).Info("Server data backed up successfully")
+	return archive, nil
+}
+
+func publishBackup(ctx context.Context, server *database.MinecraftServer, eventType events.Type, backup events.Backup) {
+	events.Publish(ctx, events.Event{
+		Type:    eventType,
+		Server:  server.ServerName,
+		OwnerID: server.OwnerID,
+		Data:    backup,
+	})
+}
@@ -0,0 +1,205 @@
+package kubernetes
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"minecharts/cmd/apierror"
+	"minecharts/cmd/config"
+	"minecharts/cmd/logging"
+
+	"github.com/gin-gonic/gin"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ErrClusterUnreachable is returned instead of calling the Kubernetes API while
+// the circuit breaker is open.
+var ErrClusterUnreachable = errors.New("kubernetes cluster unreachable")
+
+type circuitState int
+
+const (
+	circuitClosed circuitState = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+func (s circuitState) String() string {
+	switch s {
+	case circuitOpen:
+		return "open"
+	case circuitHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// circuitBreaker stops calling the API server after a run of consecutive failures.
+// Once the cooldown has elapsed a single probe request is let through: its success
+// closes the circuit again, its failure keeps it open for another cooldown.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	state     circuitState
+	failures  int
+	openedAt  time.Time
+	threshold int
+	cooldown  time.Duration
+}
+
+// breaker guards every request made with the Kubernetes client configuration.
+var breaker = &circuitBreaker{
+	threshold: config.KubernetesBreakerThreshold,
+	cooldown:  config.KubernetesBreakerCooldown,
+}
+
+// allow reports whether a request may be sent to the API server.
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case circuitOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.setState(circuitHalfOpen)
+		return true
+	case circuitHalfOpen:
+		// A probe is already in flight
+		return false
+	default:
+		return true
+	}
+}
+
+// record updates the breaker with the outcome of a request.
+func (b *circuitBreaker) record(success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if success {
+		b.failures = 0
+		b.setState(circuitClosed)
+		return
+	}
+
+	b.failures++
+	if b.state == circuitHalfOpen || b.failures >= b.threshold {
+		b.openedAt = time.Now()
+		b.setState(circuitOpen)
+	}
+}
+
+// abandon is called when a request ended without telling anything about the API
+// server, e.g. cancelled by its caller, so a pending probe can be retried.
+func (b *circuitBreaker) abandon() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == circuitHalfOpen {
+		b.openedAt = time.Now().Add(-b.cooldown)
+		b.setState(circuitOpen)
+	}
+}
+
+// retryAfter returns how long until the next probe, or zero when requests are allowed.
+func (b *circuitBreaker) retryAfter() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == circuitClosed {
+		return 0
+	}
+	if remaining := b.cooldown - time.Since(b.openedAt); remaining > 0 {
+		return remaining
+	}
+	return 0
+}
+
+// setState must be called with the lock held.
+func (b *circuitBreaker) setState(state circuitState) {
+	if b.state == state {
+		return
+	}
+
+	fields := logging.K8s.WithFields(
+		"from", b.state.String(),
+		"to", state.String(),
+		"failures", b.failures,
+	)
+	switch state {
+	case circuitOpen:
+		if b.state == circuitClosed {
+			fields.Error("Kubernetes API unreachable, circuit breaker opened")
+		} else {
+			fields.Debug("Kubernetes API probe failed, circuit breaker kept open")
+		}
+	case circuitClosed:
+		fields.Info("Kubernetes API reachable again, circuit breaker closed")
+	default:
+		fields.Debug("Probing Kubernetes API")
+	}
+	b.state = state
+}
+
+// breakerTransport short-circuits requests to the API server while the breaker is open.
+type breakerTransport struct {
+	next    http.RoundTripper
+	breaker *circuitBreaker
+}
+
+func (t *breakerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !t.breaker.allow() {
+		return nil, ErrClusterUnreachable
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	switch {
+	case err != nil && req.Context().Err() != nil:
+		t.breaker.abandon()
+	case err != nil:
+		t.breaker.record(false)
+	case resp.StatusCode >= http.StatusInternalServerError:
+		t.breaker.record(false)
+	default:
+		t.breaker.record(true)
+	}
+	return resp, err
+}
+
+// ClusterReachable reports whether the Kubernetes API is currently considered reachable.
+func ClusterReachable() bool {
+	return breaker.retryAfter() == 0
+}
+
+// Ping checks that the Kubernetes API answers, with the cheapest call the API is
+// allowed to make in its namespace. It fails at once while the circuit is open.
+func Ping(ctx context.Context, namespace string) error {
+	_, err := Clientset.AppsV1().Deployments(namespace).List(ctx, metav1.ListOptions{Limit: 1})
+	return err
+}
+
+// RequireCluster rejects requests with a 503 while the Kubernetes API is unreachable,
+// instead of letting every handler wait for its own calls to fail.
+func RequireCluster() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !ClusterReachable() {
+			AbortClusterUnreachable(c)
+			return
+		}
+		c.Next()
+	}
+}
+
+// AbortClusterUnreachable responds with a 503 telling the client when to retry.
+func AbortClusterUnreachable(c *gin.Context) {
+	if seconds := int(breaker.retryAfter().Round(time.Second).Seconds()); seconds > 0 {
+		c.Header("Retry-After", strconv.Itoa(seconds))
+	}
+	apierror.AbortWith(c, apierror.New(http.StatusServiceUnavailable, apierror.CodeClusterUnreachable, "Kubernetes cluster unreachable, try again later"))
+}
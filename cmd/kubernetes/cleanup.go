@@ -0,0 +1,185 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"minecharts/cmd/config"
+	"minecharts/cmd/logging"
+)
+
+// Categories of the files removed by a cleanup.
+const (
+	CleanupLogs         = "logs"          // Rotated server logs
+	CleanupCrashReports = "crash-reports" // Crash reports and JVM crash dumps
+	CleanupBackups      = "backups"       // Entries of the backups directory
+)
+
+// CleanupPolicy selects what a cleanup removes from a server volume. A zero value
+// leaves its category alone.
+type CleanupPolicy struct {
+	LogsMaxAgeDays         int // Rotated logs older than this are removed
+	CrashReportsMaxAgeDays int // Crash reports and dumps older than this are removed
+	BackupsKeep            int // Newest backups kept, the older ones are removed
+}
+
+// DefaultCleanupPolicy is the policy of the cleanups that do not set one.
+var DefaultCleanupPolicy = CleanupPolicy{LogsMaxAgeDays: 14, CrashReportsMaxAgeDays: 14, BackupsKeep: 10}
+
+// ParseCleanupPolicy parses a policy written as comma separated rules, such as
+// "logs=7d,crash-reports=30d,backups=5": the logs and crash reports are kept for a
+// number of days, the backups by count. An empty policy is the default one.
+func ParseCleanupPolicy(value string) (CleanupPolicy, error) {
+	if strings.TrimSpace(value) == "" {
+		return DefaultCleanupPolicy, nil
+	}
+
+	var policy CleanupPolicy
+	for _, rule := range strings.Split(value, ",") {
+		category, limit, ok := strings.Cut(strings.TrimSpace(rule), "=")
+		if !ok {
+			return policy, fmt.Errorf("invalid cleanup rule %q, expected category=limit", rule)
+		}
+		switch category {
+		case CleanupLogs, CleanupCrashReports:
+			days, err := strconv.Atoi(strings.TrimSuffix(limit, "d"))
+			if err != nil || !strings.HasSuffix(limit, "d") || days < 1 {
+				return policy, fmt.Errorf("invalid %s age %q, expected a number of days such as 7d", category, limit)
+			}
+			if category == CleanupLogs {
+				policy.LogsMaxAgeDays = days
+			} else {
+				policy.CrashReportsMaxAgeDays = days
+			}
+		case CleanupBackups:
+			count, err := strconv.Atoi(limit)
+			if err != nil || count < 1 {
+				return policy, fmt.Errorf("invalid number of backups kept %q, expected at least 1", limit)
+			}
+			policy.BackupsKeep = count
+		default:
+			return policy, fmt.Errorf("unknown cleanup category %q, expected %s, %s or %s", category, CleanupLogs, CleanupCrashReports, CleanupBackups)
+		}
+	}
+	return policy, nil
+}
+
+// String returns the policy in the form read by ParseCleanupPolicy.
+func (p CleanupPolicy) String() string {
+	var rules []string
+	if p.LogsMaxAgeDays > 0 {
+		rules = append(rules, fmt.Sprintf("%s=%dd", CleanupLogs, p.LogsMaxAgeDays))
+	}
+	if p.CrashReportsMaxAgeDays > 0 {
+		rules = append(rules, fmt.Sprintf("%s=%dd", CleanupCrashReports, p.CrashReportsMaxAgeDays))
+	}
+	if p.BackupsKeep > 0 {
+		rules = append(rules, fmt.Sprintf("%s=%d", CleanupBackups, p.BackupsKeep))
+	}
+	return strings.Join(rules, ",")
+}
+
+// CleanupResult is what a cleanup removed in a category.
+type CleanupResult struct {
+	Category string
+	Files    int
+	Bytes    int64
+}
+
+// CleanupJobName returns the name of the job cleaning up a server's volume.
+func CleanupJobName(deploymentName string) string {
+	return deploymentName + "-cleanup"
+}
+
+// CleanupVolume removes the files selected by the policy from a server's volume with
+// a job, and reports what was removed in each category.
+func CleanupVolume(ctx context.Context, namespace, deploymentName, volume string, policy CleanupPolicy) ([]CleanupResult, error) {
+	jobName := CleanupJobName(deploymentName)
+
+	// reclaim removes the paths read from stdin and reports their count and size in KiB
+	script := []string{
+		`reclaim() { count=0; kib=0; while IFS= read -r path; do [ -e "$path" ] || continue; size=$(du -sk "$path" | cut -f1); rm -rf "$path" && count=$((count+1)) && kib=$((kib+size)); done; echo "reclaimed $1 $count $kib"; }`,
+	}
+	if policy.LogsMaxAgeDays > 0 {
+		script = append(script, fmt.Sprintf(`find /data/logs -type f -name '*.log.gz' -mtime +%d 2>/dev/null | reclaim %s`,
+			policy.LogsMaxAgeDays, CleanupLogs))
+	}
+	if policy.CrashReportsMaxAgeDays > 0 {
+		script = append(script, fmt.Sprintf(`{ find /data/crash-reports -type f -mtime +%[1]d; find /data -maxdepth 1 -type f -name 'hs_err_pid*.log' -mtime +%[1]d; } 2>/dev/null | reclaim %[2]s`,
+			policy.CrashReportsMaxAgeDays, CleanupCrashReports))
+	}
+	if policy.BackupsKeep > 0 {
+		script = append(script, fmt.Sprintf(`ls -1dt %s/* 2>/dev/null | tail -n +%d | reclaim %s`,
+			BackupDir, policy.BackupsKeep+1, CleanupBackups))
+	}
+
+	logging.K8s.WithContext(ctx).WithFields(
+		"namespace", namespace,
+		"job_name", jobName,
+		"volume", volume,
+		"policy", policy.String(),
+	).Info("Cleaning up server data")
+
+	var output string
+	err := runVolumeJob(ctx, namespace, volumeJob{
+		name:     jobName,
+		app:      deploymentName,
+		near:     deploymentName,
+		command:  strings.Join(script, "\n"),
+		mounts:   []volumeJobMount{{volume: volume, path: "/data"}},
+		deadline: config.CleanupTimeout,
+		output:   &output,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to clean up server data: %w", err)
+	}
+
+	var results []CleanupResult
+	for _, line := range strings.Split(output, "\n") {
+		var result CleanupResult
+		var kib int64
+		if _, err := fmt.Sscanf(line, "reclaimed %s %d %d", &result.Category, &result.Files, &kib); err != nil {
+			continue
+		}
+		result.Bytes = kib << 10
+		results = append(results, result)
+	}
+
+	logging.K8s.WithContext(ctx).WithFields(
+		"namespace", namespace,
+		"job_name", jobName,
+		"reclaimed", CleanupSummary(results),
+	).Info("Server data cleaned up successfully")
+	return results, nil
+}
+
+// CleanupSummary describes the space reclaimed by a cleanup, in total and by category.
+func CleanupSummary(results []CleanupResult) string {
+	var total int64
+	var categories []string
+	for _, result := range results {
+		total += result.Bytes
+		categories = append(categories, fmt.Sprintf("%s: %d files, %s", result.Category, result.Files, formatBytes(result.Bytes)))
+	}
+	summary := "Reclaimed " + formatBytes(total)
+	if len(categories) > 0 {
+		summary += " (" + strings.Join(categories, "; ") + ")"
+	}
+	return summary
+}
+
+// formatBytes writes a size with binary units, such as 1.5 GiB.
+func formatBytes(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
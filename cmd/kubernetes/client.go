@@ -2,9 +2,11 @@ package kubernetes
 
 import (
 	"flag"
+	"net/http"
 	"os"
 	"path/filepath"
 
+	"minecharts/cmd/config"
 	"minecharts/cmd/logging"
 
 	"k8s.io/client-go/kubernetes"
@@ -13,29 +15,44 @@ import (
 )
 
 // Clientset is a global Kubernetes clientset instance.
-// In your kubernetes package
+// In development mode it is an in-memory fake and Config is nil.
 var (
-	Clientset *kubernetes.Clientset
+	Clientset kubernetes.Interface
 	Config    *rest.Config
 )
 
+// kubeconfigPath is the -kubeconfig flag, registered with the flags of the API so
+// that main parses them together.
+var kubeconfigPath = flag.String("kubeconfig", defaultKubeconfig(), "absolute path to the kubeconfig file")
+
+// defaultKubeconfig returns the kubeconfig of the HOME, if any.
+func defaultKubeconfig() string {
+	if home := os.Getenv("HOME"); home != "" {
+		return filepath.Join(home, ".kube", "config")
+	}
+	return ""
+}
+
 // Init initializes the global Kubernetes clientset.
 // It uses the local kubeconfig if available; otherwise, it falls back to in-cluster config.
 func Init() error {
 	logging.K8s.Info("Initializing Kubernetes client")
 
-	var err error
-	var kubeconfig string
+	// The breaker settings may come from the configuration file, loaded after the
+	// package variables were initialized
+	breaker.threshold = config.KubernetesBreakerThreshold
+	breaker.cooldown = config.KubernetesBreakerCooldown
 
-	// Set default kubeconfig path from HOME if available.
-	if home := os.Getenv("HOME"); home != "" {
-		kubeconfig = filepath.Join(home, ".kube", "config")
-		logging.K8s.WithFields(
-			"kubeconfig_path", kubeconfig,
-		).Debug("Using default kubeconfig path from HOME")
+	if config.DevMode {
+		initDevCluster()
+		return nil
+	}
+
+	var err error
+	if !flag.Parsed() {
+		flag.Parse()
 	}
-	flag.StringVar(&kubeconfig, "kubeconfig", kubeconfig, "absolute path to the kubeconfig file")
-	flag.Parse()
+	kubeconfig := *kubeconfigPath
 
 	// Use kubeconfig if available; otherwise, use in-cluster config.
 	if _, err := os.Stat(kubeconfig); err == nil {
@@ -62,6 +79,19 @@ func Init() error {
 		}
 	}
 
+	// Every client built from Config, including the exec executor and the
+	// informers, goes through the circuit breaker, its failed calls are
+	// counted in the metrics and its calls are traced.
+	Config.Wrap(func(rt http.RoundTripper) http.RoundTripper {
+		return &tracingTransport{next: rt}
+	})
+	Config.Wrap(func(rt http.RoundTripper) http.RoundTripper {
+		return &metricsTransport{next: rt}
+	})
+	Config.Wrap(func(rt http.RoundTripper) http.RoundTripper {
+		return &breakerTransport{next: rt, breaker: breaker}
+	})
+
 	Clientset, err = kubernetes.NewForConfig(Config)
 	if err != nil {
 		logging.K8s.WithFields(
@@ -70,6 +100,25 @@ func Init() error {
 		return err
 	}
 
+	options, err := ParseStorageOptions(config.StorageOptions)
+	if err != nil {
+		logging.K8s.WithFields(
+			"error", err.Error(),
+		).Error("Invalid storage driver options")
+		return err
+	}
+	Storage, err = NewStorageDriver(config.StorageDriver, options)
+	if err != nil {
+		logging.K8s.WithFields(
+			"storage_driver", config.StorageDriver,
+			"error", err.Error(),
+		).Error("Failed to configure storage driver")
+		return err
+	}
+	logging.K8s.WithFields(
+		"storage_driver", Storage.Name(),
+	).Info("Storage driver configured")
+
 	logging.K8s.Info("Kubernetes client initialized successfully")
 	return nil
 }
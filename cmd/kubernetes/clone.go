@@ -0,0 +1,50 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+
+	"minecharts/cmd/config"
+	"minecharts/cmd/logging"
+)
+
+// CopyJobName returns the name of the job copying data into a server's volume.
+func CopyJobName(deploymentName string) string {
+	return deploymentName + "-copy"
+}
+
+// CopyVolume copies the data of a server's volume into the volume of another server
+// with a job, and waits for the job to complete. The job mounts the volumes of the
+// configured storage driver and prefers the node of the source server's pod, so a
+// ReadWriteOnce volume can be read while the source server runs.
+func CopyVolume(ctx context.Context, namespace, sourceDeployment, sourceVolume, targetDeployment, targetVolume string) error {
+	jobName := CopyJobName(targetDeployment)
+
+	logging.K8s.WithContext(ctx).WithFields(
+		"namespace", namespace,
+		"job_name", jobName,
+		"source_volume", sourceVolume,
+		"target_volume", targetVolume,
+	).Info("Copying server data")
+
+	err := runVolumeJob(ctx, namespace, volumeJob{
+		name:    jobName,
+		app:     targetDeployment,
+		near:    sourceDeployment,
+		command: "cp -a /source/. /target/",
+		mounts: []volumeJobMount{
+			{volume: sourceVolume, path: "/source", readOnly: true},
+			{volume: targetVolume, path: "/target"},
+		},
+		deadline: config.CloneTimeout,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to copy server data: %w", err)
+	}
+
+	logging.K8s.WithContext(ctx).WithFields(
+		"namespace", namespace,
+		"job_name", jobName,
+	).Info("Server data copied successfully")
+	return nil
+}
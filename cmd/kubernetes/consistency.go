@@ -0,0 +1,202 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"minecharts/cmd/database"
+	"minecharts/cmd/logging"
+
+	appsv1 "k8s.io/api/apps/v1"
+)
+
+// Repairs of the startup consistency check, enabled with MINECHARTS_STARTUP_REPAIR.
+const (
+	RepairStatuses = "statuses" // Record the status the deployments of the servers are in
+	RepairOrphans  = "orphans"  // Delete the deployments, services and secrets of no server or proxy
+	RepairVolumes  = "volumes"  // Delete the PVCs of no server, with their data
+)
+
+// startupCheckTimeout bounds the startup consistency check, so that a slow cluster
+// does not hold the API back.
+const startupCheckTimeout = time.Minute
+
+// ConsistencyStore holds the records the consistency check compares with the cluster.
+type ConsistencyStore interface {
+	ServerStatusStore
+	ListServers(ctx context.Context) ([]*database.MinecraftServer, error)
+	ListProxies(ctx context.Context) ([]*database.Proxy, error)
+}
+
+// StaleStatus is a server whose recorded status does not match its deployment.
+type StaleStatus struct {
+	Server   string `json:"server"`
+	Recorded string `json:"recorded"`
+	Actual   string `json:"actual"`
+}
+
+// ConsistencyReport is the drift found between the records and the cluster.
+type ConsistencyReport struct {
+	MissingDeployments []string          `json:"missingDeployments"` // Servers whose deployment is gone
+	Orphans            []ManagedResource `json:"orphans"`            // Resources of no recorded server or proxy
+	StaleStatuses      []StaleStatus     `json:"staleStatuses"`      // Including the servers whose deployment is gone, marked failed
+	Repaired           int               `json:"repaired"`
+	RepairsFailed      int               `json:"repairsFailed"`
+}
+
+// Drift tells whether the check found anything out of sync.
+func (r *ConsistencyReport) Drift() bool {
+	return len(r.MissingDeployments) > 0 || len(r.Orphans) > 0 || len(r.StaleStatuses) > 0
+}
+
+// ParseRepairs reads a comma separated list of repairs, skipping the unknown ones.
+func ParseRepairs(value string) map[string]bool {
+	repairs := map[string]bool{}
+	for _, repair := range strings.Split(value, ",") {
+		repair = strings.TrimSpace(repair)
+		switch repair {
+		case "":
+		case RepairStatuses, RepairOrphans, RepairVolumes:
+			repairs[repair] = true
+		default:
+			logging.K8s.WithFields(
+				"repair", repair,
+			).Warn("Unknown startup repair, ignored")
+		}
+	}
+	return repairs
+}
+
+// CheckConsistency compares the server and proxy records with the resources the API
+// manages in a namespace, or in every namespace with metav1.NamespaceAll, and makes the given repairs of the drift it finds. The
+// records and resources created in the last minutes are left out, as they may still
+// be provisioning.
+func CheckConsistency(ctx context.Context, namespace string, store ConsistencyStore, repairs map[string]bool) (*ConsistencyReport, error) {
+	servers, err := store.ListServers(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list servers: %w", err)
+	}
+	proxies, err := store.ListProxies(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list proxies: %w", err)
+	}
+	list, err := listWorkloads(ctx, namespace)
+	if err != nil {
+		return nil, err
+	}
+	deployments := make(map[string]*appsv1.Deployment, len(list))
+	for _, deployment := range list {
+		deployments[deployment.Name] = deployment
+	}
+
+	report := &ConsistencyReport{
+		MissingDeployments: []string{},
+		StaleStatuses:      []StaleStatus{},
+	}
+	recorded := map[string]bool{}
+	for _, server := range servers {
+		recorded[server.DeploymentName] = true
+		// Renamed servers keep the PVC of their original name
+		recorded[server.PVCName] = true
+
+		deployment := deployments[server.DeploymentName]
+		if deployment == nil && (server.Status != database.ServerStatusCreating || time.Since(server.UpdatedAt) >= provisioningGrace) {
+			report.MissingDeployments = append(report.MissingDeployments, server.ServerName)
+		}
+		status, reason, changed := reconciledStatus(server, deployment)
+		if !changed || status == server.Status {
+			continue
+		}
+		report.StaleStatuses = append(report.StaleStatuses, StaleStatus{Server: server.ServerName, Recorded: server.Status, Actual: status})
+		if repairs[RepairStatuses] {
+			report.repair(store.UpdateServerStatus(ctx, server.ServerName, status, reason),
+				"server_name", server.ServerName, "status", status)
+		}
+	}
+	for _, proxy := range proxies {
+		recorded[proxy.DeploymentName] = true
+	}
+
+	if report.Orphans, err = OrphanedResources(ctx, namespace, recorded); err != nil {
+		return nil, err
+	}
+	if report.Orphans == nil {
+		report.Orphans = []ManagedResource{}
+	}
+	for _, resource := range report.Orphans {
+		if resource.Kind == KindPVC && repairs[RepairVolumes] || resource.Kind != KindPVC && repairs[RepairOrphans] {
+			report.repair(DeleteManagedResource(ctx, resource),
+				"kind", resource.Kind, "namespace", resource.Namespace, "name", resource.Name)
+		}
+	}
+	return report, nil
+}
+
+// repair counts the outcome of a repair, logging the failed ones.
+func (r *ConsistencyReport) repair(err error, fields ...interface{}) {
+	if err != nil {
+		r.RepairsFailed++
+		logging.K8s.WithFields(append(fields, "error", err.Error())...).Warn("Consistency repair failed")
+		return
+	}
+	r.Repaired++
+}
+
+// RunStartupCheck runs the consistency check when the API starts and logs the drift
+// accumulated while it was down, repairing it according to the comma separated
+// repairs. Failures are logged, the API starts anyway.
+func RunStartupCheck(ctx context.Context, namespace string, store ConsistencyStore, repairs string) {
+	ctx, cancel := context.WithTimeout(ctx, startupCheckTimeout)
+	defer cancel()
+
+	report, err := CheckConsistency(ctx, namespace, store, ParseRepairs(repairs))
+	if err != nil {
+		logging.K8s.WithContext(ctx).WithFields(
+			"namespace", namespace,
+			"error", err.Error(),
+		).Warn("Startup consistency check failed")
+		return
+	}
+
+	for _, server := range report.MissingDeployments {
+		logging.K8s.WithContext(ctx).WithFields(
+			"server_name", server,
+		).Warn("Consistency check: server has no deployment")
+	}
+	orphans := map[string]int{}
+	for _, resource := range report.Orphans {
+		orphans[resource.Kind]++
+		logging.K8s.WithContext(ctx).WithFields(
+			"kind", resource.Kind,
+			"namespace", resource.Namespace,
+			"name", resource.Name,
+			"deployment", resource.Deployment,
+		).Warn("Consistency check: resource has no server or proxy record")
+	}
+	for _, stale := range report.StaleStatuses {
+		logging.K8s.WithContext(ctx).WithFields(
+			"server_name", stale.Server,
+			"recorded", stale.Recorded,
+			"actual", stale.Actual,
+		).Warn("Consistency check: server status is stale")
+	}
+
+	summary := logging.K8s.WithContext(ctx).WithFields(
+		"namespace", namespace,
+		"missing_deployments", len(report.MissingDeployments),
+		"orphaned_deployments", orphans[KindDeployment],
+		"orphaned_services", orphans[KindService],
+		"orphaned_secrets", orphans[KindSecret],
+		"orphaned_pvcs", orphans[KindPVC],
+		"stale_statuses", len(report.StaleStatuses),
+		"repaired", report.Repaired,
+		"repairs_failed", report.RepairsFailed,
+	)
+	if report.Drift() {
+		summary.Warn("Startup consistency check found drift between the records and the cluster")
+		return
+	}
+	summary.Info("Startup consistency check found the records in sync with the cluster")
+}
@@ -0,0 +1,127 @@
+package kubernetes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"minecharts/cmd/logging"
+	"minecharts/cmd/rcon"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// ContainerEnv returns the value of an environment variable of the minecraft-server container.
+func ContainerEnv(deployment *appsv1.Deployment, name string) string {
+	if deployment == nil {
+		return ""
+	}
+	for _, container := range deployment.Spec.Template.Spec.Containers {
+		if container.Name != "minecraft-server" {
+			continue
+		}
+		for _, env := range container.Env {
+			if env.Name == name {
+				return env.Value
+			}
+		}
+	}
+	return ""
+}
+
+// RCONCommand runs a command over RCON against the server's pod.
+// It returns ok=false when the server has no RCON secret, in which case
+// callers should fall back to exec'ing mc-send-to-console.
+func RCONCommand(ctx context.Context, namespace string, deployment *appsv1.Deployment, pod *corev1.Pod, command string) (output string, ok bool, err error) {
+	password, err := GetSecretValue(ctx, namespace, RCONSecretName(deployment.Name), RCONSecretKey)
+	if err != nil {
+		return "", false, nil
+	}
+	if pod.Status.PodIP == "" {
+		return "", true, fmt.Errorf("pod %s has no IP", pod.Name)
+	}
+
+	port := strconv.Itoa(rcon.DefaultPort)
+	if value := ContainerEnv(deployment, "RCON_PORT"); value != "" {
+		port = value
+	}
+
+	client, err := rcon.Dial(ctx, net.JoinHostPort(pod.Status.PodIP, port), password)
+	if err != nil {
+		return "", true, err
+	}
+	defer client.Close()
+
+	output, err = client.Execute(ctx, command)
+	return output, true, err
+}
+
+// SendConsoleCommand runs a command on the server console, over RCON when the server
+// has it and with mc-send-to-console otherwise. It returns the output of the command,
+// which only RCON reports, and the method used.
+func SendConsoleCommand(ctx context.Context, namespace string, deployment *appsv1.Deployment, pod *corev1.Pod, command string) (output, method string, err error) {
+	output, usedRCON, err := RCONCommand(ctx, namespace, deployment, pod, command)
+	if usedRCON {
+		if err == nil {
+			return output, "rcon", nil
+		}
+		logging.K8s.WithContext(ctx).WithFields(
+			"namespace", namespace,
+			"pod_name", pod.Name,
+			"error", err.Error(),
+		).Warn("RCON command failed, falling back to console exec")
+	}
+
+	// Quoted so that the shell passes JSON text components and the like through unchanged
+	stdout, stderr, err := ExecuteCommandInPod(ctx, pod.Name, namespace, "minecraft-server", "mc-send-to-console "+shellQuote(command))
+	if err != nil {
+		if stderr != "" {
+			return stdout, "exec", fmt.Errorf("%w: %s", err, stderr)
+		}
+		return stdout, "exec", err
+	}
+	return stdout, "exec", nil
+}
+
+// shellQuote quotes a value as a single word of a shell command.
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+// ErrPlayerNotFound is returned for the players who never joined a server.
+var ErrPlayerNotFound = errors.New("player never joined this server")
+
+// usercacheCommand prints the user cache of a server, empty before anyone joined it.
+const usercacheCommand = "cat /data/usercache.json 2>/dev/null || echo '[]'"
+
+// CachedPlayer is a player recorded in the user cache of a server.
+type CachedPlayer struct {
+	Name string `json:"name"`
+	UUID string `json:"uuid"`
+}
+
+// FindCachedPlayer looks a player up by name, ignoring case, in the user cache the
+// server keeps of the players who joined it. The cache holds the UUIDs the server
+// identifies the players with, online or offline.
+func FindCachedPlayer(ctx context.Context, namespace string, pod *corev1.Pod, name string) (*CachedPlayer, error) {
+	stdout, stderr, err := ExecuteCommandInPod(ctx, pod.Name, namespace, "minecraft-server", usercacheCommand)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read user cache: %w", joinExecError(err, stderr))
+	}
+
+	var players []CachedPlayer
+	if err := json.Unmarshal([]byte(stdout), &players); err != nil {
+		return nil, fmt.Errorf("failed to decode user cache: %w", err)
+	}
+	for _, player := range players {
+		if strings.EqualFold(player.Name, name) && player.UUID != "" {
+			return &player, nil
+		}
+	}
+	return nil, ErrPlayerNotFound
+}
@@ -0,0 +1,101 @@
+package kubernetes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+var (
+	// ErrDatapackNotFound is returned for the data packs the server does not know.
+	ErrDatapackNotFound = errors.New("unknown data pack")
+	// ErrDatapackUnchanged is returned when a data pack is already in the requested state.
+	ErrDatapackUnchanged = errors.New("data pack already in this state")
+)
+
+// Datapack is a data pack of a server, as listed by the datapack console command.
+type Datapack struct {
+	Name    string `json:"name" example:"file/terralith.zip"` // Name given to the datapack enable and disable commands
+	Source  string `json:"source" example:"world"`            // built-in, feature, world or files when listed from the volume
+	Enabled *bool  `json:"enabled,omitempty"`                 // Unknown when listed from the volume
+}
+
+var (
+	// datapackSectionPattern matches the heading of the enabled and available lists,
+	// which RCON returns one after the other.
+	datapackSectionPattern = regexp.MustCompile(`There are (?:\d+ data packs?(?:\(s\))?|no(?: more)? data packs) (enabled|available)`)
+	// datapackEntryPattern matches a data pack of a list, such as [file/terralith.zip (world)].
+	datapackEntryPattern = regexp.MustCompile(`\[([^\]]+?) \(([^)]+)\)\]`)
+)
+
+// DatapacksDirectory returns the directory of the data packs of the world of a server.
+func DatapacksDirectory(deployment *appsv1.Deployment) string {
+	return "/data/" + ActiveWorld(deployment) + "/datapacks"
+}
+
+// ActiveWorld returns the level name of the world a server deployment plays, the
+// LEVEL environment variable or world by default.
+func ActiveWorld(deployment *appsv1.Deployment) string {
+	if level := ContainerEnv(deployment, "LEVEL"); level != "" {
+		return level
+	}
+	return "world"
+}
+
+// ListDatapacks lists the enabled and available data packs of a running server. It
+// returns ok=false when the server has no RCON, the only way to read the list back.
+func ListDatapacks(ctx context.Context, namespace string, deployment *appsv1.Deployment, pod *corev1.Pod) (datapacks []Datapack, ok bool, err error) {
+	output, ok, err := RCONCommand(ctx, namespace, deployment, pod, "datapack list")
+	if !ok || err != nil {
+		return nil, ok, err
+	}
+	return parseDatapackList(output), true, nil
+}
+
+// parseDatapackList parses the output of the datapack list command.
+func parseDatapackList(output string) []Datapack {
+	datapacks := []Datapack{}
+	sections := datapackSectionPattern.FindAllStringSubmatchIndex(output, -1)
+	for i, section := range sections {
+		end := len(output)
+		if i+1 < len(sections) {
+			end = sections[i+1][0]
+		}
+		enabled := output[section[2]:section[3]] == "enabled"
+		for _, entry := range datapackEntryPattern.FindAllStringSubmatch(output[section[1]:end], -1) {
+			datapacks = append(datapacks, Datapack{Name: entry[1], Source: entry[2], Enabled: &enabled})
+		}
+	}
+	return datapacks
+}
+
+// SetDatapackEnabled enables or disables a data pack of a running server. It returns
+// whether the server confirmed the change, which only RCON reports.
+func SetDatapackEnabled(ctx context.Context, namespace string, deployment *appsv1.Deployment, pod *corev1.Pod, name string, enabled bool) (bool, error) {
+	command := "datapack disable "
+	if enabled {
+		command = "datapack enable "
+	}
+	output, method, err := SendConsoleCommand(ctx, namespace, deployment, pod, command+`"`+name+`"`)
+	if err != nil {
+		return false, err
+	}
+	if method != "rcon" {
+		return false, nil
+	}
+
+	switch {
+	case strings.Contains(output, "Unknown data pack"):
+		return false, fmt.Errorf("%w: %s", ErrDatapackNotFound, name)
+	case strings.Contains(output, "is already enabled"), strings.Contains(output, "is not enabled"):
+		return false, fmt.Errorf("%w: %s", ErrDatapackUnchanged, name)
+	case strings.Contains(output, "Enabling new data pack"), strings.Contains(output, "Disabling data pack"):
+		return true, nil
+	}
+	return false, fmt.Errorf("unexpected datapack command output: %s", strings.TrimSpace(output))
+}
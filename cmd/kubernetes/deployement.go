@@ -6,70 +6,104 @@ package kubernetes
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"time"
 
+	"minecharts/cmd/apierror"
 	"minecharts/cmd/config"
 	"minecharts/cmd/logging"
 
 	"github.com/gin-gonic/gin"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 // CheckDeploymentExists checks if a deployment exists and returns an HTTP error if it does not.
 // Returns the deployment and a boolean indicating whether it exists.
 func CheckDeploymentExists(c *gin.Context, namespace, deploymentName string) (*appsv1.Deployment, bool) {
-	logging.K8s.WithFields(
+	logging.K8s.WithContext(c.Request.Context()).WithFields(
 		"namespace", namespace,
 		"deployment_name", deploymentName,
 		"remote_ip", c.ClientIP(),
 	).Debug("Checking if deployment exists")
 
-	deployment, err := Clientset.AppsV1().Deployments(namespace).Get(context.Background(), deploymentName, metav1.GetOptions{})
+	deployment, err := getWorkload(c.Request.Context(), namespace, deploymentName)
+	if errors.Is(err, ErrClusterUnreachable) {
+		AbortClusterUnreachable(c)
+		return nil, false
+	}
 	if err != nil {
-		logging.K8s.WithFields(
+		logging.K8s.WithContext(c.Request.Context()).WithFields(
 			"namespace", namespace,
 			"deployment_name", deploymentName,
 			"error", err.Error(),
 			"remote_ip", c.ClientIP(),
 		).Warn("Deployment not found")
-		c.JSON(http.StatusNotFound, gin.H{"error": "Deployment not found"})
+		apierror.Respond(c, apierror.New(http.StatusNotFound, apierror.CodeServerNotFound, "Deployment not found"))
 		return nil, false
 	}
 
-	logging.K8s.WithFields(
+	logging.K8s.WithContext(c.Request.Context()).WithFields(
 		"namespace", namespace,
 		"deployment_name", deploymentName,
 	).Debug("Deployment found")
 	return deployment, true
 }
 
-// CreateDeployment creates a Minecraft deployment using the specified PVC and environment variables.
-// It configures the deployment with appropriate lifecycle hooks and volume mounts.
-func CreateDeployment(namespace, deploymentName, pvcName string, envVars []corev1.EnvVar) error {
-	logging.K8s.WithFields(
+// GetDeployment returns a deployment, or nil when it does not exist.
+func GetDeployment(ctx context.Context, namespace, deploymentName string) (*appsv1.Deployment, error) {
+	deployment, err := getWorkload(ctx, namespace, deploymentName)
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return deployment, nil
+}
+
+// Edition is the edition of Minecraft a server deployment runs.
+type Edition string
+
+const (
+	JavaEdition    Edition = "java"
+	BedrockEdition Edition = "bedrock" // The Bedrock dedicated server, which Bedrock clients join over UDP
+)
+
+// CreateDeployment creates a Minecraft deployment using the storage of the specified PVC name, environment variables
+// and container resources. It configures the deployment with appropriate lifecycle hooks and volume mounts, with
+// the image, port and health check of the edition, and with the priority class and node placement when set.
+// With StatefulSetWorkload, the pod is run by a StatefulSet adopting the PVC, which must be named StatefulClaimName.
+func CreateDeployment(ctx context.Context, namespace, deploymentName, pvcName string, edition Edition, envVars []corev1.EnvVar, resources corev1.ResourceRequirements, labels map[string]string, priorityClass string, placement Placement, workload Workload) error {
+	logging.K8s.WithContext(ctx).WithFields(
 		"namespace", namespace,
 		"deployment_name", deploymentName,
 		"pvc_name", pvcName,
+		"edition", edition,
 	).Info("Creating Minecraft server deployment")
 
 	replicas := int32(config.DefaultReplicas)
+	progressDeadline := int32(config.RolloutTimeout.Seconds())
+	container := serverContainer(edition, envVars, resources)
 
 	deployment := &appsv1.Deployment{
 		ObjectMeta: metav1.ObjectMeta{
 			Name: deploymentName,
-			Labels: map[string]string{
+			Labels: withCustomLabels(map[string]string{
 				"created-by": "minecharts-api",
 				"app":        deploymentName,
-			},
+			}, labels),
 		},
 		Spec: appsv1.DeploymentSpec{
 			Replicas: &replicas,
+			// The world volume can only be mounted by one pod at a time
 			Strategy: appsv1.DeploymentStrategy{
 				Type: appsv1.RecreateDeploymentStrategyType,
 			},
+			ProgressDeadlineSeconds: &progressDeadline,
 			Selector: &metav1.LabelSelector{
 				MatchLabels: map[string]string{
 					"app": deploymentName,
@@ -77,48 +111,19 @@ func CreateDeployment(namespace, deploymentName, pvcName string, envVars []corev
 			},
 			Template: corev1.PodTemplateSpec{
 				ObjectMeta: metav1.ObjectMeta{
-					Labels: map[string]string{
-						"app": deploymentName,
-					},
+					Labels: withCustomLabels(map[string]string{
+						"created-by": "minecharts-api",
+						"app":        deploymentName,
+					}, labels),
 				},
 				Spec: corev1.PodSpec{
 					Containers: []corev1.Container{
-						{
-							Name:  "minecraft-server",
-							Image: "itzg/minecraft-server",
-							Env:   envVars,
-							Ports: []corev1.ContainerPort{
-								{
-									ContainerPort: 25565,
-									Protocol:      corev1.ProtocolTCP,
-								},
-							},
-							VolumeMounts: []corev1.VolumeMount{
-								{
-									Name:      "minecraft-storage",
-									MountPath: "/data",
-								},
-							},
-							Lifecycle: &corev1.Lifecycle{
-								PreStop: &corev1.LifecycleHandler{
-									Exec: &corev1.ExecAction{
-										Command: []string{
-											"/bin/sh", "-c",
-											"mc-send-to-console save-all stop && sleep 5",
-										},
-									},
-								},
-							},
-						},
+						container,
 					},
 					Volumes: []corev1.Volume{
 						{
-							Name: "minecraft-storage",
-							VolumeSource: corev1.VolumeSource{
-								PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
-									ClaimName: pvcName,
-								},
-							},
+							Name:         "minecraft-storage",
+							VolumeSource: Storage.VolumeSource(pvcName),
 						},
 					},
 				},
@@ -126,9 +131,29 @@ func CreateDeployment(namespace, deploymentName, pvcName string, envVars []corev
 		},
 	}
 
-	_, err := Clientset.AppsV1().Deployments(namespace).Create(context.Background(), deployment, metav1.CreateOptions{})
+	deployment.Annotations = map[string]string{}
+	if priorityClass != "" {
+		deployment.Annotations[PriorityClassAnnotation] = priorityClass
+		deployment.Spec.Template.Spec.PriorityClassName = priorityClass
+	}
+	if placement.IsZero() {
+		Storage.ConfigurePod(&deployment.Spec.Template.Spec)
+	} else {
+		if err := recordPlacement(deployment, placement); err != nil {
+			return err
+		}
+		applyPlacement(&deployment.Spec.Template.Spec, placement)
+	}
+
+	annotateChange(ctx, deployment)
+	var err error
+	if workload == StatefulSetWorkload {
+		err = createStatefulSet(ctx, namespace, deployment, pvcName)
+	} else {
+		_, err = Clientset.AppsV1().Deployments(namespace).Create(ctx, deployment, metav1.CreateOptions{FieldManager: FieldManager})
+	}
 	if err != nil {
-		logging.K8s.WithFields(
+		logging.K8s.WithContext(ctx).WithFields(
 			"namespace", namespace,
 			"deployment_name", deploymentName,
 			"pvc_name", pvcName,
@@ -137,7 +162,7 @@ func CreateDeployment(namespace, deploymentName, pvcName string, envVars []corev
 		return err
 	}
 
-	logging.K8s.WithFields(
+	logging.K8s.WithContext(ctx).WithFields(
 		"namespace", namespace,
 		"deployment_name", deploymentName,
 		"pvc_name", pvcName,
@@ -145,17 +170,66 @@ func CreateDeployment(namespace, deploymentName, pvcName string, envVars []corev
 	return nil
 }
 
+// serverContainer returns the minecraft-server container of a deployment of the edition.
+func serverContainer(edition Edition, envVars []corev1.EnvVar, resources corev1.ResourceRequirements) corev1.Container {
+	container := corev1.Container{
+		Name:      "minecraft-server",
+		Image:     config.ServerImage,
+		Env:       envVars,
+		Resources: resources,
+		Ports: []corev1.ContainerPort{
+			{
+				ContainerPort: 25565,
+				Protocol:      corev1.ProtocolTCP,
+			},
+		},
+		VolumeMounts: []corev1.VolumeMount{
+			{
+				Name:      "minecraft-storage",
+				MountPath: "/data",
+			},
+		},
+		Lifecycle: &corev1.Lifecycle{
+			PreStop: &corev1.LifecycleHandler{
+				Exec: &corev1.ExecAction{
+					Command: []string{
+						"/bin/sh", "-c",
+						"mc-send-to-console save-all stop && sleep 5",
+					},
+				},
+			},
+		},
+	}
+
+	if edition == BedrockEdition {
+		container.Image = config.BedrockServerImage
+		container.Ports = []corev1.ContainerPort{
+			{
+				ContainerPort: BedrockPort,
+				Protocol:      corev1.ProtocolUDP,
+			},
+		}
+		// The server runner of the image stops the server cleanly on SIGTERM, and
+		// the Bedrock server has no save-all
+		container.Lifecycle = nil
+	}
+	// The image's health check pings the server, so the pod only
+	// becomes ready once players can connect.
+	applyProbes(&container, healthCommand(edition))
+	return container
+}
+
 // RestartDeployment restarts a deployment by updating an annotation to trigger a rollout.
 // This is a non-disruptive way to restart pods in a deployment.
-func RestartDeployment(namespace, deploymentName string) error {
-	logging.K8s.WithFields(
+func RestartDeployment(ctx context.Context, namespace, deploymentName string) error {
+	logging.K8s.WithContext(ctx).WithFields(
 		"namespace", namespace,
 		"deployment_name", deploymentName,
 	).Info("Restarting deployment")
 
-	deployment, err := Clientset.AppsV1().Deployments(namespace).Get(context.Background(), deploymentName, metav1.GetOptions{})
+	deployment, err := getWorkload(ctx, namespace, deploymentName)
 	if err != nil {
-		logging.K8s.WithFields(
+		logging.K8s.WithContext(ctx).WithFields(
 			"namespace", namespace,
 			"deployment_name", deploymentName,
 			"error", err.Error(),
@@ -170,16 +244,18 @@ func RestartDeployment(namespace, deploymentName string) error {
 	// Add or update a restart timestamp annotation
 	restartTime := time.Now().Format(time.RFC3339)
 	deployment.Spec.Template.Annotations["kubectl.kubernetes.io/restartedAt"] = restartTime
+	// The new pods carry the labels and priority class set while the server was running
+	syncPodTemplate(deployment)
 
-	logging.K8s.WithFields(
+	logging.K8s.WithContext(ctx).WithFields(
 		"namespace", namespace,
 		"deployment_name", deploymentName,
 		"restart_time", restartTime,
 	).Debug("Setting restart annotation")
 
-	_, err = Clientset.AppsV1().Deployments(namespace).Update(context.Background(), deployment, metav1.UpdateOptions{})
+	_, err = updateWorkload(ctx, namespace, deployment)
 	if err != nil {
-		logging.K8s.WithFields(
+		logging.K8s.WithContext(ctx).WithFields(
 			"namespace", namespace,
 			"deployment_name", deploymentName,
 			"error", err.Error(),
@@ -187,7 +263,7 @@ func RestartDeployment(namespace, deploymentName string) error {
 		return err
 	}
 
-	logging.K8s.WithFields(
+	logging.K8s.WithContext(ctx).WithFields(
 		"namespace", namespace,
 		"deployment_name", deploymentName,
 	).Info("Deployment restart triggered successfully")
@@ -196,15 +272,15 @@ func RestartDeployment(namespace, deploymentName string) error {
 
 // UpdateDeployment updates a deployment with new environment variables.
 // This allows reconfiguring a Minecraft server without restarting it.
-func UpdateDeployment(namespace, deploymentName string, envVars []corev1.EnvVar) error {
-	logging.K8s.WithFields(
+func UpdateDeployment(ctx context.Context, namespace, deploymentName string, envVars []corev1.EnvVar) error {
+	logging.K8s.WithContext(ctx).WithFields(
 		"namespace", namespace,
 		"deployment_name", deploymentName,
 	).Info("Updating deployment environment variables")
 
-	deployment, err := Clientset.AppsV1().Deployments(namespace).Get(context.Background(), deploymentName, metav1.GetOptions{})
+	deployment, err := getWorkload(ctx, namespace, deploymentName)
 	if err != nil {
-		logging.K8s.WithFields(
+		logging.K8s.WithContext(ctx).WithFields(
 			"namespace", namespace,
 			"deployment_name", deploymentName,
 			"error", err.Error(),
@@ -223,15 +299,16 @@ func UpdateDeployment(namespace, deploymentName string, envVars []corev1.EnvVar)
 	}
 
 	if !containerUpdated {
-		logging.K8s.WithFields(
+		logging.K8s.WithContext(ctx).WithFields(
 			"namespace", namespace,
 			"deployment_name", deploymentName,
 		).Warn("Minecraft server container not found in deployment")
 	}
+	syncPodTemplate(deployment)
 
-	_, err = Clientset.AppsV1().Deployments(namespace).Update(context.Background(), deployment, metav1.UpdateOptions{})
+	_, err = updateWorkload(ctx, namespace, deployment)
 	if err != nil {
-		logging.K8s.WithFields(
+		logging.K8s.WithContext(ctx).WithFields(
 			"namespace", namespace,
 			"deployment_name", deploymentName,
 			"error", err.Error(),
@@ -239,23 +316,28 @@ func UpdateDeployment(namespace, deploymentName string, envVars []corev1.EnvVar)
 		return err
 	}
 
-	logging.K8s.WithFields(
+	logging.K8s.WithContext(ctx).WithFields(
 		"namespace", namespace,
 		"deployment_name", deploymentName,
 	).Info("Deployment updated successfully")
 	return nil
 }
 
-// DeleteDeployment deletes a deployment by name.
-func DeleteDeployment(namespace, deploymentName string) error {
-	logging.K8s.WithFields(
+// DeleteDeployment deletes a deployment by name, or the StatefulSet of the name.
+func DeleteDeployment(ctx context.Context, namespace, deploymentName string) error {
+	logging.K8s.WithContext(ctx).WithFields(
 		"namespace", namespace,
 		"deployment_name", deploymentName,
 	).Info("Deleting deployment")
 
-	err := Clientset.AppsV1().Deployments(namespace).Delete(context.Background(), deploymentName, metav1.DeleteOptions{})
+	err := Clientset.AppsV1().Deployments(namespace).Delete(ctx, deploymentName, metav1.DeleteOptions{})
+	if config.StatefulSets && apierrors.IsNotFound(err) {
+		if stsErr := Clientset.AppsV1().StatefulSets(namespace).Delete(ctx, deploymentName, metav1.DeleteOptions{}); !apierrors.IsNotFound(stsErr) {
+			err = stsErr
+		}
+	}
 	if err != nil {
-		logging.K8s.WithFields(
+		logging.K8s.WithContext(ctx).WithFields(
 			"namespace", namespace,
 			"deployment_name", deploymentName,
 			"error", err.Error(),
@@ -263,26 +345,39 @@ func DeleteDeployment(namespace, deploymentName string) error {
 		return err
 	}
 
-	logging.K8s.WithFields(
+	logging.K8s.WithContext(ctx).WithFields(
 		"namespace", namespace,
 		"deployment_name", deploymentName,
 	).Info("Deployment deleted successfully")
 	return nil
 }
 
+// HibernatedAnnotation marks the deployments scaled down by the idle shutdown,
+// with the reason as value, so the reconciler can tell them from stopped servers.
+const HibernatedAnnotation = "minecharts.io/hibernated"
+
 // SetDeploymentReplicas updates the number of replicas for a deployment.
 // This is used to scale up (start) or down (stop) Minecraft servers.
-func SetDeploymentReplicas(namespace, deploymentName string, replicas int32) error {
-	logging.K8s.WithFields(
+func SetDeploymentReplicas(ctx context.Context, namespace, deploymentName string, replicas int32) error {
+	return scaleDeployment(ctx, namespace, deploymentName, replicas, "")
+}
+
+// HibernateDeployment scales a deployment to 0 and marks it as hibernated for
+// the given reason. Scaling it again with SetDeploymentReplicas removes the mark.
+func HibernateDeployment(ctx context.Context, namespace, deploymentName, reason string) error {
+	return scaleDeployment(ctx, namespace, deploymentName, 0, reason)
+}
+
+func scaleDeployment(ctx context.Context, namespace, deploymentName string, replicas int32, hibernatedReason string) error {
+	logging.K8s.WithContext(ctx).WithFields(
 		"namespace", namespace,
 		"deployment_name", deploymentName,
 		"replicas", replicas,
 	).Info("Setting deployment replicas")
 
-	deployment, err := Clientset.AppsV1().Deployments(namespace).Get(
-		context.Background(), deploymentName, metav1.GetOptions{})
+	deployment, err := getWorkload(ctx, namespace, deploymentName)
 	if err != nil {
-		logging.K8s.WithFields(
+		logging.K8s.WithContext(ctx).WithFields(
 			"namespace", namespace,
 			"deployment_name", deploymentName,
 			"error", err.Error(),
@@ -291,10 +386,18 @@ func SetDeploymentReplicas(namespace, deploymentName string, replicas int32) err
 	}
 
 	deployment.Spec.Replicas = &replicas
-	_, err = Clientset.AppsV1().Deployments(namespace).Update(
-		context.Background(), deployment, metav1.UpdateOptions{})
+	syncPodTemplate(deployment)
+	if hibernatedReason != "" {
+		if deployment.Annotations == nil {
+			deployment.Annotations = map[string]string{}
+		}
+		deployment.Annotations[HibernatedAnnotation] = hibernatedReason
+	} else {
+		delete(deployment.Annotations, HibernatedAnnotation)
+	}
+	_, err = updateWorkload(ctx, namespace, deployment)
 	if err != nil {
-		logging.K8s.WithFields(
+		logging.K8s.WithContext(ctx).WithFields(
 			"namespace", namespace,
 			"deployment_name", deploymentName,
 			"replicas", replicas,
@@ -303,7 +406,7 @@ func SetDeploymentReplicas(namespace, deploymentName string, replicas int32) err
 		return err
 	}
 
-	logging.K8s.WithFields(
+	logging.K8s.WithContext(ctx).WithFields(
 		"namespace", namespace,
 		"deployment_name", deploymentName,
 		"replicas", replicas,
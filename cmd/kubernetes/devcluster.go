@@ -0,0 +1,205 @@
+package kubernetes
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"io"
+	"strings"
+	"time"
+
+	"minecharts/cmd/config"
+	"minecharts/cmd/logging"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+// initDevCluster replaces the cluster with an in-memory fake for development mode.
+// The fake has no controllers, so a simulated kubelet runs one ready pod per
+// scaled-up deployment.
+func initDevCluster() {
+	logging.K8s.Warn("Development mode: using an in-memory fake Kubernetes cluster")
+
+	Clientset = fake.NewSimpleClientset(&corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: config.DefaultNamespace},
+	})
+	Config = nil
+
+	go simulateDevPods(context.Background(), config.DefaultNamespace)
+}
+
+// simulateDevPods keeps one running and ready pod for each scaled-up deployment,
+// and completes the jobs as soon as they are created.
+func simulateDevPods(ctx context.Context, namespace string) {
+	factory := informers.NewSharedInformerFactoryWithOptions(Clientset, 0, informers.WithNamespace(namespace))
+	informer := factory.Apps().V1().Deployments().Informer()
+
+	sync := func(obj interface{}) {
+		if deployment, ok := obj.(*appsv1.Deployment); ok {
+			syncDevPod(ctx, deployment)
+		}
+	}
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: sync,
+		UpdateFunc: func(_, obj interface{}) {
+			sync(obj)
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if deployment, ok := obj.(*appsv1.Deployment); ok {
+				_ = Clientset.CoreV1().Pods(deployment.Namespace).Delete(ctx, devPodName(deployment), metav1.DeleteOptions{})
+			}
+		},
+	})
+
+	factory.Batch().V1().Jobs().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if job, ok := obj.(*batchv1.Job); ok {
+				completeDevJob(ctx, job)
+			}
+		},
+	})
+
+	factory.Start(ctx.Done())
+}
+
+// completeDevJob reports a job as succeeded.
+func completeDevJob(ctx context.Context, job *batchv1.Job) {
+	completed := job.DeepCopy()
+	now := metav1.NewTime(time.Now())
+	completed.Status.StartTime = &now
+	completed.Status.CompletionTime = &now
+	completed.Status.Succeeded = 1
+	completed.Status.Conditions = append(completed.Status.Conditions, batchv1.JobCondition{
+		Type:               batchv1.JobComplete,
+		Status:             corev1.ConditionTrue,
+		LastTransitionTime: now,
+	})
+	_, _ = Clientset.BatchV1().Jobs(job.Namespace).UpdateStatus(ctx, completed, metav1.UpdateOptions{})
+	logging.K8s.WithContext(ctx).WithFields(
+		"job_name", job.Name,
+	).Debug("Development mode: simulated job completed")
+}
+
+func devPodName(deployment *appsv1.Deployment) string {
+	return deployment.Name + "-dev"
+}
+
+// syncDevPod creates or deletes the pod of a deployment to match its replicas,
+// and reports the deployment as ready.
+func syncDevPod(ctx context.Context, deployment *appsv1.Deployment) {
+	// The fake replaces whole objects on update, work on the latest version
+	deployment, err := Clientset.AppsV1().Deployments(deployment.Namespace).Get(ctx, deployment.Name, metav1.GetOptions{})
+	if err != nil {
+		return
+	}
+
+	pods := Clientset.CoreV1().Pods(deployment.Namespace)
+	name := devPodName(deployment)
+
+	if desiredReplicas(deployment) == 0 {
+		if err := pods.Delete(ctx, name, metav1.DeleteOptions{}); err == nil {
+			logging.K8s.WithContext(ctx).WithFields(
+				"deployment", deployment.Name,
+			).Debug("Development mode: simulated pod stopped")
+		}
+		setDevDeploymentStatus(ctx, deployment, 0)
+		return
+	}
+
+	if _, err := pods.Get(ctx, name, metav1.GetOptions{}); err == nil {
+		setDevDeploymentStatus(ctx, deployment, 1)
+		return
+	}
+
+	now := metav1.NewTime(time.Now())
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: deployment.Namespace,
+			Labels:    deployment.Spec.Template.Labels,
+		},
+		Spec: deployment.Spec.Template.Spec,
+		Status: corev1.PodStatus{
+			Phase:     corev1.PodRunning,
+			PodIP:     "127.0.0.1",
+			StartTime: &now,
+			Conditions: []corev1.PodCondition{
+				{Type: corev1.PodScheduled, Status: corev1.ConditionTrue},
+				{Type: corev1.PodReady, Status: corev1.ConditionTrue, LastTransitionTime: now},
+			},
+		},
+	}
+	for _, container := range deployment.Spec.Template.Spec.Containers {
+		pod.Status.ContainerStatuses = append(pod.Status.ContainerStatuses, corev1.ContainerStatus{
+			Name:  container.Name,
+			Ready: true,
+			State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{StartedAt: now}},
+		})
+	}
+
+	if _, err := pods.Create(ctx, pod, metav1.CreateOptions{}); err != nil {
+		logging.K8s.WithContext(ctx).WithFields(
+			"deployment", deployment.Name,
+			"error", err.Error(),
+		).Warn("Development mode: failed to simulate pod")
+		return
+	}
+	logging.K8s.WithContext(ctx).WithFields(
+		"deployment", deployment.Name,
+		"pod_name", name,
+	).Debug("Development mode: simulated pod started")
+	setDevDeploymentStatus(ctx, deployment, 1)
+}
+
+func setDevDeploymentStatus(ctx context.Context, deployment *appsv1.Deployment, ready int32) {
+	if deployment.Status.Replicas == ready && deployment.Status.ReadyReplicas == ready &&
+		deployment.Status.UpdatedReplicas == ready && deployment.Status.ObservedGeneration == deployment.Generation {
+		return
+	}
+	updated := deployment.DeepCopy()
+	updated.Status.ObservedGeneration = deployment.Generation
+	updated.Status.Replicas = ready
+	updated.Status.UpdatedReplicas = ready
+	updated.Status.ReadyReplicas = ready
+	updated.Status.AvailableReplicas = ready
+	_, _ = Clientset.AppsV1().Deployments(deployment.Namespace).UpdateStatus(ctx, updated, metav1.UpdateOptions{})
+}
+
+// devExec simulates a command run in a pod in development mode.
+func devExec(podName, command string) (stdout, stderr string, err error) {
+	logging.K8s.WithFields(
+		"pod_name", podName,
+		"command", command,
+	).Debug("Development mode: simulated command execution")
+	if command == usercacheCommand {
+		return `[{"name":"Steve","uuid":"8667ba71-b85a-4004-af54-457a9734eed7","expiresOn":"2099-01-01 00:00:00 +0000"}]`, "", nil
+	}
+	// The simulated volumes are empty
+	if strings.HasPrefix(command, "/bin/sh -c "+listDirectoryScript) {
+		return "", "", nil
+	}
+	return "[dev mode] " + command + "\n", "", nil
+}
+
+// writeDevWorldArchive writes the archive of a simulated world, holding an empty
+// level.dat, in development mode.
+func writeDevWorldArchive(w io.Writer, level string) error {
+	gz := gzip.NewWriter(w)
+	archive := tar.NewWriter(gz)
+	if err := archive.WriteHeader(&tar.Header{Name: level + "/level.dat", Mode: 0o644, ModTime: time.Now()}); err != nil {
+		return err
+	}
+	if err := archive.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
@@ -0,0 +1,112 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+
+	"minecharts/cmd/logging"
+
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// PriorityClassAnnotation records the priority class of a server on its deployment,
+// from which the pod template follows when it changes anyway.
+const PriorityClassAnnotation = "minecharts.io/priority-class"
+
+// DisruptionBudgetName returns the name of the PodDisruptionBudget of a server.
+func DisruptionBudgetName(deploymentName string) string {
+	return deploymentName + "-pdb"
+}
+
+// EnsureDisruptionBudget creates the PodDisruptionBudget of a server if it has none.
+// It allows no voluntary disruption, so drains and the cluster autoscaler wait for the
+// server to be stopped or hibernated rather than evicting players mid-session, except
+// for a pod that is not ready, which has no session to lose.
+func EnsureDisruptionBudget(ctx context.Context, namespace, deploymentName string) error {
+	name := DisruptionBudgetName(deploymentName)
+	maxUnavailable := intstr.FromInt32(0)
+	alwaysAllow := policyv1.AlwaysAllow
+	budget := &policyv1.PodDisruptionBudget{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels: map[string]string{
+				"created-by": "minecharts-api",
+				"app":        deploymentName,
+			},
+		},
+		Spec: policyv1.PodDisruptionBudgetSpec{
+			Selector:                   &metav1.LabelSelector{MatchLabels: map[string]string{"app": deploymentName}},
+			MaxUnavailable:             &maxUnavailable,
+			UnhealthyPodEvictionPolicy: &alwaysAllow,
+		},
+	}
+	annotateChange(ctx, budget)
+	_, err := Clientset.PolicyV1().PodDisruptionBudgets(namespace).Create(ctx, budget, metav1.CreateOptions{FieldManager: FieldManager})
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		logging.K8s.WithContext(ctx).WithFields(
+			"namespace", namespace,
+			"budget_name", name,
+			"error", err.Error(),
+		).Error("Failed to create disruption budget")
+		return fmt.Errorf("failed to create disruption budget: %w", err)
+	}
+
+	logging.K8s.WithContext(ctx).WithFields(
+		"namespace", namespace,
+		"budget_name", name,
+	).Info("Disruption budget ensured")
+	return nil
+}
+
+// DeleteDisruptionBudget deletes the PodDisruptionBudget of a server, if it has one.
+func DeleteDisruptionBudget(ctx context.Context, namespace, deploymentName string) error {
+	name := DisruptionBudgetName(deploymentName)
+	err := Clientset.PolicyV1().PodDisruptionBudgets(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		logging.K8s.WithContext(ctx).WithFields(
+			"namespace", namespace,
+			"budget_name", name,
+			"error", err.Error(),
+		).Error("Failed to delete disruption budget")
+		return fmt.Errorf("failed to delete disruption budget: %w", err)
+	}
+	return nil
+}
+
+// SetPriorityClass records the priority class of a server deployment, empty for none.
+// The pod template only follows right away when the deployment is scaled down,
+// otherwise at the next restart or reconfiguration of the server, as changing the
+// priority of a pod means recreating it. It returns whether the change is pending.
+func SetPriorityClass(ctx context.Context, namespace, deploymentName, priorityClass string) (bool, error) {
+	logging.K8s.WithContext(ctx).WithFields(
+		"namespace", namespace,
+		"deployment_name", deploymentName,
+		"priority_class", priorityClass,
+	).Info("Setting server priority class")
+
+	deployment, err := getWorkload(ctx, namespace, deploymentName)
+	if err != nil {
+		return false, fmt.Errorf("failed to get deployment: %w", err)
+	}
+	if priorityClass == "" {
+		delete(deployment.Annotations, PriorityClassAnnotation)
+	} else {
+		if deployment.Annotations == nil {
+			deployment.Annotations = map[string]string{}
+		}
+		deployment.Annotations[PriorityClassAnnotation] = priorityClass
+	}
+	pending := deployment.Spec.Template.Spec.PriorityClassName != priorityClass
+	if deployment.Spec.Replicas != nil && *deployment.Spec.Replicas == 0 {
+		syncPodTemplate(deployment)
+		pending = false
+	}
+	if _, err := updateWorkload(ctx, namespace, deployment); err != nil {
+		return false, fmt.Errorf("failed to update deployment priority class: %w", err)
+	}
+	return pending, nil
+}
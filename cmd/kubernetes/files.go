@@ -0,0 +1,122 @@
+package kubernetes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"minecharts/cmd/config"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// ErrServerFilesBusy is returned while another file operation runs on a stopped server.
+var ErrServerFilesBusy = errors.New("another file operation is running for this server")
+
+// ServerFilesPodName returns the name of the pod working on the files of a stopped server.
+func ServerFilesPodName(deploymentName string) string {
+	return deploymentName + "-files"
+}
+
+// WriteServerFile writes the content of r to a file of a server's volume, given by its
+// path in the server container, and creates its directory if needed. The file is
+// written under a temporary name and renamed once complete, so the server never loads
+// a partial file.
+func WriteServerFile(ctx context.Context, namespace, deploymentName, volume, filePath string, r io.Reader) error {
+	if err := checkServerFilePath(filePath); err != nil {
+		return err
+	}
+	// The path is an argument of the script rather than a part of it
+	script := `mkdir -p "$(dirname "$1")" && cat > "$1.tmp" && mv "$1.tmp" "$1" && { chown "$(stat -c %u:%g /data)" "$1" 2>/dev/null || true; }`
+	return onServerVolume(ctx, namespace, deploymentName, volume, config.ExecTimeout, func(podName, containerName string) error {
+		_, stderr, err := streamToPod(ctx, podName, namespace, containerName, []string{"/bin/sh", "-c", script, "sh", filePath}, r)
+		if err != nil {
+			return fmt.Errorf("failed to write %s: %w", filePath, joinExecError(err, stderr))
+		}
+		return nil
+	})
+}
+
+// RemoveServerFile removes a file of a server's volume, given by its path in the
+// server container. A missing file is not an error.
+func RemoveServerFile(ctx context.Context, namespace, deploymentName, volume, filePath string) error {
+	if err := checkServerFilePath(filePath); err != nil {
+		return err
+	}
+	return onServerVolume(ctx, namespace, deploymentName, volume, config.ExecTimeout, func(podName, containerName string) error {
+		_, stderr, err := streamToPod(ctx, podName, namespace, containerName, []string{"/bin/sh", "-c", `rm -f "$1"`, "sh", filePath}, nil)
+		if err != nil {
+			return fmt.Errorf("failed to remove %s: %w", filePath, joinExecError(err, stderr))
+		}
+		return nil
+	})
+}
+
+// ListServerDirectory returns the names of the entries of a directory of a server's
+// volume, given by its path in the server container. A missing directory is empty.
+func ListServerDirectory(ctx context.Context, namespace, deploymentName, volume, dirPath string) ([]string, error) {
+	if err := checkServerFilePath(dirPath); err != nil {
+		return nil, err
+	}
+	var names []string
+	err := onServerVolume(ctx, namespace, deploymentName, volume, config.ExecTimeout, func(podName, containerName string) error {
+		stdout, stderr, err := streamToPod(ctx, podName, namespace, containerName, []string{"/bin/sh", "-c", listDirectoryScript, "sh", dirPath}, nil)
+		if err != nil {
+			return fmt.Errorf("failed to list %s: %w", dirPath, joinExecError(err, stderr))
+		}
+		for _, name := range strings.Split(stdout, "\n") {
+			if name != "" {
+				names = append(names, name)
+			}
+		}
+		return nil
+	})
+	return names, err
+}
+
+// listDirectoryScript prints the entries of the directory given as argument, one per line.
+const listDirectoryScript = `[ -d "$1" ] || exit 0; cd "$1" && for entry in *; do [ -e "$entry" ] && echo "$entry"; done; true`
+
+// checkServerFilePath checks that a path stays in the server volume.
+func checkServerFilePath(filePath string) error {
+	if path.Clean(filePath) != filePath || !strings.HasPrefix(filePath, "/data/") {
+		return fmt.Errorf("invalid server file path %q", filePath)
+	}
+	return nil
+}
+
+// onServerVolume runs fn with a pod container mounting the volume of a server at /data:
+// the server container when it runs, or a helper pod living at most deadline when the
+// server is stopped.
+func onServerVolume(ctx context.Context, namespace, deploymentName, volume string, deadline time.Duration, fn func(podName, containerName string) error) error {
+	pod, err := GetMinecraftPod(ctx, namespace, deploymentName)
+	if err != nil {
+		return fmt.Errorf("failed to get server pod: %w", err)
+	}
+	if pod != nil {
+		if pod.Status.Phase != corev1.PodRunning {
+			return ErrServerStarting
+		}
+		return fn(pod.Name, "minecraft-server")
+	}
+
+	podName := ServerFilesPodName(deploymentName)
+	err = createVolumePod(ctx, namespace, deploymentName, podName, "server-files", volume, deadline)
+	if apierrors.IsAlreadyExists(err) {
+		return ErrServerFilesBusy
+	}
+	if err != nil {
+		return err
+	}
+	defer deleteVolumePod(ctx, namespace, podName)
+
+	if err := waitForPodRunning(ctx, namespace, podName); err != nil {
+		return err
+	}
+	return fn(podName, "server-files")
+}
@@ -0,0 +1,147 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"minecharts/cmd/config"
+	"minecharts/cmd/logging"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Kinds of the resources created by the API for a server.
+const (
+	KindDeployment  = "Deployment"
+	KindStatefulSet = "StatefulSet"
+	KindService     = "Service"
+	KindSecret      = "Secret"
+	KindPVC         = "PersistentVolumeClaim"
+)
+
+// ManagedResource is a Kubernetes resource created by the API for a server.
+type ManagedResource struct {
+	Kind       string    `json:"kind" example:"PersistentVolumeClaim"`
+	Name       string    `json:"name" example:"minecraft-server-survival-pvc"`
+	Namespace  string    `json:"namespace" example:"minecharts"`
+	Deployment string    `json:"deployment" example:"minecraft-server-survival"` // Deployment of the server the resource belongs to
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+// gcKindOrder deletes the workloads before the data they use.
+var gcKindOrder = map[string]int{KindDeployment: 0, KindStatefulSet: 0, KindService: 1, KindSecret: 2, KindPVC: 3}
+
+// ListManagedResources lists the deployments, StatefulSets, services, secrets and PVCs created
+// by the API in a namespace, or in every namespace with metav1.NamespaceAll. PVCs
+// created before they were labeled are matched by their name, in the default namespace
+// they were created in.
+func ListManagedResources(ctx context.Context, namespace string) ([]ManagedResource, error) {
+	options := metav1.ListOptions{LabelSelector: managedSelector}
+	var resources []ManagedResource
+
+	workloads, err := listWorkloads(ctx, namespace)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range workloads {
+		kind := KindDeployment
+		if IsStatefulSet(item) {
+			kind = KindStatefulSet
+		}
+		resources = append(resources, managedResource(kind, item.ObjectMeta))
+	}
+
+	services, err := Clientset.CoreV1().Services(namespace).List(ctx, options)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list services: %w", err)
+	}
+	for _, item := range services.Items {
+		resources = append(resources, managedResource(KindService, item.ObjectMeta))
+	}
+
+	secrets, err := Clientset.CoreV1().Secrets(namespace).List(ctx, options)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list secrets: %w", err)
+	}
+	for _, item := range secrets.Items {
+		resources = append(resources, managedResource(KindSecret, item.ObjectMeta))
+	}
+
+	pvcs, err := Clientset.CoreV1().PersistentVolumeClaims(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list PVCs: %w", err)
+	}
+	for _, item := range pvcs.Items {
+		if item.Labels["created-by"] == "minecharts-api" || item.Namespace == config.DefaultNamespace &&
+			strings.HasPrefix(item.Name, config.DeploymentPrefix) && strings.HasSuffix(item.Name, config.PVCSuffix) {
+			resource := managedResource(KindPVC, item.ObjectMeta)
+			if resource.Deployment == "" {
+				resource.Deployment = strings.TrimSuffix(item.Name, config.PVCSuffix)
+			}
+			resources = append(resources, resource)
+		}
+	}
+
+	logging.K8s.WithContext(ctx).WithFields(
+		"namespace", namespace,
+		"resource_count", len(resources),
+	).Debug("Managed resources listed")
+	return resources, nil
+}
+
+func managedResource(kind string, meta metav1.ObjectMeta) ManagedResource {
+	return ManagedResource{
+		Kind:       kind,
+		Name:       meta.Name,
+		Namespace:  meta.Namespace,
+		Deployment: meta.Labels["app"],
+		CreatedAt:  meta.CreationTimestamp.Time,
+	}
+}
+
+// OrphanedResources returns the managed resources of a namespace whose server has
+// no record, given the deployment and PVC names of the recorded servers. Resources created
+// in the last minutes are left out as their server may still be provisioning.
+func OrphanedResources(ctx context.Context, namespace string, recorded map[string]bool) ([]ManagedResource, error) {
+	resources, err := ListManagedResources(ctx, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	var orphans []ManagedResource
+	for _, resource := range resources {
+		if recorded[resource.Deployment] || recorded[resource.Name] || time.Since(resource.CreatedAt) < provisioningGrace {
+			continue
+		}
+		orphans = append(orphans, resource)
+	}
+
+	// Stable order, deployments first so servers stop before their volume is removed
+	sort.Slice(orphans, func(i, j int) bool {
+		if gcKindOrder[orphans[i].Kind] != gcKindOrder[orphans[j].Kind] {
+			return gcKindOrder[orphans[i].Kind] < gcKindOrder[orphans[j].Kind]
+		}
+		return orphans[i].Name < orphans[j].Name
+	})
+	return orphans, nil
+}
+
+// DeleteManagedResource deletes a resource returned by ListManagedResources.
+func DeleteManagedResource(ctx context.Context, resource ManagedResource) error {
+	namespace := resource.Namespace
+	switch resource.Kind {
+	case KindDeployment, KindStatefulSet:
+		return DeleteDeployment(ctx, namespace, resource.Name)
+	case KindService:
+		return DeleteService(ctx, namespace, resource.Name)
+	case KindSecret:
+		return DeleteSecret(ctx, namespace, resource.Name)
+	case KindPVC:
+		return DeletePVC(ctx, namespace, resource.Name)
+	default:
+		return fmt.Errorf("unsupported resource kind %q", resource.Kind)
+	}
+}
@@ -0,0 +1,187 @@
+package kubernetes
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"minecharts/cmd/database"
+	"minecharts/cmd/logging"
+	"minecharts/cmd/mcproto"
+)
+
+// idleMonitor hibernates the running servers nobody played on for a while.
+type idleMonitor struct {
+	namespace string
+	store     ServerStore
+	idleAfter time.Duration
+	// idleSince holds when each running server was first seen without players.
+	idleSince map[string]time.Time
+}
+
+// StartIdleMonitor checks the player count of the running servers every interval
+// until the context is cancelled. A server seen without players for idleAfter has
+// its world saved, its deployment scaled to 0 and is recorded as hibernated; it
+// wakes up like a stopped server, through the start endpoint.
+//
+// Servers that do not answer the status ping are left running: the monitor only
+// hibernates servers it saw empty for the whole period.
+func StartIdleMonitor(ctx context.Context, namespace string, store ServerStore, interval, idleAfter time.Duration) {
+	if idleAfter <= 0 {
+		return
+	}
+	if interval <= 0 {
+		interval = time.Minute
+	}
+
+	m := &idleMonitor{
+		namespace: namespace,
+		store:     store,
+		idleAfter: idleAfter,
+		idleSince: map[string]time.Time{},
+	}
+
+	logging.K8s.WithContext(ctx).WithFields(
+		"namespace", namespace,
+		"interval", interval.String(),
+		"idle_after", idleAfter.String(),
+	).Info("Idle shutdown enabled")
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				m.check(ctx)
+			}
+		}
+	}()
+}
+
+func (m *idleMonitor) check(ctx context.Context) {
+	if !ClusterReachable() {
+		logging.K8s.WithContext(ctx).Debug("Skipping idle check, cluster unreachable")
+		return
+	}
+
+	servers, err := m.store.ListServers(ctx)
+	if err != nil {
+		logging.K8s.WithContext(ctx).WithFields(
+			"error", err.Error(),
+		).Warn("Idle check failed to list servers")
+		return
+	}
+
+	running := map[string]bool{}
+	for _, server := range servers {
+		if server.Status != database.ServerStatusRunning {
+			continue
+		}
+		running[server.ServerName] = true
+
+		online, err := m.onlinePlayers(ctx, cmp.Or(server.Namespace, m.namespace), server.DeploymentName)
+		if err != nil {
+			logging.K8s.WithContext(ctx).WithFields(
+				"server_name", server.ServerName,
+				"error", err.Error(),
+			).Debug("Idle check could not count players")
+			delete(m.idleSince, server.ServerName)
+			continue
+		}
+		if online > 0 {
+			delete(m.idleSince, server.ServerName)
+			continue
+		}
+
+		since, ok := m.idleSince[server.ServerName]
+		if !ok {
+			m.idleSince[server.ServerName] = time.Now()
+			continue
+		}
+		if time.Since(since) < m.idleAfter {
+			continue
+		}
+
+		if err := m.hibernate(ctx, server); err != nil {
+			logging.K8s.WithContext(ctx).WithFields(
+				"server_name", server.ServerName,
+				"deployment", server.DeploymentName,
+				"error", err.Error(),
+			).Warn("Failed to hibernate idle server")
+			continue
+		}
+		delete(m.idleSince, server.ServerName)
+	}
+
+	// Forget the servers that stopped or were deleted meanwhile
+	for name := range m.idleSince {
+		if !running[name] {
+			delete(m.idleSince, name)
+		}
+	}
+}
+
+// onlinePlayers returns the number of players connected to a server, with a
+// status ping to its pod.
+func (m *idleMonitor) onlinePlayers(ctx context.Context, namespace, deploymentName string) (int, error) {
+	pod := CachedMinecraftPod(deploymentName)
+	if pod == nil {
+		var err error
+		if pod, err = GetMinecraftPod(ctx, namespace, deploymentName); err != nil {
+			return 0, err
+		}
+	}
+	if pod == nil || pod.Status.PodIP == "" {
+		return 0, fmt.Errorf("no running pod for %s", deploymentName)
+	}
+
+	pingCtx, cancel := context.WithTimeout(ctx, mcproto.DefaultTimeout)
+	defer cancel()
+	status, err := mcproto.Ping(pingCtx, net.JoinHostPort(pod.Status.PodIP, "25565"))
+	if err != nil {
+		return 0, err
+	}
+	return status.Online, nil
+}
+
+// hibernate saves the world of an idle server and scales its deployment to 0.
+func (m *idleMonitor) hibernate(ctx context.Context, server *database.MinecraftServer) error {
+	ctx = WithActor(ctx, Actor{Username: "system:idle-shutdown"})
+	namespace := cmp.Or(server.Namespace, m.namespace)
+
+	logging.K8s.WithContext(ctx).WithFields(
+		"server_name", server.ServerName,
+		"deployment", server.DeploymentName,
+		"idle_after", m.idleAfter.String(),
+	).Info("Hibernating idle server")
+
+	pod, err := GetMinecraftPod(ctx, namespace, server.DeploymentName)
+	if err != nil {
+		return err
+	}
+	if pod != nil {
+		if _, _, err := SaveWorld(ctx, pod.Name, namespace); err != nil {
+			return fmt.Errorf("failed to save world: %w", err)
+		}
+	}
+
+	reason := "No players for " + m.idleAfter.String()
+	if err := HibernateDeployment(ctx, namespace, server.DeploymentName, reason); err != nil {
+		return fmt.Errorf("failed to scale deployment: %w", err)
+	}
+	if err := m.store.UpdateServerStatus(ctx, server.ServerName, database.ServerStatusHibernated, reason); err != nil {
+		return fmt.Errorf("failed to record server as hibernated: %w", err)
+	}
+
+	logging.Server.Hibernated.WithContext(ctx).WithFields(
+		"server_name", server.ServerName,
+		"deployment", server.DeploymentName,
+		"reason", reason,
+	).Info("Idle server hibernated")
+	return nil
+}
@@ -0,0 +1,162 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+
+	"minecharts/cmd/database"
+	"minecharts/cmd/events"
+	"minecharts/cmd/logging"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// ServerWatcherStore persists what the server watcher derives from the server pods:
+// their provisioning state and the incidents of their containers.
+type ServerWatcherStore interface {
+	ServerStatusStore
+	GetServerByName(ctx context.Context, serverName string) (*database.MinecraftServer, error)
+	CreateIncident(ctx context.Context, incident *database.Incident) error
+	CreateNotification(ctx context.Context, notification *database.Notification) error
+}
+
+// serverContainerStatus returns the status of the minecraft-server container of a pod.
+func serverContainerStatus(pod *corev1.Pod) *corev1.ContainerStatus {
+	for i := range pod.Status.ContainerStatuses {
+		if pod.Status.ContainerStatuses[i].Name == "minecraft-server" {
+			return &pod.Status.ContainerStatuses[i]
+		}
+	}
+	return nil
+}
+
+// podIncident compares two states of a server pod and returns the incident of its
+// container, if it was just OOM killed or just entered a crash loop.
+func podIncident(oldPod, newPod *corev1.Pod) *database.Incident {
+	current := serverContainerStatus(newPod)
+	if current == nil {
+		return nil
+	}
+	var previous corev1.ContainerStatus
+	if oldPod.UID == newPod.UID {
+		if status := serverContainerStatus(oldPod); status != nil {
+			previous = *status
+		}
+	}
+
+	incident := &database.Incident{
+		RestartCount: current.RestartCount,
+		PodName:      newPod.Name,
+	}
+	if terminated := current.LastTerminationState.Terminated; terminated != nil {
+		incident.Reason = joinReason(terminated.Reason, terminated.Message, "")
+		incident.ExitCode = terminated.ExitCode
+	}
+
+	// The kubelet restarts the container right away, the kill shows as the last termination
+	if current.RestartCount > previous.RestartCount && current.LastTerminationState.Terminated != nil &&
+		current.LastTerminationState.Terminated.Reason == "OOMKilled" {
+		incident.Kind = database.IncidentOOMKilled
+		return incident
+	}
+	if waiting := current.State.Waiting; waiting != nil && waiting.Reason == "CrashLoopBackOff" &&
+		(previous.State.Waiting == nil || previous.State.Waiting.Reason != "CrashLoopBackOff") {
+		incident.Kind = database.IncidentCrashLoop
+		if incident.Reason == "" {
+			incident.Reason = joinReason(waiting.Reason, waiting.Message, "")
+		}
+		return incident
+	}
+	return nil
+}
+
+// onPodUpdated records the incidents of the server containers. An incident of a kind
+// is recorded once per crash episode: the next one is recorded after the pod has been
+// ready again or was replaced, so that a crash loop does not record an incident at
+// every backoff.
+func (w *serverWatcher) onPodUpdated(oldObj, newObj interface{}) {
+	oldPod, ok1 := oldObj.(*corev1.Pod)
+	newPod, ok2 := newObj.(*corev1.Pod)
+	if !ok1 || !ok2 || newPod.DeletionTimestamp != nil {
+		return
+	}
+	serverName, ok := podServerName(newPod)
+	if !ok {
+		return
+	}
+
+	if status, _ := PodServerStatus(newPod); status == database.ServerStatusRunning {
+		delete(w.incidents, newPod.UID)
+	}
+	incident := podIncident(oldPod, newPod)
+	if incident == nil {
+		return
+	}
+	if w.incidents[newPod.UID][incident.Kind] {
+		return
+	}
+
+	server, err := w.store.GetServerByName(context.Background(), serverName)
+	if err != nil {
+		logging.K8s.WithFields(
+			"server_name", serverName,
+			"kind", incident.Kind,
+			"error", err.Error(),
+		).Warn("Failed to find server of incident")
+		return
+	}
+	incident.ServerID = server.ID
+	incident.ServerName = server.ServerName
+	if err := w.store.CreateIncident(context.Background(), incident); err != nil {
+		logging.K8s.WithFields(
+			"server_name", serverName,
+			"kind", incident.Kind,
+			"error", err.Error(),
+		).Warn("Failed to record incident")
+		return
+	}
+	if w.incidents[newPod.UID] == nil {
+		w.incidents[newPod.UID] = map[string]bool{}
+	}
+	w.incidents[newPod.UID][incident.Kind] = true
+
+	logging.K8s.WithFields(
+		"server_name", serverName,
+		"pod_name", newPod.Name,
+		"kind", incident.Kind,
+		"reason", incident.Reason,
+		"restart_count", incident.RestartCount,
+	).Warn("Server container crashed")
+
+	if err := w.store.CreateNotification(context.Background(), &database.Notification{
+		UserID:  server.OwnerID,
+		Message: incidentMessage(incident),
+	}); err != nil {
+		logging.K8s.WithFields(
+			"server_name", serverName,
+			"error", err.Error(),
+		).Warn("Failed to notify the owner of an incident")
+	}
+	events.Publish(context.Background(), events.Event{
+		Type:    events.ServerIncident,
+		Server:  server.ServerName,
+		OwnerID: server.OwnerID,
+		Time:    incident.CreatedAt,
+		Data:    incident,
+	})
+}
+
+// incidentMessage describes an incident to the owner of the server.
+func incidentMessage(incident *database.Incident) string {
+	switch incident.Kind {
+	case database.IncidentOOMKilled:
+		return fmt.Sprintf("Server %s ran out of memory and was restarted (restart count %d). Consider raising its memory limit.",
+			incident.ServerName, incident.RestartCount)
+	default:
+		message := fmt.Sprintf("Server %s keeps crashing and is restarted with a backoff (restart count %d)", incident.ServerName, incident.RestartCount)
+		if incident.Reason != "" {
+			message += ": " + incident.Reason
+		}
+		return message + "."
+	}
+}
@@ -0,0 +1,120 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"minecharts/cmd/logging"
+
+	appsv1 "k8s.io/api/apps/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// CustomLabelPrefix prefixes the custom labels of the servers in their Kubernetes
+// objects, so that cost tools such as OpenCost can slice the usage by them.
+const CustomLabelPrefix = "label.minecharts.io/"
+
+// withCustomLabels returns labels where the custom labels are replaced by the given ones.
+func withCustomLabels(labels, custom map[string]string) map[string]string {
+	result := make(map[string]string, len(labels)+len(custom))
+	for key, value := range labels {
+		if !strings.HasPrefix(key, CustomLabelPrefix) {
+			result[key] = value
+		}
+	}
+	for key, value := range custom {
+		result[CustomLabelPrefix+key] = value
+	}
+	return result
+}
+
+// customLabels returns the custom labels carried by the labels of an object.
+func customLabels(labels map[string]string) map[string]string {
+	custom := make(map[string]string)
+	for key, value := range labels {
+		if name, ok := strings.CutPrefix(key, CustomLabelPrefix); ok {
+			custom[name] = value
+		}
+	}
+	return custom
+}
+
+// syncPodTemplate copies the custom labels, the priority class and the node placement
+// of a deployment to its pod template, and brings its probes to the configuration. It
+// is called by the updates that change the pod template anyway, as changing the
+// template alone would restart the server.
+func syncPodTemplate(deployment *appsv1.Deployment) {
+	deployment.Spec.Template.Labels = withCustomLabels(deployment.Spec.Template.Labels, customLabels(deployment.Labels))
+	deployment.Spec.Template.Spec.PriorityClassName = deployment.Annotations[PriorityClassAnnotation]
+	if placement, ok := deploymentPlacement(deployment); ok {
+		applyPlacement(&deployment.Spec.Template.Spec, placement)
+	}
+	syncProbes(deployment)
+}
+
+// SetServerLabels replaces the custom labels of a server deployment, its storage, its
+// services and its running pods. The pod template only follows right away when the
+// deployment is scaled down, otherwise at the next restart or reconfiguration of the
+// server, so that the server is not restarted for a label change.
+func SetServerLabels(ctx context.Context, namespace, deploymentName, pvcName string, labels map[string]string) error {
+	logging.K8s.WithContext(ctx).WithFields(
+		"namespace", namespace,
+		"deployment_name", deploymentName,
+		"labels", len(labels),
+	).Info("Setting server labels")
+
+	deployment, err := getWorkload(ctx, namespace, deploymentName)
+	if err != nil {
+		return fmt.Errorf("failed to get deployment: %w", err)
+	}
+	deployment.Labels = withCustomLabels(deployment.Labels, labels)
+	if deployment.Spec.Replicas != nil && *deployment.Spec.Replicas == 0 {
+		syncPodTemplate(deployment)
+	}
+	if _, err := updateWorkload(ctx, namespace, deployment); err != nil {
+		return fmt.Errorf("failed to update deployment labels: %w", err)
+	}
+
+	selector := metav1.ListOptions{LabelSelector: "app=" + deploymentName}
+	pods, err := Clientset.CoreV1().Pods(namespace).List(ctx, selector)
+	if err != nil {
+		return fmt.Errorf("failed to list server pods: %w", err)
+	}
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		pod.Labels = withCustomLabels(pod.Labels, labels)
+		if _, err := Clientset.CoreV1().Pods(namespace).Update(ctx, pod, metav1.UpdateOptions{FieldManager: FieldManager}); err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to update labels of pod %s: %w", pod.Name, err)
+		}
+	}
+
+	services, err := Clientset.CoreV1().Services(namespace).List(ctx, selector)
+	if err != nil {
+		return fmt.Errorf("failed to list server services: %w", err)
+	}
+	for i := range services.Items {
+		service := &services.Items[i]
+		service.Labels = withCustomLabels(service.Labels, labels)
+		annotateChange(ctx, service)
+		if _, err := Clientset.CoreV1().Services(namespace).Update(ctx, service, metav1.UpdateOptions{FieldManager: FieldManager}); err != nil {
+			return fmt.Errorf("failed to update labels of service %s: %w", service.Name, err)
+		}
+	}
+
+	// Storage drivers other than PVCs have no claim to label
+	pvc, err := Clientset.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, pvcName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get PVC: %w", err)
+	}
+	pvc.Labels = withCustomLabels(pvc.Labels, labels)
+	annotateChange(ctx, pvc)
+	if _, err := Clientset.CoreV1().PersistentVolumeClaims(namespace).Update(ctx, pvc, metav1.UpdateOptions{FieldManager: FieldManager}); err != nil {
+		return fmt.Errorf("failed to update PVC labels: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package kubernetes
+
+import (
+	"bufio"
+	"context"
+	"sync"
+	"time"
+
+	"minecharts/cmd/events"
+	"minecharts/cmd/logging"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/utils/ptr"
+)
+
+const (
+	// logTailLines is how many past lines of the console a log follower starts with.
+	logTailLines = 50
+	// logRetryDelay is the wait before following the logs of the server pod again,
+	// once its stream ended with a restart or the pod is not running.
+	logRetryDelay = 5 * time.Second
+	// maxLogLine bounds the length of the console lines published.
+	maxLogLine = 16 << 10
+)
+
+// logFollowers follow the console of the servers streamed to the event subscribers,
+// one per server however many subscribers stream it.
+var logFollowers struct {
+	sync.Mutex
+	followers map[string]*logFollower
+}
+
+type logFollower struct {
+	refs   int
+	cancel context.CancelFunc
+}
+
+// FollowServerLogs publishes the console lines of a server on the event bus as
+// events.ServerLog events, until the returned release function has been called by
+// every caller following the same server.
+func FollowServerLogs(namespace, deploymentName, serverName string, ownerID int64) (release func()) {
+	logFollowers.Lock()
+	defer logFollowers.Unlock()
+	if logFollowers.followers == nil {
+		logFollowers.followers = map[string]*logFollower{}
+	}
+
+	follower, ok := logFollowers.followers[serverName]
+	if !ok {
+		ctx, cancel := context.WithCancel(context.Background())
+		follower = &logFollower{cancel: cancel}
+		logFollowers.followers[serverName] = follower
+		go followLogs(ctx, namespace, deploymentName, serverName, ownerID)
+	}
+	follower.refs++
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			logFollowers.Lock()
+			defer logFollowers.Unlock()
+			follower.refs--
+			if follower.refs == 0 {
+				follower.cancel()
+				delete(logFollowers.followers, serverName)
+			}
+		})
+	}
+}
+
+// followLogs follows the logs of the server pod, following the next pod once it is
+// replaced, until the context is cancelled.
+func followLogs(ctx context.Context, namespace, deploymentName, serverName string, ownerID int64) {
+	lastPod := ""
+	for ctx.Err() == nil {
+		pod, err := GetMinecraftPod(ctx, namespace, deploymentName)
+		if err == nil && pod != nil && pod.Status.Phase == corev1.PodRunning {
+			// Starts with the last lines of the console, then continues where the
+			// previous stream ended: the whole log of a new pod, or the lines written
+			// since the stream dropped otherwise
+			options := &corev1.PodLogOptions{Container: "minecraft-server", Follow: true}
+			switch lastPod {
+			case "":
+				options.TailLines = ptr.To(int64(logTailLines))
+			case pod.Name:
+				options.SinceSeconds = ptr.To(int64(logRetryDelay.Seconds()))
+			}
+			lastPod = pod.Name
+			err = streamPodLogs(ctx, namespace, pod.Name, serverName, ownerID, options)
+		}
+		if err != nil && ctx.Err() == nil {
+			logging.K8s.WithContext(ctx).WithFields(
+				"server_name", serverName,
+				"error", err.Error(),
+			).Debug("Server log stream ended")
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(logRetryDelay):
+		}
+	}
+}
+
+func streamPodLogs(ctx context.Context, namespace, podName, serverName string, ownerID int64, options *corev1.PodLogOptions) error {
+	stream, err := Clientset.CoreV1().Pods(namespace).GetLogs(podName, options).Stream(ctx)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	scanner := bufio.NewScanner(stream)
+	scanner.Buffer(make([]byte, 4096), maxLogLine)
+	for scanner.Scan() {
+		events.Publish(ctx, events.Event{
+			Type:    events.ServerLog,
+			Server:  serverName,
+			OwnerID: ownerID,
+			Data:    events.LogLine{Pod: podName, Line: scanner.Text()},
+		})
+	}
+	return scanner.Err()
+}
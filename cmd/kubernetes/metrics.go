@@ -0,0 +1,44 @@
+package kubernetes
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"minecharts/cmd/metrics"
+)
+
+// metricsTransport counts the failed calls to the Kubernetes API, those without a
+// response or answered with an error status.
+type metricsTransport struct {
+	next http.RoundTripper
+}
+
+func (t *metricsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	switch {
+	case err != nil && req.Context().Err() == nil:
+		metrics.KubernetesAPIErrors.Inc(req.Method, apiResource(req.URL.Path), "error")
+	case err == nil && resp.StatusCode >= http.StatusBadRequest:
+		metrics.KubernetesAPIErrors.Inc(req.Method, apiResource(req.URL.Path), strconv.Itoa(resp.StatusCode))
+	}
+	return resp, err
+}
+
+// apiResource returns the resource of a Kubernetes API path, such as "deployments"
+// for /apis/apps/v1/namespaces/default/deployments/minecraft-server-survival.
+func apiResource(path string) string {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	switch {
+	case len(parts) > 2 && parts[0] == "api":
+		parts = parts[2:]
+	case len(parts) > 3 && parts[0] == "apis":
+		parts = parts[3:]
+	default:
+		return "other"
+	}
+	if len(parts) > 2 && parts[0] == "namespaces" {
+		parts = parts[2:]
+	}
+	return parts[0]
+}
@@ -0,0 +1,150 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"minecharts/cmd/config"
+	"minecharts/cmd/logging"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// ServerPolicy describes who may reach the pod of a server.
+type ServerPolicy struct {
+	Java    bool // Listens on the Java port
+	Bedrock bool // Listens on the Bedrock port
+	Public  bool // Exposed with a NodePort or LoadBalancer service, reached from anywhere on its game ports
+}
+
+// NetworkPolicyName returns the name of the NetworkPolicy of a server.
+func NetworkPolicyName(deploymentName string) string {
+	return deploymentName + "-netpol"
+}
+
+// ApplyNetworkPolicy creates the NetworkPolicy of a server pod, or updates it to the
+// policy. The pods of the API reach every port, the proxies and mc-router the Java
+// port, and the pods connect to DNS and to config.NetworkPolicyEgress only.
+func ApplyNetworkPolicy(ctx context.Context, namespace, deploymentName string, policy ServerPolicy) error {
+	name := NetworkPolicyName(deploymentName)
+	policies := Clientset.NetworkingV1().NetworkPolicies(namespace)
+	networkPolicy := &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels: map[string]string{
+				"created-by": "minecharts-api",
+				"app":        deploymentName,
+			},
+		},
+		Spec: serverPolicySpec(deploymentName, policy),
+	}
+	annotateChange(ctx, networkPolicy)
+	_, err := policies.Create(ctx, networkPolicy, metav1.CreateOptions{FieldManager: FieldManager})
+	if apierrors.IsAlreadyExists(err) {
+		existing, getErr := policies.Get(ctx, name, metav1.GetOptions{})
+		if getErr != nil {
+			return fmt.Errorf("failed to get network policy: %w", getErr)
+		}
+		existing.Spec = networkPolicy.Spec
+		annotateChange(ctx, existing)
+		_, err = policies.Update(ctx, existing, metav1.UpdateOptions{FieldManager: FieldManager})
+	}
+	if err != nil {
+		logging.K8s.WithContext(ctx).WithFields(
+			"namespace", namespace,
+			"policy_name", name,
+			"error", err.Error(),
+		).Error("Failed to apply network policy")
+		return fmt.Errorf("failed to apply network policy: %w", err)
+	}
+
+	logging.K8s.WithContext(ctx).WithFields(
+		"namespace", namespace,
+		"policy_name", name,
+		"public", policy.Public,
+	).Info("Network policy applied")
+	return nil
+}
+
+// HasNetworkPolicy tells whether a server has a NetworkPolicy.
+func HasNetworkPolicy(ctx context.Context, namespace, deploymentName string) (bool, error) {
+	_, err := Clientset.NetworkingV1().NetworkPolicies(namespace).Get(ctx, NetworkPolicyName(deploymentName), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// DeleteNetworkPolicy deletes the NetworkPolicy of a server, if it has one.
+func DeleteNetworkPolicy(ctx context.Context, namespace, deploymentName string) error {
+	name := NetworkPolicyName(deploymentName)
+	err := Clientset.NetworkingV1().NetworkPolicies(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		logging.K8s.WithContext(ctx).WithFields(
+			"namespace", namespace,
+			"policy_name", name,
+			"error", err.Error(),
+		).Error("Failed to delete network policy")
+		return fmt.Errorf("failed to delete network policy: %w", err)
+	}
+	return nil
+}
+
+// serverPolicySpec returns the ingress and egress rules of the pod of a server.
+func serverPolicySpec(deploymentName string, policy ServerPolicy) networkingv1.NetworkPolicySpec {
+	tcp, udp := corev1.ProtocolTCP, corev1.ProtocolUDP
+	javaPort, bedrockPort, dnsPort := intstr.FromInt32(25565), intstr.FromInt32(BedrockPort), intstr.FromInt32(53)
+	apiNamespace := &metav1.LabelSelector{MatchLabels: map[string]string{corev1.LabelMetadataName: config.DefaultNamespace}}
+
+	// The selectors are validated with the configuration
+	apiPods, _ := metav1.ParseToLabelSelector(config.NetworkPolicyAPISelector)
+	routerPods, _ := metav1.ParseToLabelSelector(config.NetworkPolicyRouterSelector)
+	ingress := []networkingv1.NetworkPolicyIngressRule{
+		{From: []networkingv1.NetworkPolicyPeer{{NamespaceSelector: apiNamespace, PodSelector: apiPods}}},
+	}
+	if policy.Java {
+		ingress = append(ingress, networkingv1.NetworkPolicyIngressRule{
+			Ports: []networkingv1.NetworkPolicyPort{{Protocol: &tcp, Port: &javaPort}},
+			From: []networkingv1.NetworkPolicyPeer{
+				{NamespaceSelector: apiNamespace, PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{ProxyLabel: "true"}}},
+				{NamespaceSelector: &metav1.LabelSelector{}, PodSelector: routerPods},
+			},
+		})
+	}
+	if policy.Public {
+		var ports []networkingv1.NetworkPolicyPort
+		if policy.Java {
+			ports = append(ports, networkingv1.NetworkPolicyPort{Protocol: &tcp, Port: &javaPort})
+		}
+		if policy.Bedrock {
+			ports = append(ports, networkingv1.NetworkPolicyPort{Protocol: &udp, Port: &bedrockPort})
+		}
+		ingress = append(ingress, networkingv1.NetworkPolicyIngressRule{Ports: ports})
+	}
+
+	egress := []networkingv1.NetworkPolicyEgressRule{
+		{Ports: []networkingv1.NetworkPolicyPort{{Protocol: &udp, Port: &dnsPort}, {Protocol: &tcp, Port: &dnsPort}}},
+	}
+	var destinations []networkingv1.NetworkPolicyPeer
+	for _, cidr := range strings.Split(config.NetworkPolicyEgress, ",") {
+		if cidr = strings.TrimSpace(cidr); cidr != "" {
+			destinations = append(destinations, networkingv1.NetworkPolicyPeer{IPBlock: &networkingv1.IPBlock{CIDR: cidr}})
+		}
+	}
+	if len(destinations) > 0 {
+		egress = append(egress, networkingv1.NetworkPolicyEgressRule{To: destinations})
+	}
+
+	return networkingv1.NetworkPolicySpec{
+		PodSelector: metav1.LabelSelector{MatchLabels: map[string]string{"app": deploymentName}},
+		PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress, networkingv1.PolicyTypeEgress},
+		Ingress:     ingress,
+		Egress:      egress,
+	}
+}
@@ -0,0 +1,115 @@
+package kubernetes
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+
+	"minecharts/cmd/logging"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// PlacementAnnotation records the node placement of a server on its deployment, as
+// JSON, from which the pod template follows when it changes anyway.
+const PlacementAnnotation = "minecharts.io/placement"
+
+// Placement constrains the nodes the pod of a server is scheduled on.
+type Placement struct {
+	NodeSelector map[string]string   `json:"nodeSelector,omitempty"`
+	Tolerations  []corev1.Toleration `json:"tolerations,omitempty"`
+	Affinity     *corev1.Affinity    `json:"affinity,omitempty"`
+}
+
+// IsZero tells whether the placement leaves the pod to the default scheduling.
+func (p Placement) IsZero() bool {
+	return len(p.NodeSelector) == 0 && len(p.Tolerations) == 0 && p.Affinity == nil
+}
+
+// ServerAntiAffinity returns the pod affinity term matching the pods of the other
+// servers of any namespace on the same node, leaving out the proxies.
+func ServerAntiAffinity() corev1.PodAffinityTerm {
+	return corev1.PodAffinityTerm{
+		LabelSelector: &metav1.LabelSelector{
+			MatchLabels: map[string]string{"created-by": "minecharts-api"},
+			MatchExpressions: []metav1.LabelSelectorRequirement{
+				{Key: ProxyLabel, Operator: metav1.LabelSelectorOpDoesNotExist},
+			},
+		},
+		NamespaceSelector: &metav1.LabelSelector{},
+		TopologyKey:       corev1.LabelHostname,
+	}
+}
+
+// applyPlacement replaces the placement of a pod spec, with the constraints of the
+// storage added on top.
+func applyPlacement(spec *corev1.PodSpec, placement Placement) {
+	spec.NodeSelector = maps.Clone(placement.NodeSelector)
+	spec.Tolerations = append([]corev1.Toleration(nil), placement.Tolerations...)
+	spec.Affinity = placement.Affinity.DeepCopy()
+	Storage.ConfigurePod(spec)
+}
+
+// deploymentPlacement returns the placement recorded on a deployment, false for the
+// deployments created without one, whose pod template is left as it is.
+func deploymentPlacement(deployment *appsv1.Deployment) (Placement, bool) {
+	var placement Placement
+	value, ok := deployment.Annotations[PlacementAnnotation]
+	if !ok {
+		return placement, false
+	}
+	if err := json.Unmarshal([]byte(value), &placement); err != nil {
+		return placement, false
+	}
+	return placement, true
+}
+
+// recordPlacement sets the placement annotation of a deployment.
+func recordPlacement(deployment *appsv1.Deployment, placement Placement) error {
+	value, err := json.Marshal(placement)
+	if err != nil {
+		return fmt.Errorf("failed to encode placement: %w", err)
+	}
+	if deployment.Annotations == nil {
+		deployment.Annotations = map[string]string{}
+	}
+	deployment.Annotations[PlacementAnnotation] = string(value)
+	return nil
+}
+
+// SetPlacement records the node placement of a server deployment. The pod template
+// only follows right away when the deployment is scaled down, otherwise at the next
+// restart or reconfiguration of the server, as moving a pod means recreating it. It
+// returns whether the change is pending.
+func SetPlacement(ctx context.Context, namespace, deploymentName string, placement Placement) (bool, error) {
+	logging.K8s.WithContext(ctx).WithFields(
+		"namespace", namespace,
+		"deployment_name", deploymentName,
+		"node_selector", len(placement.NodeSelector),
+		"tolerations", len(placement.Tolerations),
+	).Info("Setting server placement")
+
+	deployment, err := getWorkload(ctx, namespace, deploymentName)
+	if err != nil {
+		return false, fmt.Errorf("failed to get deployment: %w", err)
+	}
+	if err := recordPlacement(deployment, placement); err != nil {
+		return false, err
+	}
+
+	wanted := deployment.Spec.Template.Spec.DeepCopy()
+	applyPlacement(wanted, placement)
+	pending := !equality.Semantic.DeepEqual(wanted, &deployment.Spec.Template.Spec)
+	if deployment.Spec.Replicas != nil && *deployment.Spec.Replicas == 0 {
+		syncPodTemplate(deployment)
+		pending = false
+	}
+	if _, err := updateWorkload(ctx, namespace, deployment); err != nil {
+		return false, fmt.Errorf("failed to update deployment placement: %w", err)
+	}
+	return pending, nil
+}
@@ -3,8 +3,12 @@ package kubernetes
 import (
 	"bytes"
 	"context"
+	"fmt"
+	"io"
+	"strings"
 	"time"
 
+	"minecharts/cmd/config"
 	"minecharts/cmd/logging"
 
 	corev1 "k8s.io/api/core/v1"
@@ -14,21 +18,21 @@ import (
 )
 
 // getMinecraftPod gets the first pod associated with a deployment
-func GetMinecraftPod(namespace, deploymentName string) (*corev1.Pod, error) {
+func GetMinecraftPod(ctx context.Context, namespace, deploymentName string) (*corev1.Pod, error) {
 	labelSelector := "app=" + deploymentName
 
-	logging.K8s.WithFields(
+	logging.K8s.WithContext(ctx).WithFields(
 		"namespace", namespace,
 		"deployment_name", deploymentName,
 		"label_selector", labelSelector,
 	).Debug("Looking for Minecraft pod with label selector")
 
-	podList, err := Clientset.CoreV1().Pods(namespace).List(context.Background(), metav1.ListOptions{
+	podList, err := Clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{
 		LabelSelector: labelSelector,
 	})
 
 	if err != nil {
-		logging.K8s.WithFields(
+		logging.K8s.WithContext(ctx).WithFields(
 			"namespace", namespace,
 			"deployment_name", deploymentName,
 			"error", err.Error(),
@@ -37,7 +41,7 @@ func GetMinecraftPod(namespace, deploymentName string) (*corev1.Pod, error) {
 	}
 
 	if len(podList.Items) == 0 {
-		logging.K8s.WithFields(
+		logging.K8s.WithContext(ctx).WithFields(
 			"namespace", namespace,
 			"deployment_name", deploymentName,
 		).Warn("No pods found for deployment")
@@ -45,7 +49,7 @@ func GetMinecraftPod(namespace, deploymentName string) (*corev1.Pod, error) {
 	}
 
 	pod := &podList.Items[0]
-	logging.K8s.WithFields(
+	logging.K8s.WithContext(ctx).WithFields(
 		"namespace", namespace,
 		"deployment_name", deploymentName,
 		"pod_name", pod.Name,
@@ -56,14 +60,18 @@ func GetMinecraftPod(namespace, deploymentName string) (*corev1.Pod, error) {
 
 // executeCommandInPod executes a command in the specified pod and returns the output.
 // This is a utility function to avoid code duplication across handlers.
-func ExecuteCommandInPod(podName, namespace, containerName, command string) (stdout, stderr string, err error) {
-	logging.K8s.WithFields(
+func ExecuteCommandInPod(ctx context.Context, podName, namespace, containerName, command string) (stdout, stderr string, err error) {
+	logging.K8s.WithContext(ctx).WithFields(
 		"namespace", namespace,
 		"pod_name", podName,
 		"container_name", containerName,
 		"command", command,
 	).Debug("Executing command in pod")
 
+	if config.DevMode {
+		return devExec(podName, command)
+	}
+
 	// Prepare the execution request in the pod.
 	execReq := Clientset.CoreV1().RESTClient().Post().
 		Resource("pods").
@@ -84,7 +92,7 @@ func ExecuteCommandInPod(podName, namespace, containerName, command string) (std
 	// Execute the command in the pod.
 	exec, err := remotecommand.NewSPDYExecutor(Config, "POST", execReq.URL())
 	if err != nil {
-		logging.K8s.WithFields(
+		logging.K8s.WithContext(ctx).WithFields(
 			"namespace", namespace,
 			"pod_name", podName,
 			"container_name", containerName,
@@ -94,7 +102,7 @@ func ExecuteCommandInPod(podName, namespace, containerName, command string) (std
 	}
 
 	// Set a timeout context for the command execution.
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 
 	// Stream the command output to our buffers.
@@ -107,7 +115,7 @@ func ExecuteCommandInPod(podName, namespace, containerName, command string) (std
 	stderr = stderrBuf.String()
 
 	if err != nil {
-		logging.K8s.WithFields(
+		logging.K8s.WithContext(ctx).WithFields(
 			"namespace", namespace,
 			"pod_name", podName,
 			"container_name", containerName,
@@ -117,7 +125,7 @@ func ExecuteCommandInPod(podName, namespace, containerName, command string) (std
 			"error", err.Error(),
 		).Error("Command execution failed")
 	} else {
-		logging.K8s.WithFields(
+		logging.K8s.WithContext(ctx).WithFields(
 			"namespace", namespace,
 			"pod_name", podName,
 			"container_name", containerName,
@@ -130,3 +138,63 @@ func ExecuteCommandInPod(podName, namespace, containerName, command string) (std
 	// Return the command output even if there was an error.
 	return stdout, stderr, err
 }
+
+// streamToPod runs a command in a pod container, with stdin read from the given reader
+// if any, until the command exits or the context is done. Unlike ExecuteCommandInPod,
+// the command is not run by bash and has no timeout of its own, so it can upload data.
+func streamToPod(ctx context.Context, podName, namespace, containerName string, command []string, stdin io.Reader) (stdout, stderr string, err error) {
+	var stdoutBuf bytes.Buffer
+	stderr, err = streamPod(ctx, podName, namespace, containerName, command, stdin, &stdoutBuf)
+	return stdoutBuf.String(), stderr, err
+}
+
+// streamPod runs a command in a pod container like streamToPod, and writes its output
+// to stdout as it comes, so it can download data.
+func streamPod(ctx context.Context, podName, namespace, containerName string, command []string, stdin io.Reader, stdout io.Writer) (stderr string, err error) {
+	logging.K8s.WithContext(ctx).WithFields(
+		"namespace", namespace,
+		"pod_name", podName,
+		"container_name", containerName,
+		"command", strings.Join(command, " "),
+	).Debug("Streaming data with pod")
+
+	if config.DevMode {
+		if stdin != nil {
+			if _, err := io.Copy(io.Discard, stdin); err != nil {
+				return "", err
+			}
+		}
+		output, stderr, err := devExec(podName, strings.Join(command, " "))
+		if _, writeErr := io.WriteString(stdout, output); writeErr != nil {
+			return stderr, writeErr
+		}
+		return stderr, err
+	}
+
+	execReq := Clientset.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Name(podName).
+		Namespace(namespace).
+		SubResource("exec")
+
+	execReq.VersionedParams(&corev1.PodExecOptions{
+		Container: containerName,
+		Command:   command,
+		Stdin:     stdin != nil,
+		Stdout:    true,
+		Stderr:    true,
+	}, scheme.ParameterCodec)
+
+	exec, err := remotecommand.NewSPDYExecutor(Config, "POST", execReq.URL())
+	if err != nil {
+		return "", fmt.Errorf("failed to create SPDY executor: %w", err)
+	}
+
+	var stderrBuf bytes.Buffer
+	err = exec.StreamWithContext(ctx, remotecommand.StreamOptions{
+		Stdin:  stdin,
+		Stdout: stdout,
+		Stderr: &stderrBuf,
+	})
+	return stderrBuf.String(), err
+}
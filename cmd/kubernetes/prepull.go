@@ -0,0 +1,221 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"minecharts/cmd/config"
+	"minecharts/cmd/logging"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// PrePullDaemonSetName is the DaemonSet pulling the server images on every node.
+const PrePullDaemonSetName = "minecharts-image-prepull"
+
+// Annotations of the pod template of the pre-pull DaemonSet. Changing them rolls
+// the DaemonSet, so every node pulls the images again.
+const (
+	prePullTriggerAnnotation     = "minecharts.io/prepull-trigger"
+	prePullTriggeredAtAnnotation = "minecharts.io/prepull-triggered-at"
+)
+
+// PrePullStatus describes the pre-pull DaemonSet.
+type PrePullStatus struct {
+	Active       bool       `json:"active"` // The DaemonSet exists
+	Images       []string   `json:"images"`
+	Trigger      string     `json:"trigger,omitempty" example:"template paper-1.21 (1.21.4)"`
+	TriggeredAt  *time.Time `json:"triggeredAt,omitempty"`
+	DesiredNodes int32      `json:"desiredNodes"`
+	UpdatedNodes int32      `json:"updatedNodes"` // Nodes running the latest pull
+	ReadyNodes   int32      `json:"readyNodes"`   // Nodes with the images pulled
+}
+
+// PrePullImages returns the images pre-pulled on the nodes: the server image and the
+// extra images configured.
+func PrePullImages() []string {
+	images := []string{config.ServerImage}
+	for _, image := range strings.Split(config.PrePullImages, ",") {
+		image = strings.TrimSpace(image)
+		if image != "" && !slices.Contains(images, image) {
+			images = append(images, image)
+		}
+	}
+	return images
+}
+
+// EnsurePrePull creates or updates the DaemonSet pulling the given images on every
+// node, so that servers start without waiting for them. Each image is pulled by an
+// init container exiting right away, which needs a shell in the image, and the pods
+// then idle with the pause image.
+//
+// With a trigger, the DaemonSet rolls so that every node pulls the images again, as
+// when a template references a new Minecraft version and tags such as latest may have
+// moved. Without one, an up-to-date DaemonSet is left as is.
+func EnsurePrePull(ctx context.Context, namespace string, images []string, trigger string) error {
+	daemonSets := Clientset.AppsV1().DaemonSets(namespace)
+	existing, err := daemonSets.Get(ctx, PrePullDaemonSetName, metav1.GetOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to get pre-pull DaemonSet: %w", err)
+	}
+	found := err == nil
+	if found && trigger == "" && slices.Equal(prePullImagesOf(existing), images) &&
+		len(existing.Spec.Template.Spec.Containers) == 1 && existing.Spec.Template.Spec.Containers[0].Image == config.PrePullPauseImage {
+		return nil
+	}
+
+	template := prePullTemplate(images)
+	switch {
+	case trigger != "":
+		template.Annotations = map[string]string{
+			prePullTriggerAnnotation:     trigger,
+			prePullTriggeredAtAnnotation: time.Now().Format(time.RFC3339),
+		}
+	case found:
+		template.Annotations = existing.Spec.Template.Annotations
+	}
+
+	if !found {
+		daemonSet := &appsv1.DaemonSet{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: PrePullDaemonSetName,
+				Labels: map[string]string{
+					"created-by": "minecharts-api",
+				},
+			},
+			Spec: appsv1.DaemonSetSpec{
+				Selector: &metav1.LabelSelector{
+					MatchLabels: template.Labels,
+				},
+				Template: template,
+			},
+		}
+		annotateChange(ctx, daemonSet)
+		if _, err := daemonSets.Create(ctx, daemonSet, metav1.CreateOptions{FieldManager: FieldManager}); err != nil {
+			logging.K8s.WithContext(ctx).WithFields(
+				"namespace", namespace,
+				"error", err.Error(),
+			).Error("Failed to create pre-pull DaemonSet")
+			return fmt.Errorf("failed to create pre-pull DaemonSet: %w", err)
+		}
+	} else {
+		existing.Spec.Template = template
+		annotateChange(ctx, existing)
+		if _, err := daemonSets.Update(ctx, existing, metav1.UpdateOptions{FieldManager: FieldManager}); err != nil {
+			logging.K8s.WithContext(ctx).WithFields(
+				"namespace", namespace,
+				"error", err.Error(),
+			).Error("Failed to update pre-pull DaemonSet")
+			return fmt.Errorf("failed to update pre-pull DaemonSet: %w", err)
+		}
+	}
+
+	logging.K8s.WithContext(ctx).WithFields(
+		"namespace", namespace,
+		"images", strings.Join(images, ","),
+		"trigger", trigger,
+	).Info("Server images pre-pull requested on all nodes")
+	return nil
+}
+
+// prePullTemplate returns the pod template of the pre-pull DaemonSet.
+func prePullTemplate(images []string) corev1.PodTemplateSpec {
+	// Kept small, the pods stay on every node
+	resources := corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse("1m"),
+			corev1.ResourceMemory: resource.MustParse("8Mi"),
+		},
+		Limits: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse("50m"),
+			corev1.ResourceMemory: resource.MustParse("64Mi"),
+		},
+	}
+
+	spec := corev1.PodSpec{
+		Containers: []corev1.Container{
+			{
+				Name:      "pause",
+				Image:     config.PrePullPauseImage,
+				Resources: resources,
+			},
+		},
+		// Pull on every node, control planes included
+		Tolerations: []corev1.Toleration{
+			{Operator: corev1.TolerationOpExists},
+		},
+	}
+	for i, image := range images {
+		spec.InitContainers = append(spec.InitContainers, corev1.Container{
+			Name:            fmt.Sprintf("pull-%d", i),
+			Image:           image,
+			ImagePullPolicy: corev1.PullAlways,
+			Command:         []string{"/bin/sh", "-c", "exit 0"},
+			Resources:       resources,
+		})
+	}
+
+	// No app label: the pods must not be taken for server pods
+	return corev1.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{
+			Labels: map[string]string{
+				"created-by": "minecharts-api",
+				"component":  PrePullDaemonSetName,
+			},
+		},
+		Spec: spec,
+	}
+}
+
+// prePullImagesOf returns the images pulled by a pre-pull DaemonSet.
+func prePullImagesOf(daemonSet *appsv1.DaemonSet) []string {
+	var images []string
+	for _, container := range daemonSet.Spec.Template.Spec.InitContainers {
+		images = append(images, container.Image)
+	}
+	return images
+}
+
+// GetPrePullStatus reports the progress of the pre-pull DaemonSet on the nodes.
+func GetPrePullStatus(ctx context.Context, namespace string) (*PrePullStatus, error) {
+	daemonSet, err := Clientset.AppsV1().DaemonSets(namespace).Get(ctx, PrePullDaemonSetName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return &PrePullStatus{Images: []string{}}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pre-pull DaemonSet: %w", err)
+	}
+
+	status := &PrePullStatus{
+		Active:       true,
+		Images:       prePullImagesOf(daemonSet),
+		Trigger:      daemonSet.Spec.Template.Annotations[prePullTriggerAnnotation],
+		DesiredNodes: daemonSet.Status.DesiredNumberScheduled,
+		UpdatedNodes: daemonSet.Status.UpdatedNumberScheduled,
+		ReadyNodes:   daemonSet.Status.NumberReady,
+	}
+	if triggeredAt, err := time.Parse(time.RFC3339, daemonSet.Spec.Template.Annotations[prePullTriggeredAtAnnotation]); err == nil {
+		status.TriggeredAt = &triggeredAt
+	}
+	return status, nil
+}
+
+// DeletePrePull deletes the pre-pull DaemonSet. The images stay on the nodes until
+// the kubelet garbage collects them.
+func DeletePrePull(ctx context.Context, namespace string) error {
+	err := Clientset.AppsV1().DaemonSets(namespace).Delete(ctx, PrePullDaemonSetName, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete pre-pull DaemonSet: %w", err)
+	}
+	logging.K8s.WithContext(ctx).WithFields(
+		"namespace", namespace,
+	).Info("Pre-pull DaemonSet deleted")
+	return nil
+}
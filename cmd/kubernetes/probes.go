@@ -0,0 +1,61 @@
+package kubernetes
+
+import (
+	"slices"
+	"time"
+
+	"minecharts/cmd/config"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// healthCommand returns the command checking that the server of an edition answers:
+// mc-health pings the Java server, mc-monitor queries the Bedrock one over UDP.
+func healthCommand(edition Edition) []string {
+	if edition == BedrockEdition {
+		return []string{"mc-monitor", "status-bedrock", "--host", "127.0.0.1"}
+	}
+	return []string{"mc-health"}
+}
+
+// applyProbes sets the probes of a server container running the health command. The
+// startup probe gives the server config.StartupProbeTimeout to load its world, the
+// readiness probe keeps the pod out of its service endpoints while it does not answer,
+// and the liveness probe restarts the server once it stopped answering for good.
+func applyProbes(container *corev1.Container, command []string) {
+	period := seconds(config.ProbePeriod)
+	probe := func(failureThreshold int32) *corev1.Probe {
+		return &corev1.Probe{
+			ProbeHandler: corev1.ProbeHandler{
+				Exec: &corev1.ExecAction{Command: slices.Clone(command)},
+			},
+			PeriodSeconds:    period,
+			TimeoutSeconds:   seconds(config.ProbeTimeout),
+			FailureThreshold: failureThreshold,
+		}
+	}
+
+	container.StartupProbe = probe(max(seconds(config.StartupProbeTimeout)/period, 1))
+	container.ReadinessProbe = probe(int32(config.ReadinessFailureThreshold))
+	container.LivenessProbe = nil
+	if config.LivenessFailureThreshold > 0 {
+		container.LivenessProbe = probe(int32(config.LivenessFailureThreshold))
+	}
+}
+
+// syncProbes brings the probes of the server container of a deployment to the
+// configuration, keeping the health command of its edition.
+func syncProbes(deployment *appsv1.Deployment) {
+	for i := range deployment.Spec.Template.Spec.Containers {
+		container := &deployment.Spec.Template.Spec.Containers[i]
+		if container.Name != "minecraft-server" || container.ReadinessProbe == nil || container.ReadinessProbe.Exec == nil {
+			continue
+		}
+		applyProbes(container, container.ReadinessProbe.Exec.Command)
+	}
+}
+
+func seconds(d time.Duration) int32 {
+	return int32(d / time.Second)
+}
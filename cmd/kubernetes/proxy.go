@@ -0,0 +1,349 @@
+package kubernetes
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"minecharts/cmd/config"
+	"minecharts/cmd/logging"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// ProxyForwardingSecretKey is the key holding the forwarding secret in a proxy's
+// secret, and the file Velocity reads it from.
+const ProxyForwardingSecretKey = "forwarding.secret"
+
+// proxyConfigHashAnnotation records the configuration a proxy pod was started with.
+// The image copies /config when the proxy starts, so a new hash rolls the pod.
+const proxyConfigHashAnnotation = "minecharts.io/proxy-config-hash"
+
+// ProxyLabel labels the proxy pods, which the network policies of the servers let in.
+const ProxyLabel = "minecharts.io/proxy"
+
+// ProxyForwardingModes are the player info forwarding modes of Velocity. The
+// legacy and bungeeguard modes are the ones of BungeeCord, for members set up
+// behind a BungeeCord proxy.
+var ProxyForwardingModes = []string{"modern", "legacy", "bungeeguard", "none"}
+
+// ProxyMember is a server registered behind a proxy.
+type ProxyMember struct {
+	Name           string
+	DeploymentName string
+	Namespace      string // Of the server, empty for the namespace of the proxy
+	Lobby          bool   // Players join the lobbies first, or the first member when there is none
+}
+
+// ProxyForwardingSecretName returns the name of the secret holding a proxy's forwarding secret.
+func ProxyForwardingSecretName(deploymentName string) string {
+	return deploymentName + "-forwarding"
+}
+
+// ProxyConfigMapName returns the name of the ConfigMap holding a proxy's velocity.toml.
+func ProxyConfigMapName(deploymentName string) string {
+	return deploymentName + "-config"
+}
+
+// CreateProxy creates the deployment of a Velocity proxy without members, along with
+// its forwarding secret and configuration.
+func CreateProxy(ctx context.Context, namespace, deploymentName, forwardingMode, forwardingSecret string) error {
+	logging.K8s.WithContext(ctx).WithFields(
+		"namespace", namespace,
+		"deployment_name", deploymentName,
+		"forwarding_mode", forwardingMode,
+	).Info("Creating proxy deployment")
+
+	if err := CreateSecret(ctx, namespace, ProxyForwardingSecretName(deploymentName), deploymentName, map[string]string{
+		ProxyForwardingSecretKey: forwardingSecret,
+	}); err != nil {
+		return fmt.Errorf("failed to store forwarding secret: %w", err)
+	}
+
+	velocityToml := velocityConfig(forwardingMode, nil, nil)
+	if err := applyProxyConfigMap(ctx, namespace, deploymentName, velocityToml); err != nil {
+		return err
+	}
+
+	replicas := int32(1)
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: deploymentName,
+			Labels: map[string]string{
+				"created-by": "minecharts-api",
+				"app":        deploymentName,
+			},
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{
+					"app": deploymentName,
+				},
+			},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: map[string]string{
+						"created-by": "minecharts-api",
+						"app":        deploymentName,
+						ProxyLabel:   "true",
+					},
+					Annotations: map[string]string{
+						proxyConfigHashAnnotation: configHash(velocityToml),
+					},
+				},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{
+						{
+							Name:  "proxy",
+							Image: config.ProxyImage,
+							Env: []corev1.EnvVar{
+								{Name: "TYPE", Value: "VELOCITY"},
+								{Name: "MEMORY", Value: config.ProxyMemory},
+							},
+							Ports: []corev1.ContainerPort{
+								{
+									ContainerPort: 25565,
+									Protocol:      corev1.ProtocolTCP,
+								},
+							},
+							VolumeMounts: []corev1.VolumeMount{
+								{
+									Name:      "proxy-config",
+									MountPath: "/config",
+									ReadOnly:  true,
+								},
+							},
+							ReadinessProbe: &corev1.Probe{
+								ProbeHandler: corev1.ProbeHandler{
+									TCPSocket: &corev1.TCPSocketAction{
+										Port: intstr.FromInt32(25565),
+									},
+								},
+								InitialDelaySeconds: 10,
+								PeriodSeconds:       10,
+							},
+						},
+					},
+					// The image copies /config into the proxy directory on start
+					Volumes: []corev1.Volume{
+						{
+							Name: "proxy-config",
+							VolumeSource: corev1.VolumeSource{
+								Projected: &corev1.ProjectedVolumeSource{
+									Sources: []corev1.VolumeProjection{
+										{ConfigMap: &corev1.ConfigMapProjection{
+											LocalObjectReference: corev1.LocalObjectReference{Name: ProxyConfigMapName(deploymentName)},
+										}},
+										{Secret: &corev1.SecretProjection{
+											LocalObjectReference: corev1.LocalObjectReference{Name: ProxyForwardingSecretName(deploymentName)},
+										}},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	annotateChange(ctx, deployment)
+	_, err := Clientset.AppsV1().Deployments(namespace).Create(ctx, deployment, metav1.CreateOptions{FieldManager: FieldManager})
+	if err != nil {
+		logging.K8s.WithContext(ctx).WithFields(
+			"namespace", namespace,
+			"deployment_name", deploymentName,
+			"error", err.Error(),
+		).Error("Failed to create proxy deployment")
+		return err
+	}
+
+	logging.K8s.WithContext(ctx).WithFields(
+		"namespace", namespace,
+		"deployment_name", deploymentName,
+	).Info("Proxy deployment created successfully")
+	return nil
+}
+
+// UpdateProxyConfig writes the velocity.toml of a proxy for its members, and rolls
+// the proxy pod when it changed. Members without a service get a ClusterIP one, for
+// the proxy to reach them.
+func UpdateProxyConfig(ctx context.Context, namespace, deploymentName, forwardingMode string, members []ProxyMember) error {
+	addresses := make(map[string]string, len(members))
+	for _, member := range members {
+		address, err := memberAddress(ctx, cmp.Or(member.Namespace, namespace), member.DeploymentName)
+		if err != nil {
+			return fmt.Errorf("failed to resolve address of server %s: %w", member.Name, err)
+		}
+		addresses[member.Name] = address
+	}
+
+	velocityToml := velocityConfig(forwardingMode, members, addresses)
+	if err := applyProxyConfigMap(ctx, namespace, deploymentName, velocityToml); err != nil {
+		return err
+	}
+
+	deployment, err := Clientset.AppsV1().Deployments(namespace).Get(ctx, deploymentName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get proxy deployment: %w", err)
+	}
+	hash := configHash(velocityToml)
+	// Proxies created before the label get it with their next configuration
+	if deployment.Spec.Template.Annotations[proxyConfigHashAnnotation] == hash && deployment.Spec.Template.Labels[ProxyLabel] == "true" {
+		return nil
+	}
+	if deployment.Spec.Template.Annotations == nil {
+		deployment.Spec.Template.Annotations = map[string]string{}
+	}
+	deployment.Spec.Template.Annotations[proxyConfigHashAnnotation] = hash
+	if deployment.Spec.Template.Labels == nil {
+		deployment.Spec.Template.Labels = map[string]string{}
+	}
+	deployment.Spec.Template.Labels[ProxyLabel] = "true"
+
+	annotateChange(ctx, deployment)
+	if _, err := Clientset.AppsV1().Deployments(namespace).Update(ctx, deployment, metav1.UpdateOptions{FieldManager: FieldManager}); err != nil {
+		logging.K8s.WithContext(ctx).WithFields(
+			"namespace", namespace,
+			"deployment_name", deploymentName,
+			"error", err.Error(),
+		).Error("Failed to roll proxy deployment")
+		return fmt.Errorf("failed to roll proxy deployment: %w", err)
+	}
+
+	logging.K8s.WithContext(ctx).WithFields(
+		"namespace", namespace,
+		"deployment_name", deploymentName,
+		"members", len(members),
+	).Info("Proxy configuration updated")
+	return nil
+}
+
+// DeleteProxy deletes the deployment, service, configuration and forwarding secret
+// of a proxy. Resources already gone are skipped.
+func DeleteProxy(ctx context.Context, namespace, deploymentName string) error {
+	logging.K8s.WithContext(ctx).WithFields(
+		"namespace", namespace,
+		"deployment_name", deploymentName,
+	).Info("Deleting proxy")
+
+	err := Clientset.AppsV1().Deployments(namespace).Delete(ctx, deploymentName, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete proxy deployment: %w", err)
+	}
+	err = Clientset.CoreV1().Services(namespace).Delete(ctx, deploymentName+"-svc", metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete proxy service: %w", err)
+	}
+	err = Clientset.CoreV1().ConfigMaps(namespace).Delete(ctx, ProxyConfigMapName(deploymentName), metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete proxy configuration: %w", err)
+	}
+	return DeleteSecret(ctx, namespace, ProxyForwardingSecretName(deploymentName))
+}
+
+// applyProxyConfigMap creates or replaces the ConfigMap holding a proxy's velocity.toml.
+func applyProxyConfigMap(ctx context.Context, namespace, deploymentName, velocityToml string) error {
+	configMaps := Clientset.CoreV1().ConfigMaps(namespace)
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      ProxyConfigMapName(deploymentName),
+			Namespace: namespace,
+			Labels: map[string]string{
+				"created-by": "minecharts-api",
+				"app":        deploymentName,
+			},
+		},
+		Data: map[string]string{
+			"velocity.toml": velocityToml,
+		},
+	}
+
+	annotateChange(ctx, configMap)
+	_, err := configMaps.Create(ctx, configMap, metav1.CreateOptions{FieldManager: FieldManager})
+	if apierrors.IsAlreadyExists(err) {
+		existing, getErr := configMaps.Get(ctx, configMap.Name, metav1.GetOptions{})
+		if getErr != nil {
+			return fmt.Errorf("failed to get proxy configuration: %w", getErr)
+		}
+		existing.Data = configMap.Data
+		annotateChange(ctx, existing)
+		_, err = configMaps.Update(ctx, existing, metav1.UpdateOptions{FieldManager: FieldManager})
+	}
+	if err != nil {
+		logging.K8s.WithContext(ctx).WithFields(
+			"namespace", namespace,
+			"config_map", configMap.Name,
+			"error", err.Error(),
+		).Error("Failed to write proxy configuration")
+		return fmt.Errorf("failed to write proxy configuration: %w", err)
+	}
+	return nil
+}
+
+// memberAddress returns the in-cluster address of a member server, creating a
+// ClusterIP service for servers that are not exposed yet.
+func memberAddress(ctx context.Context, namespace, deploymentName string) (string, error) {
+	serviceName := deploymentName + "-svc"
+	service, err := Clientset.CoreV1().Services(namespace).Get(ctx, serviceName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		service, err = CreateService(ctx, namespace, deploymentName, corev1.ServiceTypeClusterIP, 25565, 0, nil)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	port := int32(0)
+	for _, servicePort := range service.Spec.Ports {
+		if servicePort.Name == "minecraft" || (port == 0 && servicePort.Protocol == corev1.ProtocolTCP) {
+			port = servicePort.Port
+		}
+	}
+	if port == 0 {
+		return "", fmt.Errorf("service %s has no Java port", serviceName)
+	}
+	return serviceName + "." + namespace + ".svc:" + strconv.Itoa(int(port)), nil
+}
+
+// velocityConfig renders the velocity.toml of a proxy. Players join the lobbies
+// in order, or all members in order when none is a lobby.
+func velocityConfig(forwardingMode string, members []ProxyMember, addresses map[string]string) string {
+	var b strings.Builder
+	b.WriteString("# Generated by minecharts-api, changes are overwritten\n")
+	b.WriteString("config-version = \"2.7\"\n")
+	b.WriteString("bind = \"0.0.0.0:25565\"\n")
+	b.WriteString("online-mode = true\n")
+	fmt.Fprintf(&b, "player-info-forwarding-mode = %q\n", forwardingMode)
+	fmt.Fprintf(&b, "forwarding-secret-file = %q\n", ProxyForwardingSecretKey)
+
+	var try, lobbies []string
+	b.WriteString("\n[servers]\n")
+	for _, member := range members {
+		fmt.Fprintf(&b, "%q = %q\n", member.Name, addresses[member.Name])
+		try = append(try, strconv.Quote(member.Name))
+		if member.Lobby {
+			lobbies = append(lobbies, strconv.Quote(member.Name))
+		}
+	}
+	if len(lobbies) > 0 {
+		try = lobbies
+	}
+	fmt.Fprintf(&b, "try = [%s]\n", strings.Join(try, ", "))
+
+	b.WriteString("\n[forced-hosts]\n")
+	return b.String()
+}
+
+func configHash(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:8])
+}
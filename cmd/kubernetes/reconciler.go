@@ -0,0 +1,313 @@
+package kubernetes
+
+import (
+	"context"
+	"time"
+
+	"minecharts/cmd/config"
+	"minecharts/cmd/database"
+	"minecharts/cmd/events"
+	"minecharts/cmd/logging"
+
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+)
+
+// managedSelector selects the resources created by the API.
+const managedSelector = "created-by=minecharts-api"
+
+// missingDeploymentReason is recorded on servers whose deployment disappeared from the cluster.
+const missingDeploymentReason = "Deployment missing from the cluster"
+
+// provisioningGrace leaves freshly created records alone while their deployment
+// is being created.
+const provisioningGrace = 2 * time.Minute
+
+// pruneInterval is how often the status change log is pruned and the deleted users
+// purged.
+const pruneInterval = 10 * time.Minute
+
+// ServerStore persists the servers reconciled with the cluster.
+type ServerStore interface {
+	ServerStatusStore
+	ListServers(ctx context.Context) ([]*database.MinecraftServer, error)
+	PruneServerStatusChanges(ctx context.Context, before time.Time) (int64, error)
+	PurgeDeletedUsers(ctx context.Context, before time.Time) (int64, error)
+}
+
+// reconciler compares the managed deployments with the server records.
+type reconciler struct {
+	store       ServerStore
+	deployments cache.Store
+	// statefulSets is nil without config.StatefulSets
+	statefulSets cache.Store
+	trigger      chan struct{}
+	// orphans holds the orphaned deployments already reported, to log them once.
+	orphans map[string]bool
+	// pruned is when the status change log was last pruned.
+	pruned time.Time
+}
+
+// StartReconciler watches the deployments created by the API and reconciles them
+// with the server records every interval, and whenever a deployment is added,
+// scaled or deleted, until the context is cancelled:
+//   - deployments without a record are reported as orphans;
+//   - records whose deployment is gone are marked as failed;
+//   - the status of the other records follows the replicas of their deployment.
+//
+// It also prunes the server status changes older than config.StatusChangeRetention,
+// and purges the users deleted more than config.UserDeletionGracePeriod ago.
+func StartReconciler(ctx context.Context, namespace string, store ServerStore, interval time.Duration) {
+	if interval <= 0 {
+		interval = time.Minute
+	}
+
+	factory := informers.NewSharedInformerFactoryWithOptions(Clientset, watcherResync,
+		informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.LabelSelector = managedSelector
+		}),
+	)
+	deploymentInformer := factory.Apps().V1().Deployments().Informer()
+
+	r := &reconciler{
+		store:       store,
+		deployments: deploymentInformer.GetStore(),
+		trigger:     make(chan struct{}, 1),
+		orphans:     map[string]bool{},
+	}
+	deploymentInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(interface{}) { r.schedule() },
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldDeployment, ok1 := oldObj.(*appsv1.Deployment)
+			newDeployment, ok2 := newObj.(*appsv1.Deployment)
+			if !ok1 || !ok2 || desiredReplicas(oldDeployment) != desiredReplicas(newDeployment) ||
+				oldDeployment.Status.ReadyReplicas != newDeployment.Status.ReadyReplicas {
+				r.schedule()
+			}
+		},
+		DeleteFunc: func(interface{}) { r.schedule() },
+	})
+	synced := []cache.InformerSynced{deploymentInformer.HasSynced}
+
+	// Only watched when enabled, as it needs the statefulsets permissions
+	if config.StatefulSets {
+		statefulSetInformer := factory.Apps().V1().StatefulSets().Informer()
+		r.statefulSets = statefulSetInformer.GetStore()
+		statefulSetInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc: func(interface{}) { r.schedule() },
+			UpdateFunc: func(oldObj, newObj interface{}) {
+				oldStatefulSet, ok1 := oldObj.(*appsv1.StatefulSet)
+				newStatefulSet, ok2 := newObj.(*appsv1.StatefulSet)
+				if !ok1 || !ok2 || desiredReplicas(deploymentView(oldStatefulSet)) != desiredReplicas(deploymentView(newStatefulSet)) ||
+					oldStatefulSet.Status.ReadyReplicas != newStatefulSet.Status.ReadyReplicas {
+					r.schedule()
+				}
+			},
+			DeleteFunc: func(interface{}) { r.schedule() },
+		})
+		synced = append(synced, statefulSetInformer.HasSynced)
+	}
+
+	factory.Start(ctx.Done())
+
+	go func() {
+		if !cache.WaitForCacheSync(ctx.Done(), synced...) {
+			logging.K8s.WithContext(ctx).WithFields(
+				"namespace", namespace,
+			).Warn("Reconciler stopped before the deployment cache synced")
+			return
+		}
+		logging.K8s.WithContext(ctx).WithFields(
+			"namespace", namespace,
+			"interval", interval.String(),
+		).Info("Server reconciler started")
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			r.reconcile(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			case <-r.trigger:
+			}
+		}
+	}()
+}
+
+// schedule requests a reconciliation, coalescing the requests made meanwhile.
+func (r *reconciler) schedule() {
+	select {
+	case r.trigger <- struct{}{}:
+	default:
+	}
+}
+
+func (r *reconciler) reconcile(ctx context.Context) {
+	// The deployment cache is stale while the API server is unreachable
+	if !ClusterReachable() {
+		logging.K8s.WithContext(ctx).Debug("Skipping reconciliation, cluster unreachable")
+		return
+	}
+
+	servers, err := r.store.ListServers(ctx)
+	if err != nil {
+		logging.K8s.WithContext(ctx).WithFields(
+			"error", err.Error(),
+		).Warn("Reconciliation failed to list servers")
+		return
+	}
+
+	deployments := map[string]*appsv1.Deployment{}
+	for _, obj := range r.deployments.List() {
+		if deployment, ok := obj.(*appsv1.Deployment); ok {
+			deployments[deployment.Name] = deployment
+		}
+	}
+	if r.statefulSets != nil {
+		for _, obj := range r.statefulSets.List() {
+			if statefulSet, ok := obj.(*appsv1.StatefulSet); ok {
+				deployments[statefulSet.Name] = deploymentView(statefulSet)
+			}
+		}
+	}
+
+	recorded := map[string]bool{}
+	updated := 0
+	for _, server := range servers {
+		recorded[server.DeploymentName] = true
+
+		status, reason, changed := reconciledStatus(server, deployments[server.DeploymentName])
+		if !changed {
+			continue
+		}
+
+		logging.K8s.WithContext(ctx).WithFields(
+			"server_name", server.ServerName,
+			"deployment", server.DeploymentName,
+			"from", server.Status,
+			"to", status,
+			"reason", reason,
+		).Info("Reconciled server status with its deployment")
+
+		if err := r.store.UpdateServerStatus(ctx, server.ServerName, status, reason); err != nil {
+			logging.K8s.WithContext(ctx).WithFields(
+				"server_name", server.ServerName,
+				"error", err.Error(),
+			).Warn("Failed to record reconciled server status")
+			continue
+		}
+		updated++
+	}
+
+	orphans := map[string]bool{}
+	for name := range deployments {
+		if recorded[name] {
+			continue
+		}
+		orphans[name] = true
+		if !r.orphans[name] {
+			logging.K8s.WithContext(ctx).WithFields(
+				"namespace", deployments[name].Namespace,
+				"deployment", name,
+			).Warn("Orphaned deployment: managed by the API but has no server record")
+			events.Publish(ctx, events.Event{
+				Type: events.DeploymentOrphaned,
+				Data: events.Orphan{Namespace: deployments[name].Namespace, Deployment: name},
+			})
+		}
+	}
+	r.orphans = orphans
+
+	if time.Since(r.pruned) >= pruneInterval {
+		r.pruned = time.Now()
+		r.pruneStatusChanges(ctx)
+		r.purgeDeletedUsers(ctx)
+	}
+
+	logging.K8s.WithContext(ctx).WithFields(
+		"servers", len(servers),
+		"deployments", len(deployments),
+		"updated", updated,
+		"orphans", len(orphans),
+	).Debug("Reconciliation completed")
+}
+
+// pruneStatusChanges deletes the server status changes past their retention.
+func (r *reconciler) pruneStatusChanges(ctx context.Context) {
+	if config.StatusChangeRetention <= 0 {
+		return
+	}
+	pruned, err := r.store.PruneServerStatusChanges(ctx, time.Now().Add(-config.StatusChangeRetention))
+	if err != nil {
+		logging.K8s.WithContext(ctx).WithFields(
+			"error", err.Error(),
+		).Warn("Failed to prune server status changes")
+		return
+	}
+	if pruned > 0 {
+		logging.K8s.WithContext(ctx).WithFields(
+			"pruned", pruned,
+			"retention", config.StatusChangeRetention.String(),
+		).Debug("Pruned server status changes")
+	}
+}
+
+// purgeDeletedUsers deletes for good the users deleted past their grace period.
+func (r *reconciler) purgeDeletedUsers(ctx context.Context) {
+	purged, err := r.store.PurgeDeletedUsers(ctx, time.Now().Add(-config.UserDeletionGracePeriod))
+	if err != nil {
+		logging.K8s.WithContext(ctx).WithFields(
+			"error", err.Error(),
+		).Warn("Failed to purge deleted users")
+		return
+	}
+	if purged > 0 {
+		logging.K8s.WithContext(ctx).WithFields(
+			"purged", purged,
+			"grace_period", config.UserDeletionGracePeriod.String(),
+		).Info("Purged deleted users")
+	}
+}
+
+// reconciledStatus returns the status a server should have given its deployment,
+// and whether it differs from the recorded one. Pod level states, such as the
+// failure reasons set by the server watcher, are kept while the deployment is scaled up.
+func reconciledStatus(server *database.MinecraftServer, deployment *appsv1.Deployment) (status, reason string, changed bool) {
+	status, reason = server.Status, server.StatusReason
+
+	switch {
+	case deployment == nil:
+		// Keep the reason of failed provisionings
+		if server.Status == database.ServerStatusFailed ||
+			server.Status == database.ServerStatusCreating && time.Since(server.UpdatedAt) < provisioningGrace {
+			break
+		}
+		status, reason = database.ServerStatusFailed, missingDeploymentReason
+	case desiredReplicas(deployment) == 0:
+		status, reason = database.ServerStatusStopped, ""
+		if hibernated, ok := deployment.Annotations[HibernatedAnnotation]; ok {
+			status, reason = database.ServerStatusHibernated, hibernated
+		}
+	case deployment.Status.ReadyReplicas > 0:
+		status, reason = database.ServerStatusRunning, ""
+	case server.Status == database.ServerStatusStopped,
+		server.Status == database.ServerStatusHibernated,
+		server.Status == database.ServerStatusRunning,
+		server.Status == database.ServerStatusFailed && server.StatusReason == missingDeploymentReason:
+		status, reason = database.ServerStatusStarting, ""
+	}
+
+	return status, reason, status != server.Status || reason != server.StatusReason
+}
+
+func desiredReplicas(deployment *appsv1.Deployment) int32 {
+	if deployment.Spec.Replicas == nil {
+		return 1
+	}
+	return *deployment.Spec.Replicas
+}
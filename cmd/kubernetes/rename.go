@@ -0,0 +1,294 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+
+	"minecharts/cmd/logging"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// RenameServerResources moves the resources of a stopped server from one deployment
+// name to another. Kubernetes names are immutable, so the deployment, service, RCON
+// secret, network policy and disruption budget are recreated under the new name before the old ones
+// are deleted.
+// The PVC cannot be renamed: it keeps its name, is relabeled with the new
+// deployment, and the new deployment mounts it, so the world data is not copied.
+func RenameServerResources(ctx context.Context, namespace, deploymentName, newDeploymentName, pvcName string) error {
+	logging.K8s.WithContext(ctx).WithFields(
+		"namespace", namespace,
+		"deployment_name", deploymentName,
+		"new_deployment_name", newDeploymentName,
+	).Info("Renaming server resources")
+
+	deployment, err := Clientset.AppsV1().Deployments(namespace).Get(ctx, deploymentName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get deployment: %w", err)
+	}
+	if _, err := Clientset.AppsV1().Deployments(namespace).Get(ctx, newDeploymentName, metav1.GetOptions{}); err == nil {
+		return fmt.Errorf("deployment %s already exists", newDeploymentName)
+	}
+
+	// RCON password, referenced by the deployment
+	secretName, newSecretName := RCONSecretName(deploymentName), RCONSecretName(newDeploymentName)
+	hasSecret := false
+	secret, err := Clientset.CoreV1().Secrets(namespace).Get(ctx, secretName, metav1.GetOptions{})
+	switch {
+	case err == nil:
+		data := map[string]string{}
+		for key, value := range secret.Data {
+			data[key] = string(value)
+		}
+		if err := CreateSecret(ctx, namespace, newSecretName, newDeploymentName, data); err != nil {
+			return fmt.Errorf("failed to copy RCON secret: %w", err)
+		}
+		hasSecret = true
+	case !apierrors.IsNotFound(err):
+		return fmt.Errorf("failed to get RCON secret: %w", err)
+	}
+	discardSecret := func() {
+		if hasSecret {
+			_ = Clientset.CoreV1().Secrets(namespace).Delete(ctx, newSecretName, metav1.DeleteOptions{})
+		}
+	}
+
+	// Network policy, copied before the pods it selects start
+	hasPolicy, err := copyNetworkPolicy(ctx, namespace, deploymentName, newDeploymentName)
+	if err != nil {
+		discardSecret()
+		return err
+	}
+	discardPolicy := func() {
+		discardSecret()
+		if hasPolicy {
+			_ = DeleteNetworkPolicy(ctx, namespace, newDeploymentName)
+		}
+	}
+
+	// Disruption budget, the server is stopped so it blocks nothing meanwhile
+	_, err = Clientset.PolicyV1().PodDisruptionBudgets(namespace).Get(ctx, DisruptionBudgetName(deploymentName), metav1.GetOptions{})
+	hasBudget := err == nil
+	if err != nil && !apierrors.IsNotFound(err) {
+		discardPolicy()
+		return fmt.Errorf("failed to get disruption budget: %w", err)
+	}
+	if hasBudget {
+		if err := EnsureDisruptionBudget(ctx, namespace, newDeploymentName); err != nil {
+			discardPolicy()
+			return err
+		}
+	}
+	discard := func() {
+		discardPolicy()
+		if hasBudget {
+			_ = DeleteDisruptionBudget(ctx, namespace, newDeploymentName)
+		}
+	}
+
+	renamed := renamedDeployment(deployment, newDeploymentName)
+	annotateChange(ctx, renamed)
+	if _, err := Clientset.AppsV1().Deployments(namespace).Create(ctx, renamed, metav1.CreateOptions{FieldManager: FieldManager}); err != nil {
+		discard()
+		return fmt.Errorf("failed to create deployment %s: %w", newDeploymentName, err)
+	}
+
+	if err := moveService(ctx, namespace, deploymentName, newDeploymentName); err != nil {
+		_ = Clientset.AppsV1().Deployments(namespace).Delete(ctx, newDeploymentName, metav1.DeleteOptions{})
+		discard()
+		return err
+	}
+
+	// Only relabeled once the rest succeeded: a PVC labeled with a deployment that
+	// has no record would be collected as an orphan.
+	if err := relabelPVC(ctx, namespace, pvcName, newDeploymentName); err != nil {
+		logging.K8s.WithContext(ctx).WithFields(
+			"namespace", namespace,
+			"pvc_name", pvcName,
+			"error", err.Error(),
+		).Warn("Failed to relabel PVC of renamed server")
+	}
+
+	if err := DeleteDeployment(ctx, namespace, deploymentName); err != nil {
+		logging.K8s.WithContext(ctx).WithFields(
+			"namespace", namespace,
+			"deployment_name", deploymentName,
+			"error", err.Error(),
+		).Warn("Failed to delete deployment of renamed server")
+	}
+	if hasSecret {
+		if err := DeleteSecret(ctx, namespace, secretName); err != nil {
+			logging.K8s.WithContext(ctx).WithFields(
+				"namespace", namespace,
+				"secret_name", secretName,
+				"error", err.Error(),
+			).Warn("Failed to delete RCON secret of renamed server")
+		}
+	}
+	if hasPolicy {
+		if err := DeleteNetworkPolicy(ctx, namespace, deploymentName); err != nil {
+			logging.K8s.WithContext(ctx).WithFields(
+				"namespace", namespace,
+				"deployment_name", deploymentName,
+				"error", err.Error(),
+			).Warn("Failed to delete network policy of renamed server")
+		}
+	}
+	if hasBudget {
+		if err := DeleteDisruptionBudget(ctx, namespace, deploymentName); err != nil {
+			logging.K8s.WithContext(ctx).WithFields(
+				"namespace", namespace,
+				"deployment_name", deploymentName,
+				"error", err.Error(),
+			).Warn("Failed to delete disruption budget of renamed server")
+		}
+	}
+
+	logging.K8s.WithContext(ctx).WithFields(
+		"namespace", namespace,
+		"deployment_name", deploymentName,
+		"new_deployment_name", newDeploymentName,
+	).Info("Server resources renamed successfully")
+	return nil
+}
+
+// renamedDeployment returns a copy of a deployment under a new name, selecting its
+// pods and reading its RCON secret by the new name.
+func renamedDeployment(deployment *appsv1.Deployment, newDeploymentName string) *appsv1.Deployment {
+	renamed := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   newDeploymentName,
+			Labels: renamedLabels(deployment.Labels, newDeploymentName),
+		},
+		Spec: *deployment.Spec.DeepCopy(),
+	}
+	renamed.Annotations = map[string]string{}
+	for _, key := range []string{PriorityClassAnnotation, PlacementAnnotation} {
+		if value, ok := deployment.Annotations[key]; ok {
+			renamed.Annotations[key] = value
+		}
+	}
+	renamed.Spec.Selector = &metav1.LabelSelector{
+		MatchLabels: map[string]string{
+			"app": newDeploymentName,
+		},
+	}
+	renamed.Spec.Template.Labels = renamedLabels(deployment.Spec.Template.Labels, newDeploymentName)
+
+	secretName := RCONSecretName(deployment.Name)
+	for i := range renamed.Spec.Template.Spec.Containers {
+		for _, env := range renamed.Spec.Template.Spec.Containers[i].Env {
+			if env.ValueFrom != nil && env.ValueFrom.SecretKeyRef != nil && env.ValueFrom.SecretKeyRef.Name == secretName {
+				env.ValueFrom.SecretKeyRef.Name = RCONSecretName(newDeploymentName)
+			}
+		}
+	}
+	return renamed
+}
+
+func renamedLabels(labels map[string]string, newDeploymentName string) map[string]string {
+	renamed := map[string]string{}
+	for key, value := range labels {
+		renamed[key] = value
+	}
+	renamed["app"] = newDeploymentName
+	return renamed
+}
+
+// moveService recreates the service of a server under the new deployment name,
+// keeping its type, ports and annotations. Node ports are kept so players connect
+// to the same address, a load balancer may be given a new one.
+func moveService(ctx context.Context, namespace, deploymentName, newDeploymentName string) error {
+	serviceName := deploymentName + "-svc"
+	service, err := Clientset.CoreV1().Services(namespace).Get(ctx, serviceName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil // Server not exposed
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get service: %w", err)
+	}
+
+	moved := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        newDeploymentName + "-svc",
+			Labels:      renamedLabels(service.Labels, newDeploymentName),
+			Annotations: service.Annotations,
+		},
+		Spec: *service.Spec.DeepCopy(),
+	}
+	moved.Spec.Selector = map[string]string{
+		"app": newDeploymentName,
+	}
+	// Allocated by the cluster
+	moved.Spec.ClusterIP = ""
+	moved.Spec.ClusterIPs = nil
+	moved.Spec.HealthCheckNodePort = 0
+
+	// The node ports are released by deleting the old service first
+	if err := DeleteService(ctx, namespace, serviceName); err != nil {
+		return fmt.Errorf("failed to delete service: %w", err)
+	}
+	annotateChange(ctx, moved)
+	if _, err := Clientset.CoreV1().Services(namespace).Create(ctx, moved, metav1.CreateOptions{FieldManager: FieldManager}); err != nil {
+		restored := moved.DeepCopy()
+		restored.Name = serviceName
+		restored.Labels = service.Labels
+		restored.Spec.Selector = service.Spec.Selector
+		if _, restoreErr := Clientset.CoreV1().Services(namespace).Create(ctx, restored, metav1.CreateOptions{FieldManager: FieldManager}); restoreErr != nil {
+			logging.K8s.WithContext(ctx).WithFields(
+				"namespace", namespace,
+				"service_name", serviceName,
+				"error", restoreErr.Error(),
+			).Error("Failed to restore service after a failed rename")
+		}
+		return fmt.Errorf("failed to create service %s: %w", moved.Name, err)
+	}
+	return nil
+}
+
+// copyNetworkPolicy creates the NetworkPolicy of a server under the new deployment
+// name, selecting its renamed pods. It tells whether the server had one.
+func copyNetworkPolicy(ctx context.Context, namespace, deploymentName, newDeploymentName string) (bool, error) {
+	policies := Clientset.NetworkingV1().NetworkPolicies(namespace)
+	policy, err := policies.Get(ctx, NetworkPolicyName(deploymentName), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to get network policy: %w", err)
+	}
+
+	copied := &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   NetworkPolicyName(newDeploymentName),
+			Labels: renamedLabels(policy.Labels, newDeploymentName),
+		},
+		Spec: *policy.Spec.DeepCopy(),
+	}
+	copied.Spec.PodSelector = metav1.LabelSelector{MatchLabels: map[string]string{"app": newDeploymentName}}
+	annotateChange(ctx, copied)
+	if _, err := policies.Create(ctx, copied, metav1.CreateOptions{FieldManager: FieldManager}); err != nil {
+		return false, fmt.Errorf("failed to create network policy %s: %w", copied.Name, err)
+	}
+	return true, nil
+}
+
+// relabelPVC points the app label of a PVC to its new deployment.
+func relabelPVC(ctx context.Context, namespace, pvcName, newDeploymentName string) error {
+	pvc, err := Clientset.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, pvcName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil // Storage driver without PVCs
+	}
+	if err != nil {
+		return err
+	}
+	pvc.Labels = renamedLabels(pvc.Labels, newDeploymentName)
+	pvc.Labels["created-by"] = "minecharts-api"
+	annotateChange(ctx, pvc)
+	_, err = Clientset.CoreV1().PersistentVolumeClaims(namespace).Update(ctx, pvc, metav1.UpdateOptions{FieldManager: FieldManager})
+	return err
+}
@@ -0,0 +1,198 @@
+package kubernetes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"minecharts/cmd/logging"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Rollout strategies of the restarts.
+const (
+	// RolloutRecreate returns once the new pod is requested, the old one is stopped first.
+	RolloutRecreate = "recreate"
+	// RolloutWaitForReady waits for the new pod to be ready, and rolls the deployment
+	// back to its previous pod template when it is not in time.
+	RolloutWaitForReady = "wait-for-ready"
+)
+
+// Rollout states, as reported by kubectl rollout status.
+const (
+	RolloutProgressing = "progressing"
+	RolloutComplete    = "complete"
+	RolloutFailed      = "failed"
+	RolloutStopped     = "stopped"
+)
+
+// ErrRolloutAborted is returned when a rollout failed or timed out and the
+// deployment was rolled back to its previous pod template.
+var ErrRolloutAborted = errors.New("rollout aborted")
+
+// rolloutPollInterval is how often the deployment is checked while waiting for its rollout.
+const rolloutPollInterval = 2 * time.Second
+
+// RolloutCondition is a condition of a deployment.
+type RolloutCondition struct {
+	Type           string     `json:"type" example:"Progressing"`
+	Status         string     `json:"status" example:"True"`
+	Reason         string     `json:"reason,omitempty" example:"NewReplicaSetAvailable"`
+	Message        string     `json:"message,omitempty"`
+	LastUpdateTime *time.Time `json:"lastUpdateTime,omitempty"`
+}
+
+// RolloutStatus describes the progress of the latest rollout of a deployment.
+type RolloutStatus struct {
+	State              string             `json:"state" example:"progressing"` // "progressing", "complete", "failed" or "stopped"
+	Message            string             `json:"message,omitempty" example:"0 of 1 updated replicas are available"`
+	Revision           string             `json:"revision,omitempty" example:"3"`
+	RestartedAt        *time.Time         `json:"restartedAt,omitempty"`
+	Generation         int64              `json:"generation"`
+	ObservedGeneration int64              `json:"observedGeneration"`
+	Replicas           int32              `json:"replicas"`
+	UpdatedReplicas    int32              `json:"updatedReplicas"`
+	ReadyReplicas      int32              `json:"readyReplicas"`
+	AvailableReplicas  int32              `json:"availableReplicas"`
+	Conditions         []RolloutCondition `json:"conditions,omitempty"`
+}
+
+// GetRolloutStatus reports the progress of the latest rollout of a deployment from
+// its conditions and replica counts, the same way kubectl rollout status does.
+func GetRolloutStatus(deployment *appsv1.Deployment) RolloutStatus {
+	status := RolloutStatus{
+		Revision:           deployment.Annotations["deployment.kubernetes.io/revision"],
+		Generation:         deployment.Generation,
+		ObservedGeneration: deployment.Status.ObservedGeneration,
+		Replicas:           deployment.Status.Replicas,
+		UpdatedReplicas:    deployment.Status.UpdatedReplicas,
+		ReadyReplicas:      deployment.Status.ReadyReplicas,
+		AvailableReplicas:  deployment.Status.AvailableReplicas,
+	}
+	if restartedAt, err := time.Parse(time.RFC3339, deployment.Spec.Template.Annotations["kubectl.kubernetes.io/restartedAt"]); err == nil {
+		status.RestartedAt = &restartedAt
+	}
+
+	var progressing *appsv1.DeploymentCondition
+	for i, condition := range deployment.Status.Conditions {
+		rolloutCondition := RolloutCondition{
+			Type:    string(condition.Type),
+			Status:  string(condition.Status),
+			Reason:  condition.Reason,
+			Message: condition.Message,
+		}
+		if !condition.LastUpdateTime.IsZero() {
+			rolloutCondition.LastUpdateTime = &condition.LastUpdateTime.Time
+		}
+		status.Conditions = append(status.Conditions, rolloutCondition)
+		if condition.Type == appsv1.DeploymentProgressing {
+			progressing = &deployment.Status.Conditions[i]
+		}
+	}
+
+	desired := int32(1)
+	if deployment.Spec.Replicas != nil {
+		desired = *deployment.Spec.Replicas
+	}
+
+	switch {
+	case deployment.Status.ObservedGeneration < deployment.Generation:
+		status.State = RolloutProgressing
+		status.Message = "Waiting for the deployment spec update to be observed"
+	case progressing != nil && progressing.Reason == "ProgressDeadlineExceeded":
+		status.State = RolloutFailed
+		status.Message = progressing.Message
+	case desired == 0:
+		status.State = RolloutStopped
+		if deployment.Status.Replicas > 0 {
+			status.State = RolloutProgressing
+			status.Message = fmt.Sprintf("%d old replicas are pending termination", deployment.Status.Replicas)
+		}
+	case deployment.Status.UpdatedReplicas < desired:
+		status.State = RolloutProgressing
+		status.Message = fmt.Sprintf("%d of %d new replicas have been updated", deployment.Status.UpdatedReplicas, desired)
+	case deployment.Status.Replicas > deployment.Status.UpdatedReplicas:
+		status.State = RolloutProgressing
+		status.Message = fmt.Sprintf("%d old replicas are pending termination", deployment.Status.Replicas-deployment.Status.UpdatedReplicas)
+	case deployment.Status.AvailableReplicas < deployment.Status.UpdatedReplicas:
+		status.State = RolloutProgressing
+		status.Message = fmt.Sprintf("%d of %d updated replicas are available", deployment.Status.AvailableReplicas, deployment.Status.UpdatedReplicas)
+	default:
+		status.State = RolloutComplete
+	}
+	return status
+}
+
+// WaitForRollout waits up to timeout for the latest rollout of a deployment to
+// complete. When it fails or is not complete in time, the deployment is rolled back
+// to the previous pod template and ErrRolloutAborted is returned, along with the
+// status of the aborted rollout.
+func WaitForRollout(ctx context.Context, namespace, deploymentName string, previous *corev1.PodTemplateSpec, timeout time.Duration) (RolloutStatus, error) {
+	waitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	ticker := time.NewTicker(rolloutPollInterval)
+	defer ticker.Stop()
+
+	var status RolloutStatus
+	for {
+		deployment, err := getWorkload(waitCtx, namespace, deploymentName)
+		if err == nil {
+			status = GetRolloutStatus(deployment)
+			switch status.State {
+			case RolloutComplete, RolloutStopped:
+				return status, nil
+			case RolloutFailed:
+				return status, abortRollout(ctx, namespace, deploymentName, previous, status.Message)
+			}
+		} else if waitCtx.Err() == nil {
+			return status, fmt.Errorf("failed to get deployment: %w", err)
+		}
+
+		select {
+		case <-waitCtx.Done():
+			// The caller went away, the rollout is left to finish on its own
+			if ctx.Err() != nil {
+				return status, ctx.Err()
+			}
+			return status, abortRollout(ctx, namespace, deploymentName, previous,
+				fmt.Sprintf("not complete after %s: %s", timeout, status.Message))
+		case <-ticker.C:
+		}
+	}
+}
+
+// abortRollout puts the previous pod template of a deployment back.
+func abortRollout(ctx context.Context, namespace, deploymentName string, previous *corev1.PodTemplateSpec, reason string) error {
+	logging.K8s.WithContext(ctx).WithFields(
+		"namespace", namespace,
+		"deployment_name", deploymentName,
+		"reason", reason,
+	).Warn("Rollout did not complete, rolling back")
+
+	if previous == nil {
+		return fmt.Errorf("%w: %s", ErrRolloutAborted, reason)
+	}
+
+	// The rollback is done even though the waiting budget is spent
+	rollbackCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+	defer cancel()
+
+	deployment, err := getWorkload(rollbackCtx, namespace, deploymentName)
+	if err != nil {
+		return fmt.Errorf("%w: %s, and failed to get deployment for rollback: %v", ErrRolloutAborted, reason, err)
+	}
+	deployment.Spec.Template = *previous.DeepCopy()
+	if _, err := updateWorkload(rollbackCtx, namespace, deployment); err != nil {
+		return fmt.Errorf("%w: %s, and failed to roll back: %v", ErrRolloutAborted, reason, err)
+	}
+
+	logging.K8s.WithContext(ctx).WithFields(
+		"namespace", namespace,
+		"deployment_name", deploymentName,
+	).Info("Deployment rolled back to its previous pod template")
+	return fmt.Errorf("%w: %s, rolled back to the previous pod template", ErrRolloutAborted, reason)
+}
@@ -0,0 +1,106 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+
+	"minecharts/cmd/logging"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// RCONSecretKey is the key holding the RCON password in a server's RCON secret.
+const RCONSecretKey = "password"
+
+// RCONSecretName returns the name of the secret holding a server's RCON password.
+func RCONSecretName(deploymentName string) string {
+	return deploymentName + "-rcon"
+}
+
+// CreateSecret creates an Opaque secret with the given data, replacing the data
+// of an existing secret with the same name.
+func CreateSecret(ctx context.Context, namespace, secretName, appLabel string, data map[string]string) error {
+	logging.K8s.WithContext(ctx).WithFields(
+		"namespace", namespace,
+		"secret_name", secretName,
+	).Debug("Creating secret")
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      secretName,
+			Namespace: namespace,
+			Labels: map[string]string{
+				"created-by": "minecharts-api",
+				"app":        appLabel,
+			},
+		},
+		Type:       corev1.SecretTypeOpaque,
+		StringData: data,
+	}
+
+	annotateChange(ctx, secret)
+	_, err := Clientset.CoreV1().Secrets(namespace).Create(ctx, secret, metav1.CreateOptions{FieldManager: FieldManager})
+	if apierrors.IsAlreadyExists(err) {
+		existing, getErr := Clientset.CoreV1().Secrets(namespace).Get(ctx, secretName, metav1.GetOptions{})
+		if getErr != nil {
+			return getErr
+		}
+		existing.StringData = data
+		annotateChange(ctx, existing)
+		_, err = Clientset.CoreV1().Secrets(namespace).Update(ctx, existing, metav1.UpdateOptions{FieldManager: FieldManager})
+	}
+	if err != nil {
+		logging.K8s.WithContext(ctx).WithFields(
+			"namespace", namespace,
+			"secret_name", secretName,
+			"error", err.Error(),
+		).Error("Failed to create secret")
+		return err
+	}
+
+	logging.K8s.WithContext(ctx).WithFields(
+		"namespace", namespace,
+		"secret_name", secretName,
+	).Info("Secret created successfully")
+	return nil
+}
+
+// GetSecretValue returns a single value of a secret.
+func GetSecretValue(ctx context.Context, namespace, secretName, key string) (string, error) {
+	secret, err := Clientset.CoreV1().Secrets(namespace).Get(ctx, secretName, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+
+	value, ok := secret.Data[key]
+	if !ok {
+		return "", fmt.Errorf("secret %s has no key %s", secretName, key)
+	}
+	return string(value), nil
+}
+
+// DeleteSecret removes a secret if it exists.
+func DeleteSecret(ctx context.Context, namespace, secretName string) error {
+	logging.K8s.WithContext(ctx).WithFields(
+		"namespace", namespace,
+		"secret_name", secretName,
+	).Debug("Attempting to delete secret")
+
+	err := Clientset.CoreV1().Secrets(namespace).Delete(ctx, secretName, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		logging.K8s.WithContext(ctx).WithFields(
+			"namespace", namespace,
+			"secret_name", secretName,
+			"error", err.Error(),
+		).Error("Failed to delete secret")
+		return err
+	}
+
+	logging.K8s.WithContext(ctx).WithFields(
+		"namespace", namespace,
+		"secret_name", secretName,
+	).Info("Secret deleted successfully")
+	return nil
+}
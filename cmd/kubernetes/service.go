@@ -2,6 +2,7 @@ package kubernetes
 
 import (
 	"context"
+	"strings"
 
 	"minecharts/cmd/logging"
 
@@ -10,16 +11,25 @@ import (
 	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
-// createService creates a Kubernetes Service to expose a Minecraft server deployment
-func CreateService(namespace, deploymentName string, serviceType corev1.ServiceType, port int32, annotations map[string]string) (*corev1.Service, error) {
+// MCRouterAnnotation publishes a service to mc-router under the given
+// comma separated host names.
+const MCRouterAnnotation = "mc-router.itzg.me/externalServerName"
+
+// BedrockPort is the UDP port Geyser listens on in the server pod.
+const BedrockPort = 19132
+
+// createService creates a Kubernetes Service to expose a Minecraft server deployment,
+// on its Java port when port is set and on its Bedrock port when bedrockPort is set
+func CreateService(ctx context.Context, namespace, deploymentName string, serviceType corev1.ServiceType, port, bedrockPort int32, annotations map[string]string) (*corev1.Service, error) {
 	serviceName := deploymentName + "-svc"
 
-	logging.K8s.WithFields(
+	logging.K8s.WithContext(ctx).WithFields(
 		"namespace", namespace,
 		"deployment_name", deploymentName,
 		"service_name", serviceName,
 		"service_type", serviceType,
 		"port", port,
+		"bedrock_port", bedrockPort,
 	).Info("Creating Kubernetes service")
 
 	service := &corev1.Service{
@@ -33,23 +43,38 @@ func CreateService(namespace, deploymentName string, serviceType corev1.ServiceT
 		},
 		Spec: corev1.ServiceSpec{
 			Type: serviceType,
-			Ports: []corev1.ServicePort{
-				{
-					Name:       "minecraft",
-					Port:       port,
-					TargetPort: intstr.FromInt32(25565),
-					Protocol:   corev1.ProtocolTCP,
-				},
-			},
 			Selector: map[string]string{
 				"app": deploymentName,
 			},
 		},
 	}
 
-	createdService, err := Clientset.CoreV1().Services(namespace).Create(context.Background(), service, metav1.CreateOptions{})
+	if port > 0 {
+		service.Spec.Ports = append(service.Spec.Ports, corev1.ServicePort{
+			Name:       "minecraft",
+			Port:       port,
+			TargetPort: intstr.FromInt32(25565),
+			Protocol:   corev1.ProtocolTCP,
+		})
+	}
+	if bedrockPort > 0 {
+		service.Spec.Ports = append(service.Spec.Ports, corev1.ServicePort{
+			Name:       "bedrock",
+			Port:       bedrockPort,
+			TargetPort: intstr.FromInt32(BedrockPort),
+			Protocol:   corev1.ProtocolUDP,
+		})
+	}
+
+	// The service carries the custom labels of the server
+	if deployment, err := getWorkload(ctx, namespace, deploymentName); err == nil {
+		service.Labels = withCustomLabels(service.Labels, customLabels(deployment.Labels))
+	}
+
+	annotateChange(ctx, service)
+	createdService, err := Clientset.CoreV1().Services(namespace).Create(ctx, service, metav1.CreateOptions{FieldManager: FieldManager})
 	if err != nil {
-		logging.K8s.WithFields(
+		logging.K8s.WithContext(ctx).WithFields(
 			"namespace", namespace,
 			"service_name", serviceName,
 			"error", err.Error(),
@@ -57,7 +82,7 @@ func CreateService(namespace, deploymentName string, serviceType corev1.ServiceT
 		return nil, err
 	}
 
-	logging.K8s.WithFields(
+	logging.K8s.WithContext(ctx).WithFields(
 		"namespace", namespace,
 		"service_name", serviceName,
 		"service_type", serviceType,
@@ -68,15 +93,15 @@ func CreateService(namespace, deploymentName string, serviceType corev1.ServiceT
 }
 
 // deleteService removes a service if it exists
-func DeleteService(namespace, serviceName string) error {
-	logging.K8s.WithFields(
+func DeleteService(ctx context.Context, namespace, serviceName string) error {
+	logging.K8s.WithContext(ctx).WithFields(
 		"namespace", namespace,
 		"service_name", serviceName,
 	).Debug("Attempting to delete service")
 
-	err := Clientset.CoreV1().Services(namespace).Delete(context.Background(), serviceName, metav1.DeleteOptions{})
+	err := Clientset.CoreV1().Services(namespace).Delete(ctx, serviceName, metav1.DeleteOptions{})
 	if err != nil {
-		logging.K8s.WithFields(
+		logging.K8s.WithContext(ctx).WithFields(
 			"namespace", namespace,
 			"service_name", serviceName,
 			"error", err.Error(),
@@ -84,7 +109,7 @@ func DeleteService(namespace, serviceName string) error {
 		return err
 	}
 
-	logging.K8s.WithFields(
+	logging.K8s.WithContext(ctx).WithFields(
 		"namespace", namespace,
 		"service_name", serviceName,
 	).Info("Service deleted successfully")
@@ -93,15 +118,15 @@ func DeleteService(namespace, serviceName string) error {
 }
 
 // getServiceDetails retrieves information about an existing service
-func GetServiceDetails(namespace, serviceName string) (*corev1.Service, error) {
-	logging.K8s.WithFields(
+func GetServiceDetails(ctx context.Context, namespace, serviceName string) (*corev1.Service, error) {
+	logging.K8s.WithContext(ctx).WithFields(
 		"namespace", namespace,
 		"service_name", serviceName,
 	).Debug("Getting service details")
 
-	service, err := Clientset.CoreV1().Services(namespace).Get(context.Background(), serviceName, metav1.GetOptions{})
+	service, err := Clientset.CoreV1().Services(namespace).Get(ctx, serviceName, metav1.GetOptions{})
 	if err != nil {
-		logging.K8s.WithFields(
+		logging.K8s.WithContext(ctx).WithFields(
 			"namespace", namespace,
 			"service_name", serviceName,
 			"error", err.Error(),
@@ -109,7 +134,7 @@ func GetServiceDetails(namespace, serviceName string) (*corev1.Service, error) {
 		return nil, err
 	}
 
-	logging.K8s.WithFields(
+	logging.K8s.WithContext(ctx).WithFields(
 		"namespace", namespace,
 		"service_name", serviceName,
 		"service_type", service.Spec.Type,
@@ -118,3 +143,25 @@ func GetServiceDetails(namespace, serviceName string) (*corev1.Service, error) {
 
 	return service, nil
 }
+
+// FindRoutedDeployment returns the deployment behind the managed service that
+// mc-router routes the given host name to, or an empty name when there is none.
+func FindRoutedDeployment(ctx context.Context, namespace, host string) (string, error) {
+	services, err := Clientset.CoreV1().Services(namespace).List(ctx, metav1.ListOptions{LabelSelector: managedSelector})
+	if err != nil {
+		logging.K8s.WithContext(ctx).WithFields(
+			"namespace", namespace,
+			"error", err.Error(),
+		).Error("Failed to list services")
+		return "", err
+	}
+
+	for _, service := range services.Items {
+		for _, name := range strings.Split(service.Annotations[MCRouterAnnotation], ",") {
+			if strings.EqualFold(strings.TrimSpace(name), host) {
+				return service.Labels["app"], nil
+			}
+		}
+	}
+	return "", nil
+}
@@ -2,6 +2,9 @@ package kubernetes
 
 import (
 	"context"
+	"fmt"
+	"strings"
+
 	"minecharts/cmd/config"
 	"minecharts/cmd/logging"
 
@@ -11,49 +14,206 @@ import (
 	"k8s.io/utils/ptr"
 )
 
-// ensurePVC checks if a PVC exists in the given namespace; if not, it creates it.
-func EnsurePVC(namespace, pvcName string) error {
-	logging.K8s.WithFields(
+// StorageDriver provisions the volume holding the data of a server.
+// The volume name is the server's PVC name, whether or not the driver uses a PVC.
+type StorageDriver interface {
+	// Name returns the name the driver is selected with.
+	Name() string
+	// Ensure creates the storage of a server if it doesn't already exist, with the
+	// storage class of the driver when storageClass is empty.
+	Ensure(ctx context.Context, namespace, volumeName, storageSize, storageClass string) error
+	// Delete removes the storage of a server.
+	Delete(ctx context.Context, namespace, volumeName string) error
+	// VolumeSource returns the source of the volume mounted on /data.
+	VolumeSource(volumeName string) corev1.VolumeSource
+	// ConfigurePod adds the scheduling constraints the storage needs to the server pod.
+	ConfigurePod(spec *corev1.PodSpec)
+}
+
+// Storage is the storage driver selected by the configuration.
+var Storage StorageDriver = &pvcDriver{
+	name:         "pvc",
+	storageClass: config.StorageClass,
+	accessMode:   corev1.ReadWriteOnce,
+}
+
+// NewStorageDriver returns the storage driver with the given name, configured with
+// its driver specific options:
+//   - pvc: a PVC per server (storageClass, accessMode);
+//   - nfs-subdir: a PVC per server provisioned in its own directory by the
+//     nfs-subdir-external-provisioner (storageClass, defaults to nfs-client);
+//   - longhorn: a Longhorn PVC per server (storageClass, defaults to longhorn, and
+//     node, the node the servers prefer so their data stays local);
+//   - hostpath: a directory per server on the node (path, defaults to
+//     /var/lib/minecharts, and node, required on multi-node clusters).
+func NewStorageDriver(name string, options map[string]string) (StorageDriver, error) {
+	switch name {
+	case "", "pvc":
+		mode, err := accessModeOption(options["accessMode"], corev1.ReadWriteOnce)
+		if err != nil {
+			return nil, err
+		}
+		return &pvcDriver{
+			name:         "pvc",
+			storageClass: optionOr(options, "storageClass", config.StorageClass),
+			accessMode:   mode,
+		}, nil
+	case "nfs-subdir":
+		return &pvcDriver{
+			name:         name,
+			storageClass: optionOr(options, "storageClass", "nfs-client"),
+			accessMode:   corev1.ReadWriteMany,
+			// Used by the provisioner when its pathPattern references it
+			annotations: func(volumeName string) map[string]string {
+				return map[string]string{"nfs.io/storage-path": volumeName}
+			},
+		}, nil
+	case "longhorn":
+		return &pvcDriver{
+			name:          name,
+			storageClass:  optionOr(options, "storageClass", "longhorn"),
+			accessMode:    corev1.ReadWriteOnce,
+			preferredNode: options["node"],
+		}, nil
+	case "hostpath":
+		return &hostPathDriver{
+			basePath: strings.TrimSuffix(optionOr(options, "path", "/var/lib/minecharts"), "/"),
+			node:     options["node"],
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown storage driver %q", name)
+	}
+}
+
+// ParseStorageOptions parses driver options written as comma separated key=value pairs.
+func ParseStorageOptions(value string) (map[string]string, error) {
+	options := map[string]string{}
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, val, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(key) == "" {
+			return nil, fmt.Errorf("invalid storage option %q, expected key=value", pair)
+		}
+		options[strings.TrimSpace(key)] = strings.TrimSpace(val)
+	}
+	return options, nil
+}
+
+func optionOr(options map[string]string, key, fallback string) string {
+	if value := options[key]; value != "" {
+		return value
+	}
+	return fallback
+}
+
+func accessModeOption(value string, fallback corev1.PersistentVolumeAccessMode) (corev1.PersistentVolumeAccessMode, error) {
+	switch corev1.PersistentVolumeAccessMode(value) {
+	case "":
+		return fallback, nil
+	case corev1.ReadWriteOnce, corev1.ReadWriteMany, corev1.ReadWriteOncePod:
+		return corev1.PersistentVolumeAccessMode(value), nil
+	default:
+		return "", fmt.Errorf("unsupported access mode %q", value)
+	}
+}
+
+// EnsureStorage creates the storage of a server with the selected driver.
+func EnsureStorage(ctx context.Context, namespace, volumeName, storageSize, storageClass string) error {
+	return Storage.Ensure(ctx, namespace, volumeName, storageSize, storageClass)
+}
+
+// DeleteStorage removes the storage of a server with the selected driver.
+func DeleteStorage(ctx context.Context, namespace, volumeName string) error {
+	return Storage.Delete(ctx, namespace, volumeName)
+}
+
+// pvcDriver stores each server on a PVC of a storage class.
+type pvcDriver struct {
+	name         string
+	storageClass string
+	accessMode   corev1.PersistentVolumeAccessMode
+	annotations  func(volumeName string) map[string]string
+	// preferredNode is where the pods are scheduled when possible, so that storage
+	// systems keeping a replica next to the workload serve it locally.
+	preferredNode string
+}
+
+func (d *pvcDriver) Name() string { return d.name }
+
+// Ensure checks if the PVC exists in the given namespace; if not, it creates it
+// with the given size and class, or the configured defaults when empty.
+func (d *pvcDriver) Ensure(ctx context.Context, namespace, pvcName, storageSize, storageClass string) error {
+	logging.K8s.WithContext(ctx).WithFields(
 		"namespace", namespace,
 		"pvc_name", pvcName,
 	).Debug("Checking if PVC exists")
 
-	_, err := Clientset.CoreV1().PersistentVolumeClaims(namespace).Get(context.Background(), pvcName, metav1.GetOptions{})
+	_, err := Clientset.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, pvcName, metav1.GetOptions{})
 	if err == nil {
-		logging.K8s.WithFields(
+		logging.K8s.WithContext(ctx).WithFields(
 			"namespace", namespace,
 			"pvc_name", pvcName,
 		).Debug("PVC already exists")
 		return nil // PVC already exists.
 	}
 
-	logging.K8s.WithFields(
+	if storageSize == "" {
+		storageSize = config.StorageSize
+	}
+	if storageClass == "" {
+		storageClass = d.storageClass
+	}
+	quantity, err := resource.ParseQuantity(storageSize)
+	if err != nil {
+		logging.K8s.WithContext(ctx).WithFields(
+			"namespace", namespace,
+			"pvc_name", pvcName,
+			"storage_size", storageSize,
+			"error", err.Error(),
+		).Error("Invalid PVC storage size")
+		return err
+	}
+
+	logging.K8s.WithContext(ctx).WithFields(
 		"namespace", namespace,
 		"pvc_name", pvcName,
-		"storage_size", config.StorageSize,
-		"storage_class", config.StorageClass,
+		"storage_size", storageSize,
+		"storage_class", storageClass,
+		"storage_driver", d.name,
 	).Info("Creating new PVC")
 
 	pvc := &corev1.PersistentVolumeClaim{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      pvcName,
 			Namespace: namespace,
+			Labels: map[string]string{
+				"created-by": "minecharts-api",
+				"app":        pvcDeployment(pvcName),
+			},
 		},
 		Spec: corev1.PersistentVolumeClaimSpec{
 			AccessModes: []corev1.PersistentVolumeAccessMode{
-				corev1.ReadWriteOnce,
+				d.accessMode,
 			},
 			Resources: corev1.VolumeResourceRequirements{
 				Requests: corev1.ResourceList{
-					corev1.ResourceStorage: resource.MustParse(config.StorageSize),
+					corev1.ResourceStorage: quantity,
 				},
 			},
-			StorageClassName: ptr.To(config.StorageClass),
+			StorageClassName: ptr.To(storageClass),
 		},
 	}
-	_, err = Clientset.CoreV1().PersistentVolumeClaims(namespace).Create(context.Background(), pvc, metav1.CreateOptions{})
+	if d.annotations != nil {
+		pvc.Annotations = d.annotations(pvcName)
+	}
+
+	annotateChange(ctx, pvc)
+	_, err = Clientset.CoreV1().PersistentVolumeClaims(namespace).Create(ctx, pvc, metav1.CreateOptions{FieldManager: FieldManager})
 	if err != nil {
-		logging.K8s.WithFields(
+		logging.K8s.WithContext(ctx).WithFields(
 			"namespace", namespace,
 			"pvc_name", pvcName,
 			"error", err.Error(),
@@ -61,7 +221,7 @@ func EnsurePVC(namespace, pvcName string) error {
 		return err
 	}
 
-	logging.K8s.WithFields(
+	logging.K8s.WithContext(ctx).WithFields(
 		"namespace", namespace,
 		"pvc_name", pvcName,
 	).Info("PVC created successfully")
@@ -69,16 +229,103 @@ func EnsurePVC(namespace, pvcName string) error {
 	return nil
 }
 
+func (d *pvcDriver) Delete(ctx context.Context, namespace, pvcName string) error {
+	return DeletePVC(ctx, namespace, pvcName)
+}
+
+func (d *pvcDriver) VolumeSource(pvcName string) corev1.VolumeSource {
+	return corev1.VolumeSource{
+		PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+			ClaimName: pvcName,
+		},
+	}
+}
+
+func (d *pvcDriver) ConfigurePod(spec *corev1.PodSpec) {
+	if d.preferredNode == "" {
+		return
+	}
+	// Added to the node affinity the server may have
+	if spec.Affinity == nil {
+		spec.Affinity = &corev1.Affinity{}
+	}
+	if spec.Affinity.NodeAffinity == nil {
+		spec.Affinity.NodeAffinity = &corev1.NodeAffinity{}
+	}
+	nodeAffinity := spec.Affinity.NodeAffinity
+	nodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution = append(nodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution,
+		corev1.PreferredSchedulingTerm{
+			Weight: 100,
+			Preference: corev1.NodeSelectorTerm{
+				MatchExpressions: []corev1.NodeSelectorRequirement{
+					{
+						Key:      corev1.LabelHostname,
+						Operator: corev1.NodeSelectorOpIn,
+						Values:   []string{d.preferredNode},
+					},
+				},
+			},
+		})
+}
+
+// hostPathDriver stores each server in a directory of the node, for single-node clusters.
+type hostPathDriver struct {
+	basePath string
+	// node pins the servers to the node holding the directories.
+	node string
+}
+
+func (d *hostPathDriver) Name() string { return "hostpath" }
+
+// Ensure has nothing to create: the kubelet creates the directory when the pod starts.
+func (d *hostPathDriver) Ensure(ctx context.Context, namespace, volumeName, storageSize, storageClass string) error {
+	logging.K8s.WithContext(ctx).WithFields(
+		"namespace", namespace,
+		"path", d.basePath+"/"+volumeName,
+	).Debug("Host path storage is created with the pod")
+	return nil
+}
+
+// Delete cannot remove a directory of the node through the API, it is left for the
+// administrator to clean up.
+func (d *hostPathDriver) Delete(ctx context.Context, namespace, volumeName string) error {
+	logging.K8s.WithContext(ctx).WithFields(
+		"namespace", namespace,
+		"path", d.basePath+"/"+volumeName,
+		"node", d.node,
+	).Warn("Host path storage kept on the node, remove the directory manually")
+	return nil
+}
+
+func (d *hostPathDriver) VolumeSource(volumeName string) corev1.VolumeSource {
+	return corev1.VolumeSource{
+		HostPath: &corev1.HostPathVolumeSource{
+			Path: d.basePath + "/" + volumeName,
+			Type: ptr.To(corev1.HostPathDirectoryOrCreate),
+		},
+	}
+}
+
+func (d *hostPathDriver) ConfigurePod(spec *corev1.PodSpec) {
+	if d.node == "" {
+		return
+	}
+	if spec.NodeSelector == nil {
+		spec.NodeSelector = map[string]string{}
+	}
+	spec.NodeSelector[corev1.LabelHostname] = d.node
+}
+
 // deletePVC removes a PVC if it exists
-func DeletePVC(namespace, pvcName string) error {
-	logging.K8s.WithFields(
+func DeletePVC(ctx context.Context, namespace, pvcName string) error {
+	logging.K8s.WithContext(ctx).WithFields(
 		"namespace", namespace,
 		"pvc_name", pvcName,
 	).Debug("Attempting to delete PVC")
 
-	err := Clientset.CoreV1().PersistentVolumeClaims(namespace).Delete(context.Background(), pvcName, metav1.DeleteOptions{})
+	err := Clientset.CoreV1().PersistentVolumeClaims(namespace).Delete(ctx, pvcName, metav1.DeleteOptions{})
 	if err != nil {
-		logging.K8s.WithFields(
+		logging.K8s.WithContext(ctx).WithFields(
 			"namespace", namespace,
 			"pvc_name", pvcName,
 			"error", err.Error(),
@@ -86,7 +333,7 @@ func DeletePVC(namespace, pvcName string) error {
 		return err
 	}
 
-	logging.K8s.WithFields(
+	logging.K8s.WithContext(ctx).WithFields(
 		"namespace", namespace,
 		"pvc_name", pvcName,
 	).Info("PVC deleted successfully")
@@ -0,0 +1,214 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"minecharts/cmd/config"
+	"minecharts/cmd/database"
+	"minecharts/cmd/logging"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// tenantNamespacePrefix starts the namespaces of the servers of each user.
+const tenantNamespacePrefix = "minecharts-user-"
+
+// tenantPolicyName names the quota, limit range and network policy of a tenant namespace.
+const tenantPolicyName = "minecharts-tenant"
+
+// TenantLabel labels the tenant namespaces with the ID of their user.
+const TenantLabel = "minecharts.io/tenant-user"
+
+// ServerNamespace returns the namespace of the resources of a server: the namespace
+// recorded when it was created, or the default namespace for the servers created
+// without tenant namespaces.
+func ServerNamespace(server *database.MinecraftServer) string {
+	if server == nil || server.Namespace == "" {
+		return config.DefaultNamespace
+	}
+	return server.Namespace
+}
+
+// TenantNamespace returns the namespace to record for a new server of a user: their
+// own with tenant namespaces, empty for the default namespace otherwise.
+func TenantNamespace(ownerID int64) string {
+	if !config.TenantNamespaces {
+		return ""
+	}
+	return tenantNamespacePrefix + strconv.FormatInt(ownerID, 10)
+}
+
+// WatchedNamespace returns the namespace the watchers and the reconciler follow:
+// every namespace with tenant namespaces, as the servers are spread across them.
+func WatchedNamespace() string {
+	if config.TenantNamespaces {
+		return metav1.NamespaceAll
+	}
+	return config.DefaultNamespace
+}
+
+// EnsureTenantNamespace creates the namespace of a user with its ResourceQuota,
+// LimitRange and NetworkPolicy, or updates them to the configuration. The policy
+// lets players reach the game ports, and only the pods of the namespace and of the
+// namespace of the API reach the others.
+func EnsureTenantNamespace(ctx context.Context, namespace string, ownerID int64) error {
+	labels := map[string]string{
+		"created-by": "minecharts-api",
+		TenantLabel:  strconv.FormatInt(ownerID, 10),
+	}
+
+	namespaces := Clientset.CoreV1().Namespaces()
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: namespace, Labels: labels}}
+	annotateChange(ctx, ns)
+	if _, err := namespaces.Create(ctx, ns, metav1.CreateOptions{FieldManager: FieldManager}); err != nil && !apierrors.IsAlreadyExists(err) {
+		return tenantFailure(ctx, namespace, "namespace", err)
+	}
+
+	quotas := Clientset.CoreV1().ResourceQuotas(namespace)
+	quota := &corev1.ResourceQuota{
+		ObjectMeta: metav1.ObjectMeta{Name: tenantPolicyName, Namespace: namespace, Labels: labels},
+		Spec:       corev1.ResourceQuotaSpec{Hard: tenantQuota()},
+	}
+	annotateChange(ctx, quota)
+	_, err := quotas.Create(ctx, quota, metav1.CreateOptions{FieldManager: FieldManager})
+	if apierrors.IsAlreadyExists(err) {
+		existing, getErr := quotas.Get(ctx, tenantPolicyName, metav1.GetOptions{})
+		if getErr != nil {
+			return tenantFailure(ctx, namespace, "resource quota", getErr)
+		}
+		existing.Spec = quota.Spec
+		annotateChange(ctx, existing)
+		_, err = quotas.Update(ctx, existing, metav1.UpdateOptions{FieldManager: FieldManager})
+	}
+	if err != nil {
+		return tenantFailure(ctx, namespace, "resource quota", err)
+	}
+
+	limitRanges := Clientset.CoreV1().LimitRanges(namespace)
+	limitRange := &corev1.LimitRange{
+		ObjectMeta: metav1.ObjectMeta{Name: tenantPolicyName, Namespace: namespace, Labels: labels},
+		Spec:       corev1.LimitRangeSpec{Limits: tenantLimits()},
+	}
+	annotateChange(ctx, limitRange)
+	_, err = limitRanges.Create(ctx, limitRange, metav1.CreateOptions{FieldManager: FieldManager})
+	if apierrors.IsAlreadyExists(err) {
+		existing, getErr := limitRanges.Get(ctx, tenantPolicyName, metav1.GetOptions{})
+		if getErr != nil {
+			return tenantFailure(ctx, namespace, "limit range", getErr)
+		}
+		existing.Spec = limitRange.Spec
+		annotateChange(ctx, existing)
+		_, err = limitRanges.Update(ctx, existing, metav1.UpdateOptions{FieldManager: FieldManager})
+	}
+	if err != nil {
+		return tenantFailure(ctx, namespace, "limit range", err)
+	}
+
+	policies := Clientset.NetworkingV1().NetworkPolicies(namespace)
+	policy := &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: tenantPolicyName, Namespace: namespace, Labels: labels},
+		Spec:       tenantNetworkPolicy(),
+	}
+	annotateChange(ctx, policy)
+	_, err = policies.Create(ctx, policy, metav1.CreateOptions{FieldManager: FieldManager})
+	if apierrors.IsAlreadyExists(err) {
+		existing, getErr := policies.Get(ctx, tenantPolicyName, metav1.GetOptions{})
+		if getErr != nil {
+			return tenantFailure(ctx, namespace, "network policy", getErr)
+		}
+		existing.Spec = policy.Spec
+		annotateChange(ctx, existing)
+		_, err = policies.Update(ctx, existing, metav1.UpdateOptions{FieldManager: FieldManager})
+	}
+	if err != nil {
+		return tenantFailure(ctx, namespace, "network policy", err)
+	}
+
+	logging.K8s.WithContext(ctx).WithFields(
+		"namespace", namespace,
+		"owner_id", ownerID,
+	).Debug("Tenant namespace ensured")
+	return nil
+}
+
+// tenantFailure logs and returns the failure to write an object of a tenant namespace.
+func tenantFailure(ctx context.Context, namespace, object string, err error) error {
+	logging.K8s.WithContext(ctx).WithFields(
+		"namespace", namespace,
+		"object", object,
+		"error", err.Error(),
+	).Error("Failed to set up tenant namespace")
+	return fmt.Errorf("failed to set up the %s of namespace %s: %w", object, namespace, err)
+}
+
+// tenantQuota returns the hard limits of the quota of a tenant namespace, leaving
+// out the empty settings.
+func tenantQuota() corev1.ResourceList {
+	hard := corev1.ResourceList{}
+	for name, quantity := range map[corev1.ResourceName]string{
+		corev1.ResourceRequestsCPU:     config.TenantQuotaCPU,
+		corev1.ResourceLimitsMemory:    config.TenantQuotaMemory,
+		corev1.ResourceRequestsStorage: config.TenantQuotaStorage,
+	} {
+		if quantity != "" {
+			hard[name] = resource.MustParse(quantity) // Validated with the configuration
+		}
+	}
+	if config.TenantQuotaPods > 0 {
+		hard[corev1.ResourcePods] = *resource.NewQuantity(int64(config.TenantQuotaPods), resource.DecimalSI)
+	}
+	return hard
+}
+
+// tenantLimits returns the defaults of the containers of a tenant namespace that set
+// no resources, such as the volume jobs, which the quota would refuse otherwise.
+func tenantLimits() []corev1.LimitRangeItem {
+	item := corev1.LimitRangeItem{
+		Type:           corev1.LimitTypeContainer,
+		Default:        corev1.ResourceList{},
+		DefaultRequest: corev1.ResourceList{},
+	}
+	if config.TenantDefaultCPU != "" {
+		item.DefaultRequest[corev1.ResourceCPU] = resource.MustParse(config.TenantDefaultCPU)
+	}
+	if config.TenantDefaultMemory != "" {
+		item.Default[corev1.ResourceMemory] = resource.MustParse(config.TenantDefaultMemory)
+		item.DefaultRequest[corev1.ResourceMemory] = resource.MustParse(config.TenantDefaultMemory)
+	}
+	return []corev1.LimitRangeItem{item}
+}
+
+// tenantNetworkPolicy returns the ingress policy of a tenant namespace: the game
+// ports from anywhere, for the players, and every port from the namespace itself and
+// from the namespace of the API, which runs the proxies and RCON commands.
+func tenantNetworkPolicy() networkingv1.NetworkPolicySpec {
+	tcp, udp := corev1.ProtocolTCP, corev1.ProtocolUDP
+	javaPort, bedrockPort := intstr.FromInt32(25565), intstr.FromInt32(BedrockPort)
+	return networkingv1.NetworkPolicySpec{
+		PodSelector: metav1.LabelSelector{},
+		PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+		Ingress: []networkingv1.NetworkPolicyIngressRule{
+			{
+				From: []networkingv1.NetworkPolicyPeer{
+					{PodSelector: &metav1.LabelSelector{}},
+					{NamespaceSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{corev1.LabelMetadataName: config.DefaultNamespace},
+					}},
+				},
+			},
+			{
+				Ports: []networkingv1.NetworkPolicyPort{
+					{Protocol: &tcp, Port: &javaPort},
+					{Protocol: &udp, Port: &bedrockPort},
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,43 @@
+package kubernetes
+
+import (
+	"net/http"
+	"strconv"
+
+	"minecharts/cmd/tracing"
+)
+
+// tracingTransport records a client span for each call to the Kubernetes API, child
+// of the span of the request context, and propagates the trace to the API server.
+type tracingTransport struct {
+	next http.RoundTripper
+}
+
+func (t *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resource := apiResource(req.URL.Path)
+	ctx, span := tracing.Start(req.Context(), tracing.KindClient, "k8s "+req.Method+" "+resource)
+	if span == nil {
+		return t.next.RoundTrip(req)
+	}
+	defer span.End()
+
+	// RoundTrippers must not modify the request they are given
+	req = req.Clone(ctx)
+	req.Header.Set(tracing.TraceparentHeader, tracing.Traceparent(ctx))
+	span.SetAttributes(
+		"http.request.method", req.Method,
+		"server.address", req.URL.Host,
+		"k8s.resource", resource,
+	)
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		span.SetError(err)
+		return resp, err
+	}
+	span.SetAttributes("http.response.status_code", resp.StatusCode)
+	if resp.StatusCode >= http.StatusInternalServerError {
+		span.SetFailed("HTTP " + strconv.Itoa(resp.StatusCode))
+	}
+	return resp, err
+}
@@ -1,6 +1,7 @@
 package kubernetes
 
 import (
+	"context"
 	"time"
 
 	"minecharts/cmd/config"
@@ -18,7 +19,7 @@ func GetServerInfo(c *gin.Context) (deploymentName, pvcName string) {
 	deploymentName = config.DeploymentPrefix + serverName
 	pvcName = deploymentName + config.PVCSuffix
 
-	logging.K8s.WithFields(
+	logging.K8s.WithContext(c.Request.Context()).WithFields(
 		logging.F("server_name", serverName),
 		logging.F("deployment_name", deploymentName),
 		logging.F("pvc_name", pvcName),
@@ -30,15 +31,15 @@ func GetServerInfo(c *gin.Context) (deploymentName, pvcName string) {
 
 // saveWorld sends a "save-all" command to the Minecraft server pod to save the world data.
 // This is a utility function to avoid code duplication across handlers.
-func SaveWorld(podName, namespace string) (stdout, stderr string, err error) {
-	logging.K8s.WithFields(
+func SaveWorld(ctx context.Context, podName, namespace string) (stdout, stderr string, err error) {
+	logging.K8s.WithContext(ctx).WithFields(
 		logging.F("pod_name", podName),
 		logging.F("namespace", namespace),
 	).Debug("Sending save-all command to Minecraft server pod")
 
-	stdout, stderr, err = ExecuteCommandInPod(podName, namespace, "minecraft-server", "mc-send-to-console save-all")
+	stdout, stderr, err = ExecuteCommandInPod(ctx, podName, namespace, "minecraft-server", "mc-send-to-console save-all")
 	if err != nil {
-		logging.K8s.WithFields(
+		logging.K8s.WithContext(ctx).WithFields(
 			logging.F("pod_name", podName),
 			logging.F("namespace", namespace),
 			logging.F("error", err.Error()),
@@ -48,14 +49,22 @@ func SaveWorld(podName, namespace string) (stdout, stderr string, err error) {
 	}
 
 	// Wait for the save to complete
-	logging.K8s.WithFields(
+	logging.K8s.WithContext(ctx).WithFields(
 		logging.F("pod_name", podName),
 		logging.F("namespace", namespace),
 	).Debug("Waiting for save-all command to complete")
 
-	time.Sleep(10 * time.Second)
+	wait := 10 * time.Second
+	if config.DevMode {
+		wait = 0
+	}
+	select {
+	case <-time.After(wait):
+	case <-ctx.Done():
+		return stdout, stderr, ctx.Err()
+	}
 
-	logging.K8s.WithFields(
+	logging.K8s.WithContext(ctx).WithFields(
 		logging.F("pod_name", podName),
 		logging.F("namespace", namespace),
 	).Debug("Save-all command completed")
@@ -0,0 +1,192 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"minecharts/cmd/config"
+	"minecharts/cmd/logging"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+)
+
+// volumeJob is a shell command run by a job against server volumes.
+type volumeJob struct {
+	name string
+	// app is the deployment the job works for, near the deployment whose node is
+	// preferred so that ReadWriteOnce volumes can be mounted while it runs.
+	app, near string
+	command   string
+	mounts    []volumeJobMount
+	deadline  time.Duration
+	// output receives the logs of the job once it completes, when set.
+	output *string
+}
+
+// volumeJobMount mounts the volume of the storage driver with the given name at path.
+type volumeJobMount struct {
+	volume   string
+	path     string
+	readOnly bool
+}
+
+// runVolumeJob creates the job and waits for it to complete. The job is deleted
+// once finished, or when the context is done.
+func runVolumeJob(ctx context.Context, namespace string, spec volumeJob) error {
+	container := corev1.Container{
+		Name:    "job",
+		Image:   config.CloneImage,
+		Command: []string{"/bin/sh", "-c", spec.command},
+	}
+	var volumes []corev1.Volume
+	for i, mount := range spec.mounts {
+		name := fmt.Sprintf("volume-%d", i)
+		container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+			Name: name, MountPath: mount.path, ReadOnly: mount.readOnly,
+		})
+		volumes = append(volumes, corev1.Volume{Name: name, VolumeSource: Storage.VolumeSource(mount.volume)})
+	}
+
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: spec.name,
+			Labels: map[string]string{
+				"created-by": "minecharts-api",
+				"app":        spec.app,
+			},
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit:            ptr.To(int32(2)),
+			ActiveDeadlineSeconds:   ptr.To(int64(spec.deadline.Seconds())),
+			TTLSecondsAfterFinished: ptr.To(int32(600)),
+			Template: corev1.PodTemplateSpec{
+				// No app label: the pod must not be taken for a server pod
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: map[string]string{
+						"created-by": "minecharts-api",
+						"job-name":   spec.name,
+					},
+				},
+				Spec: corev1.PodSpec{
+					RestartPolicy: corev1.RestartPolicyNever,
+					Containers:    []corev1.Container{container},
+					Volumes:       volumes,
+				},
+			},
+		},
+	}
+
+	// The node of the server is preferred on top of the storage constraints
+	podSpec := &job.Spec.Template.Spec
+	Storage.ConfigurePod(podSpec)
+	if podSpec.Affinity == nil {
+		podSpec.Affinity = &corev1.Affinity{}
+	}
+	podSpec.Affinity.PodAffinity = &corev1.PodAffinity{
+		PreferredDuringSchedulingIgnoredDuringExecution: []corev1.WeightedPodAffinityTerm{
+			{
+				Weight: 100,
+				PodAffinityTerm: corev1.PodAffinityTerm{
+					LabelSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"app": spec.near},
+					},
+					TopologyKey: corev1.LabelHostname,
+				},
+			},
+		},
+	}
+
+	jobs := Clientset.BatchV1().Jobs(namespace)
+	annotateChange(ctx, job)
+	if _, err := jobs.Create(ctx, job, metav1.CreateOptions{FieldManager: FieldManager}); err != nil {
+		logging.K8s.WithContext(ctx).WithFields(
+			"namespace", namespace,
+			"job_name", spec.name,
+			"error", err.Error(),
+		).Error("Failed to create volume job")
+		return fmt.Errorf("failed to create job: %w", err)
+	}
+
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			deleteVolumeJob(context.WithoutCancel(ctx), namespace, spec.name)
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		current, err := jobs.Get(ctx, spec.name, metav1.GetOptions{})
+		if err != nil {
+			logging.K8s.WithContext(ctx).WithFields(
+				"namespace", namespace,
+				"job_name", spec.name,
+				"error", err.Error(),
+			).Warn("Failed to get volume job status")
+			continue
+		}
+		for _, condition := range current.Status.Conditions {
+			if condition.Status != corev1.ConditionTrue {
+				continue
+			}
+			switch condition.Type {
+			case batchv1.JobComplete:
+				if spec.output != nil {
+					*spec.output = volumeJobLogs(ctx, namespace, spec.name)
+				}
+				deleteVolumeJob(ctx, namespace, spec.name)
+				return nil
+			case batchv1.JobFailed:
+				logging.K8s.WithContext(ctx).WithFields(
+					"namespace", namespace,
+					"job_name", spec.name,
+					"reason", condition.Reason,
+					"message", condition.Message,
+				).Error("Volume job failed")
+				deleteVolumeJob(ctx, namespace, spec.name)
+				return fmt.Errorf("job failed: %s", joinReason(condition.Reason, condition.Message, "unknown error"))
+			}
+		}
+	}
+}
+
+// volumeJobLogs returns the logs of the pod that completed a job, or an empty string
+// when they cannot be read.
+func volumeJobLogs(ctx context.Context, namespace, jobName string) string {
+	pods, err := Clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: "job-name=" + jobName,
+		FieldSelector: "status.phase=Succeeded",
+	})
+	if err != nil || len(pods.Items) == 0 {
+		return ""
+	}
+	logs, err := Clientset.CoreV1().Pods(namespace).GetLogs(pods.Items[0].Name, &corev1.PodLogOptions{Container: "job"}).DoRaw(ctx)
+	if err != nil {
+		logging.K8s.WithContext(ctx).WithFields(
+			"namespace", namespace,
+			"job_name", jobName,
+			"error", err.Error(),
+		).Warn("Failed to read volume job logs")
+		return ""
+	}
+	return string(logs)
+}
+
+// deleteVolumeJob removes a finished job along with its pod, so a new job can use its name.
+func deleteVolumeJob(ctx context.Context, namespace, jobName string) {
+	err := Clientset.BatchV1().Jobs(namespace).Delete(ctx, jobName, metav1.DeleteOptions{
+		PropagationPolicy: ptr.To(metav1.DeletePropagationBackground),
+	})
+	if err != nil {
+		logging.K8s.WithContext(ctx).WithFields(
+			"namespace", namespace,
+			"job_name", jobName,
+			"error", err.Error(),
+		).Warn("Failed to delete volume job, it expires on its own")
+	}
+}
@@ -0,0 +1,210 @@
+package kubernetes
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"minecharts/cmd/config"
+	"minecharts/cmd/database"
+	"minecharts/cmd/logging"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+)
+
+// watcherResync is how often the informer replays every pod, so status updates
+// lost to a database error are eventually retried.
+const watcherResync = 10 * time.Minute
+
+// ServerStatusStore persists the provisioning state derived from the server pods.
+type ServerStatusStore interface {
+	UpdateServerStatus(ctx context.Context, serverName string, status string, reason string) error
+}
+
+// failedWaitingReasons are container waiting reasons that need user action rather
+// than time to resolve.
+var failedWaitingReasons = map[string]bool{
+	"ErrImagePull":               true,
+	"ImagePullBackOff":           true,
+	"InvalidImageName":           true,
+	"CrashLoopBackOff":           true,
+	"CreateContainerConfigError": true,
+	"CreateContainerError":       true,
+}
+
+// PodServerStatus derives the provisioning state of a server from its pod,
+// along with the failure reason when the pod cannot start.
+func PodServerStatus(pod *corev1.Pod) (status, reason string) {
+	switch pod.Status.Phase {
+	case corev1.PodFailed:
+		return database.ServerStatusFailed, joinReason(pod.Status.Reason, pod.Status.Message, "Pod failed")
+	case corev1.PodSucceeded:
+		return database.ServerStatusFailed, "Server process exited"
+	}
+
+	for _, container := range pod.Status.ContainerStatuses {
+		if waiting := container.State.Waiting; waiting != nil && failedWaitingReasons[waiting.Reason] {
+			return database.ServerStatusFailed, joinReason(waiting.Reason, waiting.Message, "")
+		}
+	}
+
+	for _, condition := range pod.Status.Conditions {
+		switch {
+		case condition.Type == corev1.PodScheduled && condition.Status == corev1.ConditionFalse &&
+			condition.Reason == corev1.PodReasonUnschedulable:
+			return database.ServerStatusFailed, joinReason(condition.Reason, condition.Message, "")
+		case condition.Type == corev1.PodReady && condition.Status == corev1.ConditionTrue:
+			return database.ServerStatusRunning, ""
+		}
+	}
+
+	return database.ServerStatusStarting, ""
+}
+
+func joinReason(reason, message, fallback string) string {
+	switch {
+	case reason != "" && message != "":
+		return reason + ": " + message
+	case reason != "":
+		return reason
+	case message != "":
+		return message
+	default:
+		return fallback
+	}
+}
+
+// serverPods is the pod cache of the server watcher, nil until it is started.
+var serverPods cache.Store
+
+// CachedMinecraftPod returns the last known pod of a deployment from the server
+// watcher cache, without calling the API server. It returns nil when unknown.
+func CachedMinecraftPod(deploymentName string) *corev1.Pod {
+	if serverPods == nil {
+		return nil
+	}
+	for _, obj := range serverPods.List() {
+		if pod, ok := obj.(*corev1.Pod); ok && pod.Labels["app"] == deploymentName && pod.DeletionTimestamp == nil {
+			return pod
+		}
+	}
+	return nil
+}
+
+// serverWatcher records pod state changes of Minecraft servers in the store.
+type serverWatcher struct {
+	store ServerWatcherStore
+	// last holds the last recorded "status|reason" per server to skip redundant writes.
+	last map[string]string
+	// incidents holds the kinds of the incidents recorded per pod since it was last ready.
+	incidents map[types.UID]map[string]bool
+}
+
+// StartServerWatcher watches the Minecraft server pods of a namespace with an informer
+// and records their provisioning state and the crashes of their containers in the
+// store until the context is cancelled. Across every namespace, with metav1.NamespaceAll,
+// only the pods of the API are watched.
+func StartServerWatcher(ctx context.Context, namespace string, store ServerWatcherStore) {
+	options := []informers.SharedInformerOption{informers.WithNamespace(namespace)}
+	if namespace == metav1.NamespaceAll {
+		options = append(options, informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.LabelSelector = managedSelector
+		}))
+	}
+	factory := informers.NewSharedInformerFactoryWithOptions(Clientset, watcherResync, options...)
+	podInformer := factory.Core().V1().Pods().Informer()
+	serverPods = podInformer.GetStore()
+
+	watcher := &serverWatcher{store: store, last: map[string]string{}, incidents: map[types.UID]map[string]bool{}}
+	podInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: watcher.onPod,
+		UpdateFunc: func(oldObj, obj interface{}) {
+			watcher.onPodUpdated(oldObj, obj)
+			watcher.onPod(obj)
+		},
+		DeleteFunc: watcher.onPodDeleted,
+	})
+
+	factory.Start(ctx.Done())
+
+	go func() {
+		if !cache.WaitForCacheSync(ctx.Done(), podInformer.HasSynced) {
+			logging.K8s.WithContext(ctx).WithFields(
+				"namespace", namespace,
+			).Warn("Server watcher stopped before the pod cache synced")
+			return
+		}
+		logging.K8s.WithContext(ctx).WithFields(
+			"namespace", namespace,
+		).Info("Server watcher started")
+	}()
+}
+
+func (w *serverWatcher) onPod(obj interface{}) {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		return
+	}
+
+	serverName, ok := podServerName(pod)
+	if !ok {
+		return
+	}
+
+	// Pods being deleted belong to a stopped, restarted or deleted server;
+	// the replacement pod, if any, reports the new state.
+	if pod.DeletionTimestamp != nil {
+		delete(w.last, serverName)
+		return
+	}
+
+	status, reason := PodServerStatus(pod)
+	key := status + "|" + reason
+	if w.last[serverName] == key {
+		return
+	}
+
+	logging.K8s.WithFields(
+		"server_name", serverName,
+		"pod_name", pod.Name,
+		"status", status,
+		"reason", reason,
+	).Debug("Server pod state changed")
+
+	if err := w.store.UpdateServerStatus(context.Background(), serverName, status, reason); err != nil {
+		logging.K8s.WithFields(
+			"server_name", serverName,
+			"status", status,
+			"error", err.Error(),
+		).Warn("Failed to record server status")
+		return
+	}
+	w.last[serverName] = key
+}
+
+func (w *serverWatcher) onPodDeleted(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		return
+	}
+	if serverName, ok := podServerName(pod); ok {
+		delete(w.last, serverName)
+	}
+	delete(w.incidents, pod.UID)
+}
+
+// podServerName returns the name of the server a pod belongs to.
+func podServerName(pod *corev1.Pod) (string, bool) {
+	deploymentName := pod.Labels["app"]
+	if !strings.HasPrefix(deploymentName, config.DeploymentPrefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(deploymentName, config.DeploymentPrefix), true
+}
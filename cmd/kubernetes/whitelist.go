@@ -0,0 +1,219 @@
+package kubernetes
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"minecharts/cmd/database"
+	"minecharts/cmd/logging"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// WhitelistStore provides the servers and users the whitelists are synced with.
+type WhitelistStore interface {
+	ListServers(ctx context.Context) ([]*database.MinecraftServer, error)
+	ListUsers(ctx context.Context) ([]*database.User, error)
+}
+
+// whitelistSyncer keeps the whitelist of the servers with SyncWhitelist in their spec
+// in sync with the linked Minecraft accounts of the users who can view them.
+type whitelistSyncer struct {
+	namespace string
+	store     WhitelistStore
+	// applied holds, for each server pod, whether each player was last whitelisted
+	// or removed, so that unchanged entries are not sent again.
+	applied map[string]map[string]bool
+}
+
+// whitelistRequests holds the syncs requested since the last one.
+var whitelistRequests = struct {
+	sync.Mutex
+	removed []string
+	wake    chan struct{}
+}{wake: make(chan struct{}, 1)}
+
+// RequestWhitelistSync asks for the synced whitelists to be updated soon, after the
+// access or the linked account of a user changed. The players given are removed
+// from the whitelists, for the accounts that were unlinked or whose user was deleted.
+func RequestWhitelistSync(removed ...string) {
+	whitelistRequests.Lock()
+	whitelistRequests.removed = append(whitelistRequests.removed, removed...)
+	whitelistRequests.Unlock()
+
+	select {
+	case whitelistRequests.wake <- struct{}{}:
+	default:
+	}
+}
+
+// StartWhitelistSync syncs the whitelists every interval, and when a sync is requested,
+// until the context is cancelled. Stopped servers are synced once they run again.
+func StartWhitelistSync(ctx context.Context, namespace string, store WhitelistStore, interval time.Duration) {
+	s := &whitelistSyncer{
+		namespace: namespace,
+		store:     store,
+		applied:   map[string]map[string]bool{},
+	}
+
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		tick = ticker.C
+		go func() {
+			<-ctx.Done()
+			ticker.Stop()
+		}()
+	}
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-tick:
+			case <-whitelistRequests.wake:
+			}
+
+			whitelistRequests.Lock()
+			removed := whitelistRequests.removed
+			whitelistRequests.removed = nil
+			whitelistRequests.Unlock()
+
+			s.sync(ctx, removed)
+		}
+	}()
+}
+
+func (s *whitelistSyncer) sync(ctx context.Context, removed []string) {
+	if !ClusterReachable() {
+		logging.K8s.WithContext(ctx).Debug("Skipping whitelist sync, cluster unreachable")
+		// Retried with the next sync
+		if len(removed) > 0 {
+			whitelistRequests.Lock()
+			whitelistRequests.removed = append(whitelistRequests.removed, removed...)
+			whitelistRequests.Unlock()
+		}
+		return
+	}
+
+	servers, err := s.store.ListServers(ctx)
+	if err != nil {
+		logging.K8s.WithContext(ctx).WithFields(
+			"error", err.Error(),
+		).Warn("Whitelist sync failed to list servers")
+		return
+	}
+	users, err := s.store.ListUsers(ctx)
+	if err != nil {
+		logging.K8s.WithContext(ctx).WithFields(
+			"error", err.Error(),
+		).Warn("Whitelist sync failed to list users")
+		return
+	}
+
+	pods := map[string]bool{}
+	for _, server := range servers {
+		if !server.Spec.SyncWhitelist || server.Status != database.ServerStatusRunning {
+			continue
+		}
+		podName, err := s.syncServer(ctx, server, users, removed)
+		if err != nil {
+			logging.K8s.WithContext(ctx).WithFields(
+				"server_name", server.ServerName,
+				"error", err.Error(),
+			).Warn("Failed to sync server whitelist")
+		}
+		if podName != "" {
+			pods[podName] = true
+		}
+	}
+
+	// Forget the pods that are gone
+	for podName := range s.applied {
+		if !pods[podName] {
+			delete(s.applied, podName)
+		}
+	}
+}
+
+// syncServer whitelists the linked accounts of the users who can view a running
+// server, its members and those of its organization included, and removes those
+// of the other users and the removed players. It returns the name of the server pod.
+func (s *whitelistSyncer) syncServer(ctx context.Context, server *database.MinecraftServer, users []*database.User, removed []string) (string, error) {
+	namespace := cmp.Or(server.Namespace, s.namespace)
+	deployment, err := GetDeployment(ctx, namespace, server.DeploymentName)
+	if err != nil || deployment == nil {
+		return "", err
+	}
+	pod, err := GetMinecraftPod(ctx, namespace, server.DeploymentName)
+	if err != nil || pod == nil || pod.Status.Phase != corev1.PodRunning {
+		return "", err
+	}
+
+	db := database.GetDB()
+	members, err := db.ListServerMembers(ctx, server.ID)
+	if err != nil {
+		return pod.Name, err
+	}
+	memberPermissions := map[int64]int64{}
+	for _, member := range members {
+		memberPermissions[member.UserID] = member.Permissions
+	}
+	if server.OrgID != 0 {
+		orgMembers, err := db.ListOrganizationMembers(ctx, server.OrgID)
+		if err != nil {
+			return pod.Name, err
+		}
+		for _, member := range orgMembers {
+			memberPermissions[member.UserID] |= database.OrgRolePermissions[member.Role]
+		}
+	}
+
+	// Player names are case insensitive
+	wanted := map[string]bool{}
+	names := map[string]string{}
+	for _, name := range removed {
+		wanted[strings.ToLower(name)] = false
+		names[strings.ToLower(name)] = name
+	}
+	for _, user := range users {
+		if user.MinecraftName == "" {
+			continue
+		}
+		key := strings.ToLower(user.MinecraftName)
+		wanted[key] = user.Active && (user.HasServerPermission(server.OwnerID, database.PermViewServer) ||
+			memberPermissions[user.ID]&database.PermViewServer != 0)
+		names[key] = user.MinecraftName
+	}
+
+	applied := s.applied[pod.Name]
+	if applied == nil {
+		applied = map[string]bool{}
+		s.applied[pod.Name] = applied
+	}
+	for key, whitelisted := range wanted {
+		if state, ok := applied[key]; ok && state == whitelisted {
+			continue
+		}
+		command := "whitelist remove " + names[key]
+		if whitelisted {
+			command = "whitelist add " + names[key]
+		}
+		if _, _, err := SendConsoleCommand(ctx, namespace, deployment, pod, command); err != nil {
+			return pod.Name, fmt.Errorf("failed to update whitelist for %s: %w", names[key], err)
+		}
+		applied[key] = whitelisted
+
+		logging.K8s.WithContext(ctx).WithFields(
+			"server_name", server.ServerName,
+			"minecraft_name", names[key],
+			"whitelisted", whitelisted,
+		).Info("Server whitelist synced")
+	}
+	return pod.Name, nil
+}
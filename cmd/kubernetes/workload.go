@@ -0,0 +1,208 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"minecharts/cmd/config"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Workload is the kind of object running the pod of a server.
+type Workload string
+
+const (
+	DeploymentWorkload  Workload = "deployment"
+	StatefulSetWorkload Workload = "statefulset" // A StatefulSet binding the server to its claim, with config.StatefulSets
+)
+
+// WorkloadAnnotation marks the StatefulSets of the servers, and the deployment views
+// of them, so that their updates go back to the StatefulSet.
+const WorkloadAnnotation = "minecharts.io/workload"
+
+// statefulClaimTemplate names the volume claim template of the StatefulSets, after the
+// volume of the deployments.
+const statefulClaimTemplate = "minecraft-storage"
+
+// StatefulClaimName returns the name of the claim of the pod of a StatefulSet server,
+// which the API creates and populates before the StatefulSet adopts it.
+func StatefulClaimName(deploymentName string) string {
+	return statefulClaimTemplate + "-" + deploymentName + "-0"
+}
+
+// IsStatefulSet tells whether a deployment returned by this package is the view of
+// a StatefulSet.
+func IsStatefulSet(deployment *appsv1.Deployment) bool {
+	return deployment != nil && deployment.Annotations[WorkloadAnnotation] == string(StatefulSetWorkload)
+}
+
+// getWorkload returns the deployment of a server, or the deployment view of its
+// StatefulSet, with the NotFound error of the deployment when it has neither.
+func getWorkload(ctx context.Context, namespace, deploymentName string) (*appsv1.Deployment, error) {
+	deployment, err := Clientset.AppsV1().Deployments(namespace).Get(ctx, deploymentName, metav1.GetOptions{})
+	if !config.StatefulSets || !apierrors.IsNotFound(err) {
+		return deployment, err
+	}
+	statefulSet, stsErr := Clientset.AppsV1().StatefulSets(namespace).Get(ctx, deploymentName, metav1.GetOptions{})
+	if apierrors.IsNotFound(stsErr) {
+		return nil, err
+	}
+	if stsErr != nil {
+		return nil, stsErr
+	}
+	return deploymentView(statefulSet), nil
+}
+
+// updateWorkload writes the labels, annotations, replicas and pod template of a
+// deployment returned by getWorkload, to the StatefulSet it is the view of if any.
+func updateWorkload(ctx context.Context, namespace string, deployment *appsv1.Deployment) (*appsv1.Deployment, error) {
+	annotateChange(ctx, deployment)
+	if !IsStatefulSet(deployment) {
+		return Clientset.AppsV1().Deployments(namespace).Update(ctx, deployment, metav1.UpdateOptions{FieldManager: FieldManager})
+	}
+
+	statefulSets := Clientset.AppsV1().StatefulSets(namespace)
+	statefulSet, err := statefulSets.Get(ctx, deployment.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	statefulSet.ResourceVersion = deployment.ResourceVersion
+	statefulSet.Labels = deployment.Labels
+	statefulSet.Annotations = deployment.Annotations
+	statefulSet.Spec.Replicas = deployment.Spec.Replicas
+	statefulSet.Spec.Template = deployment.Spec.Template
+	updated, err := statefulSets.Update(ctx, statefulSet, metav1.UpdateOptions{FieldManager: FieldManager})
+	if err != nil {
+		return nil, err
+	}
+	return deploymentView(updated), nil
+}
+
+// listWorkloads lists the deployments created by the API in a namespace, with the
+// deployment views of its StatefulSets.
+func listWorkloads(ctx context.Context, namespace string) ([]*appsv1.Deployment, error) {
+	options := metav1.ListOptions{LabelSelector: managedSelector}
+	deployments, err := Clientset.AppsV1().Deployments(namespace).List(ctx, options)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list deployments: %w", err)
+	}
+	workloads := make([]*appsv1.Deployment, 0, len(deployments.Items))
+	for i := range deployments.Items {
+		workloads = append(workloads, &deployments.Items[i])
+	}
+	if !config.StatefulSets {
+		return workloads, nil
+	}
+
+	statefulSets, err := Clientset.AppsV1().StatefulSets(namespace).List(ctx, options)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list statefulsets: %w", err)
+	}
+	for i := range statefulSets.Items {
+		workloads = append(workloads, deploymentView(&statefulSets.Items[i]))
+	}
+	return workloads, nil
+}
+
+// deploymentView returns a StatefulSet as a deployment, so that the code reading the
+// replicas, the pod template and the readiness of the servers handles both.
+func deploymentView(statefulSet *appsv1.StatefulSet) *appsv1.Deployment {
+	view := &appsv1.Deployment{
+		ObjectMeta: *statefulSet.ObjectMeta.DeepCopy(),
+		Spec: appsv1.DeploymentSpec{
+			Replicas: statefulSet.Spec.Replicas,
+			Selector: statefulSet.Spec.Selector,
+			Template: *statefulSet.Spec.Template.DeepCopy(),
+		},
+		Status: appsv1.DeploymentStatus{
+			ObservedGeneration: statefulSet.Status.ObservedGeneration,
+			Replicas:           statefulSet.Status.Replicas,
+			UpdatedReplicas:    statefulSet.Status.UpdatedReplicas,
+			ReadyReplicas:      statefulSet.Status.ReadyReplicas,
+			AvailableReplicas:  statefulSet.Status.AvailableReplicas,
+		},
+	}
+	if view.Annotations == nil {
+		view.Annotations = map[string]string{}
+	}
+	view.Annotations[WorkloadAnnotation] = string(StatefulSetWorkload)
+	return view
+}
+
+// createStatefulSet creates the StatefulSet running the pod of a deployment, on the
+// PVC created for it by the storage driver.
+func createStatefulSet(ctx context.Context, namespace string, deployment *appsv1.Deployment, pvcName string) error {
+	if pvcName != StatefulClaimName(deployment.Name) {
+		return fmt.Errorf("the PVC of a StatefulSet must be named %s", StatefulClaimName(deployment.Name))
+	}
+	claim, err := Clientset.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, pvcName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return fmt.Errorf("StatefulSets need a storage driver using PVCs, the %s driver has none", Storage.Name())
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get PVC: %w", err)
+	}
+	_, err = Clientset.AppsV1().StatefulSets(namespace).Create(ctx, statefulSetFrom(deployment, claim), metav1.CreateOptions{FieldManager: FieldManager})
+	return err
+}
+
+// statefulSetFrom returns the StatefulSet running the pod of a deployment instead,
+// with a claim template copied from the claim created by the storage driver, which
+// the StatefulSet adopts as it has the name of the claim of its pod.
+func statefulSetFrom(deployment *appsv1.Deployment, claim *corev1.PersistentVolumeClaim) *appsv1.StatefulSet {
+	statefulSet := &appsv1.StatefulSet{
+		ObjectMeta: *deployment.ObjectMeta.DeepCopy(),
+		Spec: appsv1.StatefulSetSpec{
+			Replicas: deployment.Spec.Replicas,
+			Selector: deployment.Spec.Selector,
+			Template: *deployment.Spec.Template.DeepCopy(),
+			// No headless service, the pod is reached through the service of the server
+			ServiceName: deployment.Name + "-svc",
+			// A single pod, stopped before the next one starts like the Recreate strategy
+			PodManagementPolicy: appsv1.ParallelPodManagement,
+			UpdateStrategy:      appsv1.StatefulSetUpdateStrategy{Type: appsv1.RollingUpdateStatefulSetStrategyType},
+			VolumeClaimTemplates: []corev1.PersistentVolumeClaim{
+				{
+					ObjectMeta: metav1.ObjectMeta{Name: statefulClaimTemplate, Labels: claim.Labels, Annotations: claim.Annotations},
+					Spec: corev1.PersistentVolumeClaimSpec{
+						AccessModes:      claim.Spec.AccessModes,
+						Resources:        claim.Spec.Resources,
+						StorageClassName: claim.Spec.StorageClassName,
+					},
+				},
+			},
+			// The API deletes the storage with the server, or keeps it when asked to
+			PersistentVolumeClaimRetentionPolicy: &appsv1.StatefulSetPersistentVolumeClaimRetentionPolicy{
+				WhenDeleted: appsv1.RetainPersistentVolumeClaimRetentionPolicyType,
+				WhenScaled:  appsv1.RetainPersistentVolumeClaimRetentionPolicyType,
+			},
+		},
+	}
+	if statefulSet.Annotations == nil {
+		statefulSet.Annotations = map[string]string{}
+	}
+	statefulSet.Annotations[WorkloadAnnotation] = string(StatefulSetWorkload)
+
+	// The claim template replaces the volume of the deployment
+	volumes := statefulSet.Spec.Template.Spec.Volumes[:0]
+	for _, volume := range statefulSet.Spec.Template.Spec.Volumes {
+		if volume.Name != statefulClaimTemplate {
+			volumes = append(volumes, volume)
+		}
+	}
+	statefulSet.Spec.Template.Spec.Volumes = volumes
+	return statefulSet
+}
+
+// pvcDeployment returns the deployment a PVC was created for, from its name.
+func pvcDeployment(pvcName string) string {
+	if name, ok := strings.CutPrefix(pvcName, statefulClaimTemplate+"-"); ok && strings.HasSuffix(name, "-0") {
+		return strings.TrimSuffix(name, "-0")
+	}
+	return strings.TrimSuffix(pvcName, config.PVCSuffix)
+}
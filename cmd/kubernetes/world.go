@@ -0,0 +1,453 @@
+package kubernetes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"minecharts/cmd/config"
+	"minecharts/cmd/logging"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/exec"
+	"k8s.io/utils/ptr"
+)
+
+// World archive formats accepted by ImportWorld.
+const (
+	WorldArchiveZip   = "zip"
+	WorldArchiveTar   = "tar"
+	WorldArchiveTarGz = "tar.gz"
+)
+
+var (
+	// ErrInvalidWorld is returned when an imported archive holds no world.
+	ErrInvalidWorld = errors.New("no level.dat found in the archive")
+	// ErrWorldImportRunning is returned while another import runs for the server.
+	ErrWorldImportRunning = errors.New("a world import is already running for this server")
+	// ErrWorldNotFound is returned when an exported server has no world yet.
+	ErrWorldNotFound = errors.New("the server has no world yet")
+	// ErrWorldExportRunning is returned while another export runs for the stopped server.
+	ErrWorldExportRunning = errors.New("a world export is already running for this server")
+	// ErrServerStarting is returned when the files of a server are accessed while its pod starts.
+	ErrServerStarting = errors.New("the server is starting, retry once it runs")
+)
+
+// worldImportDir is where the archive is uploaded and extracted on the server volume.
+const worldImportDir = "/data/.world-import"
+
+// noWorldExitCode is the exit code of the world scripts when there is no world to
+// import or export.
+const noWorldExitCode = 3
+
+// levelNamePattern matches the level names that are safe to use in the world scripts.
+var levelNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// ValidWorldName reports whether a name can be the level name of a world.
+func ValidWorldName(name string) bool {
+	return levelNamePattern.MatchString(name)
+}
+
+// WorldImportPodName returns the name of the pod importing a world into a server's volume.
+func WorldImportPodName(deploymentName string) string {
+	return deploymentName + "-world-import"
+}
+
+// WorldExportPodName returns the name of the pod exporting the world of a stopped server.
+func WorldExportPodName(deploymentName string) string {
+	return deploymentName + "-world-export"
+}
+
+// ImportWorld replaces the world of a stopped server, the directory of the given level
+// name at the root of its volume, with the one of an archive in the given format. The
+// archive is streamed to a helper pod mounting the volume and extracted there. The world
+// is the directory holding the shallowest level.dat of the archive, and ErrInvalidWorld
+// is returned without changing anything when there is none.
+//
+// The replaced world is moved into the backups directory; its path in the server
+// container is returned, or an empty string when the server had no world yet.
+func ImportWorld(ctx context.Context, namespace, deploymentName, volume, level, format string, archive io.Reader) (string, error) {
+	if !levelNamePattern.MatchString(level) {
+		return "", fmt.Errorf("invalid level name %q", level)
+	}
+	extract, err := extractCommand(format)
+	if err != nil {
+		return "", err
+	}
+
+	if err := waitForServerStopped(ctx, namespace, deploymentName); err != nil {
+		return "", err
+	}
+
+	podName := WorldImportPodName(deploymentName)
+	logging.K8s.WithContext(ctx).WithFields(
+		"namespace", namespace,
+		"deployment_name", deploymentName,
+		"pod_name", podName,
+		"level", level,
+		"format", format,
+	).Info("Importing world into server volume")
+
+	err = createVolumePod(ctx, namespace, deploymentName, podName, "world-import", volume, config.WorldUploadTimeout)
+	if apierrors.IsAlreadyExists(err) {
+		return "", ErrWorldImportRunning
+	}
+	if err != nil {
+		return "", err
+	}
+	defer deleteVolumePod(ctx, namespace, podName)
+
+	if err := waitForPodRunning(ctx, namespace, podName); err != nil {
+		return "", err
+	}
+
+	previous, err := extractWorld(ctx, namespace, podName, "world-import", worldImportDir, level, extract, archive)
+	if err != nil {
+		return "", err
+	}
+
+	logging.K8s.WithContext(ctx).WithFields(
+		"namespace", namespace,
+		"deployment_name", deploymentName,
+		"level", level,
+		"previous", previous,
+	).Info("World imported into server volume")
+	return previous, nil
+}
+
+// AddWorld imports a world next to the one a server plays, as the directory of the
+// given level name at the root of its volume, from an archive in the given format. A
+// running server is left running, the archive being extracted by its own container.
+// The world is found and a replaced one kept as with ImportWorld.
+func AddWorld(ctx context.Context, namespace, deploymentName, volume, level, format string, archive io.Reader) (string, error) {
+	if !levelNamePattern.MatchString(level) {
+		return "", fmt.Errorf("invalid level name %q", level)
+	}
+	extract, err := extractCommand(format)
+	if err != nil {
+		return "", err
+	}
+
+	logging.K8s.WithContext(ctx).WithFields(
+		"namespace", namespace,
+		"deployment_name", deploymentName,
+		"level", level,
+		"format", format,
+	).Info("Adding world to server volume")
+
+	var previous string
+	err = onServerVolume(ctx, namespace, deploymentName, volume, config.WorldUploadTimeout, func(podName, containerName string) error {
+		// Each world has its own directory, several can be uploaded at once
+		previous, err = extractWorld(ctx, namespace, podName, containerName, worldImportDir+"-"+level, level, extract, archive)
+		return err
+	})
+	if errors.Is(err, ErrServerFilesBusy) {
+		return "", ErrWorldImportRunning
+	}
+	if err != nil {
+		return "", err
+	}
+
+	logging.K8s.WithContext(ctx).WithFields(
+		"namespace", namespace,
+		"deployment_name", deploymentName,
+		"level", level,
+		"previous", previous,
+	).Info("World added to server volume")
+	return previous, nil
+}
+
+// ListWorlds returns the level names of the worlds of a server's volume, the
+// directories at its root holding a level.dat, without the nether and end dimensions
+// some server types keep apart.
+func ListWorlds(ctx context.Context, namespace, deploymentName, volume string) ([]string, error) {
+	var worlds []string
+	err := onServerVolume(ctx, namespace, deploymentName, volume, config.ExecTimeout, func(podName, containerName string) error {
+		stdout, stderr, err := streamToPod(ctx, podName, namespace, containerName, []string{"/bin/sh", "-c", listWorldsScript}, nil)
+		if err != nil {
+			return fmt.Errorf("failed to list worlds: %w", joinExecError(err, stderr))
+		}
+		names := map[string]bool{}
+		for _, name := range strings.Split(stdout, "\n") {
+			if levelNamePattern.MatchString(name) {
+				names[name] = true
+			}
+		}
+		for name := range names {
+			dimension := strings.TrimSuffix(strings.TrimSuffix(name, "_nether"), "_the_end")
+			if dimension == name || !names[dimension] {
+				worlds = append(worlds, name)
+			}
+		}
+		return nil
+	})
+	sort.Strings(worlds)
+	return worlds, err
+}
+
+// listWorldsScript prints the directories of the volume holding a level.dat, one per line.
+const listWorldsScript = `cd /data && for dir in */; do [ -f "$dir/level.dat" ] && echo "${dir%/}"; done; true`
+
+// extractCommand returns the command extracting an uploaded archive of the format.
+func extractCommand(format string) (string, error) {
+	switch format {
+	case WorldArchiveZip:
+		return "unzip -q upload -d extract", nil
+	case WorldArchiveTar:
+		return "tar -xf upload -C extract", nil
+	case WorldArchiveTarGz:
+		return "tar -xzf upload -C extract", nil
+	}
+	return "", fmt.Errorf("unsupported archive format %q", format)
+}
+
+// extractWorld uploads a world archive to dir in a pod container mounting a server
+// volume, and moves the world it holds to the directory of the level, keeping the
+// replaced one in the backups directory. It returns the path of the replaced world,
+// or an empty string when there was none.
+func extractWorld(ctx context.Context, namespace, podName, containerName, dir, level, extract string, archive io.Reader) (string, error) {
+	upload := fmt.Sprintf("rm -rf %[1]s && mkdir -p %[1]s && cat > %[1]s/upload", dir)
+	if _, stderr, err := streamToPod(ctx, podName, namespace, containerName, []string{"/bin/sh", "-c", upload}, archive); err != nil {
+		return "", fmt.Errorf("failed to upload archive: %w", joinExecError(err, stderr))
+	}
+
+	previous := fmt.Sprintf("%s/%s-%s", BackupDir, level, time.Now().UTC().Format("20060102-150405"))
+	script := strings.Join([]string{
+		"set -e",
+		fmt.Sprintf("trap 'rm -rf %s' EXIT", dir),
+		"cd " + dir,
+		"mkdir extract",
+		extract,
+		`dat=""`,
+		`for depth in 1 2 3 4 5; do dat=$(find extract -mindepth $depth -maxdepth $depth -type f -name level.dat | head -n 1); [ -z "$dat" ] || break; done`,
+		fmt.Sprintf(`[ -n "$dat" ] || { echo "%s" >&2; exit %d; }`, ErrInvalidWorld, noWorldExitCode),
+		// The files belong to the user the server runs as
+		`chown -R "$(stat -c %u:%g /data)" "$(dirname "$dat")"`,
+		fmt.Sprintf(`if [ -e /data/%s ]; then mkdir -p %s && mv /data/%s %s && echo replaced; fi`, level, BackupDir, level, previous),
+		fmt.Sprintf(`mv "$(dirname "$dat")" /data/%s`, level),
+	}, "\n")
+	stdout, stderr, err := streamToPod(ctx, podName, namespace, containerName, []string{"/bin/sh", "-c", script}, nil)
+	if err != nil {
+		var exitErr exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitStatus() == noWorldExitCode {
+			return "", ErrInvalidWorld
+		}
+		return "", fmt.Errorf("failed to extract archive: %w", joinExecError(err, stderr))
+	}
+	if !strings.Contains(stdout, "replaced") {
+		previous = ""
+	}
+	return previous, nil
+}
+
+// ExportWorld writes a tar.gz archive of the world of a server to w as it is packaged:
+// the directories of the given level name and of its nether and end dimensions, at the
+// root of its volume. A running server packages its world itself, with automatic saves
+// paused meanwhile so the files do not change; the volume of a stopped server is mounted
+// by a helper pod instead. ErrWorldNotFound is returned, with nothing written, when the
+// server has no world.
+func ExportWorld(ctx context.Context, namespace, deploymentName, volume, level string, w io.Writer) error {
+	if !levelNamePattern.MatchString(level) {
+		return fmt.Errorf("invalid level name %q", level)
+	}
+
+	pod, err := GetMinecraftPod(ctx, namespace, deploymentName)
+	if err != nil {
+		return fmt.Errorf("failed to get server pod: %w", err)
+	}
+
+	logging.K8s.WithContext(ctx).WithFields(
+		"namespace", namespace,
+		"deployment_name", deploymentName,
+		"level", level,
+		"running", pod != nil,
+	).Info("Exporting server world")
+
+	var podName, containerName string
+	switch {
+	case pod == nil:
+		podName, containerName = WorldExportPodName(deploymentName), "world-export"
+		err := createVolumePod(ctx, namespace, deploymentName, podName, containerName, volume, config.WorldExportTimeout)
+		if apierrors.IsAlreadyExists(err) {
+			return ErrWorldExportRunning
+		}
+		if err != nil {
+			return err
+		}
+		defer deleteVolumePod(ctx, namespace, podName)
+
+		if err := waitForPodRunning(ctx, namespace, podName); err != nil {
+			return err
+		}
+	case pod.Status.Phase == corev1.PodRunning:
+		podName, containerName = pod.Name, "minecraft-server"
+		if _, _, err := ExecuteCommandInPod(ctx, podName, namespace, containerName, "mc-send-to-console save-off"); err != nil {
+			return fmt.Errorf("failed to pause automatic saves: %w", err)
+		}
+		defer func() {
+			if _, _, err := ExecuteCommandInPod(context.WithoutCancel(ctx), podName, namespace, containerName, "mc-send-to-console save-on"); err != nil {
+				logging.K8s.WithContext(ctx).WithFields(
+					"namespace", namespace,
+					"pod_name", podName,
+					"error", err.Error(),
+				).Error("Failed to resume automatic saves after world export")
+			}
+		}()
+		if _, _, err := SaveWorld(ctx, podName, namespace); err != nil {
+			return fmt.Errorf("failed to save world: %w", err)
+		}
+	default:
+		return ErrServerStarting
+	}
+
+	if config.DevMode {
+		return writeDevWorldArchive(w, level)
+	}
+
+	script := strings.Join([]string{
+		"cd /data",
+		"set --",
+		fmt.Sprintf(`for dir in %[1]s %[1]s_nether %[1]s_the_end; do [ -d "$dir" ] && set -- "$@" "$dir"; done`, level),
+		fmt.Sprintf(`[ $# -gt 0 ] || { echo "%s" >&2; exit %d; }`, ErrWorldNotFound, noWorldExitCode),
+		`exec tar -czf - "$@"`,
+	}, "\n")
+	stderr, err := streamPod(ctx, podName, namespace, containerName, []string{"/bin/sh", "-c", script}, nil, w)
+	if err != nil {
+		var exitErr exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitStatus() == noWorldExitCode {
+			return ErrWorldNotFound
+		}
+		return fmt.Errorf("failed to package world: %w", joinExecError(err, stderr))
+	}
+
+	logging.K8s.WithContext(ctx).WithFields(
+		"namespace", namespace,
+		"deployment_name", deploymentName,
+		"level", level,
+	).Info("Server world exported")
+	return nil
+}
+
+// createVolumePod creates a helper pod mounting a server's volume to work on its files,
+// such as its world, for at most deadline. The role, such as world-import, names its
+// container and labels the pod.
+func createVolumePod(ctx context.Context, namespace, deploymentName, podName, role, volume string, deadline time.Duration) error {
+	seconds := int64(deadline.Seconds())
+	pod := &corev1.Pod{
+		// No app label: the pod must not be taken for a server pod
+		ObjectMeta: metav1.ObjectMeta{
+			Name: podName,
+			Labels: map[string]string{
+				"created-by": "minecharts-api",
+				role:         deploymentName,
+			},
+		},
+		Spec: corev1.PodSpec{
+			RestartPolicy:         corev1.RestartPolicyNever,
+			ActiveDeadlineSeconds: &seconds,
+			Containers: []corev1.Container{
+				{
+					Name:    role,
+					Image:   config.CloneImage,
+					Command: []string{"sleep", fmt.Sprint(seconds)},
+					VolumeMounts: []corev1.VolumeMount{
+						{Name: "minecraft-storage", MountPath: "/data"},
+					},
+				},
+			},
+			Volumes: []corev1.Volume{
+				{Name: "minecraft-storage", VolumeSource: Storage.VolumeSource(volume)},
+			},
+		},
+	}
+	Storage.ConfigurePod(&pod.Spec)
+
+	annotateChange(ctx, pod)
+	if _, err := Clientset.CoreV1().Pods(namespace).Create(ctx, pod, metav1.CreateOptions{FieldManager: FieldManager}); err != nil {
+		logging.K8s.WithContext(ctx).WithFields(
+			"namespace", namespace,
+			"pod_name", podName,
+			"error", err.Error(),
+		).Error("Failed to create " + role + " pod")
+		return fmt.Errorf("failed to create %s pod: %w", role, err)
+	}
+	return nil
+}
+
+// deleteVolumePod deletes a helper pod once its work is over, even when the request
+// was cancelled.
+func deleteVolumePod(ctx context.Context, namespace, podName string) {
+	err := Clientset.CoreV1().Pods(namespace).Delete(context.WithoutCancel(ctx), podName, metav1.DeleteOptions{
+		GracePeriodSeconds: ptr.To(int64(0)),
+	})
+	if err != nil && !apierrors.IsNotFound(err) {
+		logging.K8s.WithContext(ctx).WithFields(
+			"namespace", namespace,
+			"pod_name", podName,
+			"error", err.Error(),
+		).Warn("Failed to delete volume pod")
+	}
+}
+
+// waitForServerStopped waits until the pod of a server scaled to 0 is gone, so that
+// its volume is free.
+func waitForServerStopped(ctx context.Context, namespace, deploymentName string) error {
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
+	for {
+		pod, err := GetMinecraftPod(ctx, namespace, deploymentName)
+		if err != nil {
+			return fmt.Errorf("failed to get server pod: %w", err)
+		}
+		if pod == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("server pod still running: %w", ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// waitForPodRunning waits until a pod runs. The pods of development mode are never
+// scheduled, they are taken as running.
+func waitForPodRunning(ctx context.Context, namespace, podName string) error {
+	if config.DevMode {
+		return nil
+	}
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
+	for {
+		pod, err := Clientset.CoreV1().Pods(namespace).Get(ctx, podName, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to get pod: %w", err)
+		}
+		switch pod.Status.Phase {
+		case corev1.PodRunning:
+			return nil
+		case corev1.PodFailed, corev1.PodSucceeded:
+			return fmt.Errorf("pod %s exited: %s", podName, joinReason(pod.Status.Reason, pod.Status.Message, string(pod.Status.Phase)))
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("pod %s not running: %w", podName, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// joinExecError adds the error output of a command to its error.
+func joinExecError(err error, stderr string) error {
+	if stderr = strings.TrimSpace(stderr); stderr != "" {
+		return fmt.Errorf("%w: %s", err, stderr)
+	}
+	return err
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// scenario is a request benchmarked against the API. request builds the i-th request.
+type scenario struct {
+	name    string
+	request func(i int) *http.Request
+}
+
+// result summarizes the run of a scenario.
+type result struct {
+	name      string
+	requests  int
+	errors    int64
+	elapsed   time.Duration
+	latencies []time.Duration
+}
+
+// run sends requests to the router from concurrency workers, without going through
+// the network, so the numbers measure the middlewares, handlers and stores.
+func run(handler http.Handler, s scenario, requests, concurrency int) result {
+	latencies := make([]time.Duration, requests)
+	var next, errors int64
+	var wg sync.WaitGroup
+
+	start := time.Now()
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				i := int(atomic.AddInt64(&next, 1) - 1)
+				if i >= requests {
+					return
+				}
+				req := s.request(i)
+				recorder := httptest.NewRecorder()
+				sent := time.Now()
+				handler.ServeHTTP(recorder, req)
+				latencies[i] = time.Since(sent)
+				if recorder.Code >= 400 {
+					atomic.AddInt64(&errors, 1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	return result{
+		name:      s.name,
+		requests:  requests,
+		errors:    errors,
+		elapsed:   time.Since(start),
+		latencies: latencies,
+	}
+}
+
+func (r result) percentile(p float64) time.Duration {
+	sorted := append([]time.Duration(nil), r.latencies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted[int(float64(len(sorted)-1)*p)]
+}
+
+func (r result) String() string {
+	return fmt.Sprintf("%-16s %8d %7d %10.0f %10s %10s %10s",
+		r.name,
+		r.requests,
+		r.errors,
+		float64(r.requests)/r.elapsed.Seconds(),
+		r.percentile(0.50).Round(time.Microsecond),
+		r.percentile(0.95).Round(time.Microsecond),
+		r.percentile(0.99).Round(time.Microsecond),
+	)
+}
+
+const resultHeader = "scenario         requests  errors      req/s        p50        p95        p99"
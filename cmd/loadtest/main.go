@@ -0,0 +1,179 @@
+// Command loadtest measures the throughput of the hot endpoints of the API.
+//
+// It runs the API in-process in development mode, seeds the database and the fake
+// cluster with the given number of users and servers, then sends requests to the
+// router from concurrent workers and reports the rate and latency percentiles of
+// each scenario:
+//
+//	go run ./cmd/loadtest -users 100 -servers 500 -requests 20000 -concurrency 32
+//
+// The fake cluster answers without network round trips, so the numbers isolate the
+// cost of the middlewares, handlers, database and caches from the API server's.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"minecharts/cmd/api"
+	"minecharts/cmd/config"
+	"minecharts/cmd/database"
+	"minecharts/cmd/devmode"
+	"minecharts/cmd/kubernetes"
+	"minecharts/cmd/logging"
+
+	"github.com/gin-gonic/gin"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var (
+	users       = flag.Int("users", 50, "number of users to seed")
+	servers     = flag.Int("servers", 200, "number of servers to seed")
+	requests    = flag.Int("requests", 10000, "requests sent per scenario")
+	concurrency = flag.Int("concurrency", 16, "number of concurrent workers")
+	scenarios   = flag.String("scenarios", "ping,auth,status,list-users,list-templates", "comma separated scenarios to run")
+	logLevel    = flag.String("log-level", "error", "log level of the API while benchmarking")
+)
+
+func main() {
+	flag.Parse()
+	if *users < 1 || *servers < 1 || *requests < 1 || *concurrency < 1 {
+		fmt.Fprintln(os.Stderr, "users, servers, requests and concurrency must be positive")
+		os.Exit(2)
+	}
+
+	config.DevMode = true
+	c := config.Current()
+	c.LogLevel = *logLevel
+	config.Apply(c)
+	logging.Init()
+
+	handler, data, err := setup(context.Background())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "loadtest: %v\n", err)
+		os.Exit(1)
+	}
+	defer database.GetDB().Close()
+
+	available := map[string]scenario{
+		"ping": {"ping", func(int) *http.Request {
+			return newRequest("/ping", "")
+		}},
+		// JWT validation and the user lookup of the auth middleware
+		"auth": {"auth", func(i int) *http.Request {
+			return newRequest("/auth/me", data.userTokens[i%len(data.userTokens)])
+		}},
+		// Server permission check, deployment and pod lookups
+		"status": {"status", func(i int) *http.Request {
+			return newRequest("/servers/"+data.servers[i%len(data.servers)]+"/status", data.adminToken)
+		}},
+		"list-users": {"list-users", func(int) *http.Request {
+			return newRequest("/users", data.adminToken)
+		}},
+		"list-templates": {"list-templates", func(i int) *http.Request {
+			return newRequest("/templates", data.userTokens[i%len(data.userTokens)])
+		}},
+	}
+
+	fmt.Printf("%d users, %d servers, %d requests per scenario, %d workers\n\n", *users, *servers, *requests, *concurrency)
+	fmt.Println(resultHeader)
+	failed := false
+	for _, name := range strings.Split(*scenarios, ",") {
+		s, ok := available[strings.TrimSpace(name)]
+		if !ok {
+			fmt.Fprintf(os.Stderr, "unknown scenario %q\n", name)
+			failed = true
+			continue
+		}
+		r := run(handler, s, *requests, *concurrency)
+		fmt.Println(r)
+		failed = failed || r.errors > 0
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// setup starts the API in development mode on a database of its own and seeds it.
+func setup(ctx context.Context) (http.Handler, *seeded, error) {
+	if err := devmode.Prepare(); err != nil {
+		return nil, nil, err
+	}
+	path := filepath.Join(os.TempDir(), "minecharts-loadtest.db")
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, nil, err
+	}
+	config.DatabaseConnectionString = path
+
+	if err := kubernetes.Init(); err != nil {
+		return nil, nil, err
+	}
+	if err := database.InitDB(config.DatabaseType, config.DatabaseConnectionString, config.DatabaseReplicaConnectionString, database.PoolConfig{
+		MaxOpenConns:    config.DatabaseMaxOpenConns,
+		MaxIdleConns:    config.DatabaseMaxIdleConns,
+		ConnMaxLifetime: config.DatabaseConnMaxLifetime,
+	}); err != nil {
+		return nil, nil, err
+	}
+	if err := database.EnableCache(database.CacheConfig{
+		TTL:           config.CacheTTL,
+		RedisURL:      config.CacheRedisURL,
+		TouchInterval: config.APIKeyTouchInterval,
+	}); err != nil {
+		return nil, nil, err
+	}
+
+	start := time.Now()
+	data, err := seed(ctx, *users, *servers)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := waitForPods(ctx, len(data.servers)); err != nil {
+		return nil, nil, err
+	}
+	fmt.Printf("Seeded in %s\n", time.Since(start).Round(time.Millisecond))
+
+	// The background watchers run as in production
+	kubernetes.StartServerWatcher(ctx, config.DefaultNamespace, database.GetDB())
+	kubernetes.StartReconciler(ctx, config.DefaultNamespace, database.GetDB(), config.ReconcileInterval)
+
+	gin.SetMode(gin.ReleaseMode)
+	router := gin.New()
+	if err := api.SetupRoutes(router); err != nil {
+		return nil, nil, err
+	}
+	return router, data, nil
+}
+
+// waitForPods waits for the fake cluster to run a pod for every seeded server.
+func waitForPods(ctx context.Context, count int) error {
+	deadline := time.Now().Add(time.Minute)
+	for {
+		pods, err := kubernetes.Clientset.CoreV1().Pods(config.DefaultNamespace).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return err
+		}
+		if len(pods.Items) >= count {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("only %d of %d server pods started", len(pods.Items), count)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func newRequest(path, token string) *http.Request {
+	req, _ := http.NewRequest(http.MethodGet, path, nil)
+	req.RemoteAddr = "127.0.0.1:40000"
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return req
+}
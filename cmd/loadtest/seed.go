@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"minecharts/cmd/auth"
+	"minecharts/cmd/config"
+	"minecharts/cmd/database"
+	"minecharts/cmd/kubernetes"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// seeded holds what the benchmarks authenticate as and query.
+type seeded struct {
+	adminToken string
+	userTokens []string
+	servers    []string
+}
+
+// seed creates an administrator, the given number of operators and servers owned
+// by the operators in turn, with their deployments on the fake cluster.
+func seed(ctx context.Context, users, servers int) (*seeded, error) {
+	db := database.GetDB()
+
+	// Hashing is deliberately slow, every account shares one password
+	hash, err := auth.HashPassword("loadtest")
+	if err != nil {
+		return nil, err
+	}
+
+	result := &seeded{}
+	owners := make([]int64, 0, users)
+	for i := 0; i <= users; i++ {
+		permissions, username := database.PermOperator, fmt.Sprintf("user-%d", i)
+		if i == 0 {
+			permissions, username = database.PermAll, "admin"
+		}
+		user := &database.User{
+			Username:     username,
+			Email:        username + "@minecharts.local",
+			PasswordHash: hash,
+			Permissions:  permissions,
+			Active:       true,
+			CreatedAt:    time.Now(),
+			UpdatedAt:    time.Now(),
+		}
+		if err := db.CreateUser(ctx, user); err != nil {
+			return nil, fmt.Errorf("failed to create user %s: %w", username, err)
+		}
+		token, err := auth.GenerateJWT(user.ID, user.Username, user.Email, user.Permissions)
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			result.adminToken = token
+			continue
+		}
+		result.userTokens = append(result.userTokens, token)
+		owners = append(owners, user.ID)
+	}
+
+	for i := 0; i < servers; i++ {
+		name := fmt.Sprintf("server-%d", i)
+		deploymentName := config.DeploymentPrefix + name
+		ownerID := owners[i%len(owners)]
+
+		if err := db.CreateServerRecord(ctx, &database.MinecraftServer{
+			ServerName:     name,
+			DeploymentName: deploymentName,
+			PVCName:        deploymentName + config.PVCSuffix,
+			OwnerID:        ownerID,
+			Status:         database.ServerStatusRunning,
+			Spec:           database.ServerSpec{ServerType: "paper", Version: "1.21.4", Memory: "2G"},
+			CreatedAt:      time.Now(),
+			UpdatedAt:      time.Now(),
+		}); err != nil {
+			return nil, fmt.Errorf("failed to record server %s: %w", name, err)
+		}
+		envVars := []corev1.EnvVar{{Name: "EULA", Value: "TRUE"}}
+		if err := kubernetes.CreateDeployment(ctx, config.DefaultNamespace, deploymentName, deploymentName+config.PVCSuffix, kubernetes.JavaEdition, envVars, corev1.ResourceRequirements{}, nil, "", kubernetes.Placement{}, kubernetes.DeploymentWorkload); err != nil {
+			return nil, fmt.Errorf("failed to create server %s: %w", name, err)
+		}
+		result.servers = append(result.servers, name)
+	}
+
+	return result, nil
+}
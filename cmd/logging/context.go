@@ -0,0 +1,37 @@
+package logging
+
+import "context"
+
+// requestIDKey is the context key of the ID of the request a context serves.
+type requestIDKey struct{}
+
+// ContextWithRequestID returns a copy of ctx carrying the ID of its request, logged
+// by the domains and actions given the context.
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFromContext returns the ID of the request of ctx, or an empty string
+// outside of requests.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// WithContext returns a copy of the domain logging the request ID of ctx, when it
+// serves a request.
+func (d *LogDomain) WithContext(ctx context.Context) *LogDomain {
+	if id := RequestIDFromContext(ctx); id != "" {
+		return d.WithField("request_id", id)
+	}
+	return d
+}
+
+// WithContext returns a copy of the action logging the request ID of ctx, when it
+// serves a request.
+func (a *LogAction) WithContext(ctx context.Context) *LogAction {
+	if id := RequestIDFromContext(ctx); id != "" {
+		return a.WithFields("request_id", id)
+	}
+	return a
+}
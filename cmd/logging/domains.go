@@ -16,12 +16,18 @@ type AuthDomain struct {
 	Password *AuthPasswordDomain
 }
 
+// ServerDomain logs the lifecycle of the Minecraft servers. Each completed
+// operation is logged with its action, so it can be queried by action field.
 type ServerDomain struct {
 	*LogDomain
+	Created     *LogAction
 	Started     *LogAction
 	Stopped     *LogAction
 	Restarted   *LogAction
 	Deleted     *LogAction
+	Renamed     *LogAction
+	Cloned      *LogAction
+	Hibernated  *LogAction
 	CommandExec *LogAction
 }
 
@@ -33,6 +33,11 @@ func Init() {
 	// Set output to stdout
 	Logger.SetOutput(os.Stdout)
 
+	// Scrub secrets from every entry, including trace and debug levels. The API key
+	// prefix may come from the configuration file, loaded after the package variables
+	apiKeyPattern = newAPIKeyPattern()
+	Logger.AddHook(redactionHook{})
+
 	// Set log format
 	switch strings.ToLower(config.LogFormat) {
 	case "text":
@@ -57,17 +62,28 @@ func Init() {
 	}
 
 	// Set log level from configuration
-	level, err := logrus.ParseLevel(strings.ToLower(config.LogLevel))
+	logLevel := config.Live().LogLevel
+	level, err := logrus.ParseLevel(strings.ToLower(logLevel))
 	if err != nil {
 		// Default to info level if parsing fails
 		level = logrus.InfoLevel
-		Logger.Warnf("Invalid log level %s, using info level", config.LogLevel)
+		Logger.Warnf("Invalid log level %s, using info level", logLevel)
 	}
 	Logger.SetLevel(level)
 
 	Logger.Infof("Logger initialized with level: %s", level.String())
 }
 
+// SetLevel changes the level of the logs, such as on a configuration reload.
+func SetLevel(name string) error {
+	level, err := logrus.ParseLevel(strings.ToLower(name))
+	if err != nil {
+		return err
+	}
+	Logger.SetLevel(level)
+	return nil
+}
+
 // WithFields returns a new entry with the specified fields
 func WithFields(fields ...Field) *logrus.Entry {
 	logrusFields := logrus.Fields{}
@@ -139,9 +155,13 @@ func InitStructuredLogging() {
 	Server = &ServerDomain{
 		LogDomain: Domain("Server"),
 	}
+	Server.Created = Server.LogDomain.Action("Created")
 	Server.Started = Server.LogDomain.Action("Started")
 	Server.Stopped = Server.LogDomain.Action("Stopped")
 	Server.Restarted = Server.LogDomain.Action("Restarted")
 	Server.Deleted = Server.LogDomain.Action("Deleted")
+	Server.Renamed = Server.LogDomain.Action("Renamed")
+	Server.Cloned = Server.LogDomain.Action("Cloned")
+	Server.Hibernated = Server.LogDomain.Action("Hibernated")
 	Server.CommandExec = Server.LogDomain.Action("CommandExec")
 }
@@ -0,0 +1,135 @@
+package logging
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"minecharts/cmd/config"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Redacted replaces secret values in logs and API responses.
+const Redacted = "[REDACTED]"
+
+// sensitiveKeyParts marks a field or environment variable as secret when its
+// lowercased name contains one of them.
+var sensitiveKeyParts = []string{
+	"password", "passwd", "secret", "token", "authorization", "cookie",
+	"credential", "api_key", "apikey", "private_key",
+}
+
+// sensitiveKeys are field names that are secret on their own (OAuth parameters).
+var sensitiveKeys = map[string]bool{
+	"code":  true,
+	"state": true,
+}
+
+// metadataSuffixes mark fields describing a secret rather than holding it,
+// such as token_length or client_secret_set.
+var metadataSuffixes = []string{"_length", "_set", "_type", "_required", "_id"}
+
+var (
+	jwtPattern        = regexp.MustCompile(`eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`)
+	bearerPattern     = regexp.MustCompile(`(?i)(bearer\s+)[^\s"']+`)
+	queryParamPattern = regexp.MustCompile(`(?i)([?&](?:code|state|token|access_token|refresh_token|id_token|password|api_key)=)[^&\s"']+`)
+	apiKeyPattern     = newAPIKeyPattern()
+)
+
+// newAPIKeyPattern matches the API keys of the configured prefix.
+func newAPIKeyPattern() *regexp.Regexp {
+	return regexp.MustCompile(regexp.QuoteMeta(config.APIKeyPrefix) + `\.[A-Za-z0-9_=-]{16,}`)
+}
+
+// IsSensitiveKey reports whether a field or environment variable name denotes a secret.
+func IsSensitiveKey(key string) bool {
+	lower := strings.ToLower(key)
+	if sensitiveKeys[lower] {
+		return true
+	}
+	for _, suffix := range metadataSuffixes {
+		if strings.HasSuffix(lower, suffix) {
+			return false
+		}
+	}
+	for _, part := range sensitiveKeyParts {
+		if strings.Contains(lower, part) {
+			return true
+		}
+	}
+	return false
+}
+
+// Redact scrubs JWTs, bearer tokens, API keys and secret query parameters from a string.
+func Redact(s string) string {
+	if s == "" {
+		return s
+	}
+	s = jwtPattern.ReplaceAllString(s, Redacted)
+	s = apiKeyPattern.ReplaceAllString(s, Redacted)
+	s = bearerPattern.ReplaceAllString(s, "${1}"+Redacted)
+	s = queryParamPattern.ReplaceAllString(s, "${1}"+Redacted)
+	return s
+}
+
+// RedactField returns the value to log for a field: secret fields are replaced
+// entirely, other string values are scrubbed.
+func RedactField(key string, value interface{}) interface{} {
+	switch v := value.(type) {
+	case nil, bool, int, int32, int64, uint, uint32, uint64, float32, float64, time.Time, *time.Time:
+		return value
+	case string:
+		if IsSensitiveKey(key) && v != "" {
+			return Redacted
+		}
+		return Redact(v)
+	case error:
+		if IsSensitiveKey(key) {
+			return Redacted
+		}
+		return Redact(v.Error())
+	case fmt.Stringer:
+		if IsSensitiveKey(key) {
+			return Redacted
+		}
+		return Redact(v.String())
+	default:
+		if IsSensitiveKey(key) {
+			return Redacted
+		}
+		return value
+	}
+}
+
+// RedactEnv returns a copy of an environment map with secret values replaced.
+func RedactEnv(env map[string]string) map[string]string {
+	if env == nil {
+		return nil
+	}
+	redacted := make(map[string]string, len(env))
+	for key, value := range env {
+		if IsSensitiveKey(key) {
+			redacted[key] = Redacted
+		} else {
+			redacted[key] = value
+		}
+	}
+	return redacted
+}
+
+// redactionHook scrubs every entry before it is formatted, whatever its level.
+type redactionHook struct{}
+
+func (redactionHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (redactionHook) Fire(entry *logrus.Entry) error {
+	for key, value := range entry.Data {
+		entry.Data[key] = RedactField(key, value)
+	}
+	entry.Message = Redact(entry.Message)
+	return nil
+}
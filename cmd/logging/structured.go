@@ -2,6 +2,8 @@ package logging
 
 import (
 	"fmt"
+
+	"github.com/sirupsen/logrus"
 )
 
 // LogDomain represents a functional domain for logging
@@ -27,10 +29,17 @@ func Domain(name string) *LogDomain {
 	}
 }
 
-// WithField adds a default field to the domain
+// WithField returns a copy of the domain with an additional field
 func (d *LogDomain) WithField(key string, value interface{}) *LogDomain {
-	d.fields = append(d.fields, F(key, value))
-	return d
+	return &LogDomain{
+		name:   d.name,
+		fields: withFields(d.fields, F(key, value)),
+	}
+}
+
+// Name returns the name of the domain, with its parent domains for subdomains
+func (d *LogDomain) Name() string {
+	return d.name
 }
 
 // Action defines an action within a domain
@@ -41,6 +50,15 @@ func (d *LogDomain) Action(name string) *LogAction {
 	}
 }
 
+// Trace creates a Trace level logger for this domain
+func (d *LogDomain) Trace(msg string, args ...interface{}) {
+	entry := WithFields(d.fields...)
+	if len(args) > 0 {
+		msg = fmt.Sprintf(msg, args...)
+	}
+	entry.Trace(msg)
+}
+
 // Debug creates a Debug level logger for this domain
 func (d *LogDomain) Debug(msg string, args ...interface{}) {
 	entry := WithFields(d.fields...)
@@ -77,29 +95,34 @@ func (d *LogDomain) Error(msg string, args ...interface{}) {
 	entry.Error(msg)
 }
 
-// Add relevant methods for LogAction (Debug, Info, Warn, Error)
+// Trace logs at Trace level with the domain and action fields
+func (a *LogAction) Trace(args ...interface{}) {
+	a.entry().Trace(a.getMessage(args...))
+}
+
+// Debug logs at Debug level with the domain and action fields
 func (a *LogAction) Debug(args ...interface{}) {
-	fields := append(a.domain.fields, F("action", a.action))
-	entry := WithFields(fields...)
-	entry.Debug(a.getMessage(args...))
+	a.entry().Debug(a.getMessage(args...))
 }
 
+// Info logs at Info level with the domain and action fields
 func (a *LogAction) Info(args ...interface{}) {
-	fields := append(a.domain.fields, F("action", a.action))
-	entry := WithFields(fields...)
-	entry.Info(a.getMessage(args...))
+	a.entry().Info(a.getMessage(args...))
 }
 
+// Warn logs at Warning level with the domain and action fields
 func (a *LogAction) Warn(args ...interface{}) {
-	fields := append(a.domain.fields, F("action", a.action))
-	entry := WithFields(fields...)
-	entry.Warn(a.getMessage(args...))
+	a.entry().Warn(a.getMessage(args...))
 }
 
+// Error logs at Error level with the domain and action fields
 func (a *LogAction) Error(args ...interface{}) {
-	fields := append(a.domain.fields, F("action", a.action))
-	entry := WithFields(fields...)
-	entry.Error(a.getMessage(args...))
+	a.entry().Error(a.getMessage(args...))
+}
+
+// entry returns the log entry of the action, with its domain fields
+func (a *LogAction) entry() *logrus.Entry {
+	return WithFields(withFields(a.domain.fields, F("action", a.action))...)
 }
 
 // getMessage constructs the message based on the provided arguments
@@ -113,6 +136,11 @@ func (a *LogAction) getMessage(args ...interface{}) string {
 		return fmt.Sprintf("%s %s: %v", a.domain.name, a.action, err)
 	}
 
+	// A single message is logged as is, the domain and action are in the fields
+	if msg, ok := args[0].(string); ok && len(args) == 1 {
+		return msg
+	}
+
 	// If the first argument is a string and there is an error as the second argument
 	if msg, ok := args[0].(string); ok && len(args) > 1 {
 		if err, ok := args[1].(error); ok {
@@ -129,16 +157,16 @@ func (a *LogAction) getMessage(args ...interface{}) string {
 func (d *LogDomain) SubDomain(name string) *LogDomain {
 	return &LogDomain{
 		name:   d.name + "." + name,
-		fields: append(d.fields, F("subdomain", name)),
+		fields: withFields(d.fields, F("subdomain", name)),
 	}
 }
 
-// WithError adds an error to the fields of a LogAction
+// WithError returns a copy of the LogAction with the error in its fields
 func (a *LogAction) WithError(err error) *LogAction {
-	if err != nil {
-		a.domain.fields = append(a.domain.fields, F("error", err.Error()))
+	if err == nil {
+		return a
 	}
-	return a
+	return a.WithFields("error", err.Error())
 }
 
 // WithFields adds multiple fields without modifying the global instance
@@ -201,3 +229,11 @@ func (d *LogDomain) WithFields(keyvals ...interface{}) *LogDomain {
 
 	return newDomain
 }
+
+// withFields returns a new slice holding the fields followed by the extra ones, so
+// that domains shared by concurrent loggers are never appended to in place.
+func withFields(fields []Field, extra ...Field) []Field {
+	out := make([]Field, 0, len(fields)+len(extra))
+	out = append(out, fields...)
+	return append(out, extra...)
+}
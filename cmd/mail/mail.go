@@ -0,0 +1,63 @@
+// Package mail sends the emails of the API, such as the invitations, through the
+// SMTP server configured with MINECHARTS_SMTP_HOST. The connection is upgraded with
+// STARTTLS when the server offers it, and authenticated when MINECHARTS_SMTP_USERNAME
+// is set.
+package mail
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+
+	"minecharts/cmd/config"
+	"minecharts/cmd/logging"
+)
+
+// ErrNotConfigured is returned when sending an email without an SMTP server.
+var ErrNotConfigured = errors.New("no SMTP server configured")
+
+// Enabled reports whether an SMTP server is configured to send the emails.
+func Enabled() bool {
+	return config.SMTPHost != ""
+}
+
+// Send sends a plain text email to a single recipient.
+func Send(to, subject, body string) error {
+	if !Enabled() {
+		return ErrNotConfigured
+	}
+	// Line breaks in the headers would let them be forged
+	if strings.ContainsAny(to+subject, "\r\n") {
+		return fmt.Errorf("invalid email header")
+	}
+
+	var auth smtp.Auth
+	if config.SMTPUsername != "" {
+		auth = smtp.PlainAuth("", config.SMTPUsername, config.Live().SMTPPassword, config.SMTPHost)
+	}
+	message := "From: " + config.SMTPFrom + "\r\n" +
+		"To: " + to + "\r\n" +
+		"Subject: " + subject + "\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"\r\n" +
+		strings.ReplaceAll(body, "\n", "\r\n")
+
+	addr := net.JoinHostPort(config.SMTPHost, strconv.Itoa(config.SMTPPort))
+	if err := smtp.SendMail(addr, auth, config.SMTPFrom, []string{to}, []byte(message)); err != nil {
+		logging.API.WithFields(
+			"smtp_host", config.SMTPHost,
+			"error", err.Error(),
+		).Error("Failed to send email")
+		return fmt.Errorf("failed to send email: %w", err)
+	}
+
+	logging.API.WithFields(
+		"smtp_host", config.SMTPHost,
+		"subject", subject,
+	).Info("Email sent")
+	return nil
+}
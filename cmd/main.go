@@ -1,12 +1,32 @@
 package main
 
 import (
+	"cmp"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"minecharts/cmd/api"
+	"minecharts/cmd/api/middleware"
 	"minecharts/cmd/config"
 	"minecharts/cmd/database"
+	"minecharts/cmd/devmode"
 	_ "minecharts/cmd/docs" // Import swagger docs
+	"minecharts/cmd/errreport"
+	"minecharts/cmd/events"
+	"minecharts/cmd/jobs"
 	"minecharts/cmd/kubernetes"
 	"minecharts/cmd/logging"
+	"minecharts/cmd/ratelimit"
+	"minecharts/cmd/scheduler"
+	"minecharts/cmd/security"
+	"minecharts/cmd/shutdown"
+	"minecharts/cmd/tracing"
+	"minecharts/cmd/webhooks"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -37,21 +57,26 @@ import (
 // @description API Key for authentication.
 
 func main() {
+	configPath := flag.String("config", os.Getenv("MINECHARTS_CONFIG"), "path to a YAML or TOML configuration file, overridden by the environment")
+	flag.Parse()
+
+	// Load the configuration before anything reads it
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	config.Apply(cfg)
 
 	// Initialize logger
 	logging.Init()
 	logger := logging.Logger
-
-	// Initialize timezone
-	location, err := time.LoadLocation(config.TimeZone)
-	if err != nil {
-		logging.WithFields(
-			logging.F("timezone", config.TimeZone),
-			logging.F("error", err.Error()),
-		).Warn("Failed to load timezone, falling back to UTC")
-		location = time.UTC
+	if *configPath != "" {
+		logging.WithFields(logging.F("config_file", *configPath)).Info("Configuration file loaded")
 	}
 
+	// Initialize timezone, validated with the configuration
+	location, _ := time.LoadLocation(config.TimeZone)
 	time.Local = location
 
 	logging.WithFields(
@@ -61,12 +86,19 @@ func main() {
 	logger.Info("Starting Minecharts API server")
 
 	// Set Gin mode
-	if config.LogLevel == "debug" || config.LogLevel == "trace" {
+	if cfg.LogLevel == "debug" || cfg.LogLevel == "trace" {
 		gin.SetMode(gin.DebugMode)
 	} else {
 		gin.SetMode(gin.ReleaseMode)
 	}
 
+	// Development mode swaps the cluster and database before they are initialized
+	if config.DevMode {
+		if err := devmode.Prepare(); err != nil {
+			logger.Fatalf("Failed to start in development mode: %v", err)
+		}
+	}
+
 	// Initialize Kubernetes client
 	if err := kubernetes.Init(); err != nil {
 		logger.Fatalf("Failed to initialize Kubernetes client: %v", err)
@@ -74,26 +106,218 @@ func main() {
 	logger.Info("Kubernetes client initialized")
 
 	// Initialize database
-	if err := database.InitDB(config.DatabaseType, config.DatabaseConnectionString); err != nil {
+	if err := database.InitDB(config.DatabaseType, config.DatabaseConnectionString, config.DatabaseReplicaConnectionString, database.PoolConfig{
+		MaxOpenConns:    config.DatabaseMaxOpenConns,
+		MaxIdleConns:    config.DatabaseMaxIdleConns,
+		ConnMaxLifetime: config.DatabaseConnMaxLifetime,
+	}); err != nil {
 		logger.Fatalf("Failed to initialize database: %v", err)
 	}
+	if err := database.EnableCache(database.CacheConfig{
+		TTL:           config.CacheTTL,
+		RedisURL:      cmp.Or(config.CacheRedisURL, config.RedisURL),
+		TouchInterval: config.APIKeyTouchInterval,
+	}); err != nil {
+		logger.Fatalf("Failed to initialize the database cache: %v", err)
+	}
 	defer database.GetDB().Close()
 	logger.Info("Database initialized")
 
-	// Create a new Gin router
-	router := gin.Default()
+	// Nothing runs the jobs left unfinished by the previous run anymore
+	jobs.FailInterrupted(context.Background())
+
+	if config.DevMode {
+		if err := devmode.Seed(context.Background()); err != nil {
+			logger.Fatalf("Failed to seed development data: %v", err)
+		}
+	}
+
+	// Initialize the security event stream (disabled when no sink is configured)
+	if err := security.Init(); err != nil {
+		logger.Fatalf("Failed to initialize security event stream: %v", err)
+	}
+	defer security.Close(5 * time.Second)
+
+	// Initialize the rate limits (disabled when no limit is configured)
+	if err := ratelimit.Init(); err != nil {
+		logger.Fatalf("Failed to initialize rate limiting: %v", err)
+	}
+	defer ratelimit.Close()
+
+	// Initialize the outgoing webhooks (disabled when no URL is configured)
+	if err := webhooks.Init(); err != nil {
+		logger.Fatalf("Failed to initialize webhooks: %v", err)
+	}
+	defer webhooks.Close(5 * time.Second)
+
+	// Initialize the export of traces (disabled when no OTLP endpoint is configured)
+	if err := tracing.Init(); err != nil {
+		logger.Fatalf("Failed to initialize tracing: %v", err)
+	}
+	defer tracing.Close(5 * time.Second)
+
+	// Initialize the reporting of request panics (disabled when no DSN is configured)
+	if err := errreport.Init(); err != nil {
+		logger.Fatalf("Failed to initialize error reporting: %v", err)
+	}
+	defer errreport.Close(5 * time.Second)
+
+	// Report, and repair as configured, the drift accumulated while the API was down
+	if config.StartupCheck {
+		kubernetes.RunStartupCheck(context.Background(), kubernetes.WatchedNamespace(), database.GetDB(), config.StartupRepair)
+	}
+
+	// Track the provisioning state of the servers from their pods, reconcile the
+	// server records with the deployments, hibernate the idle servers, run the
+	// scheduled tasks and ping the database. With tenant namespaces the watchers follow
+	// every namespace, the other loops act in the namespace of each server
+	watcherCtx, stopWatcher := context.WithCancel(context.Background())
+	defer stopWatcher()
+	kubernetes.StartServerWatcher(watcherCtx, kubernetes.WatchedNamespace(), database.GetDB())
+	kubernetes.StartReconciler(watcherCtx, kubernetes.WatchedNamespace(), database.GetDB(), config.ReconcileInterval)
+	kubernetes.StartIdleMonitor(watcherCtx, config.DefaultNamespace, database.GetDB(), config.IdleCheckInterval, config.IdleShutdownAfter)
+	scheduler.Start(watcherCtx, config.DefaultNamespace, database.GetDB(), config.SchedulerInterval)
+	kubernetes.StartWhitelistSync(watcherCtx, config.DefaultNamespace, database.GetDB(), config.WhitelistSyncInterval)
+	events.StartStatusFeed(watcherCtx, database.GetDB(), config.EventsPollInterval)
+	if config.RedisURL != "" {
+		if err := events.StartRelay(watcherCtx, config.RedisURL); err != nil {
+			logger.Fatalf("Failed to start the event bus relay: %v", err)
+		}
+		logger.Info("Event bus relayed through Redis")
+	}
+	database.StartPinger(watcherCtx, database.GetDB(), config.DatabasePingInterval)
+
+	// Keep the server images pulled on every node
+	if config.PrePullEnabled {
+		if err := kubernetes.EnsurePrePull(watcherCtx, config.DefaultNamespace, kubernetes.PrePullImages(), ""); err != nil {
+			logger.Warnf("Failed to set up the server image pre-pull: %v", err)
+		}
+	}
+
+	// Create a new Gin router. The access log goes through the redaction
+	// helper since OAuth callbacks carry the authorization code in the query.
+	router := gin.New()
+	router.Use(gin.LoggerWithFormatter(redactedAccessLog))
 
 	// Setup API routes
-	api.SetupRoutes(router)
+	if err := api.SetupRoutes(router); err != nil {
+		logger.Fatalf("Failed to set up API routes: %v", err)
+	}
 	logger.Info("API routes configured")
 
 	// Setup Swagger endpoint
-	router.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
-	logger.Info("Swagger documentation endpoint enabled at /swagger/index.html")
+	if config.SwaggerEnabled {
+		router.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
+		logger.Info("Swagger documentation endpoint enabled at /swagger/index.html")
+	}
 
 	// Start the server
-	logger.Info("Starting HTTP server on port 8080")
-	if err := router.Run(":8080"); err != nil {
-		logger.Fatalf("Failed to start server: %v", err)
+	address := ":8080"
+	if config.DevMode {
+		address = devmode.ListenAddress
+	}
+	server := &http.Server{Addr: address, Handler: router}
+	// Event streams never end on their own and would hold up the shutdown
+	server.RegisterOnShutdown(events.CloseSubscriptions)
+
+	// Reload the settings that can change while the API runs on SIGHUP, and read the
+	// referenced secrets again to follow their rotations
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	go func() {
+		var refresh <-chan time.Time
+		if cfg.SecretsRefreshInterval > 0 {
+			ticker := time.NewTicker(cfg.SecretsRefreshInterval)
+			defer ticker.Stop()
+			refresh = ticker.C
+		}
+		for {
+			select {
+			case <-hangups:
+				cfg = reloadConfig(*configPath, cfg, "sighup")
+			case <-refresh:
+				cfg = reloadConfig(*configPath, cfg, "secrets_refresh")
+			}
+		}
+	}()
+
+	stopping, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+	go func() {
+		logger.Infof("Starting HTTP server on %s", address)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Fatalf("Failed to start server: %v", err)
+		}
+	}()
+	<-stopping.Done()
+	stop()
+
+	// Stop accepting requests, then wait for the in-flight ones and the background
+	// jobs and task runs they started. The deferred calls close the database last.
+	logger.Infof("Shutting down, draining requests and jobs for up to %s", config.ShutdownTimeout)
+	drainCtx, cancelDrain := context.WithTimeout(context.Background(), config.ShutdownTimeout)
+	defer cancelDrain()
+	if err := server.Shutdown(drainCtx); err != nil {
+		logger.Warnf("Requests still in flight at the shutdown timeout: %v", err)
+	}
+	stopWatcher()
+	shutdown.Drain(drainCtx)
+	logger.Info("Minecharts API stopped")
+}
+
+// reloadConfig loads the configuration again and applies the settings that can
+// change while the API runs: the log level, the rate limits, the OAuth providers, the
+// frontend URL and the secrets read on each use. An invalid configuration is not
+// applied. It returns the loaded configuration, which the next reload is compared with.
+func reloadConfig(path string, loaded *config.Config, trigger string) *config.Config {
+	next, err := config.Load(path)
+	if err == nil {
+		_, err = ratelimit.ParseRules(next.RateLimit, next.AuthRateLimit, next.ExecRateLimit)
+	}
+	if err != nil {
+		logging.WithFields(
+			logging.F("trigger", trigger),
+			logging.F("error", err.Error()),
+		).Error("Invalid configuration, not reloaded")
+		return loaded
+	}
+
+	applied, pending := config.Reload(loaded, next)
+	if trigger != "sighup" && len(applied) == 0 && len(pending) == 0 {
+		return next
+	}
+	if err := logging.SetLevel(config.Live().LogLevel); err != nil {
+		logging.WithFields(logging.F("error", err.Error())).Warn("Failed to apply the reloaded log level")
+	}
+	if err := ratelimit.Reload(); err != nil {
+		logging.WithFields(logging.F("error", err.Error())).Warn("Failed to apply the reloaded rate limits")
+	}
+
+	logging.WithFields(
+		logging.F("trigger", trigger),
+		logging.F("applied", applied),
+	).Info("Configuration reloaded")
+	if len(pending) > 0 {
+		logging.WithFields(logging.F("settings", pending)).Warn("Changed settings need a restart to apply")
+	}
+	return next
+}
+
+// redactedAccessLog formats Gin access logs like the default logger, with secrets scrubbed
+// from the path and the request ID to find the other logs of the request.
+func redactedAccessLog(param gin.LogFormatterParams) string {
+	if param.Latency > time.Minute {
+		param.Latency = param.Latency.Truncate(time.Second)
 	}
+	requestID, _ := param.Keys[middleware.RequestIDKey].(string)
+	return fmt.Sprintf("[GIN] %v | %3d | %13v | %15s | %32s | %-7s %#v\n%s",
+		param.TimeStamp.Format("2006/01/02 - 15:04:05"),
+		param.StatusCode,
+		param.Latency,
+		param.ClientIP,
+		requestID,
+		param.Method,
+		logging.Redact(param.Path),
+		logging.Redact(param.ErrorMessage),
+	)
 }
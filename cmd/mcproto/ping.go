@@ -0,0 +1,245 @@
+// Package mcproto implements the client side of the Minecraft network protocols
+// used to inspect a running server without exec'ing into its pod.
+//
+// It supports the Server List Ping (TCP, always enabled on Java servers) and the
+// GameSpy4-based Query protocol (UDP, requires enable-query=true).
+package mcproto
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultTimeout is used when the context passed to Ping or Query has no deadline.
+const DefaultTimeout = 5 * time.Second
+
+// maxPacketLength bounds the size of a status response we are willing to read.
+const maxPacketLength = 1 << 21
+
+var (
+	ErrInvalidResponse = errors.New("invalid response from Minecraft server")
+	ErrPacketTooLarge  = errors.New("packet exceeds maximum allowed length")
+)
+
+// Status is the information returned by a Server List Ping.
+type Status struct {
+	Version  string   `json:"version"`
+	Protocol int      `json:"protocol"`
+	Online   int      `json:"online"`
+	Max      int      `json:"max"`
+	Players  []string `json:"players"`
+	MOTD     string   `json:"motd"`
+}
+
+// statusResponse mirrors the JSON document sent by the server.
+type statusResponse struct {
+	Version struct {
+		Name     string `json:"name"`
+		Protocol int    `json:"protocol"`
+	} `json:"version"`
+	Players struct {
+		Max    int `json:"max"`
+		Online int `json:"online"`
+		Sample []struct {
+			Name string `json:"name"`
+			ID   string `json:"id"`
+		} `json:"sample"`
+	} `json:"players"`
+	Description json.RawMessage `json:"description"`
+}
+
+// Ping performs a Server List Ping against the given address ("host:port").
+// The player list only contains the sample sent by the server, which vanilla
+// servers cap at 12 entries.
+func Ping(ctx context.Context, address string) (*Status, error) {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address %q: %w", address, err)
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port %q: %w", portStr, err)
+	}
+
+	ctx, cancel := withDefaultTimeout(ctx)
+	defer cancel()
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	// Handshake: protocol version -1 (status only), host, port, next state 1 (status).
+	var handshake bytes.Buffer
+	writeVarInt(&handshake, 0x00)
+	writeVarInt(&handshake, -1)
+	writeString(&handshake, host)
+	binary.Write(&handshake, binary.BigEndian, uint16(port))
+	writeVarInt(&handshake, 1)
+	if err := writePacket(conn, handshake.Bytes()); err != nil {
+		return nil, err
+	}
+
+	// Status request: empty packet with ID 0x00.
+	if err := writePacket(conn, []byte{0x00}); err != nil {
+		return nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+	length, err := readVarInt(reader)
+	if err != nil {
+		return nil, err
+	}
+	if length <= 0 || length > maxPacketLength {
+		return nil, ErrPacketTooLarge
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return nil, err
+	}
+
+	body := bytes.NewReader(payload)
+	packetID, err := readVarInt(body)
+	if err != nil {
+		return nil, err
+	}
+	if packetID != 0x00 {
+		return nil, ErrInvalidResponse
+	}
+
+	raw, err := readString(body)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp statusResponse
+	if err := json.Unmarshal([]byte(raw), &resp); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidResponse, err)
+	}
+
+	status := &Status{
+		Version:  resp.Version.Name,
+		Protocol: resp.Version.Protocol,
+		Online:   resp.Players.Online,
+		Max:      resp.Players.Max,
+		Players:  make([]string, 0, len(resp.Players.Sample)),
+		MOTD:     parseDescription(resp.Description),
+	}
+	for _, p := range resp.Players.Sample {
+		status.Players = append(status.Players, p.Name)
+	}
+
+	return status, nil
+}
+
+// parseDescription flattens the MOTD, which may be a plain string or a chat component.
+func parseDescription(raw json.RawMessage) string {
+	if len(raw) == 0 {
+		return ""
+	}
+
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		return text
+	}
+
+	var component chatComponent
+	if err := json.Unmarshal(raw, &component); err != nil {
+		return ""
+	}
+	var sb strings.Builder
+	component.flatten(&sb)
+	return sb.String()
+}
+
+// chatComponent is the subset of the Minecraft chat format needed to extract text.
+type chatComponent struct {
+	Text  string          `json:"text"`
+	Extra []chatComponent `json:"extra"`
+}
+
+func (c chatComponent) flatten(sb *strings.Builder) {
+	sb.WriteString(c.Text)
+	for _, extra := range c.Extra {
+		extra.flatten(sb)
+	}
+}
+
+func withDefaultTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, DefaultTimeout)
+}
+
+func writePacket(w io.Writer, data []byte) error {
+	var packet bytes.Buffer
+	writeVarInt(&packet, int32(len(data)))
+	packet.Write(data)
+	_, err := w.Write(packet.Bytes())
+	return err
+}
+
+func writeVarInt(buf *bytes.Buffer, value int32) {
+	v := uint32(value)
+	for {
+		if v&^0x7F == 0 {
+			buf.WriteByte(byte(v))
+			return
+		}
+		buf.WriteByte(byte(v&0x7F | 0x80))
+		v >>= 7
+	}
+}
+
+func readVarInt(r io.ByteReader) (int32, error) {
+	var result uint32
+	for i := 0; i < 5; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		result |= uint32(b&0x7F) << (7 * i)
+		if b&0x80 == 0 {
+			return int32(result), nil
+		}
+	}
+	return 0, ErrInvalidResponse
+}
+
+func writeString(buf *bytes.Buffer, s string) {
+	writeVarInt(buf, int32(len(s)))
+	buf.WriteString(s)
+}
+
+func readString(r *bytes.Reader) (string, error) {
+	length, err := readVarInt(r)
+	if err != nil {
+		return "", err
+	}
+	if length < 0 || int(length) > r.Len() {
+		return "", ErrInvalidResponse
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
@@ -0,0 +1,143 @@
+package mcproto
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net"
+	"strconv"
+)
+
+const (
+	queryTypeHandshake byte = 0x09
+	queryTypeStat      byte = 0x00
+)
+
+var queryMagic = []byte{0xFE, 0xFD}
+
+// QueryResult is the information returned by a full stat Query request.
+type QueryResult struct {
+	MOTD       string   `json:"motd"`
+	GameType   string   `json:"gameType"`
+	Version    string   `json:"version"`
+	Map        string   `json:"map"`
+	Plugins    string   `json:"plugins"`
+	NumPlayers int      `json:"online"`
+	MaxPlayers int      `json:"max"`
+	Players    []string `json:"players"`
+}
+
+// Query performs a full stat request using the UDP Query protocol against
+// the given address ("host:port"). Unlike Ping, the returned player list is complete.
+// The server must run with enable-query=true (ENABLE_QUERY on the itzg image).
+func Query(ctx context.Context, address string) (*QueryResult, error) {
+	ctx, cancel := withDefaultTimeout(ctx)
+	defer cancel()
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", address)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	sessionID := int32(1) & 0x0F0F0F0F
+
+	// Handshake to obtain a challenge token.
+	if _, err := conn.Write(queryPacket(queryTypeHandshake, sessionID, nil)); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 65535)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil, err
+	}
+	if n < 6 || buf[0] != queryTypeHandshake {
+		return nil, ErrInvalidResponse
+	}
+	tokenStr := string(bytes.TrimRight(buf[5:n], "\x00"))
+	token, err := strconv.ParseInt(tokenStr, 10, 32)
+	if err != nil {
+		return nil, ErrInvalidResponse
+	}
+
+	// Full stat request: challenge token followed by four bytes of padding.
+	payload := make([]byte, 8)
+	binary.BigEndian.PutUint32(payload, uint32(int32(token)))
+	if _, err := conn.Write(queryPacket(queryTypeStat, sessionID, payload)); err != nil {
+		return nil, err
+	}
+
+	n, err = conn.Read(buf)
+	if err != nil {
+		return nil, err
+	}
+	if n < 16 || buf[0] != queryTypeStat {
+		return nil, ErrInvalidResponse
+	}
+
+	// Skip type (1), session ID (4) and the constant "splitnum\x00\x80\x00" padding (11).
+	return parseFullStat(buf[16:n])
+}
+
+func queryPacket(packetType byte, sessionID int32, payload []byte) []byte {
+	var packet bytes.Buffer
+	packet.Write(queryMagic)
+	packet.WriteByte(packetType)
+	binary.Write(&packet, binary.BigEndian, sessionID)
+	packet.Write(payload)
+	return packet.Bytes()
+}
+
+// parseFullStat decodes the key/value section and the player section of a full stat response.
+func parseFullStat(data []byte) (*QueryResult, error) {
+	fields := bytes.Split(data, []byte{0x00})
+
+	result := &QueryResult{Players: []string{}}
+	i := 0
+	for ; i+1 < len(fields); i += 2 {
+		key := string(fields[i])
+		if key == "" {
+			break
+		}
+		value := string(fields[i+1])
+		switch key {
+		case "hostname":
+			result.MOTD = value
+		case "gametype":
+			result.GameType = value
+		case "version":
+			result.Version = value
+		case "map":
+			result.Map = value
+		case "plugins":
+			result.Plugins = value
+		case "numplayers":
+			result.NumPlayers, _ = strconv.Atoi(value)
+		case "maxplayers":
+			result.MaxPlayers, _ = strconv.Atoi(value)
+		}
+	}
+
+	// The player section starts with "\x01player_\x00\x00"; find the marker
+	// and read names until the terminating empty string.
+	for ; i < len(fields); i++ {
+		if bytes.HasSuffix(fields[i], []byte("player_")) {
+			i += 2
+			break
+		}
+	}
+	for ; i < len(fields); i++ {
+		if len(fields[i]) == 0 {
+			break
+		}
+		result.Players = append(result.Players, string(fields[i]))
+	}
+
+	return result, nil
+}
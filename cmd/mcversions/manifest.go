@@ -0,0 +1,220 @@
+// Package mcversions fetches the Minecraft versions the server types are released for,
+// from the version manifests of Mojang, PaperMC and Fabric, and caches them.
+package mcversions
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+
+	"minecharts/cmd/config"
+	"minecharts/cmd/logging"
+)
+
+// userAgent identifies the API to the upstream manifests.
+const userAgent = "ZenT0x/minecharts-api (https://github.com/ZenT0x/minecharts)"
+
+// versionPattern matches the release versions, such as 1.21.4.
+var versionPattern = regexp.MustCompile(`^\d+\.\d+(\.\d+)?$`)
+
+// ErrUnknownType is returned for the server types without a version manifest.
+var ErrUnknownType = errors.New("no version manifest for this server type")
+
+// Version is a Minecraft version a server type can run.
+type Version struct {
+	ID          string     `json:"id" example:"1.21.4"`
+	Stable      bool       `json:"stable"`                // Releases, as opposed to snapshots, pre-releases and experimental builds
+	ReleaseTime *time.Time `json:"releaseTime,omitempty"` // Only known for vanilla versions
+}
+
+// Manifest lists the versions of a server type, the most recent first.
+type Manifest struct {
+	Type      string    `json:"type" example:"paper"`
+	Latest    string    `json:"latest" example:"1.21.4"` // Most recent stable version
+	Versions  []Version `json:"versions"`
+	FetchedAt time.Time `json:"fetchedAt"`
+	Stale     bool      `json:"stale,omitempty"` // Served from an expired fetch because the upstream manifest is unreachable
+}
+
+// fetchers fetch the upstream manifest of each server type.
+var fetchers = map[string]func(ctx context.Context) (*Manifest, error){
+	"vanilla": fetchVanilla,
+	"paper":   fetchPaper,
+	"fabric":  fetchFabric,
+}
+
+// Types returns the server types with a version manifest.
+func Types() []string {
+	return []string{"vanilla", "paper", "fabric"}
+}
+
+// cache holds the last manifest fetched for each server type.
+var cache = struct {
+	sync.Mutex
+	manifests map[string]*Manifest
+	fetching  map[string]*sync.Mutex
+}{manifests: map[string]*Manifest{}, fetching: map[string]*sync.Mutex{}}
+
+// Get returns the versions of a server type, fetched from upstream at most once every
+// config.VersionManifestTTL. When upstream fails, the last manifest fetched is
+// returned as stale, if any.
+func Get(ctx context.Context, serverType string) (*Manifest, error) {
+	fetch, ok := fetchers[serverType]
+	if !ok {
+		return nil, ErrUnknownType
+	}
+
+	cache.Lock()
+	lock := cache.fetching[serverType]
+	if lock == nil {
+		lock = &sync.Mutex{}
+		cache.fetching[serverType] = lock
+	}
+	cache.Unlock()
+
+	// One fetch at a time per type, the others wait for its result
+	lock.Lock()
+	defer lock.Unlock()
+
+	cache.Lock()
+	cached := cache.manifests[serverType]
+	cache.Unlock()
+	if cached != nil && time.Since(cached.FetchedAt) < config.VersionManifestTTL {
+		return cached, nil
+	}
+
+	manifest, err := fetch(ctx)
+	if err != nil {
+		logging.API.WithContext(ctx).WithFields(
+			"server_type", serverType,
+			"error", err.Error(),
+		).Warn("Failed to fetch version manifest")
+		if cached == nil {
+			return nil, err
+		}
+		stale := *cached
+		stale.Stale = true
+		return &stale, nil
+	}
+	manifest.Type = serverType
+	manifest.FetchedAt = time.Now()
+
+	cache.Lock()
+	cache.manifests[serverType] = manifest
+	cache.Unlock()
+	return manifest, nil
+}
+
+// Invalidate drops the cached manifests, so that the next requests fetch them again.
+// It returns the number of manifests dropped.
+func Invalidate() int {
+	cache.Lock()
+	defer cache.Unlock()
+	dropped := len(cache.manifests)
+	cache.manifests = map[string]*Manifest{}
+	return dropped
+}
+
+// fetchVanilla reads the Mojang version manifest, which lists the releases and
+// snapshots from the most recent.
+func fetchVanilla(ctx context.Context) (*Manifest, error) {
+	var result struct {
+		Latest struct {
+			Release string `json:"release"`
+		} `json:"latest"`
+		Versions []struct {
+			ID          string    `json:"id"`
+			Type        string    `json:"type"`
+			ReleaseTime time.Time `json:"releaseTime"`
+		} `json:"versions"`
+	}
+	if err := getJSON(ctx, config.MojangVersionManifestURL, &result); err != nil {
+		return nil, err
+	}
+
+	manifest := &Manifest{Latest: result.Latest.Release, Versions: []Version{}}
+	for _, version := range result.Versions {
+		// Old alpha and beta versions cannot be run by the server image
+		if version.Type != "release" && version.Type != "snapshot" {
+			continue
+		}
+		releaseTime := version.ReleaseTime
+		manifest.Versions = append(manifest.Versions, Version{
+			ID:          version.ID,
+			Stable:      version.Type == "release",
+			ReleaseTime: &releaseTime,
+		})
+	}
+	return manifest, nil
+}
+
+// fetchPaper reads the PaperMC project, which lists its versions from the oldest.
+func fetchPaper(ctx context.Context) (*Manifest, error) {
+	var result struct {
+		Versions []string `json:"versions"`
+	}
+	if err := getJSON(ctx, config.PaperAPIURL+"/projects/paper", &result); err != nil {
+		return nil, err
+	}
+
+	manifest := &Manifest{Versions: []Version{}}
+	for i := len(result.Versions) - 1; i >= 0; i-- {
+		id := result.Versions[i]
+		stable := versionPattern.MatchString(id)
+		if stable && manifest.Latest == "" {
+			manifest.Latest = id
+		}
+		manifest.Versions = append(manifest.Versions, Version{ID: id, Stable: stable})
+	}
+	return manifest, nil
+}
+
+// fetchFabric reads the game versions of Fabric Meta, from the most recent.
+func fetchFabric(ctx context.Context) (*Manifest, error) {
+	var result []struct {
+		Version string `json:"version"`
+		Stable  bool   `json:"stable"`
+	}
+	if err := getJSON(ctx, config.FabricMetaURL+"/versions/game", &result); err != nil {
+		return nil, err
+	}
+
+	manifest := &Manifest{Versions: []Version{}}
+	for _, version := range result {
+		if version.Stable && manifest.Latest == "" {
+			manifest.Latest = version.Version
+		}
+		manifest.Versions = append(manifest.Versions, Version{ID: version.Version, Stable: version.Stable})
+	}
+	return manifest, nil
+}
+
+// httpClient fetches the manifests, which are small.
+var httpClient = &http.Client{Timeout: 15 * time.Second}
+
+func getJSON(ctx context.Context, url string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create manifest request: %w", err)
+	}
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to fetch version manifest: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to fetch version manifest: %s", resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode version manifest: %w", err)
+	}
+	return nil
+}
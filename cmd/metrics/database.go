@@ -0,0 +1,91 @@
+package metrics
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+
+	"minecharts/cmd/database"
+)
+
+// Metrics of the database connection pools, by pool (primary or replica), and of the
+// periodic pings.
+var (
+	_ = NewGaugeFunc("minecharts_db_connections",
+		"Open connections to the database, by pool and state (in_use or idle).",
+		collectPools(func(stats sql.DBStats) []Sample {
+			return []Sample{
+				{Values: []string{"in_use"}, Value: float64(stats.InUse)},
+				{Values: []string{"idle"}, Value: float64(stats.Idle)},
+			}
+		}), "pool", "state")
+	_ = NewGaugeFunc("minecharts_db_max_open_connections",
+		"Connections each pool opens at most, 0 for unlimited.",
+		collectPools(func(stats sql.DBStats) []Sample {
+			return []Sample{{Value: float64(stats.MaxOpenConnections)}}
+		}), "pool")
+	_ = NewCounterFunc("minecharts_db_wait_count_total",
+		"Queries that waited for a connection of a full pool, by pool.",
+		collectPools(func(stats sql.DBStats) []Sample {
+			return []Sample{{Value: float64(stats.WaitCount)}}
+		}), "pool")
+	_ = NewCounterFunc("minecharts_db_wait_duration_seconds_total",
+		"Time queries waited for a connection of a full pool, by pool.",
+		collectPools(func(stats sql.DBStats) []Sample {
+			return []Sample{{Value: stats.WaitDuration.Seconds()}}
+		}), "pool")
+	_ = NewCounterFunc("minecharts_db_closed_connections_total",
+		"Connections closed by the pool, by pool and reason (max_idle or max_lifetime).",
+		collectPools(func(stats sql.DBStats) []Sample {
+			return []Sample{
+				{Values: []string{"max_idle"}, Value: float64(stats.MaxIdleClosed + stats.MaxIdleTimeClosed)},
+				{Values: []string{"max_lifetime"}, Value: float64(stats.MaxLifetimeClosed)},
+			}
+		}), "pool", "reason")
+	_ = NewGaugeFunc("minecharts_db_up",
+		"Whether the database answered the last periodic ping, left out when the pings are disabled.",
+		collectPing(func(ping database.PingResult) float64 {
+			if ping.Err != nil {
+				return 0
+			}
+			return 1
+		}))
+	_ = NewGaugeFunc("minecharts_db_ping_duration_seconds",
+		"Latency of the last periodic ping of the database.",
+		collectPing(func(ping database.PingResult) float64 {
+			return ping.Latency.Seconds()
+		}))
+)
+
+// collectPools returns the collect function of a pool metric, prefixing the values
+// sample returns for each pool with its name.
+func collectPools(sample func(stats sql.DBStats) []Sample) func(context.Context) ([]Sample, error) {
+	return func(context.Context) ([]Sample, error) {
+		pools := database.GetDB().PoolStats()
+		names := make([]string, 0, len(pools))
+		for name := range pools {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		var samples []Sample
+		for _, name := range names {
+			for _, s := range sample(pools[name]) {
+				samples = append(samples, Sample{Values: append([]string{name}, s.Values...), Value: s.Value})
+			}
+		}
+		return samples, nil
+	}
+}
+
+// collectPing returns the collect function of a ping metric, without sample before
+// the first ping.
+func collectPing(value func(ping database.PingResult) float64) func(context.Context) ([]Sample, error) {
+	return func(context.Context) ([]Sample, error) {
+		ping := database.LastPing()
+		if ping.At.IsZero() {
+			return nil, nil
+		}
+		return []Sample{{Value: value(ping)}}, nil
+	}
+}
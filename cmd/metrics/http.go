@@ -0,0 +1,61 @@
+package metrics
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"minecharts/cmd/apierror"
+	"minecharts/cmd/config"
+	"minecharts/cmd/logging"
+
+	"github.com/gin-gonic/gin"
+)
+
+// contentType is the content type of the Prometheus text exposition format.
+const contentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Middleware counts the API requests and records their latency, by method and route.
+// Requests matching no route are recorded under the "unmatched" route so that probes
+// of random paths do not create series.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		HTTPRequests.Inc(c.Request.Method, route, strconv.Itoa(c.Writer.Status()))
+		HTTPRequestDuration.Observe(time.Since(start).Seconds(), c.Request.Method, route)
+	}
+}
+
+// Handler serves the metrics to Prometheus. When MINECHARTS_METRICS_TOKEN is set the
+// scraper must send it as a bearer token.
+func Handler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if metricsToken := config.Live().MetricsToken; metricsToken != "" {
+			token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(token), []byte(metricsToken)) != 1 {
+				c.Header("WWW-Authenticate", `Bearer realm="metrics"`)
+				apierror.AbortWith(c, apierror.New(http.StatusUnauthorized, apierror.CodeInvalidToken, "Invalid metrics token"))
+				return
+			}
+		}
+
+		var body bytes.Buffer
+		if err := WriteAll(c.Request.Context(), &body); err != nil {
+			logging.API.WithContext(c.Request.Context()).WithFields(
+				"error", err.Error(),
+			).Error("Failed to write metrics")
+			apierror.Write(c, http.StatusInternalServerError, "Failed to write metrics")
+			return
+		}
+		c.Data(http.StatusOK, contentType, body.Bytes())
+	}
+}
@@ -0,0 +1,306 @@
+// Package metrics exposes the Prometheus metrics of the API at GET /metrics, in the
+// Prometheus text exposition format, so operators can monitor the control plane.
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Metrics of the API. They are registered on creation and written in this order.
+var (
+	HTTPRequests = NewCounterVec("minecharts_http_requests_total",
+		"API requests handled, by method, route and status code.",
+		"method", "route", "status")
+	HTTPRequestDuration = NewHistogramVec("minecharts_http_request_duration_seconds",
+		"Latency of the API requests, by method and route.",
+		[]float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30},
+		"method", "route")
+	RateLimitedRequests = NewCounterVec("minecharts_rate_limited_requests_total",
+		"API requests refused for exceeding a rate limit, by bucket.",
+		"bucket")
+	BackupDuration = NewHistogramVec("minecharts_backup_duration_seconds",
+		"Duration of the server volume backups, by result.",
+		[]float64{5, 15, 30, 60, 120, 300, 600, 1200, 1800, 3600},
+		"result")
+	KubernetesAPIErrors = NewCounterVec("minecharts_kubernetes_api_errors_total",
+		"Failed Kubernetes API calls, by verb, resource and status code (\"error\" when no response was received).",
+		"verb", "resource", "code")
+)
+
+// collector is a metric family written to the scrapes.
+type collector interface {
+	write(ctx context.Context, w io.Writer) error
+}
+
+var (
+	registryMu sync.Mutex
+	registry   []collector
+)
+
+func register(c collector) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry = append(registry, c)
+}
+
+// WriteAll writes every registered metric in the text exposition format.
+func WriteAll(ctx context.Context, w io.Writer) error {
+	registryMu.Lock()
+	collectors := append([]collector(nil), registry...)
+	registryMu.Unlock()
+
+	for _, c := range collectors {
+		if err := c.write(ctx, w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// series is the value of a metric for one combination of label values.
+type series struct {
+	values  []string
+	count   float64
+	sum     float64
+	buckets []float64 // Histograms only, not cumulative
+}
+
+// family holds the series of a metric, keyed by their label values.
+type family struct {
+	name   string
+	help   string
+	labels []string
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+func (f *family) init(name, help string, labels []string) {
+	f.name, f.help, f.labels = name, help, labels
+	f.series = map[string]*series{}
+}
+
+// get returns the series of the label values, creating it. The caller holds f.mu.
+func (f *family) get(values []string, buckets int) *series {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", f.name, len(f.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{values: append([]string(nil), values...), buckets: make([]float64, buckets)}
+		f.series[key] = s
+	}
+	return s
+}
+
+// sorted returns a copy of the series ordered by label values, for stable scrapes.
+func (f *family) sorted() []series {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	out := make([]series, 0, len(keys))
+	for _, key := range keys {
+		s := *f.series[key]
+		s.buckets = append([]float64(nil), s.buckets...)
+		out = append(out, s)
+	}
+	return out
+}
+
+// CounterVec is a counter partitioned by labels.
+type CounterVec struct {
+	family
+}
+
+// NewCounterVec creates and registers a counter.
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{}
+	c.init(name, help, labels)
+	register(c)
+	return c
+}
+
+// Inc adds one to the counter of the label values.
+func (c *CounterVec) Inc(values ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.get(values, 0).count++
+}
+
+func (c *CounterVec) write(_ context.Context, w io.Writer) error {
+	if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, escapeHelp(c.help), c.name); err != nil {
+		return err
+	}
+	for _, s := range c.sorted() {
+		if _, err := fmt.Fprintf(w, "%s%s %s\n", c.name, labelSet(c.labels, s.values), formatValue(s.count)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// HistogramVec is a histogram partitioned by labels.
+type HistogramVec struct {
+	family
+	bounds []float64
+}
+
+// NewHistogramVec creates and registers a histogram with the bucket upper bounds given,
+// in increasing order.
+func NewHistogramVec(name, help string, bounds []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{bounds: bounds}
+	h.init(name, help, labels)
+	register(h)
+	return h
+}
+
+// Observe records a value in the histogram of the label values.
+func (h *HistogramVec) Observe(value float64, values ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s := h.get(values, len(h.bounds))
+	s.count++
+	s.sum += value
+	if i := sort.SearchFloat64s(h.bounds, value); i < len(h.bounds) {
+		s.buckets[i]++
+	}
+}
+
+func (h *HistogramVec) write(_ context.Context, w io.Writer) error {
+	if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, escapeHelp(h.help), h.name); err != nil {
+		return err
+	}
+	labels := append(append([]string(nil), h.labels...), "le")
+	for _, s := range h.sorted() {
+		values := append(append([]string(nil), s.values...), "")
+		cumulative := 0.0
+		for i, bound := range h.bounds {
+			cumulative += s.buckets[i]
+			values[len(values)-1] = formatValue(bound)
+			if _, err := fmt.Fprintf(w, "%s_bucket%s %s\n", h.name, labelSet(labels, values), formatValue(cumulative)); err != nil {
+				return err
+			}
+		}
+		values[len(values)-1] = "+Inf"
+		if _, err := fmt.Fprintf(w, "%s_bucket%s %s\n%s_sum%s %s\n%s_count%s %s\n",
+			h.name, labelSet(labels, values), formatValue(s.count),
+			h.name, labelSet(h.labels, s.values), formatValue(s.sum),
+			h.name, labelSet(h.labels, s.values), formatValue(s.count)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Sample is a value of a gauge computed at scrape time.
+type Sample struct {
+	Values []string // Label values, in the order of the gauge labels
+	Value  float64
+}
+
+// GaugeFunc is a gauge whose values are computed at each scrape.
+type GaugeFunc struct {
+	name       string
+	help       string
+	labels     []string
+	metricType string
+	collect    func(ctx context.Context) ([]Sample, error)
+}
+
+// NewGaugeFunc creates and registers a gauge computed by collect at each scrape. A
+// failing collect leaves the gauge out of the scrape.
+func NewGaugeFunc(name, help string, collect func(ctx context.Context) ([]Sample, error), labels ...string) *GaugeFunc {
+	g := &GaugeFunc{name: name, help: help, labels: labels, metricType: "gauge", collect: collect}
+	register(g)
+	return g
+}
+
+// CounterFunc is a counter read at each scrape from a source counting on its own,
+// such as the statistics of the database connection pools.
+type CounterFunc struct {
+	GaugeFunc
+}
+
+// NewCounterFunc creates and registers a counter read by collect at each scrape. A
+// failing collect leaves the counter out of the scrape.
+func NewCounterFunc(name, help string, collect func(ctx context.Context) ([]Sample, error), labels ...string) *CounterFunc {
+	c := &CounterFunc{GaugeFunc{name: name, help: help, labels: labels, metricType: "counter", collect: collect}}
+	register(c)
+	return c
+}
+
+func (g *GaugeFunc) write(ctx context.Context, w io.Writer) error {
+	samples, err := g.collect(ctx)
+	if err != nil {
+		scrapeErrors.Inc(g.name)
+		return nil
+	}
+	if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", g.name, escapeHelp(g.help), g.name, g.metricType); err != nil {
+		return err
+	}
+	for _, sample := range samples {
+		if _, err := fmt.Fprintf(w, "%s%s %s\n", g.name, labelSet(g.labels, sample.Values), formatValue(sample.Value)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scrapeErrors counts the gauges that could not be computed, as when the database
+// is unreachable.
+var scrapeErrors = NewCounterVec("minecharts_metrics_scrape_errors_total",
+	"Gauges left out of a scrape because they could not be computed, by gauge.",
+	"metric")
+
+func labelSet(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(name)
+		b.WriteString(`="`)
+		b.WriteString(escapeLabel(values[i]))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+
+func escapeHelp(s string) string { return helpEscaper.Replace(s) }
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
@@ -0,0 +1,82 @@
+package metrics
+
+import (
+	"context"
+	"runtime"
+	"sort"
+
+	"minecharts/cmd/database"
+)
+
+// Gauges computed at scrape time.
+var (
+	_ = NewGaugeFunc("minecharts_servers",
+		"Minecraft servers recorded by the API, by status.",
+		collectServers, "status")
+	_ = NewGaugeFunc("minecharts_server_label",
+		"Custom labels of the servers, always 1, to join with the metrics of their pods or group them by label.",
+		collectServerLabels, "server", "key", "value")
+	_ = NewGaugeFunc("minecharts_goroutines",
+		"Goroutines of the API process.",
+		func(context.Context) ([]Sample, error) {
+			return []Sample{{Value: float64(runtime.NumGoroutine())}}, nil
+		})
+)
+
+// serverStatuses are always reported, at zero when no server is in them, so that
+// dashboards and alerts see the series.
+var serverStatuses = []string{
+	database.ServerStatusCreating,
+	database.ServerStatusStarting,
+	database.ServerStatusRunning,
+	database.ServerStatusFailed,
+	database.ServerStatusStopped,
+	database.ServerStatusHibernated,
+}
+
+func collectServers(ctx context.Context) ([]Sample, error) {
+	servers, err := database.GetDB().ListServers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int, len(serverStatuses))
+	for _, status := range serverStatuses {
+		counts[status] = 0
+	}
+	for _, server := range servers {
+		counts[server.Status]++
+	}
+
+	statuses := make([]string, 0, len(counts))
+	for status := range counts {
+		statuses = append(statuses, status)
+	}
+	sort.Strings(statuses)
+
+	samples := make([]Sample, 0, len(statuses))
+	for _, status := range statuses {
+		samples = append(samples, Sample{Values: []string{status}, Value: float64(counts[status])})
+	}
+	return samples, nil
+}
+
+func collectServerLabels(ctx context.Context) ([]Sample, error) {
+	servers, err := database.GetDB().ListServers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var samples []Sample
+	for _, server := range servers {
+		keys := make([]string, 0, len(server.Spec.Labels))
+		for key := range server.Spec.Labels {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			samples = append(samples, Sample{Values: []string{server.ServerName, key, server.Spec.Labels[key]}, Value: 1})
+		}
+	}
+	return samples, nil
+}
@@ -0,0 +1,341 @@
+// Package modplatform is the client of the mod platforms the plugins and mods of the
+// servers are installed from. Modrinth is the only one supported.
+package modplatform
+
+import (
+	"context"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"minecharts/cmd/config"
+	"minecharts/cmd/logging"
+)
+
+// Modrinth is the platform name recorded for the plugins installed from Modrinth.
+const Modrinth = "modrinth"
+
+// userAgent identifies the API to Modrinth, which requires it.
+const userAgent = "ZenT0x/minecharts-api (https://github.com/ZenT0x/minecharts)"
+
+// maxFileSize is the largest plugin or mod file downloaded.
+const maxFileSize = 256 << 20
+
+// ErrNotFound is returned for the projects and versions the platform does not know.
+var ErrNotFound = errors.New("not found on the mod platform")
+
+// Project is a plugin or mod published on the platform.
+type Project struct {
+	ID           string   `json:"id" example:"Lu3KuzdV"`
+	Slug         string   `json:"slug" example:"coreprotect"`
+	Title        string   `json:"title" example:"CoreProtect"`
+	Description  string   `json:"description"`
+	Downloads    int64    `json:"downloads"`
+	IconURL      string   `json:"iconUrl,omitempty"`
+	Loaders      []string `json:"loaders,omitempty" example:"paper,spigot"`
+	GameVersions []string `json:"gameVersions,omitempty"`
+}
+
+// Version is a release of a project.
+type Version struct {
+	ID            string    `json:"id" example:"b0Yxyasa"`
+	ProjectID     string    `json:"projectId" example:"Lu3KuzdV"`
+	Name          string    `json:"name"`
+	VersionNumber string    `json:"versionNumber" example:"22.4"`
+	VersionType   string    `json:"versionType" example:"release"` // "release", "beta" or "alpha"
+	Loaders       []string  `json:"loaders"`
+	GameVersions  []string  `json:"gameVersions"`
+	Published     time.Time `json:"published"`
+	Files         []File    `json:"files"`
+}
+
+// File is a file of a version.
+type File struct {
+	URL      string `json:"url"`
+	FileName string `json:"fileName" example:"CoreProtect-22.4.jar"`
+	Primary  bool   `json:"primary"`
+	Size     int64  `json:"size"`
+	SHA512   string `json:"sha512"`
+}
+
+// PrimaryFile returns the main file of a version: the one marked primary, or the
+// first one when none is.
+func (v *Version) PrimaryFile() (File, bool) {
+	for _, file := range v.Files {
+		if file.Primary {
+			return file, true
+		}
+	}
+	if len(v.Files) > 0 {
+		return v.Files[0], true
+	}
+	return File{}, false
+}
+
+// Filter restricts the projects and versions to those a server can run.
+type Filter struct {
+	Loaders     []string // Any of them, e.g. paper, spigot, bukkit
+	GameVersion string   // Empty for any version
+}
+
+// Client queries the Modrinth API.
+type Client struct {
+	baseURL string
+	http    *http.Client
+}
+
+// NewClient returns a client of the Modrinth API at config.ModrinthURL.
+func NewClient() *Client {
+	// Requests are bounded by their context, downloads may take a while
+	return &Client{baseURL: config.ModrinthURL, http: &http.Client{}}
+}
+
+// modrinthProject is a project of the Modrinth API.
+type modrinthProject struct {
+	ID           string   `json:"id"`
+	Slug         string   `json:"slug"`
+	Title        string   `json:"title"`
+	Description  string   `json:"description"`
+	Downloads    int64    `json:"downloads"`
+	IconURL      string   `json:"icon_url"`
+	Loaders      []string `json:"loaders"`
+	GameVersions []string `json:"game_versions"`
+}
+
+// modrinthSearchHit is a search result of the Modrinth API, where the loaders are
+// among the categories.
+type modrinthSearchHit struct {
+	ProjectID   string   `json:"project_id"`
+	Slug        string   `json:"slug"`
+	Title       string   `json:"title"`
+	Description string   `json:"description"`
+	Downloads   int64    `json:"downloads"`
+	IconURL     string   `json:"icon_url"`
+	Categories  []string `json:"categories"`
+	Versions    []string `json:"versions"`
+}
+
+// modrinthVersion is a version of the Modrinth API.
+type modrinthVersion struct {
+	ID            string    `json:"id"`
+	ProjectID     string    `json:"project_id"`
+	Name          string    `json:"name"`
+	VersionNumber string    `json:"version_number"`
+	VersionType   string    `json:"version_type"`
+	Loaders       []string  `json:"loaders"`
+	GameVersions  []string  `json:"game_versions"`
+	DatePublished time.Time `json:"date_published"`
+	Files         []struct {
+		URL      string            `json:"url"`
+		Filename string            `json:"filename"`
+		Primary  bool              `json:"primary"`
+		Size     int64             `json:"size"`
+		Hashes   map[string]string `json:"hashes"`
+	} `json:"files"`
+}
+
+func (v modrinthVersion) version() Version {
+	version := Version{
+		ID:            v.ID,
+		ProjectID:     v.ProjectID,
+		Name:          v.Name,
+		VersionNumber: v.VersionNumber,
+		VersionType:   v.VersionType,
+		Loaders:       v.Loaders,
+		GameVersions:  v.GameVersions,
+		Published:     v.DatePublished,
+		Files:         []File{},
+	}
+	for _, file := range v.Files {
+		version.Files = append(version.Files, File{
+			URL:      file.URL,
+			FileName: file.Filename,
+			Primary:  file.Primary,
+			Size:     file.Size,
+			SHA512:   file.Hashes["sha512"],
+		})
+	}
+	return version
+}
+
+// Search returns the projects matching a query that are compatible with the filter,
+// the most relevant first.
+func (c *Client) Search(ctx context.Context, query string, filter Filter, limit int) ([]Project, error) {
+	var facets [][]string
+	if len(filter.Loaders) > 0 {
+		var loaders []string
+		for _, loader := range filter.Loaders {
+			loaders = append(loaders, "categories:"+loader)
+		}
+		facets = append(facets, loaders)
+	}
+	if filter.GameVersion != "" {
+		facets = append(facets, []string{"versions:" + filter.GameVersion})
+	}
+
+	params := url.Values{"query": {query}, "limit": {strconv.Itoa(limit)}}
+	if len(facets) > 0 {
+		encoded, err := json.Marshal(facets)
+		if err != nil {
+			return nil, err
+		}
+		params.Set("facets", string(encoded))
+	}
+
+	var result struct {
+		Hits []modrinthSearchHit `json:"hits"`
+	}
+	if err := c.get(ctx, "/search", params, &result); err != nil {
+		return nil, err
+	}
+
+	projects := []Project{}
+	for _, hit := range result.Hits {
+		projects = append(projects, Project{
+			ID:           hit.ProjectID,
+			Slug:         hit.Slug,
+			Title:        hit.Title,
+			Description:  hit.Description,
+			Downloads:    hit.Downloads,
+			IconURL:      hit.IconURL,
+			Loaders:      hit.Categories,
+			GameVersions: hit.Versions,
+		})
+	}
+	return projects, nil
+}
+
+// GetProject returns a project by ID or slug.
+func (c *Client) GetProject(ctx context.Context, idOrSlug string) (*Project, error) {
+	var project modrinthProject
+	if err := c.get(ctx, "/project/"+url.PathEscape(idOrSlug), nil, &project); err != nil {
+		return nil, err
+	}
+	return &Project{
+		ID:           project.ID,
+		Slug:         project.Slug,
+		Title:        project.Title,
+		Description:  project.Description,
+		Downloads:    project.Downloads,
+		IconURL:      project.IconURL,
+		Loaders:      project.Loaders,
+		GameVersions: project.GameVersions,
+	}, nil
+}
+
+// ListVersions returns the versions of a project compatible with the filter, the
+// newest first.
+func (c *Client) ListVersions(ctx context.Context, projectID string, filter Filter) ([]Version, error) {
+	params := url.Values{}
+	if len(filter.Loaders) > 0 {
+		encoded, err := json.Marshal(filter.Loaders)
+		if err != nil {
+			return nil, err
+		}
+		params.Set("loaders", string(encoded))
+	}
+	if filter.GameVersion != "" {
+		params.Set("game_versions", `["`+filter.GameVersion+`"]`)
+	}
+
+	var result []modrinthVersion
+	if err := c.get(ctx, "/project/"+url.PathEscape(projectID)+"/version", params, &result); err != nil {
+		return nil, err
+	}
+	versions := make([]Version, 0, len(result))
+	for _, version := range result {
+		versions = append(versions, version.version())
+	}
+	return versions, nil
+}
+
+// GetVersion returns a version by ID.
+func (c *Client) GetVersion(ctx context.Context, id string) (*Version, error) {
+	var result modrinthVersion
+	if err := c.get(ctx, "/version/"+url.PathEscape(id), nil, &result); err != nil {
+		return nil, err
+	}
+	version := result.version()
+	return &version, nil
+}
+
+// Download writes a file to w, and fails when it does not match its hash. What was
+// written must be discarded when an error is returned.
+func (c *Client) Download(ctx context.Context, file File, w io.Writer) error {
+	if file.Size > maxFileSize {
+		return fmt.Errorf("file %s is larger than %d MiB", file.FileName, maxFileSize>>20)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, file.URL, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create download request: %w", err)
+	}
+	req.Header.Set("User-Agent", userAgent)
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to download %s: %w", file.FileName, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to download %s: %s", file.FileName, resp.Status)
+	}
+
+	hash := sha512.New()
+	written, err := io.Copy(io.MultiWriter(w, hash), io.LimitReader(resp.Body, maxFileSize+1))
+	if err != nil {
+		return fmt.Errorf("failed to download %s: %w", file.FileName, err)
+	}
+	if written > maxFileSize {
+		return fmt.Errorf("file %s is larger than %d MiB", file.FileName, maxFileSize>>20)
+	}
+	if file.SHA512 != "" && hex.EncodeToString(hash.Sum(nil)) != file.SHA512 {
+		return fmt.Errorf("downloaded %s does not match its SHA-512 hash", file.FileName)
+	}
+	return nil
+}
+
+// get queries a path of the API and decodes its JSON response into out.
+func (c *Client) get(ctx context.Context, path string, params url.Values, out interface{}) error {
+	target := c.baseURL + path
+	if len(params) > 0 {
+		target += "?" + params.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create Modrinth request: %w", err)
+	}
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		logging.API.WithContext(ctx).WithFields(
+			"path", path,
+			"error", err.Error(),
+		).Warn("Modrinth request failed")
+		return fmt.Errorf("failed to query Modrinth: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return ErrNotFound
+	case resp.StatusCode != http.StatusOK:
+		logging.API.WithContext(ctx).WithFields(
+			"path", path,
+			"status", resp.StatusCode,
+		).Warn("Modrinth request failed")
+		return fmt.Errorf("failed to query Modrinth: %s", resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode Modrinth response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often the buckets refilled to their burst are forgotten.
+const sweepInterval = time.Minute
+
+// memoryBackend keeps the buckets of a replica in memory.
+type memoryBackend struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	swept   time.Time
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	period  time.Duration
+}
+
+func newMemoryBackend() *memoryBackend {
+	return &memoryBackend{buckets: map[string]*bucket{}, swept: time.Now()}
+}
+
+func (m *memoryBackend) take(_ context.Context, key string, rule Rule) (bool, time.Duration, error) {
+	now := time.Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if now.Sub(m.swept) >= sweepInterval {
+		m.sweep(now)
+	}
+
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(rule.Requests), updated: now, period: rule.Period}
+		m.buckets[key] = b
+	}
+	b.tokens = min(float64(rule.Requests), b.tokens+float64(now.Sub(b.updated))*rule.refill())
+	b.updated = now
+
+	if b.tokens < 1 {
+		return false, rule.wait(b.tokens), nil
+	}
+	b.tokens--
+	return true, 0, nil
+}
+
+// sweep forgets the buckets idle for their whole period, which are full again.
+func (m *memoryBackend) sweep(now time.Time) {
+	m.swept = now
+	for key, b := range m.buckets {
+		if now.Sub(b.updated) >= b.period {
+			delete(m.buckets, key)
+		}
+	}
+}
+
+func (m *memoryBackend) close() error {
+	return nil
+}
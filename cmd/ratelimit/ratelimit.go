@@ -0,0 +1,235 @@
+// Package ratelimit throttles the clients of the API with token buckets: each client
+// may make a burst of requests, refilled evenly over a period. The limits are set as
+// requests per period, such as 600/1m, by MINECHARTS_RATE_LIMIT for every request and
+// by stricter buckets for the sensitive routes. The buckets are kept in memory, per
+// replica, or in Redis when MINECHARTS_RATE_LIMIT_REDIS_URL or MINECHARTS_REDIS_URL
+// is set, so that the replicas share them.
+package ratelimit
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"minecharts/cmd/config"
+	"minecharts/cmd/logging"
+)
+
+// Buckets.
+const (
+	Global = "global" // Every request
+	Auth   = "auth"   // Logins, registrations and the other credential checks
+	Exec   = "exec"   // Commands run in the servers
+)
+
+// backendTimeout bounds a bucket update in Redis.
+const backendTimeout = time.Second
+
+// Rule is the limit of a bucket: Requests per Period, which is also the burst.
+type Rule struct {
+	Requests int
+	Period   time.Duration
+}
+
+// String formats a rule as it is configured.
+func (r Rule) String() string {
+	return strconv.Itoa(r.Requests) + "/" + r.Period.String()
+}
+
+// ParseRule reads a rule written as requests/period, such as 600/1m. The period
+// defaults to a second when it is left out, as in 10.
+func ParseRule(value string) (Rule, error) {
+	requests, period, found := strings.Cut(strings.TrimSpace(value), "/")
+	rule := Rule{Period: time.Second}
+	var err error
+	if rule.Requests, err = strconv.Atoi(requests); err != nil || rule.Requests < 1 {
+		return Rule{}, fmt.Errorf("invalid rate limit %q: expected a positive number of requests, such as 600/1m", value)
+	}
+	if found {
+		if rule.Period, err = time.ParseDuration(period); err != nil || rule.Period <= 0 {
+			return Rule{}, fmt.Errorf("invalid rate limit %q: expected a positive period, such as 600/1m", value)
+		}
+	}
+	return rule, nil
+}
+
+// backend takes a token from a bucket, returning how long to wait for the next one
+// when it is empty.
+type backend interface {
+	take(ctx context.Context, key string, rule Rule) (allowed bool, retryAfter time.Duration, err error)
+	close() error
+}
+
+type limiter struct {
+	rules   map[string]Rule
+	backend backend
+	name    string
+}
+
+var (
+	current  atomic.Pointer[limiter]
+	initOnce sync.Once
+	// failures counts the backend errors, to log them without flooding the logs.
+	failures atomic.Int64
+)
+
+// Init configures the rate limits. Rate limiting stays disabled when no limit is set.
+func Init() error {
+	var err error
+	initOnce.Do(func() {
+		var rules map[string]Rule
+		if rules, err = configuredRules(); err != nil {
+			return
+		}
+		if len(rules) == 0 {
+			logging.API.Debug("Rate limiting disabled: no limit configured")
+			return
+		}
+
+		var l *limiter
+		if l, err = newLimiter(rules); err != nil {
+			return
+		}
+		current.Store(l)
+		logEnabled(l)
+	})
+	return err
+}
+
+// configuredRules parses the limits of the configuration.
+func configuredRules() (map[string]Rule, error) {
+	c := config.Live()
+	return ParseRules(c.RateLimit, c.AuthRateLimit, c.ExecRateLimit)
+}
+
+// Reload applies the configured limits again, keeping the backend and the state of
+// the buckets, such as after a configuration reload. Rate limiting starts with the
+// first limit set.
+func Reload() error {
+	rules, err := configuredRules()
+	if err != nil {
+		return err
+	}
+	l := current.Load()
+	if l == nil {
+		if len(rules) == 0 {
+			return nil
+		}
+		if l, err = newLimiter(rules); err != nil {
+			return err
+		}
+	}
+	l = &limiter{rules: rules, backend: l.backend, name: l.name}
+	current.Store(l)
+	if len(rules) == 0 {
+		logging.API.Info("Rate limiting disabled: no limit configured")
+		return nil
+	}
+	logEnabled(l)
+	return nil
+}
+
+// ParseRules reads the limits of the buckets, leaving out the empty ones.
+func ParseRules(global, auth, exec string) (map[string]Rule, error) {
+	rules := map[string]Rule{}
+	for bucket, value := range map[string]string{
+		Global: global,
+		Auth:   auth,
+		Exec:   exec,
+	} {
+		if strings.TrimSpace(value) == "" {
+			continue
+		}
+		rule, err := ParseRule(value)
+		if err != nil {
+			return nil, err
+		}
+		rules[bucket] = rule
+	}
+	return rules, nil
+}
+
+// newLimiter returns a limiter of the rules on the configured backend.
+func newLimiter(rules map[string]Rule) (*limiter, error) {
+	l := &limiter{rules: rules, backend: newMemoryBackend(), name: "memory"}
+	if url := cmp.Or(config.RateLimitRedisURL, config.RedisURL); url != "" {
+		backend, err := newRedisBackend(url)
+		if err != nil {
+			return nil, err
+		}
+		l.backend, l.name = backend, "redis"
+	}
+	return l, nil
+}
+
+// logEnabled logs the limits of the buckets.
+func logEnabled(l *limiter) {
+	fields := []interface{}{"backend", l.name}
+	for bucket, rule := range l.rules {
+		fields = append(fields, bucket, rule.String())
+	}
+	logging.API.WithFields(fields...).Info("Rate limiting enabled")
+}
+
+// Close releases the connection of the Redis backend.
+func Close() {
+	if l := current.Swap(nil); l != nil {
+		_ = l.backend.close()
+	}
+}
+
+// Enabled tells whether a bucket has a limit.
+func Enabled(bucket string) bool {
+	l := current.Load()
+	if l == nil {
+		return false
+	}
+	_, ok := l.rules[bucket]
+	return ok
+}
+
+// Allow takes a token from the bucket of a client, identified by key. When the
+// bucket is empty it returns false and how long until the client may retry. Buckets
+// without a limit always allow; so does a failing backend, so that an outage of
+// Redis does not take the API down with it.
+func Allow(ctx context.Context, bucket, key string) (bool, time.Duration) {
+	l := current.Load()
+	if l == nil {
+		return true, 0
+	}
+	rule, ok := l.rules[bucket]
+	if !ok {
+		return true, 0
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, backendTimeout)
+	defer cancel()
+	allowed, retryAfter, err := l.backend.take(ctx, bucket+":"+key, rule)
+	if err != nil {
+		if n := failures.Add(1); n == 1 || n%100 == 0 {
+			logging.API.WithContext(ctx).WithFields(
+				"backend", l.name,
+				"failures", n,
+				"error", err.Error(),
+			).Warn("Rate limit backend failed, allowing the request")
+		}
+		return true, 0
+	}
+	return allowed, retryAfter
+}
+
+// refill returns the tokens a bucket gains per nanosecond.
+func (r Rule) refill() float64 {
+	return float64(r.Requests) / float64(r.Period)
+}
+
+// wait returns how long a bucket holding tokens takes to hold one.
+func (r Rule) wait(tokens float64) time.Duration {
+	return time.Duration(math.Ceil((1 - tokens) / r.refill()))
+}
@@ -0,0 +1,68 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"minecharts/cmd/redis"
+)
+
+// takeScript updates a token bucket atomically: it refills the bucket for the time
+// elapsed since its last update, then takes a token or returns the milliseconds to
+// wait for one. The bucket expires once it would be full again.
+const takeScript = `
+local requests = tonumber(ARGV[1])
+local period = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'updated')
+local tokens = tonumber(state[1]) or requests
+local updated = tonumber(state[2]) or now
+tokens = math.min(requests, tokens + math.max(0, now - updated) * requests / period)
+local wait = 0
+if tokens < 1 then
+	wait = math.ceil((1 - tokens) * period / requests)
+else
+	tokens = tokens - 1
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'updated', now)
+redis.call('PEXPIRE', KEYS[1], period)
+return wait
+`
+
+// keyPrefix namespaces the buckets in a shared Redis.
+const keyPrefix = "minecharts:ratelimit:"
+
+// redisBackend keeps the buckets in Redis, shared by the replicas.
+type redisBackend struct {
+	client *redis.Client
+}
+
+// newRedisBackend connects to the Redis of a redis:// or rediss:// URL.
+func newRedisBackend(raw string) (*redisBackend, error) {
+	client, err := redis.NewClient(raw)
+	if err != nil {
+		return nil, fmt.Errorf("rate limit Redis: %w", err)
+	}
+	return &redisBackend{client: client}, nil
+}
+
+func (r *redisBackend) take(ctx context.Context, key string, rule Rule) (bool, time.Duration, error) {
+	now := time.Now().UnixMilli()
+	period := max(rule.Period.Milliseconds(), 1)
+	reply, err := r.client.Do(ctx, "EVAL", takeScript, "1", keyPrefix+key,
+		strconv.Itoa(rule.Requests), strconv.FormatInt(period, 10), strconv.FormatInt(now, 10))
+	if err != nil {
+		return false, 0, err
+	}
+	wait, ok := reply.(int64)
+	if !ok {
+		return false, 0, fmt.Errorf("unexpected Redis reply %v", reply)
+	}
+	return wait == 0, time.Duration(wait) * time.Millisecond, nil
+}
+
+func (r *redisBackend) close() error {
+	return r.client.Close()
+}
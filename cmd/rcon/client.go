@@ -0,0 +1,194 @@
+// Package rcon implements a client for the Source RCON protocol spoken by
+// Minecraft servers started with enable-rcon=true.
+//
+// Each command is sent as a single request; responses longer than one packet
+// are reassembled by sending an empty marker request after the command.
+package rcon
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// DefaultPort is the RCON port used by the itzg/minecraft-server image.
+const DefaultPort = 25575
+
+// DefaultTimeout is used when the context passed to Dial has no deadline.
+const DefaultTimeout = 10 * time.Second
+
+const (
+	packetTypeResponse int32 = 0
+	packetTypeCommand  int32 = 2
+	packetTypeAuth     int32 = 3
+
+	// maxPacketSize is the largest packet a Minecraft server sends (4096 body + header).
+	maxPacketSize = 4096 + 14
+	// maxCommandLength is the largest command body accepted by Minecraft servers.
+	maxCommandLength = 1446
+)
+
+var (
+	ErrAuthFailed       = errors.New("rcon authentication failed")
+	ErrCommandTooLong   = errors.New("rcon command too long")
+	ErrInvalidPacket    = errors.New("invalid rcon packet")
+	ErrUnexpectedPacket = errors.New("unexpected rcon response")
+)
+
+// Client is an authenticated RCON connection. It is safe for concurrent use;
+// commands are serialized on the underlying connection.
+type Client struct {
+	conn    net.Conn
+	mu      sync.Mutex
+	nextID  int32
+	timeout time.Duration
+}
+
+// Dial connects to the RCON server at address ("host:port") and authenticates with password.
+func Dial(ctx context.Context, address, password string) (*Client, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultTimeout)
+		defer cancel()
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &Client{conn: conn, nextID: 1, timeout: DefaultTimeout}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if err := client.authenticate(password); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return client, nil
+}
+
+// Close closes the connection.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// Execute runs a command and returns the server's response text.
+func (c *Client) Execute(ctx context.Context, command string) (string, error) {
+	if len(command) > maxCommandLength {
+		return "", ErrCommandTooLong
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(c.timeout)
+	}
+	c.conn.SetDeadline(deadline)
+	defer c.conn.SetDeadline(time.Time{})
+
+	commandID := c.newID()
+	if err := c.writePacket(commandID, packetTypeCommand, command); err != nil {
+		return "", err
+	}
+
+	// Follow up with an empty response-type packet: the server echoes it after the
+	// last fragment of the command output, marking the end of the response.
+	markerID := c.newID()
+	if err := c.writePacket(markerID, packetTypeResponse, ""); err != nil {
+		return "", err
+	}
+
+	var response bytes.Buffer
+	for {
+		id, _, body, err := c.readPacket()
+		if err != nil {
+			return "", err
+		}
+		switch id {
+		case commandID:
+			response.WriteString(body)
+		case markerID:
+			// Vanilla answers the marker with "Unknown request 0", which ends the response.
+			return response.String(), nil
+		default:
+			return "", fmt.Errorf("%w: id %d", ErrUnexpectedPacket, id)
+		}
+	}
+}
+
+func (c *Client) authenticate(password string) error {
+	id := c.newID()
+	if err := c.writePacket(id, packetTypeAuth, password); err != nil {
+		return err
+	}
+
+	for {
+		respID, respType, _, err := c.readPacket()
+		if err != nil {
+			return err
+		}
+		// Some servers send an empty response packet before the auth response.
+		if respType == packetTypeResponse && respID == id {
+			continue
+		}
+		if respID == -1 {
+			return ErrAuthFailed
+		}
+		if respID != id {
+			return fmt.Errorf("%w: id %d", ErrUnexpectedPacket, respID)
+		}
+		return nil
+	}
+}
+
+func (c *Client) newID() int32 {
+	id := c.nextID
+	c.nextID++
+	if c.nextID <= 0 {
+		c.nextID = 1
+	}
+	return id
+}
+
+func (c *Client) writePacket(id, packetType int32, body string) error {
+	var packet bytes.Buffer
+	binary.Write(&packet, binary.LittleEndian, int32(len(body)+10))
+	binary.Write(&packet, binary.LittleEndian, id)
+	binary.Write(&packet, binary.LittleEndian, packetType)
+	packet.WriteString(body)
+	packet.Write([]byte{0x00, 0x00})
+	_, err := c.conn.Write(packet.Bytes())
+	return err
+}
+
+func (c *Client) readPacket() (int32, int32, string, error) {
+	var length int32
+	if err := binary.Read(c.conn, binary.LittleEndian, &length); err != nil {
+		return 0, 0, "", err
+	}
+	if length < 10 || length > maxPacketSize {
+		return 0, 0, "", ErrInvalidPacket
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(c.conn, data); err != nil {
+		return 0, 0, "", err
+	}
+
+	id := int32(binary.LittleEndian.Uint32(data[0:4]))
+	packetType := int32(binary.LittleEndian.Uint32(data[4:8]))
+	body := string(bytes.TrimRight(data[8:], "\x00"))
+	return id, packetType, body, nil
+}
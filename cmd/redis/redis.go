@@ -0,0 +1,242 @@
+// Package redis is a minimal Redis client for the rate limits, the caches and the
+// event bus shared by the replicas. It speaks the few commands they need over a single
+// connection, reopened after a failure, rather than pulling in a client library.
+package redis
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dialTimeout bounds the connection to Redis.
+const dialTimeout = 2 * time.Second
+
+// defaultTimeout bounds the commands sent without a context deadline.
+const defaultTimeout = time.Second
+
+// Client sends commands to a Redis server.
+type Client struct {
+	mu       sync.Mutex
+	address  string
+	tls      bool
+	username string
+	password string
+	db       int
+	conn     net.Conn
+	reader   *bufio.Reader
+}
+
+// NewClient reads a redis:// or rediss:// URL,
+// redis://[[user]:password@]host[:port][/db], and checks that Redis answers.
+func NewClient(raw string) (*Client, error) {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "redis" && u.Scheme != "rediss") || u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid URL %q: expected redis://[:password@]host[:port][/db]", raw)
+	}
+	c := &Client{address: u.Host, tls: u.Scheme == "rediss"}
+	if u.Port() == "" {
+		c.address = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		c.username = u.User.Username()
+		c.password, _ = u.User.Password()
+	}
+	if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+		if c.db, err = strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf("invalid URL %q: the path must be a database number", raw)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dialTimeout)
+	defer cancel()
+	if _, err := c.Do(ctx, "PING"); err != nil {
+		return nil, fmt.Errorf("failed to reach Redis: %w", err)
+	}
+	return c, nil
+}
+
+// Close closes the connection, reopened by the next command.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn = nil
+	return err
+}
+
+// Do sends a command and reads its reply, connecting first if needed. The
+// connection is dropped after any failure, as its state is unknown.
+func (c *Client) Do(ctx context.Context, args ...string) (interface{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn == nil {
+		if err := c.connect(ctx); err != nil {
+			return nil, err
+		}
+	}
+	reply, err := c.roundTrip(ctx, args)
+	var redisErr Error
+	if err != nil && !errors.As(err, &redisErr) {
+		c.conn.Close()
+		c.conn = nil
+	}
+	return reply, err
+}
+
+// Subscribe calls handle with each message published on channel, in order, until ctx
+// is done or the connection fails. It subscribes on a connection of its own, as a
+// subscribed connection takes no other commands.
+func (c *Client) Subscribe(ctx context.Context, channel string, handle func(message string)) error {
+	sub := &Client{address: c.address, tls: c.tls, username: c.username, password: c.password, db: c.db}
+	if err := sub.connect(ctx); err != nil {
+		return err
+	}
+	defer sub.conn.Close()
+	stop := context.AfterFunc(ctx, func() { sub.conn.Close() })
+	defer stop()
+
+	if _, err := sub.roundTrip(ctx, []string{"SUBSCRIBE", channel}); err != nil {
+		return fmt.Errorf("SUBSCRIBE failed: %w", err)
+	}
+	// The messages come whenever they are published
+	if err := sub.conn.SetDeadline(time.Time{}); err != nil {
+		return err
+	}
+	for {
+		reply, err := sub.readReply()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		push, ok := reply.([]interface{})
+		if !ok || len(push) != 3 || push[0] != "message" {
+			continue
+		}
+		if message, ok := push[2].(string); ok {
+			handle(message)
+		}
+	}
+}
+
+func (c *Client) connect(ctx context.Context) error {
+	dialer := &net.Dialer{Timeout: dialTimeout}
+	var conn net.Conn
+	var err error
+	if c.tls {
+		host, _, _ := net.SplitHostPort(c.address)
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: host}}).DialContext(ctx, "tcp", c.address)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", c.address)
+	}
+	if err != nil {
+		return err
+	}
+	c.conn, c.reader = conn, bufio.NewReader(conn)
+
+	var setup [][]string
+	switch {
+	case c.username != "" && c.password != "":
+		setup = append(setup, []string{"AUTH", c.username, c.password})
+	case c.password != "":
+		setup = append(setup, []string{"AUTH", c.password})
+	}
+	if c.db != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(c.db)})
+	}
+	for _, command := range setup {
+		if _, err := c.roundTrip(ctx, command); err != nil {
+			conn.Close()
+			c.conn = nil
+			return fmt.Errorf("%s failed: %w", command[0], err)
+		}
+	}
+	return nil
+}
+
+func (c *Client) roundTrip(ctx context.Context, args []string) (interface{}, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(defaultTimeout)
+	}
+	if err := c.conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	var command strings.Builder
+	fmt.Fprintf(&command, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&command, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := c.conn.Write([]byte(command.String())); err != nil {
+		return nil, err
+	}
+	return c.readReply()
+}
+
+// Error is an error reply of Redis, after which the connection stays usable.
+type Error string
+
+func (e Error) Error() string {
+	return "redis: " + string(e)
+}
+
+// readReply reads a reply of the RESP protocol: simple strings, errors, integers,
+// bulk strings and arrays of them.
+func (c *Client) readReply() (interface{}, error) {
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("empty Redis reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, Error(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 {
+			return nil, err
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(c.reader, data); err != nil {
+			return nil, err
+		}
+		return string(data[:size]), nil
+	case '*':
+		count, err := strconv.Atoi(line[1:])
+		if err != nil || count < 0 {
+			return nil, err
+		}
+		items := make([]interface{}, count)
+		for i := range items {
+			if items[i], err = c.readReply(); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("unexpected Redis reply %q", line)
+}
@@ -0,0 +1,332 @@
+// Package scheduler runs the scheduled tasks of the servers: restarts, backups,
+// console commands, broadcasts and cleanups on cron schedules.
+package scheduler
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"minecharts/cmd/config"
+	"minecharts/cmd/database"
+	"minecharts/cmd/kubernetes"
+	"minecharts/cmd/logging"
+	"minecharts/cmd/shutdown"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// TaskStore is the subset of the database the scheduler needs.
+type TaskStore interface {
+	GetServerByName(ctx context.Context, serverName string) (*database.MinecraftServer, error)
+	ListDueScheduledTasks(ctx context.Context, at time.Time) ([]*database.ScheduledTask, error)
+	ClaimScheduledTask(ctx context.Context, task *database.ScheduledTask, runAt time.Time, next *time.Time) (bool, error)
+	CreateTaskRun(ctx context.Context, run *database.TaskRun) error
+	FinishTaskRun(ctx context.Context, run *database.TaskRun) error
+	PruneTaskRuns(ctx context.Context, taskID int64, keep int) error
+}
+
+// errNotRunning skips the tasks that need a running server.
+var errNotRunning = errors.New("server is not running")
+
+type scheduler struct {
+	namespace string
+	store     TaskStore
+	interval  time.Duration
+	warnings  downtimeWarnings
+
+	mu sync.Mutex
+	// servers serializes the tasks of each server, so a backup does not run
+	// while the server restarts.
+	servers map[string]*sync.Mutex
+}
+
+// NextRun returns when a task on the given schedule runs next after the given time,
+// or nil when the schedule never matches again.
+func NextRun(schedule string, after time.Time) (*time.Time, error) {
+	parsed, err := ParseSchedule(schedule)
+	if err != nil {
+		return nil, err
+	}
+	next := parsed.Next(after)
+	if next.IsZero() {
+		return nil, nil
+	}
+	return &next, nil
+}
+
+// Start looks up the due tasks every interval until the context is cancelled, and
+// runs each of them in its own goroutine once it is claimed, recording the run in
+// the task history. Tasks missed while the API was down run once when it is back.
+func Start(ctx context.Context, namespace string, store TaskStore, interval time.Duration) {
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+
+	s := &scheduler{
+		namespace: namespace,
+		store:     store,
+		interval:  interval,
+		warnings:  parseDowntimeWarnings(config.DowntimeWarnings, config.DowntimeWarningActions),
+		servers:   map[string]*sync.Mutex{},
+	}
+
+	logging.Server.WithContext(ctx).WithFields(
+		"namespace", namespace,
+		"interval", interval.String(),
+		"downtime_warnings", config.DowntimeWarnings,
+	).Info("Task scheduler started")
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				s.tick(ctx)
+			}
+		}
+	}()
+}
+
+func (s *scheduler) tick(ctx context.Context) {
+	// Due tasks stay due until the cluster is back
+	if !kubernetes.ClusterReachable() {
+		logging.Server.WithContext(ctx).Debug("Skipping scheduled tasks, cluster unreachable")
+		return
+	}
+
+	// Tasks whose players are warned are claimed ahead of time, for the countdown
+	now := time.Now()
+	tasks, err := s.store.ListDueScheduledTasks(ctx, now.Add(s.warnings.maxLead()))
+	if err != nil {
+		logging.Server.WithContext(ctx).WithFields(
+			"error", err.Error(),
+		).Warn("Failed to list due scheduled tasks")
+		return
+	}
+
+	for _, task := range tasks {
+		at, due := s.warnedRunAt(task, now)
+		if !due {
+			continue
+		}
+		next, err := NextRun(task.Schedule, at)
+		if err != nil {
+			// Schedules are validated by the API, a broken one is not run again
+			logging.Server.WithContext(ctx).WithFields(
+				"task_id", task.ID,
+				"schedule", task.Schedule,
+				"error", err.Error(),
+			).Warn("Invalid task schedule, task disabled until updated")
+		}
+
+		claimed, err := s.store.ClaimScheduledTask(ctx, task, at, next)
+		if err != nil || !claimed {
+			continue
+		}
+		go s.run(ctx, task, at)
+	}
+}
+
+// run executes a claimed task at its time, after warning the players when the task
+// interrupts their game, and records its outcome.
+func (s *scheduler) run(ctx context.Context, task *database.ScheduledTask, at time.Time) {
+	// The countdown does not hold up the other tasks of the server
+	if !s.countdown(ctx, task, at) {
+		logging.Server.WithContext(ctx).WithFields(
+			"server_name", task.ServerName,
+			"task_id", task.ID,
+		).Warn("Scheduled task dropped during its countdown, the API is stopping")
+		return
+	}
+	// The API waits for the running tasks, such as backups, when it shuts down
+	ctx, done, ok := shutdown.Track(ctx)
+	if !ok {
+		logging.Server.WithContext(ctx).WithFields(
+			"server_name", task.ServerName,
+			"task_id", task.ID,
+		).Warn("Scheduled task dropped, the API is stopping")
+		return
+	}
+	defer done()
+
+	lock := s.serverLock(task.ServerName)
+	lock.Lock()
+	defer lock.Unlock()
+
+	run := &database.TaskRun{
+		TaskID:    task.ID,
+		StartedAt: time.Now(),
+		Status:    database.TaskRunRunning,
+	}
+	if err := s.store.CreateTaskRun(ctx, run); err != nil {
+		return
+	}
+
+	logging.Server.WithContext(ctx).WithFields(
+		"server_name", task.ServerName,
+		"task_id", task.ID,
+		"task_name", task.Name,
+		"action", task.Action,
+	).Info("Running scheduled task")
+
+	timeout := config.ExecTimeout
+	switch task.Action {
+	case database.TaskActionBackup:
+		timeout = config.BackupTimeout
+	case database.TaskActionCleanup:
+		timeout = config.CleanupTimeout
+	case database.TaskActionRestart:
+		timeout += config.RolloutTimeout
+	}
+	// The run is logged and annotated on the objects it changes as the request it stands for
+	requestID := "task-run-" + strconv.FormatInt(run.ID, 10)
+	runCtx, cancel := context.WithTimeout(kubernetes.WithActor(logging.ContextWithRequestID(ctx, requestID), kubernetes.Actor{
+		Username:  "system:scheduler",
+		RequestID: requestID,
+	}), timeout)
+	output, err := s.execute(runCtx, task)
+	cancel()
+
+	finished := time.Now()
+	run.FinishedAt = &finished
+	run.Output = output
+	switch {
+	case errors.Is(err, errNotRunning):
+		run.Status = database.TaskRunSkipped
+		run.Output = "Server is not running"
+	case err != nil:
+		run.Status = database.TaskRunFailed
+		run.Output = err.Error()
+		logging.Server.WithContext(ctx).WithFields(
+			"server_name", task.ServerName,
+			"task_id", task.ID,
+			"action", task.Action,
+			"error", err.Error(),
+		).Warn("Scheduled task failed")
+	default:
+		run.Status = database.TaskRunSucceeded
+		logging.Server.WithContext(ctx).WithFields(
+			"server_name", task.ServerName,
+			"task_id", task.ID,
+			"action", task.Action,
+			"duration", finished.Sub(run.StartedAt).String(),
+		).Info("Scheduled task completed")
+	}
+
+	// The outcome is recorded even when the task was interrupted by the shutdown
+	storeCtx := context.WithoutCancel(ctx)
+	if err := s.store.FinishTaskRun(storeCtx, run); err != nil {
+		return
+	}
+	_ = s.store.PruneTaskRuns(storeCtx, task.ID, config.TaskRunHistory)
+}
+
+// execute performs the action of a task and returns its output.
+func (s *scheduler) execute(ctx context.Context, task *database.ScheduledTask) (string, error) {
+	server, err := s.store.GetServerByName(ctx, task.ServerName)
+	if err != nil {
+		return "", fmt.Errorf("failed to get server: %w", err)
+	}
+	namespace := cmp.Or(server.Namespace, s.namespace)
+
+	pod, err := kubernetes.GetMinecraftPod(ctx, namespace, server.DeploymentName)
+	if err != nil {
+		return "", fmt.Errorf("failed to get server pod: %w", err)
+	}
+	if pod != nil && pod.Status.Phase != corev1.PodRunning {
+		pod = nil
+	}
+
+	switch task.Action {
+	case database.TaskActionRestart:
+		if pod == nil {
+			return "", errNotRunning
+		}
+		deployment, err := kubernetes.GetDeployment(ctx, namespace, server.DeploymentName)
+		if err != nil {
+			return "", fmt.Errorf("failed to get deployment: %w", err)
+		}
+		if deployment == nil {
+			return "", errNotRunning
+		}
+		previous := deployment.Spec.Template.DeepCopy()
+		if _, _, err := kubernetes.SaveWorld(ctx, pod.Name, namespace); err != nil {
+			return "", fmt.Errorf("failed to save world: %w", err)
+		}
+		if err := kubernetes.RestartDeployment(ctx, namespace, server.DeploymentName); err != nil {
+			return "", fmt.Errorf("failed to restart deployment: %w", err)
+		}
+		if config.RolloutStrategy != kubernetes.RolloutWaitForReady {
+			return "Server restarting", nil
+		}
+		if _, err := kubernetes.WaitForRollout(ctx, namespace, server.DeploymentName, previous, config.RolloutTimeout); err != nil {
+			return "", err
+		}
+		return "Server restarted", nil
+
+	case database.TaskActionBackup:
+		// A stopped server has nothing left to save
+		if pod != nil {
+			if _, _, err := kubernetes.SaveWorld(ctx, pod.Name, namespace); err != nil {
+				return "", fmt.Errorf("failed to save world: %w", err)
+			}
+		}
+		archive, err := kubernetes.BackupVolume(ctx, namespace, server)
+		if err != nil {
+			return "", err
+		}
+		return "Backup written to " + archive, nil
+
+	case database.TaskActionCleanup:
+		policy, err := kubernetes.ParseCleanupPolicy(task.Payload)
+		if err != nil {
+			return "", err
+		}
+		results, err := kubernetes.CleanupVolume(ctx, namespace, server.DeploymentName, server.PVCName, policy)
+		if err != nil {
+			return "", err
+		}
+		return kubernetes.CleanupSummary(results), nil
+
+	case database.TaskActionCommand, database.TaskActionBroadcast:
+		if pod == nil {
+			return "", errNotRunning
+		}
+		deployment, err := kubernetes.GetDeployment(ctx, namespace, server.DeploymentName)
+		if err != nil {
+			return "", fmt.Errorf("failed to get deployment: %w", err)
+		}
+		if deployment == nil {
+			return "", errNotRunning
+		}
+		command := task.Payload
+		if task.Action == database.TaskActionBroadcast {
+			command = "say " + task.Payload
+		}
+		output, _, err := kubernetes.SendConsoleCommand(ctx, namespace, deployment, pod, command)
+		if err != nil {
+			return output, fmt.Errorf("failed to run command: %w", err)
+		}
+		return output, nil
+	}
+	return "", fmt.Errorf("unknown action %q", task.Action)
+}
+
+func (s *scheduler) serverLock(serverName string) *sync.Mutex {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	lock, ok := s.servers[serverName]
+	if !ok {
+		lock = &sync.Mutex{}
+		s.servers[serverName] = lock
+	}
+	return lock
+}
@@ -0,0 +1,184 @@
+package scheduler
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"minecharts/cmd/database"
+	"minecharts/cmd/kubernetes"
+	"minecharts/cmd/logging"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// downtimeWarnings holds when the players are warned before the tasks that
+// interrupt their game.
+type downtimeWarnings struct {
+	offsets []time.Duration // From the earliest warning
+	actions map[string]bool
+}
+
+// parseDowntimeWarnings reads the warning offsets and actions of the configuration,
+// skipping the invalid offsets.
+func parseDowntimeWarnings(offsets, actions string) downtimeWarnings {
+	warnings := downtimeWarnings{actions: map[string]bool{}}
+	for _, value := range strings.Split(offsets, ",") {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		offset, err := time.ParseDuration(value)
+		if err != nil || offset <= 0 {
+			logging.Server.WithFields(
+				"offset", value,
+			).Warn("Invalid downtime warning offset, ignored")
+			continue
+		}
+		warnings.offsets = append(warnings.offsets, offset)
+	}
+	sort.Slice(warnings.offsets, func(i, j int) bool { return warnings.offsets[i] > warnings.offsets[j] })
+
+	for _, action := range strings.Split(actions, ",") {
+		if action = strings.TrimSpace(action); action != "" {
+			warnings.actions[action] = true
+		}
+	}
+	return warnings
+}
+
+// lead returns how long before a task of the action the first warning is given.
+func (w downtimeWarnings) lead(action string) time.Duration {
+	if len(w.offsets) == 0 || !w.actions[action] {
+		return 0
+	}
+	return w.offsets[0]
+}
+
+// maxLead returns how long before any task the first warning is given.
+func (w downtimeWarnings) maxLead() time.Duration {
+	if len(w.offsets) == 0 || len(w.actions) == 0 {
+		return 0
+	}
+	return w.offsets[0]
+}
+
+// countdown warns the players of a running server as a task approaches, then waits
+// for the time of the task. The warnings already past are skipped. It returns false
+// when the context is cancelled meanwhile.
+func (s *scheduler) countdown(ctx context.Context, task *database.ScheduledTask, at time.Time) bool {
+	if s.warnings.lead(task.Action) == 0 {
+		return sleepUntil(ctx, at)
+	}
+	for _, offset := range s.warnings.offsets {
+		// Warnings late by less than a scheduler interval are still given
+		warnAt := at.Add(-offset)
+		if time.Until(warnAt) < -s.interval {
+			continue
+		}
+		if !sleepUntil(ctx, warnAt) {
+			return false
+		}
+		s.warnPlayers(ctx, task, offset)
+	}
+	return sleepUntil(ctx, at)
+}
+
+// warnPlayers broadcasts in game that a task starts in the given time. Servers that
+// do not run are left alone, and failures only logged: the task runs anyway.
+func (s *scheduler) warnPlayers(ctx context.Context, task *database.ScheduledTask, remaining time.Duration) {
+	server, err := s.store.GetServerByName(ctx, task.ServerName)
+	if err != nil {
+		return
+	}
+	namespace := cmp.Or(server.Namespace, s.namespace)
+	pod, err := kubernetes.GetMinecraftPod(ctx, namespace, server.DeploymentName)
+	if err != nil || pod == nil || pod.Status.Phase != corev1.PodRunning {
+		return
+	}
+	deployment, err := kubernetes.GetDeployment(ctx, namespace, server.DeploymentName)
+	if err != nil || deployment == nil {
+		return
+	}
+
+	message := downtimeMessage(task.Action, remaining)
+	if _, _, err := kubernetes.SendConsoleCommand(ctx, namespace, deployment, pod, "say "+message); err != nil {
+		logging.Server.WithContext(ctx).WithFields(
+			"server_name", task.ServerName,
+			"task_id", task.ID,
+			"error", err.Error(),
+		).Warn("Failed to warn players of scheduled task")
+		return
+	}
+	logging.Server.WithContext(ctx).WithFields(
+		"server_name", task.ServerName,
+		"task_id", task.ID,
+		"action", task.Action,
+		"remaining", remaining.String(),
+	).Debug("Players warned of scheduled task")
+}
+
+// downtimeMessage is the warning broadcast before a task of the action.
+func downtimeMessage(action string, remaining time.Duration) string {
+	switch action {
+	case database.TaskActionRestart:
+		return "Server restarting in " + humanDuration(remaining)
+	case database.TaskActionBackup:
+		return "Server backup in " + humanDuration(remaining) + ", the game may lag for a moment"
+	}
+	return "Server maintenance in " + humanDuration(remaining)
+}
+
+// humanDuration spells a warning offset out, such as "5 minutes" or "1 minute 30 seconds".
+func humanDuration(d time.Duration) string {
+	d = d.Round(time.Second)
+	var parts []string
+	for _, unit := range []struct {
+		size time.Duration
+		name string
+	}{{time.Hour, "hour"}, {time.Minute, "minute"}, {time.Second, "second"}} {
+		if n := int(d / unit.size); n > 0 {
+			d -= time.Duration(n) * unit.size
+			if n == 1 {
+				parts = append(parts, "1 "+unit.name)
+			} else {
+				parts = append(parts, fmt.Sprintf("%d %ss", n, unit.name))
+			}
+		}
+	}
+	if len(parts) == 0 {
+		return "a moment"
+	}
+	return strings.Join(parts, " ")
+}
+
+// sleepUntil waits for a time, returning false when the context is cancelled first.
+func sleepUntil(ctx context.Context, at time.Time) bool {
+	wait := time.Until(at)
+	if wait <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// warnedRunAt returns when a task listed ahead of time is due, and whether it is
+// claimed already so that its players are warned. Other tasks wait to be due.
+func (s *scheduler) warnedRunAt(task *database.ScheduledTask, now time.Time) (time.Time, bool) {
+	if task.NextRunAt == nil || !task.NextRunAt.After(now) {
+		return now, true
+	}
+	if lead := s.warnings.lead(task.Action); lead > 0 && task.NextRunAt.Sub(now) <= lead {
+		return *task.NextRunAt, true
+	}
+	return now, false
+}
@@ -0,0 +1,84 @@
+// Package secrets reads the secrets the settings reference instead of holding them,
+// from Kubernetes Secrets mounted as files or from HashiCorp Vault:
+//
+//	file:/var/run/secrets/minecharts/jwt-secret
+//	vault:secret/data/minecharts#jwt_secret
+//
+// The config package resolves the references when it loads the configuration, and
+// again on each refresh to follow the rotations.
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Provider reads the secrets of a reference scheme.
+type Provider interface {
+	// Read returns the secret of a reference, given without its scheme.
+	Read(ctx context.Context, ref string) (string, error)
+}
+
+// ErrUnknownScheme is returned for references of a scheme without provider.
+var ErrUnknownScheme = errors.New("unknown secret reference scheme")
+
+// schemes are the schemes of the references, values starting otherwise are plain.
+var schemes = []string{"file", "vault"}
+
+// IsReference reports whether a setting value references a secret rather than
+// holding it.
+func IsReference(value string) bool {
+	for _, scheme := range schemes {
+		if strings.HasPrefix(value, scheme+":") {
+			return true
+		}
+	}
+	return false
+}
+
+// Resolver reads the references with the provider of their scheme.
+type Resolver struct {
+	providers map[string]Provider
+}
+
+// NewResolver returns a resolver reading the files and, when vault is not nil, Vault.
+func NewResolver(vault Provider) *Resolver {
+	r := &Resolver{providers: map[string]Provider{"file": FileProvider{}}}
+	if vault != nil {
+		r.providers["vault"] = vault
+	}
+	return r
+}
+
+// Resolve returns the secret a reference points to.
+func (r *Resolver) Resolve(ctx context.Context, value string) (string, error) {
+	scheme, ref, _ := strings.Cut(value, ":")
+	provider, ok := r.providers[scheme]
+	if !ok {
+		if scheme == "vault" {
+			return "", errors.New("vault references need MINECHARTS_VAULT_ADDR")
+		}
+		return "", fmt.Errorf("%w %q", ErrUnknownScheme, scheme)
+	}
+	secret, err := provider.Read(ctx, ref)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s secret %s: %w", scheme, ref, err)
+	}
+	return secret, nil
+}
+
+// FileProvider reads the secrets from files, such as the keys of a Kubernetes Secret
+// mounted as a volume. The kubelet updates the files when the Secret is rotated.
+type FileProvider struct{}
+
+// Read returns the content of the file at ref, without its trailing newline.
+func (FileProvider) Read(_ context.Context, ref string) (string, error) {
+	data, err := os.ReadFile(ref)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
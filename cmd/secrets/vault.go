@@ -0,0 +1,145 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// serviceAccountTokenPath is where Kubernetes mounts the token of the service account
+// of the pod, which logs in to Vault.
+const serviceAccountTokenPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+// vaultTimeout bounds each request to Vault.
+const vaultTimeout = 10 * time.Second
+
+// VaultProvider reads the secrets from Vault, with a token or by logging in with the
+// Kubernetes auth method. References are the API path of the secret and its key, as
+// secret/data/minecharts#jwt_secret for the KV version 2 engine mounted at secret.
+type VaultProvider struct {
+	Addr     string // e.g., https://vault.example.com:8200
+	Token    string // Empty logs in with the service account of the pod
+	Role     string // Role of the Kubernetes auth method
+	AuthPath string // Mount path of the Kubernetes auth method
+
+	client *http.Client
+
+	mu        sync.Mutex
+	token     string
+	expiresAt time.Time
+}
+
+// NewVaultProvider returns a provider reading the secrets from the Vault at addr.
+func NewVaultProvider(addr, token, role, authPath string) *VaultProvider {
+	return &VaultProvider{
+		Addr:     addr,
+		Token:    token,
+		Role:     role,
+		AuthPath: authPath,
+		client:   &http.Client{Timeout: vaultTimeout},
+	}
+}
+
+// Read returns the key of the secret at the path of ref, path#key.
+func (v *VaultProvider) Read(ctx context.Context, ref string) (string, error) {
+	path, key, ok := strings.Cut(ref, "#")
+	if !ok || path == "" || key == "" {
+		return "", errors.New("expected a reference such as vault:secret/data/minecharts#jwt_secret")
+	}
+	token, err := v.clientToken(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	var body struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err := v.call(ctx, http.MethodGet, "/v1/"+strings.TrimLeft(path, "/"), token, nil, &body); err != nil {
+		return "", err
+	}
+	// The KV version 2 engine nests the keys under data, version 1 does not
+	data := body.Data
+	if nested, ok := body.Data["data"]; ok {
+		data = nil
+		if err := json.Unmarshal(nested, &data); err != nil {
+			return "", fmt.Errorf("unexpected secret data: %w", err)
+		}
+	}
+	raw, ok := data[key]
+	if !ok {
+		return "", fmt.Errorf("the secret has no %s key", key)
+	}
+	var value string
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return "", fmt.Errorf("the %s key is not a string", key)
+	}
+	return value, nil
+}
+
+// clientToken returns the configured token, or the token of the last Kubernetes login
+// while it is valid.
+func (v *VaultProvider) clientToken(ctx context.Context) (string, error) {
+	if v.Token != "" {
+		return v.Token, nil
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.token != "" && time.Now().Before(v.expiresAt) {
+		return v.token, nil
+	}
+
+	jwt, err := os.ReadFile(serviceAccountTokenPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read the service account token to log in to Vault: %w", err)
+	}
+	login, _ := json.Marshal(map[string]string{"role": v.Role, "jwt": strings.TrimSpace(string(jwt))})
+	var body struct {
+		Auth struct {
+			ClientToken   string `json:"client_token"`
+			LeaseDuration int    `json:"lease_duration"`
+		} `json:"auth"`
+	}
+	if err := v.call(ctx, http.MethodPost, "/v1/auth/"+strings.Trim(v.AuthPath, "/")+"/login", "", login, &body); err != nil {
+		return "", fmt.Errorf("failed to log in to Vault: %w", err)
+	}
+	if body.Auth.ClientToken == "" {
+		return "", errors.New("failed to log in to Vault: no client token")
+	}
+	// Log in again before the lease ends, as the refreshes keep reading
+	lease := time.Duration(body.Auth.LeaseDuration) * time.Second
+	v.token, v.expiresAt = body.Auth.ClientToken, time.Now().Add(lease*9/10)
+	return v.token, nil
+}
+
+// call sends a request to the Vault API and decodes its JSON answer into out.
+func (v *VaultProvider) call(ctx context.Context, method, path, token string, body []byte, out any) error {
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimRight(v.Addr, "/")+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		// Vault answers {"errors": [...]}, without the secret
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("vault answered %s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,228 @@
+// Package security emits security events (authentication failures, permission
+// denials, impersonations and admin actions) to an external sink for SIEM
+// ingestion. Events are kept apart from application logs and are delivered
+// asynchronously so a slow sink never delays API requests.
+package security
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"minecharts/cmd/config"
+	"minecharts/cmd/database"
+	"minecharts/cmd/logging"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Event types.
+const (
+	AuthFailure      = "auth_failure"
+	PermissionDenied = "permission_denied"
+	Impersonation    = "impersonation"
+	AdminAction      = "admin_action"
+)
+
+// Event outcomes.
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+)
+
+// queueSize bounds the number of events waiting for delivery; events are
+// dropped when the sink cannot keep up.
+const queueSize = 1024
+
+// Event is a single security event.
+type Event struct {
+	Time     time.Time         `json:"time"`
+	Type     string            `json:"type"`
+	Action   string            `json:"action"`
+	Outcome  string            `json:"outcome"`
+	Severity int               `json:"severity"`
+	UserID   int64             `json:"user_id,omitempty"`
+	Username string            `json:"username,omitempty"`
+	SourceIP string            `json:"source_ip,omitempty"`
+	Method   string            `json:"method,omitempty"`
+	Path     string            `json:"path,omitempty"`
+	Target   string            `json:"target,omitempty"`
+	Reason   string            `json:"reason,omitempty"`
+	Details  map[string]string `json:"details,omitempty"`
+}
+
+// NewEvent returns an event of the given type for the current request.
+// The outcome and severity default to the usual values for the type.
+func NewEvent(c *gin.Context, eventType, action string) *Event {
+	event := &Event{
+		Time:    time.Now(),
+		Type:    eventType,
+		Action:  action,
+		Outcome: OutcomeFailure,
+	}
+	switch eventType {
+	case AuthFailure:
+		event.Severity = 5
+	case PermissionDenied:
+		event.Severity = 6
+	case Impersonation:
+		event.Severity = 8
+		event.Outcome = OutcomeSuccess
+	case AdminAction:
+		event.Severity = 3
+		event.Outcome = OutcomeSuccess
+	}
+	if c != nil {
+		event.SourceIP = c.ClientIP()
+		event.Method = c.Request.Method
+		event.Path = c.Request.URL.Path
+	}
+	return event
+}
+
+// WithUser records the user the event is about.
+func (e *Event) WithUser(user *database.User) *Event {
+	if user != nil {
+		e.UserID = user.ID
+		e.Username = user.Username
+	}
+	return e
+}
+
+// WithUsername records the user by name only, for failures where no user was resolved.
+func (e *Event) WithUsername(username string) *Event {
+	e.Username = username
+	return e
+}
+
+// WithTarget records the resource the action applied to.
+func (e *Event) WithTarget(target string) *Event {
+	e.Target = target
+	return e
+}
+
+// WithReason records why the event happened, for example the failure cause.
+func (e *Event) WithReason(reason string) *Event {
+	e.Reason = reason
+	return e
+}
+
+// WithDetail adds a free-form detail. Secret values are redacted.
+func (e *Event) WithDetail(key, value string) *Event {
+	if e.Details == nil {
+		e.Details = map[string]string{}
+	}
+	if logging.IsSensitiveKey(key) {
+		value = logging.Redacted
+	} else {
+		value = logging.Redact(value)
+	}
+	e.Details[key] = value
+	return e
+}
+
+// Emit queues the event for delivery. It never blocks; when the stream is
+// disabled the event is discarded.
+func (e *Event) Emit() {
+	stream := current.Load()
+	if stream == nil {
+		return
+	}
+	e.Path = logging.Redact(e.Path)
+	e.Reason = logging.Redact(e.Reason)
+
+	select {
+	case stream.queue <- e:
+	default:
+		if dropped := stream.dropped.Add(1); dropped == 1 || dropped%100 == 0 {
+			logging.Auth.WithFields(
+				"dropped_events", dropped,
+			).Warn("Security event queue full, dropping events")
+		}
+	}
+}
+
+type eventStream struct {
+	sink    sink
+	format  string
+	queue   chan *Event
+	dropped atomic.Int64
+	done    chan struct{}
+}
+
+var (
+	current  atomic.Pointer[eventStream]
+	initOnce sync.Once
+)
+
+// Init starts the event stream configured by MINECHARTS_SECURITY_EVENTS_SINK.
+// The stream stays disabled when no sink is configured.
+func Init() error {
+	var err error
+	initOnce.Do(func() {
+		if config.SecurityEventsSink == "" {
+			logging.Auth.Debug("Security event stream disabled: no sink configured")
+			return
+		}
+
+		var s sink
+		s, err = newSink(config.SecurityEventsSink, config.SecurityEventsFormat)
+		if err != nil {
+			logging.Auth.WithFields(
+				"sink", config.SecurityEventsSink,
+				"error", err.Error(),
+			).Error("Failed to configure security event sink")
+			return
+		}
+
+		stream := &eventStream{
+			sink:   s,
+			format: config.SecurityEventsFormat,
+			queue:  make(chan *Event, queueSize),
+			done:   make(chan struct{}),
+		}
+		go stream.run()
+		current.Store(stream)
+
+		logging.Auth.WithFields(
+			"sink", config.SecurityEventsSink,
+			"format", stream.format,
+		).Info("Security event stream enabled")
+	})
+	return err
+}
+
+// Close flushes queued events, waiting at most timeout, and closes the sink.
+func Close(timeout time.Duration) {
+	stream := current.Swap(nil)
+	if stream == nil {
+		return
+	}
+	close(stream.queue)
+	select {
+	case <-stream.done:
+	case <-time.After(timeout):
+		logging.Auth.Warn("Timed out flushing security events")
+	}
+	stream.sink.Close()
+}
+
+func (s *eventStream) run() {
+	defer close(s.done)
+	for event := range s.queue {
+		payload, err := encode(event, s.format)
+		if err != nil {
+			logging.Auth.WithFields(
+				"event_type", event.Type,
+				"error", err.Error(),
+			).Error("Failed to encode security event")
+			continue
+		}
+		if err := s.sink.Send(event, payload); err != nil {
+			logging.Auth.WithFields(
+				"event_type", event.Type,
+				"error", err.Error(),
+			).Warn("Failed to deliver security event")
+		}
+	}
+}
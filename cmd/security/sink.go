@@ -0,0 +1,179 @@
+package security
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/syslog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Supported event formats.
+const (
+	FormatJSON = "json"
+	FormatCEF  = "cef"
+)
+
+const (
+	cefVendor  = "Minecharts"
+	cefProduct = "minecharts-api"
+	cefVersion = "0.1"
+)
+
+// sink delivers encoded events.
+type sink interface {
+	Send(event *Event, payload []byte) error
+	Close() error
+}
+
+// newSink builds the sink for a target URL:
+//
+//	syslog://host:514      syslog over UDP
+//	syslog+tcp://host:601  syslog over TCP
+//	http(s)://host/path    one POST per event
+func newSink(target, format string) (sink, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, fmt.Errorf("invalid security events sink: %w", err)
+	}
+
+	switch u.Scheme {
+	case "syslog", "syslog+udp", "syslog+tcp":
+		network := "udp"
+		if u.Scheme == "syslog+tcp" {
+			network = "tcp"
+		}
+		writer, err := syslog.Dial(network, u.Host, syslog.LOG_AUTH|syslog.LOG_NOTICE, "minecharts-api")
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to syslog: %w", err)
+		}
+		return &syslogSink{writer: writer}, nil
+	case "http", "https":
+		contentType := "application/json"
+		if strings.ToLower(format) == FormatCEF {
+			contentType = "text/plain"
+		}
+		return &httpSink{url: target, contentType: contentType, client: &http.Client{Timeout: 5 * time.Second}}, nil
+	default:
+		return nil, fmt.Errorf("unsupported security events sink scheme %q", u.Scheme)
+	}
+}
+
+type syslogSink struct {
+	writer *syslog.Writer
+}
+
+func (s *syslogSink) Send(event *Event, payload []byte) error {
+	message := string(payload)
+	switch {
+	case event.Severity >= 8:
+		return s.writer.Crit(message)
+	case event.Severity >= 6:
+		return s.writer.Warning(message)
+	case event.Severity >= 4:
+		return s.writer.Notice(message)
+	default:
+		return s.writer.Info(message)
+	}
+}
+
+func (s *syslogSink) Close() error {
+	return s.writer.Close()
+}
+
+type httpSink struct {
+	url         string
+	contentType string
+	client      *http.Client
+}
+
+func (s *httpSink) Send(event *Event, payload []byte) error {
+	resp, err := s.client.Post(s.url, s.contentType, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("security events sink returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (s *httpSink) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
+
+// encode renders an event in the configured format.
+func encode(event *Event, format string) ([]byte, error) {
+	switch strings.ToLower(format) {
+	case FormatCEF:
+		return []byte(encodeCEF(event)), nil
+	default:
+		return json.Marshal(event)
+	}
+}
+
+// encodeCEF renders an event in ArcSight Common Event Format.
+func encodeCEF(event *Event) string {
+	extensions := []string{
+		"rt=" + strconv.FormatInt(event.Time.UnixMilli(), 10),
+		"outcome=" + cefExtension(event.Outcome),
+	}
+	add := func(key, value string) {
+		if value != "" {
+			extensions = append(extensions, key+"="+cefExtension(value))
+		}
+	}
+	add("src", event.SourceIP)
+	add("suser", event.Username)
+	if event.UserID != 0 {
+		add("suid", strconv.FormatInt(event.UserID, 10))
+	}
+	add("requestMethod", event.Method)
+	add("request", event.Path)
+	add("duser", event.Target)
+	add("reason", event.Reason)
+
+	keys := make([]string, 0, len(event.Details))
+	for key := range event.Details {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	// CEF only defines six custom string fields
+	for i, key := range keys {
+		if i == 6 {
+			break
+		}
+		add(fmt.Sprintf("cs%dLabel", i+1), key)
+		add(fmt.Sprintf("cs%d", i+1), event.Details[key])
+	}
+
+	return fmt.Sprintf("CEF:0|%s|%s|%s|%s|%s|%d|%s",
+		cefHeader(cefVendor),
+		cefHeader(cefProduct),
+		cefHeader(cefVersion),
+		cefHeader(event.Type+":"+event.Action),
+		cefHeader(strings.ReplaceAll(event.Action, "_", " ")),
+		event.Severity,
+		strings.Join(extensions, " "),
+	)
+}
+
+var (
+	cefHeaderEscaper    = strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\n", " ", "\r", " ")
+	cefExtensionEscaper = strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\n", `\n`, "\r", `\r`)
+)
+
+func cefHeader(value string) string {
+	return cefHeaderEscaper.Replace(value)
+}
+
+func cefExtension(value string) string {
+	return cefExtensionEscaper.Replace(value)
+}
@@ -0,0 +1,90 @@
+// Package shutdown tracks the background work the API waits for when it stops, such
+// as jobs and scheduled backups, so that rolling updates do not cut them short.
+package shutdown
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"minecharts/cmd/logging"
+)
+
+// interruptGrace is how long the work interrupted at the end of a drain has to
+// record its failure.
+const interruptGrace = 5 * time.Second
+
+// ErrInterrupted is the cause of the contexts of the work interrupted because it
+// did not finish while the API was draining.
+var ErrInterrupted = errors.New("interrupted by the shutdown of the API")
+
+// ErrShuttingDown is returned when work is started after the drain began.
+var ErrShuttingDown = errors.New("the API is shutting down")
+
+var state struct {
+	sync.Mutex
+	draining bool
+	running  sync.WaitGroup
+}
+
+var interrupt, interruptAll = context.WithCancelCause(context.Background())
+
+// Track registers background work the drain waits for. The returned context keeps
+// the values of ctx but not its cancellation, as the work outlives its caller, and
+// is cancelled with ErrInterrupted when the drain runs out of time. done must be
+// called when the work ends. ok is false, and nothing is tracked, once the drain
+// has begun.
+func Track(ctx context.Context) (tracked context.Context, done func(), ok bool) {
+	state.Lock()
+	defer state.Unlock()
+	if state.draining {
+		return ctx, func() {}, false
+	}
+	state.running.Add(1)
+
+	tracked, cancel := context.WithCancelCause(context.WithoutCancel(ctx))
+	stop := context.AfterFunc(interrupt, func() { cancel(ErrInterrupted) })
+	var once sync.Once
+	return tracked, func() {
+		once.Do(func() {
+			stop()
+			cancel(nil)
+			state.running.Done()
+		})
+	}, true
+}
+
+// Interrupted reports whether the work of ctx was interrupted by the drain.
+func Interrupted(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), ErrInterrupted)
+}
+
+// Drain refuses new work and waits for the tracked work to finish until ctx is done.
+// The work still running then is interrupted, and given interruptGrace to record
+// its failure; whatever is left is marked interrupted at the next start.
+func Drain(ctx context.Context) {
+	state.Lock()
+	state.draining = true
+	state.Unlock()
+
+	finished := make(chan struct{})
+	go func() {
+		state.running.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+		return
+	case <-ctx.Done():
+	}
+
+	logging.API.Warn("Shutdown timeout reached, interrupting the background work still running")
+	interruptAll(ErrInterrupted)
+	select {
+	case <-finished:
+	case <-time.After(interruptGrace):
+		logging.API.Warn("Background work still running after its interruption, leaving it unfinished")
+	}
+}
@@ -0,0 +1,279 @@
+package tracing
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"minecharts/cmd/config"
+	"minecharts/cmd/logging"
+)
+
+const (
+	// queueSize bounds the number of ended spans waiting for export; spans are
+	// dropped when the collector cannot keep up.
+	queueSize = 4096
+	// batchSize is the number of spans sent at most in one export.
+	batchSize = 512
+	// flushInterval is how long spans wait at most before being exported.
+	flushInterval = 5 * time.Second
+)
+
+// exporter sends the ended spans to an OTLP/HTTP collector, in batches.
+type exporter struct {
+	url     string
+	bound   uint64
+	client  *http.Client
+	queue   chan *Span
+	dropped atomic.Int64
+	done    chan struct{}
+
+	// closeMu keeps spans ending during Close from being sent on the closed queue
+	closeMu sync.RWMutex
+	closed  bool
+}
+
+var (
+	current  atomic.Pointer[exporter]
+	initOnce sync.Once
+)
+
+// Init starts the export of spans configured by MINECHARTS_OTLP_ENDPOINT. Tracing
+// stays disabled when no endpoint is configured.
+func Init() error {
+	var err error
+	initOnce.Do(func() {
+		if config.TracingEndpoint == "" {
+			logging.API.Debug("Tracing disabled: no OTLP endpoint configured")
+			return
+		}
+		if !strings.HasPrefix(config.TracingEndpoint, "http://") && !strings.HasPrefix(config.TracingEndpoint, "https://") {
+			err = fmt.Errorf("unsupported OTLP endpoint %q, expected an http:// or https:// URL", config.TracingEndpoint)
+			return
+		}
+
+		exp := &exporter{
+			url:    strings.TrimSuffix(config.TracingEndpoint, "/") + "/v1/traces",
+			bound:  sampleBound(config.TracingSamplePercent),
+			client: &http.Client{Timeout: 10 * time.Second},
+			queue:  make(chan *Span, queueSize),
+			done:   make(chan struct{}),
+		}
+		go exp.run()
+		current.Store(exp)
+
+		logging.API.WithFields(
+			"endpoint", exp.url,
+			"service_name", config.TracingServiceName,
+			"sample_percent", config.TracingSamplePercent,
+		).Info("Tracing enabled")
+	})
+	return err
+}
+
+// Close exports the spans ended so far, waiting at most timeout.
+func Close(timeout time.Duration) {
+	exp := current.Swap(nil)
+	if exp == nil {
+		return
+	}
+	exp.closeMu.Lock()
+	exp.closed = true
+	close(exp.queue)
+	exp.closeMu.Unlock()
+
+	select {
+	case <-exp.done:
+	case <-time.After(timeout):
+		logging.API.Warn("Timed out exporting spans")
+	}
+}
+
+// sample reports whether a new trace is recorded.
+func (e *exporter) sample(id TraceID) bool {
+	return e.bound == ^uint64(0) || traceIDBits(id) < e.bound
+}
+
+func (e *exporter) enqueue(span *Span) {
+	e.closeMu.RLock()
+	defer e.closeMu.RUnlock()
+	if e.closed {
+		return
+	}
+	select {
+	case e.queue <- span:
+	default:
+		if dropped := e.dropped.Add(1); dropped == 1 || dropped%1000 == 0 {
+			logging.API.WithFields(
+				"dropped_spans", dropped,
+			).Warn("Span export queue full, dropping spans")
+		}
+	}
+}
+
+func (e *exporter) run() {
+	defer close(e.done)
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*Span, 0, batchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := e.send(batch); err != nil {
+			logging.API.WithFields(
+				"spans", len(batch),
+				"error", err.Error(),
+			).Warn("Failed to export spans")
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case span, ok := <-e.queue:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, span)
+			if len(batch) == batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+func (e *exporter) send(spans []*Span) error {
+	payload, err := json.Marshal(encodeRequest(spans))
+	if err != nil {
+		return err
+	}
+	resp, err := e.client.Post(e.url, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("OTLP collector returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// The OTLP/HTTP JSON encoding of an ExportTraceServiceRequest.
+type (
+	otlpRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpSpan struct {
+		TraceID           string          `json:"traceId"`
+		SpanID            string          `json:"spanId"`
+		ParentSpanID      string          `json:"parentSpanId,omitempty"`
+		Name              string          `json:"name"`
+		Kind              Kind            `json:"kind"`
+		StartTimeUnixNano string          `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+		Attributes        []otlpAttribute `json:"attributes,omitempty"`
+		Status            *otlpStatus     `json:"status,omitempty"`
+	}
+	otlpStatus struct {
+		Code    int    `json:"code"`
+		Message string `json:"message,omitempty"`
+	}
+	otlpAttribute struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+	otlpValue struct {
+		StringValue *string  `json:"stringValue,omitempty"`
+		IntValue    *string  `json:"intValue,omitempty"`
+		DoubleValue *float64 `json:"doubleValue,omitempty"`
+		BoolValue   *bool    `json:"boolValue,omitempty"`
+	}
+)
+
+// otlpStatusError is the status code of failed spans.
+const otlpStatusError = 2
+
+func encodeRequest(spans []*Span) otlpRequest {
+	resource := []otlpAttribute{encodeAttribute("service.name", config.TracingServiceName)}
+	if hostname, err := os.Hostname(); err == nil {
+		resource = append(resource, encodeAttribute("host.name", hostname))
+	}
+
+	encoded := make([]otlpSpan, 0, len(spans))
+	for _, span := range spans {
+		span.mu.Lock()
+		s := otlpSpan{
+			TraceID:           span.traceID.String(),
+			SpanID:            span.spanID.String(),
+			Name:              span.name,
+			Kind:              span.kind,
+			StartTimeUnixNano: strconv.FormatInt(span.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.end.UnixNano(), 10),
+		}
+		if span.parent != (SpanID{}) {
+			s.ParentSpanID = span.parent.String()
+		}
+		for _, attr := range span.attrs {
+			s.Attributes = append(s.Attributes, encodeAttribute(attr.key, attr.value))
+		}
+		if span.failed {
+			s.Status = &otlpStatus{Code: otlpStatusError, Message: logging.Redact(span.err)}
+		}
+		span.mu.Unlock()
+		encoded = append(encoded, s)
+	}
+
+	return otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: resource},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: "minecharts"}, Spans: encoded}},
+	}}}
+}
+
+func encodeAttribute(key string, value interface{}) otlpAttribute {
+	var v otlpValue
+	switch value := value.(type) {
+	case bool:
+		v.BoolValue = &value
+	case int:
+		s := strconv.Itoa(value)
+		v.IntValue = &s
+	case int32:
+		s := strconv.FormatInt(int64(value), 10)
+		v.IntValue = &s
+	case int64:
+		s := strconv.FormatInt(value, 10)
+		v.IntValue = &s
+	case float64:
+		v.DoubleValue = &value
+	default:
+		s := logging.Redact(fmt.Sprint(value))
+		v.StringValue = &s
+	}
+	return otlpAttribute{Key: key, Value: v}
+}
@@ -0,0 +1,50 @@
+package tracing
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TraceparentHeader is the W3C header carrying the trace of a request.
+const TraceparentHeader = "traceparent"
+
+// Middleware records a server span for each API request, continuing the trace of
+// the traceparent header of the caller, and passes it to the handler in the request
+// context. It must run after the RequestID middleware.
+func Middleware(requestID func(c *gin.Context) string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if current.Load() == nil {
+			c.Next()
+			return
+		}
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		ctx := ContextWithTraceparent(c.Request.Context(), c.GetHeader(TraceparentHeader))
+		ctx, span := Start(ctx, KindServer, c.Request.Method+" "+route)
+		defer span.End()
+		c.Request = c.Request.WithContext(ctx)
+
+		c.Next()
+
+		status := c.Writer.Status()
+		span.SetAttributes(
+			"http.request.method", c.Request.Method,
+			"http.route", route,
+			"url.path", c.Request.URL.Path,
+			"http.response.status_code", status,
+			"client.address", c.ClientIP(),
+			"minecharts.request_id", requestID(c),
+		)
+		if serverName := c.Param("serverName"); serverName != "" {
+			span.SetAttributes("minecharts.server_name", serverName)
+		}
+		if status >= http.StatusInternalServerError {
+			span.SetFailed("HTTP " + strconv.Itoa(status))
+		}
+	}
+}
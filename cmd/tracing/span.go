@@ -0,0 +1,237 @@
+// Package tracing records OpenTelemetry spans of the API requests, with the database
+// queries and Kubernetes API calls they make, and exports them to an OTLP collector.
+// Traces are continued from and propagated with W3C traceparent headers.
+//
+// Tracing is disabled unless MINECHARTS_OTLP_ENDPOINT is set; Start then returns a nil
+// span, whose methods do nothing.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Kind is the role of a span in a trace.
+type Kind int
+
+// Span kinds, numbered as in OTLP.
+const (
+	KindInternal Kind = 1
+	KindServer   Kind = 2
+	KindClient   Kind = 3
+)
+
+// TraceID identifies a trace.
+type TraceID [16]byte
+
+// SpanID identifies a span within its trace.
+type SpanID [8]byte
+
+func (t TraceID) String() string { return hex.EncodeToString(t[:]) }
+
+func (s SpanID) String() string { return hex.EncodeToString(s[:]) }
+
+// spanContext is what a span passes to its children and to the services it calls.
+type spanContext struct {
+	traceID TraceID
+	spanID  SpanID
+	sampled bool
+}
+
+// Span is an operation of a trace. Spans of traces that are not sampled only carry
+// their IDs, so that the services called keep the sampling decision.
+type Span struct {
+	spanContext
+	parent SpanID
+	kind   Kind
+	name   string
+	start  time.Time
+
+	mu     sync.Mutex
+	end    time.Time
+	attrs  []attribute
+	err    string
+	failed bool
+	ended  bool
+}
+
+type attribute struct {
+	key   string
+	value interface{}
+}
+
+type spanKey struct{}
+
+// Start starts a span, child of the span of ctx when there is one, and returns a
+// context carrying it. The caller must End the span.
+func Start(ctx context.Context, kind Kind, name string) (context.Context, *Span) {
+	exp := current.Load()
+	if exp == nil {
+		return ctx, nil
+	}
+
+	span := &Span{kind: kind, name: name, start: time.Now()}
+	span.spanID = newSpanID()
+	if parent, ok := parentContext(ctx); ok {
+		span.traceID = parent.traceID
+		span.parent = parent.spanID
+		span.sampled = parent.sampled
+	} else {
+		span.traceID = newTraceID()
+		span.sampled = exp.sample(span.traceID)
+	}
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// FromContext returns the span of ctx, or nil.
+func FromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// parentContext returns the span context of ctx, from a local span or from the
+// traceparent of an incoming request.
+func parentContext(ctx context.Context) (spanContext, bool) {
+	if span := FromContext(ctx); span != nil {
+		return span.spanContext, true
+	}
+	remote, ok := ctx.Value(remoteKey{}).(spanContext)
+	return remote, ok
+}
+
+// SetAttributes records attributes of the span, as key and value pairs such as
+// "http.route", "/servers", "http.status_code", 200.
+func (s *Span) SetAttributes(keyvals ...interface{}) {
+	if s == nil || !s.sampled {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		s.attrs = append(s.attrs, attribute{key: fmt.Sprint(keyvals[i]), value: keyvals[i+1]})
+	}
+}
+
+// SetError marks the span as failed with err. A nil error is ignored.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failed = true
+	s.err = err.Error()
+}
+
+// SetFailed marks the span as failed with a description, as for an error status.
+func (s *Span) SetFailed(description string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failed = true
+	s.err = description
+}
+
+// End ends the span and queues it for export when its trace is sampled. Ending a
+// span again does nothing.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = time.Now()
+	s.mu.Unlock()
+
+	if s.sampled {
+		if exp := current.Load(); exp != nil {
+			exp.enqueue(s)
+		}
+	}
+}
+
+// TraceID returns the trace ID of the span, or an empty string for a nil span.
+func (s *Span) TraceID() string {
+	if s == nil {
+		return ""
+	}
+	return s.traceID.String()
+}
+
+// Traceparent returns the W3C traceparent header of ctx, for the services it calls,
+// or an empty string when ctx carries no span.
+func Traceparent(ctx context.Context) string {
+	sc, ok := parentContext(ctx)
+	if !ok {
+		return ""
+	}
+	flags := "00"
+	if sc.sampled {
+		flags = "01"
+	}
+	return "00-" + sc.traceID.String() + "-" + sc.spanID.String() + "-" + flags
+}
+
+type remoteKey struct{}
+
+// ContextWithTraceparent returns a context continuing the trace of a traceparent
+// header received from a caller. Invalid headers are ignored.
+func ContextWithTraceparent(ctx context.Context, header string) context.Context {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) != 4 || parts[0] != "00" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return ctx
+	}
+	var sc spanContext
+	if _, err := hex.Decode(sc.traceID[:], []byte(parts[1])); err != nil || sc.traceID == (TraceID{}) {
+		return ctx
+	}
+	if _, err := hex.Decode(sc.spanID[:], []byte(parts[2])); err != nil || sc.spanID == (SpanID{}) {
+		return ctx
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return ctx
+	}
+	sc.sampled = flags[0]&0x01 != 0
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+func newTraceID() TraceID {
+	var id TraceID
+	_, _ = rand.Read(id[:])
+	return id
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	_, _ = rand.Read(id[:])
+	return id
+}
+
+// sampleBound returns the value the low bits of a trace ID must stay under for the
+// trace to be sampled at percent, as the TraceIdRatioBased sampler does.
+func sampleBound(percent int) uint64 {
+	switch {
+	case percent >= 100:
+		return ^uint64(0)
+	case percent <= 0:
+		return 0
+	}
+	return uint64(percent) * (^uint64(0) / 100)
+}
+
+func traceIDBits(id TraceID) uint64 {
+	return binary.BigEndian.Uint64(id[8:])
+}
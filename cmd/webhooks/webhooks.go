@@ -0,0 +1,212 @@
+// Package webhooks delivers the events of the bus, such as the server incidents, to
+// the endpoints configured with MINECHARTS_WEBHOOK_URLS. Deliveries are queued and
+// sent in the background, so a slow endpoint never delays the API.
+//
+// Each delivery is a JSON POST of {"event", "sentAt", "data"}. When
+// MINECHARTS_WEBHOOK_SECRET is set, it is signed like the deliveries of the wake-up
+// webhook: X-Webhook-Timestamp holds its Unix time and X-Webhook-Signature "sha256="
+// followed by the hex HMAC-SHA256 of the timestamp, a dot and the body.
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"minecharts/cmd/config"
+	"minecharts/cmd/events"
+	"minecharts/cmd/logging"
+)
+
+// delivered are the event types posted to the endpoints.
+var delivered = []events.Type{
+	events.ServerIncident, events.BackupCompleted, events.BackupFailed, events.DeploymentOrphaned,
+}
+
+const (
+	// queueSize bounds the deliveries waiting to be sent; they are dropped when the
+	// endpoints cannot keep up.
+	queueSize = 256
+	// maxAttempts is how many times a delivery is sent to an endpoint that fails.
+	maxAttempts = 3
+	// retryDelay is the wait before the second attempt, doubled for each next one.
+	retryDelay = 2 * time.Second
+)
+
+// userAgent identifies the API to the endpoints.
+const userAgent = "ZenT0x/minecharts-api (https://github.com/ZenT0x/minecharts)"
+
+// Delivery is the body posted to the endpoints.
+type Delivery struct {
+	Event  string    `json:"event"`
+	SentAt time.Time `json:"sentAt"`
+	Data   any       `json:"data"`
+}
+
+type dispatcher struct {
+	urls    []string
+	secret  string
+	client  *http.Client
+	queue   chan *Delivery
+	dropped atomic.Int64
+	done    chan struct{}
+}
+
+var (
+	current  atomic.Pointer[dispatcher]
+	initOnce sync.Once
+)
+
+// Init starts the delivery of the webhooks configured by MINECHARTS_WEBHOOK_URLS.
+// They stay disabled when no URL is configured.
+func Init() error {
+	var err error
+	initOnce.Do(func() {
+		var urls []string
+		for _, raw := range strings.Split(config.WebhookURLs, ",") {
+			raw = strings.TrimSpace(raw)
+			if raw == "" {
+				continue
+			}
+			u, parseErr := url.Parse(raw)
+			if parseErr != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				err = fmt.Errorf("invalid webhook URL %q: expected an http or https URL", raw)
+				return
+			}
+			urls = append(urls, raw)
+		}
+		if len(urls) == 0 {
+			logging.API.Debug("Webhooks disabled: no URL configured")
+			return
+		}
+
+		d := &dispatcher{
+			urls:   urls,
+			secret: config.WebhookSecret,
+			client: &http.Client{Timeout: 10 * time.Second},
+			queue:  make(chan *Delivery, queueSize),
+			done:   make(chan struct{}),
+		}
+		go d.run()
+		current.Store(d)
+		events.Handle(send, delivered...)
+
+		logging.API.WithFields(
+			"endpoints", len(urls),
+			"signed", d.secret != "",
+		).Info("Webhooks enabled")
+	})
+	return err
+}
+
+// Close sends the queued deliveries, waiting at most timeout.
+func Close(timeout time.Duration) {
+	d := current.Swap(nil)
+	if d == nil {
+		return
+	}
+	close(d.queue)
+	select {
+	case <-d.done:
+	case <-time.After(timeout):
+		logging.API.Warn("Timed out sending webhook deliveries")
+	}
+	d.client.CloseIdleConnections()
+}
+
+// send queues an event of the bus for delivery to every endpoint. It never blocks;
+// once the webhooks are closed the event is discarded.
+func send(_ context.Context, event events.Event) {
+	d := current.Load()
+	if d == nil {
+		return
+	}
+
+	select {
+	case d.queue <- &Delivery{Event: string(event.Type), SentAt: time.Now().UTC(), Data: event.Data}:
+	default:
+		if dropped := d.dropped.Add(1); dropped == 1 || dropped%100 == 0 {
+			logging.API.WithFields(
+				"dropped_deliveries", dropped,
+			).Warn("Webhook queue full, dropping deliveries")
+		}
+	}
+}
+
+func (d *dispatcher) run() {
+	defer close(d.done)
+	for delivery := range d.queue {
+		body, err := json.Marshal(delivery)
+		if err != nil {
+			logging.API.WithFields(
+				"event", delivery.Event,
+				"error", err.Error(),
+			).Error("Failed to encode webhook delivery")
+			continue
+		}
+		for _, target := range d.urls {
+			d.deliver(target, delivery, body)
+		}
+	}
+}
+
+// deliver posts a delivery to an endpoint, retrying on failures with a backoff.
+func (d *dispatcher) deliver(target string, delivery *Delivery, body []byte) {
+	delay := retryDelay
+	for attempt := 1; ; attempt++ {
+		err := d.post(target, delivery, body)
+		if err == nil {
+			return
+		}
+		if attempt == maxAttempts {
+			logging.API.WithFields(
+				"event", delivery.Event,
+				"webhook", logging.Redact(target),
+				"attempts", attempt,
+				"error", err.Error(),
+			).Warn("Failed to deliver webhook")
+			return
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+func (d *dispatcher) post(target string, delivery *Delivery, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("X-Webhook-Event", delivery.Event)
+	if d.secret != "" {
+		timestamp := strconv.FormatInt(delivery.SentAt.Unix(), 10)
+		mac := hmac.New(sha256.New, []byte(d.secret))
+		mac.Write([]byte(timestamp + "."))
+		mac.Write(body)
+		req.Header.Set("X-Webhook-Timestamp", timestamp)
+		req.Header.Set("X-Webhook-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}